@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/manto/manto-web/internal/backup"
+	"github.com/manto/manto-web/internal/config"
+)
+
+// runBackup implements `manto-web backup`, which archives the configured
+// data files with their checksums.
+func runBackup(args []string) int {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	now := time.Now()
+	out := flags.String("out", "manto-backup-"+now.UTC().Format("20060102T150405Z")+".tar.zst", "file to write the backup to")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create backup: %v\n", err)
+		return 1
+	}
+	manifest, err := backup.Write(f, backup.Sources(cfg), now)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+		return 1
+	}
+	printManifest(manifest)
+	fmt.Printf("Backup written to %s\n", *out)
+	return 0
+}
+
+// runRestore implements `manto-web restore`, which puts the data files in a
+// backup back in place. The server must be stopped; a running server
+// restores through POST /admin/api/restore instead.
+func runRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	verify := flags.Bool("verify", false, "check the backup's checksums without restoring it")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: manto-web restore [-verify] file.tar.zst")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open backup: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	if *verify {
+		manifest, _, err := backup.Read(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Backup is not valid: %v\n", err)
+			return 1
+		}
+		printManifest(manifest)
+		fmt.Println("Backup is valid")
+		return 0
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	manifest, err := backup.Restore(f, backup.Sources(cfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
+		return 1
+	}
+	printManifest(manifest)
+	fmt.Println("Backup restored")
+	return 0
+}
+
+func printManifest(manifest *backup.Manifest) {
+	fmt.Printf("Backup of %s\n", manifest.CreatedAt.Format(time.RFC3339))
+	for _, file := range manifest.Files {
+		fmt.Printf("  %-14s %10d bytes  sha256:%s\n", file.Name, file.Size, file.SHA256)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/manto/manto-web/internal/chat"
+)
+
+// runChat implements `manto-web chat`, an interactive terminal client for a
+// running Manto server.
+func runChat(args []string) int {
+	flags := flag.NewFlagSet("chat", flag.ContinueOnError)
+	url := flags.String("url", envOr("MANTO_URL", "http://localhost:8080"), "Manto server URL")
+	key := flags.String("key", os.Getenv("ANTHROPIC_API_KEY"), "API key (default $ANTHROPIC_API_KEY)")
+	keyHeader := flags.String("key-header", envOr("ANTHROPIC_CLIENT_KEY_HEADER", "x-api-key"), "header the server reads the key from")
+	accessCode := flags.String("access-code", os.Getenv("MANTO_ACCESS_CODE"), "access code, if the server requires one")
+	model := flags.String("model", envOr("ANTHROPIC_DEFAULT_MODEL", "claude-3-5-haiku"), "model to chat with")
+	system := flags.String("system", "", "system prompt (default: the server's)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *key == "" {
+		fmt.Fprintln(os.Stderr, "An API key is required: pass -key or set ANTHROPIC_API_KEY")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	session := &chat.Session{
+		Client: &chat.Client{
+			BaseURL:    *url,
+			APIKey:     *key,
+			KeyHeader:  *keyHeader,
+			AccessCode: *accessCode,
+			HTTP:       &http.Client{},
+		},
+		Model:  *model,
+		System: *system,
+	}
+	if err := session.Run(ctx, os.Stdin, os.Stdout); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "Chat failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/loadtest"
+)
+
+// runLoadtest implements `manto-web loadtest`, which measures the message path
+// against a mock provider using the current configuration.
+func runLoadtest(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	concurrency := flags.Int("concurrency", 10, "number of concurrent clients")
+	requests := flags.Int("requests", 1000, "total number of requests")
+	payload := flags.Int("payload", 200, "message size in characters")
+	latency := flags.Duration("latency", 500*time.Millisecond, "simulated provider latency")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := loadtest.Run(ctx, cfg, loadtest.Options{
+		Concurrency: *concurrency,
+		Requests:    *requests,
+		PayloadSize: *payload,
+		Latency:     *latency,
+		Model:       cfg.Anthropic.DefaultModel,
+	})
+	if err != nil && report.Requests == 0 {
+		fmt.Fprintf(os.Stderr, "Load test failed: %v\n", err)
+		return 1
+	}
+	fmt.Println(report)
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
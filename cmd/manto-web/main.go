@@ -1,57 +1,313 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/admin"
+	"github.com/manto/manto-web/internal/announcements"
+	"github.com/manto/manto-web/internal/archive"
+	"github.com/manto/manto-web/internal/assets"
+	"github.com/manto/manto-web/internal/backup"
+	"github.com/manto/manto-web/internal/batch"
+	"github.com/manto/manto-web/internal/capture"
 	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/conversations"
+	"github.com/manto/manto-web/internal/devmode"
+	"github.com/manto/manto-web/internal/dlp"
+	"github.com/manto/manto-web/internal/errorpages"
+	"github.com/manto/manto-web/internal/evals"
+	"github.com/manto/manto-web/internal/experiments"
 	"github.com/manto/manto-web/internal/handlers"
+	"github.com/manto/manto-web/internal/journal"
+	"github.com/manto/manto-web/internal/logging"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/middleware/access"
+	"github.com/manto/manto-web/internal/middleware/bodysize"
+	"github.com/manto/manto-web/internal/middleware/clientip"
+	"github.com/manto/manto-web/internal/middleware/latency"
+	"github.com/manto/manto-web/internal/middleware/ratelimit"
+	"github.com/manto/manto-web/internal/middleware/recovery"
 	"github.com/manto/manto-web/internal/middleware/security"
+	"github.com/manto/manto-web/internal/middleware/slowlog"
+	"github.com/manto/manto-web/internal/middleware/timeout"
+	"github.com/manto/manto-web/internal/overrides"
+	"github.com/manto/manto-web/internal/replay"
 	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/services/registry"
+	"github.com/manto/manto-web/internal/session"
+	"github.com/manto/manto-web/internal/status"
+	"github.com/manto/manto-web/internal/tenants"
+	"github.com/manto/manto-web/internal/tracing"
+	"github.com/manto/manto-web/internal/upgrade"
 )
 
 //go:embed static/*
 var embeddedStatic embed.FS
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		os.Exit(runLoadtest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "chat" {
+		os.Exit(runChat(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		os.Exit(runBackup(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestore(os.Args[2:]))
+	}
 
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	logger := logging.New(cfg.Logging, os.Stdout)
+	if cfg.Logging.OTLP {
+		exporter := logging.NewOTLPExporter(cfg.Logging, logger, metrics.Default)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			exporter.Close(ctx)
+		}()
+		logger = slog.New(logging.Tee(logger.Handler(), exporter.Handler()))
+	}
+	slog.SetDefault(logger)
+	logger.Info("effective configuration", slog.Any("config", cfg.Summary()))
+
 	port := cfg.Server.Port
 
-	anthropicService := services.NewAnthropicService(cfg)
-	apiHandlers := handlers.NewAPIHandlers(cfg, anthropicService)
+	dlpLog, err := dlp.Open(cfg.DLP)
+	if err != nil {
+		log.Fatalf("Failed to open DLP audit log: %v", err)
+	}
+	requestJournal, err := journal.Open(cfg.Journal)
+	if err != nil {
+		log.Fatalf("Failed to open request journal: %v", err)
+	}
+	defer requestJournal.Close()
 
+	exchanges := capture.New(cfg.Debug, cfg.Anthropic.AuthHeader)
+	upstreamStatus := status.NewTracker(cfg.Status)
+	anthropicService := services.NewAnthropicService(cfg).WithCapture(exchanges).WithStatus(upstreamStatus)
+	probeUpstream(cfg, anthropicService, logger)
+	go prewarmUpstream(cfg, anthropicService, logger)
+	tenantRegistry, err := tenants.Load(cfg.Tenants)
+	if err != nil {
+		log.Fatalf("Failed to load tenants: %v", err)
+	}
+	overrideStore, err := overrides.NewStore(cfg.Overrides.File)
+	if err != nil {
+		log.Fatalf("Failed to open overrides: %v", err)
+	}
+	announcementStore, err := announcements.NewStore(cfg.Announcements.File)
+	if err != nil {
+		log.Fatalf("Failed to open announcements: %v", err)
+	}
+	failureStore, err := replay.NewStore(cfg.Debug)
+	if err != nil {
+		log.Fatalf("Failed to open replay store: %v", err)
+	}
+	apiHandlers := handlers.NewAPIHandlers(cfg, anthropicService).WithAuditLog(dlpLog).WithStatus(upstreamStatus).
+		WithTenants(tenantRegistry).WithOverrides(overrideStore).WithAnnouncements(announcementStore).
+		WithReplay(failureStore).WithJournal(requestJournal)
+	slo := metrics.NewSLO(cfg.SLO, metrics.Default)
+	slo.Start(make(chan struct{}))
+	apiHandlers.WithSLO(slo)
+	providers, err := registry.Build(cfg, anthropicService)
+	if err != nil {
+		log.Fatalf("Failed to set up providers: %v", err)
+	}
+	for _, p := range providers {
+		apiHandlers.WithProvider(p)
+	}
+	apiHandlers.StartCleanup(make(chan struct{}))
+
+	errorPages, err := errorpages.NewRenderer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load error pages: %v", err)
+	}
+
+	clientIPs := clientip.New(cfg.Server)
 	r := chi.NewRouter()
 
+	r.Use(middleware.RequestID)
+	r.Use(clientIPs.Middleware)
+	r.Use(tracing.Middleware(cfg))
+	r.Use(latency.NewRecorder(metrics.Default).Middleware)
 	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(recovery.NewRecoverer(cfg, logger, metrics.Default).Middleware)
+	r.Use(slowlog.Middleware(cfg, logger))
+	r.Use(timeout.New(cfg, logger, metrics.Default).Middleware)
 	r.Use(security.SecurityHeaders(cfg))
+	r.Use(tenantRegistry.Middleware)
+	r.Use(bodysize.NewLimiter(cfg, metrics.Default).Middleware)
+
+	var sessions *session.Manager
+	if cfg.Session.Enabled {
+		sessions = session.NewManager(cfg)
+		sessions.StartCleanup(make(chan struct{}))
+		r.Use(sessions.Middleware)
+	}
+
+	experiment, err := experiments.Load(cfg.Experiments.File)
+	if err != nil {
+		log.Fatalf("Failed to load experiment: %v", err)
+	}
+	r.Use(experiment.Middleware)
 
 	r.Get("/config.js", apiHandlers.ConfigHandler)
-	r.Get("/api/models", apiHandlers.ModelsHandler)
-	r.Post("/api/messages", apiHandlers.MessagesHandler)
+	r.Get("/api/config", apiHandlers.ClientConfigHandler)
+	r.Get("/api/announcements", apiHandlers.AnnouncementsHandler)
+	r.Get("/manifest.webmanifest", apiHandlers.ManifestHandler)
+
+	limiter := ratelimit.NewLimiter(cfg.RateLimit.Requests, cfg.RateLimit.Window.Duration)
+	limiter.StartCleanup(make(chan struct{}))
+	lockout := ratelimit.NewLockout(cfg, apiHandlers.Provider, dlpLog)
+	lockout.StartCleanup(make(chan struct{}))
+
+	config.NewWatcher(cfg, logger).
+		OnChange(func(next *config.Config) { logging.SetLevel(next.Logging.Level) }, "LOG_LEVEL").
+		OnChange(func(next *config.Config) {
+			limiter.SetLimit(next.RateLimit.Requests, next.RateLimit.Window.Duration)
+		}, "RATE_LIMIT_REQUESTS", "RATE_LIMIT_WINDOW").
+		OnChange(func(next *config.Config) {
+			upstreamStatus.SetMaintenance(next.Status.Maintenance, next.Status.MaintenanceMessage)
+		}, "MAINTENANCE_MODE", "MAINTENANCE_MESSAGE").
+		Start(make(chan struct{}))
+
+	accessGate := access.NewGate(cfg)
+
+	var conversationStore *conversations.Store
+	if cfg.Conversations.Enabled {
+		conversationStore, err = conversations.NewStore(cfg.Conversations.File)
+		if err != nil {
+			log.Fatalf("Failed to open conversation store: %v", err)
+		}
+	}
+	var evalStore *evals.Store
+	if cfg.Evals.Enabled {
+		evalStore, err = evals.NewStore(cfg.Evals.File)
+		if err != nil {
+			log.Fatalf("Failed to open eval store: %v", err)
+		}
+	}
+	var archiver *archive.Archiver
+	if cfg.Archive.Enabled {
+		archiver, err = archive.New(cfg.Archive, conversationStore)
+		if err != nil {
+			log.Fatalf("Failed to set up conversation archival: %v", err)
+		}
+		archiver.Start(make(chan struct{}))
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(ratelimit.Middleware(cfg, limiter))
+
+		if accessGate.Enabled() {
+			r.Post("/api/access", accessGate.ExchangeHandler)
+		}
+
+		r.Group(func(r chi.Router) {
+			r.Use(accessGate.Middleware, apiHandlers.SelectProvider, lockout.Middleware)
+
+			r.Get("/api/i18n", apiHandlers.I18nHandler)
+			r.Get("/api/i18n/{locale}", apiHandlers.I18nHandler)
+			r.Get("/api/models", apiHandlers.ModelsHandler)
+			r.Post("/api/messages", apiHandlers.MessagesHandler)
+			r.Post("/api/messages/ndjson", apiHandlers.NDJSONHandler)
+			if cfg.Parts.Enabled {
+				apiHandlers.PartsRoutes(r)
+			}
+			r.Get("/api/queue/{id}", apiHandlers.QueueHandler)
+			r.Post("/api/estimate", apiHandlers.EstimateHandler)
+			r.Post("/api/events", apiHandlers.EventsHandler)
+
+			var titleLimiter *ratelimit.Limiter
+			if cfg.Title.RateLimitRequests > 0 {
+				titleLimiter = ratelimit.NewLimiter(cfg.Title.RateLimitRequests, cfg.Title.RateLimitWindow.Duration)
+				titleLimiter.StartCleanup(make(chan struct{}))
+			}
+			r.With(overrideStore.Gate("titles"), ratelimit.RouteMiddleware(cfg, titleLimiter)).Post("/api/generate-title", apiHandlers.GenerateTitleHandler)
+
+			if sessions != nil {
+				r.Get("/api/session", sessions.UsageHandler)
+			}
+
+			if conversationStore != nil {
+				handlers.NewConversationHandlers(apiHandlers, conversationStore).WithArchive(archiver).Routes(r.With(overrideStore.Gate("conversations")))
+			}
+
+			if cfg.Batch.Enabled {
+				runner := batch.NewRunner(cfg.Batch.Concurrency, cfg.Batch.ResultTTL.Duration)
+				runner.StartCleanup(make(chan struct{}))
+				handlers.NewBatchHandlers(apiHandlers, runner).Routes(r.With(overrideStore.Gate("batch")))
+			}
+
+			if cfg.Summarize.Enabled {
+				handlers.NewSummarizeHandlers(apiHandlers).Routes(r.With(overrideStore.Gate("summarize")))
+			}
+
+			if cfg.Speech.STTProvider != "" || cfg.Speech.TTSProvider != "" {
+				handlers.NewSpeechHandlers(apiHandlers).Routes(r.With(overrideStore.Gate("speech")))
+			}
+
+			if cfg.Passthrough.Enabled {
+				handlers.NewPassthroughHandlers(apiHandlers).Routes(r.With(overrideStore.Gate("passthrough")))
+			}
+
+			if experiment != nil {
+				handlers.NewExperimentHandlers(apiHandlers).Routes(r)
+			}
+
+			if evalStore != nil {
+				handlers.NewEvalHandlers(apiHandlers, evalStore).Routes(r.With(overrideStore.Gate("evals")))
+			}
+		})
+	})
+
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 	})
 
-	sub, err := fs.Sub(embeddedStatic, "static")
-	if err != nil {
-		log.Fatalf("Failed to create sub filesystem: %v", err)
-	}
+	if cfg.IsDevelopment() {
+		static := os.DirFS(cfg.Server.StaticDir)
+		reloader := devmode.NewReloader(static, 500*time.Millisecond)
+		go reloader.Watch(make(chan struct{}))
 
-	fileServer := http.FileServer(http.FS(sub))
-	r.Handle("/*", fileServer)
+		r.Get(devmode.ReloadPath, reloader.EventsHandler)
+		r.Get(devmode.ScriptPath, devmode.ScriptHandler)
+		fileServer := http.FileServer(http.FS(static))
+		r.With(devmode.NoCache, assets.ServiceWorkerHeaders).Handle(assets.ServiceWorkerPath, fileServer)
+		r.With(devmode.NoCache, devmode.InjectScript, errorPages.Middleware).Handle("/*", fileServer)
+		log.Printf("Serving static files from %s with live reload", cfg.Server.StaticDir)
+	} else {
+		sub, err := fs.Sub(embeddedStatic, "static")
+		if err != nil {
+			log.Fatalf("Failed to create sub filesystem: %v", err)
+		}
+
+		fileServer := assets.PrecompressedFileServer(sub)
+		r.With(assets.ServiceWorkerHeaders).Handle(assets.ServiceWorkerPath, fileServer)
+		r.With(errorPages.Middleware).Handle("/*", fileServer)
+	}
 
 	addr := fmt.Sprintf(":%d", port)
 	srv := &http.Server{
@@ -62,8 +318,174 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	log.Printf("Manto starting on port %d (%s)", port, config.GetEnvironment())
-	if err := srv.ListenAndServe(); err != nil {
+	ln, inherited, err := upgrade.Listen("public", addr)
+	if err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	listeners := map[string]net.Listener{"public": ln}
+	servers := []*http.Server{srv}
+
+	go serve(srv, ln)
+
+	if cfg.Admin.Enabled {
+		adminAddr := net.JoinHostPort(cfg.Admin.Host, strconv.Itoa(cfg.Admin.Port))
+		adminLn, _, err := upgrade.Listen("admin", adminAddr)
+		if err != nil {
+			log.Fatalf("Admin server failed to start: %v", err)
+		}
+		adminServer := admin.NewServer(cfg).WithExperiment(experiment).WithTenants(tenantRegistry).WithFeedback(conversationStore).WithDLP(dlpLog).WithCapture(exchanges).
+			WithOverrides(overrideStore).WithAnnouncements(announcementStore).WithReplay(failureStore, anthropicService).
+			WithBackup(backupSources(cfg, conversationStore, evalStore, dlpLog, overrideStore, announcementStore))
+		adminSrv := &http.Server{
+			Handler:      adminServer.Router(),
+			ReadTimeout:  cfg.Server.ReadTimeout.Duration,
+			WriteTimeout: 2 * time.Minute, // allow CPU profiles and traces
+			IdleTimeout:  60 * time.Second,
+		}
+		listeners["admin"] = adminLn
+		servers = append(servers, adminSrv)
+
+		go serve(adminSrv, adminLn)
+		log.Printf("Admin endpoints listening on %s", adminAddr)
+	}
+
+	if cfg.GRPC.Enabled {
+		grpcAddr := net.JoinHostPort(cfg.GRPC.Host, strconv.Itoa(cfg.GRPC.Port))
+		grpcLn, _, err := upgrade.Listen("grpc", grpcAddr)
+		if err != nil {
+			log.Fatalf("gRPC server failed to start: %v", err)
+		}
+		var protocols http.Protocols
+		protocols.SetUnencryptedHTTP2(true)
+		grpcSrv := &http.Server{
+			Handler:     handlers.NewGRPCHandlers(apiHandlers).Handler(clientIPs.Middleware, ratelimit.Middleware(cfg, limiter), accessGate.Middleware, apiHandlers.SelectProvider, lockout.Middleware),
+			Protocols:   &protocols,
+			ReadTimeout: cfg.Server.ReadTimeout.Duration,
+			IdleTimeout: 60 * time.Second,
+		}
+		listeners["grpc"] = grpcLn
+		servers = append(servers, grpcSrv)
+
+		go serve(grpcSrv, grpcLn)
+		log.Printf("gRPC API listening on %s", grpcAddr)
+	}
+
+	if inherited {
+		log.Printf("Manto took over listener on port %d (%s)", port, config.GetEnvironment())
+	} else {
+		log.Printf("Manto starting on port %d (%s)", port, config.GetEnvironment())
+	}
+	if err := upgrade.Ready(); err != nil {
+		log.Printf("Failed to signal readiness to parent process: %v", err)
+	}
+
+	waitForShutdown(servers, listeners, cfg.Server.DrainPeriod.Duration)
+}
+
+// probeUpstream checks the upstream can be reached when
+// ANTHROPIC_STARTUP_PROBE asks for it, so a deployment that can't get out
+// through its proxy or firewall fails, or says so, at once rather than on
+// the first user request.
+func probeUpstream(cfg *config.Config, service *services.AnthropicService, logger *slog.Logger) {
+	if cfg.Anthropic.StartupProbe == "off" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Anthropic.StartupProbeTimeout.Duration)
+	defer cancel()
+	err := service.Probe(ctx)
+	switch {
+	case err == nil:
+		logger.Info("upstream reachable", slog.String("baseURL", cfg.Anthropic.BaseURL))
+	case cfg.Anthropic.StartupProbe == "fail":
+		log.Fatalf("Startup probe failed: %v", err)
+	default:
+		logger.Error("UPSTREAM UNREACHABLE: requests will fail until this is fixed", slog.String("baseURL", cfg.Anthropic.BaseURL), slog.String("error", err.Error()))
+	}
+}
+
+// prewarmUpstream keeps ANTHROPIC_PREWARM_CONNECTIONS connections to the
+// upstream open, warming them at startup and every ANTHROPIC_PREWARM_INTERVAL.
+func prewarmUpstream(cfg *config.Config, service *services.AnthropicService, logger *slog.Logger) {
+	n := cfg.Anthropic.PrewarmConnections
+	if n <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.Anthropic.PrewarmInterval.Duration)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Anthropic.Timeout.Duration)
+		warmed, err := service.Prewarm(ctx, n)
+		cancel()
+		if err != nil {
+			logger.Warn("upstream pre-warming failed", slog.Int("warmed", warmed), slog.Int("connections", n), slog.String("error", err.Error()))
+		} else {
+			logger.Debug("upstream connections pre-warmed", slog.Int("connections", warmed))
+		}
+		<-ticker.C
+	}
+}
+
+// backupSources lists the data files to back up, restoring each into the
+// running store that owns it.
+func backupSources(cfg *config.Config, conversationStore *conversations.Store, evalStore *evals.Store, dlpLog *dlp.Log, overrideStore *overrides.Store, announcementStore *announcements.Store) []backup.Source {
+	sources := backup.Sources(cfg)
+	for i := range sources {
+		switch sources[i].Name {
+		case "conversations":
+			sources[i].Replace = conversationStore.Replace
+		case "evals":
+			sources[i].Replace = evalStore.Replace
+		case "dlp":
+			sources[i].Replace = dlpLog.Replace
+		case "overrides":
+			sources[i].Replace = overrideStore.Replace
+		case "announcements":
+			sources[i].Replace = announcementStore.Replace
+		}
+	}
+	return sources
+}
+
+func serve(srv *http.Server, ln net.Listener) {
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
+
+// waitForShutdown blocks until SIGINT/SIGTERM, or until a SIGHUP-triggered
+// upgrade has handed the listeners to a new process, then drains in-flight
+// requests for up to drain before exiting.
+func waitForShutdown(servers []*http.Server, listeners map[string]net.Listener, drain time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			child, err := upgrade.Restart(listeners, 30*time.Second)
+			if err != nil {
+				log.Printf("Upgrade failed, continuing to serve: %v", err)
+				continue
+			}
+			log.Printf("Upgraded to pid %d, draining for up to %s", child.Pid, drain)
+		} else {
+			log.Printf("Received %s, draining for up to %s", sig, drain)
+		}
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("Drain period elapsed, closing remaining connections: %v", err)
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
 }
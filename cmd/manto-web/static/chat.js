@@ -8,14 +8,33 @@ const UI_CONFIG = {
     SELECT_MODEL: "Please select a model",
     MESSAGE_TOO_LONG: "Message too long",
     GENERIC_ERROR: "Something went wrong. Please try again.",
+    ACCESS_CODE_PROMPT: "This instance is private. Enter your access code:",
+    QUEUED: "Queued, position {position} (about {seconds}s)…",
+    STATUS_OUTAGE: "Anthropic isn't responding right now, so messages may fail.",
+    STATUS_QUOTA: "The upstream usage limit has been reached, so messages may fail for a while.",
+    STATUS_MAINTENANCE: "This service is under maintenance.",
+    TRANSCRIPTION_FAILED: "Could not transcribe the recording",
+    SPEECH_FAILED: "Could not read the text aloud",
+    READ_ALOUD: "Read aloud",
+    DISMISS: "Dismiss",
   },
+  STATUS_POLL_INTERVAL: 60000,
 };
 
 const validate = {
   apiKey: (key, config) =>
     key?.trim().length >= (config?.validation?.minApiKeyLength || 10),
-  anthropicKey: (key, config) =>
-    key?.startsWith(config?.api?.anthropicKeyPrefix || "sk-ant-"),
+  anthropicKey: (key, config) => {
+    const prefixes = config?.api?.anthropicKeyPrefixes ?? [
+      config?.api?.anthropicKeyPrefix || "sk-ant-",
+    ];
+    const pattern = config?.api?.anthropicKeyPattern;
+    if (prefixes.length === 0 && !pattern) return true;
+    return (
+      prefixes.some((prefix) => key?.startsWith(prefix)) ||
+      (pattern ? new RegExp(`^(?:${pattern})$`).test(key) : false)
+    );
+  },
   message: (msg, config) =>
     msg?.trim() && msg.length <= (config?.validation?.maxMessageLength || 4000),
   provider: (provider) => Boolean(provider),
@@ -66,6 +85,7 @@ function hideValidationMessage() {
 const ChatApp = {
   state: {
     apiKey: null,
+    locale: null,
     currentProvider: null,
     currentModel: null,
     config: null,
@@ -83,6 +103,7 @@ const ChatApp = {
       return;
     }
 
+    this.loadLocale();
     this.loadConfig();
     this.setupEventListeners();
     this.showSetup();
@@ -101,6 +122,7 @@ const ChatApp = {
       chatMessages: document.getElementById("chatMessages"),
       messageInput: document.getElementById("messageInput"),
       sendBtn: document.getElementById("sendBtn"),
+      micBtn: document.getElementById("micBtn"),
       chatInputForm: document.getElementById("chatInputForm"),
       newChatBtn: document.getElementById("newChatBtn"),
       hideTips: document.getElementById("hideTips"),
@@ -112,6 +134,87 @@ const ChatApp = {
     return required.every((key) => this.elements[key]);
   },
 
+  async loadLocale() {
+    const requested = new URLSearchParams(window.location.search).get("lang");
+    const url = requested
+      ? `/api/i18n/${encodeURIComponent(requested)}`
+      : "/api/i18n";
+
+    try {
+      const response = await this.apiFetch(url);
+      if (!response.ok) return;
+
+      const { locale, messages } = await response.json();
+      this.state.locale = locale;
+      document.documentElement.lang = locale;
+      this.applyTranslations(messages);
+    } catch (error) {
+      console.warn("Failed to load translations:", error);
+    }
+  },
+
+  applyTranslations(messages) {
+    const translate = (attr, apply) => {
+      document.querySelectorAll(`[${attr}]`).forEach((el) => {
+        const text = messages[el.getAttribute(attr)];
+        if (text) apply(el, text);
+      });
+    };
+
+    translate("data-i18n", (el, text) => (el.textContent = text));
+    translate("data-i18n-placeholder", (el, text) => (el.placeholder = text));
+    translate("data-i18n-title", (el, text) => (el.title = text));
+
+    Object.keys(UI_CONFIG.MESSAGES).forEach((key) => {
+      const text = messages[`messages.${key}`];
+      if (text) UI_CONFIG.MESSAGES[key] = text;
+    });
+  },
+
+  apiHeaders(apiKey = this.state.apiKey, provider = this.state.currentProvider) {
+    const keyHeader = this.state.config?.api?.keyHeader || "x-api-key";
+    const headers = {
+      [keyHeader]:
+        keyHeader.toLowerCase() === "authorization" ? `Bearer ${apiKey}` : apiKey,
+      "Content-Type": "application/json",
+    };
+    if (provider) {
+      headers["X-Manto-Provider"] = provider;
+    }
+    if (this.state.locale) {
+      headers["Accept-Language"] = this.state.locale;
+    }
+    return headers;
+  },
+
+  // apiURL prefixes an /api path with the tenant's base path, so a tenant
+  // served under /t/{tenant} talks to its own workspace.
+  apiURL(path) {
+    return (window.MantoConfig?.tenant?.basePath || "") + path;
+  },
+
+  // apiFetch wraps fetch for /api routes. When the instance is gated by an
+  // access code it asks for the code once, exchanges it for an HttpOnly
+  // cookie and retries the request.
+  async apiFetch(path, options) {
+    const url = this.apiURL(path);
+    const response = await fetch(url, options);
+    if (response.status !== 401) return response;
+
+    const body = await response.clone().json().catch(() => ({}));
+    if (body.code !== "access_code_required") return response;
+
+    const code = window.prompt(UI_CONFIG.MESSAGES.ACCESS_CODE_PROMPT);
+    if (!code) return response;
+
+    const exchange = await fetch(this.apiURL("/api/access"), {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ code }),
+    });
+    return exchange.ok ? fetch(url, options) : exchange;
+  },
+
   loadConfig() {
     if (window.MantoConfig?.providers) {
       this.state.config = window.MantoConfig;
@@ -130,6 +233,109 @@ const ChatApp = {
       };
       this.populateProviders();
     }
+    const maxLength = this.state.config.validation?.maxMessageLength;
+    if (maxLength) this.elements.messageInput.maxLength = maxLength;
+    this.applyBranding();
+    this.renderStatus(this.state.config.status);
+    this.renderAnnouncements(this.state.config.announcements);
+    this.setupVoiceInput();
+    setInterval(() => this.refreshStatus(), UI_CONFIG.STATUS_POLL_INTERVAL);
+  },
+
+  // applyBranding shows a tenant's name and colour in place of the ones
+  // built into the page.
+  applyBranding() {
+    const { tenant, branding } = this.state.config;
+    if (!tenant || !branding) return;
+    if (branding.name) {
+      document.title = branding.description
+        ? `${branding.name} - ${branding.description}`
+        : branding.name;
+    }
+    if (branding.themeColor) {
+      document
+        .querySelector('meta[name="theme-color"]')
+        ?.setAttribute("content", branding.themeColor);
+    }
+  },
+
+  // refreshStatus re-reads the service status so the banner appears, or
+  // clears, without a reload.
+  async refreshStatus() {
+    if (document.visibilityState !== "visible") return;
+    try {
+      const response = await fetch(this.apiURL("/api/config"), { cache: "no-store" });
+      if (!response.ok) return;
+      const config = await response.json();
+      this.renderStatus(config.status);
+      this.renderAnnouncements(config.announcements);
+    } catch {
+      // Offline; keep whatever is shown.
+    }
+  },
+
+  renderStatus(status) {
+    let banner = document.getElementById("statusBanner");
+    if (!status) {
+      banner?.remove();
+      return;
+    }
+    if (!banner) {
+      banner = document.createElement("div");
+      banner.id = "statusBanner";
+      banner.className = "status-banner";
+      banner.setAttribute("role", "status");
+      const header = document.querySelector(".modern-header");
+      if (header) header.after(banner);
+      else document.body.prepend(banner);
+    }
+    banner.dataset.state = status.state;
+    banner.textContent =
+      status.message ||
+      UI_CONFIG.MESSAGES[`STATUS_${status.state.toUpperCase()}`] ||
+      UI_CONFIG.MESSAGES.GENERIC_ERROR;
+  },
+
+  // renderAnnouncements shows the scheduled banners that are live now. A
+  // dismissed banner stays hidden until the page is reloaded.
+  renderAnnouncements(announcements = []) {
+    const dismissed = (this.state.dismissedAnnouncements ??= new Set());
+    let list = document.getElementById("announcements");
+    const visible = announcements.filter((a) => !dismissed.has(a.id));
+    if (!visible.length) {
+      list?.remove();
+      return;
+    }
+    if (!list) {
+      list = document.createElement("div");
+      list.id = "announcements";
+      const anchor =
+        document.getElementById("statusBanner") ||
+        document.querySelector(".modern-header");
+      if (anchor) anchor.after(list);
+      else document.body.prepend(list);
+    }
+    list.replaceChildren(
+      ...visible.map((announcement) => {
+        const banner = document.createElement("div");
+        banner.className = "status-banner announcement";
+        banner.dataset.level = announcement.level;
+        banner.setAttribute("role", "status");
+        const text = document.createElement("span");
+        text.textContent = announcement.message;
+        const close = document.createElement("button");
+        close.type = "button";
+        close.className = "announcement-dismiss";
+        close.setAttribute("aria-label", UI_CONFIG.MESSAGES.DISMISS);
+        close.textContent = "×";
+        close.addEventListener("click", () => {
+          dismissed.add(announcement.id);
+          this.renderAnnouncements(announcements);
+        });
+        banner.append(text, close);
+        return banner;
+      }),
+    );
   },
 
   populateProviders() {
@@ -172,7 +378,7 @@ const ChatApp = {
     });
 
     if (models.length > 0) {
-      this.setDefaultModel(models, select);
+      this.setDefaultModel(models, select, provider.defaultModel);
     }
   },
 
@@ -180,12 +386,17 @@ const ChatApp = {
     return `Created: ${new Date(createdAt).toLocaleDateString()}`;
   },
 
-  setDefaultModel(models, select) {
-    const preferredIndex = models.findIndex(
-      (model) =>
-        model.id.includes("haiku") ||
-        model.display_name?.toLowerCase().includes("haiku")
-    );
+  setDefaultModel(models, select, defaultModel) {
+    let preferredIndex = defaultModel
+      ? models.findIndex((model) => model.id === defaultModel)
+      : -1;
+    if (preferredIndex < 0) {
+      preferredIndex = models.findIndex(
+        (model) =>
+          model.id.includes("haiku") ||
+          model.display_name?.toLowerCase().includes("haiku")
+      );
+    }
 
     if (preferredIndex >= 0) {
       select.selectedIndex = preferredIndex + 1;
@@ -205,12 +416,9 @@ const ChatApp = {
     }
 
     try {
-      const response = await fetch("/api/models", {
+      const response = await this.apiFetch("/api/models", {
         method: "GET",
-        headers: {
-          "x-api-key": apiKey,
-          "Content-Type": "application/json",
-        },
+        headers: this.apiHeaders(apiKey, providerName),
       });
 
       if (!response.ok) {
@@ -229,21 +437,48 @@ const ChatApp = {
     }
   },
 
-  async sendMessageToApi(model, messages) {
+  trackEvent(event) {
+    if (!this.state.config?.analytics?.enabled) return;
+
+    fetch(this.apiURL("/api/events"), {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ event }),
+      keepalive: true,
+    }).catch(() => {});
+  },
+
+  // waitForQueuedResponse polls a 202 ticket until the server returns the
+  // final response, reporting queue position through onQueued.
+  async waitForQueuedResponse(response, onQueued) {
+    while (response.status === 202) {
+      const ticket = await response.json();
+      onQueued?.(ticket);
+
+      const retryAfter = Number(response.headers.get("Retry-After")) || 1;
+      await new Promise((resolve) => setTimeout(resolve, retryAfter * 1000));
+      response = await this.apiFetch(ticket.pollUrl, {
+        headers: this.apiHeaders(),
+      });
+    }
+    return response;
+  },
+
+  async sendMessageToApi(model, messages, onQueued) {
     try {
       const requestBody = {
         model: model,
-        messages: messages,
+        messages: await Promise.all(messages.map((m) => this.uploadIfLarge(m))),
       };
 
-      const response = await fetch("/api/messages", {
-        method: "POST",
-        headers: {
-          "x-api-key": this.state.apiKey,
-          "Content-Type": "application/json",
-        },
-        body: JSON.stringify(requestBody),
-      });
+      const response = await this.waitForQueuedResponse(
+        await this.apiFetch("/api/messages", {
+          method: "POST",
+          headers: this.apiHeaders(),
+          body: JSON.stringify(requestBody),
+        }),
+        onQueued
+      );
 
       if (!response.ok) {
         const errorData = await response.json();
@@ -261,6 +496,53 @@ const ChatApp = {
     }
   },
 
+  // uploadIfLarge sends a message bigger than one request part through
+  // /api/messages/parts and returns it as a reference to the upload. The
+  // upload ID is kept on the history entry so later turns reuse it.
+  async uploadIfLarge(message) {
+    const parts = this.state.config?.parts;
+    if (!parts?.enabled) return message;
+    if (message.uploadId) return { role: message.role, content: "", uploadId: message.uploadId };
+
+    const bytes = new TextEncoder().encode(message.content);
+    if (bytes.length <= parts.maxPartSize) return message;
+
+    let uploadId = "";
+    for (let i = 0; i * parts.maxPartSize < bytes.length; i++) {
+      const segment = bytes.subarray(i * parts.maxPartSize, (i + 1) * parts.maxPartSize);
+      const query = new URLSearchParams({ index: i });
+      if (uploadId) query.set("uploadId", uploadId);
+      const headers = this.apiHeaders();
+      headers["Content-Type"] = "text/plain; charset=utf-8";
+      const response = await this.apiFetch(`/api/messages/parts?${query}`, {
+        method: "POST",
+        headers,
+        body: segment,
+      });
+      const body = await response.json().catch(() => ({}));
+      if (!response.ok) {
+        throw new ChatError(body.error || UI_CONFIG.MESSAGES.GENERIC_ERROR, "API");
+      }
+      uploadId = body.uploadId;
+    }
+    message.uploadId = uploadId;
+    return { role: message.role, content: "", uploadId };
+  },
+
+  // guardPaste refuses a paste that would take the prompt past the message
+  // length, rather than letting the textarea silently cut it off.
+  guardPaste(e) {
+    const input = this.elements.messageInput;
+    const pasted = e.clipboardData?.getData("text") || "";
+    const max = this.state.config?.validation?.maxMessageLength || 4000;
+    const length =
+      input.value.length - (input.selectionEnd - input.selectionStart) + pasted.length;
+    if (length > max) {
+      e.preventDefault();
+      showUserError(`${UI_CONFIG.MESSAGES.MESSAGE_TOO_LONG} (max ${max} characters)`);
+    }
+  },
+
   setupEventListeners() {
     this.elements.setupForm?.addEventListener("submit", (e) =>
       this.handleSetup(e)
@@ -271,6 +553,9 @@ const ChatApp = {
     this.elements.messageInput?.addEventListener("input", () =>
       this.handleInputChange()
     );
+    this.elements.messageInput?.addEventListener("paste", (e) =>
+      this.guardPaste(e)
+    );
     this.elements.messageInput?.addEventListener("keydown", (e) => {
       if (e.key === "Enter" && !e.shiftKey) {
         e.preventDefault();
@@ -286,11 +571,89 @@ const ChatApp = {
     );
     this.elements.modelSelector?.addEventListener("change", (e) => {
       this.state.currentModel = e.target.value;
+      this.trackEvent("model_changed");
       this.clearChat();
       this.updateSendButton();
     });
   },
 
+  // setupVoiceInput shows the push-to-talk button when the server has a
+  // speech-to-text provider and the browser can record.
+  setupVoiceInput() {
+    const button = this.elements.micBtn;
+    if (
+      !button ||
+      !this.state.config?.speech?.transcribe ||
+      !navigator.mediaDevices?.getUserMedia ||
+      typeof MediaRecorder === "undefined"
+    ) {
+      return;
+    }
+    button.hidden = false;
+    button.addEventListener("pointerdown", (e) => {
+      e.preventDefault();
+      this.startRecording();
+    });
+    for (const type of ["pointerup", "pointerleave", "pointercancel"]) {
+      button.addEventListener(type, () => this.stopRecording());
+    }
+  },
+
+  async startRecording() {
+    if (this.recorder) return;
+    try {
+      const stream = await navigator.mediaDevices.getUserMedia({ audio: true });
+      const chunks = [];
+      this.recorder = new MediaRecorder(stream);
+      this.recorder.addEventListener("dataavailable", (e) => chunks.push(e.data));
+      this.recorder.addEventListener("stop", () => {
+        stream.getTracks().forEach((track) => track.stop());
+        const type = this.recorder.mimeType || "audio/webm";
+        this.recorder = null;
+        this.elements.micBtn.classList.remove("recording");
+        this.transcribe(new Blob(chunks, { type }));
+      });
+      this.recorder.start();
+      this.elements.micBtn.classList.add("recording");
+    } catch (error) {
+      this.recorder = null;
+      handleError(error, "startRecording");
+    }
+  },
+
+  stopRecording() {
+    if (this.recorder?.state === "recording") this.recorder.stop();
+  },
+
+  // transcribe sends a recording to /api/transcribe and appends the text to
+  // the prompt.
+  async transcribe(blob) {
+    if (blob.size === 0) return;
+    const form = new FormData();
+    form.append("file", blob, "recording");
+    if (this.state.locale) form.append("language", this.state.locale.split("-")[0]);
+
+    const headers = this.apiHeaders();
+    delete headers["Content-Type"];
+    try {
+      const response = await this.apiFetch("/api/transcribe", {
+        method: "POST",
+        headers,
+        body: form,
+      });
+      const body = await response.json().catch(() => ({}));
+      if (!response.ok) {
+        throw new ChatError(body.error || UI_CONFIG.MESSAGES.TRANSCRIPTION_FAILED);
+      }
+      const input = this.elements.messageInput;
+      input.value = [input.value.trim(), body.text].filter(Boolean).join(" ");
+      this.handleInputChange();
+      input.focus();
+    } catch (error) {
+      handleError(error, "transcribe");
+    }
+  },
+
   async handleSetup(e) {
     e.preventDefault();
 
@@ -334,6 +697,13 @@ const ChatApp = {
       return false;
     }
 
+    const selected = this.state.config.providers.find(
+      (p) => p.name === provider
+    );
+    if (selected?.keyRequired === false && !key) {
+      return true;
+    }
+
     if (!validate.apiKey(key, this.state.config)) {
       showValidationMessage(UI_CONFIG.MESSAGES.INVALID_API_KEY, true);
       return false;
@@ -347,6 +717,12 @@ const ChatApp = {
       return false;
     }
 
+    const keyPrefix = selected?.keyPrefix;
+    if (provider !== "anthropic" && keyPrefix && !key.startsWith(keyPrefix)) {
+      showValidationMessage(UI_CONFIG.MESSAGES.INVALID_API_KEY, true);
+      return false;
+    }
+
     return true;
   },
 
@@ -372,10 +748,17 @@ const ChatApp = {
     if (!message) return;
 
     this.updateUIForSending();
+    this.trackEvent("message_sent");
     const loadingMessage = this.showLoadingMessage();
 
     try {
-      const response = await this.sendAndProcessMessage(message);
+      const response = await this.sendAndProcessMessage(message, (ticket) => {
+        const status = UI_CONFIG.MESSAGES.QUEUED.replace(
+          "{position}",
+          ticket.position
+        ).replace("{seconds}", ticket.estimatedWaitSeconds);
+        loadingMessage.querySelector(".message-content").textContent = status;
+      });
       this.handleSuccessfulResponse(response, loadingMessage);
     } catch (error) {
       this.handleMessageError(error, loadingMessage);
@@ -436,10 +819,11 @@ const ChatApp = {
     return loadingMessage;
   },
 
-  async sendAndProcessMessage(messageData) {
+  async sendAndProcessMessage(messageData, onQueued) {
     return await this.sendMessageToApi(
       messageData.model,
-      this.state.conversationHistory
+      this.state.conversationHistory,
+      onQueued
     );
   },
 
@@ -549,10 +933,58 @@ const ChatApp = {
     }
 
     wrap.append(avatarEl, contentEl);
+    if (!isUser && !isError && this.state.config?.speech?.tts) {
+      const readBtn = document.createElement("button");
+      readBtn.type = "button";
+      readBtn.className = "action-btn read-aloud-btn";
+      readBtn.title = UI_CONFIG.MESSAGES.READ_ALOUD;
+      readBtn.textContent = "🔊";
+      readBtn.addEventListener("click", () =>
+        this.readAloud(contentEl.textContent, readBtn)
+      );
+      wrap.append(readBtn);
+    }
     this.elements.chatMessages.appendChild(wrap);
     return wrap;
   },
 
+  // readAloud plays text through /api/tts. Clicking again while it plays
+  // stops it.
+  async readAloud(text, button) {
+    if (this.audio) {
+      this.audio.pause();
+      URL.revokeObjectURL(this.audio.src);
+      const playing = this.audio.button === button;
+      this.audio.button.classList.remove("playing");
+      this.audio = null;
+      if (playing) return;
+    }
+    try {
+      const response = await this.apiFetch("/api/tts", {
+        method: "POST",
+        headers: this.apiHeaders(),
+        body: JSON.stringify({ text }),
+      });
+      if (!response.ok) {
+        const body = await response.json().catch(() => ({}));
+        throw new ChatError(body.error || UI_CONFIG.MESSAGES.SPEECH_FAILED);
+      }
+      const audio = new Audio(URL.createObjectURL(await response.blob()));
+      audio.button = button;
+      audio.addEventListener("ended", () => {
+        URL.revokeObjectURL(audio.src);
+        button.classList.remove("playing");
+        if (this.audio === audio) this.audio = null;
+      });
+      this.audio = audio;
+      button.classList.add("playing");
+      await audio.play();
+    } catch (error) {
+      button.classList.remove("playing");
+      handleError(error, "readAloud");
+    }
+  },
+
   buildMessageClasses(isUser, isError) {
     const classes = ["message"];
 
@@ -596,6 +1028,14 @@ const ChatApp = {
   },
 };
 
+if ("serviceWorker" in navigator) {
+  window.addEventListener("load", () => {
+    navigator.serviceWorker.register("/sw.js").catch((error) => {
+      console.warn("Service worker registration failed:", error);
+    });
+  });
+}
+
 if (document.readyState === "loading") {
   document.addEventListener("DOMContentLoaded", () => ChatApp.init());
 } else {
@@ -5,3 +5,5 @@ go 1.25.0
 require github.com/go-chi/chi/v5 v5.2.3
 
 require github.com/joho/godotenv v1.5.1
+
+require github.com/klauspost/compress v1.18.0
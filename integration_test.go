@@ -56,8 +56,12 @@ func setupTestServer(_ *testing.T) *httptest.Server {
 	r.Use(security.SecurityHeaders(cfg))
 
 	r.Get("/config.js", apiHandlers.ConfigHandler)
+	r.Get("/manifest.webmanifest", apiHandlers.ManifestHandler)
+	r.Get("/api/i18n", apiHandlers.I18nHandler)
+	r.Get("/api/i18n/{locale}", apiHandlers.I18nHandler)
 	r.Get("/api/models", apiHandlers.ModelsHandler)
 	r.Post("/api/messages", apiHandlers.MessagesHandler)
+	r.Post("/api/events", apiHandlers.EventsHandler)
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 	})
@@ -258,8 +262,11 @@ func TestAnthropicAPIIntegration(t *testing.T) {
 	r := chi.NewRouter()
 	r.Use(middleware.Recoverer)
 	r.Use(security.SecurityHeaders(cfg))
+	r.Get("/api/i18n", apiHandlers.I18nHandler)
+	r.Get("/api/i18n/{locale}", apiHandlers.I18nHandler)
 	r.Get("/api/models", apiHandlers.ModelsHandler)
 	r.Post("/api/messages", apiHandlers.MessagesHandler)
+	r.Post("/api/events", apiHandlers.EventsHandler)
 
 	server := httptest.NewServer(r)
 	defer server.Close()
@@ -314,8 +321,8 @@ func TestAnthropicAPIIntegration(t *testing.T) {
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusBadRequest {
-			t.Errorf("expected status 400, got %d", resp.StatusCode)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", resp.StatusCode)
 		}
 
 		var errorResp map[string]string
@@ -326,6 +333,9 @@ func TestAnthropicAPIIntegration(t *testing.T) {
 		if !strings.Contains(errorResp["error"], "Invalid API key") {
 			t.Errorf("expected 'Invalid API key' error, got: %s", errorResp["error"])
 		}
+		if errorResp["code"] != "authentication_failed" {
+			t.Errorf("expected code authentication_failed, got: %s", errorResp["code"])
+		}
 	})
 
 	t.Run("models endpoint with valid key", func(t *testing.T) {
@@ -0,0 +1,302 @@
+// Package admin serves operational endpoints (health, metrics, pprof and the
+// admin API) on a separate listener so they are never reachable through the
+// public port, even if the reverse proxy in front of Manto is misconfigured.
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/announcements"
+	"github.com/manto/manto-web/internal/backup"
+	"github.com/manto/manto-web/internal/capture"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/conversations"
+	"github.com/manto/manto-web/internal/dlp"
+	"github.com/manto/manto-web/internal/egress"
+	"github.com/manto/manto-web/internal/experiments"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/middleware/security"
+	"github.com/manto/manto-web/internal/overrides"
+	"github.com/manto/manto-web/internal/replay"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/tenants"
+)
+
+type Server struct {
+	config        *config.Config
+	started       time.Time
+	client        *http.Client
+	experiment    *experiments.Experiment
+	feedback      *conversations.Store
+	dlp           *dlp.Log
+	capture       *capture.Recorder
+	backups       []backup.Source
+	tenants       *tenants.Registry
+	overrides     *overrides.Store
+	announcements *announcements.Store
+	failures      *replay.Store
+	anthropic     *services.AnthropicService
+}
+
+func NewServer(cfg *config.Config) *Server {
+	return &Server{
+		config:  cfg,
+		started: time.Now(),
+		client:  &http.Client{Timeout: cfg.Anthropic.Timeout.Duration, Transport: egress.New(cfg).Transport()},
+	}
+}
+
+// WithExperiment serves e's report at /admin/api/experiments.
+func (s *Server) WithExperiment(e *experiments.Experiment) *Server {
+	s.experiment = e
+	return s
+}
+
+// WithTenants serves reg's usage report at /admin/api/tenants.
+func (s *Server) WithTenants(reg *tenants.Registry) *Server {
+	s.tenants = reg
+	return s
+}
+
+// WithFeedback serves the deployment-wide feedback report from store at
+// /admin/api/feedback.
+func (s *Server) WithFeedback(store *conversations.Store) *Server {
+	s.feedback = store
+	return s
+}
+
+// WithDLP serves log's entries at /admin/api/dlp.
+func (s *Server) WithDLP(log *dlp.Log) *Server {
+	s.dlp = log
+	return s
+}
+
+// WithCapture serves recorder's exchanges at /admin/api/debug/exchanges.
+func (s *Server) WithCapture(recorder *capture.Recorder) *Server {
+	s.capture = recorder
+	return s
+}
+
+// Router builds the admin mux. Admin API routes live under /admin/api.
+func (s *Server) Router() chi.Router {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if s.config.Metrics.Enabled {
+		r.Handle(s.config.Metrics.Path, metrics.Default.Handler())
+	}
+
+	if s.config.Admin.EnablePprof {
+		r.HandleFunc("/debug/pprof/", pprof.Index)
+		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		r.Handle("/debug/pprof/{profile}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pprof.Handler(chi.URLParam(r, "profile")).ServeHTTP(w, r)
+		}))
+	}
+
+	r.Route("/admin/api", func(r chi.Router) {
+		r.Get("/info", s.InfoHandler)
+		r.Get("/env", s.EnvHandler)
+		r.Get("/security-policy", s.SecurityPolicyHandler)
+
+		if s.experiment != nil {
+			r.With(s.requireToken).Get("/experiments", s.ExperimentsHandler)
+		}
+
+		if s.tenants != nil {
+			r.With(s.requireToken).Get("/tenants", s.TenantsHandler)
+		}
+
+		if s.feedback != nil {
+			r.With(s.requireToken).Get("/feedback", s.FeedbackHandler)
+		}
+
+		if s.dlp != nil {
+			r.With(s.requireToken).Get("/dlp", s.DLPHandler)
+		}
+
+		if s.capture != nil {
+			r.With(s.requireToken).Get("/debug/exchanges", s.ExchangesHandler)
+		}
+
+		if s.failures != nil {
+			r.With(s.requireToken).Get("/debug/failures", s.FailuresHandler)
+			r.With(s.requireToken).Post("/debug/failures/{id}/replay", s.ReplayHandler)
+		}
+
+		if s.overrides != nil {
+			r.With(s.requireToken).Get("/overrides", s.OverridesHandler)
+			r.With(s.requireToken).Put("/overrides/{scope}", s.SetOverrideHandler)
+			r.With(s.requireToken).Delete("/overrides/{scope}", s.DeleteOverrideHandler)
+		}
+
+		if s.announcements != nil {
+			r.With(s.requireToken).Get("/announcements", s.AnnouncementsHandler)
+			r.With(s.requireToken).Post("/announcements", s.CreateAnnouncementHandler)
+			r.With(s.requireToken).Delete("/announcements/{id}", s.DeleteAnnouncementHandler)
+		}
+
+		if s.backups != nil {
+			r.With(s.requireToken).Get("/backup", s.BackupHandler)
+			r.With(s.requireToken).Post("/restore", s.RestoreHandler)
+		}
+
+		if s.config.Admin.AnthropicAdminKey != "" {
+			r.With(s.requireToken).Get("/anthropic/*", s.AnthropicProxyHandler)
+		}
+	})
+
+	return r
+}
+
+func (s *Server) InfoHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"environment":   s.config.Environment,
+		"goVersion":     runtime.Version(),
+		"goroutines":    runtime.NumGoroutine(),
+		"startedAt":     s.started.UTC(),
+		"uptimeSeconds": int(time.Since(s.started).Seconds()),
+	})
+}
+
+// EnvHandler lists the dotenv files considered at startup and the variables
+// each contributed or had shadowed, to answer where a value came from. Values
+// themselves are never shown.
+func (s *Server) EnvHandler(w http.ResponseWriter, r *http.Request) {
+	files := s.config.EnvFiles()
+	if files == nil {
+		files = []config.EnvFile{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"environment": s.config.Environment,
+		"files":       files,
+	})
+}
+
+// SecurityPolicyHandler shows the security headers of each profile, pages
+// and API responses, with the Content-Security-Policy broken into
+// directives, and the risky combinations among them.
+func (s *Server) SecurityPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	hsts := !s.config.IsDevelopment()
+	page, api := security.Headers(s.config), security.APIHeaders(s.config)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page": map[string]interface{}{
+			"headers":  flatten(page),
+			"csp":      security.ParseCSP(page.Get("Content-Security-Policy")),
+			"findings": security.Check(page, hsts),
+		},
+		"api": map[string]interface{}{
+			"headers":  flatten(api),
+			"findings": security.CheckAPI(api, hsts),
+		},
+	})
+}
+
+// flatten maps each header name to its value.
+func flatten(header http.Header) map[string]string {
+	values := make(map[string]string, len(header))
+	for name := range header {
+		values[name] = header.Get(name)
+	}
+	return values
+}
+
+// ExperimentsHandler reports responses and feedback per variant.
+func (s *Server) ExperimentsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.experiment.Report())
+}
+
+// TenantsHandler reports each tenant's usage against its quota.
+func (s *Server) TenantsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tenants": s.tenants.Report()})
+}
+
+// FeedbackHandler aggregates ratings across every owner, optionally only those
+// given since ?since= (RFC 3339 or YYYY-MM-DD).
+func (s *Server) FeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := conversations.ParseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid since"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.feedback.Feedback("", since))
+}
+
+// DLPHandler exports the DLP audit trail, optionally only entries since
+// ?since= (RFC 3339 or YYYY-MM-DD), as JSON or, with ?format=csv, as CSV.
+func (s *Server) DLPHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := conversations.ParseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid since"})
+		return
+	}
+	entries := s.dlp.Entries(since)
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="dlp-audit.csv"`)
+		w.Header().Set("Cache-Control", "no-store")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "stage", "action", "checkers", "rules", "hash", "snippet", "key", "session", "model"})
+		for _, e := range entries {
+			cw.Write([]string{
+				e.Time.Format(time.RFC3339),
+				e.Stage,
+				e.Action,
+				strings.Join(e.Checkers, ","),
+				strings.Join(e.Rules, ","),
+				e.Hash,
+				e.Snippet,
+				e.Attribution["key"],
+				e.Attribution["session"],
+				e.Attribution["model"],
+			})
+		}
+		cw.Flush()
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid format"})
+	}
+}
+
+// ExchangesHandler lists the captured upstream exchanges, newest first,
+// optionally only those with ?status= or, with ?status=error, every failed
+// one (a status of 400 or more, or no response at all).
+func (s *Server) ExchangesHandler(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("status")
+	exchanges := []capture.Exchange{}
+	for _, x := range s.capture.Exchanges() {
+		switch {
+		case filter == "",
+			filter == "error" && (x.Status >= 400 || x.Error != ""),
+			filter == strconv.Itoa(x.Status):
+			exchanges = append(exchanges, x)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"exchanges": exchanges})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
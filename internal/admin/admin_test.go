@@ -0,0 +1,535 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/announcements"
+	"github.com/manto/manto-web/internal/backup"
+	"github.com/manto/manto-web/internal/capture"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/conversations"
+	"github.com/manto/manto-web/internal/dlp"
+	"github.com/manto/manto-web/internal/experiments"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/overrides"
+	"github.com/manto/manto-web/internal/replay"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/tenants"
+)
+
+func createTestConfig() *config.Config {
+	cfg := &config.Config{Environment: "test"}
+	cfg.Metrics.Enabled = true
+	cfg.Metrics.Path = "/metrics"
+	cfg.Admin.EnablePprof = true
+	return cfg
+}
+
+func TestRouterBehavior(t *testing.T) {
+	tests := []struct {
+		name           string
+		modifyConfig   func(*config.Config)
+		path           string
+		expectedStatus int
+	}{
+		{name: "serves health", path: "/healthz", expectedStatus: http.StatusNoContent},
+		{name: "serves metrics", path: "/metrics", expectedStatus: http.StatusOK},
+		{name: "serves pprof index", path: "/debug/pprof/", expectedStatus: http.StatusOK},
+		{name: "serves named pprof profile", path: "/debug/pprof/goroutine?debug=1", expectedStatus: http.StatusOK},
+		{name: "serves admin info", path: "/admin/api/info", expectedStatus: http.StatusOK},
+		{name: "serves dotenv sources", path: "/admin/api/env", expectedStatus: http.StatusOK},
+		{
+			name:           "omits pprof when disabled",
+			modifyConfig:   func(cfg *config.Config) { cfg.Admin.EnablePprof = false },
+			path:           "/debug/pprof/",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "omits metrics when disabled",
+			modifyConfig:   func(cfg *config.Config) { cfg.Metrics.Enabled = false },
+			path:           "/metrics",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createTestConfig()
+			if tt.modifyConfig != nil {
+				tt.modifyConfig(cfg)
+			}
+			router := NewServer(cfg).Router()
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestInfoHandlerBehavior(t *testing.T) {
+	server := NewServer(createTestConfig())
+
+	w := httptest.NewRecorder()
+	server.InfoHandler(w, httptest.NewRequest("GET", "/admin/api/info", nil))
+
+	var info map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("failed to parse info: %v", err)
+	}
+	if info["environment"] != "test" {
+		t.Errorf("expected environment 'test', got %v", info["environment"])
+	}
+	if _, ok := info["uptimeSeconds"]; !ok {
+		t.Error("info should include uptimeSeconds")
+	}
+}
+
+func TestSecurityPolicyBehavior(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Security.AllowedAPIEndpoints = []string{"https://api.anthropic.com"}
+	cfg.Security.ReferrerPolicy = "no-referrer"
+	cfg.Security.ContentTypeOptions = "nosniff"
+	cfg.Security.FrameOptions = "DENY"
+	router := NewServer(cfg).Router()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/api/security-policy", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	type profile struct {
+		Headers  map[string]string   `json:"headers"`
+		CSP      map[string][]string `json:"csp"`
+		Findings []struct {
+			Severity string `json:"severity"`
+			Header   string `json:"header"`
+		} `json:"findings"`
+	}
+	var body struct {
+		Page profile `json:"page"`
+		API  profile `json:"api"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.Page.Headers["X-Frame-Options"] != "DENY" || !strings.Contains(body.Page.Headers["Content-Security-Policy"], "connect-src 'self' https://api.anthropic.com") {
+		t.Errorf("expected the rendered page headers, got %v", body.Page.Headers)
+	}
+	if got := body.Page.CSP["frame-ancestors"]; len(got) != 1 || got[0] != "'none'" {
+		t.Errorf("expected the CSP by directive, got %v", body.Page.CSP)
+	}
+	if body.API.Headers["Cache-Control"] != "no-store" || body.API.Headers["Content-Security-Policy"] != "" {
+		t.Errorf("expected the API profile without a CSP, got %v", body.API.Headers)
+	}
+	for name, p := range map[string]profile{"page": body.Page, "api": body.API} {
+		var hsts bool
+		for _, finding := range p.Findings {
+			if finding.Severity == "high" {
+				t.Errorf("expected no high severity %s findings for the defaults, got %+v", name, finding)
+			}
+			hsts = hsts || finding.Header == "Strict-Transport-Security"
+		}
+		if !hsts {
+			t.Errorf("expected missing HSTS outside development to be flagged for %s, got %+v", name, p.Findings)
+		}
+	}
+}
+
+func TestReportsNeedToken(t *testing.T) {
+	dir := t.TempDir()
+	tenantsFile := filepath.Join(dir, "tenants.json")
+	os.WriteFile(tenantsFile, []byte(`{"tenants":[{"id":"acme"}]}`), 0o600)
+	reg, err := tenants.Load(config.TenantsConfig{File: tenantsFile, Mode: "header", Header: "X-Manto-Tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	experimentFile := filepath.Join(dir, "experiment.json")
+	os.WriteFile(experimentFile, []byte(`{"name":"tone","variants":[{"name":"a","weight":1},{"name":"b","weight":1}]}`), 0o600)
+	experiment, err := experiments.Load(experimentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := conversations.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := createTestConfig()
+	cfg.Admin.Token = strings.Repeat("t", 32)
+	router := NewServer(cfg).WithTenants(reg).WithExperiment(experiment).WithFeedback(store).Router()
+
+	for _, path := range []string{"/admin/api/tenants", "/admin/api/experiments", "/admin/api/feedback"} {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("expected 401 without the admin token, got %d", w.Code)
+			}
+
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Authorization", "Bearer "+cfg.Admin.Token)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("expected 200 with the admin token, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDLPHandlerBehavior(t *testing.T) {
+	log, _ := dlp.Open(config.DLPConfig{Enabled: true, MaxEntries: 10})
+	log.Record(moderation.Event{
+		Time:        time.Now().UTC(),
+		Stage:       moderation.StageInput,
+		Action:      moderation.ActionFlag,
+		Checkers:    []string{"pii"},
+		Rules:       []string{"email", "phone"},
+		Text:        "mail me",
+		Attribution: map[string]string{"key": "abc123", "session": "s1", "model": "haiku"},
+	})
+	cfg := createTestConfig()
+	cfg.Admin.Token = strings.Repeat("t", 32)
+	router := NewServer(cfg).WithDLP(log).Router()
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "exports JSON", expectedStatus: http.StatusOK, expectedBody: `"rules":["email","phone"]`},
+		{name: "exports CSV", query: "?format=csv", expectedStatus: http.StatusOK, expectedBody: "input,flag,pii,\"email,phone\",sha256:"},
+		{name: "filters by since", query: "?since=2999-01-01", expectedStatus: http.StatusOK, expectedBody: `{"entries":[]}`},
+		{name: "rejects bad since", query: "?since=yesterday", expectedStatus: http.StatusBadRequest},
+		{name: "rejects unknown format", query: "?format=xml", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/api/dlp"+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer "+cfg.Admin.Token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.expectedBody) || strings.Contains(w.Body.String(), "mail me") {
+				t.Errorf("expected body to contain %q and no content, got %s", tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/api/dlp", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the admin token, got %d", w.Code)
+	}
+}
+
+func TestExchangesHandlerBehavior(t *testing.T) {
+	recorder := capture.New(config.DebugConfig{CaptureEnabled: true, CaptureSize: 10, CaptureMaxBody: 100}, "x-api-key")
+	for _, status := range []int{200, 400} {
+		req := httptest.NewRequest("POST", "https://api.example.com/v1/messages", strings.NewReader(`{}`))
+		req.Header.Set("x-api-key", "sk-ant-secret")
+		resp, _ := recorder.Do(req, func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+		})
+		resp.Body.Close()
+	}
+	cfg := createTestConfig()
+	cfg.Admin.Token = strings.Repeat("t", 32)
+	router := NewServer(cfg).WithCapture(recorder).Router()
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []int
+	}{
+		{name: "lists newest first", expected: []int{400, 200}},
+		{name: "filters errors", query: "?status=error", expected: []int{400}},
+		{name: "filters by status", query: "?status=200", expected: []int{200}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/api/debug/exchanges"+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer "+cfg.Admin.Token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var body struct {
+				Exchanges []capture.Exchange `json:"exchanges"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			var statuses []int
+			for _, x := range body.Exchanges {
+				statuses = append(statuses, x.Status)
+			}
+			if fmt.Sprint(statuses) != fmt.Sprint(tt.expected) || strings.Contains(w.Body.String(), "sk-ant-secret") {
+				t.Errorf("expected statuses %v without the key, got %s", tt.expected, w.Body.String())
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/api/debug/exchanges", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the admin token, got %d", w.Code)
+	}
+}
+
+func TestAnthropicProxyBehavior(t *testing.T) {
+	var upstream *http.Request
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer fake.Close()
+
+	cfg := createTestConfig()
+	cfg.Anthropic.BaseURL = fake.URL
+	cfg.Anthropic.APIVersion = "2023-06-01"
+	cfg.Admin.Token = strings.Repeat("t", 32)
+	cfg.Admin.AnthropicAdminKey = "sk-ant-admin01-secret"
+	router := NewServer(cfg).Router()
+
+	tests := []struct {
+		name           string
+		path           string
+		token          string
+		expectedStatus int
+		expectedPath   string
+	}{
+		{name: "lists workspaces", path: "/admin/api/anthropic/workspaces?limit=5", token: cfg.Admin.Token, expectedStatus: http.StatusOK, expectedPath: "/v1/organizations/workspaces"},
+		{name: "reads a single key", path: "/admin/api/anthropic/api_keys/apikey_01", token: cfg.Admin.Token, expectedStatus: http.StatusOK, expectedPath: "/v1/organizations/api_keys/apikey_01"},
+		{name: "rejects missing token", path: "/admin/api/anthropic/workspaces", expectedStatus: http.StatusUnauthorized},
+		{name: "rejects wrong token", path: "/admin/api/anthropic/workspaces", token: "wrong", expectedStatus: http.StatusUnauthorized},
+		{name: "rejects unknown resources", path: "/admin/api/anthropic/invites", token: cfg.Admin.Token, expectedStatus: http.StatusNotFound},
+		{name: "rejects encoded traversal", path: "/admin/api/anthropic/workspaces/%2e%2e/%2e%2e/messages", token: cfg.Admin.Token, expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream = nil
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedPath == "" {
+				if upstream != nil {
+					t.Error("rejected requests must not reach the Admin API")
+				}
+				return
+			}
+			if upstream.URL.Path != tt.expectedPath || upstream.Header.Get("x-api-key") != cfg.Admin.AnthropicAdminKey {
+				t.Errorf("unexpected upstream request %s %v", upstream.URL.Path, upstream.Header)
+			}
+			if upstream.Header.Get("Authorization") != "" {
+				t.Error("the admin token must not be forwarded")
+			}
+		})
+	}
+
+	t.Run("not mounted without an admin key", func(t *testing.T) {
+		cfg := createTestConfig()
+		w := httptest.NewRecorder()
+		NewServer(cfg).Router().ServeHTTP(w, httptest.NewRequest("GET", "/admin/api/anthropic/workspaces", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", w.Code)
+		}
+	})
+}
+
+func TestBackupRestoreBehavior(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Admin.Token = strings.Repeat("t", 32)
+	path := filepath.Join(t.TempDir(), "conversations.json")
+	store, _ := conversations.NewStore(path)
+	store.Create(&conversations.Conversation{ID: "conv_1", Owner: "alice", Title: "kept"})
+	router := NewServer(cfg).WithBackup([]backup.Source{{Name: "conversations", Path: path, Replace: store.Replace}}).Router()
+	serve := func(method, target, token string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("GET", "/admin/api/backup", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected backups to need the admin token, got %d", w.Code)
+	}
+	w := serve("GET", "/admin/api/backup", cfg.Admin.Token, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zstd" {
+		t.Fatalf("expected a backup download, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	archive := w.Body.Bytes()
+
+	store.Delete("alice", "conv_1")
+	if w := serve("POST", "/admin/api/restore?verify=true", cfg.Admin.Token, bytes.NewReader(archive)); w.Code != http.StatusOK {
+		t.Fatalf("expected the backup to verify, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := store.Get("alice", "conv_1"); err == nil {
+		t.Fatal("expected verifying to leave the store alone")
+	}
+	if w := serve("POST", "/admin/api/restore", cfg.Admin.Token, bytes.NewReader(archive[:len(archive)/2])); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a truncated backup to be refused, got %d", w.Code)
+	}
+	w = serve("POST", "/admin/api/restore", cfg.Admin.Token, bytes.NewReader(archive))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"conversations"`) {
+		t.Fatalf("expected the restored manifest, got %d: %s", w.Code, w.Body.String())
+	}
+	if c, err := store.Get("alice", "conv_1"); err != nil || c.Title != "kept" {
+		t.Errorf("expected the running store to have the conversation back, got %v (%v)", c, err)
+	}
+}
+
+func TestOverridesBehavior(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Admin.Token = strings.Repeat("t", 32)
+	store, _ := overrides.NewStore("")
+	router := NewServer(cfg).WithOverrides(store).Router()
+	serve := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		token          string
+		body           string
+		expectedStatus int
+	}{
+		{name: "needs the admin token", method: "PUT", target: "/admin/api/overrides/default", body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{name: "sets the deployment's override", method: "PUT", target: "/admin/api/overrides/default", token: cfg.Admin.Token, body: `{"system":"Be brief.","features":{"batch":false}}`, expectedStatus: http.StatusOK},
+		{name: "refuses unknown features", method: "PUT", target: "/admin/api/overrides/default", token: cfg.Admin.Token, body: `{"features":{"teleport":true}}`, expectedStatus: http.StatusBadRequest},
+		{name: "refuses unknown tenants", method: "PUT", target: "/admin/api/overrides/acme", token: cfg.Admin.Token, body: `{}`, expectedStatus: http.StatusNotFound},
+		{name: "lists overrides", method: "GET", target: "/admin/api/overrides", token: cfg.Admin.Token, expectedStatus: http.StatusOK},
+		{name: "deletes the override", method: "DELETE", target: "/admin/api/overrides/default", token: cfg.Admin.Token, expectedStatus: http.StatusNoContent},
+		{name: "has nothing left to delete", method: "DELETE", target: "/admin/api/overrides/default", token: cfg.Admin.Token, expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.target, tt.token, tt.body)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.method == "GET" && !strings.Contains(w.Body.String(), `"system":"Be brief."`) {
+				t.Errorf("expected the override to be listed, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAnnouncementsAdminBehavior(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Admin.Token = strings.Repeat("t", 32)
+	store, _ := announcements.NewStore("")
+	router := NewServer(cfg).WithAnnouncements(store).Router()
+	serve := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("POST", "/admin/api/announcements", "", `{"message":"Hi"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected announcements to need the admin token, got %d", w.Code)
+	}
+	if w := serve("POST", "/admin/api/announcements", cfg.Admin.Token, `{"message":"Hi","tenant":"acme"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown tenant to be refused, got %d", w.Code)
+	}
+	if w := serve("POST", "/admin/api/announcements", cfg.Admin.Token, `{"message":"Hi","level":"critical"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid level to be refused, got %d", w.Code)
+	}
+	w := serve("POST", "/admin/api/announcements", cfg.Admin.Token, `{"message":"Maintenance on Saturday","startsAt":"2026-03-07T08:00:00Z","endsAt":"2026-03-07T12:00:00Z"}`)
+	var created announcements.Announcement
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil || created.ID == "" {
+		t.Fatalf("expected the announcement to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve("GET", "/admin/api/announcements", cfg.Admin.Token, ""); !strings.Contains(w.Body.String(), created.ID) {
+		t.Errorf("expected the announcement to be listed, got %s", w.Body.String())
+	}
+	if w := serve("DELETE", "/admin/api/announcements/"+created.ID, cfg.Admin.Token, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected the announcement to be deleted, got %d", w.Code)
+	}
+	if w := serve("DELETE", "/admin/api/announcements/"+created.ID, cfg.Admin.Token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected nothing left to delete, got %d", w.Code)
+	}
+}
+
+func TestReplayAdminBehavior(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Admin.Token = strings.Repeat("t", 32)
+	store, _ := replay.NewStore(config.DebugConfig{ReplayEnabled: true, ReplaySize: 10})
+	store.Record(&services.MessageRequest{Model: "claude-haiku", MaxTokens: 16, Messages: []services.Message{{Role: "user", Content: "Hello"}}},
+		"sk-ant-secret", "key_1", "", fmt.Errorf("API error (529): overloaded"))
+	failure := store.List()[0]
+	router := NewServer(cfg).WithReplay(store, services.NewAnthropicService(cfg)).Router()
+	serve := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("GET", "/admin/api/debug/failures", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected failures to need the admin token, got %d", w.Code)
+	}
+	if w := serve("GET", "/admin/api/debug/failures", cfg.Admin.Token, ""); !strings.Contains(w.Body.String(), failure.ID) {
+		t.Errorf("expected the failure to be listed, got %s", w.Body.String())
+	}
+
+	w := serve("POST", "/admin/api/debug/failures/"+failure.ID+"/replay", cfg.Admin.Token, `{"target":"mock"}`)
+	var result struct {
+		Target   string                    `json:"target"`
+		OK       bool                      `json:"ok"`
+		Response *services.MessageResponse `json:"response"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &result) != nil || !result.OK || result.Response.Model != "claude-haiku" {
+		t.Fatalf("expected the mock provider to answer the replay, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve("POST", "/admin/api/debug/failures/"+failure.ID+"/replay", cfg.Admin.Token, `{"target":"live"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a live replay without a key to be refused, got %d", w.Code)
+	}
+	if w := serve("POST", "/admin/api/debug/failures/"+failure.ID+"/replay", cfg.Admin.Token, `{"target":"staging"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown target to be refused, got %d", w.Code)
+	}
+	if w := serve("POST", "/admin/api/debug/failures/fail_missing/replay", cfg.Admin.Token, `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown failure to answer 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/manto/manto-web/internal/announcements"
+)
+
+// maxAnnouncementSize bounds announcement request bodies.
+const maxAnnouncementSize = 16 << 10
+
+// WithAnnouncements manages store's announcements at
+// /admin/api/announcements, behind the admin token.
+func (s *Server) WithAnnouncements(store *announcements.Store) *Server {
+	s.announcements = store
+	return s
+}
+
+// AnnouncementsHandler lists every announcement, including scheduled and
+// expired ones.
+func (s *Server) AnnouncementsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"announcements": s.announcements.List()})
+}
+
+// CreateAnnouncementHandler schedules the announcement in the request body.
+func (s *Server) CreateAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	var a announcements.Announcement
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnouncementSize)).Decode(&a); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if err := a.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if a.Tenant != "" && !s.tenants.Has(a.Tenant) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown tenant: " + a.Tenant})
+		return
+	}
+	created, err := s.announcements.Add(a, time.Now().UTC())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// DeleteAnnouncementHandler removes announcement {id}.
+func (s *Server) DeleteAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.announcements.Delete(chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, announcements.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Announcement not found"})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// anthropicAdminResources are the Admin API collections that may be read
+// through the proxy. Everything is read-only: creating keys or changing
+// workspaces should happen in the Anthropic console, not through Manto.
+var anthropicAdminResources = map[string]bool{
+	"workspaces":   true,
+	"api_keys":     true,
+	"users":        true,
+	"usage_report": true,
+	"cost_report":  true,
+}
+
+// requireToken rejects requests without the configured admin bearer token.
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.config.Admin.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Admin.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="manto-admin"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Admin token required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AnthropicProxyHandler forwards GET /admin/api/anthropic/{resource}/... to
+// /v1/organizations/{resource}/... on the Anthropic API using the org admin
+// key. Only the path and query are forwarded; the caller's headers, including
+// its admin token, never leave Manto.
+func (s *Server) AnthropicProxyHandler(w http.ResponseWriter, r *http.Request) {
+	path := chi.URLParam(r, "*")
+	resource, _, _ := strings.Cut(path, "/")
+	if !anthropicAdminResources[resource] || !safeAdminPath(path) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Unknown Admin API resource"})
+		return
+	}
+
+	target := s.config.Anthropic.BaseURL + "/v1/organizations/" + path
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(r.Context(), "GET", target, nil)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
+		return
+	}
+	req.Header.Set("x-api-key", s.config.Admin.AnthropicAdminKey)
+	req.Header.Set("anthropic-version", s.config.Anthropic.APIVersion)
+	req.Header.Set("User-Agent", s.config.Anthropic.UserAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "Admin API unreachable"})
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// safeAdminPath allows only the characters Admin API resource names and IDs
+// use, so encoded or dot segments cannot escape /v1/organizations.
+func safeAdminPath(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+		for _, c := range segment {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
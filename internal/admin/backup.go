@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/manto/manto-web/internal/backup"
+)
+
+// maxRestoreSize bounds uploaded backups.
+const maxRestoreSize = 1 << 30
+
+// WithBackup serves backups of sources at /admin/api/backup and restores
+// them at /admin/api/restore, both behind the admin token.
+func (s *Server) WithBackup(sources []backup.Source) *Server {
+	s.backups = sources
+	return s
+}
+
+// BackupHandler streams a backup of the data files as a download.
+func (s *Server) BackupHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/zstd")
+	w.Header().Set("Content-Disposition", `attachment; filename="manto-backup-`+now.UTC().Format("20060102T150405Z")+`.tar.zst"`)
+	w.Header().Set("Cache-Control", "no-store")
+	// Once the archive has started there is no way to report a failure but
+	// to cut it short, which the unfinished zstd frame makes detectable.
+	if _, err := backup.Write(w, s.backups, now); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// RestoreHandler restores the backup in the request body into the running
+// server and answers its manifest. ?verify=true only checks the backup.
+func (s *Server) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	manifest, files, err := backup.Read(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if verify, _ := strconv.ParseBool(r.URL.Query().Get("verify")); !verify {
+		if err := backup.Apply(manifest, files, s.backups); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, manifest)
+}
@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/manto/manto-web/internal/overrides"
+)
+
+// maxOverrideSize bounds override request bodies.
+const maxOverrideSize = 64 << 10
+
+// WithOverrides manages store's runtime overrides at /admin/api/overrides,
+// behind the admin token.
+func (s *Server) WithOverrides(store *overrides.Store) *Server {
+	s.overrides = store
+	return s
+}
+
+// OverridesHandler lists every scope's override and the features an
+// override can switch off.
+func (s *Server) OverridesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"overrides": s.overrides.All(),
+		"features":  overrides.Features,
+	})
+}
+
+// SetOverrideHandler replaces the override for {scope}: "default" for the
+// whole deployment, or a tenant ID. It applies to the next request.
+func (s *Server) SetOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.overrideScope(w, r)
+	if !ok {
+		return
+	}
+	var o overrides.Override
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOverrideSize)).Decode(&o); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if err := o.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	o.UpdatedAt = time.Now().UTC()
+	if err := s.overrides.Set(scope, o); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// DeleteOverrideHandler removes the override for {scope}, restoring the
+// settings from the environment and tenant definitions.
+func (s *Server) DeleteOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.overrideScope(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := s.overrides.Delete(scope)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "No override for " + scope})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// overrideScope returns the {scope} URL parameter, answering 404 unless it
+// is the default scope or names a tenant.
+func (s *Server) overrideScope(w http.ResponseWriter, r *http.Request) (string, bool) {
+	scope := chi.URLParam(r, "scope")
+	if scope != overrides.DefaultScope && !s.tenants.Has(scope) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Unknown scope: " + scope})
+		return "", false
+	}
+	return scope, true
+}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/manto/manto-web/internal/loadtest"
+	"github.com/manto/manto-web/internal/replay"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// maxReplaySize bounds replay request bodies.
+const maxReplaySize = 4 << 10
+
+// WithReplay lists store's failed requests at /admin/api/debug/failures and
+// replays them, behind the admin token. Live replays go through service, so
+// they are captured like any other exchange.
+func (s *Server) WithReplay(store *replay.Store, service *services.AnthropicService) *Server {
+	s.failures = store
+	s.anthropic = service
+	return s
+}
+
+// FailuresHandler lists the kept failed requests, newest first.
+func (s *Server) FailuresHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"failures": s.failures.List()})
+}
+
+// ReplayHandler sends failed request {id} again, to the mock provider or,
+// with "target": "live", to the configured one using the key in the body or
+// ANTHROPIC_API_KEY.
+func (s *Server) ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	failure, err := s.failures.Get(chi.URLParam(r, "id"))
+	if errors.Is(err, replay.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Failure not found"})
+		return
+	}
+	var body struct {
+		Target string `json:"target"`
+		APIKey string `json:"apiKey"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReplaySize)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if body.Target == "" {
+		body.Target = "mock"
+	}
+	request, err := failure.MessageRequest()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	service, apiKey := s.anthropic, body.APIKey
+	switch body.Target {
+	case "live":
+		if apiKey == "" {
+			apiKey = s.config.Anthropic.APIKey
+		}
+		if apiKey == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "An apiKey is required without ANTHROPIC_API_KEY"})
+			return
+		}
+	case "mock":
+		mock, err := startMock()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		defer mock.Close()
+		mockCfg := *s.config
+		mockCfg.Anthropic.BaseURL = "http://" + mock.Addr
+		service, apiKey = services.NewAnthropicService(&mockCfg), "replay"
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid target: %s (must be mock or live)", body.Target)})
+		return
+	}
+
+	start := time.Now()
+	response, err := service.SendMessage(r.Context(), apiKey, request)
+	result := map[string]interface{}{
+		"id":         failure.ID,
+		"target":     body.Target,
+		"ok":         err == nil,
+		"durationMs": time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	} else {
+		result["response"] = response
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// mockServer is a loopback listener answering with loadtest's mock provider.
+type mockServer struct {
+	Addr string
+	srv  *http.Server
+}
+
+func startMock() (*mockServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	srv := &http.Server{Handler: loadtest.MockProvider(0)}
+	go srv.Serve(listener)
+	return &mockServer{Addr: listener.Addr().String(), srv: srv}, nil
+}
+
+func (m *mockServer) Close() {
+	m.srv.Close()
+}
@@ -0,0 +1,62 @@
+package analytics
+
+import (
+	"errors"
+
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+// AllowedEvents is the complete set of UI events the server will count. Events
+// carry no identifiers or content, only the fact that they happened.
+var AllowedEvents = []string{
+	"message_sent",
+	"model_changed",
+}
+
+var ErrUnknownEvent = errors.New("unknown event")
+
+// Collector aggregates anonymous UI events into in-memory counters.
+type Collector struct {
+	enabled bool
+	allowed map[string]bool
+	events  *metrics.CounterVec
+}
+
+func NewCollector(enabled bool, registry *metrics.Registry) *Collector {
+	allowed := make(map[string]bool, len(AllowedEvents))
+	for _, event := range AllowedEvents {
+		allowed[event] = true
+	}
+
+	return &Collector{
+		enabled: enabled,
+		allowed: allowed,
+		events:  registry.Counter("manto_ui_events_total", "Anonymous UI events reported by clients.", "event"),
+	}
+}
+
+func (c *Collector) Enabled() bool {
+	return c.enabled
+}
+
+// Record counts an event. When analytics are disabled events are silently
+// dropped so clients don't need to know the deployment's setting.
+func (c *Collector) Record(event string) error {
+	if !c.allowed[event] {
+		return ErrUnknownEvent
+	}
+	if !c.enabled {
+		return nil
+	}
+	c.events.Inc(event)
+	return nil
+}
+
+// Counts returns the current total for every allowed event.
+func (c *Collector) Counts() map[string]int {
+	counts := make(map[string]int, len(AllowedEvents))
+	for _, event := range AllowedEvents {
+		counts[event] = int(c.events.Value(event))
+	}
+	return counts
+}
@@ -0,0 +1,44 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+func TestCollectorBehavior(t *testing.T) {
+	t.Run("counts allowed events", func(t *testing.T) {
+		collector := NewCollector(true, metrics.NewRegistry())
+
+		for i := 0; i < 3; i++ {
+			if err := collector.Record("message_sent"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		collector.Record("model_changed")
+
+		counts := collector.Counts()
+		if counts["message_sent"] != 3 || counts["model_changed"] != 1 {
+			t.Errorf("unexpected counts: %v", counts)
+		}
+	})
+
+	t.Run("rejects events outside the allow-list", func(t *testing.T) {
+		collector := NewCollector(true, metrics.NewRegistry())
+
+		if err := collector.Record("page_view"); err != ErrUnknownEvent {
+			t.Errorf("expected ErrUnknownEvent, got %v", err)
+		}
+	})
+
+	t.Run("drops events when opted out", func(t *testing.T) {
+		collector := NewCollector(false, metrics.NewRegistry())
+
+		if err := collector.Record("message_sent"); err != nil {
+			t.Errorf("opted-out collector should accept silently, got %v", err)
+		}
+		if counts := collector.Counts(); counts["message_sent"] != 0 {
+			t.Errorf("opted-out collector should not count, got %v", counts)
+		}
+	})
+}
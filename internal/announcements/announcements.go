@@ -0,0 +1,199 @@
+// Package announcements keeps the banners operators schedule for the UI,
+// such as planned maintenance or a newly available model. Each is shown
+// between its start and end times, to every user or to one tenant's.
+package announcements
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/atomicfile"
+)
+
+// maxMessageLength bounds an announcement's text, which is shown in a
+// single banner.
+const maxMessageLength = 500
+
+// ErrNotFound is returned for an announcement that doesn't exist.
+var ErrNotFound = errors.New("announcement not found")
+
+// Announcement is one scheduled banner. A zero StartsAt shows it at once
+// and a zero EndsAt until it is deleted; an empty Tenant shows it to
+// everyone.
+type Announcement struct {
+	ID        string    `json:"id"`
+	Message   string    `json:"message"`
+	Level     string    `json:"level"`
+	Tenant    string    `json:"tenant,omitempty"`
+	StartsAt  time.Time `json:"startsAt,omitzero"`
+	EndsAt    time.Time `json:"endsAt,omitzero"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Validate checks an announcement before it is stored, defaulting its level
+// to info.
+func (a *Announcement) Validate() error {
+	if a.Message == "" {
+		return errors.New("message is required")
+	}
+	if len(a.Message) > maxMessageLength {
+		return fmt.Errorf("message is too long (at most %d bytes)", maxMessageLength)
+	}
+	if a.Level == "" {
+		a.Level = "info"
+	}
+	if a.Level != "info" && a.Level != "warning" {
+		return fmt.Errorf("invalid level: %s (must be info or warning)", a.Level)
+	}
+	if !a.StartsAt.IsZero() && !a.EndsAt.IsZero() && !a.EndsAt.After(a.StartsAt) {
+		return errors.New("endsAt must be after startsAt")
+	}
+	return nil
+}
+
+// Active reports whether the announcement is shown to tenant at now.
+func (a *Announcement) Active(now time.Time, tenant string) bool {
+	return (a.Tenant == "" || a.Tenant == tenant) &&
+		!now.Before(a.StartsAt) &&
+		(a.EndsAt.IsZero() || now.Before(a.EndsAt))
+}
+
+// Store keeps announcements in memory, optionally snapshotting them to a
+// JSON file after every change. The file can also be written by hand before
+// starting the server.
+type Store struct {
+	path string
+
+	mu            sync.RWMutex
+	announcements []*Announcement
+}
+
+// NewStore opens a store backed by path. An empty path keeps announcements
+// in memory only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read announcements: %w", err)
+	}
+	if s.announcements, err = parseSnapshot(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseSnapshot(data []byte) ([]*Announcement, error) {
+	var snap struct {
+		Announcements []*Announcement `json:"announcements"`
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse announcements: %w", err)
+	}
+	for _, a := range snap.Announcements {
+		if err := a.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid announcement %s: %w", a.ID, err)
+		}
+		if a.ID == "" {
+			a.ID = newID()
+		}
+	}
+	return snap.Announcements, nil
+}
+
+// Replace swaps the store's contents for the snapshot in data, as written to
+// its file, and persists them.
+func (s *Store) Replace(data []byte) error {
+	announcements, err := parseSnapshot(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.announcements = announcements
+	return s.persist()
+}
+
+// List returns every announcement, including past and future ones, by start
+// time.
+func (s *Store) List() []Announcement {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Announcement, 0, len(s.announcements))
+	for _, a := range s.announcements {
+		list = append(list, *a)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].StartsAt.Before(list[j].StartsAt) })
+	return list
+}
+
+// Active returns the announcements shown to tenant at now, by start time. A
+// nil store has none.
+func (s *Store) Active(now time.Time, tenant string) []Announcement {
+	active := []Announcement{}
+	if s == nil {
+		return active
+	}
+	for _, a := range s.List() {
+		if a.Active(now, tenant) {
+			active = append(active, a)
+		}
+	}
+	return active
+}
+
+// Add stores a validated announcement, assigning its ID.
+func (s *Store) Add(a Announcement, now time.Time) (Announcement, error) {
+	a.ID = newID()
+	a.CreatedAt = now
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.announcements = append(s.announcements, &a)
+	return a, s.persist()
+}
+
+// Delete removes announcement id.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, a := range s.announcements {
+		if a.ID == id {
+			s.announcements = append(s.announcements[:i], s.announcements[i+1:]...)
+			return s.persist()
+		}
+	}
+	return ErrNotFound
+}
+
+// persist writes the snapshot atomically. Callers must hold s.mu.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(map[string][]*Announcement{"announcements": s.announcements}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode announcements: %w", err)
+	}
+	if err := atomicfile.Write(s.path, data); err != nil {
+		return fmt.Errorf("failed to write announcements: %w", err)
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "ann_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package announcements
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateBehavior(t *testing.T) {
+	start := time.Date(2026, 3, 7, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		announcement Announcement
+		err          string
+	}{
+		{name: "valid", announcement: Announcement{Message: "Maintenance on Saturday", StartsAt: start, EndsAt: start.Add(time.Hour)}},
+		{name: "no message", announcement: Announcement{}, err: "required"},
+		{name: "long message", announcement: Announcement{Message: strings.Repeat("a", 501)}, err: "too long"},
+		{name: "unknown level", announcement: Announcement{Message: "Hi", Level: "critical"}, err: "level"},
+		{name: "ends before it starts", announcement: Announcement{Message: "Hi", StartsAt: start, EndsAt: start}, err: "after"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.announcement.Validate()
+			if tt.err == "" {
+				if err != nil || tt.announcement.Level != "info" {
+					t.Errorf("expected a valid info announcement, got %v (%s)", err, tt.announcement.Level)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error mentioning %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestStoreBehavior(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "announcements.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC)
+	store.Add(Announcement{Message: "Maintenance on Saturday", Level: "warning", EndsAt: now.Add(24 * time.Hour)}, now)
+	store.Add(Announcement{Message: "New model available", Level: "info", StartsAt: now.Add(time.Hour)}, now)
+	store.Add(Announcement{Message: "Acme only", Level: "info", Tenant: "acme"}, now)
+
+	if active := store.Active(now, ""); len(active) != 1 || active[0].Message != "Maintenance on Saturday" {
+		t.Errorf("expected only the current announcement, got %+v", active)
+	}
+	if active := store.Active(now.Add(2*time.Hour), "acme"); len(active) != 3 {
+		t.Errorf("expected acme to see all three once the second starts, got %+v", active)
+	}
+	if active := store.Active(now.Add(48*time.Hour), ""); len(active) != 1 || active[0].Message != "New model available" {
+		t.Errorf("expected the maintenance banner to have ended, got %+v", active)
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := reopened.List()
+	if len(list) != 3 {
+		t.Fatalf("expected announcements to survive a restart, got %+v", list)
+	}
+	if err := reopened.Delete(list[0].ID); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := reopened.Delete(list[0].ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	var none *Store
+	if active := none.Active(now, ""); active == nil || len(active) != 0 {
+		t.Errorf("expected an empty list from a nil store, got %v", active)
+	}
+}
@@ -0,0 +1,195 @@
+// Package archive moves conversations nobody has touched for a while out of
+// the conversation store into an S3-compatible bucket, and brings them back
+// on demand. Each run writes one JSON lines object holding the conversations
+// it archived, optionally sealed with AES-256-GCM; the store keeps a small
+// record of each so it can still be listed and rehydrated.
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/conversations"
+)
+
+// record is one line of an archive object. The owner is kept, as it is
+// hidden from the conversation's own JSON.
+type record struct {
+	Owner string `json:"owner"`
+	*conversations.Conversation
+}
+
+// Archiver archives conversations of store last updated more than after ago.
+type Archiver struct {
+	store    *conversations.Store
+	bucket   *Bucket
+	prefix   string
+	after    time.Duration
+	interval time.Duration
+	timeout  time.Duration
+	aead     cipher.AEAD
+	now      func() time.Time
+}
+
+// New creates an archiver for store from cfg, which must have been
+// validated.
+func New(cfg config.ArchiveConfig, store *conversations.Store) (*Archiver, error) {
+	a := &Archiver{
+		store:    store,
+		bucket:   NewBucket(cfg.Endpoint, cfg.Bucket, cfg.Region, cfg.AccessKeyID, cfg.SecretAccessKey, &http.Client{Timeout: cfg.Timeout.Duration}),
+		prefix:   cfg.Prefix,
+		after:    cfg.After.Duration,
+		interval: cfg.Interval.Duration,
+		timeout:  cfg.Timeout.Duration,
+		now:      time.Now,
+	}
+	if cfg.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive encryption key: %w", err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive encryption key: %w", err)
+		}
+		if a.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("invalid archive encryption key: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// Run archives every stale conversation into one new object and returns
+// how many were removed from the store. Nothing is removed unless the
+// object was written.
+func (a *Archiver) Run(ctx context.Context) (int, error) {
+	now := a.now()
+	stale := a.store.Stale(now.Add(-a.after))
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range stale {
+		if err := enc.Encode(record{Owner: c.Owner, Conversation: c}); err != nil {
+			return 0, fmt.Errorf("failed to encode conversation %s: %w", c.ID, err)
+		}
+	}
+
+	object := a.prefix + now.UTC().Format("2006/01/02/150405-") + randomSuffix() + ".jsonl"
+	data, contentType := buf.Bytes(), "application/x-ndjson"
+	if a.aead != nil {
+		object += ".enc"
+		data, contentType = a.seal(object, data), "application/octet-stream"
+	}
+	if err := a.bucket.Put(ctx, object, contentType, data); err != nil {
+		return 0, fmt.Errorf("failed to upload archive %s: %w", object, err)
+	}
+
+	archived, err := a.store.Archive(stale, object, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove archived conversations: %w", err)
+	}
+	return len(archived), nil
+}
+
+// Rehydrate fetches owner's archived conversation id from its archive
+// object and puts it back in the store.
+func (a *Archiver) Rehydrate(ctx context.Context, owner, id string) (*conversations.Conversation, error) {
+	archived, err := a.store.GetArchived(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := a.bucket.Get(ctx, archived.Object)
+	if err != nil {
+		return nil, err
+	}
+	if a.aead != nil {
+		if data, err = a.open(archived.Object, data); err != nil {
+			return nil, err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var rec record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("conversation %s is missing from archive %s", id, archived.Object)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %w", archived.Object, err)
+		}
+		if rec.Conversation == nil || rec.ID != id || rec.Owner != owner {
+			continue
+		}
+		rec.Conversation.Owner = rec.Owner
+		if err := a.store.Rehydrate(rec.Conversation, a.now()); err != nil {
+			return nil, err
+		}
+		return a.store.Get(owner, id)
+	}
+}
+
+// Start runs the archival job every interval until stop is closed.
+func (a *Archiver) Start(stop <-chan struct{}) {
+	ticker := time.NewTicker(a.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+				n, err := a.Run(ctx)
+				cancel()
+				if err != nil {
+					slog.Error("conversation archival failed", slog.String("error", err.Error()))
+				} else if n > 0 {
+					slog.Info("archived conversations", slog.Int("count", n))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// seal encrypts data as nonce followed by ciphertext. The object name is
+// authenticated too, so an object can't be passed off under another name.
+func (a *Archiver) seal(object string, data []byte) []byte {
+	nonce := make([]byte, a.aead.NonceSize())
+	rand.Read(nonce)
+	return a.aead.Seal(nonce, nonce, data, []byte(object))
+}
+
+func (a *Archiver) open(object string, data []byte) ([]byte, error) {
+	if len(data) < a.aead.NonceSize() {
+		return nil, fmt.Errorf("archive %s is too short to be encrypted", object)
+	}
+	nonce, sealed := data[:a.aead.NonceSize()], data[a.aead.NonceSize():]
+	plain, err := a.aead.Open(nil, nonce, sealed, []byte(object))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive %s: %w", object, err)
+	}
+	return plain, nil
+}
+
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,92 @@
+package assets
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// encodings lists the pre-compressed variants we look for, in order of
+// preference, alongside the file extension produced by `make compress`.
+var encodings = []struct {
+	name string
+	ext  string
+}{
+	{name: "br", ext: ".br"},
+	{name: "gzip", ext: ".gz"},
+}
+
+// PrecompressedFileServer serves files from fsys, preferring a .br or .gz
+// sibling of the requested file when the client accepts that encoding. Files
+// without a compressed variant fall through to http.FileServer.
+func PrecompressedFileServer(fsys fs.FS) http.Handler {
+	fallback := http.FileServer(http.FS(fsys))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+			name = path.Join(name, "index.html")
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" || !hasVariants(fsys, name) {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		for _, enc := range encodings {
+			if !acceptsEncoding(r, enc.name) {
+				continue
+			}
+			f, err := fsys.Open(name + enc.ext)
+			if err != nil {
+				continue
+			}
+			defer f.Close()
+
+			rs, ok := f.(io.ReadSeeker)
+			info, statErr := f.Stat()
+			if !ok || statErr != nil {
+				continue
+			}
+
+			w.Header().Set("Content-Encoding", enc.name)
+			w.Header().Set("Content-Type", contentType)
+			http.ServeContent(w, r, name, info.ModTime(), rs)
+			return
+		}
+
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+func hasVariants(fsys fs.FS, name string) bool {
+	for _, enc := range encodings {
+		if _, err := fs.Stat(fsys, name+enc.ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), encoding) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.ReplaceAll(param, " ", "")
+			if param == "q=0" || param == "q=0.0" || param == "q=0.00" || param == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
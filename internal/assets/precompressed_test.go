@@ -0,0 +1,113 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPrecompressedFileServerBehavior(t *testing.T) {
+	fsys := fstest.MapFS{
+		"chat.js":       {Data: []byte("console.log('plain');")},
+		"chat.js.gz":    {Data: []byte("gzip-bytes")},
+		"chat.js.br":    {Data: []byte("brotli-bytes")},
+		"styles.css":    {Data: []byte("body{}")},
+		"index.html":    {Data: []byte("<html></html>")},
+		"index.html.gz": {Data: []byte("gzip-html")},
+	}
+	server := PrecompressedFileServer(fsys)
+
+	tests := []struct {
+		name             string
+		path             string
+		acceptEncoding   string
+		expectedEncoding string
+		expectedBody     string
+		expectedType     string
+	}{
+		{
+			name:             "prefers brotli when accepted",
+			path:             "/chat.js",
+			acceptEncoding:   "gzip, deflate, br",
+			expectedEncoding: "br",
+			expectedBody:     "brotli-bytes",
+			expectedType:     "text/javascript; charset=utf-8",
+		},
+		{
+			name:             "falls back to gzip",
+			path:             "/chat.js",
+			acceptEncoding:   "gzip",
+			expectedEncoding: "gzip",
+			expectedBody:     "gzip-bytes",
+			expectedType:     "text/javascript; charset=utf-8",
+		},
+		{
+			name:             "respects q=0 refusal",
+			path:             "/chat.js",
+			acceptEncoding:   "br;q=0, gzip",
+			expectedEncoding: "gzip",
+			expectedBody:     "gzip-bytes",
+		},
+		{
+			name:         "serves identity when no encoding accepted",
+			path:         "/chat.js",
+			expectedBody: "console.log('plain');",
+		},
+		{
+			name:           "serves identity when no variant exists",
+			path:           "/styles.css",
+			acceptEncoding: "gzip, br",
+			expectedBody:   "body{}",
+		},
+		{
+			name:             "resolves directory index",
+			path:             "/",
+			acceptEncoding:   "gzip",
+			expectedEncoding: "gzip",
+			expectedBody:     "gzip-html",
+			expectedType:     "text/html; charset=utf-8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.expectedEncoding {
+				t.Errorf("expected Content-Encoding %q, got %q", tt.expectedEncoding, got)
+			}
+			if w.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, w.Body.String())
+			}
+			if tt.expectedType != "" && w.Header().Get("Content-Type") != tt.expectedType {
+				t.Errorf("expected Content-Type %q, got %q", tt.expectedType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestServiceWorkerHeaders(t *testing.T) {
+	handler := ServiceWorkerHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", ServiceWorkerPath, nil))
+
+	if got := w.Header().Get("Service-Worker-Allowed"); got != "/" {
+		t.Errorf("expected Service-Worker-Allowed '/', got %q", got)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-cache, max-age=0" {
+		t.Errorf("service worker must not be cached aggressively, got %q", got)
+	}
+}
@@ -0,0 +1,16 @@
+package assets
+
+import "net/http"
+
+const ServiceWorkerPath = "/sw.js"
+
+// ServiceWorkerHeaders lets the worker control the whole origin and forces
+// browsers to revalidate it on every load, so a deploy is picked up
+// immediately instead of after the HTTP cache expires.
+func ServiceWorkerHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Service-Worker-Allowed", "/")
+		w.Header().Set("Cache-Control", "no-cache, max-age=0")
+		next.ServeHTTP(w, r)
+	})
+}
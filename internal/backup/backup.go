@@ -0,0 +1,253 @@
+// Package backup copies the files Manto keeps its data in (conversation
+// history, eval runs, the DLP audit log, runtime overrides and announcements)
+// into one zstd-compressed tar archive, with a manifest of SHA-256 checksums,
+// and restores them from it. Backups written as gzip by earlier versions are
+// still read.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/manto/manto-web/internal/atomicfile"
+	"github.com/manto/manto-web/internal/config"
+)
+
+const (
+	// formatVersion is bumped when the archive layout changes.
+	formatVersion = 1
+	manifestName  = "manifest.json"
+	dataDir       = "data/"
+)
+
+// Source is one data file. Replace, when set, installs restored data in the
+// running server, which then writes the file itself; otherwise restoring
+// writes the file directly.
+type Source struct {
+	Name    string
+	Path    string
+	Replace func(data []byte) error
+}
+
+// Sources lists the data files cfg enables. Features without a file keep
+// their data in memory, so there is nothing to back up.
+func Sources(cfg *config.Config) []Source {
+	var sources []Source
+	if cfg.Conversations.Enabled && cfg.Conversations.File != "" {
+		sources = append(sources, Source{Name: "conversations", Path: cfg.Conversations.File})
+	}
+	if cfg.Evals.Enabled && cfg.Evals.File != "" {
+		sources = append(sources, Source{Name: "evals", Path: cfg.Evals.File})
+	}
+	if cfg.DLP.Enabled && cfg.DLP.File != "" {
+		sources = append(sources, Source{Name: "dlp", Path: cfg.DLP.File})
+	}
+	if cfg.Overrides.File != "" {
+		sources = append(sources, Source{Name: "overrides", Path: cfg.Overrides.File})
+	}
+	if cfg.Announcements.File != "" {
+		sources = append(sources, Source{Name: "announcements", Path: cfg.Announcements.File})
+	}
+	return sources
+}
+
+// File describes one file in a backup.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest is the first entry of every backup.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Files     []File    `json:"files"`
+}
+
+// Write archives the sources to w. Sources whose file doesn't exist yet are
+// left out.
+func Write(w io.Writer, sources []Source, now time.Time) (*Manifest, error) {
+	manifest := &Manifest{Version: formatVersion, CreatedAt: now.UTC(), Files: []File{}}
+	contents := make([][]byte, 0, len(sources))
+	for _, source := range sources {
+		data, err := os.ReadFile(source.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", source.Name, err)
+		}
+		manifest.Files = append(manifest.Files, File{Name: source.Name, Size: int64(len(data)), SHA256: checksum(data)})
+		contents = append(contents, data)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode backup manifest: %w", err)
+	}
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	tw := tar.NewWriter(zw)
+	if err := writeEntry(tw, manifestName, manifestData, now); err != nil {
+		return nil, err
+	}
+	for i, file := range manifest.Files {
+		if err := writeEntry(tw, dataDir+file.Name, contents[i], now); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	return manifest, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte, now time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: now}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// Read reads a backup from r and checks every file against the manifest. It
+// returns the manifest and each file's contents by name.
+func Read(r io.Reader) (*Manifest, map[string][]byte, error) {
+	archive, err := decompress(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer archive.Close()
+	tr := tar.NewReader(archive)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return nil, nil, fmt.Errorf("not a backup archive: %s must come first", manifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if manifest.Version != formatVersion {
+		return nil, nil, fmt.Errorf("unsupported backup version %d (expected %d)", manifest.Version, formatVersion)
+	}
+	expected := make(map[string]File, len(manifest.Files))
+	for _, file := range manifest.Files {
+		expected[file.Name] = file
+	}
+
+	files := make(map[string][]byte, len(manifest.Files))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+		name, ok := strings.CutPrefix(header.Name, dataDir)
+		file, listed := expected[name]
+		if !ok || !listed || files[name] != nil {
+			return nil, nil, fmt.Errorf("unexpected file in backup: %s", header.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, file.Size+1))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s from backup: %w", name, err)
+		}
+		if int64(len(data)) != file.Size || checksum(data) != file.SHA256 {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s: the backup is corrupt", name)
+		}
+		files[name] = data
+	}
+	for _, file := range manifest.Files {
+		if files[file.Name] == nil {
+			return nil, nil, fmt.Errorf("backup is missing %s", file.Name)
+		}
+	}
+	return &manifest, files, nil
+}
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// decompress picks the decoder by the archive's magic number.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	}
+	return nil, errors.New("neither zstd nor gzip compressed")
+}
+
+// Restore reads a backup from r and applies it.
+func Restore(r io.Reader, sources []Source) (*Manifest, error) {
+	manifest, files, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, Apply(manifest, files, sources)
+}
+
+// Apply puts each file read from a backup back through its source. Nothing
+// is restored unless every file in the backup has a source.
+func Apply(manifest *Manifest, files map[string][]byte, sources []Source) error {
+	byName := make(map[string]Source, len(sources))
+	for _, source := range sources {
+		byName[source.Name] = source
+	}
+	for _, file := range manifest.Files {
+		if _, ok := byName[file.Name]; !ok {
+			return fmt.Errorf("backup contains %s, which is not enabled with a file here", file.Name)
+		}
+	}
+
+	for _, file := range manifest.Files {
+		source, data := byName[file.Name], files[file.Name]
+		var err error
+		if source.Replace != nil {
+			err = source.Replace(data)
+		} else {
+			err = atomicfile.Write(source.Path, data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
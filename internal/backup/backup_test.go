@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestBackupBehavior(t *testing.T) {
+	tests := []struct {
+		name        string
+		corrupt     func(data []byte) []byte
+		restoreTo   func(dir string) []Source
+		expectedErr string
+	}{
+		{name: "round trip"},
+		{
+			name:    "gzip backups from earlier versions are read",
+			corrupt: func(data []byte) []byte { return regzip(t, data) },
+		},
+		{
+			name:        "tampered files are refused",
+			corrupt:     func(data []byte) []byte { return retar(t, data, `{"runs":[]}`, `{"runs":{}}`) },
+			expectedErr: "checksum mismatch for evals",
+		},
+		{
+			name:        "truncated archives are refused",
+			corrupt:     func(data []byte) []byte { return data[:len(data)/2] },
+			expectedErr: "backup",
+		},
+		{
+			name: "files without a source are refused",
+			restoreTo: func(dir string) []Source {
+				return []Source{{Name: "conversations", Path: filepath.Join(dir, "conversations.json")}}
+			},
+			expectedErr: "backup contains evals",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sources := []Source{
+				{Name: "conversations", Path: filepath.Join(dir, "conversations.json")},
+				{Name: "evals", Path: filepath.Join(dir, "evals.json")},
+				{Name: "dlp", Path: filepath.Join(dir, "missing.jsonl")},
+			}
+			os.WriteFile(sources[0].Path, []byte(`{"conversations":[]}`), 0o600)
+			os.WriteFile(sources[1].Path, []byte(`{"runs":[]}`), 0o600)
+
+			var buf bytes.Buffer
+			manifest, err := Write(&buf, sources, time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(manifest.Files) != 2 {
+				t.Fatalf("expected the two existing files to be backed up, got %+v", manifest.Files)
+			}
+			data := buf.Bytes()
+			if tt.corrupt != nil {
+				data = tt.corrupt(data)
+			}
+
+			restoreDir := t.TempDir()
+			targets := []Source{
+				{Name: "conversations", Path: filepath.Join(restoreDir, "conversations.json")},
+				{Name: "evals", Path: filepath.Join(restoreDir, "evals.json")},
+			}
+			if tt.restoreTo != nil {
+				targets = tt.restoreTo(restoreDir)
+			}
+			_, err = Restore(bytes.NewReader(data), targets)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedErr, err)
+				}
+				if entries, _ := os.ReadDir(restoreDir); len(entries) != 0 {
+					t.Errorf("expected nothing restored, found %d files", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			restored, _ := os.ReadFile(targets[1].Path)
+			if string(restored) != `{"runs":[]}` {
+				t.Errorf("expected the evals file back, got %q", restored)
+			}
+		})
+	}
+}
+
+// retar replaces old with new inside the compressed tar stream, leaving the
+// compression itself intact.
+func retar(t *testing.T, data []byte, old, new string) []byte {
+	plain := untar(t, data)
+	var buf bytes.Buffer
+	w, _ := zstd.NewWriter(&buf)
+	w.Write(bytes.Replace(plain, []byte(old), []byte(new), 1))
+	w.Close()
+	return buf.Bytes()
+}
+
+// regzip recompresses a backup with gzip, as earlier versions wrote them.
+func regzip(t *testing.T, data []byte) []byte {
+	plain := untar(t, data)
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(plain)
+	w.Close()
+	return buf.Bytes()
+}
+
+func untar(t *testing.T, data []byte) []byte {
+	zr, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer zr.Close()
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return plain
+}
@@ -0,0 +1,341 @@
+// Package batch runs a CSV of prompts through the Messages API in the
+// background: each row fills a template, rows are sent with a bounded number
+// in flight, and the answers are collected into a results CSV. It is meant
+// for quick, lightweight evaluations rather than bulk processing.
+package batch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+
+	// DefaultTemplate sends the "prompt" column as is.
+	DefaultTemplate = "{{prompt}}"
+)
+
+var (
+	ErrNotFound = errors.New("batch not found")
+	ErrNoRows   = errors.New("the CSV needs a header row and at least one prompt row")
+)
+
+// ErrTooManyRows is returned by Parse when the CSV exceeds the row limit.
+type ErrTooManyRows struct{ Max int }
+
+func (e ErrTooManyRows) Error() string {
+	return fmt.Sprintf("the CSV has more than %d rows", e.Max)
+}
+
+// ErrUnknownColumn is returned by Parse when the template references a column
+// the CSV header doesn't have.
+type ErrUnknownColumn struct{ Column string }
+
+func (e ErrUnknownColumn) Error() string {
+	return fmt.Sprintf("the template uses unknown column %q", e.Column)
+}
+
+var placeholder = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// Row is one prompt and, once sent, its outcome.
+type Row struct {
+	Values       []string
+	Prompt       string
+	Response     string
+	StopReason   string
+	InputTokens  int
+	OutputTokens int
+	Error        string
+	done         bool
+}
+
+// Parse reads a CSV with a header row and renders template for every row,
+// replacing {{column}} with that row's value.
+func Parse(data, template string, maxRows int) ([]string, []Row, error) {
+	if template == "" {
+		template = DefaultTemplate
+	}
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = 0
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) < 2 {
+		return nil, nil, ErrNoRows
+	}
+	if len(records)-1 > maxRows {
+		return nil, nil, ErrTooManyRows{Max: maxRows}
+	}
+
+	columns := records[0]
+	index := make(map[string]int, len(columns))
+	for i, name := range columns {
+		index[strings.TrimSpace(name)] = i
+	}
+	for _, match := range placeholder.FindAllStringSubmatch(template, -1) {
+		if _, ok := index[match[1]]; !ok {
+			return nil, nil, ErrUnknownColumn{Column: match[1]}
+		}
+	}
+
+	rows := make([]Row, 0, len(records)-1)
+	for _, values := range records[1:] {
+		prompt := placeholder.ReplaceAllStringFunc(template, func(m string) string {
+			return values[index[placeholder.FindStringSubmatch(m)[1]]]
+		})
+		rows = append(rows, Row{Values: values, Prompt: prompt})
+	}
+	return columns, rows, nil
+}
+
+// Result is what SendFunc reports for one prompt.
+type Result struct {
+	Text         string
+	StopReason   string
+	InputTokens  int
+	OutputTokens int
+}
+
+// SendFunc sends one prompt upstream.
+type SendFunc func(ctx context.Context, prompt string) (Result, error)
+
+// Job is a batch running, or finished, in the background.
+type Job struct {
+	ID        string
+	Model     string
+	CreatedAt time.Time
+
+	owner   string
+	columns []string
+	cancel  context.CancelFunc
+
+	mu         sync.Mutex
+	rows       []Row
+	completed  int
+	failed     int
+	finishedAt time.Time
+}
+
+// JobStatus is the progress report for a job.
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Model      string     `json:"model"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Completed  int        `json:"completed"`
+	Failed     int        `json:"failed"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JobStatus{
+		ID:        j.ID,
+		Model:     j.Model,
+		Status:    StatusRunning,
+		Total:     len(j.rows),
+		Completed: j.completed,
+		Failed:    j.failed,
+		CreatedAt: j.CreatedAt,
+	}
+	if !j.finishedAt.IsZero() {
+		finished := j.finishedAt
+		status.Status = StatusCompleted
+		status.FinishedAt = &finished
+	}
+	return status
+}
+
+// WriteCSV writes the input columns followed by the rendered prompt and its
+// outcome. Rows still in flight have empty outcome columns.
+func (j *Job) WriteCSV(w io.Writer) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	out := csv.NewWriter(w)
+	header := append(append([]string{}, j.columns...), "prompt", "response", "stop_reason", "input_tokens", "output_tokens", "error")
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, row := range j.rows {
+		record := append([]string{}, row.Values...)
+		record = append(record, row.Prompt, row.Response, row.StopReason, "", "", row.Error)
+		if row.done && row.Error == "" {
+			record[len(record)-3] = strconv.Itoa(row.InputTokens)
+			record[len(record)-2] = strconv.Itoa(row.OutputTokens)
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Runner tracks batch jobs by ID. Finished jobs are dropped after ttl.
+type Runner struct {
+	concurrency int
+	ttl         time.Duration
+	now         func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewRunner(concurrency int, ttl time.Duration) *Runner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Runner{concurrency: concurrency, ttl: ttl, now: time.Now, jobs: make(map[string]*Job)}
+}
+
+// Submit starts sending rows in the background, at most the runner's
+// concurrency at a time.
+func (rn *Runner) Submit(owner, model string, columns []string, rows []Row, send SendFunc) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        newJobID(),
+		Model:     model,
+		CreatedAt: rn.now(),
+		owner:     owner,
+		columns:   columns,
+		cancel:    cancel,
+		rows:      rows,
+	}
+
+	rn.mu.Lock()
+	rn.jobs[job.ID] = job
+	rn.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for i := 0; i < rn.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for index := range next {
+					job.run(ctx, index, send)
+				}
+			}()
+		}
+		for i := range rows {
+			if ctx.Err() != nil {
+				break
+			}
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+
+		job.mu.Lock()
+		job.finishedAt = rn.now()
+		job.mu.Unlock()
+	}()
+
+	return job
+}
+
+func (j *Job) run(ctx context.Context, index int, send SendFunc) {
+	j.mu.Lock()
+	prompt := j.rows[index].Prompt
+	j.mu.Unlock()
+
+	result, err := send(ctx, prompt)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	row := &j.rows[index]
+	row.done = true
+	if err != nil {
+		row.Error = err.Error()
+		j.failed++
+		return
+	}
+	row.Response = result.Text
+	row.StopReason = result.StopReason
+	row.InputTokens = result.InputTokens
+	row.OutputTokens = result.OutputTokens
+	j.completed++
+}
+
+// Get returns owner's job.
+func (rn *Runner) Get(owner, id string) (*Job, error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	job, ok := rn.jobs[id]
+	if !ok || job.owner != owner {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// Delete stops owner's job if it is still running and forgets it.
+func (rn *Runner) Delete(owner, id string) error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	job, ok := rn.jobs[id]
+	if !ok || job.owner != owner {
+		return ErrNotFound
+	}
+	job.cancel()
+	delete(rn.jobs, id)
+	return nil
+}
+
+// Cleanup drops jobs that finished more than ttl ago.
+func (rn *Runner) Cleanup() {
+	cutoff := rn.now().Add(-rn.ttl)
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	for id, job := range rn.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && job.finishedAt.Before(cutoff)
+		job.mu.Unlock()
+		if expired {
+			delete(rn.jobs, id)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup every minute until stop is closed.
+func (rn *Runner) StartCleanup(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rn.Cleanup()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "batch_" + hex.EncodeToString(b)
+}
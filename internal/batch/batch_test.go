@@ -0,0 +1,117 @@
+package batch
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		template string
+		prompts  []string
+		err      error
+	}{
+		{name: "default template uses the prompt column", data: "prompt\nHi\nBye\n", prompts: []string{"Hi", "Bye"}},
+		{name: "template fills columns", data: "lang,word\nes,cat\ngl,dog\n", template: "Translate {{word}} to {{ lang }}", prompts: []string{"Translate cat to es", "Translate dog to gl"}},
+		{name: "unknown column", data: "word\ncat\n", template: "{{lang}}", err: ErrUnknownColumn{Column: "lang"}},
+		{name: "header only", data: "prompt\n", err: ErrNoRows},
+		{name: "too many rows", data: "prompt\na\nb\nc\n", err: ErrTooManyRows{Max: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rows, err := Parse(tt.data, tt.template, 2)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i, prompt := range tt.prompts {
+				if rows[i].Prompt != prompt {
+					t.Errorf("row %d: expected %q, got %q", i, prompt, rows[i].Prompt)
+				}
+			}
+		})
+	}
+
+	if _, _, err := Parse("a,b\n1\n", "", 10); err == nil {
+		t.Error("ragged rows should be rejected")
+	}
+}
+
+func TestRunnerBehavior(t *testing.T) {
+	columns, rows, err := Parse("prompt\none\ntwo\nfail\nfour\n", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var inFlight, peak atomic.Int32
+	runner := NewRunner(2, time.Hour)
+	job := runner.Submit("owner", "m", columns, rows, func(ctx context.Context, prompt string) (Result, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if prompt == "fail" {
+			return Result{}, errors.New("upstream error")
+		}
+		return Result{Text: strings.ToUpper(prompt), StopReason: "end_turn", InputTokens: 1, OutputTokens: 2}, nil
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for job.Status().Status != StatusCompleted {
+		if time.Now().After(deadline) {
+			t.Fatal("batch did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	status := job.Status()
+	if status.Total != 4 || status.Completed != 3 || status.Failed != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 rows in flight, saw %d", peak.Load())
+	}
+
+	var out strings.Builder
+	if err := job.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(records[0], ",") != "prompt,prompt,response,stop_reason,input_tokens,output_tokens,error" {
+		t.Errorf("unexpected header %v", records[0])
+	}
+	if records[1][2] != "ONE" || records[1][5] != "2" || records[3][6] != "upstream error" {
+		t.Errorf("unexpected results %v", records)
+	}
+
+	if _, err := runner.Get("someone else", job.ID); !errors.Is(err, ErrNotFound) {
+		t.Error("jobs should be scoped to their owner")
+	}
+
+	runner.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	runner.Cleanup()
+	if _, err := runner.Get("owner", job.ID); !errors.Is(err, ErrNotFound) {
+		t.Error("finished jobs should expire after the TTL")
+	}
+}
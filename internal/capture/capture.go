@@ -0,0 +1,241 @@
+// Package capture keeps sanitized copies of recent upstream exchanges for
+// debugging requests the provider rejects with unhelpful errors. Credentials
+// are redacted from headers and bodies, and bodies are truncated, before
+// anything is stored.
+package capture
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+const (
+	// Redacted replaces credentials.
+	Redacted = "[redacted]"
+
+	// minSecretFragment is the shortest start of a key, cut off by
+	// truncation, that is masked.
+	minSecretFragment = 4
+)
+
+// sensitiveHeaders are redacted wherever they appear; the configured auth
+// header is added to them.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie", "X-Api-Key"}
+
+// Exchange is one upstream request and its response, as far as it got.
+type Exchange struct {
+	ID                int64               `json:"id"`
+	Time              time.Time           `json:"time"`
+	Method            string              `json:"method"`
+	URL               string              `json:"url"`
+	RequestHeaders    map[string][]string `json:"requestHeaders"`
+	RequestBody       string              `json:"requestBody,omitempty"`
+	RequestTruncated  bool                `json:"requestTruncated,omitempty"`
+	Status            int                 `json:"status,omitempty"`
+	ResponseHeaders   map[string][]string `json:"responseHeaders,omitempty"`
+	ResponseBody      string              `json:"responseBody,omitempty"`
+	ResponseTruncated bool                `json:"responseTruncated,omitempty"`
+	DurationMS        int64               `json:"durationMs"`
+	Error             string              `json:"error,omitempty"`
+}
+
+// Recorder holds the most recent exchanges. A nil *Recorder captures
+// nothing.
+type Recorder struct {
+	size       int
+	maxBody    int
+	authHeader string
+	nextID     atomic.Int64
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// New returns the recorder cfg describes, or nil when capture is disabled.
+// authHeader is the header upstream keys are sent in.
+func New(cfg config.DebugConfig, authHeader string) *Recorder {
+	if !cfg.CaptureEnabled {
+		return nil
+	}
+	return &Recorder{size: cfg.CaptureSize, maxBody: cfg.CaptureMaxBody, authHeader: http.CanonicalHeaderKey(authHeader)}
+}
+
+// Do sends req with send, capturing the exchange. The request body is
+// captured as it is sent and the response body as the caller reads it; the
+// exchange is stored when the response body is closed, or at once if there
+// is no response.
+func (c *Recorder) Do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if c == nil {
+		return send(req)
+	}
+
+	secret := c.secret(req.Header)
+	x := &Exchange{
+		ID:             c.nextID.Add(1),
+		Time:           time.Now().UTC(),
+		Method:         req.Method,
+		URL:            req.URL.Redacted(),
+		RequestHeaders: c.headers(req.Header),
+	}
+	var reqBody *limitedBuffer
+	if req.Body != nil {
+		reqBody = &limitedBuffer{max: c.maxBody + len(secret)}
+		req.Body = &teeBody{ReadCloser: req.Body, buf: reqBody}
+	}
+	start := time.Now()
+
+	finish := func(resp *http.Response, respBody *limitedBuffer, err error) {
+		x.DurationMS = time.Since(start).Milliseconds()
+		if reqBody != nil {
+			x.RequestBody, x.RequestTruncated = c.body(reqBody, secret)
+		}
+		if resp != nil {
+			x.Status = resp.StatusCode
+			x.ResponseHeaders = c.headers(resp.Header)
+		}
+		if respBody != nil {
+			x.ResponseBody, x.ResponseTruncated = c.body(respBody, secret)
+		}
+		if err != nil {
+			x.Error = redact(err.Error(), secret)
+		}
+		c.add(*x)
+	}
+
+	resp, err := send(req)
+	if err != nil {
+		finish(nil, nil, err)
+		return resp, err
+	}
+	respBody := &limitedBuffer{max: c.maxBody + len(secret)}
+	resp.Body = &teeBody{ReadCloser: resp.Body, buf: respBody, onClose: func() { finish(resp, respBody, nil) }}
+	return resp, nil
+}
+
+// Exchanges returns the captured exchanges, newest first.
+func (c *Recorder) Exchanges() []Exchange {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]Exchange, len(c.exchanges))
+	for i, x := range c.exchanges {
+		out[len(out)-1-i] = x
+	}
+	return out
+}
+
+func (c *Recorder) add(x Exchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exchanges = append(c.exchanges, x)
+	if len(c.exchanges) > c.size {
+		c.exchanges = c.exchanges[len(c.exchanges)-c.size:]
+	}
+}
+
+func (c *Recorder) sensitive(name string) bool {
+	return slices.Contains(sensitiveHeaders, name) || name == c.authHeader
+}
+
+// secret returns the upstream key, without any scheme, so it can also be
+// scrubbed from bodies and errors.
+func (c *Recorder) secret(header http.Header) string {
+	value := header.Get(c.authHeader)
+	if _, key, ok := strings.Cut(value, " "); ok {
+		return key
+	}
+	return value
+}
+
+func (c *Recorder) headers(header http.Header) map[string][]string {
+	out := make(map[string][]string, len(header))
+	for name, values := range header {
+		if c.sensitive(name) {
+			out[name] = []string{Redacted}
+			continue
+		}
+		out[name] = append([]string(nil), values...)
+	}
+	return out
+}
+
+// body returns what b captured with the secret scrubbed, cut to maxBody
+// bytes. Buffers keep len(secret) bytes extra so a key straddling the limit
+// is still whole when scrubbed; one the cut splits is masked as well.
+func (c *Recorder) body(b *limitedBuffer, secret string) (string, bool) {
+	text, truncated := b.contents()
+	text = redact(text, secret)
+	if len(text) <= c.maxBody {
+		return text, truncated
+	}
+	text = text[:c.maxBody]
+	for n := len(secret) - 1; n >= minSecretFragment; n-- {
+		if strings.HasSuffix(text, secret[:n]) {
+			return text[:len(text)-n] + Redacted, true
+		}
+	}
+	return text, true
+}
+
+func redact(text, secret string) string {
+	if secret == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, secret, Redacted)
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); len(p) > room {
+		p, b.truncated = p[:max(room, 0)], true
+	}
+	b.buf.Write(p)
+}
+
+func (b *limitedBuffer) contents() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String(), b.truncated
+}
+
+// teeBody copies what is read through it into buf.
+type teeBody struct {
+	io.ReadCloser
+	buf     *limitedBuffer
+	onClose func()
+	once    sync.Once
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.buf.Write(p[:n])
+	return n, err
+}
+
+func (t *teeBody) Close() error {
+	err := t.ReadCloser.Close()
+	if t.onClose != nil {
+		t.once.Do(t.onClose)
+	}
+	return err
+}
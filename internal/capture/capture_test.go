@@ -0,0 +1,71 @@
+package capture
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func TestRecorderBehavior(t *testing.T) {
+	recorder := New(config.DebugConfig{CaptureEnabled: true, CaptureSize: 2, CaptureMaxBody: 40}, "Authorization")
+	respond := func(status int, body string) func(*http.Request) (*http.Response, error) {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Body != nil {
+				io.ReadAll(req.Body)
+			}
+			header := http.Header{"Set-Cookie": {"a=b"}, "Request-Id": {"req_1"}}
+			return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+		}
+	}
+	send := func(body string, fn func(*http.Request) (*http.Response, error)) {
+		req, _ := http.NewRequest("POST", "https://api.example.com/v1/messages", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sk-ant-secret-key")
+		req.Header.Set("Anthropic-Version", "2023-06-01")
+		resp, err := recorder.Do(req, fn)
+		if err == nil {
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	}
+
+	send(`{"model":"a"}`, respond(200, `{"ok":true}`))
+	send(`{"model":"b","note":"my key is sk-ant-secret-key"}`, respond(400, `{"error":{"message":"`+strings.Repeat("x", 60)+`"}}`))
+	send(`{"model":"c"}`, func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("dial failed for sk-ant-secret-key")
+	})
+
+	exchanges := recorder.Exchanges()
+	if len(exchanges) != 2 {
+		t.Fatalf("expected the ring to keep 2 exchanges, got %d", len(exchanges))
+	}
+	failed, rejected := exchanges[0], exchanges[1]
+	if failed.Error != "dial failed for [redacted]" || failed.Status != 0 {
+		t.Errorf("expected a redacted transport error, got %+v", failed)
+	}
+	if rejected.Status != 400 || !rejected.ResponseTruncated || len(rejected.ResponseBody) != 40 {
+		t.Errorf("expected a truncated 400 response, got %+v", rejected)
+	}
+	if strings.Contains(rejected.RequestBody, "sk-ant-s") || !strings.Contains(rejected.RequestBody, "my key is [redact") {
+		t.Errorf("expected the key to be scrubbed from the body, got %q", rejected.RequestBody)
+	}
+	if rejected.RequestHeaders["Authorization"][0] != Redacted || rejected.ResponseHeaders["Set-Cookie"][0] != Redacted {
+		t.Errorf("expected credentials redacted, got %v and %v", rejected.RequestHeaders, rejected.ResponseHeaders)
+	}
+	if rejected.RequestHeaders["Anthropic-Version"][0] != "2023-06-01" || rejected.ResponseHeaders["Request-Id"][0] != "req_1" {
+		t.Errorf("expected other headers kept, got %v and %v", rejected.RequestHeaders, rejected.ResponseHeaders)
+	}
+
+	var none *Recorder
+	if _, err := none.Do(httptestRequest(), respond(204, "")); err != nil || none.Exchanges() != nil {
+		t.Error("expected a nil recorder to pass requests through")
+	}
+}
+
+func httptestRequest() *http.Request {
+	req, _ := http.NewRequest("GET", "https://api.example.com/v1/models", nil)
+	return req
+}
@@ -0,0 +1,177 @@
+// Package chat is a terminal client for Manto's ndjson endpoint: it keeps
+// the conversation locally, sends it with each prompt and prints the answer
+// as it streams in.
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Path is the server's ndjson endpoint.
+const Path = "/api/messages/ndjson"
+
+// Event is one line of the server's answer.
+type Event struct {
+	Type       string `json:"type"`
+	Position   int    `json:"position"`
+	Text       string `json:"text"`
+	Model      string `json:"model"`
+	StopReason string `json:"stopReason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Moderation string `json:"moderation"`
+	Error      string `json:"error"`
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Client talks to one Manto server.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	KeyHeader  string
+	AccessCode string
+	HTTP       *http.Client
+}
+
+// Send posts the conversation and calls onEvent for each event until the
+// answer is done, returning the final event.
+func (c *Client) Send(ctx context.Context, model, system string, messages []message, onEvent func(Event)) (Event, error) {
+	payload := map[string]any{"model": model, "messages": messages}
+	if system != "" {
+		payload["system"] = system
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(c.BaseURL, "/")+Path, bytes.NewReader(body))
+	if err != nil {
+		return Event{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/x-ndjson")
+	req.Header.Set(c.KeyHeader, c.APIKey)
+	if c.AccessCode != "" {
+		req.Header.Set("X-Manto-Access-Code", c.AccessCode)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return Event{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&failure)
+		if failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return Event{}, errors.New(failure.Error)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return Event{}, fmt.Errorf("invalid event: %w", err)
+		}
+		switch event.Type {
+		case "done":
+			return event, nil
+		case "error":
+			return Event{}, errors.New(event.Error)
+		}
+		onEvent(event)
+	}
+	if err := scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, errors.New("connection closed before the answer finished")
+}
+
+// Session is an interactive conversation.
+type Session struct {
+	Client *Client
+	Model  string
+	System string
+
+	history []message
+}
+
+const help = `Commands: /model <id> switches model, /reset starts over, /exit quits.`
+
+// Run reads prompts from in, one per line, and writes answers to out until
+// in ends, /exit is entered or ctx is done. A failed prompt is dropped from
+// the history so it can be retried.
+func (s *Session) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	fmt.Fprintf(out, "Chatting with %s. %s\n", s.Model, help)
+	lines := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !lines.Scan() {
+			fmt.Fprintln(out)
+			return lines.Err()
+		}
+		line := strings.TrimSpace(lines.Text())
+		switch {
+		case line == "":
+			continue
+		case line == "/exit" || line == "/quit":
+			return nil
+		case line == "/reset":
+			s.history = nil
+			fmt.Fprintln(out, "Conversation cleared.")
+			continue
+		case strings.HasPrefix(line, "/model"):
+			if model := strings.TrimSpace(strings.TrimPrefix(line, "/model")); model != "" {
+				s.Model = model
+			}
+			fmt.Fprintf(out, "Model: %s\n", s.Model)
+			continue
+		case line == "/help":
+			fmt.Fprintln(out, help)
+			continue
+		}
+
+		s.history = append(s.history, message{Role: "user", Content: line})
+		var answer strings.Builder
+		done, err := s.Client.Send(ctx, s.Model, s.System, s.history, func(e Event) {
+			switch e.Type {
+			case "queued":
+				fmt.Fprintf(out, "\r(queued, position %d)", e.Position)
+			case "start":
+				fmt.Fprint(out, "\r\033[K")
+			case "delta":
+				answer.WriteString(e.Text)
+				fmt.Fprint(out, e.Text)
+			}
+		})
+		if err != nil {
+			s.history = s.history[:len(s.history)-1]
+			fmt.Fprintf(out, "\nerror: %v\n", err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		s.history = append(s.history, message{Role: "assistant", Content: answer.String()})
+		fmt.Fprintf(out, "\n[%s, %d in / %d out tokens]\n", done.Model, done.Usage.InputTokens, done.Usage.OutputTokens)
+	}
+}
@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionBehavior(t *testing.T) {
+	var requests []struct {
+		Model    string    `json:"model"`
+		Messages []message `json:"messages"`
+	}
+	var gotKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != Path {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		gotKey = r.Header.Get("x-api-key")
+		var req struct {
+			Model    string    `json:"model"`
+			Messages []message `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		last := req.Messages[len(req.Messages)-1].Content
+		if last == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"Message too long"}`))
+			return
+		}
+		fmt.Fprintln(w, `{"type":"queued","position":1}`)
+		fmt.Fprintln(w, `{"type":"start","model":"haiku"}`)
+		fmt.Fprintf(w, "{\"type\":\"delta\",\"text\":\"echo \"}\n{\"type\":\"delta\",\"text\":%q}\n", last)
+		fmt.Fprintln(w, `{"type":"done","model":"`+req.Model+`","usage":{"input_tokens":4,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	session := &Session{
+		Client: &Client{BaseURL: server.URL, APIKey: "sk-ant-123", KeyHeader: "x-api-key", HTTP: server.Client()},
+		Model:  "haiku",
+	}
+	var out strings.Builder
+	input := strings.Join([]string{"hello", "bad", "/model sonnet", "again", "/reset", "fresh", "/exit", "ignored"}, "\n")
+	if err := session.Run(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotKey != "sk-ant-123" {
+		t.Errorf("expected the key header, got %q", gotKey)
+	}
+	if len(requests) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(requests))
+	}
+	if again := requests[2]; again.Model != "sonnet" || len(again.Messages) != 3 || again.Messages[1].Content != "echo hello" {
+		t.Errorf("expected the failed prompt dropped and history kept, got %+v", again)
+	}
+	if fresh := requests[3]; len(fresh.Messages) != 1 {
+		t.Errorf("expected /reset to clear history, got %+v", fresh)
+	}
+	for _, expected := range []string{"echo hello", "error: Message too long", "[sonnet, 4 in / 2 out tokens]"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got %s", expected, out.String())
+		}
+	}
+}
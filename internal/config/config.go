@@ -1,60 +1,435 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"maps"
+	"net/netip"
+	"net/url"
 	"os"
 	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
+// Duration is a time.Duration loaded with ParseDuration, so config values
+// may use day and week units or a bare number of seconds.
 type Duration struct {
 	time.Duration
 }
 
 type Config struct {
-	Server     ServerConfig
-	Security   SecurityConfig
-	Logging    LoggingConfig
-	Anthropic  AnthropicConfig
-	Validation ValidationConfig
+	Environment   string
+	Server        ServerConfig
+	Security      SecurityConfig
+	Logging       LoggingConfig
+	Provider      ProviderConfig
+	Providers     []ProviderSpec `env:"PROVIDERS"`
+	Anthropic     AnthropicConfig
+	OpenAI        OpenAIConfig
+	OpenRouter    OpenRouterConfig
+	Ollama        OllamaConfig
+	Vertex        VertexConfig
+	Validation    ValidationConfig
+	BodyLimits    BodyLimitsConfig
+	Branding      BrandingConfig
+	ErrorPages    ErrorPagesConfig
+	I18n          I18nConfig
+	Analytics     AnalyticsConfig
+	Metrics       MetricsConfig
+	Tracing       TracingConfig
+	SLO           SLOConfig
+	RateLimit     RateLimitConfig
+	ErrorTracking ErrorTrackingConfig
+	Admin         AdminConfig
+	Conversations ConversationsConfig
+	Archive       ArchiveConfig
+	Session       SessionConfig
+	Access        AccessConfig
+	Queue         QueueConfig
+	DNS           DNSConfig
+	Tokens        TokensConfig
+	Models        ModelsConfig
+	Batch         BatchConfig
+	Evals         EvalsConfig
+	Summarize     SummarizeConfig
+	Title         TitleConfig
+	Speech        SpeechConfig
+	Parts         PartsConfig
+	Experiments   ExperimentsConfig
+	Tenants       TenantsConfig
+	Overrides     OverridesConfig
+	Announcements AnnouncementsConfig
+	Moderation    ModerationConfig
+	PII           PIIConfig
+	Secrets       SecretsConfig
+	DLP           DLPConfig
+	Journal       JournalConfig
+	GRPC          GRPCConfig
+	Passthrough   PassthroughConfig
+	Debug         DebugConfig
+	Remote        RemoteConfig
+	Status        StatusConfig
+
+	// envFiles records the dotenv cascade, for troubleshooting.
+	envFiles []EnvFile
+
+	// remote is what the remote backend supplied at load time.
+	remote *remoteState
 }
 
+// ServerConfig's RequestTimeout bounds how long a request may take before
+// it is answered with a 504; it is cut to just under WriteTimeout when that
+// is shorter. 0 leaves only WriteTimeout. TrustedProxies lists the addresses
+// or CIDR ranges of reverse proxies whose Fly-Client-IP and X-Forwarded-For
+// headers name the client; "private" trusts every loopback and private
+// address.
 type ServerConfig struct {
-	Port         int      `env:"PORT" default:"8080"`
-	Host         string   `env:"HOST" default:"0.0.0.0"`
-	ReadTimeout  Duration `env:"READ_TIMEOUT" default:"30s"`
-	WriteTimeout Duration `env:"WRITE_TIMEOUT" default:"30s"`
-	AllowedHosts []string `env:"ALLOWED_HOSTS" default:"*"`
+	Port           int      `env:"PORT" default:"8080"`
+	Host           string   `env:"HOST" default:"0.0.0.0"`
+	ReadTimeout    Duration `env:"READ_TIMEOUT" default:"30s"`
+	WriteTimeout   Duration `env:"WRITE_TIMEOUT" default:"30s"`
+	RequestTimeout Duration `env:"REQUEST_TIMEOUT" default:"60s"`
+	AllowedHosts   []string `env:"ALLOWED_HOSTS" default:"*"`
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+	StaticDir      string   `env:"STATIC_DIR" default:"cmd/manto-web/static"`
+	DrainPeriod    Duration `env:"SHUTDOWN_DRAIN_PERIOD" default:"2m"`
 }
 
+// SecurityConfig's AllowedAPIEndpoints lists origins the browser may connect
+// to beyond Manto itself; the providers' own origins are added at load time.
+// With EnforceEgress, they are also the only origins the upstream client may
+// dial.
 type SecurityConfig struct {
 	EnableHSTS          bool     `env:"ENABLE_HSTS" default:"true"`
-	AllowedAPIEndpoints []string `env:"ALLOWED_API_ENDPOINTS" default:"https://api.anthropic.com"`
-	APIKeyMinLength     int      `env:"API_KEY_MIN_LENGTH" default:"10"`
+	AllowedAPIEndpoints []string `env:"ALLOWED_API_ENDPOINTS"`
+	EnforceEgress       bool     `env:"EGRESS_ENFORCE" default:"true"`
+	// AllowInsecureEndpoints permits plain http upstreams beyond loopback.
+	AllowInsecureEndpoints bool `env:"ALLOW_INSECURE_ENDPOINTS" default:"false"`
+	APIKeyMinLength        int  `env:"API_KEY_MIN_LENGTH" default:"10"`
+	// KeyFailureThreshold invalid API keys from one IP or session within
+	// KeyFailureWindow start delaying its requests by KeyFailureDelay,
+	// doubling with each further failure up to KeyFailureMaxDelay. At
+	// KeyFailureBanAfter failures it is refused for KeyFailureBanDuration.
+	// A threshold of 0 turns this off, and a KeyFailureBanAfter of 0 never
+	// bans.
+	KeyFailureThreshold   int      `env:"KEY_FAILURE_THRESHOLD" default:"5"`
+	KeyFailureWindow      Duration `env:"KEY_FAILURE_WINDOW" default:"15m"`
+	KeyFailureDelay       Duration `env:"KEY_FAILURE_DELAY" default:"1s"`
+	KeyFailureMaxDelay    Duration `env:"KEY_FAILURE_MAX_DELAY" default:"30s"`
+	KeyFailureBanAfter    int      `env:"KEY_FAILURE_BAN_AFTER" default:"20"`
+	KeyFailureBanDuration Duration `env:"KEY_FAILURE_BAN_DURATION" default:"15m"`
+
+	// The values of the hardening headers sent on every response. "off"
+	// leaves X-Content-Type-Options or X-Frame-Options out, and an empty
+	// PermissionsPolicy leaves Permissions-Policy out. FrameOptions also
+	// sets the CSP's frame-ancestors.
+	ReferrerPolicy            string `env:"REFERRER_POLICY" default:"no-referrer"`
+	ContentTypeOptions        string `env:"CONTENT_TYPE_OPTIONS" default:"nosniff"`
+	FrameOptions              string `env:"FRAME_OPTIONS" default:"DENY"`
+	CrossOriginResourcePolicy string `env:"CROSS_ORIGIN_RESOURCE_POLICY" default:"same-site"`
+	PermissionsPolicy         string `env:"PERMISSIONS_POLICY" default:"geolocation=()"`
 }
 
+// The values the hardening headers in SecurityConfig may take.
+var (
+	referrerPolicies            = []string{"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin", "same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url"}
+	contentTypeOptions          = []string{"nosniff", "off"}
+	frameOptions                = []string{"DENY", "SAMEORIGIN", "off"}
+	crossOriginResourcePolicies = []string{"same-site", "same-origin", "cross-origin"}
+)
+
 type LoggingConfig struct {
-	Level            string `env:"LOG_LEVEL" default:"info"`
-	Format           string `env:"LOG_FORMAT" default:"json"`
-	IncludeTimestamp bool   `env:"LOG_INCLUDE_TIMESTAMP" default:"true"`
-	IncludeSource    bool   `env:"LOG_INCLUDE_SOURCE" default:"false"`
+	Level                string   `env:"LOG_LEVEL" default:"info"`
+	Format               string   `env:"LOG_FORMAT" default:"json"`
+	IncludeTimestamp     bool     `env:"LOG_INCLUDE_TIMESTAMP" default:"true"`
+	IncludeSource        bool     `env:"LOG_INCLUDE_SOURCE" default:"false"`
+	SlowRequestThreshold Duration `env:"SLOW_REQUEST_THRESHOLD" default:"10s"`
+	// OTLP also exports logs to the OpenTelemetry collector at OTLPEndpoint
+	// over OTLP/HTTP, with OTLPHeaders on every export and ServiceName as
+	// the service.name resource attribute.
+	OTLP         bool              `env:"LOG_OTLP_ENABLED" default:"false"`
+	OTLPEndpoint string            `env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"http://localhost:4318"`
+	OTLPHeaders  map[string]string `env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true"`
+	ServiceName  string            `env:"OTEL_SERVICE_NAME" default:"manto-web"`
+}
+
+// ProviderConfig chooses the model backend for requests that don't name one
+// in X-Manto-Provider or ?provider=.
+type ProviderConfig struct {
+	Default string `env:"PROVIDER_DEFAULT" default:"anthropic"`
+}
+
+// ProviderSpec adds a provider to the registry under Name, alongside the
+// built-in ones. Type picks the implementation: anthropic for a gateway
+// serving the Messages API, openai for any OpenAI-compatible API, openrouter
+// or ollama. BaseURL and KeyPrefix replace the type's own settings when set,
+// and DefaultModel is the model the UI preselects.
+type ProviderSpec struct {
+	Name         string `env:"NAME"`
+	Type         string `env:"TYPE"`
+	DisplayName  string `env:"DISPLAY_NAME"`
+	BaseURL      string `env:"BASE_URL"`
+	KeyPrefix    string `env:"KEY_PREFIX"`
+	DefaultModel string `env:"DEFAULT_MODEL"`
 }
 
+// providerTypes are the types a ProviderSpec may name.
+var providerTypes = []string{"anthropic", "openai", "openrouter", "ollama"}
+
+// providerName matches names safe in a header, a query and a metric label.
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// AnthropicConfig configures the upstream API. ModelMaxTokens caps max_tokens
+// per model ID or ID prefix, given as "model=limit" entries;
+// requests over a cap are clamped, or rejected when MaxTokensPolicy is
+// "reject". StopSequences are added to every request on top of the client's.
+// KeyPrefix may list several comma-separated prefixes, and KeyPattern adds a
+// regular expression a whole key may match instead, for gateway-issued keys.
+// ClientKeyHeader is where browsers send their key; AuthHeader and AuthScheme
+// control how it is forwarded, for gateways expecting "Authorization: Bearer".
+// ThinkingModels lists the model IDs or ID prefixes that accept extended
+// thinking. APIVersion is the anthropic-version sent by default;
+// ModelAPIVersions overrides it per model as "model=version" entries, and
+// clients may ask for any of those or of APIVersions instead.
 type AnthropicConfig struct {
-	APIKey        string   `env:"ANTHROPIC_API_KEY"`
-	BaseURL       string   `env:"ANTHROPIC_BASE_URL" default:"https://api.anthropic.com"`
-	APIVersion    string   `env:"ANTHROPIC_API_VERSION" default:"2023-06-01"`
-	Timeout       Duration `env:"ANTHROPIC_TIMEOUT" default:"60s"`
-	MaxRetries    int      `env:"ANTHROPIC_MAX_RETRIES" default:"3"`
-	KeyPrefix     string   `env:"ANTHROPIC_KEY_PREFIX" default:"sk-ant-"`
-	DefaultModel  string   `env:"ANTHROPIC_DEFAULT_MODEL" default:"claude-3-5-haiku"`
-	MaxTokens     int      `env:"ANTHROPIC_MAX_TOKENS" default:"1024"`
-	Temperature   float64  `env:"ANTHROPIC_TEMPERATURE" default:"0.7"`
-	SystemMessage string   `env:"ANTHROPIC_SYSTEM_MESSAGE" default:"Be concise in your responses unless asked otherwise. Prefer tables and short paragraphs."`
+	APIKey           string            `env:"ANTHROPIC_API_KEY" secret:"true"`
+	BaseURL          string            `env:"ANTHROPIC_BASE_URL" default:"https://api.anthropic.com"`
+	APIVersion       string            `env:"ANTHROPIC_API_VERSION" default:"2023-06-01"`
+	APIVersions      []string          `env:"ANTHROPIC_API_VERSIONS"`
+	ModelAPIVersions map[string]string `env:"ANTHROPIC_MODEL_API_VERSIONS"`
+	Timeout          Duration          `env:"ANTHROPIC_TIMEOUT" default:"60s"`
+	MaxRetries       int               `env:"ANTHROPIC_MAX_RETRIES" default:"3"`
+	KeyPrefix        string            `env:"ANTHROPIC_KEY_PREFIX" default:"sk-ant-"`
+	KeyPattern       string            `env:"ANTHROPIC_KEY_PATTERN"`
+	ClientKeyHeader  string            `env:"ANTHROPIC_CLIENT_KEY_HEADER" default:"x-api-key"`
+	AuthHeader       string            `env:"ANTHROPIC_AUTH_HEADER" default:"x-api-key"`
+	AuthScheme       string            `env:"ANTHROPIC_AUTH_SCHEME"`
+	UserAgent        string            `env:"ANTHROPIC_USER_AGENT" default:"Manto/1.0"`
+	DefaultModel     string            `env:"ANTHROPIC_DEFAULT_MODEL" default:"claude-3-5-haiku"`
+	MaxTokens        int               `env:"ANTHROPIC_MAX_TOKENS" default:"1024"`
+	ModelMaxTokens   ModelLimits       `env:"ANTHROPIC_MODEL_MAX_TOKENS"`
+	ThinkingModels   []string          `env:"ANTHROPIC_THINKING_MODELS" default:"claude-3-7-sonnet,claude-sonnet-4,claude-opus-4,claude-haiku-4"`
+	MaxTokensPolicy  string            `env:"ANTHROPIC_MAX_TOKENS_POLICY" default:"clamp"`
+	StopSequences    []string          `env:"ANTHROPIC_STOP_SEQUENCES"`
+	Temperature      float64           `env:"ANTHROPIC_TEMPERATURE" default:"0.7"`
+	SystemMessage    string            `env:"ANTHROPIC_SYSTEM_MESSAGE" default:"Be concise in your responses unless asked otherwise. Prefer tables and short paragraphs."`
+
+	// StartupProbe checks at startup that BaseURL answers: "off", "warn" to
+	// log loudly when it doesn't, or "fail" to refuse to start.
+	StartupProbe        string   `env:"ANTHROPIC_STARTUP_PROBE" default:"off"`
+	StartupProbeTimeout Duration `env:"ANTHROPIC_STARTUP_PROBE_TIMEOUT" default:"5s"`
+
+	// PrewarmConnections, when positive, opens that many connections to
+	// BaseURL at startup and again every PrewarmInterval, so requests don't
+	// wait for DNS and TLS handshakes after a quiet spell.
+	PrewarmConnections int      `env:"ANTHROPIC_PREWARM_CONNECTIONS" default:"0"`
+	PrewarmInterval    Duration `env:"ANTHROPIC_PREWARM_INTERVAL" default:"45s"`
+
+	// SigningKey, when set, signs every upstream request with an HMAC in
+	// SigningHeader, for gateways in front of Anthropic that require one.
+	// SigningAlgorithm is "sha256" or "sha512".
+	SigningKey       string `env:"ANTHROPIC_SIGNING_KEY" secret:"true"`
+	SigningAlgorithm string `env:"ANTHROPIC_SIGNING_ALGORITHM" default:"sha256"`
+	SigningHeader    string `env:"ANTHROPIC_SIGNING_HEADER" default:"X-Manto-Signature"`
+
+	// ResumeAttempts is how many times a streamed answer that breaks off
+	// partway is continued from where it stopped; 0 returns it incomplete.
+	ResumeAttempts int `env:"ANTHROPIC_RESUME_ATTEMPTS" default:"0"`
+	// MaxContinuations is how many follow-up requests may carry on an answer
+	// cut off by max_tokens; 0 returns it as cut off.
+	MaxContinuations int `env:"ANTHROPIC_MAX_CONTINUATIONS" default:"0"`
+	// SchemaRetries is how many times an answer that doesn't match the
+	// requested response_format schema is retried with the errors fed back.
+	SchemaRetries int `env:"ANTHROPIC_SCHEMA_RETRIES" default:"2"`
+
+	// InputPrices and OutputPrices are USD per million tokens by model
+	// prefix, for cost estimates; models without a price get none.
+	InputPrices  map[string]float64 `env:"ANTHROPIC_INPUT_PRICES"`
+	OutputPrices map[string]float64 `env:"ANTHROPIC_OUTPUT_PRICES"`
+}
+
+// OpenAIConfig configures the OpenAI-compatible provider, offered alongside
+// Anthropic when enabled. BaseURL includes the API version, as OpenAI-
+// compatible servers expect (e.g. http://localhost:8000/v1).
+type OpenAIConfig struct {
+	Enabled   bool     `env:"OPENAI_ENABLED" default:"false"`
+	BaseURL   string   `env:"OPENAI_BASE_URL" default:"https://api.openai.com/v1"`
+	KeyPrefix string   `env:"OPENAI_KEY_PREFIX" default:"sk-"`
+	Timeout   Duration `env:"OPENAI_TIMEOUT" default:"60s"`
+}
+
+// OpenRouterConfig configures the OpenRouter provider, which reaches many
+// vendors' models with one key through an OpenAI-compatible API.
+type OpenRouterConfig struct {
+	Enabled   bool     `env:"OPENROUTER_ENABLED" default:"false"`
+	BaseURL   string   `env:"OPENROUTER_BASE_URL" default:"https://openrouter.ai/api/v1"`
+	KeyPrefix string   `env:"OPENROUTER_KEY_PREFIX" default:"sk-or-"`
+	Timeout   Duration `env:"OPENROUTER_TIMEOUT" default:"60s"`
+}
+
+// OllamaConfig configures the provider for models served by a local Ollama,
+// which needs no API key. Timeout is generous since local models can take
+// a while to load and answer.
+type OllamaConfig struct {
+	Enabled bool     `env:"OLLAMA_ENABLED" default:"false"`
+	BaseURL string   `env:"OLLAMA_BASE_URL" default:"http://localhost:11434"`
+	Timeout Duration `env:"OLLAMA_TIMEOUT" default:"5m"`
+}
+
+// VertexConfig configures the provider for Claude on Google Cloud Vertex AI.
+// Manto signs in as the service account in CredentialsFile, so callers need
+// no key. BaseURL defaults to Region's endpoint; TokenURL is where the
+// service account's signed assertions are exchanged for access tokens.
+type VertexConfig struct {
+	Enabled         bool     `env:"VERTEX_ENABLED" default:"false"`
+	ProjectID       string   `env:"VERTEX_PROJECT_ID"`
+	Region          string   `env:"VERTEX_REGION" default:"us-east5"`
+	BaseURL         string   `env:"VERTEX_BASE_URL"`
+	CredentialsFile string   `env:"VERTEX_CREDENTIALS_FILE"`
+	TokenURL        string   `env:"VERTEX_TOKEN_URL" default:"https://oauth2.googleapis.com/token"`
+	Models          []string `env:"VERTEX_MODELS" default:"claude-sonnet-4-5@20250929,claude-opus-4-1@20250805,claude-3-5-haiku@20241022"`
+	Timeout         Duration `env:"VERTEX_TIMEOUT" default:"60s"`
+}
+
+// Endpoint returns BaseURL, or the Vertex AI endpoint for Region when it is
+// empty; the global region has no regional host.
+func (c VertexConfig) Endpoint() string {
+	if c.BaseURL != "" {
+		return c.BaseURL
+	}
+	if c.Region == "global" {
+		return "https://aiplatform.googleapis.com"
+	}
+	return "https://" + c.Region + "-aiplatform.googleapis.com"
+}
+
+// SupportsThinking reports whether model matches one of ThinkingModels.
+func (c AnthropicConfig) SupportsThinking(model string) bool {
+	for _, prefix := range c.ThinkingModels {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIVersionFor returns the anthropic-version to send for model: that of the
+// longest ModelAPIVersions entry matching it, or APIVersion.
+func (c AnthropicConfig) APIVersionFor(model string) string {
+	best, version := -1, c.APIVersion
+	for prefix, value := range c.ModelAPIVersions {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, version = len(prefix), value
+		}
+	}
+	return version
+}
+
+// AllowsAPIVersion reports whether a client may ask for version: the
+// default, one of APIVersions or a model's default.
+func (c AnthropicConfig) AllowsAPIVersion(version string) bool {
+	if version == c.APIVersion || slices.Contains(c.APIVersions, version) {
+		return true
+	}
+	for _, value := range c.ModelAPIVersions {
+		if value == version {
+			return true
+		}
+	}
+	return false
+}
+
+// EstimateCost returns the cost in USD of a request to model, using the
+// longest matching price prefix for each direction. It reports false when
+// either price is unknown.
+func (c AnthropicConfig) EstimateCost(model string, inputTokens, outputTokens int) (float64, bool) {
+	input, ok := priceFor(c.InputPrices, model)
+	if !ok {
+		return 0, false
+	}
+	output, ok := priceFor(c.OutputPrices, model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(inputTokens)*input + float64(outputTokens)*output) / 1e6, true
+}
+
+// CheapestModel returns the priced model, by its price prefix, whose input
+// and output prices add up to the least, or "" when none has both.
+func (c AnthropicConfig) CheapestModel() string {
+	cheapest, lowest := "", 0.0
+	for model, input := range c.InputPrices {
+		output, ok := priceFor(c.OutputPrices, model)
+		if !ok {
+			continue
+		}
+		if cost := input + output; cheapest == "" || cost < lowest || cost == lowest && model < cheapest {
+			cheapest, lowest = model, cost
+		}
+	}
+	return cheapest
+}
+
+func priceFor(prices map[string]float64, model string) (float64, bool) {
+	best, found := "", false
+	for prefix := range prices {
+		if strings.HasPrefix(model, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	return prices[best], found
+}
+
+// KeyPrefixes splits KeyPrefix into its comma-separated prefixes.
+func (c AnthropicConfig) KeyPrefixes() []string {
+	var prefixes []string
+	for _, prefix := range strings.Split(c.KeyPrefix, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// KeyRegexp compiles KeyPattern anchored to the whole key, or returns nil when
+// no pattern is set.
+func (c AnthropicConfig) KeyRegexp() (*regexp.Regexp, error) {
+	if c.KeyPattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + c.KeyPattern + ")$")
+}
+
+var stopSequenceEscapes = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t")
+
+// StopSequenceList returns StopSequences with \n, \t and \\ escapes decoded,
+// since environment values are trimmed and stop sequences usually start with
+// a newline (e.g. "\n\nUser:").
+func (c AnthropicConfig) StopSequenceList() []string {
+	list := make([]string, len(c.StopSequences))
+	for i, s := range c.StopSequences {
+		list[i] = stopSequenceEscapes.Replace(s)
+	}
+	return list
+}
+
+// ModelLimits maps model IDs or ID prefixes to a limit.
+type ModelLimits map[string]int
+
+// For returns the limit of the longest entry matching model, or 0 if none
+// does.
+func (l ModelLimits) For(model string) int {
+	best, limit := -1, 0
+	for prefix, value := range l {
+		if strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, limit = len(prefix), value
+		}
+	}
+	return limit
 }
 
 type ValidationConfig struct {
@@ -62,19 +437,501 @@ type ValidationConfig struct {
 	MaxFileSize      int `env:"MAX_FILE_SIZE" default:"10485760"` // 10MB
 }
 
+// BodyLimitsConfig caps request bodies before handlers read them. Routes maps
+// path prefixes to a maximum in bytes, the longest matching prefix winning;
+// other requests get MaxRequestBody. A limit of 0 leaves the route to the
+// handler's own limit, as for uploads, which are capped at MAX_FILE_SIZE.
+type BodyLimitsConfig struct {
+	MaxRequestBody int64            `env:"MAX_REQUEST_BODY" default:"2097152"` // 2MB
+	Routes         map[string]int64 `env:"MAX_REQUEST_BODY_ROUTES" default:"/api/models=1024,/api/config=1024,/api/i18n=1024,/api/events=1024,/api/batches=5242880,/api/messages/parts=0,/api/transcribe=0,/anthropic/=0"`
+}
+
+// For returns the limit for path and the Routes prefix it came from, or ""
+// for MaxRequestBody.
+func (c BodyLimitsConfig) For(path string) (int64, string) {
+	best, limit := "", c.MaxRequestBody
+	for prefix, value := range c.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(best) {
+			best, limit = prefix, value
+		}
+	}
+	return limit, best
+}
+
+type BrandingConfig struct {
+	Name            string `env:"BRAND_NAME" default:"Manto"`
+	ShortName       string `env:"BRAND_SHORT_NAME" default:"Manto"`
+	Description     string `env:"BRAND_DESCRIPTION" default:"Private AI Chat"`
+	ThemeColor      string `env:"BRAND_THEME_COLOR" default:"#6b46c1"`
+	BackgroundColor string `env:"BRAND_BACKGROUND_COLOR" default:"#0f0f10"`
+}
+
+// ErrorPagesConfig points at html/template files used in place of the
+// built-in 404 and 500 pages for static routes. Empty uses the defaults.
+type ErrorPagesConfig struct {
+	NotFoundTemplate    string `env:"ERROR_PAGE_NOT_FOUND_TEMPLATE"`
+	ServerErrorTemplate string `env:"ERROR_PAGE_SERVER_ERROR_TEMPLATE"`
+}
+
+type I18nConfig struct {
+	DefaultLocale string `env:"DEFAULT_LOCALE" default:"en"`
+}
+
+// AnalyticsConfig controls the anonymous UI event counters. Setting
+// ANALYTICS_ENABLED=false opts the whole deployment out.
+type AnalyticsConfig struct {
+	Enabled bool `env:"ANALYTICS_ENABLED" default:"true"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `env:"METRICS_ENABLED" default:"true"`
+	Path    string `env:"METRICS_PATH" default:"/metrics"`
+}
+
+// TracingConfig joins the W3C trace context, the traceparent header, that
+// callers such as an instrumented ingress propagate. Manto records no spans
+// of its own: it links its latency metrics, as exemplars, and slow request
+// logs to the caller's trace.
+type TracingConfig struct {
+	Enabled bool `env:"TRACING_ENABLED" default:"false"`
+}
+
+// SLOConfig holds upstream calls, per provider and model, to two objectives:
+// AvailabilityTarget of them succeed, and LatencyTarget of the successful
+// ones finish within LatencyThreshold. How fast each objective's error budget
+// is burning is measured over ShortWindow and LongWindow, and an alert fires
+// when both burn at BurnRateThreshold or more with at least MinRequests
+// calls in the short window. Alerts are logged, and posted as JSON to
+// AlertWebhookURL when set.
+type SLOConfig struct {
+	Enabled            bool     `env:"SLO_ENABLED" default:"false"`
+	AvailabilityTarget float64  `env:"SLO_AVAILABILITY_TARGET" default:"0.99"`
+	LatencyTarget      float64  `env:"SLO_LATENCY_TARGET" default:"0.95"`
+	LatencyThreshold   Duration `env:"SLO_LATENCY_THRESHOLD" default:"30s"`
+	ShortWindow        Duration `env:"SLO_SHORT_WINDOW" default:"5m"`
+	LongWindow         Duration `env:"SLO_LONG_WINDOW" default:"1h"`
+	BurnRateThreshold  float64  `env:"SLO_BURN_RATE_THRESHOLD" default:"14.4"`
+	MinRequests        int      `env:"SLO_MIN_REQUESTS" default:"10"`
+	AlertWebhookURL    string   `env:"SLO_ALERT_WEBHOOK_URL" secret:"true"`
+}
+
+// RateLimitConfig caps requests per client on /api routes within a fixed
+// window. Callers matching ExemptIPs (addresses or CIDR ranges) or
+// ExemptSessions (anonymous session IDs) bypass it. Tokens, when positive,
+// also gives each client a bucket of that many output tokens, refilled over
+// TokensWindow, that every message request is charged its max_tokens from.
+type RateLimitConfig struct {
+	Enabled        bool     `env:"RATE_LIMIT_ENABLED" default:"true"`
+	Requests       int      `env:"RATE_LIMIT_REQUESTS" default:"60"`
+	Window         Duration `env:"RATE_LIMIT_WINDOW" default:"1m"`
+	Tokens         int      `env:"RATE_LIMIT_TOKENS" default:"0"`
+	TokensWindow   Duration `env:"RATE_LIMIT_TOKENS_WINDOW" default:"1m"`
+	ExemptIPs      []string `env:"RATE_LIMIT_EXEMPT_IPS"`
+	ExemptSessions []string `env:"RATE_LIMIT_EXEMPT_SESSIONS"`
+}
+
+// ParseIPPrefix parses an IP address or CIDR range. A bare address becomes a
+// single-address prefix.
+func ParseIPPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ErrorTrackingConfig forwards panic reports as JSON to an external error
+// tracker webhook. Empty disables forwarding.
+type ErrorTrackingConfig struct {
+	URL string `env:"ERROR_TRACKER_URL" secret:"true"`
+}
+
+// AdminConfig controls the operational listener serving health, metrics,
+// pprof and the admin API. It binds to localhost unless told otherwise.
+// With an AnthropicAdminKey, read-only Anthropic Admin API calls are proxied
+// for callers presenting Token as a bearer token.
+type AdminConfig struct {
+	Enabled           bool   `env:"ADMIN_ENABLED" default:"true"`
+	Host              string `env:"ADMIN_HOST" default:"127.0.0.1"`
+	Port              int    `env:"ADMIN_PORT" default:"9090"`
+	EnablePprof       bool   `env:"ADMIN_PPROF_ENABLED" default:"true"`
+	Token             string `env:"ADMIN_API_TOKEN" secret:"true"`
+	AnthropicAdminKey string `env:"ANTHROPIC_ADMIN_KEY" secret:"true"`
+}
+
+// GRPCConfig enables the gRPC API (proto/manto/v1/manto.proto) on its own
+// listener. It speaks HTTP/2 without TLS, so it is meant for internal
+// networks or a TLS-terminating proxy, and shares the rate limit and access
+// gate with the HTTP API.
+type GRPCConfig struct {
+	Enabled bool   `env:"GRPC_ENABLED" default:"false"`
+	Host    string `env:"GRPC_HOST" default:"0.0.0.0"`
+	Port    int    `env:"GRPC_PORT" default:"9091"`
+}
+
+// PassthroughConfig enables /anthropic/v1/*, which forwards requests on
+// Paths to the upstream API unchanged, so official SDKs can be pointed at
+// Manto. A path ending in /* allows everything below it.
+type PassthroughConfig struct {
+	Enabled bool     `env:"PASSTHROUGH_ENABLED" default:"false"`
+	Paths   []string `env:"PASSTHROUGH_PATHS" default:"/v1/messages,/v1/messages/count_tokens,/v1/models,/v1/models/*"`
+}
+
+// Allows reports whether path may be forwarded.
+func (c PassthroughConfig) Allows(path string) bool {
+	for _, allowed := range c.Paths {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(path, prefix+"/") || path == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// DebugConfig enables capture of sanitized upstream exchanges, viewable on
+// the admin listener. Keys are redacted and bodies cut at CaptureMaxBody
+// bytes, but prompts and answers are kept, so leave it off in production
+// unless chasing a problem. ReplayEnabled likewise keeps the payloads of the
+// last ReplaySize failed message requests, in ReplayFile when set, so they
+// can be sent again from the admin listener.
+type DebugConfig struct {
+	CaptureEnabled bool   `env:"DEBUG_CAPTURE_ENABLED" default:"false"`
+	CaptureSize    int    `env:"DEBUG_CAPTURE_SIZE" default:"50"`
+	CaptureMaxBody int    `env:"DEBUG_CAPTURE_MAX_BODY" default:"8192"`
+	ReplayEnabled  bool   `env:"DEBUG_REPLAY_ENABLED" default:"false"`
+	ReplaySize     int    `env:"DEBUG_REPLAY_SIZE" default:"100"`
+	ReplayFile     string `env:"DEBUG_REPLAY_FILE"`
+}
+
+// ConversationsConfig enables opt-in server-side conversation history, which
+// regeneration and branching build on. With no file, history is kept in memory
+// and lost on restart. RequireIfMatch refuses changes that don't say, with
+// If-Match, which version of the conversation they were made against.
+type ConversationsConfig struct {
+	Enabled        bool   `env:"CONVERSATIONS_ENABLED" default:"false"`
+	File           string `env:"CONVERSATIONS_FILE"`
+	RequireIfMatch bool   `env:"CONVERSATIONS_REQUIRE_IF_MATCH" default:"false"`
+}
+
+// ArchiveConfig moves conversations left untouched for After (pinned ones
+// excepted) out of the conversation store into JSON lines objects in an
+// S3-compatible bucket, checking every Interval. Endpoint is the storage
+// API's origin; requests use path-style addressing, which AWS, MinIO and
+// most compatible stores accept. With EncryptionKey, a base64-encoded 32-byte
+// key, objects are sealed with AES-256-GCM before upload. Archived
+// conversations are rehydrated on demand.
+type ArchiveConfig struct {
+	Enabled         bool     `env:"ARCHIVE_ENABLED" default:"false"`
+	After           Duration `env:"ARCHIVE_AFTER" default:"90d"`
+	Interval        Duration `env:"ARCHIVE_INTERVAL" default:"24h"`
+	Endpoint        string   `env:"ARCHIVE_S3_ENDPOINT"`
+	Region          string   `env:"ARCHIVE_S3_REGION" default:"us-east-1"`
+	Bucket          string   `env:"ARCHIVE_S3_BUCKET"`
+	Prefix          string   `env:"ARCHIVE_S3_PREFIX" default:"conversations/"`
+	AccessKeyID     string   `env:"ARCHIVE_S3_ACCESS_KEY_ID"`
+	SecretAccessKey string   `env:"ARCHIVE_S3_SECRET_ACCESS_KEY" secret:"true"`
+	EncryptionKey   string   `env:"ARCHIVE_ENCRYPTION_KEY" secret:"true"`
+	Timeout         Duration `env:"ARCHIVE_TIMEOUT" default:"60s"`
+}
+
+// SessionConfig controls anonymous signed sessions: an HttpOnly cookie issued
+// on first visit that scopes rate limits, usage counters and, optionally,
+// stored conversations to a browser without accounts.
+type SessionConfig struct {
+	Enabled            bool     `env:"SESSION_ENABLED" default:"false"`
+	Secret             string   `env:"SESSION_SECRET" secret:"true"`
+	CookieName         string   `env:"SESSION_COOKIE_NAME" default:"manto_session"`
+	MaxAge             Duration `env:"SESSION_MAX_AGE" default:"30d"`
+	ScopeConversations bool     `env:"SESSION_SCOPE_CONVERSATIONS" default:"false"`
+}
+
+// AccessConfig gates every /api route behind shared access codes (for example
+// one per invited friend). Empty leaves the API open.
+type AccessConfig struct {
+	Codes []string `env:"ACCESS_CODES" secret:"true"`
+}
+
+// QueueConfig caps concurrent upstream message requests. Requests beyond the
+// cap wait in a queue shared fairly across clients and are answered with 202
+// and a poll URL reporting their position. A concurrency of 0 disables the
+// limit.
+type QueueConfig struct {
+	Concurrency int      `env:"UPSTREAM_MAX_CONCURRENCY" default:"0"`
+	MaxWaiting  int      `env:"UPSTREAM_QUEUE_SIZE" default:"100"`
+	ResultTTL   Duration `env:"UPSTREAM_QUEUE_RESULT_TTL" default:"5m"`
+}
+
+// DNSConfig resolves the upstream's hostname through a cache, serving the
+// last answer when DNS fails, for CacheTTL (0 disables it). Pins maps
+// hostnames to fixed addresses as "host=ip|ip" entries, skipping DNS
+// entirely.
+type DNSConfig struct {
+	CacheTTL Duration `env:"UPSTREAM_DNS_CACHE_TTL" default:"0s"`
+	Pins     []string `env:"UPSTREAM_DNS_PINS"`
+}
+
+// ParseDNSPins parses "host=ip|ip" entries into addresses by lowercased
+// host.
+func ParseDNSPins(entries []string) (map[string][]string, error) {
+	pins := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		host, list, ok := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		if !ok || host == "" {
+			return nil, fmt.Errorf("invalid DNS pin %q (must be host=ip|ip)", entry)
+		}
+		for _, addr := range strings.Split(list, "|") {
+			addr = strings.TrimSpace(addr)
+			if _, err := netip.ParseAddr(addr); err != nil {
+				return nil, fmt.Errorf("invalid DNS pin %q: %q is not an IP address", entry, addr)
+			}
+			pins[host] = append(pins[host], addr)
+		}
+	}
+	return pins, nil
+}
+
+// TokensConfig tunes the local token estimator and the input budget enforced
+// on /api/messages. A MaxInputTokens of 0 disables the budget; with
+// TrimContext the oldest messages are dropped to fit instead of rejecting.
+// Requests estimated at HistoryHint tokens or more get a hint to compact
+// their history; 0 turns hints off. Exact counts fetched from the provider
+// are cached for CountCacheTTL.
+type TokensConfig struct {
+	CharsPerToken  float64  `env:"TOKEN_ESTIMATE_CHARS_PER_TOKEN" default:"4"`
+	MaxInputTokens int      `env:"TOKEN_MAX_INPUT" default:"0"`
+	TrimContext    bool     `env:"TOKEN_TRIM_CONTEXT" default:"false"`
+	HistoryHint    int      `env:"TOKEN_HISTORY_HINT" default:"100000"`
+	CountCacheTTL  Duration `env:"TOKEN_COUNT_CACHE_TTL" default:"5m"`
+	CountCacheSize int      `env:"TOKEN_COUNT_CACHE_SIZE" default:"1000"`
+}
+
+// ModelsConfig caches each API key's model list for CacheTTL; 0 asks the
+// provider on every request.
+type ModelsConfig struct {
+	CacheTTL Duration `env:"MODELS_CACHE_TTL" default:"10m"`
+}
+
+// BatchConfig enables the CSV prompt runner. Each batch sends at most
+// Concurrency rows at once, and its results are kept for ResultTTL after it
+// finishes.
+type BatchConfig struct {
+	Enabled     bool     `env:"BATCH_ENABLED" default:"false"`
+	Concurrency int      `env:"BATCH_CONCURRENCY" default:"4"`
+	MaxRows     int      `env:"BATCH_MAX_ROWS" default:"500"`
+	ResultTTL   Duration `env:"BATCH_RESULT_TTL" default:"1h"`
+}
+
+// EvalsConfig enables the prompt evaluation runner. Reports are kept in
+// memory, and also written to File when it is set.
+type EvalsConfig struct {
+	Enabled     bool   `env:"EVALS_ENABLED" default:"false"`
+	File        string `env:"EVALS_FILE"`
+	Concurrency int    `env:"EVALS_CONCURRENCY" default:"4"`
+	MaxCases    int    `env:"EVALS_MAX_CASES" default:"200"`
+}
+
+// SummarizeConfig enables the document summarizer. Documents up to MaxChars
+// are split into chunks of about ChunkTokens, summarized Concurrency at a
+// time, and the summaries combined into one. Model is used when a request
+// doesn't name one, and defaults to ANTHROPIC_DEFAULT_MODEL.
+type SummarizeConfig struct {
+	Enabled     bool   `env:"SUMMARIZE_ENABLED" default:"false"`
+	Model       string `env:"SUMMARIZE_MODEL"`
+	ChunkTokens int    `env:"SUMMARIZE_CHUNK_TOKENS" default:"4000"`
+	MaxChars    int    `env:"SUMMARIZE_MAX_CHARS" default:"400000"`
+	Concurrency int    `env:"SUMMARIZE_CONCURRENCY" default:"2"`
+}
+
+// TitleConfig configures title generation. Model defaults to the cheapest
+// model priced in ANTHROPIC_INPUT_PRICES and ANTHROPIC_OUTPUT_PRICES, then to
+// ANTHROPIC_DEFAULT_MODEL. The endpoint allows RateLimitRequests per
+// RateLimitWindow whether or not the general rate limit is on, or any
+// number with 0. With Conversations, conversations created without a title
+// get one after their first reply.
+type TitleConfig struct {
+	Model             string   `env:"TITLE_MODEL"`
+	RateLimitRequests int      `env:"TITLE_RATE_LIMIT_REQUESTS" default:"10"`
+	RateLimitWindow   Duration `env:"TITLE_RATE_LIMIT_WINDOW" default:"1m"`
+	Conversations     bool     `env:"TITLE_CONVERSATIONS" default:"false"`
+}
+
+// SpeechConfig configures voice input and read-aloud. STTProvider is empty
+// (off), openai for the OpenAI transcription API or whispercpp for a
+// whisper.cpp server; uploads are limited to MAX_FILE_SIZE. TTSProvider is
+// empty (off), openai for the OpenAI speech API (or a server compatible with
+// it) or elevenlabs; TTSModel and TTSVoice default per provider, and
+// ElevenLabs needs TTSVoice set to a voice ID. URLs default to the
+// provider's usual address.
+type SpeechConfig struct {
+	STTProvider string   `env:"STT_PROVIDER"`
+	STTURL      string   `env:"STT_URL"`
+	STTAPIKey   string   `env:"STT_API_KEY" secret:"true"`
+	STTModel    string   `env:"STT_MODEL" default:"whisper-1"`
+	STTTimeout  Duration `env:"STT_TIMEOUT" default:"60s"`
+
+	TTSProvider string   `env:"TTS_PROVIDER"`
+	TTSURL      string   `env:"TTS_URL"`
+	TTSAPIKey   string   `env:"TTS_API_KEY" secret:"true"`
+	TTSModel    string   `env:"TTS_MODEL"`
+	TTSVoice    string   `env:"TTS_VOICE"`
+	TTSFormat   string   `env:"TTS_FORMAT" default:"mp3"`
+	TTSMaxChars int      `env:"TTS_MAX_CHARS" default:"4096"`
+	TTSTimeout  Duration `env:"TTS_TIMEOUT" default:"60s"`
+}
+
+// PartsConfig enables chunked message submission: long messages are
+// uploaded in segments of up to MAX_FILE_SIZE bytes and then referred to by
+// upload ID. The assembled text is still held to MAX_MESSAGE_LENGTH. Each API
+// key may have MaxUploads in progress, kept for TTL after last use.
+type PartsConfig struct {
+	Enabled    bool     `env:"MESSAGE_PARTS_ENABLED" default:"false"`
+	TTL        Duration `env:"MESSAGE_PARTS_TTL" default:"15m"`
+	MaxUploads int      `env:"MESSAGE_PARTS_MAX_UPLOADS" default:"4"`
+}
+
+// ttsFormats are the audio formats TTS_FORMAT may name.
+var ttsFormats = []string{"mp3", "opus", "aac", "flac", "wav"}
+
+// ModerationConfig configures checks on prompts (the input stage) and
+// answers (the output stage). Keyword lists and patterns exist per action:
+// block refuses the content, redact masks the match, and flag only logs it.
+// An external moderation API, when set, applies APIAction to anything it
+// flags.
+type ModerationConfig struct {
+	Stages         []string `env:"MODERATION_STAGES" default:"input,output"`
+	BlockKeywords  []string `env:"MODERATION_BLOCK_KEYWORDS"`
+	RedactKeywords []string `env:"MODERATION_REDACT_KEYWORDS"`
+	FlagKeywords   []string `env:"MODERATION_FLAG_KEYWORDS"`
+	BlockPattern   string   `env:"MODERATION_BLOCK_PATTERN"`
+	RedactPattern  string   `env:"MODERATION_REDACT_PATTERN"`
+	FlagPattern    string   `env:"MODERATION_FLAG_PATTERN"`
+	APIURL         string   `env:"MODERATION_API_URL"`
+	APIKey         string   `env:"MODERATION_API_KEY" secret:"true"`
+	APIAction      string   `env:"MODERATION_API_ACTION" default:"block"`
+	FailClosed     bool     `env:"MODERATION_FAIL_CLOSED" default:"false"`
+}
+
+// PIIConfig configures personal data detection in prompts before they leave
+// for the upstream API. Action is empty (off), block, mask or warn; warn lets
+// the prompt through but logs and reports it. Pattern adds a custom regular
+// expression to the built-in detectors.
+type PIIConfig struct {
+	Action    string   `env:"PII_ACTION"`
+	Detectors []string `env:"PII_DETECTORS" default:"email,phone,card"`
+	Pattern   string   `env:"PII_PATTERN"`
+}
+
+// SecretsConfig configures credential scanning in prompts. Action is empty
+// (off), block or warn. Tokens of 24 or more characters are reported when
+// their entropy reaches MinEntropy bits per character; zero turns that
+// heuristic off. With AllowOverride, clients may send X-Manto-Allow-Secrets:
+// true to downgrade findings to a warning.
+type SecretsConfig struct {
+	Action        string  `env:"SECRET_SCAN_ACTION"`
+	MinEntropy    float64 `env:"SECRET_SCAN_MIN_ENTROPY" default:"4.5"`
+	AllowOverride bool    `env:"SECRET_SCAN_ALLOW_OVERRIDE" default:"false"`
+}
+
+// DLPConfig configures the audit trail of moderation actions. Entries hold
+// a hash of the content (HMAC-SHA256 under HashKey when set) and, with
+// Snippets, a short excerpt with every finding masked. The latest MaxEntries
+// are kept in memory for export; File, when set, keeps all of them.
+type DLPConfig struct {
+	Enabled    bool   `env:"DLP_AUDIT_ENABLED" default:"false"`
+	File       string `env:"DLP_AUDIT_FILE"`
+	MaxEntries int    `env:"DLP_AUDIT_MAX_ENTRIES" default:"10000"`
+	Snippets   bool   `env:"DLP_AUDIT_SNIPPETS" default:"false"`
+	HashKey    string `env:"DLP_AUDIT_HASH_KEY" secret:"true"`
+}
+
+// JournalConfig configures the request journal: an append-only JSON lines
+// file with an entry for every message request, so spend can be reconciled
+// when metrics are lost. Once File would grow past MaxSize bytes it is
+// rotated to File.1, the older files moving up to File.<MaxFiles>; a MaxSize
+// of 0 never rotates.
+type JournalConfig struct {
+	Enabled  bool   `env:"JOURNAL_ENABLED" default:"false"`
+	File     string `env:"JOURNAL_FILE"`
+	MaxSize  int64  `env:"JOURNAL_MAX_SIZE" default:"104857600"` // 100MB
+	MaxFiles int    `env:"JOURNAL_MAX_FILES" default:"10"`
+}
+
+// StatusConfig controls the status block /config.js carries for the UI's
+// banner. Maintenance is declared here; outages and quota exhaustion are
+// reported after FailureThreshold consecutive upstream failures of the kind.
+type StatusConfig struct {
+	Maintenance        bool   `env:"MAINTENANCE_MODE" default:"false"`
+	MaintenanceMessage string `env:"MAINTENANCE_MESSAGE"`
+	FailureThreshold   int    `env:"STATUS_FAILURE_THRESHOLD" default:"5"`
+}
+
+// ExperimentsConfig points at a JSON definition of the A/B experiment to run.
+// Variants are assigned per session, so sessions must be enabled.
+type ExperimentsConfig struct {
+	File string `env:"EXPERIMENTS_FILE"`
+}
+
+// TenantsConfig points at a JSON definition of the tenants one process
+// hosts. Requests name their tenant in Header, or with a /t/{tenant} path
+// prefix when Mode is "path".
+type TenantsConfig struct {
+	File     string `env:"TENANTS_FILE"`
+	Mode     string `env:"TENANT_MODE" default:"header"`
+	Header   string `env:"TENANT_HEADER" default:"X-Manto-Tenant"`
+	Required bool   `env:"TENANT_REQUIRED" default:"false"`
+}
+
+// OverridesConfig is where runtime overrides set through the admin API are
+// kept. Without a file they last until the server restarts.
+type OverridesConfig struct {
+	File string `env:"OVERRIDES_FILE"`
+}
+
+// AnnouncementsConfig is where scheduled UI banners are kept. The file can be
+// edited before startup or managed through the admin API.
+type AnnouncementsConfig struct {
+	File string `env:"ANNOUNCEMENTS_FILE"`
+}
+
 func Load() (*Config, error) {
-	cfg := &Config{}
+	cfg := &Config{Environment: GetEnvironment()}
 
-	loadEnvFiles()
+	cfg.envFiles = loadEnvFiles()
+
+	remote, source, err := loadRemote()
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil {
+		cfg.remote = remote
+		cfg.envFiles = append(cfg.envFiles, *source)
+	}
 
 	if err := setDefaults(cfg); err != nil {
 		return nil, fmt.Errorf("failed to set defaults: %w", err)
 	}
 
+	strict, err := strictMode()
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err := loadStrict(cfg); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+
 	if err := loadFromEnv(cfg); err != nil {
 		return nil, fmt.Errorf("failed to load environment variables: %w", err)
 	}
 
+	deriveAPIEndpoints(cfg)
 	if err := validate(cfg); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
@@ -82,39 +939,309 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
-func loadEnvFiles() {
-	env := GetEnvironment()
+func GetEnvironment() string {
+	env := os.Getenv("GO_ENV")
+	if env == "" {
+		env = os.Getenv("ENVIRONMENT")
+	}
+	if env == "" {
+		env = "production"
+	}
+	return strings.ToLower(env)
+}
+
+// ProviderNames lists every configured provider by name: Anthropic, the
+// built-in ones enabled by their own settings, then Providers.
+func (c *Config) ProviderNames() []string {
+	names := []string{"anthropic"}
+	for _, builtin := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"openai", c.OpenAI.Enabled},
+		{"openrouter", c.OpenRouter.Enabled},
+		{"ollama", c.Ollama.Enabled},
+		{"vertex", c.Vertex.Enabled},
+	} {
+		if builtin.enabled {
+			names = append(names, builtin.name)
+		}
+	}
+	for _, p := range c.Providers {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// DefaultModel returns the model the UI preselects for the named provider,
+// or "" to let the UI pick one.
+func (c *Config) DefaultModel(provider string) string {
+	if provider == "anthropic" {
+		return c.Anthropic.DefaultModel
+	}
+	for _, p := range c.Providers {
+		if p.Name == provider {
+			return p.DefaultModel
+		}
+	}
+	return ""
+}
+
+// providerBaseURLs lists the base URLs of the configured providers.
+func (c *Config) providerBaseURLs() []string {
+	urls := []string{c.Anthropic.BaseURL}
+	if c.OpenAI.Enabled {
+		urls = append(urls, c.OpenAI.BaseURL)
+	}
+	if c.OpenRouter.Enabled {
+		urls = append(urls, c.OpenRouter.BaseURL)
+	}
+	if c.Ollama.Enabled {
+		urls = append(urls, c.Ollama.BaseURL)
+	}
+	if c.Vertex.Enabled {
+		urls = append(urls, c.Vertex.Endpoint(), c.Vertex.TokenURL)
+	}
+	for _, p := range c.Providers {
+		if p.BaseURL != "" {
+			urls = append(urls, p.BaseURL)
+		}
+	}
+	return urls
+}
+
+// APIEndpoints returns the origin of every provider base URL followed by
+// Security.AllowedAPIEndpoints, without duplicates.
+func (c *Config) APIEndpoints() []string {
+	endpoints := []string{}
+	for _, raw := range c.providerBaseURLs() {
+		if origin := originOf(raw); !slices.Contains(endpoints, origin) {
+			endpoints = append(endpoints, origin)
+		}
+	}
+	for _, endpoint := range c.Security.AllowedAPIEndpoints {
+		if !slices.Contains(endpoints, endpoint) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// deriveAPIEndpoints sets Security.AllowedAPIEndpoints, which feeds the CSP
+// connect-src and the egress allow-list, to APIEndpoints, so operators only
+// list endpoints beyond the providers themselves.
+func deriveAPIEndpoints(cfg *Config) {
+	cfg.Security.AllowedAPIEndpoints = cfg.APIEndpoints()
+}
 
-	envFiles := []string{
-		fmt.Sprintf(".env.%s.local", env),
-		fmt.Sprintf(".env.%s", env),
-		".env.local",
-		".env",
+// originOf returns raw's scheme and host, or raw itself if it doesn't parse
+// as an absolute URL.
+func originOf(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return raw
+}
+
+// checkEndpoint validates an upstream URL setting. It must be an absolute
+// https URL without credentials, query or fragment, or plain http on a
+// loopback host or when insecure is set. Only base URLs may have a path,
+// and not a trailing slash, since API paths are appended to them.
+func checkEndpoint(setting, raw string, base, insecure bool) error {
+	example := "https://api.example.com"
+	if base {
+		example = "https://gateway.example.com/anthropic"
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Opaque != "" {
+		return fmt.Errorf("invalid %s: %q (must be an absolute URL such as %s)", setting, raw, example)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" || strings.HasSuffix(raw, "?") {
+		return fmt.Errorf("invalid %s: %q (must not contain credentials, a query or a fragment)", setting, raw)
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && (insecure || isLoopback(u.Hostname())):
+	case u.Scheme == "http":
+		return fmt.Errorf("invalid %s: %q (must use https; set ALLOW_INSECURE_ENDPOINTS=true to allow plain http)", setting, raw)
+	default:
+		return fmt.Errorf("invalid %s: %q (scheme must be https)", setting, raw)
+	}
+	if !base && u.Path != "" {
+		return fmt.Errorf("invalid %s: %q (must be an origin without a path, e.g. %s)", setting, raw, originOf(raw))
+	}
+	if base && strings.HasSuffix(u.Path, "/") {
+		return fmt.Errorf("invalid %s: %q (remove the trailing slash: %s)", setting, raw, strings.TrimRight(raw, "/"))
 	}
+	return nil
+}
+
+// validateProviders checks the registry's entries and that PROVIDER_DEFAULT
+// names a configured provider.
+func validateProviders(cfg *Config, insecure bool) error {
+	names := cfg.ProviderNames()
+	builtins := len(names) - len(cfg.Providers)
+	for i, p := range cfg.Providers {
+		if !providerName.MatchString(p.Name) {
+			return fmt.Errorf("invalid PROVIDERS_%d_NAME: %q (must be lowercase letters, digits, dashes and underscores)", i, p.Name)
+		}
+		if slices.Index(names, p.Name) < builtins+i {
+			return fmt.Errorf("invalid PROVIDERS_%d_NAME: %q (already names another provider)", i, p.Name)
+		}
+		if !slices.Contains(providerTypes, p.Type) {
+			return fmt.Errorf("invalid PROVIDERS_%d_TYPE: %q (must be one of %s)", i, p.Type, strings.Join(providerTypes, ", "))
+		}
+		if p.Type == "openai" && p.BaseURL == "" {
+			return fmt.Errorf("invalid PROVIDERS_%d_BASE_URL: empty (must be set for an openai provider)", i)
+		}
+		if p.BaseURL != "" {
+			if err := checkEndpoint(fmt.Sprintf("PROVIDERS_%d_BASE_URL", i), p.BaseURL, true, insecure); err != nil {
+				return err
+			}
+		}
+	}
+	if !slices.Contains(names, cfg.Provider.Default) {
+		return fmt.Errorf("invalid default provider: %q (must be one of %s)", cfg.Provider.Default, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// validateVertex checks the Vertex AI settings.
+func validateVertex(v VertexConfig, insecure bool) error {
+	if v.ProjectID == "" {
+		return fmt.Errorf("invalid Vertex project: empty (must be set when VERTEX_ENABLED is true)")
+	}
+	if v.CredentialsFile == "" {
+		return fmt.Errorf("invalid Vertex credentials file: empty (must be a service account key file when VERTEX_ENABLED is true)")
+	}
+	if !regionPattern.MatchString(v.Region) {
+		return fmt.Errorf("invalid Vertex region: %q (must be a region such as us-east5, europe-west1 or global)", v.Region)
+	}
+	if err := checkEndpoint("VERTEX_BASE_URL", v.Endpoint(), true, insecure); err != nil {
+		return err
+	}
+	if err := checkEndpoint("VERTEX_TOKEN_URL", v.TokenURL, true, insecure); err != nil {
+		return err
+	}
+	if len(v.Models) == 0 {
+		return fmt.Errorf("invalid Vertex models: empty (must list at least one model)")
+	}
+	if v.Timeout.Duration <= 0 {
+		return fmt.Errorf("invalid Vertex timeout: %s (must be positive)", v.Timeout)
+	}
+	return nil
+}
+
+// regionPattern matches a Google Cloud region name.
+var regionPattern = regexp.MustCompile(`^[a-z]+(-[a-z]+[0-9]*)*$`)
+
+// validateSLO checks the SLO targets, windows and alerting settings.
+func validateSLO(s SLOConfig) error {
+	if s.AvailabilityTarget <= 0 || s.AvailabilityTarget >= 1 {
+		return fmt.Errorf("invalid SLO availability target: %g (must be between 0 and 1, e.g. 0.99)", s.AvailabilityTarget)
+	}
+	if s.LatencyTarget <= 0 || s.LatencyTarget >= 1 {
+		return fmt.Errorf("invalid SLO latency target: %g (must be between 0 and 1, e.g. 0.95)", s.LatencyTarget)
+	}
+	if s.LatencyThreshold.Duration <= 0 {
+		return fmt.Errorf("invalid SLO latency threshold: %s (must be positive)", s.LatencyThreshold.Duration)
+	}
+	if s.ShortWindow.Duration <= 0 || s.LongWindow.Duration <= s.ShortWindow.Duration {
+		return fmt.Errorf("invalid SLO windows: %s and %s (the short window must be positive and below the long one)", s.ShortWindow.Duration, s.LongWindow.Duration)
+	}
+	if s.BurnRateThreshold <= 0 {
+		return fmt.Errorf("invalid SLO burn rate threshold: %g (must be positive)", s.BurnRateThreshold)
+	}
+	if s.MinRequests < 0 {
+		return fmt.Errorf("invalid SLO min requests: %d (must not be negative)", s.MinRequests)
+	}
+	if s.AlertWebhookURL != "" {
+		if u, err := url.Parse(s.AlertWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid SLO alert webhook URL (must be an http or https URL)")
+		}
+	}
+	return nil
+}
+
+// validateArchive checks the archival settings. The endpoint is checked with
+// the other endpoints.
+func validateArchive(cfg *Config) error {
+	a := cfg.Archive
+	if !a.Enabled {
+		return nil
+	}
+	if !cfg.Conversations.Enabled {
+		return fmt.Errorf("invalid archive settings: ARCHIVE_ENABLED needs CONVERSATIONS_ENABLED=true")
+	}
+	if a.Bucket == "" || a.Region == "" || a.AccessKeyID == "" || a.SecretAccessKey == "" {
+		return fmt.Errorf("invalid archive settings: ARCHIVE_S3_BUCKET, ARCHIVE_S3_REGION, ARCHIVE_S3_ACCESS_KEY_ID and ARCHIVE_S3_SECRET_ACCESS_KEY must be set")
+	}
+	if a.After.Duration <= 0 || a.Interval.Duration <= 0 || a.Timeout.Duration <= 0 {
+		return fmt.Errorf("invalid archive schedule: after %s, every %s with a %s timeout (must all be positive)", a.After, a.Interval, a.Timeout)
+	}
+	if a.EncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(a.EncryptionKey); err != nil || len(key) != 32 {
+			return fmt.Errorf("invalid archive encryption key: must be 32 bytes encoded in base64 (e.g. openssl rand -base64 32)")
+		}
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+// IsDevelopment reports whether the server is running with GO_ENV=development,
+// which serves static files from disk with live reload and a relaxed CSP.
+func (c *Config) IsDevelopment() bool {
+	return c.Environment == "development"
+}
+
+func loadFromEnv(cfg *Config) error {
+	return loadEnvVars(reflect.ValueOf(cfg).Elem(), reflect.TypeOf(cfg).Elem(), func(err error) error {
+		return err
+	})
+}
+
+// lookupEnv returns the value for name, preferring the MANTO_-prefixed form.
+func lookupEnv(name string) string {
+	if value := os.Getenv(envPrefix + name); value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+var (
+	durationType = reflect.TypeOf(Duration{})
+	timeType     = reflect.TypeOf(time.Time{})
+)
 
-	for _, file := range envFiles {
-		if _, err := os.Stat(file); err == nil {
-			_ = godotenv.Load(file)
-		}
-	}
+// isSection reports whether t is a struct of settings to recurse into, as
+// opposed to one parsed from a single value.
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != durationType && t != timeType
 }
 
-func GetEnvironment() string {
-	env := os.Getenv("GO_ENV")
-	if env == "" {
-		env = os.Getenv("ENVIRONMENT")
-	}
-	if env == "" {
-		env = "production"
-	}
-	return strings.ToLower(env)
+// isList reports whether t is a slice of sections, loaded from indexed
+// variables.
+func isList(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && isSection(t.Elem())
 }
 
-func loadFromEnv(cfg *Config) error {
-	return loadEnvVars(reflect.ValueOf(cfg).Elem(), reflect.TypeOf(cfg).Elem())
+// loadEnvVars sets fields from the environment. Parse failures are passed to
+// onError, which either aborts the load by returning the error or records it
+// and returns nil to keep going.
+func loadEnvVars(v reflect.Value, t reflect.Type, onError func(error) error) error {
+	return loadPrefixedEnvVars(v, t, "", onError)
 }
 
-func loadEnvVars(v reflect.Value, t reflect.Type) error {
+// loadPrefixedEnvVars is loadEnvVars for fields whose variables are named
+// prefix followed by their env tag.
+func loadPrefixedEnvVars(v reflect.Value, t reflect.Type, prefix string, onError func(error) error) error {
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
 		fieldType := t.Field(i)
@@ -123,8 +1250,8 @@ func loadEnvVars(v reflect.Value, t reflect.Type) error {
 			continue
 		}
 
-		if field.Kind() == reflect.Struct && fieldType.Type != reflect.TypeOf(Duration{}) {
-			if err := loadEnvVars(field, fieldType.Type); err != nil {
+		if isSection(fieldType.Type) {
+			if err := loadPrefixedEnvVars(field, fieldType.Type, prefix, onError); err != nil {
 				return err
 			}
 			continue
@@ -135,18 +1262,66 @@ func loadEnvVars(v reflect.Value, t reflect.Type) error {
 			continue
 		}
 
-		envValue := os.Getenv(envTag)
+		if isList(fieldType.Type) {
+			if err := loadList(field, prefix+envTag, onError); err != nil {
+				return err
+			}
+			continue
+		}
+
+		envValue := lookupEnv(prefix + envTag)
 		if envValue == "" {
 			continue
 		}
 
 		if err := setFieldFromString(field, envValue); err != nil {
-			return fmt.Errorf("failed to set field %s from env var %s: %w", fieldType.Name, envTag, err)
+			if err := onError(fmt.Errorf("failed to set field %s from env var %s: %w", fieldType.Name, prefix+envTag, err)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadList fills a slice of sections from indexed variables, e.g.
+// PROVIDERS_0_NAME, PROVIDERS_1_NAME, stopping at the first index with no
+// variables set. Each element starts from its fields' defaults. A list with
+// no elements set keeps its current value.
+func loadList(field reflect.Value, name string, onError func(error) error) error {
+	itemType := field.Type().Elem()
+	list := reflect.MakeSlice(field.Type(), 0, 0)
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("%s_%d_", name, i)
+		if !anyEnvWithPrefix(prefix) {
+			break
+		}
+		item := reflect.New(itemType).Elem()
+		if err := setDefaultValues(item, itemType); err != nil {
+			return err
 		}
+		if err := loadPrefixedEnvVars(item, itemType, prefix, onError); err != nil {
+			return err
+		}
+		list = reflect.Append(list, item)
+	}
+	if list.Len() > 0 {
+		field.Set(list)
 	}
 	return nil
 }
 
+// anyEnvWithPrefix reports whether any non-empty variable starts with
+// prefix, with or without the MANTO_ prefix.
+func anyEnvWithPrefix(prefix string) bool {
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if value != "" && (strings.HasPrefix(name, prefix) || strings.HasPrefix(name, envPrefix+prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
 func setDefaults(cfg *Config) error {
 	return setDefaultValues(reflect.ValueOf(cfg).Elem(), reflect.TypeOf(cfg).Elem())
 }
@@ -160,7 +1335,7 @@ func setDefaultValues(v reflect.Value, t reflect.Type) error {
 			continue
 		}
 
-		if field.Kind() == reflect.Struct && fieldType.Type != reflect.TypeOf(Duration{}) {
+		if isSection(fieldType.Type) {
 			if err := setDefaultValues(field, fieldType.Type); err != nil {
 				return err
 			}
@@ -179,20 +1354,75 @@ func setDefaultValues(v reflect.Value, t reflect.Type) error {
 	return nil
 }
 
+// setFieldFromString parses value into field. Slices are comma-separated
+// and maps are comma-separated key=value pairs, e.g. "haiku=4096,opus=8192";
+// their elements are parsed like single values.
 func setFieldFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.Slice:
+		if value == "" {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		items := strings.Split(value, ",")
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return fmt.Errorf("item %d: %w", i+1, err)
+			}
+		}
+		field.Set(slice)
+
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type: %s", field.Type().Key())
+		}
+		m := reflect.MakeMap(field.Type())
+		if value != "" {
+			for _, pair := range strings.Split(value, ",") {
+				key, item, ok := strings.Cut(pair, "=")
+				key = strings.TrimSpace(key)
+				if !ok || key == "" {
+					return fmt.Errorf("invalid entry %q (must be key=value)", strings.TrimSpace(pair))
+				}
+				elem := reflect.New(field.Type().Elem()).Elem()
+				if err := setValue(elem, strings.TrimSpace(item)); err != nil {
+					return fmt.Errorf("entry %s: %w", key, err)
+				}
+				m.SetMapIndex(reflect.ValueOf(key).Convert(field.Type().Key()), elem)
+			}
+		}
+		field.Set(m)
+
+	default:
+		return setValue(field, value)
+	}
+
+	return nil
+}
+
+// setValue parses a single value into field.
+func setValue(field reflect.Value, value string) error {
 	switch field.Kind() {
 	case reflect.String:
 		field.SetString(value)
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		intValue, err := strconv.ParseInt(value, 10, 64)
+		intValue, err := strconv.ParseInt(value, 10, field.Type().Bits())
 		if err != nil {
 			return err
 		}
 		field.SetInt(intValue)
 
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uintValue, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(uintValue)
+
 	case reflect.Float32, reflect.Float64:
-		floatValue, err := strconv.ParseFloat(value, 64)
+		floatValue, err := strconv.ParseFloat(value, field.Type().Bits())
 		if err != nil {
 			return err
 		}
@@ -205,46 +1435,186 @@ func setFieldFromString(field reflect.Value, value string) error {
 		}
 		field.SetBool(boolValue)
 
-	case reflect.Slice:
-		if field.Type().Elem().Kind() == reflect.String {
-			var slice []string
-			if value != "" {
-				slice = strings.Split(value, ",")
-				for i, v := range slice {
-					slice[i] = strings.TrimSpace(v)
-				}
-			}
-			field.Set(reflect.ValueOf(slice))
-		}
-
 	case reflect.Struct:
-		if field.Type() == reflect.TypeOf(Duration{}) {
-			duration, err := time.ParseDuration(value)
+		switch field.Type() {
+		case durationType:
+			duration, err := ParseDuration(value)
 			if err != nil {
 				return err
 			}
 			field.Set(reflect.ValueOf(Duration{duration}))
+		case timeType:
+			t, err := parseTime(value)
+			if err != nil {
+				return err
+			}
+			field.Set(reflect.ValueOf(t))
+		default:
+			return fmt.Errorf("unsupported field type: %s", field.Type())
 		}
 
 	default:
-		return fmt.Errorf("unsupported field type: %s", field.Kind())
+		return fmt.Errorf("unsupported field type: %s", field.Type())
 	}
 
 	return nil
 }
 
+// parseTime accepts RFC 3339 timestamps, or dates, taken as midnight UTC.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (must be RFC 3339 or YYYY-MM-DD)", value)
+	}
+	return t, nil
+}
+
 func validate(cfg *Config) error {
 	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
 		return fmt.Errorf("invalid server port: %d (must be between 1 and 65535)", cfg.Server.Port)
 	}
 
+	if cfg.Server.RequestTimeout.Duration < 0 {
+		return fmt.Errorf("invalid request timeout: %s (must not be negative)", cfg.Server.RequestTimeout.Duration)
+	}
+
+	if cfg.Admin.Enabled && (cfg.Admin.Port < 1 || cfg.Admin.Port > 65535) {
+		return fmt.Errorf("invalid admin port: %d (must be between 1 and 65535)", cfg.Admin.Port)
+	}
+
+	if cfg.Admin.Enabled && cfg.Admin.Port == cfg.Server.Port {
+		return fmt.Errorf("admin port %d must differ from the server port", cfg.Admin.Port)
+	}
+
+	if cfg.GRPC.Enabled && (cfg.GRPC.Port < 1 || cfg.GRPC.Port > 65535) {
+		return fmt.Errorf("invalid gRPC port: %d (must be between 1 and 65535)", cfg.GRPC.Port)
+	}
+
+	if cfg.GRPC.Enabled && (cfg.GRPC.Port == cfg.Server.Port || cfg.Admin.Enabled && cfg.GRPC.Port == cfg.Admin.Port) {
+		return fmt.Errorf("gRPC port %d must differ from the server and admin ports", cfg.GRPC.Port)
+	}
+
+	for _, p := range cfg.Passthrough.Paths {
+		if !strings.HasPrefix(p, "/v1/") {
+			return fmt.Errorf("invalid passthrough path: %q (must start with /v1/)", p)
+		}
+	}
+
+	if cfg.Debug.CaptureEnabled && (cfg.Debug.CaptureSize < 1 || cfg.Debug.CaptureMaxBody < 0) {
+		return fmt.Errorf("invalid debug capture size: %d exchanges of %d bytes (must keep at least one exchange)", cfg.Debug.CaptureSize, cfg.Debug.CaptureMaxBody)
+	}
+	if cfg.Debug.ReplayEnabled && cfg.Debug.ReplaySize < 1 {
+		return fmt.Errorf("invalid debug replay size: %d (must keep at least one failure)", cfg.Debug.ReplaySize)
+	}
+
+	if err := validateRemote(cfg.Remote); err != nil {
+		return err
+	}
+
+	insecure := cfg.Security.AllowInsecureEndpoints
+	if err := checkEndpoint("ANTHROPIC_BASE_URL", cfg.Anthropic.BaseURL, true, insecure); err != nil {
+		return err
+	}
+	if cfg.OpenAI.Enabled {
+		if err := checkEndpoint("OPENAI_BASE_URL", cfg.OpenAI.BaseURL, true, insecure); err != nil {
+			return err
+		}
+		if cfg.OpenAI.Timeout.Duration <= 0 {
+			return fmt.Errorf("invalid OpenAI timeout: %s (must be positive)", cfg.OpenAI.Timeout)
+		}
+	}
+	if cfg.OpenRouter.Enabled {
+		if err := checkEndpoint("OPENROUTER_BASE_URL", cfg.OpenRouter.BaseURL, true, insecure); err != nil {
+			return err
+		}
+		if cfg.OpenRouter.Timeout.Duration <= 0 {
+			return fmt.Errorf("invalid OpenRouter timeout: %s (must be positive)", cfg.OpenRouter.Timeout)
+		}
+	}
+	if cfg.Ollama.Enabled {
+		if err := checkEndpoint("OLLAMA_BASE_URL", cfg.Ollama.BaseURL, true, insecure); err != nil {
+			return err
+		}
+		if cfg.Ollama.Timeout.Duration <= 0 {
+			return fmt.Errorf("invalid Ollama timeout: %s (must be positive)", cfg.Ollama.Timeout)
+		}
+	}
+	if cfg.Vertex.Enabled {
+		if err := validateVertex(cfg.Vertex, insecure); err != nil {
+			return err
+		}
+	}
+	if err := validateProviders(cfg, insecure); err != nil {
+		return err
+	}
+	if cfg.Speech.STTURL != "" {
+		if err := checkEndpoint("STT_URL", cfg.Speech.STTURL, true, insecure); err != nil {
+			return err
+		}
+	}
+	if cfg.Speech.TTSURL != "" {
+		if err := checkEndpoint("TTS_URL", cfg.Speech.TTSURL, true, insecure); err != nil {
+			return err
+		}
+	}
+	if cfg.Archive.Enabled {
+		if err := checkEndpoint("ARCHIVE_S3_ENDPOINT", cfg.Archive.Endpoint, false, insecure); err != nil {
+			return err
+		}
+	}
+	for _, endpoint := range cfg.Security.AllowedAPIEndpoints {
+		if err := checkEndpoint("ALLOWED_API_ENDPOINTS entry", endpoint, false, insecure); err != nil {
+			return err
+		}
+	}
+	for _, raw := range cfg.providerBaseURLs() {
+		if !slices.Contains(cfg.Security.AllowedAPIEndpoints, originOf(raw)) {
+			return fmt.Errorf("provider base URL %q is not covered by ALLOWED_API_ENDPOINTS (add %s)", raw, originOf(raw))
+		}
+	}
+
+	for _, header := range []struct {
+		setting, value string
+		allowed        []string
+	}{
+		{"Referrer-Policy", cfg.Security.ReferrerPolicy, referrerPolicies},
+		{"X-Content-Type-Options", cfg.Security.ContentTypeOptions, contentTypeOptions},
+		{"X-Frame-Options", cfg.Security.FrameOptions, frameOptions},
+		{"Cross-Origin-Resource-Policy", cfg.Security.CrossOriginResourcePolicy, crossOriginResourcePolicies},
+	} {
+		if !slices.Contains(header.allowed, header.value) {
+			return fmt.Errorf("invalid %s: %q (must be one of %s)", header.setting, header.value, strings.Join(header.allowed, ", "))
+		}
+	}
+
 	if cfg.Security.APIKeyMinLength < 1 {
 		return fmt.Errorf("invalid API key minimum length: %d (must be at least 1)", cfg.Security.APIKeyMinLength)
 	}
+	if sec := cfg.Security; sec.KeyFailureThreshold < 0 {
+		return fmt.Errorf("invalid key failure threshold: %d (must not be negative)", sec.KeyFailureThreshold)
+	} else if sec.KeyFailureThreshold > 0 {
+		if sec.KeyFailureWindow.Duration <= 0 || sec.KeyFailureDelay.Duration < 0 || sec.KeyFailureMaxDelay.Duration < sec.KeyFailureDelay.Duration {
+			return fmt.Errorf("invalid key failure delays: %s doubling up to %s within %s (must be a positive window and a maximum no smaller than the delay)", sec.KeyFailureDelay, sec.KeyFailureMaxDelay, sec.KeyFailureWindow)
+		}
+		if sec.KeyFailureBanAfter < 0 || sec.KeyFailureBanAfter > 0 && sec.KeyFailureBanDuration.Duration <= 0 {
+			return fmt.Errorf("invalid key failure ban: after %d failures for %s (must be a non-negative count and a positive duration)", sec.KeyFailureBanAfter, sec.KeyFailureBanDuration)
+		}
+	}
 
 	if cfg.Validation.MaxMessageLength < 1 {
 		return fmt.Errorf("invalid max message length: %d (must be at least 1)", cfg.Validation.MaxMessageLength)
 	}
+	if cfg.BodyLimits.MaxRequestBody < 0 {
+		return fmt.Errorf("invalid max request body: %d (must be 0 or more bytes)", cfg.BodyLimits.MaxRequestBody)
+	}
+	for prefix, limit := range cfg.BodyLimits.Routes {
+		if !strings.HasPrefix(prefix, "/") || limit < 0 {
+			return fmt.Errorf("invalid request body limit %s=%d (must be a path prefix starting with / and 0 or more bytes)", prefix, limit)
+		}
+	}
 
 	if cfg.Anthropic.MaxTokens < 1 {
 		return fmt.Errorf("invalid max tokens: %d (must be at least 1)", cfg.Anthropic.MaxTokens)
@@ -254,6 +1624,264 @@ func validate(cfg *Config) error {
 		return fmt.Errorf("invalid temperature: %f (must be between 0 and 2)", cfg.Anthropic.Temperature)
 	}
 
+	if cfg.RateLimit.Enabled && cfg.RateLimit.Requests < 1 {
+		return fmt.Errorf("invalid rate limit: %d requests (must be at least 1)", cfg.RateLimit.Requests)
+	}
+
+	if cfg.RateLimit.Enabled && cfg.RateLimit.Window.Duration <= 0 {
+		return fmt.Errorf("invalid rate limit window: %s (must be positive)", cfg.RateLimit.Window.Duration)
+	}
+	if cfg.RateLimit.Tokens < 0 || cfg.RateLimit.Tokens > 0 && cfg.RateLimit.TokensWindow.Duration <= 0 {
+		return fmt.Errorf("invalid token rate limit: %d tokens per %s (must be a non-negative count over a positive window)", cfg.RateLimit.Tokens, cfg.RateLimit.TokensWindow)
+	}
+
+	if cfg.Admin.AnthropicAdminKey != "" && len(cfg.Admin.Token) < 32 {
+		return fmt.Errorf("invalid admin API token: ANTHROPIC_ADMIN_KEY requires an ADMIN_API_TOKEN of at least 32 characters")
+	}
+
+	if _, err := cfg.Anthropic.KeyRegexp(); err != nil {
+		return fmt.Errorf("invalid key pattern: %w", err)
+	}
+
+	for model, limit := range cfg.Anthropic.ModelMaxTokens {
+		if limit < 1 {
+			return fmt.Errorf("invalid max tokens for %s: %d (must be positive)", model, limit)
+		}
+	}
+
+	versions := slices.Concat([]string{cfg.Anthropic.APIVersion}, cfg.Anthropic.APIVersions, slices.Collect(maps.Values(cfg.Anthropic.ModelAPIVersions)))
+	for _, version := range versions {
+		if _, err := time.Parse("2006-01-02", version); err != nil {
+			return fmt.Errorf("invalid API version: %q (must be a date such as 2023-06-01)", version)
+		}
+	}
+
+	for _, s := range cfg.Anthropic.StopSequenceList() {
+		if strings.TrimSpace(s) == "" {
+			return fmt.Errorf("invalid stop sequence %q: must contain a non-whitespace character", s)
+		}
+	}
+
+	if cfg.Anthropic.MaxTokensPolicy != "clamp" && cfg.Anthropic.MaxTokensPolicy != "reject" {
+		return fmt.Errorf("invalid max tokens policy: %s (must be clamp or reject)", cfg.Anthropic.MaxTokensPolicy)
+	}
+
+	for _, prices := range []map[string]float64{cfg.Anthropic.InputPrices, cfg.Anthropic.OutputPrices} {
+		for model, price := range prices {
+			if price < 0 {
+				return fmt.Errorf("invalid price for %s: %g (must not be negative)", model, price)
+			}
+		}
+	}
+
+	if cfg.Anthropic.ResumeAttempts < 0 {
+		return fmt.Errorf("invalid resume attempts: %d (must not be negative)", cfg.Anthropic.ResumeAttempts)
+	}
+
+	if cfg.Anthropic.MaxContinuations < 0 {
+		return fmt.Errorf("invalid max continuations: %d (must not be negative)", cfg.Anthropic.MaxContinuations)
+	}
+
+	if cfg.Anthropic.SchemaRetries < 0 {
+		return fmt.Errorf("invalid schema retries: %d (must not be negative)", cfg.Anthropic.SchemaRetries)
+	}
+
+	if cfg.Status.FailureThreshold < 1 {
+		return fmt.Errorf("invalid status failure threshold: %d (must be at least 1)", cfg.Status.FailureThreshold)
+	}
+
+	switch cfg.Anthropic.StartupProbe {
+	case "off", "warn", "fail":
+	default:
+		return fmt.Errorf("invalid startup probe: %s (must be off, warn or fail)", cfg.Anthropic.StartupProbe)
+	}
+	if cfg.Anthropic.StartupProbe != "off" && cfg.Anthropic.StartupProbeTimeout.Duration <= 0 {
+		return fmt.Errorf("invalid startup probe timeout: %s (must be positive)", cfg.Anthropic.StartupProbeTimeout)
+	}
+
+	if cfg.Anthropic.PrewarmConnections < 0 || cfg.Anthropic.PrewarmConnections > 0 && cfg.Anthropic.PrewarmInterval.Duration <= 0 {
+		return fmt.Errorf("invalid upstream pre-warming: %d connections every %s (must be a non-negative count and a positive interval)", cfg.Anthropic.PrewarmConnections, cfg.Anthropic.PrewarmInterval)
+	}
+
+	if cfg.DNS.CacheTTL.Duration < 0 {
+		return fmt.Errorf("invalid DNS cache TTL: %s (must not be negative)", cfg.DNS.CacheTTL)
+	}
+	if _, err := ParseDNSPins(cfg.DNS.Pins); err != nil {
+		return err
+	}
+
+	if cfg.Anthropic.SigningKey != "" {
+		if cfg.Anthropic.SigningAlgorithm != "sha256" && cfg.Anthropic.SigningAlgorithm != "sha512" {
+			return fmt.Errorf("invalid signing algorithm: %s (must be sha256 or sha512)", cfg.Anthropic.SigningAlgorithm)
+		}
+		if cfg.Anthropic.SigningHeader == "" {
+			return fmt.Errorf("invalid signing header: must be set with ANTHROPIC_SIGNING_KEY")
+		}
+	}
+
+	if cfg.Tokens.CharsPerToken < 1 {
+		return fmt.Errorf("invalid token estimate ratio: %g characters per token (must be at least 1)", cfg.Tokens.CharsPerToken)
+	}
+
+	if cfg.Tokens.MaxInputTokens < 0 {
+		return fmt.Errorf("invalid max input tokens: %d (must not be negative)", cfg.Tokens.MaxInputTokens)
+	}
+
+	if cfg.Tokens.HistoryHint < 0 {
+		return fmt.Errorf("invalid history hint threshold: %d (must not be negative)", cfg.Tokens.HistoryHint)
+	}
+
+	if cfg.Models.CacheTTL.Duration < 0 {
+		return fmt.Errorf("invalid models cache TTL: %s (must not be negative)", cfg.Models.CacheTTL.Duration)
+	}
+
+	if cfg.Tokens.CountCacheSize < 1 {
+		return fmt.Errorf("invalid token count cache size: %d (must be at least 1)", cfg.Tokens.CountCacheSize)
+	}
+
+	if cfg.Batch.Enabled && (cfg.Batch.Concurrency < 1 || cfg.Batch.MaxRows < 1) {
+		return fmt.Errorf("invalid batch limits: concurrency %d and max rows %d (must be at least 1)", cfg.Batch.Concurrency, cfg.Batch.MaxRows)
+	}
+
+	if cfg.Evals.Enabled && (cfg.Evals.Concurrency < 1 || cfg.Evals.MaxCases < 1) {
+		return fmt.Errorf("invalid eval limits: concurrency %d and max cases %d (must be at least 1)", cfg.Evals.Concurrency, cfg.Evals.MaxCases)
+	}
+
+	if cfg.Title.RateLimitRequests < 0 || (cfg.Title.RateLimitRequests > 0 && cfg.Title.RateLimitWindow.Duration <= 0) {
+		return fmt.Errorf("invalid title rate limit: %d requests per %s (must be 0 to turn it off, or a positive window)", cfg.Title.RateLimitRequests, cfg.Title.RateLimitWindow)
+	}
+
+	if cfg.Summarize.Enabled && (cfg.Summarize.Concurrency < 1 || cfg.Summarize.ChunkTokens < 100 || cfg.Summarize.MaxChars < 1) {
+		return fmt.Errorf("invalid summarize limits: concurrency %d, chunk tokens %d and max chars %d (must be at least 1, 100 and 1)", cfg.Summarize.Concurrency, cfg.Summarize.ChunkTokens, cfg.Summarize.MaxChars)
+	}
+
+	if cfg.Parts.Enabled && (cfg.Parts.TTL.Duration <= 0 || cfg.Parts.MaxUploads < 1 || cfg.Validation.MaxFileSize < 1) {
+		return fmt.Errorf("invalid message parts limits: %d uploads of %d-byte segments kept %s (must be at least 1 upload of 1 byte for a positive time)", cfg.Parts.MaxUploads, cfg.Validation.MaxFileSize, cfg.Parts.TTL)
+	}
+
+	if err := validateArchive(cfg); err != nil {
+		return err
+	}
+
+	switch cfg.Speech.STTProvider {
+	case "", "openai", "whispercpp":
+	default:
+		return fmt.Errorf("invalid speech-to-text provider: %s (must be openai or whispercpp)", cfg.Speech.STTProvider)
+	}
+	if cfg.Speech.STTProvider != "" && cfg.Speech.STTTimeout.Duration <= 0 {
+		return fmt.Errorf("invalid speech-to-text timeout: %s (must be positive)", cfg.Speech.STTTimeout)
+	}
+	switch cfg.Speech.TTSProvider {
+	case "", "openai":
+	case "elevenlabs":
+		if cfg.Speech.TTSVoice == "" {
+			return fmt.Errorf("invalid text-to-speech voice: TTS_VOICE must be set to an ElevenLabs voice ID")
+		}
+		if cfg.Speech.TTSFormat != "mp3" {
+			return fmt.Errorf("invalid text-to-speech format: %s (ElevenLabs supports mp3)", cfg.Speech.TTSFormat)
+		}
+	default:
+		return fmt.Errorf("invalid text-to-speech provider: %s (must be openai or elevenlabs)", cfg.Speech.TTSProvider)
+	}
+	if cfg.Speech.TTSProvider != "" {
+		if !slices.Contains(ttsFormats, cfg.Speech.TTSFormat) {
+			return fmt.Errorf("invalid text-to-speech format: %s (must be one of %s)", cfg.Speech.TTSFormat, strings.Join(ttsFormats, ", "))
+		}
+		if cfg.Speech.TTSMaxChars < 1 || cfg.Speech.TTSTimeout.Duration <= 0 {
+			return fmt.Errorf("invalid text-to-speech limits: %d characters in %s (must be at least 1 in a positive timeout)", cfg.Speech.TTSMaxChars, cfg.Speech.TTSTimeout)
+		}
+	}
+
+	if cfg.Queue.Concurrency < 0 || cfg.Queue.MaxWaiting < 0 {
+		return fmt.Errorf("invalid upstream queue: concurrency %d and size %d must not be negative", cfg.Queue.Concurrency, cfg.Queue.MaxWaiting)
+	}
+
+	for _, entry := range cfg.Server.TrustedProxies {
+		if _, err := ParseIPPrefix(entry); err != nil && entry != "private" {
+			return fmt.Errorf("invalid trusted proxy %q: must be an IP address, a CIDR range or \"private\"", entry)
+		}
+	}
+
+	for _, entry := range cfg.RateLimit.ExemptIPs {
+		if _, err := ParseIPPrefix(entry); err != nil {
+			return fmt.Errorf("invalid rate limit exemption %q: must be an IP address or CIDR range", entry)
+		}
+	}
+
+	if cfg.Session.Enabled && cfg.Session.Secret != "" && len(cfg.Session.Secret) < 32 {
+		return fmt.Errorf("invalid session secret: must be at least 32 characters")
+	}
+
+	for _, stage := range cfg.Moderation.Stages {
+		if stage != "input" && stage != "output" {
+			return fmt.Errorf("invalid moderation stage: %s (must be input or output)", stage)
+		}
+	}
+
+	for name, pattern := range map[string]string{
+		"block":  cfg.Moderation.BlockPattern,
+		"redact": cfg.Moderation.RedactPattern,
+		"flag":   cfg.Moderation.FlagPattern,
+	} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid moderation %s pattern: %v", name, err)
+		}
+	}
+
+	if a := cfg.Moderation.APIAction; a != "block" && a != "redact" && a != "flag" {
+		return fmt.Errorf("invalid moderation API action: %s (must be block, redact or flag)", a)
+	}
+
+	if a := cfg.PII.Action; a != "" && a != "block" && a != "mask" && a != "warn" {
+		return fmt.Errorf("invalid PII action: %s (must be block, mask or warn)", a)
+	}
+	for _, d := range cfg.PII.Detectors {
+		if d != "email" && d != "phone" && d != "card" {
+			return fmt.Errorf("invalid PII detector: %s (must be email, phone or card)", d)
+		}
+	}
+	if _, err := regexp.Compile(cfg.PII.Pattern); err != nil {
+		return fmt.Errorf("invalid PII pattern: %v", err)
+	}
+
+	if a := cfg.Secrets.Action; a != "" && a != "block" && a != "warn" {
+		return fmt.Errorf("invalid secret scan action: %s (must be block or warn)", a)
+	}
+	if cfg.Secrets.MinEntropy < 0 || cfg.Secrets.MinEntropy > 8 {
+		return fmt.Errorf("invalid secret scan minimum entropy: %g (must be between 0 and 8)", cfg.Secrets.MinEntropy)
+	}
+
+	if cfg.DLP.Enabled && cfg.DLP.MaxEntries < 1 {
+		return fmt.Errorf("invalid DLP audit max entries: %d (must be at least 1)", cfg.DLP.MaxEntries)
+	}
+
+	if cfg.Journal.Enabled {
+		if cfg.Journal.File == "" {
+			return fmt.Errorf("invalid journal file: empty (must be set when JOURNAL_ENABLED is true)")
+		}
+		if cfg.Journal.MaxSize < 0 {
+			return fmt.Errorf("invalid journal max size: %d (must not be negative)", cfg.Journal.MaxSize)
+		}
+		if cfg.Journal.MaxFiles < 1 {
+			return fmt.Errorf("invalid journal max files: %d (must be at least 1)", cfg.Journal.MaxFiles)
+		}
+	}
+
+	if cfg.Experiments.File != "" && !cfg.Session.Enabled {
+		return fmt.Errorf("invalid experiments: EXPERIMENTS_FILE requires SESSION_ENABLED=true")
+	}
+
+	if m := cfg.Tenants.Mode; m != "header" && m != "path" {
+		return fmt.Errorf("invalid tenant mode: %s (must be header or path)", m)
+	}
+	if cfg.Tenants.Mode == "header" && cfg.Tenants.Header == "" {
+		return fmt.Errorf("invalid tenant header: must not be empty in header mode")
+	}
+
+	if cfg.Session.Enabled && cfg.Session.MaxAge.Duration <= 0 {
+		return fmt.Errorf("invalid session max age: %s (must be positive)", cfg.Session.MaxAge.Duration)
+	}
+
 	validLogLevels := []string{"debug", "info", "warn", "error"}
 	found := false
 	for _, level := range validLogLevels {
@@ -266,5 +1894,24 @@ func validate(cfg *Config) error {
 		return fmt.Errorf("invalid log level: %s (must be one of: %s)", cfg.Logging.Level, strings.Join(validLogLevels, ", "))
 	}
 
+	if cfg.Logging.Format != "json" && cfg.Logging.Format != "text" {
+		return fmt.Errorf("invalid log format: %s (must be one of: json, text)", cfg.Logging.Format)
+	}
+
+	if cfg.SLO.Enabled {
+		if err := validateSLO(cfg.SLO); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Logging.OTLP {
+		if u, err := url.Parse(cfg.Logging.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid OTLP endpoint: %q (must be an http or https URL)", cfg.Logging.OTLPEndpoint)
+		}
+		if cfg.Logging.ServiceName == "" {
+			return fmt.Errorf("invalid OTLP service name: empty (must be set when LOG_OTLP_ENABLED is true)")
+		}
+	}
+
 	return nil
 }
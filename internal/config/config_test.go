@@ -1,7 +1,22 @@
 package config
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -52,6 +67,81 @@ func TestConfigLoadBehavior(t *testing.T) {
 			setupFiles:  func(tempDir string) {},
 			expectError: true,
 		},
+		{
+			name:        "rejects invalid rate limit exemptions",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects admin key without admin token",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects invalid key pattern",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects invalid model max tokens",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects experiments without sessions",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects invalid moderation pattern",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects unknown PII detector",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects masking secrets",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects gRPC on the admin port",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects passthrough paths outside the API",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects plain http endpoints",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects endpoints with paths",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects base URLs with a trailing slash",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:       "allows plain http endpoints when insecure",
+			setupFiles: func(tempDir string) {},
+			validate: func(cfg *Config) error {
+				if got := strings.Join(cfg.Security.AllowedAPIEndpoints, " "); got != "http://gateway.internal:8080" {
+					t.Errorf("expected the gateway origin, got %q", got)
+				}
+				return nil
+			},
+		},
 		{
 			name:       "handles duration parsing",
 			setupFiles: func(tempDir string) {},
@@ -81,6 +171,244 @@ func TestConfigLoadBehavior(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			name:       "derives endpoints from provider base URLs",
+			setupFiles: func(tempDir string) {},
+			validate: func(cfg *Config) error {
+				expected := "https://gateway.example.com https://cdn.example.com"
+				if got := strings.Join(cfg.Security.AllowedAPIEndpoints, " "); got != expected {
+					t.Errorf("expected endpoints %q, got %q", expected, got)
+				}
+				return nil
+			},
+		},
+		{
+			name:        "rejects an unknown startup probe mode",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects negative resume attempts",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects negative max continuations",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects negative schema retries",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects tiny summarize chunks",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects unknown speech-to-text providers",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects elevenlabs text-to-speech without a voice",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects message parts without uploads",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a short archive encryption key",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects an unknown tenant mode",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a model API version that isn't a date",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects an unknown signing algorithm",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a negative token rate limit",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects pre-warming without an interval",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a DNS pin that isn't an address",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects key failure delays above their maximum",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects an OpenAI base URL that isn't absolute",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a Referrer-Policy outside the allowed values",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects an Ollama base URL on plain http beyond loopback",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a request body limit that isn't a path prefix",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects Vertex without a project",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:       "derives the Vertex endpoint from its region",
+			setupFiles: func(tempDir string) {},
+			validate: func(cfg *Config) error {
+				if got := cfg.Vertex.Endpoint(); got != "https://europe-west1-aiplatform.googleapis.com" {
+					return fmt.Errorf("unexpected Vertex endpoint: %s", got)
+				}
+				for _, origin := range []string{"https://europe-west1-aiplatform.googleapis.com", "https://oauth2.googleapis.com"} {
+					if !slices.Contains(cfg.Security.AllowedAPIEndpoints, origin) {
+						return fmt.Errorf("expected %s to be allowed, got %v", origin, cfg.Security.AllowedAPIEndpoints)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			name:       "loads a custom provider from the providers list",
+			setupFiles: func(tempDir string) {},
+			validate: func(cfg *Config) error {
+				if len(cfg.Providers) != 1 || cfg.Providers[0].Name != "groq" || cfg.Providers[0].Type != "openai" {
+					return fmt.Errorf("unexpected providers: %+v", cfg.Providers)
+				}
+				if got := cfg.DefaultModel("groq"); got != "llama-3.3-70b-versatile" {
+					return fmt.Errorf("unexpected default model: %q", got)
+				}
+				if !slices.Contains(cfg.ProviderNames(), "groq") || !slices.Contains(cfg.Security.AllowedAPIEndpoints, "https://api.groq.com") {
+					return fmt.Errorf("expected groq to be registered and allowed, got %v %v", cfg.ProviderNames(), cfg.Security.AllowedAPIEndpoints)
+				}
+				return nil
+			},
+		},
+		{
+			name:        "rejects a providers list entry with a duplicate name",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a providers list entry with an unknown type",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a default provider that isn't configured",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a negative history hint threshold",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a journal without a file",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects an SLO short window longer than the long one",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects an SLO target of 100%",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a trusted proxy that isn't an address or range",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a negative models cache TTL",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects a negative request timeout",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:        "rejects an OTLP endpoint that isn't an http URL",
+			setupFiles:  func(tempDir string) {},
+			expectError: true,
+		},
+		{
+			name:       "parses OTLP export headers",
+			setupFiles: func(tempDir string) {},
+			validate: func(cfg *Config) error {
+				if !cfg.Logging.OTLP || cfg.Logging.OTLPHeaders["authorization"] != "Bearer token" || cfg.Logging.OTLPHeaders["x-tenant"] != "manto" {
+					return fmt.Errorf("unexpected OTLP settings: %+v", cfg.Logging)
+				}
+				return nil
+			},
+		},
+		{
+			name: "records which dotenv files supplied each variable",
+			setupFiles: func(tempDir string) {
+				os.WriteFile(filepath.Join(tempDir, ".env.test"), []byte("LOG_LEVEL=warn\n"), 0o600)
+				os.WriteFile(filepath.Join(tempDir, ".env"), []byte("LOG_LEVEL=debug\nPORT=7000\n"), 0o600)
+			},
+			validate: func(cfg *Config) error {
+				if cfg.Logging.Level != "warn" {
+					t.Errorf("expected the more specific file to win, got log level %s", cfg.Logging.Level)
+				}
+				if cfg.Server.Port != 9999 {
+					t.Errorf("expected the process environment to win, got port %d", cfg.Server.Port)
+				}
+				files := cfg.EnvFiles()
+				if len(files) != 4 || files[0].Path != ".env.test.local" || files[0].Found {
+					t.Fatalf("expected the four-file cascade starting with a missing .env.test.local, got %+v", files)
+				}
+				if !files[1].Loaded || strings.Join(files[1].Applied, ",") != "LOG_LEVEL" {
+					t.Errorf("expected .env.test to supply LOG_LEVEL, got %+v", files[1])
+				}
+				if len(files[3].Applied) != 0 || strings.Join(files[3].Shadowed, ",") != "LOG_LEVEL,PORT" {
+					t.Errorf("expected .env to be shadowed entirely, got %+v", files[3])
+				}
+				return nil
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -93,15 +421,204 @@ func TestConfigLoadBehavior(t *testing.T) {
 			if strings.Contains(tt.name, "validates") {
 				t.Setenv("PORT", "99999")
 			}
+			if strings.Contains(tt.name, "exemptions") {
+				t.Setenv("RATE_LIMIT_EXEMPT_IPS", "10.0.0.0/8,not-an-ip")
+			}
+			if strings.Contains(tt.name, "admin key") {
+				t.Setenv("ANTHROPIC_ADMIN_KEY", "sk-ant-admin01-test")
+			}
+			if strings.Contains(tt.name, "key pattern") {
+				t.Setenv("ANTHROPIC_KEY_PATTERN", "gw-[")
+			}
+			if strings.Contains(tt.name, "model max tokens") {
+				t.Setenv("ANTHROPIC_MODEL_MAX_TOKENS", "claude-3-opus=4096,claude-3-5-haiku")
+			}
+			if strings.Contains(tt.name, "experiments") {
+				t.Setenv("EXPERIMENTS_FILE", "experiment.json")
+			}
+			if strings.Contains(tt.name, "moderation") {
+				t.Setenv("MODERATION_REDACT_PATTERN", "(unclosed")
+			}
+			if strings.Contains(tt.name, "PII") {
+				t.Setenv("PII_ACTION", "mask")
+				t.Setenv("PII_DETECTORS", "email,passport")
+			}
+			if strings.Contains(tt.name, "secrets") {
+				t.Setenv("SECRET_SCAN_ACTION", "mask")
+			}
+			if strings.Contains(tt.name, "gRPC") {
+				t.Setenv("GRPC_ENABLED", "true")
+				t.Setenv("GRPC_PORT", "9090")
+			}
+			if strings.Contains(tt.name, "passthrough") {
+				t.Setenv("PASSTHROUGH_PATHS", "/admin/*")
+			}
 			if strings.Contains(tt.name, "duration") {
 				t.Setenv("READ_TIMEOUT", "45s")
 				t.Setenv("ANTHROPIC_TIMEOUT", "2m")
 			}
 			if strings.Contains(tt.name, "comma-separated") {
+				t.Setenv("ANTHROPIC_BASE_URL", "https://api.anthropic.com")
 				t.Setenv("ALLOWED_API_ENDPOINTS", "https://api.anthropic.com,https://api.example.com")
 				t.Setenv("ALLOWED_HOSTS", "localhost,example.com")
 			}
 
+			if strings.Contains(tt.name, "plain http") {
+				t.Setenv("ANTHROPIC_BASE_URL", "http://gateway.internal:8080")
+			}
+			if strings.Contains(tt.name, "insecure") {
+				t.Setenv("ALLOW_INSECURE_ENDPOINTS", "true")
+			}
+			if strings.Contains(tt.name, "with paths") {
+				t.Setenv("ALLOWED_API_ENDPOINTS", "https://api.example.com/v1")
+			}
+			if strings.Contains(tt.name, "trailing slash") {
+				t.Setenv("ANTHROPIC_BASE_URL", "https://gateway.example.com/anthropic/")
+			}
+			if strings.Contains(tt.name, "provider base URLs") {
+				t.Setenv("ANTHROPIC_BASE_URL", "https://gateway.example.com/anthropic")
+				t.Setenv("ALLOWED_API_ENDPOINTS", "https://gateway.example.com,https://cdn.example.com")
+			}
+
+			if strings.Contains(tt.name, "startup probe") {
+				t.Setenv("ANTHROPIC_STARTUP_PROBE", "maybe")
+			}
+			if strings.Contains(tt.name, "resume attempts") {
+				t.Setenv("ANTHROPIC_RESUME_ATTEMPTS", "-1")
+			}
+			if strings.Contains(tt.name, "max continuations") {
+				t.Setenv("ANTHROPIC_MAX_CONTINUATIONS", "-1")
+			}
+			if strings.Contains(tt.name, "schema retries") {
+				t.Setenv("ANTHROPIC_SCHEMA_RETRIES", "-1")
+			}
+			if strings.Contains(tt.name, "summarize chunks") {
+				t.Setenv("SUMMARIZE_ENABLED", "true")
+				t.Setenv("SUMMARIZE_CHUNK_TOKENS", "10")
+			}
+			if strings.Contains(tt.name, "speech-to-text providers") {
+				t.Setenv("STT_PROVIDER", "deepgram")
+			}
+			if strings.Contains(tt.name, "text-to-speech without a voice") {
+				t.Setenv("TTS_PROVIDER", "elevenlabs")
+			}
+			if strings.Contains(tt.name, "parts without uploads") {
+				t.Setenv("MESSAGE_PARTS_ENABLED", "true")
+				t.Setenv("MESSAGE_PARTS_MAX_UPLOADS", "0")
+			}
+			if strings.Contains(tt.name, "archive encryption key") {
+				t.Setenv("CONVERSATIONS_ENABLED", "true")
+				t.Setenv("ARCHIVE_ENABLED", "true")
+				t.Setenv("ARCHIVE_S3_ENDPOINT", "https://s3.example.com")
+				t.Setenv("ARCHIVE_S3_BUCKET", "history")
+				t.Setenv("ARCHIVE_S3_ACCESS_KEY_ID", "AKID")
+				t.Setenv("ARCHIVE_S3_SECRET_ACCESS_KEY", "secret")
+				t.Setenv("ARCHIVE_ENCRYPTION_KEY", "c2hvcnQ=")
+			}
+			if strings.Contains(tt.name, "tenant mode") {
+				t.Setenv("TENANT_MODE", "subdomain")
+			}
+			if strings.Contains(tt.name, "model API version") {
+				t.Setenv("ANTHROPIC_MODEL_API_VERSIONS", "claude-opus-4=latest")
+			}
+			if strings.Contains(tt.name, "DNS pin") {
+				t.Setenv("UPSTREAM_DNS_PINS", "api.anthropic.com=anthropic.example")
+			}
+			if strings.Contains(tt.name, "key failure delays") {
+				t.Setenv("KEY_FAILURE_DELAY", "1m")
+				t.Setenv("KEY_FAILURE_MAX_DELAY", "30s")
+			}
+			if strings.Contains(tt.name, "OpenAI base URL") {
+				t.Setenv("OPENAI_ENABLED", "true")
+				t.Setenv("OPENAI_BASE_URL", "api.openai.com/v1")
+			}
+			if strings.Contains(tt.name, "Referrer-Policy") {
+				t.Setenv("REFERRER_POLICY", "never")
+			}
+			if strings.Contains(tt.name, "Ollama base URL") {
+				t.Setenv("OLLAMA_ENABLED", "true")
+				t.Setenv("OLLAMA_BASE_URL", "http://gpu-box.internal:11434")
+			}
+			if strings.Contains(tt.name, "request body limit") {
+				t.Setenv("MAX_REQUEST_BODY_ROUTES", "api/models=1024")
+			}
+			if strings.Contains(tt.name, "Vertex") {
+				t.Setenv("VERTEX_ENABLED", "true")
+				t.Setenv("VERTEX_CREDENTIALS_FILE", "/etc/manto/vertex.json")
+				t.Setenv("VERTEX_REGION", "europe-west1")
+			}
+			if strings.Contains(tt.name, "Vertex endpoint") {
+				t.Setenv("VERTEX_PROJECT_ID", "example-project")
+			}
+			if strings.Contains(tt.name, "providers list") {
+				t.Setenv("PROVIDERS_0_NAME", "groq")
+				t.Setenv("PROVIDERS_0_TYPE", "openai")
+				t.Setenv("PROVIDERS_0_BASE_URL", "https://api.groq.com/openai/v1")
+				t.Setenv("PROVIDERS_0_DEFAULT_MODEL", "llama-3.3-70b-versatile")
+			}
+			if strings.Contains(tt.name, "duplicate name") {
+				t.Setenv("PROVIDERS_0_NAME", "anthropic")
+			}
+			if strings.Contains(tt.name, "unknown type") {
+				t.Setenv("PROVIDERS_0_NAME", "bedrock")
+				t.Setenv("PROVIDERS_0_TYPE", "bedrock")
+			}
+			if strings.Contains(tt.name, "default provider") {
+				t.Setenv("PROVIDER_DEFAULT", "groq")
+			}
+			if strings.Contains(tt.name, "history hint") {
+				t.Setenv("TOKEN_HISTORY_HINT", "-1")
+			}
+			if strings.Contains(tt.name, "journal without") {
+				t.Setenv("JOURNAL_ENABLED", "true")
+			}
+			if strings.Contains(tt.name, "SLO short window") {
+				t.Setenv("SLO_ENABLED", "true")
+				t.Setenv("SLO_SHORT_WINDOW", "2h")
+			}
+			if strings.Contains(tt.name, "SLO target") {
+				t.Setenv("SLO_ENABLED", "true")
+				t.Setenv("SLO_AVAILABILITY_TARGET", "1")
+			}
+			if strings.Contains(tt.name, "trusted proxy") {
+				t.Setenv("TRUSTED_PROXIES", "private,fly-proxy")
+			}
+			if strings.Contains(tt.name, "models cache TTL") {
+				t.Setenv("MODELS_CACHE_TTL", "-1m")
+			}
+			if strings.Contains(tt.name, "request timeout") {
+				t.Setenv("REQUEST_TIMEOUT", "-1s")
+			}
+			if strings.Contains(tt.name, "OTLP endpoint") {
+				t.Setenv("LOG_OTLP_ENABLED", "true")
+				t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4318")
+			}
+			if strings.Contains(tt.name, "OTLP export headers") {
+				t.Setenv("LOG_OTLP_ENABLED", "true")
+				t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer token,x-tenant=manto")
+			}
+			if strings.Contains(tt.name, "pre-warming") {
+				t.Setenv("ANTHROPIC_PREWARM_CONNECTIONS", "2")
+				t.Setenv("ANTHROPIC_PREWARM_INTERVAL", "0s")
+			}
+			if strings.Contains(tt.name, "token rate limit") {
+				t.Setenv("RATE_LIMIT_TOKENS", "-1")
+			}
+			if strings.Contains(tt.name, "signing algorithm") {
+				t.Setenv("ANTHROPIC_SIGNING_KEY", "gateway-secret")
+				t.Setenv("ANTHROPIC_SIGNING_ALGORITHM", "md5")
+			}
+			if strings.Contains(tt.name, "dotenv") {
+				t.Setenv("GO_ENV", "test")
+				t.Setenv("PORT", "9999")
+				t.Setenv("LOG_LEVEL", "")
+				os.Unsetenv("LOG_LEVEL")
+			}
+
+			tempDir := t.TempDir()
+			t.Chdir(tempDir)
+			tt.setupFiles(tempDir)
+
 			cfg, err := Load()
 
 			if tt.expectError && err == nil {
@@ -173,3 +690,453 @@ func TestEnvironmentDetectionBehavior(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigSummaryRedactsSecrets(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Port = 8080
+	cfg.Server.ReadTimeout = Duration{30 * time.Second}
+	cfg.Anthropic.APIKey = "sk-ant-supersecretvalue"
+	cfg.Anthropic.BaseURL = "https://api.anthropic.com"
+
+	summary := cfg.Summary()
+
+	server, ok := summary["Server"].(map[string]interface{})
+	if !ok {
+		t.Fatal("summary should contain a Server section")
+	}
+	if server["Port"] != 8080 {
+		t.Errorf("expected port 8080, got %v", server["Port"])
+	}
+	if server["ReadTimeout"] != "30s" {
+		t.Errorf("expected durations to be rendered as strings, got %v", server["ReadTimeout"])
+	}
+
+	anthropic := summary["Anthropic"].(map[string]interface{})
+	if anthropic["APIKey"] != redacted {
+		t.Errorf("expected API key to be redacted, got %v", anthropic["APIKey"])
+	}
+	if anthropic["BaseURL"] != "https://api.anthropic.com" {
+		t.Errorf("expected base URL to be shown, got %v", anthropic["BaseURL"])
+	}
+
+	errorTracking := summary["ErrorTracking"].(map[string]interface{})
+	if errorTracking["URL"] != "" {
+		t.Errorf("unset secrets should be shown as empty, got %v", errorTracking["URL"])
+	}
+
+	cfg.Logging.OTLPHeaders = map[string]string{}
+	if logging := cfg.Summary()["Logging"].(map[string]interface{}); logging["OTLPHeaders"] != "" {
+		t.Errorf("empty secret maps should be shown as empty, got %v", logging["OTLPHeaders"])
+	}
+	cfg.Logging.OTLPHeaders["authorization"] = "Bearer token"
+	if logging := cfg.Summary()["Logging"].(map[string]interface{}); logging["OTLPHeaders"] != redacted {
+		t.Errorf("expected OTLP headers to be redacted, got %v", logging["OTLPHeaders"])
+	}
+}
+
+func TestStrictModeBehavior(t *testing.T) {
+	t.Run("reports unknown prefixed variables with suggestions", func(t *testing.T) {
+		t.Setenv("CONFIG_STRICT", "true")
+		t.Setenv("MANTO_ANTHROPIC_TIMEUT", "30s")
+
+		_, err := Load()
+		if err == nil {
+			t.Fatal("expected strict mode to reject unknown variable")
+		}
+		if !strings.Contains(err.Error(), "MANTO_ANTHROPIC_TIMEUT") || !strings.Contains(err.Error(), "did you mean MANTO_ANTHROPIC_TIMEOUT?") {
+			t.Errorf("expected typo suggestion, got: %v", err)
+		}
+	})
+
+	t.Run("collects every unparseable value", func(t *testing.T) {
+		t.Setenv("CONFIG_STRICT", "true")
+		t.Setenv("PORT", "eighty")
+		t.Setenv("ANTHROPIC_TIMEOUT", "soon")
+
+		_, err := Load()
+		problems, ok := err.(Problems)
+		if !ok {
+			t.Fatalf("expected Problems error, got %T: %v", err, err)
+		}
+		if len(problems) < 2 {
+			t.Errorf("expected both parse failures to be reported, got: %v", problems)
+		}
+	})
+
+	t.Run("accepts known prefixed variables", func(t *testing.T) {
+		t.Setenv("MANTO_CONFIG_STRICT", "true")
+		t.Setenv("MANTO_ANTHROPIC_TIMEOUT", "45s")
+		t.Setenv("ANTHROPIC_TIMEOUT", "10s")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Anthropic.Timeout.Duration != 45*time.Second {
+			t.Errorf("expected MANTO_ prefixed value to win, got %v", cfg.Anthropic.Timeout.Duration)
+		}
+	})
+
+	t.Run("accepts the variables an upgrade hands its child", func(t *testing.T) {
+		t.Setenv("CONFIG_STRICT", "true")
+		t.Setenv("MANTO_LISTEN_FD_PUBLIC", "3")
+		t.Setenv("MANTO_LISTEN_FD_GRPC", "4")
+		t.Setenv("MANTO_READY_FD", "5")
+
+		if _, err := Load(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("non-strict mode ignores unknown prefixed variables", func(t *testing.T) {
+		t.Setenv("MANTO_ANTHROPIC_TIMEUT", "30s")
+
+		if _, err := Load(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestModelLimitsBehavior(t *testing.T) {
+	t.Setenv("ANTHROPIC_MODEL_MAX_TOKENS", "claude-3=4096, claude-3-5-haiku = 8192")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	limits := cfg.Anthropic.ModelMaxTokens
+
+	tests := []struct {
+		model    string
+		expected int
+	}{
+		{model: "claude-3-opus-20240229", expected: 4096},
+		{model: "claude-3-5-haiku-20241022", expected: 8192},
+		{model: "claude-sonnet-4", expected: 0},
+	}
+	for _, tt := range tests {
+		if got := limits.For(tt.model); got != tt.expected {
+			t.Errorf("%s: expected limit %d, got %d", tt.model, tt.expected, got)
+		}
+	}
+
+	for _, entry := range []string{"claude-3", "=10", "claude-3=0", "claude-3=lots"} {
+		t.Setenv("ANTHROPIC_MODEL_MAX_TOKENS", entry)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected %q to be rejected", entry)
+		}
+	}
+}
+
+func TestEstimateCostBehavior(t *testing.T) {
+	cfg := AnthropicConfig{
+		InputPrices:  map[string]float64{"claude-3": 3, "claude-3-5-haiku": 0.8},
+		OutputPrices: map[string]float64{"claude-3": 15, "claude-3-5-haiku": 4},
+	}
+	if cost, ok := cfg.EstimateCost("claude-3-5-haiku-20241022", 1000, 500); !ok || math.Abs(cost-0.0028) > 1e-12 {
+		t.Errorf("expected the longest prefix to price the model, got %v, %v", cost, ok)
+	}
+	if cost, ok := cfg.EstimateCost("claude-3-opus", 1e6, 0); !ok || cost != 3 {
+		t.Errorf("expected the family price, got %v, %v", cost, ok)
+	}
+	if _, ok := cfg.EstimateCost("gpt-4o", 10, 10); ok {
+		t.Error("expected no estimate for an unpriced model")
+	}
+	if cheapest := cfg.CheapestModel(); cheapest != "claude-3-5-haiku" {
+		t.Errorf("expected the cheapest priced model, got %q", cheapest)
+	}
+	if cheapest := (AnthropicConfig{InputPrices: map[string]float64{"m": 1}}).CheapestModel(); cheapest != "" {
+		t.Errorf("expected no cheapest model without output prices, got %q", cheapest)
+	}
+}
+
+func TestStopSequenceListBehavior(t *testing.T) {
+	cfg := AnthropicConfig{StopSequences: []string{`\n\nUser:`, `a\tb`, `back\\nslash`}}
+	expected := []string{"\n\nUser:", "a\tb", `back\nslash`}
+
+	got := cfg.StopSequenceList()
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("entry %d: expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestParseDurationBehavior(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{input: "30s", expected: 30 * time.Second},
+		{input: "1h30m", expected: 90 * time.Minute},
+		{input: "7d", expected: 7 * 24 * time.Hour},
+		{input: "2w", expected: 14 * 24 * time.Hour},
+		{input: "1w2d12h", expected: 9*24*time.Hour + 12*time.Hour},
+		{input: "1.5d", expected: 36 * time.Hour},
+		{input: "90", expected: 90 * time.Second},
+		{input: "0", expected: 0},
+		{input: "-1d", expected: -24 * time.Hour},
+		{input: "", wantErr: true},
+		{input: "7days", wantErr: true},
+		{input: "d", wantErr: true},
+		{input: "1.5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseDuration(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error for %q, got %v", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("config fields accept extended units", func(t *testing.T) {
+		t.Setenv("ANTHROPIC_TIMEOUT", "120")
+		t.Setenv("RATE_LIMIT_WINDOW", "1d")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Anthropic.Timeout.Duration != 2*time.Minute {
+			t.Errorf("expected bare integer as seconds, got %v", cfg.Anthropic.Timeout.Duration)
+		}
+		if cfg.RateLimit.Window.Duration != 24*time.Hour {
+			t.Errorf("expected day unit to parse, got %v", cfg.RateLimit.Window.Duration)
+		}
+	})
+}
+
+func TestRemoteConfigBehavior(t *testing.T) {
+	// unsetEnv clears names for the test, restoring them afterwards, so
+	// values the backend sets don't leak into other tests.
+	unsetEnv := func(t *testing.T, names ...string) {
+		for _, name := range names {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+
+	var mu sync.Mutex
+	index, kv := 1, map[string]string{"manto/LOG_LEVEL": "debug", "manto/PORT": "7000", "manto/nested/KEY": "x"}
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/manto/" || r.Header.Get("X-Consul-Token") != "consul-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		type pair struct {
+			Key   string
+			Value []byte
+		}
+		var pairs []pair
+		for key, value := range kv {
+			pairs = append(pairs, pair{key, []byte(value)})
+		}
+		w.Header().Set("X-Consul-Index", strconv.Itoa(index))
+		json.NewEncoder(w).Encode(pairs)
+	}))
+	defer consul.Close()
+
+	setConsul := func(t *testing.T) {
+		t.Setenv("CONFIG_BACKEND", "consul")
+		t.Setenv("CONFIG_BACKEND_ADDRESS", consul.URL)
+		t.Setenv("CONFIG_BACKEND_TOKEN", "consul-token")
+		t.Setenv("CONFIG_BACKEND_INTERVAL", "10ms")
+		t.Setenv("PORT", "9999")
+		unsetEnv(t, "LOG_LEVEL")
+	}
+
+	t.Run("fills in settings the environment leaves unset", func(t *testing.T) {
+		setConsul(t)
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Logging.Level != "debug" || cfg.Server.Port != 9999 {
+			t.Errorf("expected remote log level and local port, got %s and %d", cfg.Logging.Level, cfg.Server.Port)
+		}
+		files := cfg.EnvFiles()
+		remote := files[len(files)-1]
+		if remote.Path != "consul:manto/" || strings.Join(remote.Applied, ",") != "LOG_LEVEL" || strings.Join(remote.Shadowed, ",") != "PORT" {
+			t.Errorf("expected the backend to be reported as a source, got %+v", remote)
+		}
+	})
+
+	t.Run("applies reloadable changes through hooks", func(t *testing.T) {
+		setConsul(t)
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		levels := make(chan string, 1)
+		stop := make(chan struct{})
+		defer close(stop)
+		NewWatcher(cfg, slog.New(slog.NewTextHandler(io.Discard, nil))).
+			OnChange(func(next *Config) { levels <- next.Logging.Level }, "LOG_LEVEL").
+			Start(stop)
+
+		mu.Lock()
+		index, kv["manto/LOG_LEVEL"], kv["manto/PORT"] = 2, "warn", "7001"
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			index, kv["manto/LOG_LEVEL"], kv["manto/PORT"] = 1, "debug", "7000"
+			mu.Unlock()
+		}()
+
+		select {
+		case level := <-levels:
+			if level != "warn" || cfg.Logging.Level != "debug" {
+				t.Errorf("expected the hook to see warn and the loaded config to be untouched, got %s and %s", level, cfg.Logging.Level)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the log level change to be applied")
+		}
+	})
+
+	t.Run("rejects unknown backends", func(t *testing.T) {
+		t.Setenv("CONFIG_BACKEND", "zookeeper")
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), "CONFIG_BACKEND") {
+			t.Errorf("expected an invalid backend error, got %v", err)
+		}
+	})
+
+	t.Run("fails to start when the backend refuses access", func(t *testing.T) {
+		t.Setenv("CONFIG_BACKEND", "consul")
+		t.Setenv("CONFIG_BACKEND_ADDRESS", consul.URL)
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), "403") {
+			t.Errorf("expected the backend's refusal to fail the load, got %v", err)
+		}
+	})
+
+	t.Run("reads etcd prefixes", func(t *testing.T) {
+		etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Key      []byte `json:"key"`
+				RangeEnd []byte `json:"range_end"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if r.URL.Path != "/v3/kv/range" || string(req.Key) != "manto/" || string(req.RangeEnd) != "manto0" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"kvs":[{"key":%q,"value":%q,"mod_revision":"5"}]}`,
+				base64.StdEncoding.EncodeToString([]byte("manto/ANTHROPIC_MAX_TOKENS")),
+				base64.StdEncoding.EncodeToString([]byte("2048")))
+		}))
+		defer etcd.Close()
+
+		source := NewRemoteSource(RemoteConfig{Backend: BackendEtcd, Address: etcd.URL, Path: "manto/"}, etcd.Client())
+		values, version, err := source.Fetch(context.Background(), "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if values["ANTHROPIC_MAX_TOKENS"] != "2048" || version == "" {
+			t.Errorf("expected the prefix's keys with a version, got %v (%q)", values, version)
+		}
+	})
+}
+
+func TestLoaderTypesBehavior(t *testing.T) {
+	type provider struct {
+		Name    string   `env:"NAME"`
+		BaseURL string   `env:"BASE_URL"`
+		APIKey  string   `env:"API_KEY" secret:"true"`
+		Timeout Duration `env:"TIMEOUT" default:"30s"`
+	}
+	type settings struct {
+		Caps      map[string]int `env:"TEST_CAPS" default:"haiku=1024"`
+		Weights   []float64      `env:"TEST_WEIGHTS"`
+		Since     time.Time      `env:"TEST_SINCE"`
+		Providers []provider     `env:"TEST_PROVIDERS"`
+	}
+
+	load := func(t *testing.T) (settings, error) {
+		var s settings
+		v, typ := reflect.ValueOf(&s).Elem(), reflect.TypeOf(s)
+		if err := setDefaultValues(v, typ); err != nil {
+			return s, err
+		}
+		return s, loadEnvVars(v, typ, func(err error) error { return err })
+	}
+
+	t.Run("parses maps, typed slices and times", func(t *testing.T) {
+		t.Setenv("TEST_CAPS", "haiku=4096, opus = 8192")
+		t.Setenv("TEST_WEIGHTS", "0.5,1.5")
+		t.Setenv("MANTO_TEST_SINCE", "2024-03-01")
+
+		s, err := load(t)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.Caps["haiku"] != 4096 || s.Caps["opus"] != 8192 || len(s.Caps) != 2 {
+			t.Errorf("expected both caps, got %v", s.Caps)
+		}
+		if len(s.Weights) != 2 || s.Weights[1] != 1.5 {
+			t.Errorf("expected parsed weights, got %v", s.Weights)
+		}
+		if !s.Since.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected a date at midnight UTC, got %v", s.Since)
+		}
+	})
+
+	t.Run("keeps map defaults when unset", func(t *testing.T) {
+		s, err := load(t)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s.Caps["haiku"] != 1024 || s.Providers != nil {
+			t.Errorf("expected defaults only, got %+v", s)
+		}
+	})
+
+	t.Run("loads lists of sections from indexed variables", func(t *testing.T) {
+		t.Setenv("TEST_PROVIDERS_0_NAME", "anthropic")
+		t.Setenv("TEST_PROVIDERS_0_API_KEY", "sk-secret")
+		t.Setenv("MANTO_TEST_PROVIDERS_1_NAME", "local")
+		t.Setenv("TEST_PROVIDERS_1_TIMEOUT", "5s")
+		t.Setenv("TEST_PROVIDERS_3_NAME", "unreachable")
+
+		s, err := load(t)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(s.Providers) != 2 || s.Providers[0].Name != "anthropic" || s.Providers[1].Name != "local" {
+			t.Fatalf("expected two providers, stopping at the gap, got %+v", s.Providers)
+		}
+		if s.Providers[0].Timeout.Duration != 30*time.Second || s.Providers[1].Timeout.Duration != 5*time.Second {
+			t.Errorf("expected element defaults to apply, got %+v", s.Providers)
+		}
+
+		summary := summarize(reflect.ValueOf(s), reflect.TypeOf(s))
+		providers := summary["Providers"].([]map[string]interface{})
+		if providers[0]["APIKey"] != redacted || summary["Since"] != "0001-01-01T00:00:00Z" {
+			t.Errorf("expected list secrets redacted and times formatted, got %v", summary)
+		}
+	})
+
+	t.Run("rejects malformed values", func(t *testing.T) {
+		for name, value := range map[string]string{"TEST_CAPS": "haiku", "TEST_WEIGHTS": "1,x", "TEST_SINCE": "yesterday", "TEST_PROVIDERS_0_TIMEOUT": "soon"} {
+			t.Run(name, func(t *testing.T) {
+				t.Setenv(name, value)
+				if _, err := load(t); err == nil || !strings.Contains(err.Error(), name) {
+					t.Errorf("expected an error naming %s, got %v", name, err)
+				}
+			})
+		}
+	})
+}
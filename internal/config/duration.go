@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationTerm = regexp.MustCompile(`^(\d+(?:\.\d+)?)(ns|us|µs|ms|s|m|h|d|w)`)
+
+// ParseDuration extends time.ParseDuration with day ("d") and week ("w")
+// units, which may be combined with the standard ones ("1w2d12h"), and
+// treats a bare integer as a number of seconds.
+func ParseDuration(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+	if s == "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	sign := time.Duration(1)
+	if s[0] == '-' || s[0] == '+' {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+
+	var total time.Duration
+	for s != "" {
+		match := durationTerm.FindStringSubmatch(s)
+		if match == nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		s = s[len(match[0]):]
+
+		var term time.Duration
+		switch match[2] {
+		case "d", "w":
+			n, err := strconv.ParseFloat(match[1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			unit := 24 * time.Hour
+			if match[2] == "w" {
+				unit *= 7
+			}
+			term = time.Duration(n * float64(unit))
+		default:
+			parsed, err := time.ParseDuration(match[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			term = parsed
+		}
+		total += term
+	}
+
+	return sign * total, nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/joho/godotenv"
+)
+
+// EnvFile reports what one dotenv file in the cascade contributed. Files are
+// read in order and never override a variable that is already set, so a name
+// the process environment or an earlier file set is Shadowed instead of
+// Applied. Values are never recorded.
+type EnvFile struct {
+	Path     string   `json:"path"`
+	Found    bool     `json:"found"`
+	Loaded   bool     `json:"loaded"`
+	Error    string   `json:"error,omitempty"`
+	Applied  []string `json:"applied,omitempty"`
+	Shadowed []string `json:"shadowed,omitempty"`
+}
+
+// EnvFiles returns the dotenv files considered at load time, in cascade
+// order, with the variables each contributed.
+func (c *Config) EnvFiles() []EnvFile {
+	return c.envFiles
+}
+
+// envFileNames is the cascade, most specific first.
+func envFileNames(env string) []string {
+	return []string{
+		fmt.Sprintf(".env.%s.local", env),
+		fmt.Sprintf(".env.%s", env),
+		".env.local",
+		".env",
+	}
+}
+
+// loadEnvFiles applies the dotenv cascade to the process environment and
+// reports where each variable came from.
+func loadEnvFiles() []EnvFile {
+	var files []EnvFile
+	for _, path := range envFileNames(GetEnvironment()) {
+		file := EnvFile{Path: path}
+		if _, err := os.Stat(path); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				file.Error = err.Error()
+			}
+			files = append(files, file)
+			continue
+		}
+		file.Found = true
+
+		vars, err := godotenv.Read(path)
+		if err != nil {
+			file.Error = err.Error()
+			files = append(files, file)
+			continue
+		}
+		file.Loaded = true
+
+		for name, value := range vars {
+			if _, set := os.LookupEnv(name); set {
+				file.Shadowed = append(file.Shadowed, name)
+				continue
+			}
+			_ = os.Setenv(name, value)
+			file.Applied = append(file.Applied, name)
+		}
+		sort.Strings(file.Applied)
+		sort.Strings(file.Shadowed)
+		files = append(files, file)
+	}
+	return files
+}
@@ -0,0 +1,416 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BackendConsul = "consul"
+	BackendEtcd   = "etcd"
+
+	// remoteLoadTimeout bounds the fetch at startup.
+	remoteLoadTimeout = 10 * time.Second
+)
+
+// RemoteConfig points at a Consul or etcd key prefix holding settings shared
+// by a fleet. Each key directly below Path is named after the environment
+// variable it sets, e.g. manto/LOG_LEVEL. The process environment and dotenv
+// files take precedence, so a host can still override a fleet-wide value.
+type RemoteConfig struct {
+	Backend  string   `env:"CONFIG_BACKEND"`
+	Address  string   `env:"CONFIG_BACKEND_ADDRESS"`
+	Path     string   `env:"CONFIG_BACKEND_PATH" default:"manto/"`
+	Token    string   `env:"CONFIG_BACKEND_TOKEN" secret:"true"`
+	Interval Duration `env:"CONFIG_BACKEND_INTERVAL" default:"30s"`
+}
+
+// RemoteSource reads the settings under a key prefix.
+type RemoteSource interface {
+	// Fetch returns the variables under the prefix and a version that
+	// changes whenever they do. Given the version last seen, it waits up to
+	// the poll interval for a change before returning.
+	Fetch(ctx context.Context, after string) (map[string]string, string, error)
+}
+
+// NewRemoteSource returns the source cfg describes, or nil when no backend
+// is configured.
+func NewRemoteSource(cfg RemoteConfig, client *http.Client) RemoteSource {
+	address := strings.TrimRight(cfg.Address, "/")
+	switch cfg.Backend {
+	case BackendConsul:
+		return &consulSource{address: address, path: cfg.Path, token: cfg.Token, wait: cfg.Interval.Duration, client: client}
+	case BackendEtcd:
+		return &etcdSource{address: address, path: cfg.Path, token: cfg.Token, wait: cfg.Interval.Duration, client: client}
+	}
+	return nil
+}
+
+// remoteState is what the startup fetch saw, so the watcher carries on from
+// it.
+type remoteState struct {
+	source  RemoteSource
+	version string
+	values  map[string]string
+	owned   map[string]bool
+}
+
+// loadRemote fetches the remote settings and sets those the environment
+// leaves unset. It returns nil when no backend is configured.
+func loadRemote() (*remoteState, *EnvFile, error) {
+	var cfg RemoteConfig
+	v, t := reflect.ValueOf(&cfg).Elem(), reflect.TypeOf(cfg)
+	if err := setDefaultValues(v, t); err != nil {
+		return nil, nil, err
+	}
+	if err := loadEnvVars(v, t, func(err error) error { return err }); err != nil {
+		return nil, nil, err
+	}
+	if err := validateRemote(cfg); err != nil || cfg.Backend == "" {
+		return nil, nil, err
+	}
+
+	source := NewRemoteSource(cfg, &http.Client{})
+	ctx, cancel := context.WithTimeout(context.Background(), remoteLoadTimeout)
+	defer cancel()
+	values, version, err := source.Fetch(ctx, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %s configuration from %s: %w", cfg.Backend, cfg.Address, err)
+	}
+
+	state := &remoteState{source: source, version: version, values: values, owned: map[string]bool{}}
+	file := &EnvFile{Path: cfg.Backend + ":" + cfg.Path, Found: true, Loaded: true}
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if _, set := os.LookupEnv(name); set {
+			file.Shadowed = append(file.Shadowed, name)
+			continue
+		}
+		_ = os.Setenv(name, values[name])
+		state.owned[name] = true
+		file.Applied = append(file.Applied, name)
+	}
+	return state, file, nil
+}
+
+func validateRemote(cfg RemoteConfig) error {
+	switch cfg.Backend {
+	case "":
+		return nil
+	case BackendConsul, BackendEtcd:
+	default:
+		return fmt.Errorf("invalid CONFIG_BACKEND: %q (must be consul or etcd)", cfg.Backend)
+	}
+	if u, err := url.Parse(cfg.Address); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid CONFIG_BACKEND_ADDRESS: %q (must be an absolute URL such as http://127.0.0.1:8500)", cfg.Address)
+	}
+	if cfg.Interval.Duration <= 0 {
+		return fmt.Errorf("invalid CONFIG_BACKEND_INTERVAL: %s (must be positive)", cfg.Interval)
+	}
+	return nil
+}
+
+// variableName maps a key below prefix to the variable it sets, rejecting
+// nested keys and folders.
+func variableName(key, prefix string) (string, bool) {
+	name, ok := strings.CutPrefix(key, prefix)
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+// consulSource reads Consul's KV store, using blocking queries to wait for
+// changes.
+type consulSource struct {
+	address string
+	path    string
+	token   string
+	wait    time.Duration
+	client  *http.Client
+}
+
+func (s *consulSource) Fetch(ctx context.Context, after string) (map[string]string, string, error) {
+	query := url.Values{"recurse": {"true"}}
+	if after != "" {
+		query.Set("index", after)
+		query.Set("wait", strconv.Itoa(int(s.wait.Seconds()))+"s")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.address+"/v1/kv/"+s.path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	version := resp.Header.Get("X-Consul-Index")
+	values := map[string]string{}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return values, version, nil
+	default:
+		return nil, "", fmt.Errorf("consul returned %s", resp.Status)
+	}
+
+	var pairs []struct {
+		Key   string
+		Value []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, "", fmt.Errorf("invalid consul response: %w", err)
+	}
+	for _, pair := range pairs {
+		if name, ok := variableName(pair.Key, s.path); ok {
+			values[name] = string(pair.Value)
+		}
+	}
+	return values, version, nil
+}
+
+// etcdSource reads etcd through its v3 JSON gateway. The gateway's watch is
+// a long-lived stream, so changes are found by polling instead.
+type etcdSource struct {
+	address string
+	path    string
+	token   string
+	wait    time.Duration
+	client  *http.Client
+}
+
+func (s *etcdSource) Fetch(ctx context.Context, after string) (map[string]string, string, error) {
+	if after != "" {
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-time.After(s.wait):
+		}
+	}
+
+	body, _ := json.Marshal(map[string][]byte{"key": []byte(s.path), "range_end": prefixEnd(s.path)})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.address+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, "", fmt.Errorf("etcd returned %s", resp.Status)
+	}
+
+	var result struct {
+		KVs []struct {
+			Key         string `json:"key"`
+			Value       string `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("invalid etcd response: %w", err)
+	}
+
+	// The store revision moves on any write anywhere, so the version is
+	// built from the keys under the prefix alone.
+	values := map[string]string{}
+	var version strings.Builder
+	for _, kv := range result.KVs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid etcd key: %w", err)
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid etcd value for %s: %w", key, err)
+		}
+		if name, ok := variableName(string(key), s.path); ok {
+			values[name] = string(value)
+			fmt.Fprintf(&version, "%s@%s;", name, kv.ModRevision)
+		}
+	}
+	return values, version.String(), nil
+}
+
+// prefixEnd returns the range end covering every key starting with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// Watcher follows the remote backend and applies changes to reloadable
+// settings through the hooks registered for them. Other changes are logged
+// as needing a restart. A nil *Watcher does nothing.
+type Watcher struct {
+	cfg    *Config
+	state  *remoteState
+	logger *slog.Logger
+	hooks  map[string]func(*Config)
+}
+
+// NewWatcher returns a watcher carrying on from the settings cfg was loaded
+// with, or nil when no remote backend is configured.
+func NewWatcher(cfg *Config, logger *slog.Logger) *Watcher {
+	if cfg.remote == nil {
+		return nil
+	}
+	return &Watcher{cfg: cfg, state: cfg.remote, logger: logger, hooks: map[string]func(*Config){}}
+}
+
+// OnChange marks the settings names as reloadable. When one changes, apply
+// is called, once per changed setting, with a copy of the configuration holding the new value; the
+// loaded configuration itself is never modified, since it is read without
+// locks.
+func (w *Watcher) OnChange(apply func(*Config), names ...string) *Watcher {
+	if w != nil {
+		for _, name := range names {
+			w.hooks[name] = apply
+		}
+	}
+	return w
+}
+
+// Start follows the backend until stop is closed.
+func (w *Watcher) Start(stop <-chan struct{}) {
+	if w == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		for ctx.Err() == nil {
+			values, version, err := w.state.source.Fetch(ctx, w.state.version)
+			if err != nil {
+				if ctx.Err() == nil {
+					w.logger.Warn("remote configuration fetch failed", slog.String("error", err.Error()))
+					sleep(ctx, w.cfg.Remote.Interval.Duration)
+				}
+				continue
+			}
+			if version != w.state.version {
+				w.apply(values)
+				w.state.version = version
+			}
+		}
+	}()
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// apply handles a new set of remote values. Only settings the backend
+// supplied at startup, or that the environment still leaves unset, are
+// taken; the rest stay overridden locally.
+func (w *Watcher) apply(values map[string]string) {
+	changed := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if values[name] == w.state.values[name] {
+			continue
+		}
+		if _, set := os.LookupEnv(name); set && !w.state.owned[name] {
+			continue
+		}
+		changed[name] = values[name]
+	}
+	for name := range w.state.values {
+		if _, ok := values[name]; !ok && w.state.owned[name] {
+			w.logger.Warn("remote setting removed; restart to apply", slog.String("setting", name))
+		}
+	}
+	w.state.values = values
+
+	next := *w.cfg
+	var names []string
+	for _, name := range slices.Sorted(maps.Keys(changed)) {
+		if _, ok := w.hooks[strings.TrimPrefix(name, envPrefix)]; !ok {
+			w.logger.Warn("remote setting changed; restart to apply", slog.String("setting", name))
+			continue
+		}
+		if err := setByEnvName(&next, name, changed[name]); err != nil {
+			w.logger.Warn("remote setting rejected", slog.String("setting", name), slog.String("error", err.Error()))
+			continue
+		}
+		_ = os.Setenv(name, changed[name])
+		w.state.owned[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return
+	}
+	if err := validate(&next); err != nil {
+		w.logger.Warn("remote settings rejected", slog.String("settings", strings.Join(names, ",")), slog.String("error", err.Error()))
+		return
+	}
+	for _, name := range names {
+		w.hooks[strings.TrimPrefix(name, envPrefix)](&next)
+	}
+	w.logger.Info("remote settings applied", slog.String("settings", strings.Join(names, ",")))
+}
+
+// setByEnvName sets the field whose env tag is name, with or without the
+// MANTO_ prefix.
+func setByEnvName(cfg *Config, name, value string) error {
+	name = strings.TrimPrefix(name, envPrefix)
+	var set func(v reflect.Value, t reflect.Type) (bool, error)
+	set = func(v reflect.Value, t reflect.Type) (bool, error) {
+		for i := 0; i < v.NumField(); i++ {
+			field, fieldType := v.Field(i), t.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+			if isSection(fieldType.Type) {
+				if ok, err := set(field, fieldType.Type); ok || err != nil {
+					return ok, err
+				}
+				continue
+			}
+			if fieldType.Tag.Get("env") == name {
+				return true, setFieldFromString(field, value)
+			}
+		}
+		return false, nil
+	}
+	ok, err := set(reflect.ValueOf(cfg).Elem(), reflect.TypeOf(cfg).Elem())
+	if err == nil && !ok {
+		err = fmt.Errorf("unknown setting %s", name)
+	}
+	return err
+}
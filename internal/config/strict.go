@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	envPrefix     = "MANTO_"
+	strictModeEnv = "CONFIG_STRICT"
+)
+
+// Problems is every issue found while loading configuration in strict mode.
+type Problems []string
+
+func (p Problems) Error() string {
+	return fmt.Sprintf("%d configuration problem(s):\n  - %s", len(p), strings.Join(p, "\n  - "))
+}
+
+// strictMode reports whether CONFIG_STRICT (or MANTO_CONFIG_STRICT) is set.
+// It is read before the rest of the config because it changes how loading
+// behaves.
+func strictMode() (bool, error) {
+	value := lookupEnv(strictModeEnv)
+	if value == "" {
+		return false, nil
+	}
+	strict, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: %w", strictModeEnv, value, err)
+	}
+	return strict, nil
+}
+
+// loadStrict loads the environment collecting every problem instead of
+// stopping at the first, and additionally rejects MANTO_* variables that
+// don't correspond to a known setting, so typos fail loudly.
+func loadStrict(cfg *Config) error {
+	var problems Problems
+
+	loadEnvVars(reflect.ValueOf(cfg).Elem(), reflect.TypeOf(cfg).Elem(), func(err error) error {
+		problems = append(problems, err.Error())
+		return nil
+	})
+
+	problems = append(problems, unknownPrefixedVars(os.Environ())...)
+
+	deriveAPIEndpoints(cfg)
+	if err := validate(cfg); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// processVars are MANTO_ variables that aren't settings but are passed
+// between processes: the sockets and readiness pipe a SIGHUP upgrade hands
+// its child (see package upgrade). processVarPrefixes covers those named
+// after each socket.
+var (
+	processVars        = []string{"MANTO_READY_FD"}
+	processVarPrefixes = []string{"MANTO_LISTEN_FD_"}
+)
+
+func unknownPrefixedVars(environ []string) Problems {
+	known := map[string]bool{envPrefix + strictModeEnv: true}
+	for _, name := range EnvNames() {
+		known[envPrefix+name] = true
+	}
+	for _, name := range processVars {
+		known[name] = true
+	}
+
+	var problems Problems
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, envPrefix) || known[listIndex.ReplaceAllString(name, listPlaceholder)] || isProcessVar(name) {
+			continue
+		}
+
+		problem := fmt.Sprintf("unknown environment variable %s", name)
+		if suggestion := closestName(name, known); suggestion != "" {
+			problem += fmt.Sprintf(" (did you mean %s?)", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func isProcessVar(name string) bool {
+	for _, prefix := range processVarPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listPlaceholder stands for the index in the names of list variables, e.g.
+// PROVIDERS_<n>_NAME.
+const listPlaceholder = "_<n>_"
+
+var listIndex = regexp.MustCompile(`_[0-9]+_`)
+
+// EnvNames lists every environment variable name the config understands,
+// without the optional MANTO_ prefix. Variables of list elements are named
+// with listPlaceholder in place of the index.
+func EnvNames() []string {
+	var names []string
+	collectEnvNames(reflect.TypeOf(Config{}), "", &names)
+	sort.Strings(names)
+	return names
+}
+
+func collectEnvNames(t reflect.Type, prefix string, names *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if isSection(field.Type) {
+			collectEnvNames(field.Type, prefix, names)
+			continue
+		}
+		tag := field.Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+		if isList(field.Type) {
+			collectEnvNames(field.Type.Elem(), prefix+tag+listPlaceholder, names)
+			continue
+		}
+		*names = append(*names, prefix+tag)
+	}
+}
+
+// closestName suggests a known name within a small edit distance of name.
+func closestName(name string, known map[string]bool) string {
+	best, bestDistance := "", 4
+	for candidate := range known {
+		if d := editDistance(name, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
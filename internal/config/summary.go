@@ -0,0 +1,63 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// Summary returns the effective configuration as nested maps keyed by section
+// and field name, suitable for structured logging. Fields tagged
+// `secret:"true"` are replaced with a marker when set, so the summary shows
+// whether a secret was loaded without revealing it.
+func (c *Config) Summary() map[string]interface{} {
+	return summarize(reflect.ValueOf(c).Elem(), reflect.TypeOf(c).Elem())
+}
+
+func summarize(v reflect.Value, t reflect.Type) map[string]interface{} {
+	out := make(map[string]interface{}, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		fieldType := t.Field(i)
+
+		if !fieldType.IsExported() {
+			continue
+		}
+
+		if isSection(fieldType.Type) {
+			out[fieldType.Name] = summarize(field, fieldType.Type)
+			continue
+		}
+
+		if isList(fieldType.Type) {
+			items := make([]map[string]interface{}, field.Len())
+			for j := range items {
+				items[j] = summarize(field.Index(j), fieldType.Type.Elem())
+			}
+			out[fieldType.Name] = items
+			continue
+		}
+
+		if fieldType.Tag.Get("secret") == "true" {
+			if field.IsZero() || field.Kind() == reflect.Map && field.Len() == 0 {
+				out[fieldType.Name] = ""
+			} else {
+				out[fieldType.Name] = redacted
+			}
+			continue
+		}
+
+		switch value := field.Interface().(type) {
+		case Duration:
+			out[fieldType.Name] = value.String()
+			continue
+		case time.Time:
+			out[fieldType.Name] = value.Format(time.RFC3339)
+			continue
+		}
+
+		out[fieldType.Name] = field.Interface()
+	}
+	return out
+}
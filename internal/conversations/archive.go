@@ -0,0 +1,133 @@
+package conversations
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrArchived is returned for a conversation that has been moved to the
+// archive; it must be rehydrated before it can be read or changed.
+var ErrArchived = errors.New("conversation is archived")
+
+// Archived records where an archived conversation went, so it can still be
+// listed and brought back. Object names the archive object holding it.
+type Archived struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"-"`
+	Title      string    `json:"title"`
+	Object     string    `json:"object"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+type storedArchived struct {
+	Owner string `json:"owner"`
+	*Archived
+}
+
+// Stale returns copies of the conversations, across all owners, last
+// updated before cutoff. Pinned conversations are never stale.
+func (s *Store) Stale(cutoff time.Time) []*Conversation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []*Conversation
+	for _, c := range s.conversations {
+		if !c.Pinned && c.UpdatedAt.Before(cutoff) {
+			list = append(list, c.Clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Archive removes the given conversations, now stored in object, leaving a
+// record of each behind. A conversation changed since it was read is kept,
+// as its archived copy is out of date; Archive returns the ones removed.
+func (s *Store) Archive(list []*Conversation, object string, at time.Time) ([]*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var archived []*Conversation
+	for _, c := range list {
+		current, ok := s.conversations[c.ID]
+		if !ok || current.Owner != c.Owner || current.Version != c.Version {
+			continue
+		}
+		delete(s.conversations, c.ID)
+		s.archived[c.ID] = &Archived{
+			ID:         c.ID,
+			Owner:      c.Owner,
+			Title:      c.Title,
+			Object:     object,
+			UpdatedAt:  c.UpdatedAt,
+			ArchivedAt: at,
+		}
+		archived = append(archived, c)
+	}
+	if len(archived) == 0 {
+		return nil, nil
+	}
+	return archived, s.persist()
+}
+
+// ListArchived returns owner's archived conversations, most recently updated
+// first.
+func (s *Store) ListArchived(owner string) []Archived {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []Archived{}
+	for _, a := range s.archived {
+		if a.Owner == owner {
+			list = append(list, *a)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UpdatedAt.After(list[j].UpdatedAt) })
+	return list
+}
+
+// GetArchived returns the record of owner's archived conversation id.
+func (s *Store) GetArchived(owner, id string) (Archived, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.archived[id]
+	if !ok || a.Owner != owner {
+		return Archived{}, ErrNotFound
+	}
+	return *a, nil
+}
+
+// Rehydrate puts an archived conversation back in the store, updated at so
+// it isn't archived again straight away, and forgets its archive record. c
+// must be the conversation as it was archived; if it is already back,
+// nothing changes.
+func (s *Store) Rehydrate(c *Conversation, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.archived[c.ID]
+	if !ok || a.Owner != c.Owner {
+		if current, ok := s.conversations[c.ID]; ok && current.Owner == c.Owner {
+			// Another request rehydrated it first.
+			return nil
+		}
+		return ErrNotFound
+	}
+	delete(s.archived, c.ID)
+	c = c.Clone()
+	c.UpdatedAt = at
+	s.conversations[c.ID] = c
+	return s.persist()
+}
+
+// missing is the error for a conversation that isn't in the store: whether
+// owner's conversation was archived or never existed. Callers must hold s.mu.
+func (s *Store) missing(owner, id string) error {
+	if a, ok := s.archived[id]; ok && a.Owner == owner {
+		return ErrArchived
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,263 @@
+// Package conversations provides opt-in server-side storage of chat history.
+// Messages form a tree: regenerating a reply adds a sibling under the same
+// parent, and the conversation tracks which leaf is currently active.
+package conversations
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/manto/manto-web/internal/services"
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+var (
+	ErrNotFound        = errors.New("conversation not found")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrNotEditable     = errors.New("only user messages can be edited")
+)
+
+// ConflictError reports a change refused because the conversation had moved
+// on from the version the caller last saw. Current is the conversation as
+// stored, for the caller to merge with.
+type ConflictError struct {
+	Current *Conversation
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conversation changed since it was read (now at version %d)", e.Current.Version)
+}
+
+type Message struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parentId,omitempty"`
+	Role     string `json:"role"`
+	Content  string `json:"content"`
+	// Blocks keeps an answer's content blocks when it has more than text,
+	// e.g. thinking, so they can be sent back with the history.
+	Blocks      []services.ContentBlock `json:"blocks,omitempty"`
+	Model       string                  `json:"model,omitempty"`
+	Temperature *float64                `json:"temperature,omitempty"`
+	StopReason  string                  `json:"stopReason,omitempty"`
+	Incomplete  *services.Incomplete    `json:"incomplete,omitempty"`
+	// Continuations counts the follow-up requests stitched into an answer
+	// that ran out of tokens.
+	Continuations int       `json:"continuations,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Edits         []Edit    `json:"edits,omitempty"`
+	Feedback      *Feedback `json:"feedback,omitempty"`
+}
+
+// Edit records the content a message had before it was edited.
+type Edit struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"editedAt"`
+}
+
+type Conversation struct {
+	ID           string     `json:"id"`
+	Owner        string     `json:"-"`
+	Title        string     `json:"title"`
+	Model        string     `json:"model,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Folder       string     `json:"folder,omitempty"`
+	Pinned       bool       `json:"pinned,omitempty"`
+	ActiveLeafID string     `json:"activeLeafId,omitempty"`
+	Messages     []*Message `json:"messages"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+	// Version counts the changes saved, for optimistic concurrency.
+	Version int `json:"version"`
+}
+
+// OwnerFromAPIKey derives a stable, non-reversible owner ID from the caller's
+// API key, so conversations are scoped per key without storing the key.
+func OwnerFromAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// OwnerFromSession derives the owner ID for an anonymous browser session.
+func OwnerFromSession(sessionID string) string {
+	return OwnerFromAPIKey("session:" + sessionID)
+}
+
+// NormalizeTags trims, lowercases and de-duplicates tags, dropping empty ones,
+// so "Work" and " work" filter as the same tag.
+func NormalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (c *Conversation) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func NewID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+func (c *Conversation) Message(id string) (*Message, bool) {
+	for _, m := range c.Messages {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// AddMessage appends m as a child of m.ParentID and makes it the active leaf.
+func (c *Conversation) AddMessage(m *Message) error {
+	if m.ParentID != "" {
+		if _, ok := c.Message(m.ParentID); !ok {
+			return ErrMessageNotFound
+		}
+	}
+	copied := *m
+	c.Messages = append(c.Messages, &copied)
+	c.ActiveLeafID = m.ID
+	c.UpdatedAt = m.CreatedAt
+	return nil
+}
+
+// PathTo returns the messages from the root down to id.
+func (c *Conversation) PathTo(id string) []*Message {
+	var path []*Message
+	for id != "" {
+		m, ok := c.Message(id)
+		if !ok {
+			break
+		}
+		path = append(path, m)
+		id = m.ParentID
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// ActivePath returns the currently selected branch from root to leaf.
+func (c *Conversation) ActivePath() []*Message {
+	return c.PathTo(c.ActiveLeafID)
+}
+
+// Children returns the direct replies to parentID in creation order. An empty
+// parentID returns the root messages.
+func (c *Conversation) Children(parentID string) []*Message {
+	var children []*Message
+	for _, m := range c.Messages {
+		if m.ParentID == parentID {
+			children = append(children, m)
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].CreatedAt.Before(children[j].CreatedAt)
+	})
+	return children
+}
+
+// SelectBranch makes the branch through id active, following the most recent
+// reply at each level down to a leaf.
+func (c *Conversation) SelectBranch(id string) error {
+	if _, ok := c.Message(id); !ok {
+		return ErrMessageNotFound
+	}
+	for {
+		children := c.Children(id)
+		if len(children) == 0 {
+			break
+		}
+		id = children[len(children)-1].ID
+	}
+	c.ActiveLeafID = id
+	return nil
+}
+
+// EditMessage replaces the content of user message id, keeping the previous
+// content in its edit history. Everything below the message is discarded,
+// since those replies answered the old prompt, and the message becomes the
+// active leaf.
+func (c *Conversation) EditMessage(id, content string, at time.Time) error {
+	m, ok := c.Message(id)
+	if !ok {
+		return ErrMessageNotFound
+	}
+	if m.Role != RoleUser {
+		return ErrNotEditable
+	}
+
+	m.Edits = append(m.Edits, Edit{Content: m.Content, EditedAt: at})
+	m.Content = content
+
+	removed := map[string]bool{}
+	for _, d := range c.descendants(id) {
+		removed[d.ID] = true
+	}
+	kept := c.Messages[:0]
+	for _, msg := range c.Messages {
+		if !removed[msg.ID] {
+			kept = append(kept, msg)
+		}
+	}
+	c.Messages = kept
+	c.ActiveLeafID = id
+	c.UpdatedAt = at
+	return nil
+}
+
+func (c *Conversation) descendants(id string) []*Message {
+	var out []*Message
+	for _, child := range c.Children(id) {
+		out = append(out, child)
+		out = append(out, c.descendants(child.ID)...)
+	}
+	return out
+}
+
+// Clone returns a deep copy so callers can read a conversation without
+// holding the store lock.
+func (c *Conversation) Clone() *Conversation {
+	clone := *c
+	clone.Tags = append([]string(nil), c.Tags...)
+	clone.Messages = make([]*Message, len(c.Messages))
+	for i, m := range c.Messages {
+		copied := *m
+		copied.Edits = append([]Edit(nil), m.Edits...)
+		copied.Blocks = append([]services.ContentBlock(nil), m.Blocks...)
+		if m.Feedback != nil {
+			feedback := *m.Feedback
+			copied.Feedback = &feedback
+		}
+		clone.Messages[i] = &copied
+	}
+	return &clone
+}
@@ -0,0 +1,388 @@
+package conversations
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestConversation(owner string) *Conversation {
+	now := time.Now().UTC()
+	return &Conversation{ID: NewID("conv_"), Owner: owner, Title: "test", CreatedAt: now, UpdatedAt: now}
+}
+
+func addMessage(t *testing.T, c *Conversation, id, parentID, role string, offset time.Duration) {
+	t.Helper()
+	m := &Message{ID: id, ParentID: parentID, Role: role, Content: id, CreatedAt: c.CreatedAt.Add(offset)}
+	if err := c.AddMessage(m); err != nil {
+		t.Fatalf("failed to add %s: %v", id, err)
+	}
+}
+
+func TestConversationBranching(t *testing.T) {
+	c := newTestConversation("owner")
+	addMessage(t, c, "u1", "", RoleUser, 1)
+	addMessage(t, c, "a1", "u1", RoleAssistant, 2)
+	addMessage(t, c, "u2", "a1", RoleUser, 3)
+	addMessage(t, c, "a2", "u2", RoleAssistant, 4)
+	addMessage(t, c, "a1b", "u1", RoleAssistant, 5)
+
+	tests := []struct {
+		name     string
+		selectID string
+		expected []string
+	}{
+		{name: "new branch is active", expected: []string{"u1", "a1b"}},
+		{name: "selecting a branch follows it to the latest leaf", selectID: "a1", expected: []string{"u1", "a1", "u2", "a2"}},
+		{name: "selecting a shared ancestor picks the newest reply", selectID: "u1", expected: []string{"u1", "a1b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.selectID != "" {
+				if err := c.SelectBranch(tt.selectID); err != nil {
+					t.Fatalf("select failed: %v", err)
+				}
+			}
+			path := c.ActivePath()
+			if len(path) != len(tt.expected) {
+				t.Fatalf("expected path %v, got %d messages", tt.expected, len(path))
+			}
+			for i, m := range path {
+				if m.ID != tt.expected[i] {
+					t.Errorf("expected %s at %d, got %s", tt.expected[i], i, m.ID)
+				}
+			}
+		})
+	}
+
+	if children := c.Children("u1"); len(children) != 2 || children[0].ID != "a1" {
+		t.Errorf("expected two replies to u1 in creation order, got %d", len(children))
+	}
+	if err := c.AddMessage(&Message{ID: "x", ParentID: "missing"}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound for unknown parent, got %v", err)
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	c := newTestConversation("owner")
+	addMessage(t, c, "u1", "", RoleUser, 1)
+	addMessage(t, c, "a1", "u1", RoleAssistant, 2)
+	addMessage(t, c, "u2", "a1", RoleUser, 3)
+	addMessage(t, c, "a2", "u2", RoleAssistant, 4)
+	addMessage(t, c, "a1b", "u1", RoleAssistant, 5)
+
+	if err := c.EditMessage("a1", "nope", time.Now()); !errors.Is(err, ErrNotEditable) {
+		t.Errorf("expected ErrNotEditable for assistant message, got %v", err)
+	}
+
+	if err := c.EditMessage("u1", "edited", time.Now()); err != nil {
+		t.Fatalf("edit failed: %v", err)
+	}
+	if len(c.Messages) != 1 || c.ActiveLeafID != "u1" {
+		t.Fatalf("expected every downstream branch removed, got %d messages", len(c.Messages))
+	}
+	m, _ := c.Message("u1")
+	if m.Content != "edited" || len(m.Edits) != 1 || m.Edits[0].Content != "u1" {
+		t.Errorf("expected edit history to keep old content, got %+v", m)
+	}
+}
+
+func TestStoreBehavior(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	c := newTestConversation("alice")
+	if err := store.Create(c); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, err := store.Get("bob", c.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected other owners to get ErrNotFound, got %v", err)
+	}
+
+	_, err = store.Update("alice", c.ID, func(c *Conversation) error {
+		return c.AddMessage(&Message{ID: "u1", Role: RoleUser, Content: "hi", CreatedAt: time.Now()})
+	})
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	_, err = store.Update("alice", c.ID, func(c *Conversation) error {
+		c.Title = "discarded"
+		return errors.New("boom")
+	})
+	if err == nil {
+		t.Fatal("expected update error to propagate")
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	got, err := reopened.Get("alice", c.ID)
+	if err != nil {
+		t.Fatalf("expected conversation to persist: %v", err)
+	}
+	if got.Title != "test" || got.ActiveLeafID != "u1" || len(got.Messages) != 1 {
+		t.Errorf("unexpected persisted conversation: %+v", got)
+	}
+	if list := reopened.List("alice", ListOptions{}); len(list) != 1 {
+		t.Errorf("expected one conversation for alice, got %d", len(list))
+	}
+
+	if err := reopened.Delete("alice", c.ID); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := reopened.Get("alice", c.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected deleted conversation to be gone, got %v", err)
+	}
+}
+
+func TestStoreVersioning(t *testing.T) {
+	store, _ := NewStore("")
+	c := newTestConversation("alice")
+	store.Create(c)
+	at := func(version int) func(int) bool {
+		return func(v int) bool { return v == version }
+	}
+
+	updated, err := store.UpdateIf("alice", c.ID, at(0), func(c *Conversation) error {
+		c.Title = "first"
+		return nil
+	})
+	if err != nil || updated.Version != 1 {
+		t.Fatalf("expected the update to save version 1, got %v (%v)", updated, err)
+	}
+
+	_, err = store.UpdateIf("alice", c.ID, at(0), func(c *Conversation) error {
+		c.Title = "stale"
+		return nil
+	})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Current.Version != 1 || conflict.Current.Title != "first" {
+		t.Fatalf("expected a conflict carrying version 1, got %v", err)
+	}
+
+	if err := store.DeleteIf("alice", c.ID, at(0)); !errors.As(err, &conflict) {
+		t.Errorf("expected a stale delete to conflict, got %v", err)
+	}
+	if err := store.DeleteIf("alice", c.ID, at(1)); err != nil {
+		t.Errorf("expected a current delete to succeed, got %v", err)
+	}
+}
+
+func TestStoreArchiving(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.json")
+	store, _ := NewStore(path)
+	old, pinned, recent, changed := newTestConversation("alice"), newTestConversation("alice"), newTestConversation("alice"), newTestConversation("alice")
+	for _, c := range []*Conversation{old, pinned, changed} {
+		c.UpdatedAt = c.UpdatedAt.Add(-48 * time.Hour)
+	}
+	pinned.Pinned = true
+	for _, c := range []*Conversation{old, pinned, recent, changed} {
+		store.Create(c)
+	}
+
+	stale := store.Stale(time.Now().Add(-24 * time.Hour))
+	if len(stale) != 2 {
+		t.Fatalf("expected the two unpinned old conversations to be stale, got %d", len(stale))
+	}
+	store.Update("alice", changed.ID, func(c *Conversation) error { return nil })
+
+	archived, err := store.Archive(stale, "archive/1.jsonl", time.Now())
+	if err != nil || len(archived) != 1 || archived[0].ID != old.ID {
+		t.Fatalf("expected only the unchanged conversation to be archived, got %v (%v)", archived, err)
+	}
+	if _, err := store.Get("alice", old.ID); !errors.Is(err, ErrArchived) {
+		t.Errorf("expected ErrArchived, got %v", err)
+	}
+	if _, err := store.Get("bob", old.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected other owners to get ErrNotFound, got %v", err)
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	list := reopened.ListArchived("alice")
+	if len(list) != 1 || list[0].ID != old.ID || list[0].Object != "archive/1.jsonl" {
+		t.Fatalf("expected the archive record to persist, got %+v", list)
+	}
+
+	now := time.Now()
+	if err := reopened.Rehydrate(archived[0], now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := reopened.Get("alice", old.ID)
+	if err != nil || !got.UpdatedAt.Equal(now) {
+		t.Errorf("expected the conversation back and touched, got %v (%v)", got, err)
+	}
+	if len(reopened.ListArchived("alice")) != 0 {
+		t.Error("expected the archive record to be dropped")
+	}
+	if err := reopened.Rehydrate(archived[0], now); err != nil {
+		t.Errorf("expected rehydrating twice to be harmless, got %v", err)
+	}
+}
+
+func TestStoreFeedback(t *testing.T) {
+	store, _ := NewStore("")
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, owner := range []string{"alice", "bob"} {
+		c := newTestConversation(owner)
+		addMessage(t, c, owner+"-u", "", RoleUser, 1)
+		addMessage(t, c, owner+"-a", owner+"-u", RoleAssistant, 2)
+		c.Messages[1].Model = "haiku"
+		store.Create(c)
+	}
+
+	if _, err := store.SetFeedback("alice", "alice-u", Feedback{Rating: RatingUp}); !errors.Is(err, ErrNotRateable) {
+		t.Errorf("expected user messages to be unrateable, got %v", err)
+	}
+	if _, err := store.SetFeedback("alice", "bob-a", Feedback{Rating: RatingUp}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected other owners' messages to be hidden, got %v", err)
+	}
+	if _, err := store.SetFeedback("alice", "alice-a", Feedback{Rating: "meh"}); !errors.Is(err, ErrInvalidRating) {
+		t.Errorf("expected invalid rating, got %v", err)
+	}
+
+	store.SetFeedback("alice", "alice-a", Feedback{Rating: RatingDown, CreatedAt: day})
+	store.SetFeedback("alice", "alice-a", Feedback{Rating: RatingUp, Comment: "better", CreatedAt: day})
+	store.SetFeedback("bob", "bob-a", Feedback{Rating: RatingDown, CreatedAt: day.AddDate(0, 0, 1)})
+
+	if report := store.Feedback("alice", time.Time{}); report.Total.Up != 1 || report.Total.Down != 0 || len(report.RecentComments) != 1 {
+		t.Errorf("expected re-rating to replace feedback, got %+v", report)
+	}
+	all := store.Feedback("", time.Time{})
+	if all.Total.Up != 1 || all.Total.Down != 1 || all.ByModel[0].ApprovalRate != 0.5 || len(all.ByDay) != 2 || all.ByDay[0].Key != "2026-03-01" {
+		t.Errorf("unexpected deployment-wide report %+v", all)
+	}
+	if since := store.Feedback("", day.AddDate(0, 0, 1)); since.Total.Down != 1 || since.Total.Up != 0 {
+		t.Errorf("expected since to drop older ratings, got %+v", since.Total)
+	}
+}
+
+func TestStoreListFiltering(t *testing.T) {
+	store, _ := NewStore("")
+	pinned := true
+	unpinned := false
+
+	work := newTestConversation("alice")
+	work.Tags = NormalizeTags([]string{" Work", "urgent", "work"})
+	work.Folder = "projects"
+	old := newTestConversation("alice")
+	old.Pinned = true
+	old.UpdatedAt = work.UpdatedAt.Add(-time.Hour)
+	for _, c := range []*Conversation{work, old, newTestConversation("bob")} {
+		store.Create(c)
+	}
+
+	tests := []struct {
+		name     string
+		opts     ListOptions
+		expected []string
+	}{
+		{name: "all, pinned first", expected: []string{old.ID, work.ID}},
+		{name: "by tag ignoring case", opts: ListOptions{Tag: "WORK"}, expected: []string{work.ID}},
+		{name: "by folder", opts: ListOptions{Folder: "projects"}, expected: []string{work.ID}},
+		{name: "pinned only", opts: ListOptions{Pinned: &pinned}, expected: []string{old.ID}},
+		{name: "unpinned only", opts: ListOptions{Pinned: &unpinned}, expected: []string{work.ID}},
+		{name: "no match", opts: ListOptions{Tag: "missing"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := store.List("alice", tt.opts)
+			if len(list) != len(tt.expected) {
+				t.Fatalf("expected %d conversations, got %d", len(tt.expected), len(list))
+			}
+			for i, c := range list {
+				if c.ID != tt.expected[i] {
+					t.Errorf("expected %s at %d, got %s", tt.expected[i], i, c.ID)
+				}
+			}
+		})
+	}
+
+	if len(work.Tags) != 2 {
+		t.Errorf("expected tags to be normalized and de-duplicated, got %v", work.Tags)
+	}
+}
+
+func TestStoreSearch(t *testing.T) {
+	store, _ := NewStore("")
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	work := newTestConversation("alice")
+	work.Model = "haiku"
+	work.Tags = []string{"work"}
+	for i, content := range []string{"Deploy the Go service", "Use a rolling deploy", "deploy again"} {
+		role := RoleUser
+		if i%2 == 1 {
+			role = RoleAssistant
+		}
+		work.Messages = append(work.Messages, &Message{ID: NewID("msg_"), Role: role, Content: content, CreatedAt: base.Add(time.Duration(i) * time.Hour)})
+	}
+	other := newTestConversation("bob")
+	other.Messages = []*Message{{ID: "b1", Role: RoleUser, Content: "deploy", CreatedAt: base}}
+	store.Create(work)
+	store.Create(other)
+
+	tests := []struct {
+		name     string
+		query    SearchQuery
+		expected []string
+		err      error
+	}{
+		{name: "case insensitive, newest first", query: SearchQuery{Text: "DEPLOY"}, expected: []string{"deploy again", "Use a rolling deploy", "Deploy the Go service"}},
+		{name: "oldest first", query: SearchQuery{Text: "deploy", Sort: SortOldest}, expected: []string{"Deploy the Go service", "Use a rolling deploy", "deploy again"}},
+		{name: "by role", query: SearchQuery{Text: "deploy", Role: RoleAssistant}, expected: []string{"Use a rolling deploy"}},
+		{name: "by conversation model", query: SearchQuery{Model: "haiku", Sort: SortOldest, Limit: 1}, expected: []string{"Deploy the Go service"}},
+		{name: "by date range", query: SearchQuery{From: base.Add(time.Hour), To: base.Add(2 * time.Hour)}, expected: []string{"Use a rolling deploy"}},
+		{name: "by tag", query: SearchQuery{Tag: "personal"}},
+		{name: "invalid sort", query: SearchQuery{Sort: "relevance"}, err: ErrInvalidSort},
+		{name: "invalid cursor", query: SearchQuery{Cursor: "!!"}, err: ErrInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := store.Search("alice", tt.query)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if len(result.Hits) != len(tt.expected) {
+				t.Fatalf("expected %d hits, got %+v", len(tt.expected), result.Hits)
+			}
+			for i, hit := range result.Hits {
+				if hit.Snippet != tt.expected[i] {
+					t.Errorf("expected %q at %d, got %q", tt.expected[i], i, hit.Snippet)
+				}
+			}
+		})
+	}
+
+	t.Run("cursor pagination", func(t *testing.T) {
+		var seen []string
+		q := SearchQuery{Text: "deploy", Limit: 2}
+		for page := 0; page < 3; page++ {
+			result, err := store.Search("alice", q)
+			if err != nil {
+				t.Fatalf("search failed: %v", err)
+			}
+			for _, hit := range result.Hits {
+				seen = append(seen, hit.MessageID)
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			q.Cursor = result.NextCursor
+		}
+		if len(seen) != 3 || seen[0] == seen[2] {
+			t.Errorf("expected three distinct hits across pages, got %v", seen)
+		}
+	})
+}
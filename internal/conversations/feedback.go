@@ -0,0 +1,173 @@
+package conversations
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	RatingUp   = "up"
+	RatingDown = "down"
+
+	maxFeedbackComment = 2000
+)
+
+var (
+	ErrNotRateable   = errors.New("only assistant messages can be rated")
+	ErrInvalidRating = errors.New(`rating must be "up" or "down"`)
+)
+
+// Feedback is a thumbs-up or thumbs-down on an assistant message, with an
+// optional comment. Rating a message again replaces its feedback.
+type Feedback struct {
+	Rating    string    `json:"rating"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SetFeedback rates owner's message id, wherever it is, and returns the
+// updated message. Comments longer than 2000 bytes are truncated.
+func (s *Store) SetFeedback(owner, messageID string, f Feedback) (*Message, error) {
+	if f.Rating != RatingUp && f.Rating != RatingDown {
+		return nil, ErrInvalidRating
+	}
+	if len(f.Comment) > maxFeedbackComment {
+		f.Comment = f.Comment[:maxFeedbackComment]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conversations {
+		if c.Owner != owner {
+			continue
+		}
+		m, ok := c.Message(messageID)
+		if !ok {
+			continue
+		}
+		if m.Role != RoleAssistant {
+			return nil, ErrNotRateable
+		}
+		previous := m.Feedback
+		m.Feedback = &f
+		c.Version++
+		if err := s.persist(); err != nil {
+			m.Feedback = previous
+			c.Version--
+			return nil, err
+		}
+		copied := *m
+		return &copied, nil
+	}
+	return nil, ErrMessageNotFound
+}
+
+// FeedbackCount totals ratings for one model or day.
+type FeedbackCount struct {
+	Key          string  `json:"key"`
+	Up           int     `json:"up"`
+	Down         int     `json:"down"`
+	ApprovalRate float64 `json:"approvalRate"`
+}
+
+func (c *FeedbackCount) add(rating string) {
+	if rating == RatingUp {
+		c.Up++
+	} else {
+		c.Down++
+	}
+	c.ApprovalRate = float64(c.Up) / float64(c.Up+c.Down)
+}
+
+// FeedbackReport aggregates ratings overall, per model and per UTC day, with
+// the most recent comments.
+type FeedbackReport struct {
+	Total          FeedbackCount   `json:"total"`
+	ByModel        []FeedbackCount `json:"byModel"`
+	ByDay          []FeedbackCount `json:"byDay"`
+	RecentComments []FeedbackNote  `json:"recentComments"`
+}
+
+// FeedbackNote is a commented rating.
+type FeedbackNote struct {
+	MessageID string    `json:"messageId"`
+	Model     string    `json:"model,omitempty"`
+	Rating    string    `json:"rating"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const recentFeedbackComments = 20
+
+// Feedback aggregates the ratings on owner's conversations, or on every
+// conversation when owner is empty, that were given at or after since.
+func (s *Store) Feedback(owner string, since time.Time) FeedbackReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	report := FeedbackReport{Total: FeedbackCount{Key: "total"}, ByModel: []FeedbackCount{}, ByDay: []FeedbackCount{}, RecentComments: []FeedbackNote{}}
+	byModel := map[string]*FeedbackCount{}
+	byDay := map[string]*FeedbackCount{}
+	for _, c := range s.conversations {
+		if owner != "" && c.Owner != owner {
+			continue
+		}
+		for _, m := range c.Messages {
+			f := m.Feedback
+			if f == nil || f.CreatedAt.Before(since) {
+				continue
+			}
+			report.Total.add(f.Rating)
+			count(byModel, m.Model).add(f.Rating)
+			count(byDay, f.CreatedAt.UTC().Format(time.DateOnly)).add(f.Rating)
+			if f.Comment != "" {
+				report.RecentComments = append(report.RecentComments, FeedbackNote{
+					MessageID: m.ID,
+					Model:     m.Model,
+					Rating:    f.Rating,
+					Comment:   f.Comment,
+					CreatedAt: f.CreatedAt,
+				})
+			}
+		}
+	}
+
+	for _, c := range byModel {
+		report.ByModel = append(report.ByModel, *c)
+	}
+	sort.Slice(report.ByModel, func(i, j int) bool { return report.ByModel[i].Key < report.ByModel[j].Key })
+	for _, c := range byDay {
+		report.ByDay = append(report.ByDay, *c)
+	}
+	sort.Slice(report.ByDay, func(i, j int) bool { return report.ByDay[i].Key < report.ByDay[j].Key })
+	sort.Slice(report.RecentComments, func(i, j int) bool {
+		return report.RecentComments[i].CreatedAt.After(report.RecentComments[j].CreatedAt)
+	})
+	if len(report.RecentComments) > recentFeedbackComments {
+		report.RecentComments = report.RecentComments[:recentFeedbackComments]
+	}
+	return report
+}
+
+// ParseSince parses a report's lower bound, RFC 3339 or YYYY-MM-DD. An empty
+// value means no bound.
+func ParseSince(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, raw)
+}
+
+func count(counts map[string]*FeedbackCount, key string) *FeedbackCount {
+	c, ok := counts[key]
+	if !ok {
+		c = &FeedbackCount{Key: key}
+		counts[key] = c
+	}
+	return c
+}
@@ -0,0 +1,216 @@
+package conversations
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	SortNewest = "newest"
+	SortOldest = "oldest"
+
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+
+	snippetRadius = 80
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort")
+)
+
+// SearchQuery selects messages across an owner's conversations. Every field
+// is optional; an empty query matches every message.
+type SearchQuery struct {
+	Text   string
+	Model  string
+	Role   string
+	Tag    string
+	Folder string
+	From   time.Time
+	To     time.Time
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+type SearchHit struct {
+	ConversationID    string    `json:"conversationId"`
+	ConversationTitle string    `json:"conversationTitle"`
+	MessageID         string    `json:"messageId"`
+	Role              string    `json:"role"`
+	Model             string    `json:"model,omitempty"`
+	Snippet           string    `json:"snippet"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// SearchResult is one page of hits. NextCursor is empty on the last page.
+type SearchResult struct {
+	Hits       []SearchHit `json:"hits"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// Search matches message content case-insensitively and returns a page of
+// hits ordered by message time. The cursor encodes the position of the last
+// hit rather than an offset, so pages stay stable while new messages arrive.
+func (s *Store) Search(owner string, q SearchQuery) (SearchResult, error) {
+	if q.Sort == "" {
+		q.Sort = SortNewest
+	}
+	if q.Sort != SortNewest && q.Sort != SortOldest {
+		return SearchResult{}, ErrInvalidSort
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit > MaxSearchLimit {
+		q.Limit = MaxSearchLimit
+	}
+
+	var after *cursor
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		after = &c
+	}
+
+	needle := strings.ToLower(q.Text)
+	var hits []SearchHit
+
+	s.mu.RLock()
+	for _, c := range s.conversations {
+		if c.Owner != owner {
+			continue
+		}
+		if q.Tag != "" && !c.HasTag(q.Tag) {
+			continue
+		}
+		if q.Folder != "" && c.Folder != q.Folder {
+			continue
+		}
+		for _, m := range c.Messages {
+			model := m.Model
+			if model == "" {
+				model = c.Model
+			}
+			if q.Model != "" && model != q.Model {
+				continue
+			}
+			if q.Role != "" && m.Role != q.Role {
+				continue
+			}
+			if !q.From.IsZero() && m.CreatedAt.Before(q.From) {
+				continue
+			}
+			if !q.To.IsZero() && !m.CreatedAt.Before(q.To) {
+				continue
+			}
+			index := strings.Index(strings.ToLower(m.Content), needle)
+			if index < 0 {
+				continue
+			}
+			hits = append(hits, SearchHit{
+				ConversationID:    c.ID,
+				ConversationTitle: c.Title,
+				MessageID:         m.ID,
+				Role:              m.Role,
+				Model:             model,
+				Snippet:           snippet(m.Content, index, len(needle)),
+				CreatedAt:         m.CreatedAt,
+			})
+		}
+	}
+	s.mu.RUnlock()
+
+	less := func(a, b cursor) bool {
+		if !a.at.Equal(b.at) {
+			if q.Sort == SortOldest {
+				return a.at.Before(b.at)
+			}
+			return a.at.After(b.at)
+		}
+		return a.id < b.id
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return less(hitCursor(hits[i]), hitCursor(hits[j]))
+	})
+
+	if after != nil {
+		start := sort.Search(len(hits), func(i int) bool {
+			return less(*after, hitCursor(hits[i]))
+		})
+		hits = hits[start:]
+	}
+
+	result := SearchResult{Hits: hits}
+	if len(hits) > q.Limit {
+		result.Hits = hits[:q.Limit]
+		result.NextCursor = encodeCursor(hitCursor(result.Hits[q.Limit-1]))
+	}
+	if result.Hits == nil {
+		result.Hits = []SearchHit{}
+	}
+	return result, nil
+}
+
+type cursor struct {
+	at time.Time
+	id string
+}
+
+func hitCursor(h SearchHit) cursor {
+	return cursor{at: h.CreatedAt, id: h.MessageID}
+}
+
+func encodeCursor(c cursor) string {
+	raw := strconv.FormatInt(c.at.UnixNano(), 10) + ":" + c.id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cursor{at: time.Unix(0, n), id: id}, nil
+}
+
+// snippet returns the text around the match at index, aligned to character
+// boundaries and marked with ellipses where it was cut.
+func snippet(content string, index, length int) string {
+	start := index - snippetRadius
+	end := index + length + snippetRadius
+	prefix, suffix := "…", "…"
+	if start <= 0 {
+		start, prefix = 0, ""
+	}
+	if end >= len(content) {
+		end, suffix = len(content), ""
+	}
+	if start > end {
+		start = end
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+	return prefix + content[start:end] + suffix
+}
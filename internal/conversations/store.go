@@ -0,0 +1,241 @@
+package conversations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/manto/manto-web/internal/atomicfile"
+)
+
+// Store keeps conversations in memory, optionally snapshotting them to a JSON
+// file after every change so they survive restarts.
+type Store struct {
+	path string
+
+	mu            sync.RWMutex
+	conversations map[string]*Conversation
+	archived      map[string]*Archived
+}
+
+type snapshot struct {
+	Conversations []*storedConversation `json:"conversations"`
+	Archived      []*storedArchived     `json:"archived,omitempty"`
+}
+
+// storedConversation keeps the owner, which is hidden from API responses.
+type storedConversation struct {
+	Owner string `json:"owner"`
+	*Conversation
+}
+
+// NewStore opens a store backed by path. An empty path keeps everything in
+// memory only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:          path,
+		conversations: make(map[string]*Conversation),
+		archived:      make(map[string]*Archived),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conversation store: %w", err)
+	}
+
+	s.conversations, s.archived, err = parseSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Replace swaps the store's contents for the snapshot in data, as written to
+// the store's file, and persists it. It is how a backup is restored into a
+// running server.
+func (s *Store) Replace(data []byte) error {
+	conversations, archived, err := parseSnapshot(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversations, s.archived = conversations, archived
+	return s.persist()
+}
+
+func parseSnapshot(data []byte) (map[string]*Conversation, map[string]*Archived, error) {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse conversation store: %w", err)
+	}
+	conversations := make(map[string]*Conversation, len(snap.Conversations))
+	for _, stored := range snap.Conversations {
+		stored.Conversation.Owner = stored.Owner
+		conversations[stored.ID] = stored.Conversation
+	}
+	archived := make(map[string]*Archived, len(snap.Archived))
+	for _, stored := range snap.Archived {
+		stored.Archived.Owner = stored.Owner
+		archived[stored.ID] = stored.Archived
+	}
+	return conversations, archived, nil
+}
+
+func (s *Store) Create(c *Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conversations[c.ID] = c.Clone()
+	return s.persist()
+}
+
+// Get returns a copy of the conversation if it exists and belongs to owner.
+func (s *Store) Get(owner, id string) (*Conversation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.conversations[id]
+	if !ok || c.Owner != owner {
+		return nil, s.missing(owner, id)
+	}
+	return c.Clone(), nil
+}
+
+// ListOptions narrows List. Zero values match everything.
+type ListOptions struct {
+	Tag    string
+	Folder string
+	Pinned *bool
+}
+
+func (o ListOptions) matches(c *Conversation) bool {
+	if o.Tag != "" && !c.HasTag(o.Tag) {
+		return false
+	}
+	if o.Folder != "" && c.Folder != o.Folder {
+		return false
+	}
+	if o.Pinned != nil && c.Pinned != *o.Pinned {
+		return false
+	}
+	return true
+}
+
+// List returns copies of owner's conversations matching opts, pinned ones
+// first and otherwise most recently updated first.
+func (s *Store) List(owner string, opts ListOptions) []*Conversation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []*Conversation
+	for _, c := range s.conversations {
+		if c.Owner == owner && opts.matches(c) {
+			list = append(list, c.Clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Pinned != list[j].Pinned {
+			return list[i].Pinned
+		}
+		return list[i].UpdatedAt.After(list[j].UpdatedAt)
+	})
+	return list
+}
+
+// Update applies fn to the stored conversation under the store lock and
+// persists the result with its version bumped. If fn returns an error
+// nothing is saved.
+func (s *Store) Update(owner, id string, fn func(*Conversation) error) (*Conversation, error) {
+	return s.UpdateIf(owner, id, nil, fn)
+}
+
+// UpdateIf is Update for callers that read the conversation earlier: when
+// match rejects the stored version, nothing is changed and a
+// *ConflictError carrying the current conversation is returned. A nil match
+// accepts any version.
+func (s *Store) UpdateIf(owner, id string, match func(version int) bool, fn func(*Conversation) error) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conversations[id]
+	if !ok || c.Owner != owner {
+		return nil, s.missing(owner, id)
+	}
+	if match != nil && !match(c.Version) {
+		return nil, &ConflictError{Current: c.Clone()}
+	}
+
+	working := c.Clone()
+	if err := fn(working); err != nil {
+		return nil, err
+	}
+	working.Version = c.Version + 1
+	s.conversations[id] = working
+	if err := s.persist(); err != nil {
+		return nil, err
+	}
+	return working.Clone(), nil
+}
+
+func (s *Store) Delete(owner, id string) error {
+	return s.DeleteIf(owner, id, nil)
+}
+
+// DeleteIf deletes the conversation only if match accepts its version, as
+// UpdateIf does.
+func (s *Store) DeleteIf(owner, id string, match func(version int) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conversations[id]
+	if !ok || c.Owner != owner {
+		return s.missing(owner, id)
+	}
+	if match != nil && !match(c.Version) {
+		return &ConflictError{Current: c.Clone()}
+	}
+	delete(s.conversations, id)
+	return s.persist()
+}
+
+// persist writes the snapshot atomically. Callers must hold s.mu.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	snap := snapshot{Conversations: make([]*storedConversation, 0, len(s.conversations))}
+	for _, c := range s.conversations {
+		snap.Conversations = append(snap.Conversations, &storedConversation{Owner: c.Owner, Conversation: c})
+	}
+	sort.Slice(snap.Conversations, func(i, j int) bool {
+		return snap.Conversations[i].ID < snap.Conversations[j].ID
+	})
+	for _, a := range s.archived {
+		snap.Archived = append(snap.Archived, &storedArchived{Owner: a.Owner, Archived: a})
+	}
+	sort.Slice(snap.Archived, func(i, j int) bool {
+		return snap.Archived[i].ID < snap.Archived[j].ID
+	})
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation store: %w", err)
+	}
+
+	if err := atomicfile.Write(s.path, data); err != nil {
+		return fmt.Errorf("failed to write conversation store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package devmode
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/middleware/timeout"
+)
+
+const (
+	ReloadPath = "/__dev/reload"
+	ScriptPath = "/__dev/livereload.js"
+)
+
+const reloadScript = `(function () {
+  var source = new EventSource("` + ReloadPath + `");
+  source.addEventListener("reload", function () {
+    source.close();
+    window.location.reload();
+  });
+})();
+`
+
+// Reloader polls a static directory for changes and notifies connected
+// browsers over server-sent events so they can refresh.
+type Reloader struct {
+	fsys     fs.FS
+	interval time.Duration
+
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+func NewReloader(fsys fs.FS, interval time.Duration) *Reloader {
+	return &Reloader{
+		fsys:     fsys,
+		interval: interval,
+		clients:  make(map[chan struct{}]struct{}),
+	}
+}
+
+// Watch blocks, polling for modifications until stop is closed.
+func (rl *Reloader) Watch(stop <-chan struct{}) {
+	last := rl.snapshot()
+	ticker := time.NewTicker(rl.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := rl.snapshot()
+			if current != last {
+				last = current
+				log.Printf("Static files changed, reloading browsers")
+				rl.broadcast()
+			}
+		}
+	}
+}
+
+func (rl *Reloader) snapshot() string {
+	var b strings.Builder
+	_ = fs.WalkDir(rl.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return b.String()
+}
+
+func (rl *Reloader) broadcast() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for ch := range rl.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (rl *Reloader) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	rl.mu.Lock()
+	rl.clients[ch] = struct{}{}
+	rl.mu.Unlock()
+	return ch
+}
+
+func (rl *Reloader) unsubscribe(ch chan struct{}) {
+	rl.mu.Lock()
+	delete(rl.clients, ch)
+	rl.mu.Unlock()
+}
+
+// EventsHandler streams a "reload" event whenever the watched files change.
+func (rl *Reloader) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	r = timeout.Stream(r)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ch := rl.subscribe()
+	defer rl.unsubscribe(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func ScriptHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(reloadScript))
+}
+
+// NoCache disables browser caching so edited assets are always refetched.
+func NoCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+		w.Header().Set("Pragma", "no-cache")
+		w.Header().Set("Expires", "0")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InjectScript appends the live reload script tag to HTML responses.
+func InjectScript(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		body := rec.body.Bytes()
+		if strings.HasPrefix(rec.header.Get("Content-Type"), "text/html") {
+			tag := []byte(`<script src="` + ScriptPath + `"></script></body>`)
+			body = bytes.Replace(body, []byte("</body>"), tag, 1)
+			rec.header.Del("Content-Length")
+		}
+
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.status)
+		w.Write(body)
+	})
+}
+
+type bufferedResponse struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
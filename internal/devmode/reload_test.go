@@ -0,0 +1,77 @@
+package devmode
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInjectScriptBehavior(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expectTag   bool
+	}{
+		{
+			name:        "injects script into HTML",
+			contentType: "text/html; charset=utf-8",
+			body:        "<html><body><p>hi</p></body></html>",
+			expectTag:   true,
+		},
+		{
+			name:        "leaves other content untouched",
+			contentType: "text/css",
+			body:        "body { color: red; }",
+			expectTag:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := InjectScript(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			}))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			hasTag := strings.Contains(w.Body.String(), ScriptPath)
+			if hasTag != tt.expectTag {
+				t.Errorf("expected script tag present=%v, got body %q", tt.expectTag, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReloaderBroadcastsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index.html")
+	if err := os.WriteFile(path, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reloader := NewReloader(os.DirFS(dir), 5*time.Millisecond)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go reloader.Watch(stop)
+
+	ch := reloader.subscribe()
+	defer reloader.unsubscribe(ch)
+
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("two!"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("expected reload notification after file change")
+	}
+}
@@ -0,0 +1,212 @@
+// Package dlp keeps an audit trail of prompts and answers that moderation
+// blocked, redacted or flagged, for compliance review. Content is never kept
+// as is: each entry holds a hash of the text and, optionally, a snippet with
+// every finding masked.
+package dlp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/moderation"
+)
+
+// maxSnippet bounds snippets, in bytes.
+const maxSnippet = 200
+
+// Entry is one recorded action. Attribution identifies who sent the content:
+// the API key fingerprint, session and model, as far as they are known.
+type Entry struct {
+	Time        time.Time         `json:"time"`
+	Stage       string            `json:"stage"`
+	Action      string            `json:"action"`
+	Checkers    []string          `json:"checkers"`
+	Rules       []string          `json:"rules"`
+	Hash        string            `json:"hash"`
+	Snippet     string            `json:"snippet,omitempty"`
+	Attribution map[string]string `json:"attribution,omitempty"`
+}
+
+// Log holds the most recent entries in memory and, when a file is
+// configured, appends every entry to it as a JSON line. A nil *Log records
+// nothing.
+type Log struct {
+	hashKey  []byte
+	snippets bool
+	max      int
+
+	mu      sync.Mutex
+	entries []Entry
+	file    *os.File
+}
+
+// Open returns the log cfg describes, or nil when the audit is disabled.
+// Entries already in the file are loaded, up to the in-memory limit.
+func Open(cfg config.DLPConfig) (*Log, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	l := &Log{hashKey: []byte(cfg.HashKey), snippets: cfg.Snippets, max: cfg.MaxEntries}
+	if cfg.File == "" {
+		return l, nil
+	}
+
+	if err := l.load(cfg.File); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DLP audit file: %w", err)
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *Log) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read DLP audit file: %w", err)
+	}
+	defer f.Close()
+	return l.read(f)
+}
+
+// read appends the entries in r, one JSON line each.
+func (l *Log) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("failed to parse DLP audit file: %w", err)
+		}
+		l.append(e)
+	}
+	return scanner.Err()
+}
+
+// Record implements moderation.Recorder.
+func (l *Log) Record(event moderation.Event) {
+	if l == nil {
+		return
+	}
+	e := Entry{
+		Time:        event.Time,
+		Stage:       event.Stage,
+		Action:      event.Action,
+		Checkers:    event.Checkers,
+		Rules:       event.Rules,
+		Hash:        l.hash(event.Text),
+		Attribution: event.Attribution,
+	}
+	if l.snippets {
+		e.Snippet = truncate(moderation.MaskFindings(event.Text, event.Findings), maxSnippet)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.append(e)
+	if l.file != nil {
+		line, _ := json.Marshal(e)
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			slog.Error("failed to write DLP audit entry", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (l *Log) append(e Entry) {
+	l.entries = append(l.entries, e)
+	if over := len(l.entries) - l.max; l.max > 0 && over > 0 {
+		l.entries = append(l.entries[:0:0], l.entries[over:]...)
+	}
+}
+
+// Entries returns the entries recorded at or after since, oldest first.
+func (l *Log) Entries(since time.Time) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []Entry{}
+	for _, e := range l.entries {
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Replace swaps the log's entries for the JSON lines in data and rewrites the
+// audit file to match. It is how a backup is restored into a running server.
+func (l *Log) Replace(data []byte) error {
+	fresh := &Log{max: l.max}
+	if err := fresh.read(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = fresh.entries
+	if l.file == nil {
+		return nil
+	}
+	path := l.file.Name()
+	l.file.Close()
+	err := os.WriteFile(path, data, 0o600)
+	f, openErr := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if openErr != nil {
+		l.file = nil
+		return fmt.Errorf("failed to reopen DLP audit file: %w", openErr)
+	}
+	l.file = f
+	if err != nil {
+		return fmt.Errorf("failed to write DLP audit file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the audit file.
+func (l *Log) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// hash identifies text without revealing it: HMAC-SHA256 under the
+// deployment's key when there is one, so short prompts cannot be guessed by
+// hashing candidates, and plain SHA-256 otherwise.
+func (l *Log) hash(text string) string {
+	if len(l.hashKey) == 0 {
+		sum := sha256.Sum256([]byte(text))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, l.hashKey)
+	mac.Write([]byte(text))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
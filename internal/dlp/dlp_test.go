@@ -0,0 +1,112 @@
+package dlp
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/moderation"
+)
+
+func event(text string) moderation.Event {
+	start := strings.Index(text, "secret")
+	return moderation.Event{
+		Time:        time.Now().UTC(),
+		Stage:       moderation.StageInput,
+		Action:      moderation.ActionBlock,
+		Checkers:    []string{"keywords"},
+		Rules:       []string{moderation.ActionBlock},
+		Text:        text,
+		Findings:    []moderation.Finding{{Checker: "keywords", Rule: moderation.ActionBlock, Action: moderation.ActionBlock, Start: start, End: start + len("secret")}},
+		Attribution: map[string]string{"key": "abc123", "model": "haiku"},
+	}
+}
+
+func TestLogBehavior(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.DLPConfig
+		check func(t *testing.T, e Entry)
+	}{
+		{
+			name: "hashes content without snippets by default",
+			cfg:  config.DLPConfig{Enabled: true, MaxEntries: 10},
+			check: func(t *testing.T, e Entry) {
+				if !strings.HasPrefix(e.Hash, "sha256:") || e.Snippet != "" || e.Attribution["key"] != "abc123" {
+					t.Errorf("unexpected entry %+v", e)
+				}
+			},
+		},
+		{
+			name: "keyed hashes use HMAC",
+			cfg:  config.DLPConfig{Enabled: true, MaxEntries: 10, HashKey: "k"},
+			check: func(t *testing.T, e Entry) {
+				if !strings.HasPrefix(e.Hash, "hmac-sha256:") {
+					t.Errorf("expected an HMAC, got %q", e.Hash)
+				}
+			},
+		},
+		{
+			name: "snippets mask findings",
+			cfg:  config.DLPConfig{Enabled: true, MaxEntries: 10, Snippets: true},
+			check: func(t *testing.T, e Entry) {
+				if e.Snippet != "the [redacted] plan" {
+					t.Errorf("expected a masked snippet, got %q", e.Snippet)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, err := Open(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			log.Record(event("the secret plan"))
+			entries := log.Entries(time.Time{})
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 entry, got %d", len(entries))
+			}
+			tt.check(t, entries[0])
+		})
+	}
+
+	t.Run("disabled log is nil and records nothing", func(t *testing.T) {
+		log, err := Open(config.DLPConfig{})
+		if log != nil || err != nil {
+			t.Fatalf("expected nil log, got %v, %v", log, err)
+		}
+		log.Record(event("a secret"))
+	})
+
+	t.Run("keeps the newest entries in memory and all of them on disk", func(t *testing.T) {
+		cfg := config.DLPConfig{Enabled: true, MaxEntries: 2, File: filepath.Join(t.TempDir(), "dlp.jsonl")}
+		log, err := Open(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, text := range []string{"one secret", "two secret", "three secret"} {
+			log.Record(event(text))
+		}
+		if n := len(log.Entries(time.Time{})); n != 2 {
+			t.Errorf("expected 2 entries in memory, got %d", n)
+		}
+		log.Close()
+
+		cfg.MaxEntries = 10
+		reopened, err := Open(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		if n := len(reopened.Entries(time.Time{})); n != 3 {
+			t.Errorf("expected 3 entries reloaded, got %d", n)
+		}
+		if n := len(reopened.Entries(time.Now().Add(time.Hour))); n != 0 {
+			t.Errorf("expected no entries in the future, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+// Package dnscache resolves hostnames for the upstream HTTP transport,
+// caching answers and serving the last good one when the resolver fails, and
+// pinning hosts to fixed addresses, for deployments with slow or unreliable
+// DNS. Lookups are counted by outcome in manto_upstream_dns_lookups_total,
+// with the time spent in manto_upstream_dns_lookup_seconds_total.
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+type entry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// Resolver looks up hosts through its pins, then its cache, then DNS. A nil
+// *Resolver leaves dialing to the transport.
+type Resolver struct {
+	ttl    time.Duration
+	pins   map[string][]string
+	lookup func(ctx context.Context, host string) ([]string, error)
+	now    func() time.Time
+
+	lookups *metrics.CounterVec
+	seconds *metrics.CounterVec
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// New returns the resolver cfg describes, or nil when it neither caches nor
+// pins anything.
+func New(cfg config.DNSConfig, registry *metrics.Registry) *Resolver {
+	pins, _ := config.ParseDNSPins(cfg.Pins)
+	if cfg.CacheTTL.Duration <= 0 && len(pins) == 0 {
+		return nil
+	}
+	return &Resolver{
+		ttl:     cfg.CacheTTL.Duration,
+		pins:    pins,
+		lookup:  net.DefaultResolver.LookupHost,
+		now:     time.Now,
+		lookups: registry.Counter("manto_upstream_dns_lookups_total", "Upstream hostname lookups by result (pinned, cached, resolved, stale or failed).", "result"),
+		seconds: registry.Counter("manto_upstream_dns_lookup_seconds_total", "Time spent resolving upstream hostnames with DNS."),
+		entries: make(map[string]entry),
+	}
+}
+
+// LookupHost returns host's addresses: its pins, a cached answer younger
+// than the TTL, or a fresh DNS answer. When DNS fails, the last answer is
+// served however old it is.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.pins[strings.ToLower(host)]; ok {
+		r.lookups.Inc("pinned")
+		return addrs, nil
+	}
+
+	r.mu.Lock()
+	cached, ok := r.entries[host]
+	r.mu.Unlock()
+	if ok && r.now().Before(cached.expires) {
+		r.lookups.Inc("cached")
+		return cached.addrs, nil
+	}
+
+	start := time.Now()
+	addrs, err := r.lookup(ctx, host)
+	r.seconds.Add(time.Since(start).Seconds())
+	if err != nil || len(addrs) == 0 {
+		if ok {
+			r.lookups.Inc("stale")
+			return cached.addrs, nil
+		}
+		r.lookups.Inc("failed")
+		if err == nil {
+			err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		return nil, err
+	}
+	r.lookups.Inc("resolved")
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.entries[host] = entry{addrs: addrs, expires: r.now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return addrs, nil
+}
+
+// DialContext wraps dialer so hostnames are resolved through r, trying each
+// address in turn until one connects. Addresses given as IPs are dialed
+// directly. TLS still verifies the certificate against the hostname.
+func (r *Resolver) DialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, address)
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		for _, addr := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
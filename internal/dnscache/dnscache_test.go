@@ -0,0 +1,79 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+func TestResolverBehavior(t *testing.T) {
+	registry := metrics.NewRegistry()
+	r := New(config.DNSConfig{CacheTTL: config.Duration{Duration: time.Minute}, Pins: []string{"Pinned.Example=192.0.2.10|192.0.2.11"}}, registry)
+	now := time.Unix(1000, 0)
+	r.now = func() time.Time { return now }
+	calls := 0
+	var failure error
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		calls++
+		if failure != nil {
+			return nil, failure
+		}
+		return []string{"198.51.100.1"}, nil
+	}
+
+	if addrs, _ := r.LookupHost(context.Background(), "pinned.example"); len(addrs) != 2 || calls != 0 {
+		t.Errorf("expected pinned addresses without a lookup, got %v after %d lookups", addrs, calls)
+	}
+	r.LookupHost(context.Background(), "api.example")
+	r.LookupHost(context.Background(), "api.example")
+	if calls != 1 {
+		t.Errorf("expected the second lookup to be cached, got %d lookups", calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	failure = errors.New("server misbehaving")
+	if addrs, err := r.LookupHost(context.Background(), "api.example"); err != nil || len(addrs) != 1 {
+		t.Errorf("expected the expired answer when DNS fails, got %v %v", addrs, err)
+	}
+	if _, err := r.LookupHost(context.Background(), "other.example"); err == nil {
+		t.Error("expected a failed lookup with nothing cached to fail")
+	}
+
+	for result, expected := range map[string]float64{"pinned": 1, "resolved": 1, "cached": 1, "stale": 1, "failed": 1} {
+		if got := r.lookups.Value(result); got != expected {
+			t.Errorf("expected %g %s lookups, got %g", expected, result, got)
+		}
+	}
+
+	if New(config.DNSConfig{}, registry) != nil {
+		t.Error("expected no resolver without a cache or pins")
+	}
+}
+
+func TestDialContextBehavior(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	r := New(config.DNSConfig{Pins: []string{"upstream.invalid=192.0.2.1|127.0.0.1"}}, metrics.NewRegistry())
+	client := &http.Client{Transport: &http.Transport{DialContext: r.DialContext(&net.Dialer{Timeout: time.Second})}}
+	resp, err := client.Get("http://upstream.invalid:" + port + "/")
+	if err != nil {
+		t.Fatalf("expected the pinned address that answers to be used, got %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "upstream.invalid:"+port {
+		t.Errorf("expected the original Host header, got %q", body)
+	}
+}
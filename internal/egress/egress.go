@@ -0,0 +1,109 @@
+// Package egress keeps the upstream HTTP transport to the origins in
+// Security.AllowedAPIEndpoints, refusing to dial anything else, so a
+// mistyped base URL or a request steered at an internal address can't reach
+// the network behind Manto. Proxies named in HTTPS_PROXY and HTTP_PROXY are
+// allowed too, since with one set every connection is dialed to the proxy.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+// ErrDenied is returned when dialing an address outside the allow-list.
+var ErrDenied = errors.New("egress denied")
+
+// DialFunc dials an address, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Guard is the set of host:port pairs the transport may dial. A nil *Guard
+// allows everything.
+type Guard struct {
+	allowed map[string]bool
+}
+
+// New returns the guard for cfg's allowed endpoints and providers, or nil
+// when EGRESS_ENFORCE is off.
+func New(cfg *config.Config) *Guard {
+	if !cfg.Security.EnforceEgress {
+		return nil
+	}
+	g := &Guard{allowed: make(map[string]bool)}
+	for _, origin := range cfg.APIEndpoints() {
+		g.add(origin)
+	}
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+		if proxy := os.Getenv(name); proxy != "" {
+			if !strings.Contains(proxy, "://") {
+				proxy = "http://" + proxy
+			}
+			g.add(proxy)
+		}
+	}
+	return g
+}
+
+func (g *Guard) add(raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	g.allowed[net.JoinHostPort(strings.ToLower(u.Hostname()), port)] = true
+}
+
+// Allows reports whether address, a host:port, may be dialed.
+func (g *Guard) Allows(address string) bool {
+	if g == nil {
+		return true
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	return g.allowed[net.JoinHostPort(strings.ToLower(host), port)]
+}
+
+// DialContext wraps next so addresses outside the allow-list are refused
+// before anything is resolved or dialed.
+func (g *Guard) DialContext(next DialFunc) DialFunc {
+	if g == nil {
+		return next
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if !g.Allows(address) {
+			return nil, fmt.Errorf("%w: %s is not covered by ALLOWED_API_ENDPOINTS", ErrDenied, address)
+		}
+		return next(ctx, network, address)
+	}
+}
+
+// Transport returns a copy of the default transport dialing through g, or
+// the default transport itself for a nil guard.
+func (g *Guard) Transport() http.RoundTripper {
+	if g == nil {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = g.DialContext((&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext)
+	return transport
+}
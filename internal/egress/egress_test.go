@@ -0,0 +1,78 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func TestGuardBehavior(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "proxy.internal:3128")
+	cfg := &config.Config{}
+	cfg.Anthropic.BaseURL = "https://API.anthropic.com"
+	cfg.Security.AllowedAPIEndpoints = []string{"https://gateway.example:8443", "http://127.0.0.1"}
+	cfg.Security.EnforceEgress = true
+	g := New(cfg)
+
+	for address, expected := range map[string]bool{
+		"api.anthropic.com:443": true,
+		"gateway.example:8443":  true,
+		"gateway.example:443":   false,
+		"127.0.0.1:80":          true,
+		"169.254.169.254:80":    false,
+		"proxy.internal:3128":   true,
+		"metadata.internal:443": false,
+		"not an address":        false,
+	} {
+		if got := g.Allows(address); got != expected {
+			t.Errorf("expected Allows(%q) to be %v", address, expected)
+		}
+	}
+
+	dialed := false
+	dial := g.DialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = true
+		return nil, errors.New("unreachable")
+	})
+	if _, err := dial(context.Background(), "tcp", "10.0.0.1:443"); !errors.Is(err, ErrDenied) || dialed {
+		t.Errorf("expected a denied address to be refused without dialing, got %v", err)
+	}
+
+	cfg.Security.EnforceEgress = false
+	if New(cfg) != nil {
+		t.Error("expected no guard with EGRESS_ENFORCE off")
+	}
+	if !(*Guard)(nil).Allows("10.0.0.1:443") {
+		t.Error("expected a nil guard to allow everything")
+	}
+}
+
+func TestTransportBehavior(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("HTTP_PROXY", "")
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer allowed.Close()
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the internal server not to be reached")
+	}))
+	defer internal.Close()
+
+	cfg := &config.Config{}
+	cfg.Anthropic.BaseURL = allowed.URL
+	cfg.Security.EnforceEgress = true
+	client := &http.Client{Transport: New(cfg).Transport()}
+
+	resp, err := client.Get(allowed.URL)
+	if err != nil {
+		t.Fatalf("expected the provider to be reachable, got %v", err)
+	}
+	resp.Body.Close()
+	if _, err := client.Get(internal.URL); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected an address outside the allow-list to be denied, got %v", err)
+	}
+}
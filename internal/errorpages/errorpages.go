@@ -0,0 +1,154 @@
+package errorpages
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+const defaultTemplate = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+    <title>{{.StatusCode}} {{.StatusText}} - {{.Brand.Name}}</title>
+    <link rel="icon" type="image/svg+xml" href="/logo.svg" />
+    <style>
+      body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center;
+        background: #0f0f10; color: #ffffff; text-align: center;
+        font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif; }
+      h1 { font-size: 3rem; margin: 0 0 0.5rem; color: {{.Brand.ThemeColor}}; }
+      p { color: #b4b4b4; margin: 0 0 1.5rem; }
+      a { color: #ffffff; background: {{.Brand.ThemeColor}}; padding: 0.6rem 1.2rem; border-radius: 6px; text-decoration: none; }
+    </style>
+  </head>
+  <body>
+    <main>
+      <h1>{{.StatusCode}}</h1>
+      <p>{{.Message}}</p>
+      <a href="/">Back to {{.Brand.Name}}</a>
+    </main>
+  </body>
+</html>
+`
+
+// PageData is passed to error page templates.
+type PageData struct {
+	StatusCode int
+	StatusText string
+	Message    string
+	Path       string
+	Brand      config.BrandingConfig
+}
+
+type Renderer struct {
+	brand       config.BrandingConfig
+	notFound    *template.Template
+	serverError *template.Template
+}
+
+// NewRenderer parses the configured templates, falling back to the built-in
+// page for any that are not set.
+func NewRenderer(cfg *config.Config) (*Renderer, error) {
+	notFound, err := loadTemplate("not-found", cfg.ErrorPages.NotFoundTemplate)
+	if err != nil {
+		return nil, err
+	}
+	serverError, err := loadTemplate("server-error", cfg.ErrorPages.ServerErrorTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Renderer{
+		brand:       cfg.Branding,
+		notFound:    notFound,
+		serverError: serverError,
+	}, nil
+}
+
+func loadTemplate(name, path string) (*template.Template, error) {
+	if path == "" {
+		return template.New(name).Parse(defaultTemplate)
+	}
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse error page template %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// Render writes the error page for statusCode.
+func (rn *Renderer) Render(w http.ResponseWriter, r *http.Request, statusCode int) {
+	tmpl := rn.serverError
+	message := "Something went wrong on our side. Please try again shortly."
+	if statusCode == http.StatusNotFound {
+		tmpl = rn.notFound
+		message = "The page you were looking for doesn't exist."
+	}
+
+	data := PageData{
+		StatusCode: statusCode,
+		StatusText: http.StatusText(statusCode),
+		Message:    message,
+		Path:       r.URL.Path,
+		Brand:      rn.brand,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("Failed to render error page: %v", err)
+		http.Error(w, http.StatusText(statusCode), statusCode)
+		return
+	}
+
+	w.Header().Del("Content-Encoding")
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	w.Write(buf.Bytes())
+}
+
+// Middleware replaces 404 and 5xx responses from static handlers with the
+// rendered error page. It must only wrap non-API routes.
+func (rn *Renderer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iw := &interceptWriter{ResponseWriter: w}
+		next.ServeHTTP(iw, r)
+		if iw.intercepted != 0 {
+			rn.Render(w, r, iw.intercepted)
+		}
+	})
+}
+
+type interceptWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	intercepted int
+}
+
+func (iw *interceptWriter) WriteHeader(statusCode int) {
+	if iw.wroteHeader {
+		return
+	}
+	iw.wroteHeader = true
+	if statusCode == http.StatusNotFound || statusCode >= http.StatusInternalServerError {
+		iw.intercepted = statusCode
+		return
+	}
+	iw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (iw *interceptWriter) Write(p []byte) (int, error) {
+	if !iw.wroteHeader {
+		iw.WriteHeader(http.StatusOK)
+	}
+	if iw.intercepted != 0 {
+		return len(p), nil
+	}
+	return iw.ResponseWriter.Write(p)
+}
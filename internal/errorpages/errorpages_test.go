@@ -0,0 +1,96 @@
+package errorpages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func createTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Branding.Name = "Acme Chat"
+	cfg.Branding.ThemeColor = "#112233"
+	return cfg
+}
+
+func TestMiddlewareBehavior(t *testing.T) {
+	renderer, err := NewRenderer(createTestConfig())
+	if err != nil {
+		t.Fatalf("failed to create renderer: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		status         int
+		expectedStatus int
+		expectTemplate bool
+	}{
+		{name: "renders branded 404", status: http.StatusNotFound, expectedStatus: http.StatusNotFound, expectTemplate: true},
+		{name: "renders branded 500", status: http.StatusInternalServerError, expectedStatus: http.StatusInternalServerError, expectTemplate: true},
+		{name: "passes through success", status: http.StatusOK, expectedStatus: http.StatusOK},
+		{name: "passes through other client errors", status: http.StatusForbidden, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := renderer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(tt.status)
+				w.Write([]byte("plain body"))
+			}))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			body := w.Body.String()
+			if tt.expectTemplate {
+				if !strings.Contains(body, "Acme Chat") || !strings.Contains(body, "#112233") {
+					t.Errorf("expected branded error page, got %q", body)
+				}
+				if strings.Contains(body, "plain body") {
+					t.Error("original plain-text body should be discarded")
+				}
+				if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+					t.Errorf("expected HTML content type, got %s", ct)
+				}
+			} else if body != "plain body" {
+				t.Errorf("expected original body, got %q", body)
+			}
+		})
+	}
+}
+
+func TestCustomTemplateBehavior(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "404.html")
+	if err := os.WriteFile(path, []byte("custom {{.StatusCode}} for {{.Path}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := createTestConfig()
+	cfg.ErrorPages.NotFoundTemplate = path
+	renderer, err := NewRenderer(cfg)
+	if err != nil {
+		t.Fatalf("failed to create renderer: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	renderer.Render(w, httptest.NewRequest("GET", "/nope", nil), http.StatusNotFound)
+
+	if w.Body.String() != "custom 404 for /nope" {
+		t.Errorf("expected custom template output, got %q", w.Body.String())
+	}
+
+	cfg.ErrorPages.ServerErrorTemplate = filepath.Join(t.TempDir(), "missing.html")
+	if _, err := NewRenderer(cfg); err == nil {
+		t.Error("expected error for missing template file")
+	}
+}
@@ -0,0 +1,294 @@
+// Package evals runs suites of prompts against one or more models and scores
+// each answer against an expectation, so prompt or model changes can be
+// regression-tested. Answers are scored by exact match, substring, regular
+// expression, or by asking a grader model whether they meet a criterion.
+package evals
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ScoreExact    = "exact"
+	ScoreContains = "contains"
+	ScoreRegex    = "regex"
+	ScoreLLM      = "llm"
+
+	StatusRunning     = "running"
+	StatusCompleted   = "completed"
+	StatusInterrupted = "interrupted"
+)
+
+var ErrNotFound = errors.New("eval run not found")
+
+// Case is one prompt and what a good answer looks like. For the llm scorer,
+// Expected is the criterion the grader model checks the answer against.
+type Case struct {
+	Name     string `json:"name,omitempty"`
+	Prompt   string `json:"prompt"`
+	System   string `json:"system,omitempty"`
+	Expected string `json:"expected"`
+	Scorer   string `json:"scorer"`
+}
+
+// Suite is a set of cases run against every model in Models. LLM-graded cases
+// use GraderModel, or the first model when it is empty.
+type Suite struct {
+	Name        string   `json:"name"`
+	Models      []string `json:"models"`
+	GraderModel string   `json:"graderModel,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Cases       []Case   `json:"cases"`
+}
+
+// Validate checks the suite is runnable and has at most maxCases cases.
+func (s *Suite) Validate(maxCases int) error {
+	if len(s.Models) == 0 {
+		return errors.New("at least one model is required")
+	}
+	if len(s.Cases) == 0 {
+		return errors.New("at least one case is required")
+	}
+	if len(s.Cases) > maxCases {
+		return fmt.Errorf("at most %d cases are allowed", maxCases)
+	}
+	for i, c := range s.Cases {
+		if c.Prompt == "" {
+			return fmt.Errorf("case %d has no prompt", i+1)
+		}
+		switch c.Scorer {
+		case ScoreExact, ScoreContains, ScoreLLM:
+		case ScoreRegex:
+			if _, err := regexp.Compile(c.Expected); err != nil {
+				return fmt.Errorf("case %d has an invalid pattern: %v", i+1, err)
+			}
+		default:
+			return fmt.Errorf("case %d has unknown scorer %q (must be exact, contains, regex or llm)", i+1, c.Scorer)
+		}
+	}
+	return nil
+}
+
+func (s *Suite) graderModel() string {
+	if s.GraderModel != "" {
+		return s.GraderModel
+	}
+	return s.Models[0]
+}
+
+// CaseResult is the outcome of one case against one model.
+type CaseResult struct {
+	Case       int    `json:"case"`
+	Model      string `json:"model"`
+	Output     string `json:"output"`
+	Passed     bool   `json:"passed"`
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// ModelSummary totals a model's results.
+type ModelSummary struct {
+	Model    string  `json:"model"`
+	Total    int     `json:"total"`
+	Passed   int     `json:"passed"`
+	Failed   int     `json:"failed"`
+	Errors   int     `json:"errors"`
+	PassRate float64 `json:"passRate"`
+}
+
+// Run is one execution of a suite and its results so far.
+type Run struct {
+	ID         string         `json:"id"`
+	Owner      string         `json:"-"`
+	Suite      Suite          `json:"suite"`
+	Status     string         `json:"status"`
+	Results    []CaseResult   `json:"results"`
+	Summary    []ModelSummary `json:"summary"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+}
+
+// Clone returns a deep copy of the run.
+func (r *Run) Clone() *Run {
+	c := *r
+	c.Suite.Models = append([]string{}, r.Suite.Models...)
+	c.Suite.Cases = append([]Case{}, r.Suite.Cases...)
+	c.Results = append([]CaseResult{}, r.Results...)
+	c.Summary = append([]ModelSummary{}, r.Summary...)
+	if r.FinishedAt != nil {
+		finished := *r.FinishedAt
+		c.FinishedAt = &finished
+	}
+	return &c
+}
+
+// summarize recomputes the per-model totals from the results.
+func (r *Run) summarize() {
+	summary := make([]ModelSummary, len(r.Suite.Models))
+	index := make(map[string]int, len(r.Suite.Models))
+	for i, model := range r.Suite.Models {
+		summary[i] = ModelSummary{Model: model}
+		index[model] = i
+	}
+	for _, result := range r.Results {
+		s := &summary[index[result.Model]]
+		s.Total++
+		switch {
+		case result.Error != "":
+			s.Errors++
+		case result.Passed:
+			s.Passed++
+		default:
+			s.Failed++
+		}
+	}
+	for i := range summary {
+		if summary[i].Total > 0 {
+			summary[i].PassRate = float64(summary[i].Passed) / float64(summary[i].Total)
+		}
+	}
+	r.Summary = summary
+}
+
+// CompleteFunc asks model to answer prompt.
+type CompleteFunc func(ctx context.Context, model, system, prompt string) (string, error)
+
+// Score checks output against c. The llm scorer calls complete with the
+// grader model.
+func Score(ctx context.Context, c Case, output, graderModel string, complete CompleteFunc) (bool, string, error) {
+	switch c.Scorer {
+	case ScoreExact:
+		return strings.TrimSpace(output) == strings.TrimSpace(c.Expected), "", nil
+	case ScoreContains:
+		return strings.Contains(strings.ToLower(output), strings.ToLower(c.Expected)), "", nil
+	case ScoreRegex:
+		re, err := regexp.Compile(c.Expected)
+		if err != nil {
+			return false, "", err
+		}
+		return re.MatchString(output), "", nil
+	case ScoreLLM:
+		verdict, err := complete(ctx, graderModel, graderSystem, gradePrompt(c, output))
+		if err != nil {
+			return false, "", fmt.Errorf("grading failed: %w", err)
+		}
+		return parseVerdict(verdict)
+	}
+	return false, "", fmt.Errorf("unknown scorer %q", c.Scorer)
+}
+
+const graderSystem = "You grade answers strictly against a criterion. Reply with PASS or FAIL on the first line, then one sentence explaining why."
+
+func gradePrompt(c Case, output string) string {
+	return fmt.Sprintf("Criterion:\n%s\n\nQuestion:\n%s\n\nAnswer:\n%s", c.Expected, c.Prompt, output)
+}
+
+func parseVerdict(verdict string) (bool, string, error) {
+	first, rest, _ := strings.Cut(strings.TrimSpace(verdict), "\n")
+	first = strings.ToUpper(strings.TrimSpace(first))
+	reason := strings.TrimSpace(rest)
+	switch {
+	case strings.HasPrefix(first, "PASS"):
+		return true, reason, nil
+	case strings.HasPrefix(first, "FAIL"):
+		return false, reason, nil
+	}
+	return false, "", fmt.Errorf("grader gave no verdict: %q", first)
+}
+
+// Runner executes suites in the background, recording results in a Store.
+type Runner struct {
+	store       *Store
+	concurrency int
+}
+
+func NewRunner(store *Store, concurrency int) *Runner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Runner{store: store, concurrency: concurrency}
+}
+
+// Start records a new run for owner and executes every case against every
+// model, at most the runner's concurrency at a time.
+func (rn *Runner) Start(owner string, suite Suite, complete CompleteFunc) (*Run, error) {
+	run := &Run{
+		ID:        newRunID(),
+		Owner:     owner,
+		Suite:     suite,
+		Status:    StatusRunning,
+		Results:   []CaseResult{},
+		CreatedAt: time.Now().UTC(),
+	}
+	run.summarize()
+	if err := rn.store.Create(run); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		ctx := context.Background()
+		sem := make(chan struct{}, rn.concurrency)
+		var wg sync.WaitGroup
+		for i, c := range suite.Cases {
+			for _, model := range suite.Models {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(i int, c Case, model string) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					result := execute(ctx, i, c, model, suite.graderModel(), complete)
+					rn.store.update(run.ID, func(r *Run) {
+						r.Results = append(r.Results, result)
+						r.summarize()
+					})
+				}(i, c, model)
+			}
+		}
+		wg.Wait()
+
+		rn.store.update(run.ID, func(r *Run) {
+			finished := time.Now().UTC()
+			r.Status = StatusCompleted
+			r.FinishedAt = &finished
+		})
+	}()
+
+	return run.Clone(), nil
+}
+
+func execute(ctx context.Context, index int, c Case, model, graderModel string, complete CompleteFunc) CaseResult {
+	start := time.Now()
+	result := CaseResult{Case: index, Model: model}
+
+	output, err := complete(ctx, model, c.System, c.Prompt)
+	result.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Output = output
+
+	passed, reason, err := Score(ctx, c, output, graderModel, complete)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Passed = passed
+	result.Reason = reason
+	return result
+}
+
+func newRunID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "eval_" + hex.EncodeToString(b)
+}
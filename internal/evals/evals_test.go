@@ -0,0 +1,140 @@
+package evals
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScoreBehavior(t *testing.T) {
+	grader := func(verdict string) CompleteFunc {
+		return func(ctx context.Context, model, system, prompt string) (string, error) {
+			if model != "grader" || !strings.Contains(prompt, "Criterion:\nmentions Paris") {
+				t.Errorf("unexpected grading call %q %q", model, prompt)
+			}
+			return verdict, nil
+		}
+	}
+
+	tests := []struct {
+		name     string
+		c        Case
+		output   string
+		complete CompleteFunc
+		passed   bool
+		reason   string
+		wantErr  bool
+	}{
+		{name: "exact ignores surrounding space", c: Case{Scorer: ScoreExact, Expected: "4"}, output: " 4\n", passed: true},
+		{name: "exact mismatch", c: Case{Scorer: ScoreExact, Expected: "4"}, output: "four"},
+		{name: "contains ignores case", c: Case{Scorer: ScoreContains, Expected: "paris"}, output: "It is Paris.", passed: true},
+		{name: "regex", c: Case{Scorer: ScoreRegex, Expected: `^\d+$`}, output: "42", passed: true},
+		{name: "llm pass", c: Case{Scorer: ScoreLLM, Expected: "mentions Paris"}, complete: grader("PASS\nIt names Paris."), passed: true, reason: "It names Paris."},
+		{name: "llm fail", c: Case{Scorer: ScoreLLM, Expected: "mentions Paris"}, complete: grader("fail: wrong city")},
+		{name: "llm without verdict", c: Case{Scorer: ScoreLLM, Expected: "mentions Paris"}, complete: grader("Maybe"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passed, reason, err := Score(context.Background(), tt.c, tt.output, "grader", tt.complete)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if passed != tt.passed || reason != tt.reason {
+				t.Errorf("expected (%v, %q), got (%v, %q)", tt.passed, tt.reason, passed, reason)
+			}
+		})
+	}
+}
+
+func TestSuiteValidateBehavior(t *testing.T) {
+	valid := Case{Prompt: "2+2?", Expected: "4", Scorer: ScoreExact}
+	tests := []struct {
+		name  string
+		suite Suite
+		err   string
+	}{
+		{name: "valid", suite: Suite{Models: []string{"m"}, Cases: []Case{valid}}},
+		{name: "no models", suite: Suite{Cases: []Case{valid}}, err: "model"},
+		{name: "no cases", suite: Suite{Models: []string{"m"}}, err: "case"},
+		{name: "too many cases", suite: Suite{Models: []string{"m"}, Cases: []Case{valid, valid, valid}}, err: "at most 2"},
+		{name: "unknown scorer", suite: Suite{Models: []string{"m"}, Cases: []Case{{Prompt: "x", Scorer: "fuzzy"}}}, err: "fuzzy"},
+		{name: "bad pattern", suite: Suite{Models: []string{"m"}, Cases: []Case{{Prompt: "x", Scorer: ScoreRegex, Expected: "("}}}, err: "pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.suite.Validate(2)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error mentioning %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRunnerBehavior(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "evals.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	complete := func(ctx context.Context, model, system, prompt string) (string, error) {
+		if model == "broken" {
+			return "", errors.New("upstream down")
+		}
+		return "4", nil
+	}
+	suite := Suite{
+		Name:   "arithmetic",
+		Models: []string{"good", "broken"},
+		Cases: []Case{
+			{Prompt: "2+2?", Expected: "4", Scorer: ScoreExact},
+			{Prompt: "3+3?", Expected: "6", Scorer: ScoreExact},
+		},
+	}
+	run, err := NewRunner(store, 2).Start("alice", suite, complete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for run.Status != StatusCompleted {
+		if time.Now().After(deadline) {
+			t.Fatal("run did not finish")
+		}
+		time.Sleep(time.Millisecond)
+		run, _ = store.Get("alice", run.ID)
+	}
+
+	want := []ModelSummary{
+		{Model: "good", Total: 2, Passed: 1, Failed: 1, PassRate: 0.5},
+		{Model: "broken", Total: 2, Errors: 2},
+	}
+	for i, s := range want {
+		if run.Summary[i] != s {
+			t.Errorf("expected summary %+v, got %+v", s, run.Summary[i])
+		}
+	}
+
+	if _, err := store.Get("bob", run.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("other owners should not see the run, got %v", err)
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := reopened.List("alice"); len(runs) != 1 || len(runs[0].Results) != 4 {
+		t.Errorf("expected the run to survive a restart, got %+v", runs)
+	}
+}
@@ -0,0 +1,175 @@
+package evals
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/manto/manto-web/internal/atomicfile"
+)
+
+// Store keeps eval runs in memory, optionally snapshotting them to a JSON
+// file after every change so reports survive restarts.
+type Store struct {
+	path string
+
+	mu   sync.RWMutex
+	runs map[string]*Run
+}
+
+type snapshot struct {
+	Runs []*storedRun `json:"runs"`
+}
+
+// storedRun keeps the owner, which is hidden from API responses.
+type storedRun struct {
+	Owner string `json:"owner"`
+	*Run
+}
+
+// NewStore opens a store backed by path. An empty path keeps everything in
+// memory only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, runs: make(map[string]*Run)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read eval store: %w", err)
+	}
+
+	if s.runs, err = parseSnapshot(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Replace swaps the store's runs for the snapshot in data, as written to the
+// store's file, and persists it. It is how a backup is restored into a
+// running server.
+func (s *Store) Replace(data []byte) error {
+	runs, err := parseSnapshot(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs = runs
+	return s.persist()
+}
+
+// parseSnapshot reads the runs in data. Runs that were still going when it
+// was written are marked interrupted, since nothing will finish them.
+func parseSnapshot(data []byte) (map[string]*Run, error) {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse eval store: %w", err)
+	}
+	runs := make(map[string]*Run, len(snap.Runs))
+	for _, stored := range snap.Runs {
+		stored.Run.Owner = stored.Owner
+		if stored.Status == StatusRunning {
+			stored.Status = StatusInterrupted
+		}
+		runs[stored.ID] = stored.Run
+	}
+	return runs, nil
+}
+
+func (s *Store) Create(r *Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runs[r.ID] = r.Clone()
+	return s.persist()
+}
+
+// Get returns a copy of the run if it exists and belongs to owner.
+func (s *Store) Get(owner, id string) (*Run, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.runs[id]
+	if !ok || r.Owner != owner {
+		return nil, ErrNotFound
+	}
+	return r.Clone(), nil
+}
+
+// List returns copies of owner's runs, newest first.
+func (s *Store) List(owner string) []*Run {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []*Run
+	for _, r := range s.runs {
+		if r.Owner == owner {
+			list = append(list, r.Clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+func (s *Store) Delete(owner, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	if !ok || r.Owner != owner {
+		return ErrNotFound
+	}
+	delete(s.runs, id)
+	return s.persist()
+}
+
+// update applies fn to a run the runner is executing. A run deleted while in
+// progress is left deleted. Persistence errors are ignored here: the run is
+// still served from memory and the next change retries the write.
+func (s *Store) update(id string, fn func(*Run)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	if !ok {
+		return
+	}
+	fn(r)
+	s.persist()
+}
+
+// persist writes the snapshot atomically. Callers must hold s.mu.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	snap := snapshot{Runs: make([]*storedRun, 0, len(s.runs))}
+	for _, r := range s.runs {
+		snap.Runs = append(snap.Runs, &storedRun{Owner: r.Owner, Run: r})
+	}
+	sort.Slice(snap.Runs, func(i, j int) bool {
+		return snap.Runs[i].ID < snap.Runs[j].ID
+	})
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode eval store: %w", err)
+	}
+
+	if err := atomicfile.Write(s.path, data); err != nil {
+		return fmt.Errorf("failed to write eval store: %w", err)
+	}
+	return nil
+}
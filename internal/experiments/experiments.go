@@ -0,0 +1,214 @@
+// Package experiments runs A/B tests of system prompts and models. Each
+// session is assigned a variant by hashing its ID, so assignment is random
+// across sessions but sticky within one, without storing anything. Responses
+// served under a variant are counted, and thumbs-up/down feedback is
+// aggregated per variant.
+package experiments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/session"
+)
+
+// Header tags responses with the experiment and variant that produced them.
+const Header = "X-Manto-Variant"
+
+// Variant is one arm of an experiment. An empty System or Model leaves the
+// deployment's default, or the client's choice, in place.
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	System string `json:"system,omitempty"`
+	Model  string `json:"model,omitempty"`
+
+	responses atomic.Int64
+	up        atomic.Int64
+	down      atomic.Int64
+}
+
+// Experiment is the running experiment, as read from EXPERIMENTS_FILE.
+type Experiment struct {
+	Name     string     `json:"name"`
+	Variants []*Variant `json:"variants"`
+
+	totalWeight int
+}
+
+// Load reads an experiment definition. An empty path means no experiment and
+// returns nil, which is safe to use.
+func Load(path string) (*Experiment, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read experiment: %w", err)
+	}
+	var e Experiment
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("failed to parse experiment: %w", err)
+	}
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (e *Experiment) validate() error {
+	if e.Name == "" {
+		return errors.New("invalid experiment: a name is required")
+	}
+	if len(e.Variants) < 2 {
+		return errors.New("invalid experiment: at least two variants are required")
+	}
+	seen := make(map[string]bool, len(e.Variants))
+	for _, v := range e.Variants {
+		if v.Name == "" || seen[v.Name] {
+			return fmt.Errorf("invalid experiment: variant names must be unique and non-empty (got %q)", v.Name)
+		}
+		if v.Weight < 1 {
+			return fmt.Errorf("invalid experiment: variant %s has weight %d (must be at least 1)", v.Name, v.Weight)
+		}
+		seen[v.Name] = true
+		e.totalWeight += v.Weight
+	}
+	return nil
+}
+
+// Assign picks sessionID's variant in proportion to the weights.
+func (e *Experiment) Assign(sessionID string) *Assignment {
+	sum := sha256.Sum256([]byte(e.Name + ":" + sessionID))
+	bucket := int(binary.BigEndian.Uint64(sum[:8]) % uint64(e.totalWeight))
+	for _, v := range e.Variants {
+		if bucket < v.Weight {
+			return &Assignment{experiment: e, variant: v}
+		}
+		bucket -= v.Weight
+	}
+	return nil
+}
+
+// Middleware attaches the session's assignment to the request context.
+// Requests without a session take no part in the experiment.
+func (e *Experiment) Middleware(next http.Handler) http.Handler {
+	if e == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := session.FromContext(r.Context())
+		if s == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), e.Assign(s.ID))))
+	})
+}
+
+// VariantReport is a variant's totals so far.
+type VariantReport struct {
+	Name         string  `json:"name"`
+	Weight       int     `json:"weight"`
+	Model        string  `json:"model,omitempty"`
+	Responses    int64   `json:"responses"`
+	ThumbsUp     int64   `json:"thumbsUp"`
+	ThumbsDown   int64   `json:"thumbsDown"`
+	ApprovalRate float64 `json:"approvalRate"`
+}
+
+// Report is the experiment's totals since the server started. System prompts
+// are left out; they stay in the experiment file.
+type Report struct {
+	Name     string          `json:"name"`
+	Variants []VariantReport `json:"variants"`
+}
+
+func (e *Experiment) Report() Report {
+	variants := make([]VariantReport, 0, len(e.Variants))
+	for _, v := range e.Variants {
+		report := VariantReport{
+			Name:       v.Name,
+			Weight:     v.Weight,
+			Model:      v.Model,
+			Responses:  v.responses.Load(),
+			ThumbsUp:   v.up.Load(),
+			ThumbsDown: v.down.Load(),
+		}
+		if rated := report.ThumbsUp + report.ThumbsDown; rated > 0 {
+			report.ApprovalRate = float64(report.ThumbsUp) / float64(rated)
+		}
+		variants = append(variants, report)
+	}
+	return Report{Name: e.Name, Variants: variants}
+}
+
+// Assignment is a session's variant. A nil *Assignment changes and records
+// nothing, so callers never need to check for it.
+type Assignment struct {
+	experiment *Experiment
+	variant    *Variant
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, a *Assignment) context.Context {
+	return context.WithValue(ctx, contextKey{}, a)
+}
+
+func FromContext(ctx context.Context) *Assignment {
+	a, _ := ctx.Value(contextKey{}).(*Assignment)
+	return a
+}
+
+// Apply overrides the request's model, and its system prompt unless the
+// client sent one, with the variant's. Call it before applying defaults.
+func (a *Assignment) Apply(request *services.MessageRequest) {
+	if a == nil {
+		return
+	}
+	if a.variant.Model != "" {
+		request.Model = a.variant.Model
+	}
+	if request.System == nil && a.variant.System != "" {
+		system := a.variant.System
+		request.System = &system
+	}
+}
+
+// Tag names the experiment and variant, e.g. "concise/b".
+func (a *Assignment) Tag() string {
+	if a == nil {
+		return ""
+	}
+	return a.experiment.Name + "/" + a.variant.Name
+}
+
+// RecordResponse counts a response served under the variant and tags header.
+func (a *Assignment) RecordResponse(header http.Header) {
+	if a == nil {
+		return
+	}
+	a.variant.responses.Add(1)
+	header.Set(Header, a.Tag())
+}
+
+// RecordFeedback counts a thumbs-up or thumbs-down for the variant.
+func (a *Assignment) RecordFeedback(up bool) {
+	if a == nil {
+		return
+	}
+	if up {
+		a.variant.up.Add(1)
+	} else {
+		a.variant.down.Add(1)
+	}
+}
@@ -0,0 +1,112 @@
+package experiments
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manto/manto-web/internal/services"
+)
+
+func writeExperiment(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "experiment.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadBehavior(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{name: "valid", data: `{"name":"tone","variants":[{"name":"a","weight":1},{"name":"b","weight":3,"system":"Be brief."}]}`},
+		{name: "one variant", data: `{"name":"tone","variants":[{"name":"a","weight":1}]}`, err: "two variants"},
+		{name: "duplicate names", data: `{"name":"tone","variants":[{"name":"a","weight":1},{"name":"a","weight":1}]}`, err: "unique"},
+		{name: "zero weight", data: `{"name":"tone","variants":[{"name":"a","weight":1},{"name":"b"}]}`, err: "weight"},
+		{name: "no name", data: `{"variants":[{"name":"a","weight":1},{"name":"b","weight":1}]}`, err: "name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeExperiment(t, tt.data))
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error mentioning %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if e, err := Load(""); e != nil || err != nil {
+		t.Errorf("expected no experiment without a file, got %v %v", e, err)
+	}
+}
+
+func TestAssignmentBehavior(t *testing.T) {
+	e, err := Load(writeExperiment(t, `{"name":"tone","variants":[{"name":"a","weight":1},{"name":"b","weight":3,"system":"Be brief.","model":"claude-haiku"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		id := fmt.Sprintf("session-%d", i)
+		tag := e.Assign(id).Tag()
+		if e.Assign(id).Tag() != tag {
+			t.Fatalf("assignment for %s is not sticky", id)
+		}
+		counts[tag]++
+	}
+	if counts["tone/a"] < 800 || counts["tone/a"] > 1200 {
+		t.Errorf("expected about a quarter of sessions in variant a, got %v", counts)
+	}
+
+	var b *Assignment
+	for i := 0; b == nil; i++ {
+		if a := e.Assign(fmt.Sprint(i)); a.Tag() == "tone/b" {
+			b = a
+		}
+	}
+
+	request := services.MessageRequest{Model: "claude-sonnet"}
+	b.Apply(&request)
+	if request.Model != "claude-haiku" || request.System == nil || *request.System != "Be brief." {
+		t.Errorf("expected variant model and system, got %+v", request)
+	}
+
+	own := "Answer in Spanish."
+	request = services.MessageRequest{Model: "claude-sonnet", System: &own}
+	b.Apply(&request)
+	if *request.System != own {
+		t.Errorf("expected the client's system prompt to be kept, got %q", *request.System)
+	}
+
+	header := http.Header{}
+	b.RecordResponse(header)
+	b.RecordFeedback(true)
+	b.RecordFeedback(true)
+	b.RecordFeedback(false)
+	if header.Get(Header) != "tone/b" {
+		t.Errorf("expected response to be tagged, got %v", header)
+	}
+	report := e.Report().Variants[1]
+	if report.Responses != 1 || report.ThumbsUp != 2 || report.ThumbsDown != 1 || report.ApprovalRate < 0.66 || report.ApprovalRate > 0.67 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	var none *Assignment
+	none.Apply(&request)
+	none.RecordResponse(header)
+	none.RecordFeedback(true)
+}
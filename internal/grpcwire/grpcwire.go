@@ -0,0 +1,263 @@
+// Package grpcwire implements the small part of gRPC and Protocol Buffers
+// Manto needs to serve its API over gRPC with the standard library alone:
+// length-prefixed message framing, status codes and trailers, and encoding
+// of the scalar, string and nested message fields used by
+// proto/manto/v1/manto.proto.
+package grpcwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code is a gRPC status code.
+type Code int
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	Unauthenticated    Code = 16
+)
+
+// CodeForHTTP maps an HTTP status to the closest gRPC code.
+func CodeForHTTP(status int) Code {
+	switch status {
+	case http.StatusOK:
+		return OK
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return InvalidArgument
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusUnprocessableEntity:
+		return FailedPrecondition
+	case http.StatusTooManyRequests:
+		return ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return Unavailable
+	case http.StatusInternalServerError:
+		return Internal
+	}
+	return Unknown
+}
+
+// Status is a call's outcome, sent in the grpc-status and grpc-message
+// trailers.
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", s.Code, s.Message)
+}
+
+// Errorf returns a status with a formatted message.
+func Errorf(code Code, format string, args ...any) *Status {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// WriteTrailers sets st as the response trailers. A nil st is OK.
+func WriteTrailers(w http.ResponseWriter, st *Status) {
+	if st == nil {
+		st = &Status{Code: OK}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(st.Code)))
+	if st.Message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeMessage(st.Message))
+	}
+}
+
+// encodeMessage percent-encodes a grpc-message value as the spec requires.
+func encodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// ParseTimeout parses a grpc-timeout header such as "10S" or "500m".
+func ParseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	unit := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}[value[len(value)-1]]
+	if unit == 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// ErrTooLarge is returned for messages over the read limit.
+var ErrTooLarge = errors.New("message too large")
+
+// ReadFrame reads one length-prefixed message of at most max bytes.
+// Compressed messages are not supported.
+func ReadFrame(r io.Reader, max int) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if int64(size) > int64(max) {
+		return nil, ErrTooLarge
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteFrame writes msg with its length prefix.
+func WriteFrame(w io.Writer, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// Wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// AppendString appends a string field, omitting it when empty as proto3
+// does.
+func AppendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return AppendBytes(b, field, []byte(s))
+}
+
+// AppendBytes appends a length-delimited field, even when empty; nested
+// messages use it so their presence is kept.
+func AppendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendInt appends an integer field, omitting zero.
+func AppendInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+// AppendDouble appends a double field.
+func AppendDouble(b []byte, field int, v float64) []byte {
+	b = appendTag(b, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// Field is one decoded field. Varint holds varint and fixed values; Bytes
+// holds length-delimited ones.
+type Field struct {
+	Number int
+	Type   int
+	Varint uint64
+	Bytes  []byte
+}
+
+func (f Field) String() string  { return string(f.Bytes) }
+func (f Field) Int() int64      { return int64(f.Varint) }
+func (f Field) Double() float64 { return math.Float64frombits(f.Varint) }
+func (f Field) IsBytes() bool   { return f.Type == wireBytes }
+func (f Field) IsVarint() bool  { return f.Type == wireVarint }
+func (f Field) IsFixed64() bool { return f.Type == wireFixed64 }
+
+var errMalformed = errors.New("malformed protobuf message")
+
+// Parse calls fn for each field of msg in order, skipping nothing: fn
+// ignores the numbers it does not know.
+func Parse(msg []byte, fn func(Field) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errMalformed
+		}
+		msg = msg[n:]
+		f := Field{Number: int(tag >> 3), Type: int(tag & 7)}
+		if f.Number == 0 {
+			return errMalformed
+		}
+
+		switch f.Type {
+		case wireVarint:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return errMalformed
+			}
+			f.Varint, msg = v, msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return errMalformed
+			}
+			f.Varint, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return errMalformed
+			}
+			f.Varint, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return errMalformed
+			}
+			f.Bytes, msg = msg[n:n+int(size)], msg[n+int(size):]
+		default:
+			return errMalformed
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package grpcwire
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWireBehavior(t *testing.T) {
+	var nested []byte
+	nested = AppendString(nested, 1, "user")
+	var msg []byte
+	msg = AppendString(msg, 1, "claude")
+	msg = AppendString(msg, 2, "")
+	msg = AppendBytes(msg, 3, nested)
+	msg = AppendInt(msg, 4, 300)
+	msg = AppendInt(msg, 5, -1)
+	msg = AppendDouble(msg, 6, 0.25)
+
+	var frame bytes.Buffer
+	if err := WriteFrame(&frame, msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(frame.Bytes()), len(msg)-1); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	got, err := ReadFrame(&frame, len(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields []Field
+	if err := Parse(got, func(f Field) error { fields = append(fields, f); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 5 {
+		t.Fatalf("expected 5 fields (empty strings omitted), got %d", len(fields))
+	}
+	if fields[0].String() != "claude" || fields[2].Int() != 300 || int32(fields[3].Int()) != -1 || fields[4].Double() != 0.25 {
+		t.Errorf("unexpected fields %+v", fields)
+	}
+	var role string
+	Parse(fields[1].Bytes, func(f Field) error { role = f.String(); return nil })
+	if role != "user" {
+		t.Errorf("expected nested role, got %q", role)
+	}
+	if err := Parse([]byte{0x0a, 0x05, 'a'}, func(Field) error { return nil }); err == nil {
+		t.Error("expected truncated messages to fail")
+	}
+}
+
+func TestStatusBehavior(t *testing.T) {
+	for value, expected := range map[string]time.Duration{"10S": 10 * time.Second, "500m": 500 * time.Millisecond, "1H": time.Hour, "5x": 0, "S": 0} {
+		if got, _ := ParseTimeout(value); got != expected {
+			t.Errorf("ParseTimeout(%q): expected %v, got %v", value, expected, got)
+		}
+	}
+
+	for status, expected := range map[int]Code{400: InvalidArgument, 401: Unauthenticated, 422: FailedPrecondition, 429: ResourceExhausted, 503: Unavailable, 418: Unknown} {
+		if got := CodeForHTTP(status); got != expected {
+			t.Errorf("CodeForHTTP(%d): expected %d, got %d", status, expected, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	WriteTrailers(w, Errorf(PermissionDenied, "100%% nope\n"))
+	if w.Header().Get(http.TrailerPrefix+"Grpc-Status") != "7" || w.Header().Get(http.TrailerPrefix+"Grpc-Message") != "100%25 nope%0A" {
+		t.Errorf("unexpected trailers %v", w.Header())
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/archive"
+	"github.com/manto/manto-web/internal/conversations"
+)
+
+// WithArchive enables listing and rehydrating archived conversations.
+func (h *ConversationHandlers) WithArchive(a *archive.Archiver) *ConversationHandlers {
+	h.archive = a
+	return h
+}
+
+// ListArchivedHandler lists the caller's archived conversations, most
+// recently updated first.
+func (h *ConversationHandlers) ListArchivedHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"conversations": h.store.ListArchived(owner)})
+}
+
+// RehydrateHandler brings an archived conversation back from the archive
+// and answers it as GetHandler would.
+func (h *ConversationHandlers) RehydrateHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := h.archive.Rehydrate(r.Context(), owner, chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, conversations.ErrNotFound):
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.conversationNotFound"), "")
+	case err != nil:
+		slog.Warn("conversation rehydration failed",
+			slog.String("key", h.upstream(r.Context()).Fingerprint(apiKey)),
+			slog.String("error", err.Error()))
+		writeJSONError(w, http.StatusBadGateway, h.localize(r, "errors.rehydrationFailed"), "")
+	default:
+		writeConversation(w, http.StatusOK, c)
+	}
+}
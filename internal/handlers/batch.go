@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/batch"
+	"github.com/manto/manto-web/internal/conversations"
+	"github.com/manto/manto-web/internal/services"
+)
+
+const maxBatchBodySize = 5 << 20
+
+// BatchHandlers serves the opt-in CSV prompt runner. Batches are scoped to
+// the API key that submitted them, and run with that key.
+type BatchHandlers struct {
+	*APIHandlers
+	runner *batch.Runner
+}
+
+func NewBatchHandlers(api *APIHandlers, runner *batch.Runner) *BatchHandlers {
+	return &BatchHandlers{APIHandlers: api, runner: runner}
+}
+
+// Routes mounts the batch endpoints on r.
+func (h *BatchHandlers) Routes(r chi.Router) {
+	r.Post("/api/batches", h.CreateHandler)
+	r.Get("/api/batches/{id}", h.StatusHandler)
+	r.Get("/api/batches/{id}/results", h.ResultsHandler)
+	r.Delete("/api/batches/{id}", h.DeleteHandler)
+}
+
+func (h *BatchHandlers) owner(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return "", "", false
+	}
+	return apiKey, conversations.OwnerFromAPIKey(apiKey), true
+}
+
+// CreateHandler accepts {model, csv, template, system, maxTokens}. The CSV
+// needs a header row; template placeholders like {{question}} are filled from
+// the matching column, and without a template the "prompt" column is sent.
+func (h *BatchHandlers) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Model     string  `json:"model"`
+		CSV       string  `json:"csv"`
+		Template  string  `json:"template"`
+		System    *string `json:"system"`
+		MaxTokens int     `json:"maxTokens"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+	if body.Model == "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.modelRequired"), "")
+		return
+	}
+	if body.MaxTokens < 0 {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidMaxTokens"), "")
+		return
+	}
+
+	columns, rows, err := batch.Parse(body.CSV, body.Template, h.config.Batch.MaxRows)
+	var tooMany batch.ErrTooManyRows
+	var unknown batch.ErrUnknownColumn
+	switch {
+	case errors.As(err, &tooMany):
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.batchTooLarge", tooMany.Max), "")
+		return
+	case errors.As(err, &unknown):
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.unknownTemplateColumn", unknown.Column), "")
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidCsv", err.Error()), "")
+		return
+	}
+	maxLength := h.config.Validation.MaxMessageLength
+	for _, row := range rows {
+		if len(row.Prompt) > maxLength {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messageTooLong", maxLength), "")
+			return
+		}
+	}
+
+	template := services.MessageRequest{Model: body.Model, MaxTokens: body.MaxTokens, System: body.System}
+	template.ApplyDefaults(h.config.Anthropic.MaxTokens, h.config.Anthropic.Temperature, h.systemMessage(r.Context()))
+	template.MergeStopSequences(h.stopSequences)
+	if limit := h.config.Anthropic.ModelMaxTokens.For(body.Model); limit > 0 && template.MaxTokens > limit {
+		template.MaxTokens = limit
+	}
+
+	provider := h.upstream(r.Context())
+	lane := h.queueLane(r, apiKey)
+	job := h.runner.Submit(owner, body.Model, columns, rows, func(ctx context.Context, prompt string) (batch.Result, error) {
+		request := template
+		request.Messages = []services.Message{{Role: "user", Content: prompt}}
+		response, _, err := h.moderatedSend(withProvider(ctx, provider), apiKey, lane, &request)
+		if err != nil {
+			return batch.Result{}, err
+		}
+		return batch.Result{
+			Text:         response.Text(),
+			StopReason:   response.StopReason,
+			InputTokens:  response.Usage.InputTokens,
+			OutputTokens: response.Usage.OutputTokens,
+		}, nil
+	})
+
+	w.Header().Set("Location", "/api/batches/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job.Status())
+}
+
+// StatusHandler reports how many rows have been answered.
+func (h *BatchHandlers) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+	job, err := h.runner.Get(owner, chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.batchNotFound"), "")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, job.Status())
+}
+
+// ResultsHandler downloads the results CSV. It can be fetched while the batch
+// is still running; unanswered rows have empty result columns.
+func (h *BatchHandlers) ResultsHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+	job, err := h.runner.Get(owner, chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.batchNotFound"), "")
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, job.ID))
+	w.Header().Set("Cache-Control", "no-store")
+	job.WriteCSV(w)
+}
+
+// DeleteHandler stops a running batch and discards its results.
+func (h *BatchHandlers) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+	if err := h.runner.Delete(owner, chi.URLParam(r, "id")); err != nil {
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.batchNotFound"), "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"github.com/manto/manto-web/internal/services"
+)
+
+// sendFunc sends one upstream request for an answer. trimmed reports that
+// whitespace ending the answer so far, already passed on, was left out of the
+// prefill.
+type sendFunc func(request *services.MessageRequest, trimmed bool) (*services.MessageResponse, error)
+
+// canCarryOn reports whether answer can be continued from a prefill. It needs
+// some text, and thinking blocks can't be prefilled, so answers that think
+// are never continued.
+func canCarryOn(request *services.MessageRequest, answer *services.MessageResponse) bool {
+	return request.Thinking == nil && strings.TrimSpace(answer.Text()) != ""
+}
+
+// carryOn asks for the rest of answer by prefilling the assistant turn with
+// its text, and stitches the two together. A continuation that breaks off
+// partway is returned stitched to answer as an *IncompleteError.
+func carryOn(request *services.MessageRequest, answer *services.MessageResponse, send sendFunc) (*services.MessageResponse, error) {
+	full := answer.Text()
+	// The API rejects a prefill ending in whitespace.
+	text := strings.TrimRightFunc(full, unicode.IsSpace)
+	next, err := send(continueRequest(request, text), len(text) < len(full))
+	var incomplete *services.IncompleteError
+	if errors.As(err, &incomplete) {
+		return nil, &services.IncompleteError{Partial: stitch(answer, text, incomplete.Partial), Err: incomplete.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stitch(answer, text, next), nil
+}
+
+// continueTruncated follows an answer cut off by max_tokens with up to
+// ANTHROPIC_MAX_CONTINUATIONS requests carrying on from it. If one fails, the
+// answer is returned as far as it got, still stopped by max_tokens; one that
+// breaks off partway is passed on as an *IncompleteError.
+func (h *APIHandlers) continueTruncated(request *services.MessageRequest, answer *services.MessageResponse, send sendFunc) (*services.MessageResponse, error) {
+	for answer.StopReason == services.StopMaxTokens && answer.Continuations < h.config.Anthropic.MaxContinuations && canCarryOn(request, answer) {
+		next, err := carryOn(request, answer, send)
+		var incomplete *services.IncompleteError
+		if errors.As(err, &incomplete) {
+			incomplete.Partial.Continuations++
+			return nil, err
+		}
+		if err != nil {
+			slog.Warn("continuation request failed",
+				slog.String("model", request.Model),
+				slog.String("error", err.Error()))
+			return answer, nil
+		}
+		next.Continuations++
+		answer = next
+	}
+	return answer, nil
+}
+
+// continueRequest returns a copy of request that prefills the assistant turn
+// with text, so the model carries on from where text ends. An assistant
+// message already closing the history is extended instead.
+func continueRequest(request *services.MessageRequest, text string) *services.MessageRequest {
+	next := *request
+	next.Messages = append([]services.Message(nil), request.Messages...)
+	if last := len(next.Messages) - 1; last >= 0 && next.Messages[last].Role == "assistant" {
+		next.Messages[last].Content = strings.TrimRightFunc(next.Messages[last].Content+text, unicode.IsSpace)
+		next.Messages[last].Blocks = nil
+		return &next
+	}
+	next.Messages = append(next.Messages, services.Message{Role: "assistant", Content: text})
+	return &next
+}
+
+// stitch joins the answer so far, whose text was sent on as prefill, with
+// the response that continued it. Usage is summed across both requests.
+func stitch(answer *services.MessageResponse, prefill string, next *services.MessageResponse) *services.MessageResponse {
+	stitched := *next
+	text := prefill + next.Text()
+	stitched.Content = []services.ContentBlock{{Type: services.BlockText, Text: &text}}
+	stitched.Usage.InputTokens += answer.Usage.InputTokens
+	stitched.Usage.OutputTokens += answer.Usage.OutputTokens
+	stitched.Continuations = answer.Continuations
+	return &stitched
+}
+
+// skipLeadingSpace wraps onText to drop whitespace opening a continuation
+// when trimmed, since the whitespace the prefill left off was passed on
+// already.
+func skipLeadingSpace(onText func(string) error, trimmed bool) func(string) error {
+	return func(s string) error {
+		if trimmed {
+			if s = strings.TrimLeftFunc(s, unicode.IsSpace); s == "" {
+				return nil
+			}
+			trimmed = false
+		}
+		return onText(s)
+	}
+}
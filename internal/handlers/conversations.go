@@ -0,0 +1,783 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/archive"
+	"github.com/manto/manto-web/internal/conversations"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/session"
+	"github.com/manto/manto-web/internal/tenants"
+)
+
+// ConversationHandlers serves the opt-in conversation history API. Every
+// route is scoped to the owner derived from the caller's API key.
+type ConversationHandlers struct {
+	*APIHandlers
+	store   *conversations.Store
+	archive *archive.Archiver
+}
+
+func NewConversationHandlers(api *APIHandlers, store *conversations.Store) *ConversationHandlers {
+	return &ConversationHandlers{APIHandlers: api, store: store}
+}
+
+// Routes mounts the conversation endpoints on r.
+func (h *ConversationHandlers) Routes(r chi.Router) {
+	r.Get("/api/search", h.SearchHandler)
+	r.Get("/api/conversations", h.ListHandler)
+	r.Post("/api/conversations", h.CreateHandler)
+	r.Get("/api/conversations/{id}", h.GetHandler)
+	r.Patch("/api/conversations/{id}", h.UpdateHandler)
+	r.Delete("/api/conversations/{id}", h.DeleteHandler)
+	r.Post("/api/conversations/{id}/messages", h.SendHandler)
+	r.Patch("/api/conversations/{id}/messages/{msgId}", h.EditHandler)
+	r.Post("/api/conversations/{id}/messages/{msgId}/regenerate", h.RegenerateHandler)
+	r.Post("/api/conversations/{id}/messages/{msgId}/select", h.SelectBranchHandler)
+	r.Post("/api/messages/{id}/feedback", h.FeedbackHandler)
+	r.Get("/api/feedback", h.FeedbackReportHandler)
+	if h.archive != nil {
+		r.Get("/api/conversations/archived", h.ListArchivedHandler)
+		r.Post("/api/conversations/{id}/rehydrate", h.RehydrateHandler)
+	}
+}
+
+// messageView is a message on the active path along with the alternatives
+// that share its parent, so clients can render "< 2/3 >" branch controls.
+type messageView struct {
+	*conversations.Message
+	SiblingIDs  []string `json:"siblingIds"`
+	BranchIndex int      `json:"branchIndex"`
+}
+
+type conversationView struct {
+	ID           string        `json:"id"`
+	Title        string        `json:"title"`
+	Model        string        `json:"model,omitempty"`
+	Tags         []string      `json:"tags"`
+	Folder       string        `json:"folder,omitempty"`
+	Pinned       bool          `json:"pinned"`
+	ActiveLeafID string        `json:"activeLeafId,omitempty"`
+	Messages     []messageView `json:"messages"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	UpdatedAt    time.Time     `json:"updatedAt"`
+	Version      int           `json:"version"`
+}
+
+func newConversationView(c *conversations.Conversation) conversationView {
+	view := conversationView{
+		ID:           c.ID,
+		Title:        c.Title,
+		Model:        c.Model,
+		Tags:         append([]string{}, c.Tags...),
+		Folder:       c.Folder,
+		Pinned:       c.Pinned,
+		ActiveLeafID: c.ActiveLeafID,
+		Messages:     []messageView{},
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+		Version:      c.Version,
+	}
+	for _, m := range c.ActivePath() {
+		mv := messageView{Message: m}
+		for i, sibling := range c.Children(m.ParentID) {
+			mv.SiblingIDs = append(mv.SiblingIDs, sibling.ID)
+			if sibling.ID == m.ID {
+				mv.BranchIndex = i
+			}
+		}
+		view.Messages = append(view.Messages, mv)
+	}
+	return view
+}
+
+// owner validates the API key and returns the owner it maps to: the anonymous
+// session when SESSION_SCOPE_CONVERSATIONS is set, otherwise the key itself.
+// It writes the error response itself when the key is invalid.
+func (h *ConversationHandlers) owner(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return "", "", false
+	}
+	tenant := tenants.FromContext(r.Context())
+	if s := session.FromContext(r.Context()); s != nil && h.config.Session.ScopeConversations {
+		return apiKey, tenant.Owner(conversations.OwnerFromSession(s.ID)), true
+	}
+	return apiKey, tenant.Owner(conversations.OwnerFromAPIKey(apiKey)), true
+}
+
+func (h *ConversationHandlers) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	var conflict *conversations.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		// The current conversation lets the client merge its change in and
+		// retry with the new ETag.
+		w.Header().Set("ETag", conversationETag(conflict.Current))
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":          h.localize(r, "errors.conversationConflict"),
+			"currentVersion": conflict.Current.Version,
+			"conversation":   newConversationView(conflict.Current),
+		})
+	case errors.Is(err, conversations.ErrArchived):
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"error":    h.localize(r, "errors.conversationArchived"),
+			"archived": true,
+		})
+	case errors.Is(err, conversations.ErrNotFound):
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.conversationNotFound"), "")
+	case errors.Is(err, conversations.ErrMessageNotFound):
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.conversationMessageNotFound"), "")
+	case errors.Is(err, conversations.ErrNotEditable):
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messageNotEditable"), "")
+	case errors.Is(err, conversations.ErrNotRateable):
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messageNotRateable"), "")
+	case errors.Is(err, conversations.ErrInvalidRating):
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidRating"), "")
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), "")
+	}
+}
+
+// conversationETag is the strong entity tag for c's version.
+func conversationETag(c *conversations.Conversation) string {
+	return `"` + strconv.Itoa(c.Version) + `"`
+}
+
+// writeConversation answers with c and its ETag, which clients send back in
+// If-Match to change it.
+func writeConversation(w http.ResponseWriter, status int, c *conversations.Conversation) {
+	w.Header().Set("ETag", conversationETag(c))
+	writeJSON(w, status, newConversationView(c))
+}
+
+// ifMatch turns the If-Match header into a version check for the store: nil,
+// accepting any version, when the header is absent, and a check that always
+// passes for "*". Weak tags never match. With CONVERSATIONS_REQUIRE_IF_MATCH
+// a missing header is refused with 428; it writes that response itself.
+func (h *ConversationHandlers) ifMatch(w http.ResponseWriter, r *http.Request) (func(int) bool, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if h.config.Conversations.RequireIfMatch {
+			writeJSONError(w, http.StatusPreconditionRequired, h.localize(r, "errors.ifMatchRequired"), "")
+			return nil, false
+		}
+		return nil, true
+	}
+	if header == "*" {
+		return func(int) bool { return true }, true
+	}
+	var versions []int
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") || len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		if version, err := strconv.Atoi(tag[1 : len(tag)-1]); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return func(version int) bool { return slices.Contains(versions, version) }, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ListHandler lists the caller's conversations, optionally filtered by
+// ?tag=, ?folder= and ?pinned=.
+func (h *ConversationHandlers) ListHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	opts := conversations.ListOptions{Tag: query.Get("tag"), Folder: query.Get("folder")}
+	if raw := query.Get("pinned"); raw != "" {
+		pinned, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidQueryParameter", "pinned"), "")
+			return
+		}
+		opts.Pinned = &pinned
+	}
+
+	type summary struct {
+		ID        string    `json:"id"`
+		Title     string    `json:"title"`
+		Model     string    `json:"model,omitempty"`
+		Tags      []string  `json:"tags"`
+		Folder    string    `json:"folder,omitempty"`
+		Pinned    bool      `json:"pinned"`
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+	list := []summary{}
+	for _, c := range h.store.List(owner, opts) {
+		list = append(list, summary{
+			ID:        c.ID,
+			Title:     c.Title,
+			Model:     c.Model,
+			Tags:      append([]string{}, c.Tags...),
+			Folder:    c.Folder,
+			Pinned:    c.Pinned,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"conversations": list})
+}
+
+// SearchHandler searches message content across the caller's conversations.
+// Filters: q, model, role, tag, folder, from and to (RFC 3339 or YYYY-MM-DD,
+// with a bare "to" date being inclusive), sort (newest or oldest), limit, and
+// the cursor returned as nextCursor by the previous page.
+func (h *ConversationHandlers) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	q := conversations.SearchQuery{
+		Text:   query.Get("q"),
+		Model:  query.Get("model"),
+		Role:   query.Get("role"),
+		Tag:    query.Get("tag"),
+		Folder: query.Get("folder"),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+	}
+
+	invalid := func(param string) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidQueryParameter", param), "")
+	}
+	if q.Role != "" && q.Role != conversations.RoleUser && q.Role != conversations.RoleAssistant {
+		invalid("role")
+		return
+	}
+	for _, bound := range []struct {
+		param string
+		dest  *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		raw := query.Get(bound.param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, raw)
+			if err == nil && bound.param == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		if err != nil {
+			invalid(bound.param)
+			return
+		}
+		*bound.dest = t
+	}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			invalid("limit")
+			return
+		}
+		q.Limit = limit
+	}
+
+	result, err := h.store.Search(owner, q)
+	switch {
+	case errors.Is(err, conversations.ErrInvalidSort):
+		invalid("sort")
+		return
+	case errors.Is(err, conversations.ErrInvalidCursor):
+		invalid("cursor")
+		return
+	case err != nil:
+		h.writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *ConversationHandlers) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Title  string   `json:"title"`
+		Model  string   `json:"model"`
+		Tags   []string `json:"tags"`
+		Folder string   `json:"folder"`
+		Pinned bool     `json:"pinned"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+
+	now := time.Now().UTC()
+	c := &conversations.Conversation{
+		ID:        conversations.NewID("conv_"),
+		Owner:     owner,
+		Title:     body.Title,
+		Model:     body.Model,
+		Tags:      conversations.NormalizeTags(body.Tags),
+		Folder:    body.Folder,
+		Pinned:    body.Pinned,
+		Messages:  []*conversations.Message{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := h.store.Create(c); err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	writeConversation(w, http.StatusCreated, c)
+}
+
+func (h *ConversationHandlers) GetHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := h.store.Get(owner, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	writeConversation(w, http.StatusOK, c)
+}
+
+// UpdateHandler changes a conversation's title, model, tags, folder or pinned
+// flag. Omitted fields are left as they are; an empty folder removes it.
+func (h *ConversationHandlers) UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Title  *string  `json:"title"`
+		Model  *string  `json:"model"`
+		Tags   []string `json:"tags"`
+		Folder *string  `json:"folder"`
+		Pinned *bool    `json:"pinned"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+
+	match, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+	c, err := h.store.UpdateIf(owner, chi.URLParam(r, "id"), match, func(c *conversations.Conversation) error {
+		if body.Title != nil {
+			c.Title = *body.Title
+		}
+		if body.Model != nil {
+			c.Model = *body.Model
+		}
+		if body.Tags != nil {
+			c.Tags = conversations.NormalizeTags(body.Tags)
+		}
+		if body.Folder != nil {
+			c.Folder = *body.Folder
+		}
+		if body.Pinned != nil {
+			c.Pinned = *body.Pinned
+		}
+		c.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	writeConversation(w, http.StatusOK, c)
+}
+
+func (h *ConversationHandlers) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	match, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteIf(owner, chi.URLParam(r, "id"), match); err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FeedbackHandler rates a stored assistant message with {"rating": "up" or
+// "down", "comment": "..."}. Rating it again replaces the earlier feedback.
+func (h *ConversationHandlers) FeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Rating  string `json:"rating"`
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+
+	message, err := h.store.SetFeedback(owner, chi.URLParam(r, "id"), conversations.Feedback{
+		Rating:    body.Rating,
+		Comment:   body.Comment,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, message)
+}
+
+// FeedbackReportHandler aggregates the caller's ratings overall, per model
+// and per day, optionally only those given since ?since= (RFC 3339 or
+// YYYY-MM-DD).
+func (h *ConversationHandlers) FeedbackReportHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	since, err := conversations.ParseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidQueryParameter", "since"), "")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, h.store.Feedback(owner, since))
+}
+
+// autoTitle titles an untitled conversation from the first message on the
+// path to leafID. Failures are only logged, since the reply itself worked.
+func (h *ConversationHandlers) autoTitle(ctx context.Context, apiKey string, lane clientLane, owner string, c *conversations.Conversation, leafID string) *conversations.Conversation {
+	path := c.PathTo(leafID)
+	if len(path) == 0 {
+		return c
+	}
+	title, _, err := h.generateTitle(ctx, apiKey, lane, path[0].Content)
+	if err == nil {
+		var updated *conversations.Conversation
+		updated, err = h.store.Update(owner, c.ID, func(c *conversations.Conversation) error {
+			if c.Title == "" {
+				c.Title = title
+			}
+			return nil
+		})
+		if err == nil {
+			return updated
+		}
+	}
+	slog.Warn("conversation title generation failed",
+		slog.String("key", h.upstream(ctx).Fingerprint(apiKey)),
+		slog.String("error", err.Error()))
+	return c
+}
+
+// generationOptions are the per-request overrides accepted when sending or
+// regenerating a message.
+type generationOptions struct {
+	Model       string                   `json:"model"`
+	Temperature *float64                 `json:"temperature"`
+	Thinking    *services.ThinkingConfig `json:"thinking"`
+}
+
+// SendHandler appends a user message (to the active leaf unless parentId is
+// given) and stores the model's reply as its child. If the upstream call
+// fails the user message is kept so the reply can be regenerated.
+func (h *ConversationHandlers) SendHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		generationOptions
+		Content  string  `json:"content"`
+		ParentID *string `json:"parentId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+	if body.Content == "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contentRequired"), "")
+		return
+	}
+	if maxLength := h.config.Validation.MaxMessageLength; len(body.Content) > maxLength {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messageTooLong", maxLength), "")
+		return
+	}
+	if !h.validOptions(w, r, body.generationOptions) {
+		return
+	}
+
+	match, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	userMessage := &conversations.Message{
+		ID:        conversations.NewID("msg_"),
+		Role:      conversations.RoleUser,
+		Content:   body.Content,
+		CreatedAt: time.Now().UTC(),
+	}
+	c, err := h.store.UpdateIf(owner, chi.URLParam(r, "id"), match, func(c *conversations.Conversation) error {
+		userMessage.ParentID = c.ActiveLeafID
+		if body.ParentID != nil {
+			userMessage.ParentID = *body.ParentID
+		}
+		return c.AddMessage(userMessage)
+	})
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+
+	h.reply(w, r, apiKey, owner, c, userMessage.ID, "", body.generationOptions)
+}
+
+// EditHandler rewrites a user message and drops the replies below it, keeping
+// the previous content in the message's edit history. Clients rerun the
+// prompt by regenerating on the edited message.
+func (h *ConversationHandlers) EditHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+	if body.Content == "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contentRequired"), "")
+		return
+	}
+	if maxLength := h.config.Validation.MaxMessageLength; len(body.Content) > maxLength {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messageTooLong", maxLength), "")
+		return
+	}
+
+	match, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+	msgID := chi.URLParam(r, "msgId")
+	c, err := h.store.UpdateIf(owner, chi.URLParam(r, "id"), match, func(c *conversations.Conversation) error {
+		return c.EditMessage(msgID, body.Content, time.Now().UTC())
+	})
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	writeConversation(w, http.StatusOK, c)
+}
+
+// RegenerateHandler asks the model again for a reply to the same user
+// message, optionally with a different model or temperature. The new reply
+// becomes a sibling branch of msgId and is made active; earlier replies stay
+// reachable via the select endpoint. msgId may be the assistant reply to
+// retry or the user message itself.
+func (h *ConversationHandlers) RegenerateHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	var opts generationOptions
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+			return
+		}
+	}
+	if !h.validOptions(w, r, opts) {
+		return
+	}
+	match, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := h.store.Get(owner, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	if match != nil && !match(c.Version) {
+		h.writeStoreError(w, r, &conversations.ConflictError{Current: c})
+		return
+	}
+	target, ok := c.Message(chi.URLParam(r, "msgId"))
+	if !ok {
+		h.writeStoreError(w, r, conversations.ErrMessageNotFound)
+		return
+	}
+
+	parentID, previousModel := target.ID, ""
+	if target.Role == conversations.RoleAssistant {
+		parentID, previousModel = target.ParentID, target.Model
+	}
+	h.reply(w, r, apiKey, owner, c, parentID, previousModel, opts)
+}
+
+// SelectBranchHandler switches the active branch to the one containing msgId.
+func (h *ConversationHandlers) SelectBranchHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	match, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+	msgID := chi.URLParam(r, "msgId")
+	c, err := h.store.UpdateIf(owner, chi.URLParam(r, "id"), match, func(c *conversations.Conversation) error {
+		return c.SelectBranch(msgID)
+	})
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	writeConversation(w, http.StatusOK, c)
+}
+
+// richBlocks returns blocks if they hold anything besides text, which
+// Content already keeps, or nil.
+func richBlocks(blocks []services.ContentBlock) []services.ContentBlock {
+	for _, b := range blocks {
+		if b.Type != services.BlockText {
+			return blocks
+		}
+	}
+	return nil
+}
+
+func (h *ConversationHandlers) validOptions(w http.ResponseWriter, r *http.Request, opts generationOptions) bool {
+	if t := opts.Temperature; t != nil && (*t < 0 || *t > 1) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidTemperature"), "")
+		return false
+	}
+	return true
+}
+
+// reply sends the history leading to parentID upstream and stores the answer
+// as a new child of parentID, marked incomplete if the upstream broke off.
+// The model is taken from opts, then fallback, then the conversation default.
+func (h *ConversationHandlers) reply(w http.ResponseWriter, r *http.Request, apiKey, owner string, c *conversations.Conversation, parentID, fallbackModel string, opts generationOptions) {
+	model := opts.Model
+	if model == "" {
+		model = fallbackModel
+	}
+	if model == "" {
+		model = c.Model
+	}
+	if model == "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.modelRequired"), "")
+		return
+	}
+
+	request := services.MessageRequest{Model: model, Temperature: opts.Temperature, Thinking: opts.Thinking}
+	for _, m := range c.PathTo(parentID) {
+		request.Messages = append(request.Messages, services.Message{Role: m.Role, Content: m.Content, Blocks: m.Blocks, Model: m.Model})
+	}
+	scope, ok := h.checkMessage(w, r, apiKey, &request)
+	if !ok {
+		return
+	}
+
+	ctx := h.moderationContext(r)
+	lane := h.queueLane(r, apiKey)
+	release, err := h.acquireSlot(ctx, lane)
+	var response *services.MessageResponse
+	var outputAction string
+	if err == nil {
+		started := time.Now()
+		response, outputAction, err = h.streamAnswer(ctx, apiKey, &request, func(string) error { return nil })
+		release()
+		h.journalRequest(ctx, scope, apiKey, request.Model, response, err)
+		h.observeSLO(ctx, request.Model, started, err)
+	}
+	action := stricter(scope.moderation, outputAction)
+	if h.writeModerationError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	answer := &conversations.Message{
+		ID:            conversations.NewID("msg_"),
+		ParentID:      parentID,
+		Role:          conversations.RoleAssistant,
+		Content:       response.Text(),
+		Blocks:        richBlocks(response.Content),
+		Model:         request.Model,
+		Temperature:   request.Temperature,
+		StopReason:    response.StopReason,
+		Incomplete:    response.Incomplete,
+		Continuations: response.Continuations,
+		CreatedAt:     time.Now().UTC(),
+	}
+	updated, err := h.store.Update(owner, c.ID, func(c *conversations.Conversation) error {
+		return c.AddMessage(answer)
+	})
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	if updated.Title == "" && h.config.Title.Conversations {
+		updated = h.autoTitle(ctx, apiKey, lane, owner, updated, answer.ID)
+	}
+
+	scope.session.RecordUsage(h.upstream(r.Context()).Fingerprint(apiKey), response.Usage.InputTokens, response.Usage.OutputTokens)
+	scope.tenant.RecordUsage(response.Usage.InputTokens, response.Usage.OutputTokens)
+	setUsageHeaders(w.Header(), response.Usage)
+	if action != "" {
+		w.Header().Set(moderationHeader, action)
+	}
+	scope.variant.RecordResponse(w.Header())
+	w.Header().Set("ETag", conversationETag(updated))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message":      answer,
+		"conversation": newConversationView(updated),
+	})
+}
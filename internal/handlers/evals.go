@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/conversations"
+	"github.com/manto/manto-web/internal/evals"
+	"github.com/manto/manto-web/internal/services"
+)
+
+const maxEvalBodySize = 1 << 20
+
+// EvalHandlers serves the opt-in prompt evaluation runner. Runs are scoped to
+// the API key that started them, and call the models with that key.
+type EvalHandlers struct {
+	*APIHandlers
+	store  *evals.Store
+	runner *evals.Runner
+}
+
+func NewEvalHandlers(api *APIHandlers, store *evals.Store) *EvalHandlers {
+	return &EvalHandlers{
+		APIHandlers: api,
+		store:       store,
+		runner:      evals.NewRunner(store, api.config.Evals.Concurrency),
+	}
+}
+
+// Routes mounts the eval endpoints on r.
+func (h *EvalHandlers) Routes(r chi.Router) {
+	r.Post("/api/evals", h.CreateHandler)
+	r.Get("/api/evals", h.ListHandler)
+	r.Get("/api/evals/{id}", h.ReportHandler)
+	r.Delete("/api/evals/{id}", h.DeleteHandler)
+}
+
+func (h *EvalHandlers) owner(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return "", "", false
+	}
+	return apiKey, conversations.OwnerFromAPIKey(apiKey), true
+}
+
+func (h *EvalHandlers) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, evals.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.evalNotFound"), "")
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, err.Error(), "")
+}
+
+// CreateHandler starts running a suite and answers 202 with the empty report.
+func (h *EvalHandlers) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	var suite evals.Suite
+	r.Body = http.MaxBytesReader(w, r.Body, maxEvalBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&suite); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+	if err := suite.Validate(h.config.Evals.MaxCases); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidEvalSuite", err.Error()), "")
+		return
+	}
+	if suite.Temperature != nil && (*suite.Temperature < 0 || *suite.Temperature > 1) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidTemperature"), "")
+		return
+	}
+	maxLength := h.config.Validation.MaxMessageLength
+	for _, c := range suite.Cases {
+		if len(c.Prompt) > maxLength {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messageTooLong", maxLength), "")
+			return
+		}
+	}
+
+	run, err := h.runner.Start(owner, suite, h.completer(apiKey, h.queueLane(r, apiKey), suite.Temperature))
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/evals/"+run.ID)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// completer sends single-turn prompts with apiKey, waiting in lane for an
+// upstream slot and applying the deployment's defaults, stop sequences and
+// max_tokens caps like any other request.
+func (h *EvalHandlers) completer(apiKey string, lane clientLane, temperature *float64) evals.CompleteFunc {
+	return func(ctx context.Context, model, system, prompt string) (string, error) {
+		request := services.MessageRequest{
+			Model:       model,
+			Messages:    []services.Message{{Role: "user", Content: prompt}},
+			Temperature: temperature,
+		}
+		if system != "" {
+			request.System = &system
+		}
+		request.ApplyDefaults(h.config.Anthropic.MaxTokens, h.config.Anthropic.Temperature, h.systemMessage(ctx))
+		request.MergeStopSequences(h.stopSequences)
+		if limit := h.config.Anthropic.ModelMaxTokens.For(model); limit > 0 && request.MaxTokens > limit {
+			request.MaxTokens = limit
+		}
+
+		response, _, err := h.moderatedSend(ctx, apiKey, lane, &request)
+		if err != nil {
+			return "", err
+		}
+		return response.Text(), nil
+	}
+}
+
+// evalListItem is a run without its per-case results.
+type evalListItem struct {
+	ID         string               `json:"id"`
+	Name       string               `json:"name"`
+	Status     string               `json:"status"`
+	Cases      int                  `json:"cases"`
+	Summary    []evals.ModelSummary `json:"summary"`
+	CreatedAt  time.Time            `json:"createdAt"`
+	FinishedAt *time.Time           `json:"finishedAt,omitempty"`
+}
+
+// ListHandler lists the caller's runs, newest first, with their summaries.
+func (h *EvalHandlers) ListHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+
+	runs := h.store.List(owner)
+	items := make([]evalListItem, 0, len(runs))
+	for _, run := range runs {
+		items = append(items, evalListItem{
+			ID:         run.ID,
+			Name:       run.Suite.Name,
+			Status:     run.Status,
+			Cases:      len(run.Suite.Cases),
+			Summary:    run.Summary,
+			CreatedAt:  run.CreatedAt,
+			FinishedAt: run.FinishedAt,
+		})
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{"evals": items})
+}
+
+// ReportHandler returns a run with every case result. It can be fetched while
+// the run is still going.
+func (h *EvalHandlers) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+	run, err := h.store.Get(owner, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, run)
+}
+
+// DeleteHandler discards a run's report. Cases still in flight finish but are
+// not recorded.
+func (h *EvalHandlers) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	_, owner, ok := h.owner(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.Delete(owner, chi.URLParam(r, "id")); err != nil {
+		h.writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
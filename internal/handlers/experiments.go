@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/experiments"
+)
+
+// ExperimentHandlers collects feedback for the running A/B experiment. The
+// variant is the one the caller's session was assigned, so clients only say
+// whether they liked the answer.
+type ExperimentHandlers struct {
+	*APIHandlers
+}
+
+func NewExperimentHandlers(api *APIHandlers) *ExperimentHandlers {
+	return &ExperimentHandlers{APIHandlers: api}
+}
+
+// Routes mounts the experiment endpoints on r.
+func (h *ExperimentHandlers) Routes(r chi.Router) {
+	r.Post("/api/experiments/feedback", h.FeedbackHandler)
+}
+
+// FeedbackHandler accepts {"rating": "up"|"down"} for the session's variant.
+func (h *ExperimentHandlers) FeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	variant := experiments.FromContext(r.Context())
+	if variant == nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.notInExperiment"), "")
+		return
+	}
+
+	var body struct {
+		Rating string `json:"rating"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxEventBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+	if body.Rating != "up" && body.Rating != "down" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidRating"), "")
+		return
+	}
+
+	variant.RecordFeedback(body.Rating == "up")
+	w.WriteHeader(http.StatusNoContent)
+}
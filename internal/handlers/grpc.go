@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/manto/manto-web/internal/grpcwire"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+)
+
+const (
+	grpcContentType    = "application/grpc"
+	grpcMaxMessageSize = 4 << 20
+
+	grpcListModels    = "/manto.v1.Manto/ListModels"
+	grpcSendMessage   = "/manto.v1.Manto/SendMessage"
+	grpcStreamMessage = "/manto.v1.Manto/StreamMessage"
+)
+
+// GRPCHandlers serves the manto.v1.Manto service described in
+// proto/manto/v1/manto.proto. Requests go through the same checks as the
+// HTTP API; informational X-Manto-* headers are returned as response
+// metadata and rejections as the matching gRPC status.
+type GRPCHandlers struct {
+	*APIHandlers
+}
+
+func NewGRPCHandlers(api *APIHandlers) *GRPCHandlers {
+	return &GRPCHandlers{APIHandlers: api}
+}
+
+type grpcGateKey struct{}
+
+// grpcGate holds back what middleware writes until the request reaches the
+// gRPC handler, so a middleware rejection can be sent as a gRPC status.
+type grpcGate struct {
+	http.ResponseWriter
+	passed bool
+	status int
+	body   bytes.Buffer
+}
+
+func (g *grpcGate) WriteHeader(status int) {
+	if g.passed {
+		g.ResponseWriter.WriteHeader(status)
+		return
+	}
+	g.status = status
+}
+
+func (g *grpcGate) Write(b []byte) (int, error) {
+	if g.passed {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.body.Write(b)
+}
+
+func (g *grpcGate) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Handler returns the service behind middleware, outermost first, turning
+// the JSON errors middleware answers with into gRPC statuses.
+func (g *GRPCHandlers) Handler(middleware ...func(http.Handler) http.Handler) http.Handler {
+	var next http.Handler = g
+	for i := len(middleware) - 1; i >= 0; i-- {
+		next = middleware[i](next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gate := &grpcGate{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(gate, r.WithContext(context.WithValue(r.Context(), grpcGateKey{}, gate)))
+		if !gate.passed {
+			w.Header().Set("Content-Type", grpcContentType)
+			grpcwire.WriteTrailers(w, grpcStatus(gate.status, gate.body.Bytes()))
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+func (g *GRPCHandlers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if gate, ok := r.Context().Value(grpcGateKey{}).(*grpcGate); ok {
+		gate.passed = true
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType) {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", grpcContentType)
+
+	if timeout, ok := grpcwire.ParseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	var st *grpcwire.Status
+	msg, err := grpcwire.ReadFrame(r.Body, grpcMaxMessageSize)
+	switch {
+	case errors.Is(err, grpcwire.ErrTooLarge):
+		st = grpcwire.Errorf(grpcwire.ResourceExhausted, "request message larger than %d bytes", grpcMaxMessageSize)
+	case err != nil:
+		st = grpcwire.Errorf(grpcwire.InvalidArgument, "reading request: %v", err)
+	default:
+		switch r.URL.Path {
+		case grpcListModels:
+			st = g.listModels(w, r)
+		case grpcSendMessage:
+			st = g.sendMessage(w, r, msg)
+		case grpcStreamMessage:
+			st = g.streamMessage(w, r, msg)
+		default:
+			st = grpcwire.Errorf(grpcwire.Unimplemented, "unknown method %s", r.URL.Path)
+		}
+	}
+	grpcwire.WriteTrailers(w, st)
+}
+
+// grpcStatus converts an HTTP error response from the shared handler code
+// into a gRPC status.
+func grpcStatus(status int, body []byte) *grpcwire.Status {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil || e.Error == "" {
+		e.Error = http.StatusText(status)
+	}
+	return &grpcwire.Status{Code: grpcwire.CodeForHTTP(status), Message: e.Error}
+}
+
+// grpcRecorder captures what checkMessage writes, so its headers can become
+// metadata and its error response a status.
+type grpcRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *grpcRecorder) Header() http.Header         { return c.header }
+func (c *grpcRecorder) WriteHeader(status int)      { c.status = status }
+func (c *grpcRecorder) Write(b []byte) (int, error) { return c.body.Write(b) }
+
+func (g *GRPCHandlers) apiKey(r *http.Request) (string, *grpcwire.Status) {
+	apiKey := g.upstream(r.Context()).ClientAPIKey(r)
+	if !g.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		return "", grpcwire.Errorf(grpcwire.Unauthenticated, "%s", g.localize(r, "errors.invalidApiKey"))
+	}
+	return apiKey, nil
+}
+
+func (g *GRPCHandlers) listModels(w http.ResponseWriter, r *http.Request) *grpcwire.Status {
+	apiKey, st := g.apiKey(r)
+	if st != nil {
+		return st
+	}
+	modelsData, err := g.modelsCache.GetModels(r.Context(), g.upstream(r.Context()), apiKey)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return grpcwire.Errorf(grpcwire.Canceled, "%v", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return grpcwire.Errorf(grpcwire.DeadlineExceeded, "%v", err)
+	case err != nil:
+		return grpcwire.Errorf(grpcwire.CodeForHTTP(services.AsAPIError(err).HTTPStatus), "%v", err)
+	}
+
+	var models struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+			CreatedAt   string `json:"created_at"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(modelsData), &models); err != nil {
+		return grpcwire.Errorf(grpcwire.Internal, "%s", g.localize(r, "errors.invalidUpstreamResponse"))
+	}
+	var out []byte
+	for _, m := range models.Data {
+		var model []byte
+		model = grpcwire.AppendString(model, 1, m.ID)
+		model = grpcwire.AppendString(model, 2, m.DisplayName)
+		model = grpcwire.AppendString(model, 3, m.CreatedAt)
+		out = grpcwire.AppendBytes(out, 1, model)
+	}
+	if err := grpcwire.WriteFrame(w, out); err != nil {
+		return grpcwire.Errorf(grpcwire.Unavailable, "%v", err)
+	}
+	return nil
+}
+
+// prepare decodes and checks a MessageRequest, copying checkMessage's
+// headers to the response metadata.
+func (g *GRPCHandlers) prepare(w http.ResponseWriter, r *http.Request, msg []byte) (string, *services.MessageRequest, requestScope, *grpcwire.Status) {
+	apiKey, st := g.apiKey(r)
+	if st != nil {
+		return "", nil, requestScope{}, st
+	}
+	request, err := decodeMessageRequest(msg)
+	if err != nil {
+		return "", nil, requestScope{}, grpcwire.Errorf(grpcwire.InvalidArgument, "%s", g.localize(r, "errors.invalidJson"))
+	}
+
+	rec := &grpcRecorder{header: http.Header{}, status: http.StatusOK}
+	scope, ok := g.checkMessage(rec, r, apiKey, request)
+	for name, values := range rec.header {
+		if strings.HasPrefix(name, "X-Manto-") {
+			w.Header()[name] = values
+		}
+	}
+	if !ok {
+		return "", nil, requestScope{}, grpcStatus(rec.status, rec.body.Bytes())
+	}
+	return apiKey, request, scope, nil
+}
+
+// acquire waits for an upstream slot; gRPC clients wait in line rather than
+// polling. The caller must release the slot.
+func (g *GRPCHandlers) acquire(r *http.Request, apiKey string) *grpcwire.Status {
+	lane := g.queueLane(r, apiKey)
+	waiter, err := g.queue.EnqueueFor(lane.name, lane.weight)
+	if err != nil {
+		return grpcwire.Errorf(grpcwire.Unavailable, "%s", g.localize(r, "errors.queueFull"))
+	}
+	select {
+	case <-waiter.Ready():
+		return nil
+	case <-r.Context().Done():
+		g.queue.Cancel(waiter)
+		if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			return grpcwire.Errorf(grpcwire.DeadlineExceeded, "deadline exceeded while queued")
+		}
+		return grpcwire.Errorf(grpcwire.Canceled, "canceled while queued")
+	}
+}
+
+// upstreamStatus turns an upstream or output moderation failure into a
+// status, logging the former. Upstream failures get the code closest to
+// their *services.APIError's HTTP status.
+func (g *GRPCHandlers) upstreamStatus(r *http.Request, apiKey, model string, err error) *grpcwire.Status {
+	if errors.Is(err, moderation.ErrBlocked) {
+		return grpcwire.Errorf(grpcwire.FailedPrecondition, "%s", g.localize(r, moderationMessageKey(err)))
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return grpcwire.Errorf(grpcwire.DeadlineExceeded, "%v", err)
+	}
+	slog.Warn("upstream gRPC message request failed",
+		slog.String("key", g.upstream(r.Context()).Fingerprint(apiKey)),
+		slog.String("model", model),
+		slog.String("error", err.Error()))
+	return grpcwire.Errorf(grpcwire.CodeForHTTP(services.AsAPIError(err).HTTPStatus), "%v", err)
+}
+
+func (g *GRPCHandlers) sendMessage(w http.ResponseWriter, r *http.Request, msg []byte) *grpcwire.Status {
+	apiKey, request, scope, st := g.prepare(w, r, msg)
+	if st != nil {
+		return st
+	}
+	if st := g.acquire(r, apiKey); st != nil {
+		return st
+	}
+	upstreamStart := time.Now()
+	response, err := g.upstream(r.Context()).SendMessage(r.Context(), apiKey, request)
+	g.queue.Release(time.Since(upstreamStart))
+	g.observeSLO(r.Context(), request.Model, upstreamStart, err)
+	if err != nil {
+		g.journalRequest(r.Context(), scope, apiKey, request.Model, nil, err)
+		return g.upstreamStatus(r, apiKey, request.Model, err)
+	}
+
+	scope.session.RecordUsage(g.upstream(r.Context()).Fingerprint(apiKey), response.Usage.InputTokens, response.Usage.OutputTokens)
+	outputAction, err := g.moderateOutput(r.Context(), apiKey, response)
+	g.journalRequest(r.Context(), scope, apiKey, request.Model, response, err)
+	if err != nil {
+		return g.upstreamStatus(r, apiKey, request.Model, err)
+	}
+	setUsageHeaders(w.Header(), response.Usage)
+	scope.variant.RecordResponse(w.Header())
+	if err := grpcwire.WriteFrame(w, encodeMessageResponse(response, stricter(scope.moderation, outputAction))); err != nil {
+		return grpcwire.Errorf(grpcwire.Unavailable, "%v", err)
+	}
+	return nil
+}
+
+func (g *GRPCHandlers) streamMessage(w http.ResponseWriter, r *http.Request, msg []byte) *grpcwire.Status {
+	apiKey, request, scope, st := g.prepare(w, r, msg)
+	if st != nil {
+		return st
+	}
+	if st := g.acquire(r, apiKey); st != nil {
+		return st
+	}
+	upstreamStart := time.Now()
+	defer func() { g.queue.Release(time.Since(upstreamStart)) }()
+
+	scope.variant.RecordResponse(w.Header())
+	rc := http.NewResponseController(w)
+	send := func(event []byte) error {
+		if err := grpcwire.WriteFrame(w, event); err != nil {
+			return err
+		}
+		rc.Flush()
+		return nil
+	}
+
+	response, outputAction, err := g.streamAnswer(r.Context(), apiKey, request, func(text string) error {
+		return send(grpcwire.AppendString(nil, 1, text))
+	})
+	g.journalRequest(r.Context(), scope, apiKey, request.Model, response, err)
+	g.observeSLO(r.Context(), request.Model, upstreamStart, err)
+	if err != nil {
+		return g.upstreamStatus(r, apiKey, request.Model, err)
+	}
+	scope.session.RecordUsage(g.upstream(r.Context()).Fingerprint(apiKey), response.Usage.InputTokens, response.Usage.OutputTokens)
+	done := encodeMessageResponse(response, stricter(scope.moderation, outputAction))
+	if err := send(grpcwire.AppendBytes(nil, 2, done)); err != nil {
+		return grpcwire.Errorf(grpcwire.Unavailable, "%v", err)
+	}
+	return nil
+}
+
+// decodeMessageRequest decodes a manto.v1.MessageRequest.
+func decodeMessageRequest(msg []byte) (*services.MessageRequest, error) {
+	request := &services.MessageRequest{}
+	err := grpcwire.Parse(msg, func(f grpcwire.Field) error {
+		switch {
+		case f.Number == 1 && f.IsBytes():
+			request.Model = f.String()
+		case f.Number == 2 && f.IsBytes():
+			var m services.Message
+			err := grpcwire.Parse(f.Bytes, func(f grpcwire.Field) error {
+				switch {
+				case f.Number == 1 && f.IsBytes():
+					m.Role = f.String()
+				case f.Number == 2 && f.IsBytes():
+					m.Content = f.String()
+				}
+				return nil
+			})
+			request.Messages = append(request.Messages, m)
+			return err
+		case f.Number == 3 && f.IsVarint():
+			request.MaxTokens = int(int32(f.Varint))
+		case f.Number == 4 && f.IsFixed64():
+			t := f.Double()
+			request.Temperature = &t
+		case f.Number == 5 && f.IsFixed64():
+			p := f.Double()
+			request.TopP = &p
+		case f.Number == 6 && f.IsVarint():
+			k := int(int32(f.Varint))
+			request.TopK = &k
+		case f.Number == 7 && f.IsBytes():
+			request.StopSequences = append(request.StopSequences, f.String())
+		case f.Number == 8 && f.IsBytes():
+			s := f.String()
+			request.System = &s
+		}
+		return nil
+	})
+	return request, err
+}
+
+// encodeMessageResponse encodes a manto.v1.MessageResponse.
+func encodeMessageResponse(response *services.MessageResponse, moderationAction string) []byte {
+	var usage []byte
+	usage = grpcwire.AppendInt(usage, 1, int64(response.Usage.InputTokens))
+	usage = grpcwire.AppendInt(usage, 2, int64(response.Usage.OutputTokens))
+
+	var out []byte
+	out = grpcwire.AppendString(out, 1, response.ID)
+	out = grpcwire.AppendString(out, 2, response.Model)
+	out = grpcwire.AppendString(out, 3, response.Text())
+	out = grpcwire.AppendString(out, 4, response.StopReason)
+	out = grpcwire.AppendBytes(out, 5, usage)
+	return grpcwire.AppendString(out, 6, moderationAction)
+}
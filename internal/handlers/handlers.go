@@ -1,45 +1,233 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/analytics"
+	"github.com/manto/manto-web/internal/announcements"
 	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/conversations"
+	"github.com/manto/manto-web/internal/experiments"
+	"github.com/manto/manto-web/internal/i18n"
+	"github.com/manto/manto-web/internal/journal"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/middleware/ratelimit"
+	"github.com/manto/manto-web/internal/middleware/security"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/overrides"
+	"github.com/manto/manto-web/internal/parts"
+	"github.com/manto/manto-web/internal/queue"
+	"github.com/manto/manto-web/internal/replay"
 	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/session"
+	"github.com/manto/manto-web/internal/status"
+	"github.com/manto/manto-web/internal/tenants"
+	"github.com/manto/manto-web/internal/timing"
+	"github.com/manto/manto-web/internal/tokens"
+)
+
+const (
+	maxEventBodySize    = 1024
+	maxEstimateBodySize = 1 << 20
 )
 
 type APIHandlers struct {
-	config           *config.Config
-	anthropicService *services.AnthropicService
+	config        *config.Config
+	provider      services.Provider
+	providers     map[string]services.Provider
+	catalog       *i18n.Catalog
+	analytics     *analytics.Collector
+	queue         *queue.Queue
+	jobs          *queue.Jobs
+	tokens        *tokens.Estimator
+	tokenCounts   *tokens.Cache
+	modelsCache   *services.ModelsCache
+	stopSequences []string
+	moderation    *moderation.Pipeline
+	status        *status.Tracker
+	parts         *parts.Store
+	tenants       *tenants.Registry
+	overrides     *overrides.Store
+	announcements *announcements.Store
+	failures      *replay.Store
+	journal       *journal.Journal
+	slo           *metrics.SLO
+	costs         *ratelimit.CostLimiter
 }
 
-func NewAPIHandlers(cfg *config.Config, anthropicService *services.AnthropicService) *APIHandlers {
+func NewAPIHandlers(cfg *config.Config, provider services.Provider) *APIHandlers {
+	upstreamQueue := queue.New(cfg.Queue.Concurrency, cfg.Queue.MaxWaiting)
+	var uploads *parts.Store
+	if cfg.Parts.Enabled {
+		uploads = parts.NewStore(cfg.Parts.TTL.Duration, cfg.Validation.MaxMessageLength, cfg.Parts.MaxUploads)
+	}
 	return &APIHandlers{
-		config:           cfg,
-		anthropicService: anthropicService,
+		config:        cfg,
+		provider:      provider,
+		providers:     map[string]services.Provider{provider.Name(): provider},
+		catalog:       i18n.New(cfg.I18n.DefaultLocale),
+		analytics:     analytics.NewCollector(cfg.Analytics.Enabled, metrics.Default),
+		queue:         upstreamQueue,
+		jobs:          queue.NewJobs(upstreamQueue, cfg.Queue.ResultTTL.Duration),
+		tokens:        tokens.NewEstimator(cfg.Tokens.CharsPerToken),
+		tokenCounts:   tokens.NewCache(cfg.Tokens.CountCacheTTL.Duration, cfg.Tokens.CountCacheSize),
+		modelsCache:   services.NewModelsCache(cfg.Models.CacheTTL.Duration, metrics.Default),
+		stopSequences: cfg.Anthropic.StopSequenceList(),
+		moderation:    moderation.New(cfg.Moderation, cfg.PII, cfg.Secrets, &http.Client{Timeout: cfg.Anthropic.Timeout.Duration}, slog.Default()),
+		parts:         uploads,
+		costs:         ratelimit.NewCostLimiter(cfg.RateLimit),
 	}
 }
 
-func (h *APIHandlers) ConfigHandler(w http.ResponseWriter, r *http.Request) {
-	configData := map[string]interface{}{
-		"providers": []map[string]string{
-			{
-				"name":        "anthropic",
-				"displayName": "Anthropic",
-			},
-		},
+// WithStatus reports tracker's status to the UI in /config.js and
+// /api/config.
+func (h *APIHandlers) WithStatus(tracker *status.Tracker) *APIHandlers {
+	h.status = tracker
+	return h
+}
+
+// WithTenants tells the UI which tenant it serves, and under which path, in
+// /config.js and /api/config, and brands it for that tenant.
+func (h *APIHandlers) WithTenants(reg *tenants.Registry) *APIHandlers {
+	h.tenants = reg
+	return h
+}
+
+// WithOverrides applies store's runtime overrides of the system message,
+// model allow-list and feature flags.
+func (h *APIHandlers) WithOverrides(store *overrides.Store) *APIHandlers {
+	h.overrides = store
+	return h
+}
+
+// WithAnnouncements shows store's active announcements in /config.js and
+// /api/config, and at /api/announcements.
+func (h *APIHandlers) WithAnnouncements(store *announcements.Store) *APIHandlers {
+	h.announcements = store
+	return h
+}
+
+// WithReplay keeps the payloads of failed message requests in store, so they
+// can be replayed from the admin API.
+func (h *APIHandlers) WithReplay(store *replay.Store) *APIHandlers {
+	h.failures = store
+	return h
+}
+
+// recordFailure keeps a message request the provider failed for replay.
+func (h *APIHandlers) recordFailure(ctx context.Context, apiKey string, request *services.MessageRequest, scope requestScope, failure error) {
+	var tenantID string
+	if scope.tenant != nil {
+		tenantID = scope.tenant.ID
+	}
+	if err := h.failures.Record(request, apiKey, h.upstream(ctx).Fingerprint(apiKey), tenantID, failure); err != nil {
+		slog.Error("failed to record failed request", slog.String("error", err.Error()))
+	}
+}
+
+// systemMessage is the default system message for ctx's tenant: the runtime
+// override if there is one, or SYSTEM_MESSAGE.
+func (h *APIHandlers) systemMessage(ctx context.Context) string {
+	return h.overrides.Resolve(tenants.FromContext(ctx)).SystemMessage(h.config.Anthropic.SystemMessage)
+}
+
+func firstOrEmpty(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// normalizeHistory adapts the history to the request's model, reporting how
+// many content blocks were dropped in X-Manto-History-Normalized.
+func (h *APIHandlers) normalizeHistory(w http.ResponseWriter, request *services.MessageRequest) {
+	if dropped := request.NormalizeHistory(h.config.Anthropic.SupportsThinking(request.Model)); dropped > 0 {
+		w.Header().Set("X-Manto-History-Normalized", strconv.Itoa(dropped))
+	}
+}
+
+// localize returns the message for key in the locale negotiated for r.
+func (h *APIHandlers) localize(r *http.Request, key string, args ...interface{}) string {
+	return h.catalog.Message(h.catalog.Negotiate(r), key, args...)
+}
+
+// clientConfig is what the UI needs to know about this deployment, as seen
+// by tenant, with current, the service status, nil unless the service is
+// degraded.
+func (h *APIHandlers) clientConfig(tenant *tenants.Tenant, current *status.Status) map[string]interface{} {
+	var tenantView map[string]interface{}
+	var tenantID string
+	if tenant != nil {
+		tenantID = tenant.ID
+		tenantView = map[string]interface{}{
+			"id":       tenant.ID,
+			"basePath": h.tenants.BasePath(tenant),
+		}
+	}
+	brand := tenant.Brand(h.config.Branding)
+	overridden := h.overrides.Resolve(tenant)
+	return map[string]interface{}{
+		"providers": h.clientProviders(),
 		"api": map[string]interface{}{
-			"anthropicKeyPrefix": h.config.Anthropic.KeyPrefix,
+			"anthropicKeyPrefix":   firstOrEmpty(h.config.Anthropic.KeyPrefixes()),
+			"anthropicKeyPrefixes": h.config.Anthropic.KeyPrefixes(),
+			"anthropicKeyPattern":  h.config.Anthropic.KeyPattern,
+			"keyHeader":            h.config.Anthropic.ClientKeyHeader,
 		},
 		"validation": map[string]interface{}{
 			"maxMessageLength": h.config.Validation.MaxMessageLength,
 			"minApiKeyLength":  h.config.Security.APIKeyMinLength,
 		},
-		"version": "2.0.0",
+		"i18n": map[string]interface{}{
+			"defaultLocale":    h.catalog.DefaultLocale(),
+			"supportedLocales": h.catalog.Supported(),
+		},
+		"analytics": map[string]interface{}{
+			"enabled": h.analytics.Enabled(),
+		},
+		"conversations": map[string]interface{}{
+			"enabled":  h.config.Conversations.Enabled && overridden.Enabled("conversations"),
+			"archived": h.config.Archive.Enabled,
+		},
+		"access": map[string]interface{}{
+			"required": len(h.config.Access.Codes) > 0,
+		},
+		"parts": map[string]interface{}{
+			"enabled":     h.parts != nil,
+			"maxPartSize": h.config.Validation.MaxFileSize,
+		},
+		"speech": map[string]interface{}{
+			"transcribe": h.config.Speech.STTProvider != "" && overridden.Enabled("speech"),
+			"tts":        h.config.Speech.TTSProvider != "" && overridden.Enabled("speech"),
+		},
+		"tenant": tenantView,
+		"branding": map[string]interface{}{
+			"name":        brand.Name,
+			"description": brand.Description,
+			"themeColor":  brand.ThemeColor,
+		},
+		"announcements": h.announcements.Active(time.Now(), tenantID),
+		"status":        current,
+		"version":       "2.0.0",
 	}
+}
 
-	jsonData, err := json.Marshal(configData)
+// ConfigHandler serves the client config as a script. It is cached for five
+// minutes while the service is healthy, but not while a status is shown, so
+// the banner goes away as soon as the service recovers.
+func (h *APIHandlers) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	current := h.status.Current()
+	jsonData, err := json.Marshal(h.clientConfig(tenants.FromContext(r.Context()), current))
 	if err != nil {
 		http.Error(w, "Failed to generate config", http.StatusInternalServerError)
 		return
@@ -48,71 +236,722 @@ func (h *APIHandlers) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	configScript := fmt.Sprintf("window.MantoConfig = %s;", string(jsonData))
 
 	w.Header().Set("Content-Type", "application/javascript")
-	w.Header().Set("Cache-Control", "public, max-age=300") // 5 minutes
+	if current != nil {
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300") // 5 minutes
+	}
 	w.Write([]byte(configScript))
 }
 
+// ClientConfigHandler serves the client config as JSON, uncached, for the UI
+// to poll for status changes.
+func (h *APIHandlers) ClientConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, h.clientConfig(tenants.FromContext(r.Context()), h.status.Current()))
+}
+
+// AnnouncementsHandler lists the announcements shown now, for clients that
+// don't load /config.js.
+func (h *APIHandlers) AnnouncementsHandler(w http.ResponseWriter, r *http.Request) {
+	var tenantID string
+	if tenant := tenants.FromContext(r.Context()); tenant != nil {
+		tenantID = tenant.ID
+	}
+	security.AllowCaching(w, "public, max-age=60")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"announcements": h.announcements.Active(time.Now(), tenantID),
+	})
+}
+
+func (h *APIHandlers) ManifestHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := tenants.FromContext(r.Context())
+	brand := tenant.Brand(h.config.Branding)
+	home := h.tenants.BasePath(tenant) + "/"
+	manifest := map[string]interface{}{
+		"name":             brand.Name,
+		"short_name":       brand.ShortName,
+		"description":      brand.Description,
+		"start_url":        home,
+		"scope":            home,
+		"display":          "standalone",
+		"theme_color":      brand.ThemeColor,
+		"background_color": brand.BackgroundColor,
+		"icons": []map[string]string{
+			{
+				"src":     home + "logo.svg",
+				"sizes":   "any",
+				"type":    "image/svg+xml",
+				"purpose": "any",
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(manifest)
+	if err != nil {
+		http.Error(w, "Failed to generate manifest", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "public, max-age=300") // 5 minutes
+	w.Write(jsonData)
+}
+
+// I18nHandler serves the UI message bundle for the {locale} URL parameter, or
+// the negotiated locale when the parameter is absent.
+func (h *APIHandlers) I18nHandler(w http.ResponseWriter, r *http.Request) {
+	locale := chi.URLParam(r, "locale")
+	if locale == "" {
+		locale = h.catalog.Negotiate(r)
+	}
+
+	messages, ok := h.catalog.Bundle(locale)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.unsupportedLocale", locale), "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", locale)
+	w.Header().Set("Vary", "Accept-Language")
+	security.AllowCaching(w, "public, max-age=300") // 5 minutes
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locale":   locale,
+		"messages": messages,
+	})
+}
+
+// EventsHandler records a single anonymous UI event from the allow-list.
+func (h *APIHandlers) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	var event struct {
+		Event string `json:"event"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxEventBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+
+	if err := h.analytics.Record(event.Event); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.unknownEvent", event.Event), "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *APIHandlers) ModelsHandler(w http.ResponseWriter, r *http.Request) {
-	apiKey := r.Header.Get("x-api-key")
-	if !h.anthropicService.ValidateAPIKey(apiKey) {
-		writeJSONError(w, http.StatusBadRequest, "Invalid API key format", "")
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
 		return
 	}
 
-	modelsData, err := h.anthropicService.GetModels(apiKey)
+	modelsData, err := h.modelsCache.GetModels(r.Context(), h.upstream(r.Context()), apiKey)
+	if errors.Is(err, context.Canceled) {
+		// The client went away while waiting on a shared fetch.
+		return
+	}
 	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, err.Error(), "")
+		writeUpstreamError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(modelsData))
+	h.writeModels(w, r, modelsData)
 }
 
 func (h *APIHandlers) MessagesHandler(w http.ResponseWriter, r *http.Request) {
-	apiKey := r.Header.Get("x-api-key")
-	if !h.anthropicService.ValidateAPIKey(apiKey) {
-		writeJSONError(w, http.StatusBadRequest, "Invalid API key format", "")
+	apiKey, messageRequest, scope, ok := h.prepareMessage(w, r)
+	if !ok {
+		return
+	}
+	if messageRequest.Stream {
+		h.streamMessage(w, r, apiKey, messageRequest, scope)
 		return
 	}
+	timings := timing.FromContext(r.Context())
+
+	if !h.queue.TryAcquire() {
+		h.enqueueMessage(w, r, apiKey, messageRequest, scope)
+		return
+	}
+
+	upstreamStart := time.Now()
+	result := h.sendMessage(r.Context(), apiKey, messageRequest, scope)
+	h.queue.Release(time.Since(upstreamStart))
+	timings.Since("upstream_total", upstreamStart)
+
+	writeResult(w, result)
+}
+
+// prepareMessage reads a message request and checks it with checkMessage.
+// It writes the error response itself and reports false when the request
+// cannot go ahead.
+func (h *APIHandlers) prepareMessage(w http.ResponseWriter, r *http.Request) (string, *services.MessageRequest, requestScope, bool) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return "", nil, requestScope{}, false
+	}
 
 	var messageRequest services.MessageRequest
 	if err := json.NewDecoder(r.Body).Decode(&messageRequest); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Invalid JSON format", "")
-		return
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return "", nil, requestScope{}, false
+	}
+
+	scope, ok := h.checkMessage(w, r, apiKey, &messageRequest)
+	if !ok {
+		return "", nil, requestScope{}, false
 	}
+	return apiKey, &messageRequest, scope, true
+}
+
+// checkMessage validates a decoded message request, then applies the
+// experiment variant, history normalization, defaults, caps, input
+// moderation and the token budget. Informational headers (clamping,
+// trimming, normalization) go on w, and so does the error response when it
+// reports false.
+func (h *APIHandlers) checkMessage(w http.ResponseWriter, r *http.Request, apiKey string, messageRequest *services.MessageRequest) (requestScope, bool) {
+	timings := timing.FromContext(r.Context())
+	validationStart := time.Now()
 
 	if messageRequest.Model == "" {
-		writeJSONError(w, http.StatusBadRequest, "Model is required", "")
-		return
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.modelRequired"), "")
+		return requestScope{}, false
 	}
 
 	if len(messageRequest.Messages) == 0 {
-		writeJSONError(w, http.StatusBadRequest, "Messages are required", "")
-		return
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messagesRequired"), "")
+		return requestScope{}, false
+	}
+
+	if !h.resolveUploads(w, r, apiKey, messageRequest) {
+		return requestScope{}, false
 	}
 
 	maxLength := h.config.Validation.MaxMessageLength
 	for _, msg := range messageRequest.Messages {
 		if len(msg.Content) > maxLength {
-			writeJSONError(w, http.StatusBadRequest,
-				fmt.Sprintf("Message too long (max %d characters)", maxLength), "")
-			return
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messageTooLong", maxLength), "")
+			return requestScope{}, false
+		}
+	}
+
+	if messageRequest.MaxTokens < 0 {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidMaxTokens"), "")
+		return requestScope{}, false
+	}
+
+	if t := messageRequest.Temperature; t != nil && (*t < 0 || *t > 1) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidTemperature"), "")
+		return requestScope{}, false
+	}
+
+	if p := messageRequest.TopP; p != nil && (*p < 0 || *p > 1) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidTopP"), "")
+		return requestScope{}, false
+	}
+
+	if k := messageRequest.TopK; k != nil && *k < 0 {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidTopK"), "")
+		return requestScope{}, false
+	}
+
+	if version := r.Header.Get("Anthropic-Version"); version != "" {
+		if !h.config.Anthropic.AllowsAPIVersion(version) {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.apiVersionNotAllowed", version), "")
+			return requestScope{}, false
 		}
+		messageRequest.APIVersion = version
 	}
 
-	messageRequest.MaxTokens = h.config.Anthropic.MaxTokens
-	messageRequest.Temperature = &h.config.Anthropic.Temperature
-	messageRequest.System = &h.config.Anthropic.SystemMessage
+	var structured *structuredOutput
+	if format := messageRequest.ResponseFormat; format != nil {
+		var err error
+		if structured, err = newStructuredOutput(format); err != nil {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidResponseFormat", err.Error()), "")
+			return requestScope{}, false
+		}
+		messageRequest.ResponseFormat = nil
+	}
 
-	response, err := h.anthropicService.SendMessage(apiKey, &messageRequest)
+	scope := h.scope(r)
+	scope.structured = structured
+	scope.variant.Apply(messageRequest)
+	overridden := h.overrides.Resolve(scope.tenant)
+	if !scope.tenant.Allows(messageRequest.Model) || !overridden.Allows(messageRequest.Model) {
+		writeJSONError(w, http.StatusForbidden, h.localize(r, "errors.modelNotAllowed"), "")
+		return requestScope{}, false
+	}
+	h.normalizeHistory(w, messageRequest)
+	clientMaxTokens := messageRequest.MaxTokens > 0
+	overridden.Apply(messageRequest)
+	scope.tenant.Apply(messageRequest)
+	messageRequest.ApplyDefaults(h.config.Anthropic.MaxTokens, h.config.Anthropic.Temperature, overridden.SystemMessage(h.config.Anthropic.SystemMessage))
+	messageRequest.MergeStopSequences(h.stopSequences)
+	if !h.capMaxTokens(w, r, messageRequest, clientMaxTokens) {
+		return requestScope{}, false
+	}
+	action, err := h.moderateInput(h.moderationContext(r), apiKey, messageRequest)
 	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, err.Error(), "")
+		h.writeModerationError(w, r, err)
+		return requestScope{}, false
+	}
+	scope.moderation = action
+	h.hintHistory(w, messageRequest)
+	if !h.fitBudget(w, r, messageRequest) {
+		return requestScope{}, false
+	}
+	if err := scope.tenant.Admit(time.Now()); err != nil {
+		writeJSONError(w, http.StatusTooManyRequests, h.localize(r, "errors.tenantQuotaExceeded"), "")
+		return requestScope{}, false
+	}
+	if !h.chargeTokens(w, r, messageRequest.MaxTokens) {
+		return requestScope{}, false
+	}
+	timings.Since("validation", validationStart)
+	return scope, true
+}
+
+// chargeTokens takes a request's maxTokens from the caller's token bucket
+// (RATE_LIMIT_TOKENS), reporting what is left in X-RateLimit-Tokens-Limit and
+// X-RateLimit-Tokens-Remaining. It writes the 429 itself when the bucket is
+// short.
+func (h *APIHandlers) chargeTokens(w http.ResponseWriter, r *http.Request, maxTokens int) bool {
+	result := h.costs.Take(r, maxTokens)
+	if result.Limit == 0 {
+		return true
+	}
+	w.Header().Set("X-RateLimit-Tokens-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Tokens-Remaining", strconv.Itoa(result.Remaining))
+	if !result.Allowed {
+		retryAfter := int(math.Ceil(time.Until(result.Reset).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeJSONError(w, http.StatusTooManyRequests, h.localize(r, "errors.tokenRateLimitExceeded", retryAfter), "")
+		return false
+	}
+	return true
+}
+
+// capMaxTokens enforces ANTHROPIC_MODEL_MAX_TOKENS. A max_tokens over the
+// model's cap is clamped, and X-Manto-Max-Tokens-Clamped reports the value
+// used; with the reject policy a client-supplied value over the cap is refused
+// instead. Server defaults are always clamped. It writes the error response
+// itself when rejecting.
+func (h *APIHandlers) capMaxTokens(w http.ResponseWriter, r *http.Request, request *services.MessageRequest, clientSet bool) bool {
+	limit := h.config.Anthropic.ModelMaxTokens.For(request.Model)
+	if limit == 0 || request.MaxTokens <= limit {
+		return true
+	}
+	if clientSet && h.config.Anthropic.MaxTokensPolicy == "reject" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.maxTokensExceeded", limit, request.Model), "")
+		return false
+	}
+	request.MaxTokens = limit
+	w.Header().Set("X-Manto-Max-Tokens-Clamped", strconv.Itoa(limit))
+	return true
+}
+
+// hintHistory advises clients whose history is growing large to compact it
+// before hard limits hit. Once the local estimate of the submitted request
+// reaches TOKEN_HISTORY_HINT, or four fifths of TOKEN_MAX_INPUT if that is
+// lower, X-Manto-History-Tokens carries the estimate and X-Manto-History-Hint
+// says what to do: "truncate" to drop or summarize older turns, or
+// "server-trim" when TOKEN_TRIM_CONTEXT will drop them here anyway.
+// X-Manto-History-Budget carries TOKEN_MAX_INPUT when it is set.
+func (h *APIHandlers) hintHistory(w http.ResponseWriter, request *services.MessageRequest) {
+	threshold := h.config.Tokens.HistoryHint
+	if threshold <= 0 {
 		return
 	}
+	budget := h.config.Tokens.MaxInputTokens
+	if budget > 0 {
+		threshold = min(threshold, budget*4/5)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	system := ""
+	if request.System != nil {
+		system = *request.System
+	}
+	estimate := h.tokens.CountRequest(system, request.Messages)
+	if estimate < threshold {
+		return
+	}
+	w.Header().Set("X-Manto-History-Tokens", strconv.Itoa(estimate))
+	if budget > 0 {
+		w.Header().Set("X-Manto-History-Budget", strconv.Itoa(budget))
+	}
+	if budget > 0 && h.config.Tokens.TrimContext {
+		w.Header().Set("X-Manto-History-Hint", "server-trim")
+	} else {
+		w.Header().Set("X-Manto-History-Hint", "truncate")
+	}
+}
+
+// fitBudget enforces TOKEN_MAX_INPUT using the local estimate, trimming the
+// oldest messages when TOKEN_TRIM_CONTEXT is set. It writes the error
+// response itself when the request cannot fit.
+func (h *APIHandlers) fitBudget(w http.ResponseWriter, r *http.Request, request *services.MessageRequest) bool {
+	budget := h.config.Tokens.MaxInputTokens
+	if budget <= 0 {
+		return true
+	}
+
+	system := ""
+	if request.System != nil {
+		system = *request.System
+	}
+
+	if h.config.Tokens.TrimContext {
+		kept, ok := h.tokens.Trim(system, request.Messages, budget)
+		if dropped := len(request.Messages) - len(kept); ok && dropped > 0 {
+			w.Header().Set("X-Manto-Context-Trimmed", strconv.Itoa(dropped))
+			request.Messages = kept
+		}
+		if ok {
+			return true
+		}
+	}
+
+	if estimate := h.tokens.CountRequest(system, request.Messages); estimate > budget {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contextTooLong", estimate, budget), "")
+		return false
+	}
+	return true
+}
+
+// EstimateHandler returns a local token estimate for a prospective request
+// (system and messages) or a bare text, so the UI can show counts while the
+// user types without calling the provider. When a model and API key are given,
+// the messages before the draft are counted exactly by the provider; that
+// count is cached, so keystrokes in the draft don't each cost a round trip.
+func (h *APIHandlers) EstimateHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text     string             `json:"text"`
+		Model    string             `json:"model"`
+		System   string             `json:"system"`
+		Messages []services.Message `json:"messages"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxEstimateBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+
+	var estimate int
+	method := tokens.Method
+	if body.Messages != nil || body.System != "" {
+		if count, ok := h.countHistory(r, body.Model, body.System, body.Messages); ok {
+			estimate = count + h.tokens.CountMessage(body.Messages[len(body.Messages)-1])
+			method = tokens.MethodHybrid
+		} else {
+			estimate = h.tokens.CountRequest(body.System, body.Messages)
+		}
+	} else {
+		estimate = h.tokens.Count(body.Text)
+	}
+
+	resp := map[string]interface{}{
+		"inputTokens": estimate,
+		"method":      method,
+	}
+	if budget := h.config.Tokens.MaxInputTokens; budget > 0 {
+		resp["maxInputTokens"] = budget
+		resp["withinBudget"] = estimate <= budget
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// countHistory returns the provider's exact token count for every message but
+// the last, which is the draft still being typed. Counts are cached by a hash
+// of the model, system prompt and messages. It reports false when there is no
+// history, no model or valid key, the provider can't count tokens, or the
+// provider call fails, in which case the caller falls back to the local
+// estimate.
+func (h *APIHandlers) countHistory(r *http.Request, model, system string, messages []services.Message) (int, bool) {
+	provider := h.upstream(r.Context())
+	counter, ok := provider.(services.TokenCounter)
+	if !ok {
+		return 0, false
+	}
+	apiKey := provider.ClientAPIKey(r)
+	if model == "" || len(messages) < 2 || !provider.ValidateAPIKey(apiKey) {
+		return 0, false
+	}
+
+	history := messages[:len(messages)-1]
+	key := tokens.Key(model, system, history)
+	if count, ok := h.tokenCounts.Get(key); ok {
+		return count, true
+	}
+
+	request := &services.CountTokensRequest{Model: model, Messages: history}
+	if system != "" {
+		request.System = &system
+	}
+	count, err := counter.CountTokens(r.Context(), apiKey, request)
+	if err != nil {
+		return 0, false
+	}
+	h.tokenCounts.Set(key, count)
+	return count, true
+}
+
+// requestScope carries what sendMessage needs from the originating request,
+// which may have returned by the time a queued request is sent.
+type requestScope struct {
+	session   *session.Session
+	variant   *experiments.Assignment
+	tenant    *tenants.Tenant
+	locale    string
+	requestID string
+	// moderation is the action the input stage took, if any.
+	moderation string
+	// structured is the response_format the answer must satisfy, if any.
+	structured *structuredOutput
+}
+
+func (h *APIHandlers) scope(r *http.Request) requestScope {
+	return requestScope{
+		session: session.FromContext(r.Context()),
+		variant: experiments.FromContext(r.Context()),
+		tenant:  tenants.FromContext(r.Context()),
+		locale:  h.catalog.Negotiate(r),
+		// Queued requests are sent after r has returned, so the ID is
+		// kept for the journal.
+		requestID: middleware.GetReqID(r.Context()),
+	}
+}
+
+// sendMessage calls the upstream API and captures the response, so the same
+// code serves requests answered inline and those finished in the background
+// after queueing.
+func (h *APIHandlers) sendMessage(ctx context.Context, apiKey string, request *services.MessageRequest, scope requestScope) queue.Result {
+	keyFingerprint := h.upstream(ctx).Fingerprint(apiKey)
+	started := time.Now()
+	send := func(request *services.MessageRequest, _ bool) (*services.MessageResponse, error) {
+		return h.upstream(ctx).SendMessage(ctx, apiKey, request)
+	}
+	var response *services.MessageResponse
+	var err error
+	if scope.structured != nil {
+		response, err = h.structuredAnswer(request, scope.structured, send)
+	} else if response, err = send(request, false); err == nil {
+		response, err = h.continueTruncated(request, response, send)
+	}
+	var invalid *schemaError
+	if errors.As(err, &invalid) {
+		h.journalRequest(ctx, scope, apiKey, request.Model, nil, err)
+		h.observeSLO(ctx, request.Model, started, err)
+		scope.session.RecordUsage(keyFingerprint, invalid.Usage.InputTokens, invalid.Usage.OutputTokens)
+		scope.tenant.RecordUsage(invalid.Usage.InputTokens, invalid.Usage.OutputTokens)
+		return jsonResult(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":            h.catalog.Message(scope.locale, "errors.schemaValidationFailed", invalid.Attempts),
+			"type":             "schema_validation_failed",
+			"attempts":         invalid.Attempts,
+			"validationErrors": invalid.Errors,
+		})
+	}
+	if err != nil {
+		slog.Warn("upstream message request failed",
+			slog.String("key", keyFingerprint),
+			slog.String("model", request.Model),
+			slog.String("error", err.Error()))
+		h.journalRequest(ctx, scope, apiKey, request.Model, nil, err)
+		h.observeSLO(ctx, request.Model, started, err)
+		h.recordFailure(ctx, apiKey, request, scope, err)
+		return upstreamResult(err)
+	}
+
+	scope.session.RecordUsage(keyFingerprint, response.Usage.InputTokens, response.Usage.OutputTokens)
+	scope.tenant.RecordUsage(response.Usage.InputTokens, response.Usage.OutputTokens)
+	h.observeSLO(ctx, request.Model, started, nil)
+	outputAction, err := h.moderateOutput(ctx, apiKey, response)
+	h.journalRequest(ctx, scope, apiKey, request.Model, response, err)
+	if err != nil {
+		return jsonResult(http.StatusUnprocessableEntity, map[string]string{"error": h.catalog.Message(scope.locale, moderationMessageKey(err))})
+	}
+
+	result := jsonResult(http.StatusOK, response)
+	setUsageHeaders(result.Header, response.Usage)
+	if action := stricter(scope.moderation, outputAction); action != "" {
+		result.Header.Set(moderationHeader, action)
+	}
+	scope.variant.RecordResponse(result.Header)
+	return result
+}
+
+func jsonResult(status int, v interface{}) queue.Result {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body = []byte(`{"error":"Failed to encode response"}`)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	return queue.Result{Status: status, Header: header, Body: append(body, '\n')}
+}
+
+// upstreamResult answers with a provider's failure: the status and code of
+// its *services.APIError, and when to retry if the upstream said.
+func upstreamResult(err error) queue.Result {
+	apiErr := services.AsAPIError(err)
+	body := map[string]interface{}{"error": apiErr.Message, "code": apiErr.Code}
+	if apiErr.RetryAfter > 0 {
+		body["retry_after"] = apiErr.RetryAfter
+	}
+	result := jsonResult(apiErr.HTTPStatus, body)
+	if apiErr.RetryAfter > 0 {
+		result.Header.Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+	return result
+}
+
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	writeResult(w, upstreamResult(err))
+}
+
+func writeResult(w http.ResponseWriter, result queue.Result) {
+	for name, values := range result.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(result.Status)
+	w.Write(result.Body)
+}
+
+// enqueueMessage parks a request that found every upstream slot busy. The
+// request keeps running in the background and the client gets 202 with a URL
+// to poll for its queue position and, eventually, the response.
+func (h *APIHandlers) enqueueMessage(w http.ResponseWriter, r *http.Request, apiKey string, request *services.MessageRequest, scope requestScope) {
+	lane := h.queueLane(r, apiKey)
+	waiter, err := h.queue.EnqueueFor(lane.name, lane.weight)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		writeJSONError(w, http.StatusServiceUnavailable, h.localize(r, "errors.queueFull"), "")
+		return
+	}
+
+	provider := h.upstream(r.Context())
+	job := h.jobs.Submit(conversations.OwnerFromAPIKey(apiKey), waiter, func(ctx context.Context) queue.Result {
+		return h.sendMessage(withProvider(ctx, provider), apiKey, request, scope)
+	})
+	h.writeQueued(w, job)
+}
+
+// clientLane is the lane a client's requests wait in for an upstream slot,
+// and how many of them are served per turn of the round robin.
+type clientLane struct {
+	name   string
+	weight int
+}
+
+// queueLane is the lane r waits in when upstream slots are busy: its
+// tenant's and session's, or its key's for clients without a session, so
+// clients take turns instead of one starving the rest. It is weighted by the
+// tenant's queue weight.
+func (h *APIHandlers) queueLane(r *http.Request, apiKey string) clientLane {
+	tenant := tenants.FromContext(r.Context())
+	client := "key:" + h.upstream(r.Context()).Fingerprint(apiKey)
+	if s := session.FromContext(r.Context()); s != nil && !s.New {
+		client = "session:" + s.ID
+	}
+	return clientLane{name: tenant.Owner(client), weight: tenant.Weight()}
+}
+
+// errQueueFull is the 503 a request gets when the line for upstream slots
+// is full.
+var errQueueFull = &services.APIError{Code: services.CodeOverloaded, HTTPStatus: http.StatusServiceUnavailable, Message: "queue is full", RetryAfter: 5, Err: queue.ErrFull}
+
+// acquireSlot waits in lane for an upstream slot, for requests answered in
+// one piece that have no way to report their place in line. The caller
+// must call release once the upstream has answered.
+func (h *APIHandlers) acquireSlot(ctx context.Context, lane clientLane) (release func(), err error) {
+	waiter, err := h.queue.EnqueueFor(lane.name, lane.weight)
+	if err != nil {
+		return nil, errQueueFull
+	}
+	select {
+	case <-waiter.Ready():
+	case <-ctx.Done():
+		h.queue.Cancel(waiter)
+		return nil, ctx.Err()
+	}
+	start := time.Now()
+	return func() { h.queue.Release(time.Since(start)) }, nil
+}
+
+func (h *APIHandlers) writeQueued(w http.ResponseWriter, job *queue.Job) {
+	position := h.jobs.Position(job)
+	wait := h.queue.EstimatedWait(position)
+	pollURL := "/api/queue/" + job.ID
+
+	retryAfter := int(math.Ceil(wait.Seconds() / 2))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	if retryAfter > 5 {
+		retryAfter = 5
+	}
+
+	status := "queued"
+	if position == 0 {
+		status = "running"
+	}
+
+	w.Header().Set("Location", pollURL)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"id":                   job.ID,
+		"status":               status,
+		"position":             position,
+		"estimatedWaitSeconds": int(math.Ceil(wait.Seconds())),
+		"pollUrl":              pollURL,
+	})
+}
+
+// QueueHandler reports a queued request's position, or returns its final
+// response once it has finished. A result can be collected only once.
+func (h *APIHandlers) QueueHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return
+	}
+
+	job, ok := h.jobs.Get(conversations.OwnerFromAPIKey(apiKey), chi.URLParam(r, "id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.queuedRequestNotFound"), "")
+		return
+	}
+
+	if !job.Done() {
+		h.writeQueued(w, job)
+		return
+	}
+
+	h.jobs.Remove(job.ID)
+	writeResult(w, job.Result())
+}
+
+// StartCleanup periodically drops abandoned queued requests, uncollected
+// results and expired uploads until stop is closed.
+func (h *APIHandlers) StartCleanup(stop <-chan struct{}) {
+	h.jobs.StartCleanup(stop)
+	h.costs.StartCleanup(stop)
+	if h.parts != nil {
+		h.parts.StartCleanup(stop)
+	}
+}
+
+// setUsageHeaders echoes token usage so proxies and load tests can track
+// consumption without parsing the body.
+func setUsageHeaders(header http.Header, usage services.UsageInfo) {
+	header.Set("X-Manto-Usage-Input-Tokens", strconv.Itoa(usage.InputTokens))
+	header.Set("X-Manto-Usage-Output-Tokens", strconv.Itoa(usage.OutputTokens))
 }
 
 func writeJSONError(w http.ResponseWriter, statusCode int, message string, details string) {
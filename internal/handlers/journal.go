@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manto/manto-web/internal/journal"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// WithJournal records every message request in j for billing
+// reconciliation.
+func (h *APIHandlers) WithJournal(j *journal.Journal) *APIHandlers {
+	h.journal = j
+	return h
+}
+
+// journalRequest records a finished message request for model. response is
+// the provider's answer, if there was one; tokens a failed request still
+// used are taken from err.
+func (h *APIHandlers) journalRequest(ctx context.Context, scope requestScope, apiKey, model string, response *services.MessageResponse, err error) {
+	if h.journal == nil {
+		return
+	}
+	provider := h.upstream(ctx)
+	entry := journal.Entry{
+		Time:           time.Now().UTC(),
+		RequestID:      scope.requestID,
+		KeyFingerprint: provider.Fingerprint(apiKey),
+		Provider:       provider.Name(),
+		Model:          model,
+		Status:         journal.StatusOK,
+	}
+	if scope.tenant != nil {
+		entry.Tenant = scope.tenant.ID
+	}
+
+	var usage services.UsageInfo
+	var incomplete *services.IncompleteError
+	var invalid *schemaError
+	switch {
+	case err == nil:
+		if response.Incomplete != nil {
+			entry.Status = journal.StatusIncomplete
+		}
+	case errors.As(err, &incomplete):
+		entry.Status = journal.StatusIncomplete
+		if response == nil {
+			response = incomplete.Partial
+		}
+	case errors.As(err, &invalid):
+		entry.Status = journal.StatusInvalid
+		usage = invalid.Usage
+	case errors.Is(err, moderation.ErrBlocked):
+		entry.Status = journal.StatusBlocked
+	default:
+		entry.Status = journal.StatusError
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if response != nil {
+		usage = response.Usage
+		if response.Model != "" {
+			entry.Model = response.Model
+		}
+	}
+
+	entry.InputTokens, entry.OutputTokens = usage.InputTokens, usage.OutputTokens
+	if usage.InputTokens > 0 || usage.OutputTokens > 0 {
+		if cost, ok := h.config.Anthropic.EstimateCost(entry.Model, usage.InputTokens, usage.OutputTokens); ok {
+			entry.CostUSD = &cost
+		}
+	}
+	h.journal.Record(entry)
+}
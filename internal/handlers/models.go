@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// modelFilter narrows and orders the provider's model list. The Models API
+// only reports an ID, display name and release date, so those are what can
+// be filtered and sorted on.
+type modelFilter struct {
+	Family string // ID prefix, e.g. "claude-3-5"
+	Query  string // case-insensitive substring of the ID or display name
+	Sort   string // "newest" or "oldest" (by creation date), or "name"
+}
+
+type modelEntry struct {
+	raw         json.RawMessage
+	ID          string    `json:"id"`
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func parseModelFilter(query url.Values) (modelFilter, string) {
+	f := modelFilter{
+		Family: query.Get("family"),
+		Query:  strings.ToLower(query.Get("q")),
+		Sort:   query.Get("sort"),
+	}
+	switch f.Sort {
+	case "", "newest", "oldest", "name":
+	default:
+		return f, "sort"
+	}
+	return f, ""
+}
+
+func (f modelFilter) empty() bool {
+	return f == modelFilter{}
+}
+
+// apply filters the data array of a Models API response. Each entry is passed
+// through unchanged, so fields Manto doesn't know about still reach the UI.
+func (f modelFilter) apply(modelsData string) ([]byte, error) {
+	var list struct {
+		Data    []json.RawMessage `json:"data"`
+		HasMore bool              `json:"has_more"`
+	}
+	if err := json.Unmarshal([]byte(modelsData), &list); err != nil {
+		return nil, err
+	}
+
+	entries := make([]modelEntry, 0, len(list.Data))
+	for _, raw := range list.Data {
+		var entry modelEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, err
+		}
+		entry.raw = raw
+		if f.Family != "" && !strings.HasPrefix(entry.ID, f.Family) {
+			continue
+		}
+		if f.Query != "" && !strings.Contains(strings.ToLower(entry.ID), f.Query) && !strings.Contains(strings.ToLower(entry.DisplayName), f.Query) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	switch f.Sort {
+	case "newest":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].CreatedAt.After(entries[j].CreatedAt) })
+	case "oldest":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+	case "name":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].DisplayName < entries[j].DisplayName })
+	}
+
+	data := make([]json.RawMessage, len(entries))
+	for i, entry := range entries {
+		data[i] = entry.raw
+	}
+	return json.Marshal(map[string]interface{}{"data": data, "has_more": list.HasMore})
+}
+
+func (h *APIHandlers) writeModels(w http.ResponseWriter, r *http.Request, modelsData string) {
+	filter, invalid := parseModelFilter(r.URL.Query())
+	if invalid != "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidQueryParameter", invalid), "")
+		return
+	}
+
+	body := []byte(modelsData)
+	if !filter.empty() {
+		filtered, err := filter.apply(modelsData)
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, h.localize(r, "errors.invalidUpstreamResponse"), "")
+			return
+		}
+		body = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
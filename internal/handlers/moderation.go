@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/manto/manto-web/internal/dlp"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/session"
+)
+
+// errResponseBlocked distinguishes a blocked answer from a blocked prompt.
+var errResponseBlocked = fmt.Errorf("answer %w", moderation.ErrBlocked)
+
+const (
+	// moderationHeader reports the strictest non-blocking action moderation
+	// took on a request, "flag" or "redact".
+	moderationHeader = "X-Manto-Moderation"
+
+	// allowSecretsHeader asks for secret findings to be let through, where
+	// the deployment allows it.
+	allowSecretsHeader = "X-Manto-Allow-Secrets"
+)
+
+func stricter(a, b string) string {
+	if b == moderation.ActionRedact || a == "" {
+		return b
+	}
+	return a
+}
+
+// WithAuditLog records every moderation action in log for compliance export.
+func (h *APIHandlers) WithAuditLog(log *dlp.Log) *APIHandlers {
+	if log != nil {
+		h.moderation.WithRecorder(log)
+	}
+	return h
+}
+
+// moderationContext returns the context to moderate r's prompts in, honouring
+// a secret scan override when the deployment allows one.
+func (h *APIHandlers) moderationContext(r *http.Request) context.Context {
+	if h.config.Secrets.AllowOverride && r.Header.Get(allowSecretsHeader) == "true" {
+		return moderation.AllowSecrets(r.Context())
+	}
+	return r.Context()
+}
+
+// auditAttrs attributes a moderation action to the key fingerprint, the
+// session when there is one, and the model.
+func (h *APIHandlers) auditAttrs(ctx context.Context, apiKey, model string) []slog.Attr {
+	attrs := []slog.Attr{slog.String("key", h.upstream(ctx).Fingerprint(apiKey))}
+	if s := session.FromContext(ctx); s != nil {
+		attrs = append(attrs, slog.String("session", s.ID))
+	}
+	return append(attrs, slog.String("model", model))
+}
+
+// moderateInput runs the input stage over the request's user messages,
+// redacting them in place. Local checks see every user message, since clients
+// resend history as they hold it; remote checks only see the newest. It
+// returns the action taken, or moderation.ErrBlocked.
+func (h *APIHandlers) moderateInput(ctx context.Context, apiKey string, request *services.MessageRequest) (string, error) {
+	if !h.moderation.Enabled(moderation.StageInput) {
+		return "", nil
+	}
+
+	var action string
+	for i := range request.Messages {
+		m := &request.Messages[i]
+		if m.Role != "user" {
+			continue
+		}
+		verdict := h.moderation.Check(ctx, moderation.StageInput, m.Content, i == len(request.Messages)-1)
+		h.moderation.Audit(moderation.StageInput, verdict, h.auditAttrs(ctx, apiKey, request.Model)...)
+		if verdict.Action == moderation.ActionBlock {
+			return "", moderation.ErrBlocked
+		}
+		m.Content = verdict.Text
+		action = stricter(action, verdict.Action)
+	}
+	return action, nil
+}
+
+// moderateOutput runs the output stage over the response's text blocks,
+// redacting them in place. It returns the action taken, or
+// errResponseBlocked.
+func (h *APIHandlers) moderateOutput(ctx context.Context, apiKey string, response *services.MessageResponse) (string, error) {
+	if !h.moderation.Enabled(moderation.StageOutput) {
+		return "", nil
+	}
+
+	var action string
+	for i := range response.Content {
+		block := &response.Content[i]
+		if block.Type != "text" || block.Text == nil {
+			continue
+		}
+		verdict := h.moderation.Check(ctx, moderation.StageOutput, *block.Text, true)
+		h.moderation.Audit(moderation.StageOutput, verdict, h.auditAttrs(ctx, apiKey, response.Model)...)
+		if verdict.Action == moderation.ActionBlock {
+			return "", errResponseBlocked
+		}
+		text := verdict.Text
+		block.Text = &text
+		action = stricter(action, verdict.Action)
+	}
+	return action, nil
+}
+
+// moderatedSend moderates the request, sends it once lane is given an
+// upstream slot and moderates the answer, for callers that answer in one
+// piece.
+func (h *APIHandlers) moderatedSend(ctx context.Context, apiKey string, lane clientLane, request *services.MessageRequest) (*services.MessageResponse, string, error) {
+	inputAction, err := h.moderateInput(ctx, apiKey, request)
+	if err != nil {
+		return nil, "", err
+	}
+	release, err := h.acquireSlot(ctx, lane)
+	if err != nil {
+		return nil, "", err
+	}
+	response, err := h.upstream(ctx).SendMessage(ctx, apiKey, request)
+	release()
+	if err != nil {
+		return nil, "", err
+	}
+	outputAction, err := h.moderateOutput(ctx, apiKey, response)
+	if err != nil {
+		return response, "", err
+	}
+	return response, stricter(inputAction, outputAction), nil
+}
+
+// moderationMessageKey returns the error message key for a moderation block.
+func moderationMessageKey(err error) string {
+	if errors.Is(err, errResponseBlocked) {
+		return "errors.responseBlocked"
+	}
+	return "errors.contentBlocked"
+}
+
+// writeModerationError answers a blocked prompt or answer with 422 and
+// reports true, or reports false if err is not a moderation block.
+func (h *APIHandlers) writeModerationError(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, moderation.ErrBlocked) {
+		return false
+	}
+	writeJSONError(w, http.StatusUnprocessableEntity, h.localize(r, moderationMessageKey(err)), "")
+	return true
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/manto/manto-web/internal/middleware/timeout"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/queue"
+	"github.com/manto/manto-web/internal/services"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonEvent is one line of an ndjson answer: "queued" while waiting for an
+// upstream slot, "start" once the request is sent, "delta" for each piece of
+// text, then "done" followed by "message_stats", or "error". A "done" with
+// "incomplete" set ends an answer the upstream stopped sending partway.
+type ndjsonEvent struct {
+	Type          string               `json:"type"`
+	Position      int                  `json:"position,omitempty"`
+	Text          string               `json:"text,omitempty"`
+	ID            string               `json:"id,omitempty"`
+	Model         string               `json:"model,omitempty"`
+	StopReason    string               `json:"stopReason,omitempty"`
+	Usage         *services.UsageInfo  `json:"usage,omitempty"`
+	Moderation    string               `json:"moderation,omitempty"`
+	Incomplete    *services.Incomplete `json:"incomplete,omitempty"`
+	Continuations int                  `json:"continuations,omitempty"`
+	Stats         *messageStats        `json:"stats,omitempty"`
+	Error         string               `json:"error,omitempty"`
+	Code          string               `json:"code,omitempty"`
+}
+
+type ndjsonWriter struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+}
+
+func (n *ndjsonWriter) write(event interface{}) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	extendWrite(n.rc, n.writeTimeout)
+	if _, err := n.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	n.rc.Flush()
+	return nil
+}
+
+// NDJSONHandler answers a message request like MessagesHandler, but streams
+// the answer as newline-delimited JSON events for terminal clients. Instead
+// of a 202 when every upstream slot is busy, the connection waits in line
+// and reports its position. Validation errors are still plain JSON errors;
+// once streaming starts, failures arrive as an "error" event.
+func (h *APIHandlers) NDJSONHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, request, scope, ok := h.prepareMessage(w, r)
+	if !ok {
+		return
+	}
+	if scope.structured != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.responseFormatStreaming"), "")
+		return
+	}
+	r = timeout.Stream(r)
+
+	lane := h.queueLane(r, apiKey)
+	waiter, err := h.queue.EnqueueFor(lane.name, lane.weight)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		writeJSONError(w, http.StatusServiceUnavailable, h.localize(r, "errors.queueFull"), "")
+		return
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	if scope.moderation != "" {
+		w.Header().Set(moderationHeader, scope.moderation)
+	}
+	scope.variant.RecordResponse(w.Header())
+	w.WriteHeader(http.StatusOK)
+	out := &ndjsonWriter{w: w, rc: http.NewResponseController(w), writeTimeout: h.config.Server.WriteTimeout.Duration}
+
+	if !h.awaitSlot(r.Context(), waiter, func(position int) error {
+		return out.write(ndjsonEvent{Type: "queued", Position: position})
+	}) {
+		return
+	}
+	upstreamStart := time.Now()
+	defer func() { h.queue.Release(time.Since(upstreamStart)) }()
+
+	out.write(ndjsonEvent{Type: "start", Model: request.Model})
+	clock := newStreamClock()
+	response, outputAction, err := h.streamAnswer(r.Context(), apiKey, request, func(text string) error {
+		clock.text()
+		return out.write(ndjsonEvent{Type: "delta", Text: text})
+	})
+	h.journalRequest(r.Context(), scope, apiKey, request.Model, response, err)
+	h.observeSLO(r.Context(), request.Model, upstreamStart, err)
+	if err != nil {
+		event := ndjsonEvent{Type: "error", Error: err.Error()}
+		if errors.Is(err, moderation.ErrBlocked) {
+			event.Error = h.catalog.Message(scope.locale, moderationMessageKey(err))
+		} else {
+			slog.Warn("upstream message stream failed",
+				slog.String("key", h.upstream(r.Context()).Fingerprint(apiKey)),
+				slog.String("model", request.Model),
+				slog.String("error", event.Error))
+			h.recordFailure(r.Context(), apiKey, request, scope, err)
+			event.Code = services.AsAPIError(err).Code
+		}
+		out.write(event)
+		return
+	}
+
+	scope.session.RecordUsage(h.upstream(r.Context()).Fingerprint(apiKey), response.Usage.InputTokens, response.Usage.OutputTokens)
+	scope.tenant.RecordUsage(response.Usage.InputTokens, response.Usage.OutputTokens)
+	out.write(ndjsonEvent{
+		Type:          "done",
+		ID:            response.ID,
+		Model:         response.Model,
+		StopReason:    response.StopReason,
+		Usage:         &response.Usage,
+		Moderation:    stricter(scope.moderation, outputAction),
+		Incomplete:    response.Incomplete,
+		Continuations: response.Continuations,
+	})
+	stats := h.stats(clock, response)
+	out.write(ndjsonEvent{Type: "message_stats", Stats: &stats})
+}
+
+// extendWrite moves the server's write deadline to d from now, so a stream
+// can outlast WRITE_TIMEOUT for as long as it keeps sending.
+func extendWrite(rc *http.ResponseController, d time.Duration) {
+	if d > 0 {
+		rc.SetWriteDeadline(time.Now().Add(d))
+	}
+}
+
+// awaitSlot waits for waiter to be given an upstream slot, passing the
+// position in line to report every second. It reports false if the client
+// went away.
+func (h *APIHandlers) awaitSlot(ctx context.Context, waiter *queue.Waiter, report func(position int) error) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-waiter.Ready():
+			return true
+		default:
+		}
+		if err := report(h.queue.Position(waiter)); err != nil {
+			h.queue.Cancel(waiter)
+			return false
+		}
+		select {
+		case <-waiter.Ready():
+			return true
+		case <-ctx.Done():
+			h.queue.Cancel(waiter)
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// streamAnswer passes the answer's text to onText as it arrives. When answers
+// are moderated the whole answer has to be checked before any of it is
+// shown, so it is fetched in one piece and passed on at once. Answers cut off
+// by max_tokens are continued as configured. If the stream breaks partway,
+// it is resumed up to ANTHROPIC_RESUME_ATTEMPTS times from where it stopped;
+// failing that, what did arrive is returned marked incomplete rather than
+// thrown away.
+func (h *APIHandlers) streamAnswer(ctx context.Context, apiKey string, request *services.MessageRequest, onText func(string) error) (*services.MessageResponse, string, error) {
+	if h.moderation.Enabled(moderation.StageOutput) {
+		send := func(request *services.MessageRequest, _ bool) (*services.MessageResponse, error) {
+			return h.upstream(ctx).SendMessage(ctx, apiKey, request)
+		}
+		response, err := send(request, false)
+		if err == nil {
+			response, err = h.continueTruncated(request, response, send)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		action, err := h.moderateOutput(ctx, apiKey, response)
+		if err != nil {
+			return nil, "", err
+		}
+		if text := response.Text(); text != "" {
+			if err := onText(text); err != nil {
+				return nil, "", err
+			}
+		}
+		return response, action, nil
+	}
+
+	send := func(request *services.MessageRequest, trimmed bool) (*services.MessageResponse, error) {
+		return h.upstream(ctx).StreamMessage(ctx, apiKey, request, skipLeadingSpace(onText, trimmed))
+	}
+	response, err := send(request, false)
+	if err == nil {
+		response, err = h.continueTruncated(request, response, send)
+	}
+	var incomplete *services.IncompleteError
+	if !errors.As(err, &incomplete) {
+		return response, "", err
+	}
+	partial := incomplete.Partial
+	for attempt := 0; attempt < h.config.Anthropic.ResumeAttempts && canCarryOn(request, partial) && ctx.Err() == nil; attempt++ {
+		next, nextErr := carryOn(request, partial, send)
+		if nextErr == nil {
+			next, nextErr = h.continueTruncated(request, next, send)
+		}
+		if nextErr == nil {
+			return next, "", nil
+		}
+		err = nextErr
+		if !errors.As(nextErr, &incomplete) {
+			break
+		}
+		partial = incomplete.Partial
+	}
+	if partial.Text() == "" {
+		return nil, "", err
+	}
+	if errors.As(err, &incomplete) {
+		err = incomplete.Err
+	}
+	slog.Warn("returning incomplete answer",
+		slog.String("key", h.upstream(ctx).Fingerprint(apiKey)),
+		slog.String("model", request.Model),
+		slog.String("error", err.Error()))
+	partial.Incomplete = &services.Incomplete{Reason: services.IncompleteUpstreamError, Error: err.Error()}
+	return partial, "", nil
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/conversations"
+	"github.com/manto/manto-web/internal/parts"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// PartsRoutes mounts the chunked submission endpoints on r.
+func (h *APIHandlers) PartsRoutes(r chi.Router) {
+	r.Post("/api/messages/parts", h.AppendPartHandler)
+	r.Delete("/api/messages/parts/{id}", h.DeletePartsHandler)
+}
+
+// AppendPartHandler adds the raw request body, a segment of up to
+// MAX_FILE_SIZE bytes, to the upload named by ?uploadId=, or starts one
+// without it. ?index= counts segments from 0. It answers {uploadId, parts,
+// size, expiresAt}; a segment out of order gets 409 with the expectedIndex.
+func (h *APIHandlers) AppendPartHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return
+	}
+
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidPartIndex"), "")
+		return
+	}
+	maxSize := h.config.Validation.MaxFileSize
+	segment, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxSize)))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeJSONError(w, http.StatusRequestEntityTooLarge, h.localize(r, "errors.fileTooLarge", maxSize), "")
+		return
+	case err != nil || len(segment) == 0:
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contentRequired"), "")
+		return
+	}
+
+	status, err := h.parts.Append(conversations.OwnerFromAPIKey(apiKey), r.URL.Query().Get("uploadId"), index, segment)
+	var outOfOrder *parts.IndexError
+	switch {
+	case errors.As(err, &outOfOrder):
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":         h.localize(r, "errors.invalidPartIndex"),
+			"expectedIndex": outOfOrder.Expected,
+		})
+	case errors.Is(err, parts.ErrNotFound):
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.uploadNotFound"), "")
+	case errors.Is(err, parts.ErrTooMany):
+		writeJSONError(w, http.StatusTooManyRequests, h.localize(r, "errors.tooManyUploads", h.config.Parts.MaxUploads), "")
+	case errors.Is(err, parts.ErrTooLarge):
+		writeJSONError(w, http.StatusRequestEntityTooLarge, h.localize(r, "errors.messageTooLong", h.config.Validation.MaxMessageLength), "")
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, err.Error(), "")
+	default:
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// DeletePartsHandler discards an upload before it expires.
+func (h *APIHandlers) DeletePartsHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return
+	}
+	if !h.parts.Delete(conversations.OwnerFromAPIKey(apiKey), chi.URLParam(r, "id")) {
+		writeJSONError(w, http.StatusNotFound, h.localize(r, "errors.uploadNotFound"), "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveUploads appends the text of each message's upload to its content,
+// so the length policy and moderation see the whole message. It writes the
+// error response itself when an upload can't be used.
+func (h *APIHandlers) resolveUploads(w http.ResponseWriter, r *http.Request, apiKey string, request *services.MessageRequest) bool {
+	for i := range request.Messages {
+		msg := &request.Messages[i]
+		if msg.UploadID == "" {
+			continue
+		}
+		if h.parts == nil {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.uploadNotFound"), "")
+			return false
+		}
+		text, err := h.parts.Text(conversations.OwnerFromAPIKey(apiKey), msg.UploadID)
+		switch {
+		case errors.Is(err, parts.ErrInvalidUTF):
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidUpload"), "")
+			return false
+		case err != nil:
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.uploadNotFound"), "")
+			return false
+		}
+		if msg.Content != "" {
+			text = msg.Content + "\n\n" + text
+		}
+		msg.Content = text
+		msg.Blocks = nil
+		msg.UploadID = ""
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/middleware/timeout"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/tenants"
+)
+
+const (
+	passthroughPrefix      = "/anthropic"
+	passthroughMessages    = "/v1/messages"
+	maxPassthroughBodySize = 32 << 20
+)
+
+// passthroughResponseHeaders are the upstream response headers returned to
+// the client, besides any starting with Anthropic-.
+var passthroughResponseHeaders = []string{"Content-Type", "Request-Id", "Retry-After"}
+
+// PassthroughHandlers forwards allow-listed Anthropic API paths unchanged,
+// so official SDKs can use Manto as their base URL. Rate limiting and the
+// access gate apply as for the rest of the API. Bodies are forwarded as sent,
+// so nothing is clamped or redacted: instead /v1/messages requests are
+// refused when their model or max_tokens breaks the tenant's allow-lists and
+// caps or ANTHROPIC_MODEL_MAX_TOKENS, or the tenant has used its quota, and
+// their max_tokens is taken from the caller's RATE_LIMIT_TOKENS bucket. What
+// can't be checked that way is refused outright: every POST while moderation
+// is on, POSTs to other paths from restricted tenants, and messages from
+// tenants with a token quota, since streamed usage isn't metered.
+type PassthroughHandlers struct {
+	*APIHandlers
+}
+
+func NewPassthroughHandlers(api *APIHandlers) *PassthroughHandlers {
+	return &PassthroughHandlers{APIHandlers: api}
+}
+
+// Routes mounts the passthrough on r.
+func (h *PassthroughHandlers) Routes(r chi.Router) {
+	r.Get(passthroughPrefix+"/v1/*", h.ProxyHandler)
+	r.Post(passthroughPrefix+"/v1/*", h.ProxyHandler)
+}
+
+// ProxyHandler forwards the request to the same path upstream with the
+// caller's key once it is given an upstream slot, and streams the response
+// back as it arrives, without the request timeout, since it may be a stream.
+func (h *PassthroughHandlers) ProxyHandler(w http.ResponseWriter, r *http.Request) {
+	upstreamPath := strings.TrimPrefix(r.URL.Path, passthroughPrefix)
+	provider := h.providers[services.AnthropicProvider]
+	forwarder, ok := provider.(services.Forwarder)
+	if !ok || path.Clean(upstreamPath) != upstreamPath || !h.config.Passthrough.Allows(upstreamPath) {
+		writeJSONError(w, http.StatusForbidden, h.localize(r, "errors.passthroughNotAllowed"), "")
+		return
+	}
+
+	apiKey := provider.ClientAPIKey(r)
+	if !provider.ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusUnauthorized, h.localize(r, "errors.invalidApiKey"), "")
+		return
+	}
+
+	if version := r.Header.Get("Anthropic-Version"); version != "" && !h.config.Anthropic.AllowsAPIVersion(version) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.apiVersionNotAllowed", version), "")
+		return
+	}
+
+	r = timeout.Stream(r)
+	var body io.Reader
+	if r.Method == http.MethodPost {
+		body = http.MaxBytesReader(w, r.Body, maxPassthroughBodySize)
+		var ok bool
+		if body, ok = h.checkPassthrough(w, r, upstreamPath, body); !ok {
+			return
+		}
+	}
+	release, err := h.acquireSlot(r.Context(), h.queueLane(r, apiKey))
+	if errors.Is(err, errQueueFull) {
+		w.Header().Set("Retry-After", "5")
+		writeJSONError(w, http.StatusServiceUnavailable, h.localize(r, "errors.queueFull"), "")
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer release()
+	resp, err := forwarder.Forward(r.Context(), apiKey, r.Method, upstreamPath, r.URL.RawQuery, r.Header, body)
+	if err != nil {
+		slog.Warn("passthrough request failed",
+			slog.String("key", provider.Fingerprint(apiKey)),
+			slog.String("path", upstreamPath),
+			slog.String("error", err.Error()))
+		writeJSONError(w, http.StatusBadGateway, h.localize(r, "errors.invalidUpstreamResponse"), "")
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		if strings.HasPrefix(name, "Anthropic-") || slices.Contains(passthroughResponseHeaders, name) {
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			extendWrite(rc, h.config.Server.WriteTimeout.Duration)
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// checkPassthrough applies what can be enforced without changing the body
+// to a POST for upstreamPath, and returns the body to forward. It writes the
+// error response itself when it reports false.
+func (h *PassthroughHandlers) checkPassthrough(w http.ResponseWriter, r *http.Request, upstreamPath string, body io.Reader) (io.Reader, bool) {
+	tenant := tenants.FromContext(r.Context())
+	overridden := h.overrides.Resolve(tenant)
+	if h.moderation.Enabled(moderation.StageInput) || h.moderation.Enabled(moderation.StageOutput) {
+		writeJSONError(w, http.StatusForbidden, h.localize(r, "errors.passthroughNotAllowed"), "")
+		return nil, false
+	}
+	if upstreamPath != passthroughMessages {
+		if tenant.Restricted() || overridden.Restricted() {
+			writeJSONError(w, http.StatusForbidden, h.localize(r, "errors.passthroughNotAllowed"), "")
+			return nil, false
+		}
+		return body, true
+	}
+	if tenant != nil && tenant.Quota.Tokens > 0 {
+		writeJSONError(w, http.StatusForbidden, h.localize(r, "errors.passthroughNotAllowed"), "")
+		return nil, false
+	}
+
+	raw, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, h.localize(r, "errors.fileTooLarge", maxPassthroughBodySize), "")
+		return nil, false
+	}
+	var request struct {
+		Model     string `json:"model"`
+		MaxTokens int    `json:"max_tokens"`
+	}
+	if err != nil || json.Unmarshal(raw, &request) != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return nil, false
+	}
+	if !tenant.Allows(request.Model) || !overridden.Allows(request.Model) {
+		writeJSONError(w, http.StatusForbidden, h.localize(r, "errors.modelNotAllowed"), "")
+		return nil, false
+	}
+	limit := h.config.Anthropic.ModelMaxTokens.For(request.Model)
+	if tenant != nil && tenant.MaxTokens > 0 && (limit == 0 || tenant.MaxTokens < limit) {
+		limit = tenant.MaxTokens
+	}
+	if limit > 0 && request.MaxTokens > limit {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.maxTokensExceeded", limit, request.Model), "")
+		return nil, false
+	}
+	if err := tenant.Admit(time.Now()); err != nil {
+		writeJSONError(w, http.StatusTooManyRequests, h.localize(r, "errors.tenantQuotaExceeded"), "")
+		return nil, false
+	}
+	if !h.chargeTokens(w, r, request.MaxTokens) {
+		return nil, false
+	}
+	return bytes.NewReader(raw), true
+}
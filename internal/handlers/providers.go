@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/manto/manto-web/internal/services"
+)
+
+// providerHeader names the provider a request is for; ?provider= works too.
+const providerHeader = "X-Manto-Provider"
+
+type providerKey struct{}
+
+// WithProvider makes p available to requests that name it, alongside the
+// provider the handlers were created with.
+func (h *APIHandlers) WithProvider(p services.Provider) *APIHandlers {
+	h.providers[p.Name()] = p
+	return h
+}
+
+// SelectProvider picks the provider r names in X-Manto-Provider or
+// ?provider=, for the handlers behind it. Requests naming none get
+// PROVIDER_DEFAULT, and those naming one that isn't configured get a 400.
+func (h *APIHandlers) SelectProvider(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(providerHeader)
+		if name == "" {
+			name = r.URL.Query().Get("provider")
+		}
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, ok := h.providers[name]
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.unknownProvider", name), "")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withProvider(r.Context(), p)))
+	})
+}
+
+func withProvider(ctx context.Context, p services.Provider) context.Context {
+	return context.WithValue(ctx, providerKey{}, p)
+}
+
+// Provider returns the provider selected for ctx, for middleware behind
+// SelectProvider that needs to know it.
+func (h *APIHandlers) Provider(ctx context.Context) services.Provider {
+	return h.upstream(ctx)
+}
+
+// upstream returns the provider selected for ctx, or the default one:
+// PROVIDER_DEFAULT if it is configured, otherwise the one the handlers were
+// created with.
+func (h *APIHandlers) upstream(ctx context.Context) services.Provider {
+	if p, ok := ctx.Value(providerKey{}).(services.Provider); ok {
+		return p
+	}
+	if p, ok := h.providers[h.config.Provider.Default]; ok {
+		return p
+	}
+	return h.provider
+}
+
+// clientProviders describes the registered providers for the UI, by name,
+// with the default one flagged and the model each preselects.
+func (h *APIHandlers) clientProviders() []map[string]interface{} {
+	names := make([]string, 0, len(h.providers))
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	defaultName := h.upstream(context.Background()).Name()
+	providers := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		entry := map[string]interface{}{
+			"name":        name,
+			"displayName": name,
+			"default":     name == defaultName,
+		}
+		if model := h.config.DefaultModel(name); model != "" {
+			entry["defaultModel"] = model
+		}
+		if d, ok := h.providers[name].(services.Describer); ok {
+			entry["displayName"] = d.DisplayName()
+			entry["keyRequired"] = d.KeyRequired()
+			entry["keyPrefix"] = d.KeyPrefix()
+		}
+		providers = append(providers, entry)
+	}
+	return providers
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// WithSLO reports every upstream message call to slo.
+func (h *APIHandlers) WithSLO(slo *metrics.SLO) *APIHandlers {
+	h.slo = slo
+	return h
+}
+
+// observeSLO records an upstream call to model that started at started and
+// ended with err. Calls the client abandoned say nothing about the upstream,
+// answers failing moderation or the response schema were still served, and
+// requests refused for the client's own reasons, such as a bad key or its
+// rate limit, show the upstream working.
+func (h *APIHandlers) observeSLO(ctx context.Context, model string, started time.Time, err error) {
+	var invalid *schemaError
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	failed := err != nil && !errors.As(err, &invalid) && !errors.Is(err, moderation.ErrBlocked) &&
+		services.AsAPIError(err).HTTPStatus >= http.StatusInternalServerError
+	h.slo.Observe(h.upstream(ctx).Name(), model, time.Since(started), failed)
+}
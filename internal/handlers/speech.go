@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/speech"
+)
+
+// SpeechHandlers serves voice input and read-aloud through the configured
+// speech providers.
+type SpeechHandlers struct {
+	*APIHandlers
+	transcriber *speech.Transcriber
+	synthesizer *speech.Synthesizer
+}
+
+func NewSpeechHandlers(api *APIHandlers) *SpeechHandlers {
+	cfg := api.config.Speech
+	return &SpeechHandlers{
+		APIHandlers: api,
+		transcriber: speech.NewTranscriber(cfg.STTProvider, cfg.STTURL, cfg.STTAPIKey, cfg.STTModel, &http.Client{Timeout: cfg.STTTimeout.Duration}),
+		synthesizer: speech.NewSynthesizer(cfg.TTSProvider, cfg.TTSURL, cfg.TTSAPIKey, cfg.TTSModel, cfg.TTSVoice, &http.Client{Timeout: cfg.TTSTimeout.Duration}),
+	}
+}
+
+// Routes mounts the endpoints of the configured providers on r.
+func (h *SpeechHandlers) Routes(r chi.Router) {
+	if h.config.Speech.STTProvider != "" {
+		r.Post("/api/transcribe", h.TranscribeHandler)
+	}
+	if h.config.Speech.TTSProvider != "" {
+		r.Post("/api/tts", h.TTSHandler)
+	}
+}
+
+// TranscribeHandler accepts a multipart upload with the recording in "file"
+// and an optional "language", and answers {text}. Recordings are limited to
+// MAX_FILE_SIZE.
+func (h *SpeechHandlers) TranscribeHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return
+	}
+
+	maxSize := h.config.Validation.MaxFileSize
+	// The multipart framing and the language field need a little room on
+	// top of the recording itself.
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxSize)+1<<14)
+	file, header, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeJSONError(w, http.StatusRequestEntityTooLarge, h.localize(r, "errors.fileTooLarge", maxSize), "")
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contentRequired"), "")
+		return
+	}
+	defer file.Close()
+	if header.Size > int64(maxSize) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, h.localize(r, "errors.fileTooLarge", maxSize), "")
+		return
+	}
+	contentType := header.Header.Get("Content-Type")
+	if !isAudio(contentType) {
+		writeJSONError(w, http.StatusUnsupportedMediaType, h.localize(r, "errors.unsupportedAudio"), "")
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || len(data) == 0 {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contentRequired"), "")
+		return
+	}
+
+	text, err := h.transcriber.Transcribe(r.Context(), speech.Audio{
+		Name:        header.Filename,
+		ContentType: contentType,
+		Data:        data,
+		Language:    strings.TrimSpace(r.FormValue("language")),
+	})
+	if err != nil {
+		slog.Warn("transcription failed",
+			slog.String("key", h.upstream(r.Context()).Fingerprint(apiKey)),
+			slog.String("error", err.Error()))
+		writeJSONError(w, http.StatusBadGateway, h.localize(r, "errors.transcriptionFailed"), "")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"text": text})
+}
+
+// isAudio reports whether contentType is a recording format. Browsers label
+// MediaRecorder output video/webm or video/mp4 even when it holds only
+// audio.
+func isAudio(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "audio/") || mediaType == "video/webm" || mediaType == "video/mp4"
+}
+
+// TTSHandler accepts {text, voice, format} and streams the text read aloud,
+// as it is synthesized, with the audio's content type. Voice and format
+// default to TTS_VOICE and TTS_FORMAT; text is limited to TTS_MAX_CHARS.
+func (h *SpeechHandlers) TTSHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return
+	}
+
+	var body struct {
+		Text   string `json:"text"`
+		Voice  string `json:"voice"`
+		Format string `json:"format"`
+	}
+	maxChars := h.config.Speech.TTSMaxChars
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxChars)*4+1<<12)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+	text := strings.TrimSpace(body.Text)
+	if text == "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contentRequired"), "")
+		return
+	}
+	if utf8.RuneCountInString(text) > maxChars {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.messageTooLong", maxChars), "")
+		return
+	}
+	format := h.config.Speech.TTSFormat
+	if body.Format != "" && body.Format != format {
+		if _, ok := speech.ContentTypes[body.Format]; !ok || h.config.Speech.TTSProvider == speech.ProviderElevenLabs {
+			writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.unsupportedAudio"), "")
+			return
+		}
+		format = body.Format
+	}
+
+	audio, err := h.synthesizer.Synthesize(r.Context(), text, body.Voice, format)
+	if err != nil {
+		slog.Warn("speech synthesis failed",
+			slog.String("key", h.upstream(r.Context()).Fingerprint(apiKey)),
+			slog.String("error", err.Error()))
+		writeJSONError(w, http.StatusBadGateway, h.localize(r, "errors.speechFailed"), "")
+		return
+	}
+	defer audio.Body.Close()
+
+	w.Header().Set("Content-Type", audio.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := audio.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/manto/manto-web/internal/middleware/timeout"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/timing"
+)
+
+// sseWriter writes server-sent events, flushing each one and moving the
+// write deadline past it. The 200 and the event-stream headers go out with
+// the first write, so a request that fails before anything is sent still
+// gets a plain JSON error.
+type sseWriter struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+	started      bool
+}
+
+func (s *sseWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-store")
+	s.w.Header().Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *sseWriter) event(name string, data []byte) error {
+	s.start()
+	extendWrite(s.rc, s.writeTimeout)
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	s.rc.Flush()
+	return nil
+}
+
+// comment writes an SSE comment, which clients ignore, to show the
+// connection is alive.
+func (s *sseWriter) comment(text string) error {
+	s.start()
+	extendWrite(s.rc, s.writeTimeout)
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	s.rc.Flush()
+	return nil
+}
+
+func (s *sseWriter) send(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.event(name, data)
+}
+
+// streamMessage answers a message request sent with "stream": true as
+// server-sent events in the Messages API's own format: message_start, one
+// text block of content_block_delta events, then message_delta and
+// message_stop, followed by a message_stats event with the same stats the
+// ndjson stream ends with. When every upstream slot is busy the connection
+// waits in line, kept alive by comments. Answers are fetched with
+// streamAnswer, so they are moderated, continued and resumed the same way;
+// an answer the upstream stopped sending partway ends with "incomplete" set
+// on message_delta. Failures before the first event are plain JSON errors;
+// after it, an "error" event ends the stream. The upstream request is
+// cancelled when the client goes away.
+func (h *APIHandlers) streamMessage(w http.ResponseWriter, r *http.Request, apiKey string, request *services.MessageRequest, scope requestScope) {
+	if scope.structured != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.responseFormatStreaming"), "")
+		return
+	}
+	r = timeout.Stream(r)
+
+	lane := h.queueLane(r, apiKey)
+	waiter, err := h.queue.EnqueueFor(lane.name, lane.weight)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		writeJSONError(w, http.StatusServiceUnavailable, h.localize(r, "errors.queueFull"), "")
+		return
+	}
+
+	if scope.moderation != "" {
+		w.Header().Set(moderationHeader, scope.moderation)
+	}
+	scope.variant.RecordResponse(w.Header())
+	out := &sseWriter{w: w, rc: http.NewResponseController(w), writeTimeout: h.config.Server.WriteTimeout.Duration}
+	if !h.awaitSlot(r.Context(), waiter, func(position int) error {
+		return out.comment(fmt.Sprintf("queued %d", position))
+	}) {
+		return
+	}
+	upstreamStart := time.Now()
+	defer func() {
+		h.queue.Release(time.Since(upstreamStart))
+		timing.FromContext(r.Context()).Since("upstream_total", upstreamStart)
+	}()
+
+	// A moderated answer arrives in one piece once it has been checked, but
+	// the moderation header has to go out before it, so it is held back
+	// until streamAnswer reports what moderation did.
+	held := h.moderation.Enabled(moderation.StageOutput)
+	var pending string
+	opened := false
+	delta := func(text string) error {
+		if !opened {
+			opened = true
+			if err := writeMessageStart(out, request.Model); err != nil {
+				return err
+			}
+		}
+		if text == "" {
+			return nil
+		}
+		return out.send("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]string{"type": "text_delta", "text": text},
+		})
+	}
+	clock := newStreamClock()
+	response, outputAction, err := h.streamAnswer(r.Context(), apiKey, request, func(text string) error {
+		clock.text()
+		if held {
+			pending += text
+			return nil
+		}
+		return delta(text)
+	})
+	h.journalRequest(r.Context(), scope, apiKey, request.Model, response, err)
+	h.observeSLO(r.Context(), request.Model, upstreamStart, err)
+	keyFingerprint := h.upstream(r.Context()).Fingerprint(apiKey)
+	if err != nil {
+		message := err.Error()
+		if errors.Is(err, moderation.ErrBlocked) {
+			message = h.catalog.Message(scope.locale, moderationMessageKey(err))
+			if !out.started {
+				writeJSONError(w, http.StatusUnprocessableEntity, message, "")
+				return
+			}
+		} else {
+			slog.Warn("upstream message stream failed",
+				slog.String("key", keyFingerprint),
+				slog.String("model", request.Model),
+				slog.String("error", message))
+			h.recordFailure(r.Context(), apiKey, request, scope, err)
+			if !out.started {
+				writeUpstreamError(w, err)
+				return
+			}
+		}
+		out.send("error", map[string]interface{}{
+			"type":  "error",
+			"error": services.ErrorDetail{Type: "api_error", Message: message},
+		})
+		return
+	}
+
+	scope.session.RecordUsage(keyFingerprint, response.Usage.InputTokens, response.Usage.OutputTokens)
+	scope.tenant.RecordUsage(response.Usage.InputTokens, response.Usage.OutputTokens)
+	if action := stricter(scope.moderation, outputAction); action != "" && !out.started {
+		w.Header().Set(moderationHeader, action)
+	}
+	if err := delta(pending); err != nil {
+		return
+	}
+	if err := out.send("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": 0}); err != nil {
+		return
+	}
+	ending := map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]string{"stop_reason": response.StopReason},
+		"usage": map[string]int{"output_tokens": response.Usage.OutputTokens},
+	}
+	if response.Incomplete != nil {
+		ending["incomplete"] = response.Incomplete
+	}
+	if response.Continuations > 0 {
+		ending["continuations"] = response.Continuations
+	}
+	if err := out.send("message_delta", ending); err != nil {
+		return
+	}
+	if err := out.send("message_stop", map[string]string{"type": "message_stop"}); err != nil {
+		return
+	}
+	stats := h.stats(clock, response)
+	out.send("message_stats", map[string]interface{}{"type": "message_stats", "stats": stats})
+}
+
+// writeMessageStart opens an answer the way the Messages API streams one:
+// message_start for an empty message from model, then content_block_start
+// for the text block the deltas go into.
+func writeMessageStart(out *sseWriter, model string) error {
+	start := services.MessageResponse{Type: "message", Role: "assistant", Model: model, Content: []services.ContentBlock{}}
+	if err := out.send("message_start", map[string]interface{}{"type": "message_start", "message": start}); err != nil {
+		return err
+	}
+	empty := ""
+	return out.send("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         0,
+		"content_block": services.ContentBlock{Type: "text", Text: &empty},
+	})
+}
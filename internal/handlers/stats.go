@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"math"
+	"time"
+
+	"github.com/manto/manto-web/internal/services"
+)
+
+// messageStats summarises a streamed answer for the UI's per-message footer:
+// token counts, how long it took, how soon the first text arrived, the
+// generation rate after that, and the estimated cost when prices are
+// configured for the model.
+type messageStats struct {
+	InputTokens     int      `json:"inputTokens"`
+	OutputTokens    int      `json:"outputTokens"`
+	TotalTokens     int      `json:"totalTokens"`
+	DurationMS      int64    `json:"durationMs"`
+	TTFBMS          int64    `json:"ttfbMs"`
+	TokensPerSecond float64  `json:"tokensPerSecond"`
+	EstimatedCost   *float64 `json:"estimatedCost,omitempty"`
+	Currency        string   `json:"currency,omitempty"`
+}
+
+// streamClock times an answer from when it is sent upstream.
+type streamClock struct {
+	start      time.Time
+	firstToken time.Time
+}
+
+func newStreamClock() *streamClock {
+	return &streamClock{start: time.Now()}
+}
+
+// text records that text has arrived.
+func (c *streamClock) text() {
+	if c.firstToken.IsZero() {
+		c.firstToken = time.Now()
+	}
+}
+
+// stats summarises the finished response. The rate counts from the first
+// text, so queueing and prompt processing don't drag it down.
+func (h *APIHandlers) stats(clock *streamClock, response *services.MessageResponse) messageStats {
+	end := time.Now()
+	firstToken := clock.firstToken
+	if firstToken.IsZero() {
+		firstToken = end
+	}
+	usage := response.Usage
+	stats := messageStats{
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		TotalTokens:  usage.InputTokens + usage.OutputTokens,
+		DurationMS:   end.Sub(clock.start).Milliseconds(),
+		TTFBMS:       firstToken.Sub(clock.start).Milliseconds(),
+	}
+	if generating := end.Sub(firstToken).Seconds(); generating > 0 {
+		stats.TokensPerSecond = math.Round(float64(usage.OutputTokens)/generating*10) / 10
+	}
+	if cost, ok := h.config.Anthropic.EstimateCost(response.Model, usage.InputTokens, usage.OutputTokens); ok {
+		stats.EstimatedCost, stats.Currency = &cost, "USD"
+	}
+	return stats
+}
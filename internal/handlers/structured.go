@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/manto/manto-web/internal/jsonschema"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// structuredOutput is a response_format the answer has to satisfy.
+type structuredOutput struct {
+	schema *jsonschema.Schema
+	raw    string
+}
+
+func newStructuredOutput(format *services.ResponseFormat) (*structuredOutput, error) {
+	if format.Type != services.ResponseFormatJSONSchema {
+		return nil, fmt.Errorf("type must be %s", services.ResponseFormatJSONSchema)
+	}
+	if len(format.Schema) == 0 {
+		return nil, errors.New("schema is required")
+	}
+	schema, err := jsonschema.Compile(format.Schema)
+	if err != nil {
+		return nil, err
+	}
+	return &structuredOutput{schema: schema, raw: string(format.Schema)}, nil
+}
+
+// schemaError is the typed failure for answers that never matched the
+// schema. Usage covers every attempt.
+type schemaError struct {
+	Errors   []string
+	Attempts int
+	Usage    services.UsageInfo
+}
+
+func (e *schemaError) Error() string {
+	return fmt.Sprintf("answer did not match the schema after %d attempts: %s", e.Attempts, strings.Join(e.Errors, "; "))
+}
+
+// structuredAnswer asks for an answer matching format's schema, telling the
+// model about it in the system prompt. An answer that doesn't match is
+// retried up to ANTHROPIC_SCHEMA_RETRIES times with the validation errors fed
+// back, after which a *schemaError is returned. The answer returned holds
+// just the JSON document, with usage summed over the attempts.
+func (h *APIHandlers) structuredAnswer(request *services.MessageRequest, format *structuredOutput, send sendFunc) (*services.MessageResponse, error) {
+	attempt := format.instruct(request)
+	var usage services.UsageInfo
+	for tries := 1; ; tries++ {
+		response, err := send(attempt, false)
+		if err == nil {
+			response, err = h.continueTruncated(attempt, response, send)
+		}
+		if err != nil {
+			return nil, err
+		}
+		usage.InputTokens += response.Usage.InputTokens
+		usage.OutputTokens += response.Usage.OutputTokens
+
+		doc := stripFences(response.Text())
+		invalid := format.schema.Validate([]byte(doc))
+		if len(invalid) == 0 {
+			response.Content = []services.ContentBlock{{Type: services.BlockText, Text: &doc}}
+			response.Usage = usage
+			return response, nil
+		}
+		messages := make([]string, len(invalid))
+		for i, e := range invalid {
+			messages[i] = e.Error()
+		}
+		if tries > h.config.Anthropic.SchemaRetries {
+			return nil, &schemaError{Errors: messages, Attempts: tries, Usage: usage}
+		}
+		attempt = format.feedback(attempt, response.Text(), messages)
+	}
+}
+
+// instruct returns a copy of request whose system prompt asks for the
+// schema.
+func (f *structuredOutput) instruct(request *services.MessageRequest) *services.MessageRequest {
+	next := *request
+	system := "Respond with only a JSON document matching this JSON Schema, without any other text or code fences:\n" + f.raw
+	if request.System != nil && *request.System != "" {
+		system = *request.System + "\n\n" + system
+	}
+	next.System = &system
+	return &next
+}
+
+// feedback returns attempt followed by the answer it got and a user turn
+// listing what was wrong with it.
+func (f *structuredOutput) feedback(attempt *services.MessageRequest, answer string, problems []string) *services.MessageRequest {
+	if strings.TrimSpace(answer) == "" {
+		answer = "(no answer)"
+	}
+	next := continueRequest(attempt, answer)
+	next.Messages = append(next.Messages, services.Message{
+		Role:    "user",
+		Content: "That answer does not match the JSON Schema:\n- " + strings.Join(problems, "\n- ") + "\nReply with only the corrected JSON document.",
+	})
+	return next
+}
+
+// stripFences removes a Markdown code fence around text, which models add
+// despite being asked not to.
+func stripFences(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	text = strings.TrimSuffix(text, "```")
+	if newline := strings.IndexByte(text, '\n'); newline >= 0 {
+		text = text[newline+1:]
+	} else {
+		text = strings.TrimPrefix(text, "```")
+	}
+	return strings.TrimSpace(text)
+}
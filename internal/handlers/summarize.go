@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/middleware/timeout"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/session"
+	"github.com/manto/manto-web/internal/summarize"
+	"github.com/manto/manto-web/internal/tenants"
+)
+
+// SummarizeHandlers serves the opt-in document summarizer, which runs with
+// the caller's API key.
+type SummarizeHandlers struct {
+	*APIHandlers
+}
+
+func NewSummarizeHandlers(api *APIHandlers) *SummarizeHandlers {
+	return &SummarizeHandlers{APIHandlers: api}
+}
+
+// Routes mounts the summarize endpoint on r.
+func (h *SummarizeHandlers) Routes(r chi.Router) {
+	r.Post("/api/summarize", h.SummarizeHandler)
+}
+
+// summarizeEvent is one line of a summary's progress: "progress" as each
+// request finishes, then "summary" or "error".
+type summarizeEvent struct {
+	Type string `json:"type"`
+	*summarize.Progress
+	Summary string              `json:"summary,omitempty"`
+	Model   string              `json:"model,omitempty"`
+	Usage   *services.UsageInfo `json:"usage,omitempty"`
+	Error   string              `json:"error,omitempty"`
+}
+
+// SummarizeHandler accepts {text, model, instructions, maxTokens} and streams
+// the progress of summarizing the text as newline-delimited JSON, ending with
+// the summary. Long texts are summarized in chunks that are then combined.
+// Attachment IDs are refused, as there is no attachment store to read them
+// from.
+func (h *SummarizeHandlers) SummarizeHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return
+	}
+
+	var body struct {
+		Text         string `json:"text"`
+		AttachmentID string `json:"attachmentId"`
+		Model        string `json:"model"`
+		Instructions string `json:"instructions"`
+		MaxTokens    int    `json:"maxTokens"`
+	}
+	maxChars := h.config.Summarize.MaxChars
+	// Text is counted in characters, which take up to four bytes each.
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxChars)*4+1<<16)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+	if body.AttachmentID != "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.attachmentsUnsupported"), "")
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contentRequired"), "")
+		return
+	}
+	if utf8.RuneCountInString(body.Text) > maxChars {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.documentTooLong", maxChars), "")
+		return
+	}
+	if body.MaxTokens < 0 {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidMaxTokens"), "")
+		return
+	}
+
+	model := body.Model
+	if model == "" {
+		model = h.config.Summarize.Model
+	}
+	if model == "" {
+		model = h.config.Anthropic.DefaultModel
+	}
+	template := services.MessageRequest{Model: model, MaxTokens: body.MaxTokens}
+	template.ApplyDefaults(h.config.Anthropic.MaxTokens, h.config.Anthropic.Temperature, h.systemMessage(r.Context()))
+	template.MergeStopSequences(h.stopSequences)
+	if limit := h.config.Anthropic.ModelMaxTokens.For(model); limit > 0 && template.MaxTokens > limit {
+		template.MaxTokens = limit
+	}
+
+	r = timeout.Stream(r)
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	out := &ndjsonWriter{w: w, rc: http.NewResponseController(w), writeTimeout: h.config.Server.WriteTimeout.Duration}
+
+	lane := h.queueLane(r, apiKey)
+	var mu sync.Mutex
+	var usage services.UsageInfo
+	summarizer := &summarize.Summarizer{
+		ChunkTokens:  h.config.Summarize.ChunkTokens,
+		Concurrency:  h.config.Summarize.Concurrency,
+		Instructions: body.Instructions,
+		Count:        h.tokens.Count,
+		Send: func(ctx context.Context, prompt string) (string, error) {
+			request := template
+			request.Messages = []services.Message{{Role: "user", Content: prompt}}
+			response, _, err := h.moderatedSend(ctx, apiKey, lane, &request)
+			if err != nil {
+				return "", err
+			}
+			mu.Lock()
+			usage.InputTokens += response.Usage.InputTokens
+			usage.OutputTokens += response.Usage.OutputTokens
+			mu.Unlock()
+			return response.Text(), nil
+		},
+	}
+	summary, err := summarizer.Summarize(h.moderationContext(r), body.Text, func(p summarize.Progress) {
+		out.write(summarizeEvent{Type: "progress", Progress: &p})
+	})
+	session.FromContext(r.Context()).RecordUsage(h.upstream(r.Context()).Fingerprint(apiKey), usage.InputTokens, usage.OutputTokens)
+	tenants.FromContext(r.Context()).RecordUsage(usage.InputTokens, usage.OutputTokens)
+	if err != nil {
+		message := err.Error()
+		if errors.Is(err, moderation.ErrBlocked) {
+			message = h.localize(r, moderationMessageKey(err))
+		}
+		out.write(summarizeEvent{Type: "error", Error: message})
+		return
+	}
+	out.write(summarizeEvent{Type: "summary", Summary: summary, Model: model, Usage: &usage})
+}
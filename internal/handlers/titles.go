@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/session"
+	"github.com/manto/manto-web/internal/tenants"
+)
+
+const (
+	// titleInputChars is how much of the text a title is written from.
+	titleInputChars = 2000
+	maxTitleChars   = 80
+	maxSlugChars    = 60
+)
+
+const titlePrompt = "Write a title of at most six words for a conversation or document that starts with the text below. Reply with the title only, in the text's language, without quotes or a final full stop.\n\n<text>\n%s\n</text>"
+
+// GenerateTitleHandler answers {text} with {title, slug, model}, so apps
+// embedding Manto don't have to write their own throwaway prompt. Titles are
+// written by the cheapest configured model.
+func (h *APIHandlers) GenerateTitleHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := h.upstream(r.Context()).ClientAPIKey(r)
+	if !h.upstream(r.Context()).ValidateAPIKey(apiKey) {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidApiKey"), "")
+		return
+	}
+
+	var body struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.invalidJson"), "")
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" {
+		writeJSONError(w, http.StatusBadRequest, h.localize(r, "errors.contentRequired"), "")
+		return
+	}
+
+	title, model, err := h.generateTitle(h.moderationContext(r), apiKey, h.queueLane(r, apiKey), body.Text)
+	if h.writeModerationError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"title": title,
+		"slug":  slugify(title),
+		"model": model,
+	})
+}
+
+// titleModel is TITLE_MODEL, else the cheapest priced model, else the
+// default model.
+func (h *APIHandlers) titleModel() string {
+	for _, model := range []string{h.config.Title.Model, h.config.Anthropic.CheapestModel()} {
+		if model != "" {
+			return model
+		}
+	}
+	return h.config.Anthropic.DefaultModel
+}
+
+// generateTitle asks the title model for a title for text, waiting in lane
+// for an upstream slot, and returns it with the model used.
+func (h *APIHandlers) generateTitle(ctx context.Context, apiKey string, lane clientLane, text string) (string, string, error) {
+	if runes := []rune(strings.TrimSpace(text)); len(runes) > titleInputChars {
+		text = string(runes[:titleInputChars])
+	}
+	request := services.MessageRequest{
+		Model:     h.titleModel(),
+		MaxTokens: 32,
+		Messages:  []services.Message{{Role: "user", Content: fmt.Sprintf(titlePrompt, text)}},
+	}
+	request.ApplyDefaults(h.config.Anthropic.MaxTokens, h.config.Anthropic.Temperature, "")
+	response, _, err := h.moderatedSend(ctx, apiKey, lane, &request)
+	if err != nil {
+		return "", "", err
+	}
+	session.FromContext(ctx).RecordUsage(h.upstream(ctx).Fingerprint(apiKey), response.Usage.InputTokens, response.Usage.OutputTokens)
+	tenants.FromContext(ctx).RecordUsage(response.Usage.InputTokens, response.Usage.OutputTokens)
+
+	title := cleanTitle(response.Text())
+	if title == "" {
+		return "", "", errors.New("the model returned an empty title")
+	}
+	return title, request.Model, nil
+}
+
+// cleanTitle keeps the first line of a model's title, without the quotes,
+// markup and final full stop models add anyway.
+func cleanTitle(text string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	title = strings.TrimSpace(strings.TrimLeft(title, "#* "))
+	title = strings.Trim(title, "\"'`*“”‘’«» ")
+	title = strings.TrimRight(title, ".。")
+	if runes := []rune(title); len(runes) > maxTitleChars {
+		title = strings.TrimSpace(string(runes[:maxTitleChars]))
+	}
+	return title
+}
+
+// slugify lowercases title and joins its words with hyphens, keeping only
+// letters and digits.
+func slugify(title string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		default:
+			hyphen = true
+		}
+		if b.Len() >= maxSlugChars {
+			break
+		}
+	}
+	return b.String()
+}
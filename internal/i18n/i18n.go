@@ -0,0 +1,155 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+const FallbackLocale = "en"
+
+// bundles holds every embedded locale, keyed by language tag. The files are
+// compiled into the binary, so a parse failure is a programming error.
+var bundles = mustLoadBundles()
+
+func mustLoadBundles() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: failed to read embedded locales: %v", err))
+	}
+
+	loaded := make(map[string]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: failed to read %s: %v", entry.Name(), err))
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: invalid locale file %s: %v", entry.Name(), err))
+		}
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+	return loaded
+}
+
+// Catalog resolves messages for a request, falling back to the configured
+// default locale and then to English for missing keys.
+type Catalog struct {
+	defaultLocale string
+}
+
+func New(defaultLocale string) *Catalog {
+	defaultLocale = strings.ToLower(defaultLocale)
+	if _, ok := bundles[defaultLocale]; !ok {
+		defaultLocale = FallbackLocale
+	}
+	return &Catalog{defaultLocale: defaultLocale}
+}
+
+func (c *Catalog) DefaultLocale() string {
+	return c.defaultLocale
+}
+
+// Supported returns the available locale tags in sorted order.
+func (c *Catalog) Supported() []string {
+	locales := make([]string, 0, len(bundles))
+	for locale := range bundles {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Bundle returns the full message set for locale, with untranslated keys
+// filled in from the default and fallback locales.
+func (c *Catalog) Bundle(locale string) (map[string]string, bool) {
+	if _, ok := bundles[locale]; !ok {
+		return nil, false
+	}
+
+	merged := make(map[string]string)
+	for _, l := range []string{FallbackLocale, c.defaultLocale, locale} {
+		for key, msg := range bundles[l] {
+			merged[key] = msg
+		}
+	}
+	return merged, true
+}
+
+// Message formats the message for key in locale. Unknown keys return the key
+// itself so a missing translation is visible rather than blank.
+func (c *Catalog) Message(locale, key string, args ...interface{}) string {
+	for _, l := range []string{locale, c.defaultLocale, FallbackLocale} {
+		if msg, ok := bundles[l][key]; ok {
+			if len(args) > 0 {
+				return fmt.Sprintf(msg, args...)
+			}
+			return msg
+		}
+	}
+	return key
+}
+
+// Negotiate picks a locale from the "lang" query parameter, then the
+// Accept-Language header, then the catalog default.
+func (c *Catalog) Negotiate(r *http.Request) string {
+	if locale, ok := c.match(r.URL.Query().Get("lang")); ok {
+		return locale
+	}
+
+	type candidate struct {
+		tag string
+		q   float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{tag: fields[0], q: q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	for _, cand := range candidates {
+		if locale, ok := c.match(cand.tag); ok {
+			return locale
+		}
+	}
+	return c.defaultLocale
+}
+
+func (c *Catalog) match(tag string) (string, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if tag == "" {
+		return "", false
+	}
+	if _, ok := bundles[tag]; ok {
+		return tag, true
+	}
+	base, _, _ := strings.Cut(tag, "-")
+	if _, ok := bundles[base]; ok {
+		return base, true
+	}
+	return "", false
+}
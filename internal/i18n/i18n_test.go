@@ -0,0 +1,66 @@
+package i18n
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateBehavior(t *testing.T) {
+	catalog := New("en")
+
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		expected       string
+	}{
+		{name: "defaults when nothing requested", expected: "en"},
+		{name: "query parameter wins", query: "?lang=gl", acceptLanguage: "es", expected: "gl"},
+		{name: "matches base language of region tag", acceptLanguage: "es-ES,es;q=0.9", expected: "es"},
+		{name: "honours quality ordering", acceptLanguage: "fr;q=0.9, gl;q=0.5, es;q=0.7", expected: "es"},
+		{name: "skips unsupported languages", acceptLanguage: "de, fr", expected: "en"},
+		{name: "ignores unsupported query parameter", query: "?lang=xx", acceptLanguage: "gl", expected: "gl"},
+		{name: "ignores q=0 entries", acceptLanguage: "es;q=0, gl;q=0.1", expected: "gl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/i18n"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+
+			if got := catalog.Negotiate(req); got != tt.expected {
+				t.Errorf("expected locale %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMessageBehavior(t *testing.T) {
+	catalog := New("es")
+
+	if got := catalog.Message("gl", "errors.messageTooLong", 10); got != "Mensaxe demasiado longa (máximo 10 caracteres)" {
+		t.Errorf("unexpected formatted message: %s", got)
+	}
+	if got := catalog.Message("xx", "errors.modelRequired"); got != "El modelo es obligatorio" {
+		t.Errorf("unknown locale should use the default locale, got %s", got)
+	}
+	if got := catalog.Message("en", "missing.key"); got != "missing.key" {
+		t.Errorf("missing keys should return the key, got %s", got)
+	}
+	if New("xx").DefaultLocale() != FallbackLocale {
+		t.Error("unsupported default locale should fall back to English")
+	}
+}
+
+func TestBundlesHaveMatchingKeys(t *testing.T) {
+	reference := bundles[FallbackLocale]
+	for locale, messages := range bundles {
+		for key := range reference {
+			if _, ok := messages[key]; !ok {
+				t.Errorf("locale %s is missing key %s", locale, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package journal keeps an append-only record of message requests for
+// billing reconciliation: who sent each one, to which model, the tokens it
+// used and what it cost. Unlike the metrics, the journal survives restarts,
+// so an operator can rebuild spend for any period it covers. It never holds
+// content or keys, only key fingerprints.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+// Statuses a request can end with.
+const (
+	StatusOK         = "ok"
+	StatusIncomplete = "incomplete"
+	StatusBlocked    = "blocked"
+	StatusInvalid    = "invalid"
+	StatusError      = "error"
+)
+
+// Entry is one message request. Tokens and cost count what the provider
+// billed, so failed requests that still used tokens carry them too. CostUSD
+// is absent when no prices are configured for the model.
+type Entry struct {
+	Time           time.Time `json:"time"`
+	RequestID      string    `json:"request_id,omitempty"`
+	KeyFingerprint string    `json:"key_fingerprint"`
+	Provider       string    `json:"provider"`
+	Tenant         string    `json:"tenant,omitempty"`
+	Model          string    `json:"model"`
+	InputTokens    int       `json:"input_tokens"`
+	OutputTokens   int       `json:"output_tokens"`
+	CostUSD        *float64  `json:"cost_usd,omitempty"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Journal appends entries to a file as JSON lines, rotating it by size. A
+// nil *Journal records nothing.
+type Journal struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open returns the journal cfg describes, or nil when it is disabled.
+func Open(cfg config.JournalConfig) (*Journal, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	j := &Journal{path: cfg.File, maxSize: cfg.MaxSize, maxFiles: cfg.MaxFiles}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) open() error {
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	j.file, j.size = f, info.Size()
+	return nil
+}
+
+// Record appends e, rotating the file first if e would take it past its
+// maximum size. Failures are logged: a request isn't failed because it
+// couldn't be journaled.
+func (j *Journal) Record(e Entry) {
+	if j == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode journal entry", slog.String("error", err.Error()))
+		return
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.maxSize > 0 && j.size > 0 && j.size+int64(len(line)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			slog.Error("failed to rotate journal", slog.String("error", err.Error()))
+		}
+	}
+	if j.file == nil {
+		return
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		slog.Error("failed to write journal entry", slog.String("error", err.Error()))
+	}
+}
+
+// rotate moves the file to path.1, shifting older files up and dropping the
+// one past maxFiles, and starts a new file.
+func (j *Journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	j.file = nil
+	os.Remove(j.rotated(j.maxFiles))
+	for i := j.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(j.rotated(i), j.rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(j.path, j.rotated(1)); err != nil {
+		// Keep appending to the current file rather than losing entries.
+		if openErr := j.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	return j.open()
+}
+
+func (j *Journal) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", j.path, n)
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,113 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+// readEntries returns the entries in the journal file at path.
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("failed to parse %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func entry(requestID string) Entry {
+	cost := 0.0042
+	return Entry{
+		Time:           time.Now().UTC(),
+		RequestID:      requestID,
+		KeyFingerprint: "abc123",
+		Provider:       "anthropic",
+		Model:          "claude-3-5-haiku",
+		InputTokens:    1200,
+		OutputTokens:   300,
+		CostUSD:        &cost,
+		Status:         StatusOK,
+	}
+}
+
+func TestJournalBehavior(t *testing.T) {
+	t.Run("records nothing when disabled", func(t *testing.T) {
+		j, err := Open(config.JournalConfig{})
+		if err != nil || j != nil {
+			t.Fatalf("expected no journal, got %v %v", j, err)
+		}
+		j.Record(entry("req-1"))
+		if err := j.Close(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("appends entries across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.jsonl")
+		cfg := config.JournalConfig{Enabled: true, File: path, MaxFiles: 3}
+		for _, id := range []string{"req-1", "req-2"} {
+			j, err := Open(cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			j.Record(entry(id))
+			j.Close()
+		}
+
+		entries := readEntries(t, path)
+		if len(entries) != 2 || entries[0].RequestID != "req-1" || entries[1].RequestID != "req-2" {
+			t.Fatalf("unexpected entries: %+v", entries)
+		}
+		if e := entries[0]; e.KeyFingerprint != "abc123" || e.InputTokens != 1200 || e.CostUSD == nil || *e.CostUSD != 0.0042 || e.Status != StatusOK {
+			t.Errorf("unexpected entry: %+v", e)
+		}
+	})
+
+	t.Run("rotates by size and keeps the newest files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.jsonl")
+		line, _ := json.Marshal(entry("req-0"))
+		j, err := Open(config.JournalConfig{Enabled: true, File: path, MaxSize: int64(len(line)+1) * 2, MaxFiles: 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer j.Close()
+		for _, id := range []string{"req-0", "req-1", "req-2", "req-3", "req-4", "req-5", "req-6"} {
+			j.Record(entry(id))
+		}
+
+		for file, expected := range map[string][]string{
+			path:        {"req-6"},
+			path + ".1": {"req-4", "req-5"},
+			path + ".2": {"req-2", "req-3"},
+		} {
+			entries := readEntries(t, file)
+			if len(entries) != len(expected) {
+				t.Fatalf("%s: expected %d entries, got %+v", file, len(expected), entries)
+			}
+			for i, e := range entries {
+				if e.RequestID != expected[i] {
+					t.Errorf("%s: expected %s at %d, got %s", file, expected[i], i, e.RequestID)
+				}
+			}
+		}
+		if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+			t.Errorf("expected no third rotated file, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,430 @@
+// Package jsonschema validates JSON documents against the commonly used
+// subset of JSON Schema: type, enum, const, properties, required,
+// additionalProperties, items, the length, size and range bounds, pattern,
+// and allOf, anyOf, oneOf and not. Other keywords are ignored, as the
+// specification asks of unknown ones, except $ref, which is refused rather
+// than silently left unchecked.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled schema.
+type Schema struct {
+	never bool
+
+	types    []string
+	enum     []any
+	constant any
+	hasConst bool
+
+	properties   map[string]*Schema
+	required     []string
+	additional   *Schema
+	items        *Schema
+	minItems     *int
+	maxItems     *int
+	minLength    *int
+	maxLength    *int
+	minimum      *float64
+	maximum      *float64
+	exclusiveMin *float64
+	exclusiveMax *float64
+	pattern      *regexp.Regexp
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+}
+
+// ValidationError is one way a document fails its schema. Path is a JSON
+// Pointer to the offending value, empty for the document itself.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Compile parses a schema.
+func Compile(raw []byte) (*Schema, error) {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	return compile(v, "")
+}
+
+var knownTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+func compile(v any, path string) (*Schema, error) {
+	switch v := v.(type) {
+	case bool:
+		return &Schema{never: !v}, nil
+	case map[string]any:
+		s := &Schema{}
+		return s, s.compileKeywords(v, path)
+	default:
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", pointer(path))
+	}
+}
+
+func (s *Schema) compileKeywords(m map[string]any, path string) error {
+	if _, ok := m["$ref"]; ok {
+		return fmt.Errorf("%s: $ref is not supported", pointer(path))
+	}
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("%s: type must name types", pointer(path))
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return fmt.Errorf("%s: type must be a string or an array", pointer(path))
+	}
+	for _, t := range s.types {
+		if !knownTypes[t] {
+			return fmt.Errorf("%s: unknown type %q", pointer(path), t)
+		}
+	}
+
+	if enum, ok := m["enum"]; ok {
+		values, ok := enum.([]any)
+		if !ok {
+			return fmt.Errorf("%s: enum must be an array", pointer(path))
+		}
+		s.enum = values
+	}
+	s.constant, s.hasConst = m["const"]
+
+	if props, ok := m["properties"]; ok {
+		obj, ok := props.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: properties must be an object", pointer(path))
+		}
+		s.properties = make(map[string]*Schema, len(obj))
+		for name, sub := range obj {
+			compiled, err := compile(sub, path+"/properties/"+escape(name))
+			if err != nil {
+				return err
+			}
+			s.properties[name] = compiled
+		}
+	}
+	if req, ok := m["required"]; ok {
+		names, ok := req.([]any)
+		if !ok {
+			return fmt.Errorf("%s: required must be an array", pointer(path))
+		}
+		for _, name := range names {
+			n, ok := name.(string)
+			if !ok {
+				return fmt.Errorf("%s: required must list property names", pointer(path))
+			}
+			s.required = append(s.required, n)
+		}
+	}
+
+	var err error
+	if s.additional, err = compileOptional(m, "additionalProperties", path); err != nil {
+		return err
+	}
+	if s.items, err = compileOptional(m, "items", path); err != nil {
+		return err
+	}
+	if s.not, err = compileOptional(m, "not", path); err != nil {
+		return err
+	}
+	if s.allOf, err = compileList(m, "allOf", path); err != nil {
+		return err
+	}
+	if s.anyOf, err = compileList(m, "anyOf", path); err != nil {
+		return err
+	}
+	if s.oneOf, err = compileList(m, "oneOf", path); err != nil {
+		return err
+	}
+
+	for key, target := range map[string]**int{
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+	} {
+		if *target, err = count(m, key, path); err != nil {
+			return err
+		}
+	}
+	for key, target := range map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMin, "exclusiveMaximum": &s.exclusiveMax,
+	} {
+		if *target, err = number(m, key, path); err != nil {
+			return err
+		}
+	}
+
+	if p, ok := m["pattern"]; ok {
+		expr, ok := p.(string)
+		if !ok {
+			return fmt.Errorf("%s: pattern must be a string", pointer(path))
+		}
+		if s.pattern, err = regexp.Compile(expr); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", pointer(path), err)
+		}
+	}
+	return nil
+}
+
+func compileOptional(m map[string]any, key, path string) (*Schema, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	return compile(v, path+"/"+key)
+}
+
+func compileList(m map[string]any, key, path string) ([]*Schema, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	items, ok := v.([]any)
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("%s: %s must be a non-empty array", pointer(path), key)
+	}
+	schemas := make([]*Schema, len(items))
+	for i, item := range items {
+		compiled, err := compile(item, path+"/"+key+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		schemas[i] = compiled
+	}
+	return schemas, nil
+}
+
+func count(m map[string]any, key, path string) (*int, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, fmt.Errorf("%s: %s must be a non-negative integer", pointer(path), key)
+	}
+	n := int(f)
+	return &n, nil
+}
+
+func number(m map[string]any, key, path string) (*float64, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s must be a number", pointer(path), key)
+	}
+	return &f, nil
+}
+
+// Validate checks a JSON document against the schema. It returns nothing if
+// the document is valid.
+func (s *Schema) Validate(doc []byte) []ValidationError {
+	var v any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return []ValidationError{{Message: "not valid JSON: " + err.Error()}}
+	}
+	var errs []ValidationError
+	s.validate(v, "", &errs)
+	return errs
+}
+
+func (s *Schema) validate(v any, path string, errs *[]ValidationError) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.never {
+		fail("no value is allowed here")
+		return
+	}
+
+	if len(s.types) > 0 && !hasType(v, s.types) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if s.enum != nil && !contains(s.enum, v) {
+		fail("must be one of %s", marshal(s.enum))
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, v) {
+		fail("must be %s", marshal(s.constant))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := s.properties[name]; ok {
+				sub.validate(v[name], path+"/"+escape(name), errs)
+			} else if s.additional != nil {
+				if s.additional.never {
+					fail("unexpected property %q", name)
+				} else {
+					s.additional.validate(v[name], path+"/"+escape(name), errs)
+				}
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, path+"/"+strconv.Itoa(i), errs)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be at least %g", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be at most %g", *s.maximum)
+		}
+		if s.exclusiveMin != nil && v <= *s.exclusiveMin {
+			fail("must be greater than %g", *s.exclusiveMin)
+		}
+		if s.exclusiveMax != nil && v >= *s.exclusiveMax {
+			fail("must be less than %g", *s.exclusiveMax)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, errs)
+	}
+	if len(s.anyOf) > 0 && countMatches(s.anyOf, v) == 0 {
+		fail("must match at least one of the anyOf schemas")
+	}
+	if len(s.oneOf) > 0 {
+		if n := countMatches(s.oneOf, v); n != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", n)
+		}
+	}
+	if s.not != nil && countMatches([]*Schema{s.not}, v) == 1 {
+		fail("must not match the not schema")
+	}
+}
+
+func countMatches(schemas []*Schema, v any) int {
+	n := 0
+	for _, sub := range schemas {
+		var errs []ValidationError
+		sub.validate(v, "", &errs)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func hasType(v any, types []string) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func contains(values []any, v any) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func marshal(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// escape encodes a property name as a JSON Pointer segment.
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "schema"
+	}
+	return "schema at " + path
+}
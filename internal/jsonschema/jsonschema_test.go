@@ -0,0 +1,90 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBehavior(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[A-Z]"},
+			"age": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"enum": ["a", "b"]}, "maxItems": 2},
+			"kind": {"const": "person"},
+			"contact": {"anyOf": [{"type": "string"}, {"type": "null"}]}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatalf("failed to compile schema: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		doc      string
+		expected string
+	}{
+		{name: "valid document", doc: `{"name":"Ada","age":36,"tags":["a"],"kind":"person","contact":null}`},
+		{name: "not JSON", doc: `{"name":`, expected: "not valid JSON"},
+		{name: "wrong root type", doc: `[]`, expected: "expected object, got array"},
+		{name: "missing required property", doc: `{"name":"Ada"}`, expected: `missing required property "age"`},
+		{name: "integer with a fraction", doc: `{"name":"Ada","age":1.5}`, expected: "/age: expected integer, got number"},
+		{name: "below minimum", doc: `{"name":"Ada","age":-1}`, expected: "/age: must be at least 0"},
+		{name: "pattern mismatch", doc: `{"name":"ada","age":1}`, expected: "/name: must match ^[A-Z]"},
+		{name: "enum in items", doc: `{"name":"Ada","age":1,"tags":["c"]}`, expected: `/tags/0: must be one of ["a","b"]`},
+		{name: "too many items", doc: `{"name":"Ada","age":1,"tags":["a","b","a"]}`, expected: "/tags: must have at most 2 items"},
+		{name: "const", doc: `{"name":"Ada","age":1,"kind":"robot"}`, expected: `/kind: must be "person"`},
+		{name: "anyOf", doc: `{"name":"Ada","age":1,"contact":3}`, expected: "/contact: must match at least one of the anyOf schemas"},
+		{name: "additional property", doc: `{"name":"Ada","age":1,"extra":true}`, expected: `unexpected property "extra"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := schema.Validate([]byte(tt.doc))
+			if tt.expected == "" {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.expected) {
+				t.Errorf("expected one error containing %q, got %v", tt.expected, errs)
+			}
+		})
+	}
+}
+
+func TestCompileBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		expected string
+	}{
+		{name: "boolean schema", schema: `true`},
+		{name: "not JSON", schema: `{`, expected: "not valid JSON"},
+		{name: "not a schema", schema: `3`, expected: "must be an object or a boolean"},
+		{name: "unknown type", schema: `{"type":"date"}`, expected: `unknown type "date"`},
+		{name: "refs are refused", schema: `{"properties":{"a":{"$ref":"#/x"}}}`, expected: "schema at /properties/a: $ref is not supported"},
+		{name: "negative bound", schema: `{"minLength":-1}`, expected: "minLength must be a non-negative integer"},
+		{name: "bad pattern", schema: `{"pattern":"("}`, expected: "invalid pattern"},
+		{name: "empty anyOf", schema: `{"anyOf":[]}`, expected: "anyOf must be a non-empty array"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile([]byte(tt.schema))
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("expected schema to compile, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+// Package loadtest drives Manto's message path against an in-process mock
+// provider so operators can size instances before launch without spending
+// real tokens. Requests go through the real handler, validation, budget and
+// queueing code; only the upstream is simulated.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/handlers"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// Options configures a run.
+type Options struct {
+	Concurrency int
+	Requests    int
+	PayloadSize int
+	Latency     time.Duration
+	Model       string
+}
+
+// Report summarizes a run. Latencies are measured client-side, end to end
+// through Manto's handler.
+type Report struct {
+	Requests int
+	Errors   int
+	Duration time.Duration
+	P50      time.Duration
+	P95      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// Throughput is completed requests per second.
+func (r Report) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("requests=%d errors=%d duration=%s throughput=%.1f/s p50=%s p95=%s p99=%s max=%s",
+		r.Requests, r.Errors, r.Duration.Round(time.Millisecond), r.Throughput(),
+		r.P50.Round(time.Microsecond), r.P95.Round(time.Microsecond), r.P99.Round(time.Microsecond), r.Max.Round(time.Microsecond))
+}
+
+// MockProvider answers the Anthropic Messages API after latency, echoing a
+// short reply with usage roughly proportional to the request size.
+func MockProvider(latency time.Duration) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		var req services.MessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		input := 0
+		for _, m := range req.Messages {
+			input += len(m.Content) / 4
+		}
+		reply := "ok"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(services.MessageResponse{
+			ID:         "msg_loadtest",
+			Type:       "message",
+			Role:       "assistant",
+			Content:    []services.ContentBlock{{Type: "text", Text: &reply}},
+			Model:      req.Model,
+			StopReason: "end_turn",
+			Usage:      services.UsageInfo{InputTokens: input, OutputTokens: 1},
+		})
+	})
+	return r
+}
+
+// Run starts a mock provider and a Manto message handler configured from cfg,
+// then sends opts.Requests messages with opts.Concurrency workers.
+func Run(ctx context.Context, cfg *config.Config, opts Options) (Report, error) {
+	if opts.Concurrency < 1 || opts.Requests < 1 {
+		return Report{}, errors.New("concurrency and requests must be at least 1")
+	}
+
+	provider, err := serve(MockProvider(opts.Latency))
+	if err != nil {
+		return Report{}, err
+	}
+	defer provider.Close()
+
+	runCfg := *cfg
+	runCfg.Anthropic.BaseURL = "http://" + provider.Addr
+	api := handlers.NewAPIHandlers(&runCfg, services.NewAnthropicService(&runCfg))
+	router := chi.NewRouter()
+	router.Post("/api/messages", api.MessagesHandler)
+	router.Get("/api/queue/{id}", api.QueueHandler)
+
+	manto, err := serve(router)
+	if err != nil {
+		return Report{}, err
+	}
+	defer manto.Close()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"model":    opts.Model,
+		"messages": []services.Message{{Role: "user", Content: strings.Repeat("a", opts.PayloadSize)}},
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	var keyPrefix string
+	if prefixes := runCfg.Anthropic.KeyPrefixes(); len(prefixes) > 0 {
+		keyPrefix = prefixes[0]
+	}
+	apiKey := keyPrefix + strings.Repeat("0", runCfg.Security.APIKeyMinLength)
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: opts.Concurrency}}
+	baseURL := "http://" + manto.Addr
+	keyHeader := runCfg.Anthropic.ClientKeyHeader
+	if keyHeader == "" {
+		keyHeader = "x-api-key"
+	}
+
+	jobs := make(chan struct{})
+	latencies := make([]time.Duration, 0, opts.Requests)
+	failures := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				began := time.Now()
+				err := send(ctx, client, baseURL, keyHeader, apiKey, body)
+				elapsed := time.Since(began)
+
+				mu.Lock()
+				if err != nil {
+					failures++
+				} else {
+					latencies = append(latencies, elapsed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < opts.Requests; i++ {
+		select {
+		case jobs <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	report := Report{Requests: len(latencies) + failures, Errors: failures, Duration: time.Since(start)}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 50)
+	report.P95 = percentile(latencies, 95)
+	report.P99 = percentile(latencies, 99)
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+	return report, ctx.Err()
+}
+
+// send posts one message and, if it was queued, polls until it finishes.
+func send(ctx context.Context, client *http.Client, baseURL, keyHeader, apiKey string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/api/messages", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(keyHeader, apiKey)
+
+	for {
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusAccepted:
+			req, err = http.NewRequestWithContext(ctx, "GET", baseURL+resp.Header.Get("Location"), nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set(keyHeader, apiKey)
+			time.Sleep(10 * time.Millisecond)
+		case resp.StatusCode != http.StatusOK:
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		default:
+			return nil
+		}
+	}
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank
+// method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+type server struct {
+	Addr string
+	srv  *http.Server
+}
+
+func serve(handler http.Handler) (*server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(listener)
+	return &server{Addr: listener.Addr().String(), srv: srv}, nil
+}
+
+func (s *server) Close() {
+	s.srv.Close()
+}
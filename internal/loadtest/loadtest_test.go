@@ -0,0 +1,57 @@
+package loadtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	tests := []struct {
+		p        int
+		expected time.Duration
+	}{
+		{p: 50, expected: 50 * time.Millisecond},
+		{p: 95, expected: 95 * time.Millisecond},
+		{p: 99, expected: 99 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.expected {
+			t.Errorf("p%d: expected %s, got %s", tt.p, tt.expected, got)
+		}
+	}
+	if percentile(nil, 50) != 0 {
+		t.Error("empty input should give 0")
+	}
+}
+
+func TestRunBehavior(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Anthropic.KeyPrefix = "sk-ant-"
+	cfg.Anthropic.MaxTokens = 16
+	cfg.Anthropic.Timeout = config.Duration{Duration: 5 * time.Second}
+	cfg.Validation.MaxMessageLength = 1000
+	cfg.Security.APIKeyMinLength = 10
+	cfg.Tokens.CountCacheSize = 1
+	cfg.Queue.Concurrency = 2
+	cfg.Queue.MaxWaiting = 100
+	cfg.Queue.ResultTTL = config.Duration{Duration: time.Minute}
+
+	report, err := Run(context.Background(), cfg, Options{Concurrency: 5, Requests: 20, PayloadSize: 50, Latency: time.Millisecond, Model: "m"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Requests != 20 || report.Errors != 0 {
+		t.Errorf("expected 20 successful requests, got %+v", report)
+	}
+	if report.P50 <= 0 || report.P50 > report.P99 || report.P99 > report.Max {
+		t.Errorf("expected ordered percentiles, got %+v", report)
+	}
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+// level is shared by every logger New builds, so SetLevel can change it at
+// runtime.
+var level = new(slog.LevelVar)
+
+// New builds the process logger from the logging config. Level and format
+// have already been validated by config.Load.
+func New(cfg config.LoggingConfig, w io.Writer) *slog.Logger {
+	SetLevel(cfg.Level)
+	opts := &slog.HandlerOptions{
+		Level:     level,
+		AddSource: cfg.IncludeSource,
+	}
+	if !cfg.IncludeTimestamp {
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+	}
+
+	if cfg.Format == "text" {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// SetLevel changes the minimum level logged, e.g. when LOG_LEVEL is reloaded.
+func SetLevel(name string) {
+	level.Set(parseLevel(name))
+}
+
+func parseLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
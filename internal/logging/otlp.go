@@ -0,0 +1,403 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/tracing"
+)
+
+const (
+	// otlpQueueSize bounds the records waiting for export. Records logged
+	// while it is full are dropped rather than holding up the caller.
+	otlpQueueSize = 4096
+	// otlpBatchSize records are sent together, or whatever is waiting once
+	// otlpFlushInterval has passed.
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+	otlpTimeout       = 10 * time.Second
+	otlpScope         = "github.com/manto/manto-web"
+)
+
+// OTLPExporter sends log records to an OpenTelemetry collector over
+// OTLP/HTTP with JSON encoding, in batches from a background goroutine.
+// Export failures are reported once, to the fallback logger, until exports
+// succeed again; the records are dropped and counted.
+type OTLPExporter struct {
+	url      string
+	headers  map[string]string
+	resource otlpResource
+	client   *http.Client
+	fallback *slog.Logger
+	exported *metrics.CounterVec
+	dropped  *metrics.CounterVec
+
+	queue chan otlpRecord
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+
+	failing bool
+}
+
+// NewOTLPExporter starts exporting to the collector cfg names. Problems with
+// the collector are logged to fallback, which must not lead back to the
+// exporter.
+func NewOTLPExporter(cfg config.LoggingConfig, fallback *slog.Logger, registry *metrics.Registry) *OTLPExporter {
+	e := &OTLPExporter{
+		url:     strings.TrimSuffix(cfg.OTLPEndpoint, "/") + "/v1/logs",
+		headers: cfg.OTLPHeaders,
+		resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: &cfg.ServiceName}},
+		}},
+		client:   &http.Client{Timeout: otlpTimeout},
+		fallback: fallback,
+		exported: registry.Counter("manto_otlp_log_records_exported_total", "Log records exported over OTLP."),
+		dropped:  registry.Counter("manto_otlp_log_records_dropped_total", "Log records not exported over OTLP, by reason (queue_full, export_failed).", "reason"),
+		queue:    make(chan otlpRecord, otlpQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Handler returns a slog.Handler queueing records for export, at the level
+// shared by every logger New builds.
+func (e *OTLPExporter) Handler() slog.Handler {
+	return &otlpHandler{exporter: e}
+}
+
+// Close exports the records still queued, waiting until ctx is done at most.
+func (e *OTLPExporter) Close(ctx context.Context) error {
+	e.once.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) enqueue(record otlpRecord) {
+	select {
+	case e.queue <- record:
+	default:
+		e.dropped.Inc("queue_full")
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]otlpRecord, 0, otlpBatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case record := <-e.queue:
+			batch = append(batch, record)
+			if len(batch) == otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case record := <-e.queue:
+					batch = append(batch, record)
+					if len(batch) == otlpBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// export posts batch as one ExportLogsServiceRequest.
+func (e *OTLPExporter) export(batch []otlpRecord) {
+	err := e.post(otlpRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource:  e.resource,
+		ScopeLogs: []otlpScopeLogs{{Scope: otlpInstrumentationScope{Name: otlpScope}, LogRecords: batch}},
+	}}})
+	if err != nil {
+		e.dropped.Add(float64(len(batch)), "export_failed")
+		if !e.failing {
+			e.fallback.Warn("OTLP log export failed", slog.String("url", e.url), slog.String("error", err.Error()))
+		}
+		e.failing = true
+		return
+	}
+	e.exported.Add(float64(len(batch)))
+	if e.failing {
+		e.fallback.Info("OTLP log export recovered", slog.String("url", e.url))
+	}
+	e.failing = false
+}
+
+func (e *OTLPExporter) post(request otlpRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode logs: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("network error: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpHandler turns slog records into OTLP log records. Attributes in
+// groups are flattened to dotted keys, as OpenTelemetry names them.
+type otlpHandler struct {
+	exporter *OTLPExporter
+	attrs    []otlpAttribute
+	group    string
+}
+
+func (h *otlpHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *otlpHandler) Handle(ctx context.Context, r slog.Record) error {
+	message := r.Message
+	severity, text := otlpSeverity(r.Level)
+	record := otlpRecord{
+		TimeUnixNano:         strconv.FormatInt(r.Time.UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		SeverityNumber:       severity,
+		SeverityText:         text,
+		Body:                 otlpValue{StringValue: &message},
+		Attributes:           append([]otlpAttribute(nil), h.attrs...),
+	}
+	if r.Time.IsZero() {
+		record.TimeUnixNano = record.ObservedTimeUnixNano
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		record.Attributes = appendAttr(record.Attributes, h.group, a)
+		return true
+	})
+	if ctx != nil {
+		if sc, ok := tracing.FromContext(ctx); ok {
+			record.TraceID, record.SpanID = sc.TraceID, sc.SpanID
+		}
+	}
+	h.exporter.enqueue(record)
+	return nil
+}
+
+func (h *otlpHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append([]otlpAttribute(nil), h.attrs...)
+	for _, a := range attrs {
+		next.attrs = appendAttr(next.attrs, h.group, a)
+	}
+	return &next
+}
+
+func (h *otlpHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.group = h.group + name + "."
+	return &next
+}
+
+// appendAttr adds a, under prefix, skipping empty attributes as slog does.
+func appendAttr(attrs []otlpAttribute, prefix string, a slog.Attr) []otlpAttribute {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, member := range a.Value.Group() {
+			attrs = appendAttr(attrs, prefix, member)
+		}
+		return attrs
+	}
+	return append(attrs, otlpAttribute{Key: prefix + a.Key, Value: otlpValueOf(a.Value)})
+}
+
+func otlpValueOf(v slog.Value) otlpValue {
+	switch v.Kind() {
+	case slog.KindInt64:
+		n := strconv.FormatInt(v.Int64(), 10)
+		return otlpValue{IntValue: &n}
+	case slog.KindUint64:
+		n := strconv.FormatUint(v.Uint64(), 10)
+		return otlpValue{IntValue: &n}
+	case slog.KindFloat64:
+		f := v.Float64()
+		return otlpValue{DoubleValue: &f}
+	case slog.KindBool:
+		b := v.Bool()
+		return otlpValue{BoolValue: &b}
+	case slog.KindTime:
+		s := v.Time().Format(time.RFC3339Nano)
+		return otlpValue{StringValue: &s}
+	case slog.KindAny:
+		var s string
+		switch any := v.Any().(type) {
+		case error:
+			s = any.Error()
+		case fmt.Stringer:
+			s = any.String()
+		default:
+			data, err := json.Marshal(any)
+			if err != nil {
+				s = fmt.Sprint(any)
+			} else {
+				s = string(data)
+			}
+		}
+		return otlpValue{StringValue: &s}
+	default:
+		s := v.String()
+		return otlpValue{StringValue: &s}
+	}
+}
+
+// otlpSeverity maps a slog level to OpenTelemetry's severity number and
+// text.
+func otlpSeverity(l slog.Level) (int, string) {
+	switch {
+	case l >= slog.LevelError:
+		return 17, "ERROR"
+	case l >= slog.LevelWarn:
+		return 13, "WARN"
+	case l >= slog.LevelInfo:
+		return 9, "INFO"
+	default:
+		return 5, "DEBUG"
+	}
+}
+
+// Tee returns a handler passing each record to every handler enabled for
+// it.
+func Tee(handlers ...slog.Handler) slog.Handler {
+	return teeHandler(handlers)
+}
+
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := make(teeHandler, len(t))
+	for i, h := range t {
+		next[i] = h.WithAttrs(attrs)
+	}
+	return next
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	next := make(teeHandler, len(t))
+	for i, h := range t {
+		next[i] = h.WithGroup(name)
+	}
+	return next
+}
+
+// The OTLP/JSON shapes of an ExportLogsServiceRequest. IDs are hex and
+// 64-bit integers are strings, as the JSON encoding specifies.
+type otlpRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpInstrumentationScope `json:"scope"`
+	LogRecords []otlpRecord             `json:"logRecords"`
+}
+
+type otlpInstrumentationScope struct {
+	Name string `json:"name"`
+}
+
+type otlpRecord struct {
+	TimeUnixNano         string          `json:"timeUnixNano"`
+	ObservedTimeUnixNano string          `json:"observedTimeUnixNano"`
+	SeverityNumber       int             `json:"severityNumber"`
+	SeverityText         string          `json:"severityText"`
+	Body                 otlpValue       `json:"body"`
+	Attributes           []otlpAttribute `json:"attributes,omitempty"`
+	TraceID              string          `json:"traceId,omitempty"`
+	SpanID               string          `json:"spanId,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/tracing"
+)
+
+func TestOTLPExporterBehavior(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []otlpRequest
+		auth     string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected export: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode export: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		auth = r.Header.Get("Authorization")
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	cfg := config.LoggingConfig{
+		Level:        "info",
+		OTLP:         true,
+		OTLPEndpoint: collector.URL + "/",
+		OTLPHeaders:  map[string]string{"Authorization": "Bearer token"},
+		ServiceName:  "manto-test",
+	}
+	registry := metrics.NewRegistry()
+	exporter := NewOTLPExporter(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), registry)
+	logger := slog.New(exporter.Handler()).With(slog.String("component", "test")).WithGroup("request")
+
+	ctx := tracing.NewContext(context.Background(), tracing.SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true})
+	logger.WarnContext(ctx, "slow request", slog.Int("status", 200), slog.Group("timing", slog.Duration("total", time.Second)))
+	logger.Debug("not exported")
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Close(closeCtx); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || auth != "Bearer token" {
+		t.Fatalf("expected one authorized export, got %d (auth %q)", len(requests), auth)
+	}
+	resource := requests[0].ResourceLogs[0]
+	if got := *resource.Resource.Attributes[0].Value.StringValue; got != "manto-test" {
+		t.Errorf("expected service.name manto-test, got %q", got)
+	}
+	records := resource.ScopeLogs[0].LogRecords
+	if len(records) != 1 {
+		t.Fatalf("expected only the warning to be exported, got %d records", len(records))
+	}
+	record := records[0]
+	if *record.Body.StringValue != "slow request" || record.SeverityNumber != 13 || record.SeverityText != "WARN" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+	if record.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || record.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected the record to be linked to its trace, got %q/%q", record.TraceID, record.SpanID)
+	}
+	var keys []string
+	for _, a := range record.Attributes {
+		keys = append(keys, a.Key)
+	}
+	if got := strings.Join(keys, ","); got != "component,request.status,request.timing.total" {
+		t.Errorf("unexpected attribute keys: %s", got)
+	}
+}
+
+func TestOTLPExporterDropsOnFailure(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	var fallback strings.Builder
+	registry := metrics.NewRegistry()
+	exporter := NewOTLPExporter(config.LoggingConfig{OTLPEndpoint: collector.URL, ServiceName: "manto-test"}, slog.New(slog.NewTextHandler(&fallback, nil)), registry)
+	logger := slog.New(exporter.Handler())
+	logger.Error("first")
+	logger.Error("second")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Close(ctx); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	if strings.Count(fallback.String(), "OTLP log export failed") != 1 {
+		t.Errorf("expected the failure to be reported once, got %q", fallback.String())
+	}
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `manto_otlp_log_records_dropped_total{reason="export_failed"} 2`) {
+		t.Errorf("expected two dropped records, got:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,374 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry is a minimal in-process metrics store that renders the Prometheus
+// text exposition format, or OpenMetrics for scrapers that ask for it. It
+// intentionally supports only what Manto needs: counters, gauges and
+// histograms, with exemplars on histogram buckets.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]metric
+}
+
+// metric is a registered counter, gauge or histogram. openMetrics selects the
+// OpenMetrics format, the only one that carries exemplars.
+type metric interface {
+	write(b *strings.Builder, openMetrics bool)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the process-wide registry served on the metrics endpoint.
+var Default = NewRegistry()
+
+// CounterVec is a monotonically increasing counter partitioned by labels.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Counter returns the counter registered under name, creating it on first use.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		return m.(*CounterVec)
+	}
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	r.metrics[name] = c
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current count for the given label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) key(labelValues []string) string {
+	return labelKey(c.name, c.labels, labelValues)
+}
+
+func labelKey(name string, labels, labelValues []string) string {
+	if len(labelValues) != len(labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", name, len(labels), len(labelValues)))
+	}
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = fmt.Sprintf("%s=%q", label, labelValues[i])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (c *CounterVec) write(b *strings.Builder, openMetrics bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	family, kind := c.name, "counter"
+	if openMetrics {
+		// OpenMetrics names the counter family without the _total its
+		// samples carry, and has no counters without it.
+		if trimmed := strings.TrimSuffix(c.name, "_total"); trimmed != c.name {
+			family = trimmed
+		} else {
+			kind = "unknown"
+		}
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", family, c.help, family, kind)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(b, "%s %g\n", c.name, c.values[key])
+		} else {
+			fmt.Fprintf(b, "%s{%s} %g\n", c.name, key, c.values[key])
+		}
+	}
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Gauge returns the gauge registered under name, creating it on first use.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		return m.(*GaugeVec)
+	}
+	g := &GaugeVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	r.metrics[name] = g
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	key := labelKey(g.name, g.labels, labelValues)
+	g.mu.Lock()
+	g.values[key] = value
+	g.mu.Unlock()
+}
+
+// Delete drops the series for the given label values, e.g. once what it
+// measured has gone away.
+func (g *GaugeVec) Delete(labelValues ...string) {
+	key := labelKey(g.name, g.labels, labelValues)
+	g.mu.Lock()
+	delete(g.values, key)
+	g.mu.Unlock()
+}
+
+// Value returns the current value for the given label values.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	key := labelKey(g.name, g.labels, labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[key]
+}
+
+func (g *GaugeVec) write(b *strings.Builder, _ bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	keys := make([]string, 0, len(g.values))
+	for key := range g.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(b, "%s %g\n", g.name, g.values[key])
+		} else {
+			fmt.Fprintf(b, "%s{%s} %g\n", g.name, key, g.values[key])
+		}
+	}
+}
+
+// HistogramVec counts observations into cumulative buckets, partitioned by
+// labels.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts    []uint64 // per bucket, not cumulative; the last is +Inf
+	sum       float64
+	exemplars []*exemplar // the latest per bucket, if any
+}
+
+// exemplar links an observation to where it came from, e.g. a trace.
+type exemplar struct {
+	labels string
+	value  float64
+	time   time.Time
+}
+
+// Histogram returns the histogram registered under name, creating it with
+// the given upper bucket bounds, in increasing order, on first use.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		return m.(*HistogramVec)
+	}
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	r.metrics[name] = h
+	return h
+}
+
+// ExponentialBuckets returns count bucket bounds starting at start, each
+// factor times the last.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Observe records value for the given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.ObserveWithExemplar(value, nil, labelValues...)
+}
+
+// ObserveWithExemplar records value like Observe and, when exemplarLabels
+// isn't empty, keeps it as the exemplar of its bucket with those labels,
+// such as a trace_id. Each bucket keeps only its latest exemplar.
+func (h *HistogramVec) ObserveWithExemplar(value float64, exemplarLabels map[string]string, labelValues ...string) {
+	key := labelKey(h.name, h.labels, labelValues)
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets)+1), exemplars: make([]*exemplar, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.sum += value
+	if len(exemplarLabels) > 0 {
+		s.exemplars[i] = &exemplar{labels: exemplarKey(exemplarLabels), value: value, time: time.Now()}
+	}
+}
+
+// exemplarKey renders exemplar labels in name order.
+func exemplarKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, labels[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Count returns how many values were observed for the given label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	key := labelKey(h.name, h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var total uint64
+	if s, ok := h.series[key]; ok {
+		for _, n := range s.counts {
+			total += n
+		}
+	}
+	return total
+}
+
+func (h *HistogramVec) write(b *strings.Builder, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		prefix := key
+		if prefix != "" {
+			prefix += ","
+		}
+		var cumulative uint64
+		for i, n := range s.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = fmt.Sprintf("%g", h.buckets[i])
+			}
+			fmt.Fprintf(b, "%s_bucket{%sle=%q} %d", h.name, prefix, le, cumulative)
+			if e := s.exemplars[i]; openMetrics && e != nil {
+				fmt.Fprintf(b, " # {%s} %g %.3f", e.labels, e.value, float64(e.time.UnixMilli())/1000)
+			}
+			b.WriteByte('\n')
+		}
+		if key == "" {
+			fmt.Fprintf(b, "%s_sum %g\n%s_count %d\n", h.name, s.sum, h.name, cumulative)
+		} else {
+			fmt.Fprintf(b, "%s_sum{%s} %g\n%s_count{%s} %d\n", h.name, key, s.sum, h.name, key, cumulative)
+		}
+	}
+}
+
+// Handler serves every registered metric in Prometheus text format, or in
+// OpenMetrics, with exemplars, when the scraper accepts it.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+
+		r.mu.RLock()
+		names := make([]string, 0, len(r.metrics))
+		for name := range r.metrics {
+			names = append(names, name)
+		}
+		r.mu.RUnlock()
+		sort.Strings(names)
+
+		var b strings.Builder
+		for _, name := range names {
+			r.mu.RLock()
+			m := r.metrics[name]
+			r.mu.RUnlock()
+			m.write(&b, openMetrics)
+		}
+
+		if openMetrics {
+			b.WriteString("# EOF\n")
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(b.String()))
+	})
+}
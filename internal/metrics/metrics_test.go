@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryHandlerBehavior(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("test_requests_total", "Test requests.", "route")
+	requests.Inc("/a")
+	requests.Add(2, "/b")
+	registry.Counter("test_plain_total", "Unlabelled counter.").Inc()
+
+	if registry.Counter("test_requests_total", "ignored") != requests {
+		t.Error("Counter should return the existing metric for a registered name")
+	}
+
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="/a"} 1`,
+		`test_requests_total{route="/b"} 2`,
+		"test_plain_total 1",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestHistogramBehavior(t *testing.T) {
+	registry := NewRegistry()
+	sizes := registry.Histogram("test_body_bytes", "Test body sizes.", ExponentialBuckets(100, 10, 3), "route")
+	sizes.Observe(50, "/a")
+	sizes.Observe(100, "/a")
+	sizes.Observe(5000, "/a")
+	sizes.Observe(1e6, "/a")
+
+	if registry.Histogram("test_body_bytes", "ignored", nil) != sizes {
+		t.Error("Histogram should return the existing metric for a registered name")
+	}
+	if got := sizes.Count("/a"); got != 4 {
+		t.Errorf("expected 4 observations, got %d", got)
+	}
+
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE test_body_bytes histogram",
+		`test_body_bytes_bucket{route="/a",le="100"} 2`,
+		`test_body_bytes_bucket{route="/a",le="1000"} 2`,
+		`test_body_bytes_bucket{route="/a",le="10000"} 3`,
+		`test_body_bytes_bucket{route="/a",le="+Inf"} 4`,
+		`test_body_bytes_sum{route="/a"} 1.00515e+06`,
+		`test_body_bytes_count{route="/a"} 4`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestExemplarBehavior(t *testing.T) {
+	registry := NewRegistry()
+	latency := registry.Histogram("test_duration_seconds", "Test latencies.", []float64{0.1, 1}, "route")
+	latency.ObserveWithExemplar(0.05, map[string]string{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"}, "/a")
+	latency.ObserveWithExemplar(0.07, map[string]string{"trace_id": "0af7651916cd43dd8448eb211c80319c"}, "/a")
+	latency.Observe(0.5, "/a")
+	registry.Counter("test_requests_total", "Test requests.").Inc()
+	registry.Counter("test_legacy", "Counter without the suffix.").Inc()
+
+	scrape := func(accept string) string {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		registry.Handler().ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	t.Run("serves the latest exemplar per bucket in OpenMetrics", func(t *testing.T) {
+		body := scrape("application/openmetrics-text; version=1.0.0,text/plain;q=0.5")
+		for _, want := range []string{
+			`test_duration_seconds_bucket{route="/a",le="0.1"} 2 # {trace_id="0af7651916cd43dd8448eb211c80319c"} 0.07 `,
+			`test_duration_seconds_bucket{route="/a",le="1"} 3` + "\n",
+			"# TYPE test_requests counter\ntest_requests_total 1\n",
+			"# TYPE test_legacy unknown\ntest_legacy 1\n",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected output to contain %q, got:\n%s", want, body)
+			}
+		}
+		if !strings.HasSuffix(body, "# EOF\n") {
+			t.Errorf("expected the output to end with # EOF, got:\n%s", body)
+		}
+	})
+
+	t.Run("leaves exemplars out of the Prometheus text format", func(t *testing.T) {
+		body := scrape("text/plain")
+		if strings.Contains(body, "trace_id") || strings.Contains(body, "# EOF") {
+			t.Errorf("expected plain Prometheus text, got:\n%s", body)
+		}
+		if !strings.Contains(body, `test_duration_seconds_bucket{route="/a",le="0.1"} 2`+"\n") {
+			t.Errorf("expected the bucket, got:\n%s", body)
+		}
+	})
+}
@@ -0,0 +1,296 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+// The objectives upstream calls are held to, as the slo label names them.
+const (
+	SLOAvailability = "availability"
+	SLOLatency      = "latency"
+)
+
+// sloBucketsPerWindow is how many buckets the short window is split into.
+// The long window is counted in buckets of the same width.
+const sloBucketsPerWindow = 10
+
+// SLO follows upstream calls per provider and model, and exposes how fast
+// each objective is burning its error budget over a short and a long window:
+// a burn rate of 1 spends the budget exactly by the end of the objective's
+// period. Alerts fire when both windows burn at the configured threshold or
+// more, so a brief spike doesn't page anyone and a recovery clears soon.
+// A nil *SLO records nothing.
+type SLO struct {
+	cfg         config.SLOConfig
+	width       time.Duration
+	longBuckets int
+	windows     [2]string // short and long, as the window label names them
+	client      *http.Client
+	now         func() time.Time
+
+	burnRate *GaugeVec
+	alerting *GaugeVec
+
+	mu     sync.Mutex
+	series map[sloKey]*sloSeries
+}
+
+type sloKey struct {
+	provider, model string
+}
+
+// sloSeries is a ring of buckets covering the long window.
+type sloSeries struct {
+	buckets []sloBucket
+	firing  map[string]bool // by objective
+}
+
+type sloBucket struct {
+	n         int64 // the bucket's number since the epoch, in bucket widths
+	total     int
+	failed    int
+	succeeded int
+	slow      int
+}
+
+// Alert is posted to the SLO alert webhook when an objective starts, or
+// stops, burning its error budget too fast.
+type Alert struct {
+	Status        string    `json:"status"` // "firing" or "resolved"
+	Provider      string    `json:"provider"`
+	Model         string    `json:"model"`
+	SLO           string    `json:"slo"`
+	Target        float64   `json:"target"`
+	ShortWindow   string    `json:"short_window"`
+	ShortBurnRate float64   `json:"short_burn_rate"`
+	LongWindow    string    `json:"long_window"`
+	LongBurnRate  float64   `json:"long_burn_rate"`
+	Threshold     float64   `json:"threshold"`
+	Time          time.Time `json:"time"`
+}
+
+// NewSLO returns a tracker registering its gauges in registry, or nil when
+// cfg doesn't enable it.
+func NewSLO(cfg config.SLOConfig, registry *Registry) *SLO {
+	if !cfg.Enabled {
+		return nil
+	}
+	width := cfg.ShortWindow.Duration / sloBucketsPerWindow
+	return &SLO{
+		cfg:         cfg,
+		width:       width,
+		longBuckets: int((cfg.LongWindow.Duration + width - 1) / width),
+		windows:     [2]string{windowLabel(cfg.ShortWindow.Duration), windowLabel(cfg.LongWindow.Duration)},
+		client:      &http.Client{Timeout: 5 * time.Second},
+		now:         time.Now,
+		burnRate:    registry.Gauge("manto_slo_burn_rate", "Rate at which upstream calls burn an SLO's error budget, by provider, model, objective (availability, latency) and window.", "provider", "model", "slo", "window"),
+		alerting:    registry.Gauge("manto_slo_alert", "Whether an SLO burn-rate alert is firing, by provider, model and objective.", "provider", "model", "slo"),
+		series:      make(map[sloKey]*sloSeries),
+	}
+}
+
+// Observe records an upstream call to model on provider that took latency.
+// Only successful calls count towards the latency objective.
+func (s *SLO) Observe(provider, model string, latency time.Duration, failed bool) {
+	if s == nil {
+		return
+	}
+	n := s.bucket(s.now())
+	key := sloKey{provider, model}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	series, ok := s.series[key]
+	if !ok {
+		series = &sloSeries{buckets: make([]sloBucket, s.longBuckets), firing: make(map[string]bool)}
+		s.series[key] = series
+	}
+	b := &series.buckets[n%int64(len(series.buckets))]
+	if b.n != n {
+		*b = sloBucket{n: n}
+	}
+	b.total++
+	if failed {
+		b.failed++
+		return
+	}
+	b.succeeded++
+	if latency > s.cfg.LatencyThreshold.Duration {
+		b.slow++
+	}
+}
+
+// Start evaluates the burn rates once per bucket width until stop is
+// closed.
+func (s *SLO) Start(stop <-chan struct{}) {
+	if s == nil {
+		return
+	}
+	ticker := time.NewTicker(s.width)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.Evaluate()
+			}
+		}
+	}()
+}
+
+// Evaluate updates the gauges and raises or resolves alerts. Series without
+// calls in the long window are dropped.
+func (s *SLO) Evaluate() {
+	now := s.now()
+	n := s.bucket(now)
+
+	var alerts []Alert
+	s.mu.Lock()
+	for key, series := range s.series {
+		long := series.sum(n, s.longBuckets)
+		if long.total == 0 {
+			delete(s.series, key)
+			for _, slo := range []string{SLOAvailability, SLOLatency} {
+				s.burnRate.Delete(key.provider, key.model, slo, s.windows[0])
+				s.burnRate.Delete(key.provider, key.model, slo, s.windows[1])
+				s.alerting.Delete(key.provider, key.model, slo)
+			}
+			continue
+		}
+		short := series.sum(n, sloBucketsPerWindow)
+		for _, objective := range []struct {
+			slo                string
+			target             float64
+			shortBad, shortAll int
+			longBad, longAll   int
+		}{
+			{SLOAvailability, s.cfg.AvailabilityTarget, short.failed, short.total, long.failed, long.total},
+			{SLOLatency, s.cfg.LatencyTarget, short.slow, short.succeeded, long.slow, long.succeeded},
+		} {
+			shortRate := burnRate(objective.shortBad, objective.shortAll, objective.target)
+			longRate := burnRate(objective.longBad, objective.longAll, objective.target)
+			s.burnRate.Set(shortRate, key.provider, key.model, objective.slo, s.windows[0])
+			s.burnRate.Set(longRate, key.provider, key.model, objective.slo, s.windows[1])
+
+			firing := objective.shortAll >= s.cfg.MinRequests &&
+				shortRate >= s.cfg.BurnRateThreshold && longRate >= s.cfg.BurnRateThreshold
+			if firing {
+				s.alerting.Set(1, key.provider, key.model, objective.slo)
+			} else {
+				s.alerting.Set(0, key.provider, key.model, objective.slo)
+			}
+			if firing == series.firing[objective.slo] {
+				continue
+			}
+			series.firing[objective.slo] = firing
+			alert := Alert{
+				Status:        "resolved",
+				Provider:      key.provider,
+				Model:         key.model,
+				SLO:           objective.slo,
+				Target:        objective.target,
+				ShortWindow:   s.windows[0],
+				ShortBurnRate: shortRate,
+				LongWindow:    s.windows[1],
+				LongBurnRate:  longRate,
+				Threshold:     s.cfg.BurnRateThreshold,
+				Time:          now.UTC(),
+			}
+			if firing {
+				alert.Status = "firing"
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, alert := range alerts {
+		s.raise(alert)
+	}
+}
+
+// raise logs alert and, when a webhook is configured, posts it there.
+func (s *SLO) raise(alert Alert) {
+	attrs := []any{
+		slog.String("provider", alert.Provider),
+		slog.String("model", alert.Model),
+		slog.String("slo", alert.SLO),
+		slog.Float64("short_burn_rate", alert.ShortBurnRate),
+		slog.Float64("long_burn_rate", alert.LongBurnRate),
+	}
+	if alert.Status == "firing" {
+		slog.Warn("SLO error budget burning too fast", attrs...)
+	} else {
+		slog.Info("SLO burn rate back under threshold", attrs...)
+	}
+	if s.cfg.AlertWebhookURL != "" {
+		go s.post(alert)
+	}
+}
+
+func (s *SLO) post(alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	resp, err := s.client.Post(s.cfg.AlertWebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("failed to post SLO alert", slog.String("error", err.Error()))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("SLO alert webhook rejected alert", slog.Int("status", resp.StatusCode))
+	}
+}
+
+func (s *SLO) bucket(t time.Time) int64 {
+	return t.UnixNano() / int64(s.width)
+}
+
+// sum adds up the last count buckets up to and including bucket n.
+func (series *sloSeries) sum(n int64, count int) sloBucket {
+	var total sloBucket
+	for _, b := range series.buckets {
+		if b.n > n-int64(count) && b.n <= n {
+			total.total += b.total
+			total.failed += b.failed
+			total.succeeded += b.succeeded
+			total.slow += b.slow
+		}
+	}
+	return total
+}
+
+// burnRate is the share of bad events over the share the target allows,
+// rounded so that 1-0.99 isn't taken for a hair over 0.01.
+func burnRate(bad, all int, target float64) float64 {
+	if all == 0 {
+		return 0
+	}
+	return math.Round(float64(bad)/float64(all)/(1-target)*1e9) / 1e9
+}
+
+// windowLabel writes d without zero trailing units, e.g. 5m rather than
+// 5m0s.
+func windowLabel(d time.Duration) string {
+	label := d.String()
+	if strings.HasSuffix(label, "m0s") {
+		label = strings.TrimSuffix(label, "0s")
+	}
+	if strings.HasSuffix(label, "h0m") {
+		label = strings.TrimSuffix(label, "0m")
+	}
+	return label
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func createTestSLOConfig() config.SLOConfig {
+	return config.SLOConfig{
+		Enabled:            true,
+		AvailabilityTarget: 0.99,
+		LatencyTarget:      0.9,
+		LatencyThreshold:   config.Duration{Duration: 10 * time.Second},
+		ShortWindow:        config.Duration{Duration: 5 * time.Minute},
+		LongWindow:         config.Duration{Duration: time.Hour},
+		BurnRateThreshold:  10,
+		MinRequests:        5,
+	}
+}
+
+func TestSLOBehavior(t *testing.T) {
+	tests := []struct {
+		name           string
+		observe        func(slo *SLO, now *time.Time)
+		slo            string
+		expectAlert    float64
+		expectShortMin float64
+	}{
+		{
+			name: "fires when both windows burn fast",
+			observe: func(slo *SLO, now *time.Time) {
+				for i := range 10 {
+					slo.Observe("anthropic", "claude", time.Second, i%2 == 0)
+				}
+			},
+			slo:            SLOAvailability,
+			expectAlert:    1,
+			expectShortMin: 50,
+		},
+		{
+			name: "stays quiet below the minimum request count",
+			observe: func(slo *SLO, now *time.Time) {
+				slo.Observe("anthropic", "claude", time.Second, true)
+				slo.Observe("anthropic", "claude", time.Second, true)
+			},
+			slo:            SLOAvailability,
+			expectShortMin: 100,
+		},
+		{
+			name: "stays quiet when the short window has recovered",
+			observe: func(slo *SLO, now *time.Time) {
+				for range 10 {
+					slo.Observe("anthropic", "claude", time.Second, true)
+				}
+				*now = now.Add(10 * time.Minute)
+				for range 10 {
+					slo.Observe("anthropic", "claude", time.Second, false)
+				}
+			},
+			slo: SLOAvailability,
+		},
+		{
+			name: "counts slow successful calls against latency",
+			observe: func(slo *SLO, now *time.Time) {
+				for range 6 {
+					slo.Observe("anthropic", "claude", time.Minute, false)
+				}
+				slo.Observe("anthropic", "claude", time.Second, true)
+			},
+			slo:            SLOLatency,
+			expectAlert:    1,
+			expectShortMin: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry()
+			slo := NewSLO(createTestSLOConfig(), registry)
+			now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			slo.now = func() time.Time { return now }
+
+			tt.observe(slo, &now)
+			slo.Evaluate()
+
+			if got := slo.alerting.Value("anthropic", "claude", tt.slo); got != tt.expectAlert {
+				t.Errorf("expected alert %g, got %g", tt.expectAlert, got)
+			}
+			if got := slo.burnRate.Value("anthropic", "claude", tt.slo, "5m"); got < tt.expectShortMin || (tt.expectShortMin == 0 && got != 0) {
+				t.Errorf("expected a short window burn rate of at least %g, got %g", tt.expectShortMin, got)
+			}
+		})
+	}
+}
+
+func TestSLOAlertWebhook(t *testing.T) {
+	alerts := make(chan Alert, 2)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("failed to decode alert: %v", err)
+		}
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	cfg := createTestSLOConfig()
+	cfg.AlertWebhookURL = webhook.URL
+	registry := NewRegistry()
+	slo := NewSLO(cfg, registry)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	slo.now = func() time.Time { return now }
+
+	for range 5 {
+		slo.Observe("openai", "gpt-4o", time.Second, true)
+	}
+	slo.Evaluate()
+	alert := <-alerts
+	if alert.Status != "firing" || alert.Provider != "openai" || alert.Model != "gpt-4o" || alert.SLO != SLOAvailability || alert.LongWindow != "1h" {
+		t.Errorf("unexpected alert: %+v", alert)
+	}
+
+	now = now.Add(10 * time.Minute)
+	slo.Evaluate()
+	if alert := <-alerts; alert.Status != "resolved" {
+		t.Errorf("expected the alert to resolve, got %+v", alert)
+	}
+
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		"# TYPE manto_slo_burn_rate gauge",
+		`manto_slo_alert{provider="openai",model="gpt-4o",slo="availability"} 0`,
+		`manto_slo_burn_rate{provider="openai",model="gpt-4o",slo="availability",window="1h"} 100`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, w.Body.String())
+		}
+	}
+
+	now = now.Add(2 * time.Hour)
+	slo.Evaluate()
+	if got := slo.alerting.Value("openai", "gpt-4o", SLOAvailability); got != 0 || len(slo.series) != 0 {
+		t.Errorf("expected idle series to be dropped, got %d", len(slo.series))
+	}
+}
+
+func TestNewSLODisabled(t *testing.T) {
+	slo := NewSLO(config.SLOConfig{}, NewRegistry())
+	if slo != nil {
+		t.Fatal("expected no tracker when SLOs are disabled")
+	}
+	slo.Observe("anthropic", "claude", time.Second, true)
+}
@@ -0,0 +1,134 @@
+// Package access implements an optional instance-wide access code gate for
+// sharing a personal deployment with a few people without setting up real
+// authentication.
+package access
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+const (
+	// Header carries an access code on API requests from non-browser clients.
+	Header = "X-Manto-Access-Code"
+	// CookieName holds proof of a code exchanged via ExchangeHandler.
+	CookieName = "manto_access"
+
+	// ErrorCode identifies gate rejections so the UI knows to ask for a code.
+	ErrorCode = "access_code_required"
+
+	maxExchangeBodySize = 1024
+)
+
+// Gate checks requests against the configured access codes. The cookie is an
+// HMAC keyed by the code itself, so it is stateless, cannot be forged without
+// a code, and stops working as soon as that code is removed from the config.
+type Gate struct {
+	codes  []string
+	secure bool
+}
+
+func NewGate(cfg *config.Config) *Gate {
+	return &Gate{codes: cfg.Access.Codes, secure: !cfg.IsDevelopment()}
+}
+
+func (g *Gate) Enabled() bool {
+	return len(g.codes) > 0
+}
+
+// Middleware rejects requests without a valid code header or cookie with a
+// 401. It is a no-op when no codes are configured.
+func (g *Gate) Middleware(next http.Handler) http.Handler {
+	if !g.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.allowed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeError(w, http.StatusUnauthorized, "Access code required")
+	})
+}
+
+// ExchangeHandler trades a valid code for an HttpOnly cookie so browsers do not
+// have to keep the code in script-accessible storage.
+func (g *Gate) ExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxExchangeBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	code, ok := g.match(body.Code)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid access code")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token(code),
+		Path:     "/api/",
+		HttpOnly: true,
+		Secure:   g.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gate) allowed(r *http.Request) bool {
+	if _, ok := g.match(r.Header.Get(Header)); ok {
+		return true
+	}
+	c, err := r.Cookie(CookieName)
+	if err != nil {
+		return false
+	}
+	for _, code := range g.codes {
+		if hmac.Equal([]byte(c.Value), []byte(token(code))) {
+			return true
+		}
+	}
+	return false
+}
+
+// match compares candidate against every code in constant time.
+func (g *Gate) match(candidate string) (string, bool) {
+	if candidate == "" {
+		return "", false
+	}
+	var matched string
+	for _, code := range g.codes {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(code)) == 1 {
+			matched = code
+		}
+	}
+	return matched, matched != ""
+}
+
+func token(code string) string {
+	mac := hmac.New(sha256.New, []byte(code))
+	mac.Write([]byte("manto-access"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	resp := map[string]string{"error": message}
+	if status == http.StatusUnauthorized {
+		resp["code"] = ErrorCode
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,103 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func createTestConfig(codes ...string) *config.Config {
+	cfg := &config.Config{Environment: "production"}
+	cfg.Access.Codes = codes
+	return cfg
+}
+
+func TestGateMiddlewareBehavior(t *testing.T) {
+	gate := NewGate(createTestConfig("alpha", "beta"))
+	handler := gate.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		header         string
+		cookie         string
+		expectedStatus int
+	}{
+		{name: "no code", expectedStatus: http.StatusUnauthorized},
+		{name: "valid header", header: "beta", expectedStatus: http.StatusOK},
+		{name: "wrong header", header: "gamma", expectedStatus: http.StatusUnauthorized},
+		{name: "valid cookie", cookie: token("alpha"), expectedStatus: http.StatusOK},
+		{name: "forged cookie", cookie: token("gamma"), expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/models", nil)
+			if tt.header != "" {
+				req.Header.Set(Header, tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CookieName, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && !strings.Contains(w.Body.String(), ErrorCode) {
+				t.Errorf("expected %s in body, got %s", ErrorCode, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("disabled without codes", func(t *testing.T) {
+		open := NewGate(createTestConfig())
+		w := httptest.NewRecorder()
+		open.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})).ServeHTTP(w, httptest.NewRequest("GET", "/api/models", nil))
+		if open.Enabled() || w.Code != http.StatusOK {
+			t.Errorf("expected open gate, got enabled=%v status=%d", open.Enabled(), w.Code)
+		}
+	})
+}
+
+func TestExchangeHandlerBehavior(t *testing.T) {
+	gate := NewGate(createTestConfig("alpha"))
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "valid code", body: `{"code":"alpha"}`, expectedStatus: http.StatusNoContent},
+		{name: "invalid code", body: `{"code":"nope"}`, expectedStatus: http.StatusUnauthorized},
+		{name: "invalid json", body: `{`, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			gate.ExchangeHandler(w, httptest.NewRequest("POST", "/api/access", strings.NewReader(tt.body)))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			cookies := w.Result().Cookies()
+			if tt.expectedStatus != http.StatusNoContent {
+				if len(cookies) != 0 {
+					t.Error("expected no cookie on failure")
+				}
+				return
+			}
+			if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].Value != token("alpha") {
+				t.Errorf("expected secure HttpOnly access cookie, got %+v", cookies)
+			}
+		})
+	}
+}
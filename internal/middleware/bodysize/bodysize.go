@@ -0,0 +1,147 @@
+package bodysize
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/middleware/route"
+)
+
+// sizeBuckets run from 256 bytes to 16MB, in steps of four.
+var sizeBuckets = metrics.ExponentialBuckets(256, 4, 9)
+
+// Limiter caps request bodies by path and records body sizes by route.
+type Limiter struct {
+	limits    config.BodyLimitsConfig
+	requests  *metrics.HistogramVec
+	responses *metrics.HistogramVec
+	rejected  *metrics.CounterVec
+}
+
+func NewLimiter(cfg *config.Config, registry *metrics.Registry) *Limiter {
+	return &Limiter{
+		limits:    cfg.BodyLimits,
+		requests:  registry.Histogram("manto_http_request_body_bytes", "Request body bytes read by handlers, by route.", sizeBuckets, "route"),
+		responses: registry.Histogram("manto_http_response_body_bytes", "Response body bytes written, by route.", sizeBuckets, "route"),
+		rejected:  registry.Counter("manto_http_request_body_too_large_total", "Requests rejected for a body over their limit, by the limit's path prefix (empty for MAX_REQUEST_BODY).", "limit"),
+	}
+}
+
+// Middleware answers 413 with a JSON error when a request's Content-Length
+// is over its limit. Bodies without a length are cut off at the limit, and
+// whatever error the handler then writes is replaced by the same 413. It
+// must run after tenant paths have been rewritten, so limits see the route's
+// own path.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, prefix := l.limits.For(r.URL.Path)
+		if limit > 0 && r.ContentLength > limit {
+			l.rejected.Inc(prefix)
+			writeTooLarge(w, limit)
+			return
+		}
+
+		body := &countingBody{ReadCloser: r.Body, limit: limit}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		tw := &trackingWriter{ResponseWriter: w, body: body}
+
+		next.ServeHTTP(tw, r)
+
+		if body.exceeded {
+			l.rejected.Inc(prefix)
+		}
+		route := route.Pattern(r)
+		l.requests.Observe(float64(body.read), route)
+		l.responses.Observe(float64(tw.written), route)
+	})
+}
+
+func writeTooLarge(w http.ResponseWriter, limit int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       "Request body too large",
+		"code":        "request_too_large",
+		"limit_bytes": limit,
+	})
+}
+
+// countingBody counts the bytes read from a request body and, with a
+// positive limit, fails reads past it.
+type countingBody struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	if b.limit > 0 && int64(len(p)) > b.limit-b.read+1 {
+		// Read one byte past the limit to tell a body of exactly the limit
+		// from a longer one.
+		p = p[:b.limit-b.read+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.limit > 0 && b.read > b.limit {
+		b.exceeded = true
+		return n - int(b.read-b.limit), &http.MaxBytesError{Limit: b.limit}
+	}
+	return n, err
+}
+
+// trackingWriter counts the bytes written and swaps an error response for a
+// 413 once the body has gone over its limit.
+type trackingWriter struct {
+	http.ResponseWriter
+	body        *countingBody
+	wroteHeader bool
+	replaced    bool
+	written     int64
+}
+
+func (w *trackingWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status >= 400 && w.body.exceeded {
+		w.replaced = true
+		writeTooLarge(w.ResponseWriter, w.body.limit)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(p), nil
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush passes flushes on for streamed answers.
+func (w *trackingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
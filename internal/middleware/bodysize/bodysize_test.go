@@ -0,0 +1,93 @@
+package bodysize
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+func TestMiddlewareBehavior(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.BodyLimits.MaxRequestBody = 64
+	cfg.BodyLimits.Routes = map[string]int64{"/api/models": 8, "/api/transcribe": 0}
+	registry := metrics.NewRegistry()
+	limiter := NewLimiter(cfg, registry)
+
+	r := chi.NewRouter()
+	r.Use(limiter.Middleware)
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"Invalid JSON"}`))
+			return
+		}
+		w.Write(body)
+	}
+	r.Post("/api/messages", echo)
+	r.Post("/api/models", echo)
+	r.Post("/api/transcribe", echo)
+
+	tests := []struct {
+		name          string
+		path          string
+		size          int
+		chunked       bool
+		expectStatus  int
+		expectLimited bool
+	}{
+		{name: "passes a body within the default limit", path: "/api/messages", size: 64, expectStatus: http.StatusOK},
+		{name: "rejects a declared length over the default limit", path: "/api/messages", size: 65, expectStatus: http.StatusRequestEntityTooLarge, expectLimited: true},
+		{name: "rejects a chunked body over the default limit", path: "/api/messages", size: 65, chunked: true, expectStatus: http.StatusRequestEntityTooLarge, expectLimited: true},
+		{name: "applies the route's own limit", path: "/api/models", size: 9, expectStatus: http.StatusRequestEntityTooLarge, expectLimited: true},
+		{name: "leaves routes limited to 0 to their handler", path: "/api/transcribe", size: 4096, chunked: true, expectStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(strings.Repeat("x", tt.size))
+			if tt.chunked {
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest("POST", tt.path, body)
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectStatus, w.Code, w.Body.String())
+			}
+			if !tt.expectLimited {
+				if w.Body.Len() != tt.size {
+					t.Errorf("expected the whole body to reach the handler, got %d bytes", w.Body.Len())
+				}
+				return
+			}
+			var errorBody struct {
+				Code       string `json:"code"`
+				LimitBytes int64  `json:"limit_bytes"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &errorBody); err != nil || errorBody.Code != "request_too_large" || errorBody.LimitBytes == 0 {
+				t.Errorf("expected a structured 413, got %s", w.Body.String())
+			}
+		})
+	}
+
+	if got := registry.Histogram("manto_http_request_body_bytes", "", nil, "route").Count("/api/messages"); got != 2 {
+		t.Errorf("expected body sizes of the served messages requests, got %d", got)
+	}
+	if got := registry.Counter("manto_http_request_body_too_large_total", "", "limit").Value(""); got != 2 {
+		t.Errorf("expected 2 rejections under the default limit, got %v", got)
+	}
+	if got := registry.Counter("manto_http_request_body_too_large_total", "", "limit").Value("/api/models"); got != 1 {
+		t.Errorf("expected 1 rejection under the models limit, got %v", got)
+	}
+}
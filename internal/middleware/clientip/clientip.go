@@ -0,0 +1,134 @@
+// Package clientip works out which address a request came from. Behind a
+// reverse proxy every connection comes from the proxy, so the client's own
+// address is taken from Fly-Client-IP or X-Forwarded-For, but only when the
+// connection comes from a proxy listed in TRUSTED_PROXIES: anyone else could
+// set those headers to whatever they like.
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+// TrustPrivate is the TRUSTED_PROXIES entry that trusts every loopback and
+// private address, as on platforms whose proxies reach the app over a
+// private network.
+const TrustPrivate = "private"
+
+type contextKey struct{}
+
+type address struct {
+	ip string
+	// proxy is set when ip is a trusted proxy's that forwarded no client
+	// address, so it stands for everyone behind that proxy.
+	proxy bool
+}
+
+// Resolver resolves client addresses through the trusted proxies.
+type Resolver struct {
+	trusted []netip.Prefix
+	private bool
+}
+
+// New returns a resolver trusting cfg's TrustedProxies. Entries that don't
+// parse are skipped; config validation reports them.
+func New(cfg config.ServerConfig) *Resolver {
+	res := &Resolver{}
+	for _, entry := range cfg.TrustedProxies {
+		if entry == TrustPrivate {
+			res.private = true
+			continue
+		}
+		if prefix, err := config.ParseIPPrefix(entry); err == nil {
+			res.trusted = append(res.trusted, prefix)
+		}
+	}
+	return res
+}
+
+// Middleware records the client's address for IP and Known.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextKey{}, res.resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// resolve takes the peer's address unless it is a trusted proxy. Then it is
+// Fly-Client-IP if set, or otherwise the last X-Forwarded-For hop that isn't
+// a trusted proxy itself, so a client can't pass off an address it prepended.
+func (res *Resolver) resolve(r *http.Request) address {
+	peer := peerIP(r)
+	if !res.trusts(peer) {
+		return address{ip: peer}
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("Fly-Client-IP"))); err == nil {
+		return address{ip: ip.Unmap().String()}
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = ip.Unmap().String()
+		if !res.trusts(client) {
+			break
+		}
+	}
+	if client == "" {
+		return address{ip: peer, proxy: true}
+	}
+	return address{ip: client}
+}
+
+func (res *Resolver) trusts(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if res.private && (addr.IsLoopback() || addr.IsPrivate()) {
+		return true
+	}
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP returns the address r came from: the client's as the middleware
+// resolved it, or the connection's peer without the middleware.
+func IP(r *http.Request) string {
+	if addr, ok := r.Context().Value(contextKey{}).(address); ok {
+		return addr.ip
+	}
+	return peerIP(r)
+}
+
+// Known reports whether IP(r) identifies the client, rather than a trusted
+// proxy that didn't say whom it forwarded the request for.
+func Known(r *http.Request) bool {
+	addr, ok := r.Context().Value(contextKey{}).(address)
+	return !ok || !addr.proxy
+}
+
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
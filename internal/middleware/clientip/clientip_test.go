@@ -0,0 +1,84 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func TestResolverBehavior(t *testing.T) {
+	tests := []struct {
+		name          string
+		trusted       []string
+		remoteAddr    string
+		header        map[string]string
+		expected      string
+		expectedKnown bool
+	}{
+		{name: "peer without proxies", remoteAddr: "192.0.2.1:1234", expected: "192.0.2.1", expectedKnown: true},
+		{
+			name:          "headers from untrusted peers are ignored",
+			remoteAddr:    "192.0.2.1:1234",
+			header:        map[string]string{"Fly-Client-IP": "198.51.100.7", "X-Forwarded-For": "198.51.100.8"},
+			expected:      "192.0.2.1",
+			expectedKnown: true,
+		},
+		{
+			name:          "Fly-Client-IP from a trusted proxy",
+			trusted:       []string{"172.16.0.0/12"},
+			remoteAddr:    "172.16.3.4:1234",
+			header:        map[string]string{"Fly-Client-IP": "198.51.100.7", "X-Forwarded-For": "198.51.100.8"},
+			expected:      "198.51.100.7",
+			expectedKnown: true,
+		},
+		{
+			name:          "last untrusted X-Forwarded-For hop",
+			trusted:       []string{"10.0.0.0/8"},
+			remoteAddr:    "10.0.0.2:1234",
+			header:        map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.8, 10.0.0.1"},
+			expected:      "198.51.100.8",
+			expectedKnown: true,
+		},
+		{
+			name:          "private trusts loopback and private networks",
+			trusted:       []string{TrustPrivate},
+			remoteAddr:    "[fdaa::3]:1234",
+			header:        map[string]string{"Fly-Client-IP": "2001:db8::7"},
+			expected:      "2001:db8::7",
+			expectedKnown: true,
+		},
+		{
+			name:       "trusted proxy that names no client",
+			trusted:    []string{TrustPrivate},
+			remoteAddr: "127.0.0.1:1234",
+			expected:   "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip string
+			var known bool
+			handler := New(config.ServerConfig{TrustedProxies: tt.trusted}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip, known = IP(r), Known(r)
+			}))
+			req := httptest.NewRequest("GET", "/api/models", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if ip != tt.expected || known != tt.expectedKnown {
+				t.Errorf("expected %s (known %v), got %s (known %v)", tt.expected, tt.expectedKnown, ip, known)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/api/models", nil)
+	if IP(req) != "192.0.2.1" || !Known(req) {
+		t.Errorf("expected the peer's address without the middleware, got %s", IP(req))
+	}
+}
@@ -0,0 +1,46 @@
+package latency
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/middleware/route"
+	"github.com/manto/manto-web/internal/tracing"
+)
+
+// durationBuckets run from 5ms to about 82s, doubling, to cover both quick
+// API calls and long streamed answers.
+var durationBuckets = metrics.ExponentialBuckets(0.005, 2, 15)
+
+// Recorder records how long requests take to serve, by route, method and
+// status code.
+type Recorder struct {
+	durations *metrics.HistogramVec
+}
+
+func NewRecorder(registry *metrics.Registry) *Recorder {
+	return &Recorder{
+		durations: registry.Histogram("manto_http_request_duration_seconds", "Time to serve HTTP requests, to the end of the response, by route, method and status code.", durationBuckets, "route", "method", "code"),
+	}
+}
+
+// Middleware times each request. Requests from a sampled trace leave its
+// trace ID as the exemplar of their bucket, so a latency spike leads to the
+// trace of a request behind it. It must run after tracing.Middleware.
+func (l *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		l.durations.ObserveWithExemplar(time.Since(start).Seconds(), tracing.Exemplar(r.Context()), route.Pattern(r), r.Method, strconv.Itoa(status))
+	})
+}
@@ -0,0 +1,57 @@
+package latency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/tracing"
+)
+
+func TestMiddlewareBehavior(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Tracing.Enabled = true
+	registry := metrics.NewRegistry()
+
+	r := chi.NewRouter()
+	r.Use(tracing.Middleware(cfg))
+	r.Use(NewRecorder(registry).Middleware)
+	r.Get("/api/conversations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Get("/api/models", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[]}`))
+	})
+
+	for _, path := range []string{"/api/conversations/c1", "/api/conversations/c2", "/api/models"} {
+		req := httptest.NewRequest("GET", path, nil)
+		if path == "/api/models" {
+			req.Header.Set(tracing.Header, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	durations := registry.Histogram("manto_http_request_duration_seconds", "", nil)
+	if got := durations.Count("/api/conversations/{id}", "GET", "404"); got != 2 {
+		t.Errorf("expected 2 requests by route pattern, got %d", got)
+	}
+	if got := durations.Count("/api/models", "GET", "200"); got != 1 {
+		t.Errorf("expected a 200 for an implicit status, got %d", got)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, req)
+	body := w.Body.String()
+	if !strings.Contains(body, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`) {
+		t.Errorf("expected the traced request's exemplar, got:\n%s", body)
+	}
+	if strings.Count(body, "trace_id") != 1 {
+		t.Errorf("expected untraced requests to leave no exemplar, got:\n%s", body)
+	}
+}
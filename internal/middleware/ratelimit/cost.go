@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// CostLimiter is a token bucket per client key, charged each request's
+// max_tokens rather than one per request, so a caller asking for huge
+// generations runs dry long before one sending short questions. Buckets hold
+// up to capacity tokens and refill at capacity per window.
+type CostLimiter struct {
+	capacity float64
+	rate     float64
+	allow    exemptions
+	now      func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewCostLimiter returns the limiter cfg describes, or nil when rate
+// limiting or RATE_LIMIT_TOKENS is off.
+func NewCostLimiter(cfg config.RateLimitConfig) *CostLimiter {
+	if !cfg.Enabled || cfg.Tokens <= 0 {
+		return nil
+	}
+	return &CostLimiter{
+		capacity: float64(cfg.Tokens),
+		rate:     float64(cfg.Tokens) / cfg.TokensWindow.Duration.Seconds(),
+		allow:    newExemptions(cfg),
+		now:      time.Now,
+		buckets:  make(map[string]*bucket),
+	}
+}
+
+// Take charges cost tokens to each of r's client keys and reports whether
+// all their buckets held them; when one didn't, none is charged. A cost over
+// the capacity is charged as the capacity, so a large request still goes
+// through once the buckets are full. The result describes the emptiest
+// bucket: Reset is when it will be full again or, when refused, when it will
+// hold cost. A nil limiter and exempt callers are always allowed, with a
+// zero Limit.
+func (l *CostLimiter) Take(r *http.Request, cost int) Result {
+	if l == nil || l.allow.exempt(r) {
+		return Result{Allowed: true}
+	}
+	charge := math.Min(float64(cost), l.capacity)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var emptiest *bucket
+	var buckets []*bucket
+	for _, key := range ClientKeys(r) {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: l.capacity, updated: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(l.capacity, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+		b.updated = now
+		buckets = append(buckets, b)
+		if emptiest == nil || b.tokens < emptiest.tokens {
+			emptiest = b
+		}
+	}
+
+	result := Result{Limit: int(l.capacity)}
+	if emptiest.tokens < charge {
+		result.Remaining = int(emptiest.tokens)
+		result.Reset = now.Add(l.refillTime(charge - emptiest.tokens))
+		return result
+	}
+	for _, b := range buckets {
+		b.tokens -= charge
+	}
+	result.Allowed = true
+	result.Remaining = int(emptiest.tokens)
+	result.Reset = now.Add(l.refillTime(l.capacity - emptiest.tokens))
+	return result
+}
+
+func (l *CostLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / l.rate * float64(time.Second)))
+}
+
+// Cleanup drops buckets that have refilled, bounding memory use.
+func (l *CostLimiter) Cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup once a minute until stop is closed.
+func (l *CostLimiter) StartCleanup(stop <-chan struct{}) {
+	if l == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				l.Cleanup()
+			}
+		}
+	}()
+}
@@ -0,0 +1,234 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/middleware/clientip"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/session"
+)
+
+type offender struct {
+	failures    int
+	last        time.Time
+	bannedUntil time.Time
+}
+
+// Lockout slows down and then shuts out clients that keep presenting
+// invalid API keys, whether malformed or rejected by the provider. Clients
+// are tracked by IP, as resolved through TRUSTED_PROXIES, and, separately,
+// by session, so neither rotating sessions nor moving between networks
+// starts afresh. Delays and bans are recorded in the audit trail.
+type Lockout struct {
+	cfg      config.SecurityConfig
+	provider func(ctx context.Context) services.Provider
+	recorder moderation.Recorder
+	allow    exemptions
+	now      func() time.Time
+
+	mu        sync.Mutex
+	offenders map[string]*offender
+}
+
+// NewLockout returns the lockout cfg describes, or nil when
+// KEY_FAILURE_THRESHOLD is 0. Keys are checked with the provider that
+// provider returns for the request, and delays and bans are recorded with
+// recorder.
+func NewLockout(cfg *config.Config, provider func(ctx context.Context) services.Provider, recorder moderation.Recorder) *Lockout {
+	if cfg.Security.KeyFailureThreshold <= 0 {
+		return nil
+	}
+	return &Lockout{
+		cfg:       cfg.Security,
+		provider:  provider,
+		recorder:  recorder,
+		allow:     newExemptions(cfg.RateLimit),
+		now:       time.Now,
+		offenders: make(map[string]*offender),
+	}
+}
+
+// Middleware refuses banned clients with a 429, holds back clients with
+// recent failures, and counts the failures of the requests it passes on.
+// It goes after provider selection, so each key is judged by the provider
+// it is for. It is a no-op for a nil lockout and for exempt callers.
+func (l *Lockout) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.allow.exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		clients := lockoutClients(r)
+		delay, bannedUntil := l.standing(clients)
+		if !bannedUntil.IsZero() {
+			retryAfter := int(math.Ceil(bannedUntil.Sub(l.now()).Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Too many invalid API keys; try again later"})
+			return
+		}
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		provider := l.provider(r.Context())
+		if key := provider.ClientAPIKey(r); key != "" && !provider.ValidateAPIKey(key) {
+			l.fail(clients, "malformed")
+		}
+		ctx := services.WithAuthFailureHook(r.Context(), func() { l.fail(clients, "rejected") })
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// lockoutClients lists the identities r's failures count against: its IP,
+// resolved through the trusted proxies, and, when it presented one, its
+// session. A trusted proxy's own address stands for everyone behind it, so
+// it is never counted against.
+func lockoutClients(r *http.Request) []string {
+	var clients []string
+	if clientip.Known(r) {
+		clients = append(clients, remoteIP(r))
+	}
+	if s := session.FromContext(r.Context()); s != nil && !s.New {
+		clients = append(clients, "session:"+s.ID)
+	}
+	return clients
+}
+
+// standing returns how long to hold back the clients' next request and,
+// if any of them is banned, until when.
+func (l *Lockout) standing(clients []string) (time.Duration, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var delay time.Duration
+	var bannedUntil time.Time
+	for _, client := range clients {
+		o := l.offenders[client]
+		if o == nil {
+			continue
+		}
+		if now.Before(o.bannedUntil) && o.bannedUntil.After(bannedUntil) {
+			bannedUntil = o.bannedUntil
+		}
+		if now.Sub(o.last) < l.cfg.KeyFailureWindow.Duration {
+			delay = max(delay, l.delayFor(o.failures))
+		}
+	}
+	return delay, bannedUntil
+}
+
+// delayFor is the hold-back after failures: nothing below the threshold,
+// then KeyFailureDelay, doubling per failure up to KeyFailureMaxDelay.
+func (l *Lockout) delayFor(failures int) time.Duration {
+	over := failures - l.cfg.KeyFailureThreshold
+	if over < 0 {
+		return 0
+	}
+	delay := l.cfg.KeyFailureDelay.Duration
+	for range over {
+		if delay *= 2; delay >= l.cfg.KeyFailureMaxDelay.Duration {
+			return l.cfg.KeyFailureMaxDelay.Duration
+		}
+	}
+	return min(delay, l.cfg.KeyFailureMaxDelay.Duration)
+}
+
+// fail counts an invalid key against each of clients, for reason
+// ("malformed" or "rejected"). Failures older than KeyFailureWindow are
+// forgotten first.
+func (l *Lockout) fail(clients []string, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for _, client := range clients {
+		o := l.offenders[client]
+		if o == nil {
+			o = &offender{}
+			l.offenders[client] = o
+		}
+		if now.Sub(o.last) >= l.cfg.KeyFailureWindow.Duration {
+			o.failures = 0
+		}
+		o.failures++
+		o.last = now
+
+		switch {
+		case l.cfg.KeyFailureBanAfter > 0 && o.failures >= l.cfg.KeyFailureBanAfter:
+			o.bannedUntil = now.Add(l.cfg.KeyFailureBanDuration.Duration)
+			o.failures = 0
+			l.record(client, "banned", reason, now)
+		case o.failures == l.cfg.KeyFailureThreshold:
+			l.record(client, "delayed", reason, now)
+		}
+	}
+}
+
+func (l *Lockout) record(client, action, reason string, now time.Time) {
+	slog.Warn("repeated invalid API keys",
+		slog.String("client", client),
+		slog.String("action", action),
+		slog.String("reason", reason))
+	if l.recorder == nil {
+		return
+	}
+	l.recorder.Record(moderation.Event{
+		Time:        now.UTC(),
+		Stage:       "auth",
+		Action:      action,
+		Checkers:    []string{"lockout"},
+		Rules:       []string{reason + "_api_key"},
+		Attribution: map[string]string{"client": client},
+	})
+}
+
+// Cleanup drops clients whose failures and bans have lapsed, bounding
+// memory use.
+func (l *Lockout) Cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for client, o := range l.offenders {
+		if now.Sub(o.last) >= l.cfg.KeyFailureWindow.Duration && !now.Before(o.bannedUntil) {
+			delete(l.offenders, client)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup once a minute until stop is closed.
+func (l *Lockout) StartCleanup(stop <-chan struct{}) {
+	if l == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				l.Cleanup()
+			}
+		}
+	}()
+}
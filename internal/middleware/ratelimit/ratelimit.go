@@ -0,0 +1,255 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/middleware/clientip"
+	"github.com/manto/manto-web/internal/session"
+)
+
+// Result describes a client's standing in its current window.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Limiter is a fixed-window request counter keyed by client.
+type Limiter struct {
+	limit  int
+	period time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+func NewLimiter(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		period:  period,
+		now:     time.Now,
+		windows: make(map[string]*window),
+	}
+}
+
+// SetLimit changes the limit and window for requests from now on; windows
+// already open keep their start.
+func (l *Limiter) SetLimit(limit int, period time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.period = limit, period
+}
+
+// Allow records a request against each of keys and reports whether it fits
+// in all their windows. A request refused by one window isn't counted in
+// the others. The result describes the window with the fewest requests
+// left, or the last to reset among those that are full.
+func (l *Limiter) Allow(keys ...string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	windows := make([]*window, len(keys))
+	for i, key := range keys {
+		w, ok := l.windows[key]
+		if !ok || now.Sub(w.start) >= l.period {
+			w = &window{start: now}
+			l.windows[key] = w
+		}
+		windows[i] = w
+	}
+
+	result := Result{Limit: l.limit, Remaining: l.limit}
+	for _, w := range windows {
+		if w.count >= l.limit && w.start.Add(l.period).After(result.Reset) {
+			result.Remaining, result.Reset = 0, w.start.Add(l.period)
+		}
+	}
+	if !result.Reset.IsZero() {
+		return result
+	}
+
+	result.Allowed = true
+	for _, w := range windows {
+		w.count++
+		if remaining := l.limit - w.count; remaining < result.Remaining {
+			result.Remaining, result.Reset = remaining, w.start.Add(l.period)
+		}
+	}
+	return result
+}
+
+// Cleanup drops windows that have expired, bounding memory use.
+func (l *Limiter) Cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.period {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup once per period until stop is closed.
+func (l *Limiter) StartCleanup(stop <-chan struct{}) {
+	ticker := time.NewTicker(l.period)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				l.Cleanup()
+			}
+		}
+	}()
+}
+
+// ClientKeys lists the identities r is limited as: its IP and, when the
+// client presented one, its anonymous session. Requests count against both,
+// so rotating sessions gets no fresh bucket, and neither does moving
+// between networks. Freshly issued sessions are left out, as clients that
+// drop cookies get a new one per request.
+func ClientKeys(r *http.Request) []string {
+	keys := []string{remoteIP(r)}
+	if s := session.FromContext(r.Context()); s != nil && !s.New {
+		keys = append(keys, "session:"+s.ID)
+	}
+	return keys
+}
+
+// remoteIP is the client's address, resolved through the trusted proxies.
+func remoteIP(r *http.Request) string {
+	return clientip.IP(r)
+}
+
+// exemptions is the allow-list of callers that bypass the limiter entirely,
+// such as monitoring probes or the operator's own workstation.
+type exemptions struct {
+	prefixes []netip.Prefix
+	sessions map[string]bool
+}
+
+func newExemptions(cfg config.RateLimitConfig) exemptions {
+	e := exemptions{sessions: make(map[string]bool)}
+	for _, entry := range cfg.ExemptIPs {
+		if prefix, err := config.ParseIPPrefix(entry); err == nil {
+			e.prefixes = append(e.prefixes, prefix)
+		}
+	}
+	for _, id := range cfg.ExemptSessions {
+		e.sessions[id] = true
+	}
+	return e
+}
+
+func (e exemptions) exempt(r *http.Request) bool {
+	if s := session.FromContext(r.Context()); s != nil && !s.New && e.sessions[s.ID] {
+		return true
+	}
+	if len(e.prefixes) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(remoteIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range e.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware enforces the limiter and reports the caller's standing on every
+// response via X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset
+// (seconds until the window resets). Exempt callers pass through without
+// being counted or receiving the headers.
+func Middleware(cfg *config.Config, limiter *Limiter) func(http.Handler) http.Handler {
+	allowList := newExemptions(cfg.RateLimit)
+
+	return func(next http.Handler) http.Handler {
+		if !cfg.RateLimit.Enabled {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowList.exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result := limiter.Allow(ClientKeys(r)...)
+			resetIn := resetSeconds(result)
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(resetIn))
+
+			if !result.Allowed {
+				refuse(w, resetIn)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RouteMiddleware enforces a single route's own limiter, such as the title
+// endpoint's, independently of the general rate limit. It leaves the
+// X-RateLimit-* headers to Middleware, so they keep describing the general
+// window, and only sets Retry-After when it refuses. A nil limiter lets
+// every request through; exempt callers aren't counted.
+func RouteMiddleware(cfg *config.Config, limiter *Limiter) func(http.Handler) http.Handler {
+	allowList := newExemptions(cfg.RateLimit)
+
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowList.exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if result := limiter.Allow(ClientKeys(r)...); !result.Allowed {
+				refuse(w, resetSeconds(result))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// resetSeconds is how long until result's window resets, rounded up.
+func resetSeconds(result Result) int {
+	return max(int(math.Ceil(time.Until(result.Reset).Seconds())), 0)
+}
+
+func refuse(w http.ResponseWriter, resetIn int) {
+	w.Header().Set("Retry-After", strconv.Itoa(resetIn))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Rate limit exceeded"})
+}
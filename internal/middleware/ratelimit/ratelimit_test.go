@@ -0,0 +1,446 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/middleware/clientip"
+	"github.com/manto/manto-web/internal/moderation"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/session"
+)
+
+func TestLimiterBehavior(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	first := limiter.Allow("a")
+	second := limiter.Allow("a")
+	third := limiter.Allow("a")
+
+	if !first.Allowed || first.Remaining != 1 {
+		t.Errorf("first request should be allowed with 1 remaining, got %+v", first)
+	}
+	if !second.Allowed || second.Remaining != 0 {
+		t.Errorf("second request should be allowed with 0 remaining, got %+v", second)
+	}
+	if third.Allowed {
+		t.Error("third request should be rejected")
+	}
+	if !limiter.Allow("b").Allowed {
+		t.Error("other clients should have their own window")
+	}
+
+	now = now.Add(time.Minute)
+	if result := limiter.Allow("a"); !result.Allowed || result.Remaining != 1 {
+		t.Errorf("window should reset after the period, got %+v", result)
+	}
+
+	now = now.Add(2 * time.Minute)
+	limiter.Cleanup()
+	if len(limiter.windows) != 0 {
+		t.Errorf("expired windows should be removed, %d left", len(limiter.windows))
+	}
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.RateLimit.Enabled = true
+	limiter := NewLimiter(1, time.Minute)
+
+	handler := Middleware(cfg, limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/api/models", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d", w.Code)
+	}
+	if w.Header().Get("X-RateLimit-Limit") != "1" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected rate limit headers: %v", w.Header())
+	}
+	if w.Header().Get("X-RateLimit-Reset") == "" {
+		t.Error("X-RateLimit-Reset should be set")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After should be set on 429 responses")
+	}
+}
+
+func TestClientKeysBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		session  *session.Session
+		expected string
+	}{
+		{name: "remote IP without a session", expected: "192.0.2.1"},
+		{name: "returning session counts alongside the IP", session: &session.Session{ID: "abc"}, expected: "192.0.2.1,session:abc"},
+		{name: "freshly issued session is left out", session: &session.Session{ID: "abc", New: true}, expected: "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/models", nil)
+			if tt.session != nil {
+				req = req.WithContext(session.NewContext(req.Context(), tt.session))
+			}
+			if got := strings.Join(ClientKeys(req), ","); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCountsIPAndSession(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.RateLimit.Enabled = true
+	handler := Middleware(cfg, NewLimiter(2, time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(remoteAddr, sessionID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/access", nil)
+		req.RemoteAddr = remoteAddr
+		req = req.WithContext(session.NewContext(req.Context(), &session.Session{ID: sessionID}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	send("192.0.2.1:1234", "one")
+	if w := send("192.0.2.1:1234", "two"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("expected the IP's last request to pass, got %d with %s left", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+	if w := send("192.0.2.1:1234", "three"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected rotating sessions to share the IP's limit, got %d", w.Code)
+	}
+
+	send("192.0.2.2:1234", "roaming")
+	send("192.0.2.3:1234", "roaming")
+	if w := send("192.0.2.4:1234", "roaming"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected a session moving between IPs to keep its limit, got %d", w.Code)
+	}
+	if w := send("192.0.2.4:1234", "fresh"); w.Code != http.StatusOK {
+		t.Errorf("expected the refused request not to count against the IP, got %d", w.Code)
+	}
+}
+
+func TestMiddlewareExemptions(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.RateLimit.Enabled = true
+	cfg.RateLimit.ExemptIPs = []string{"10.0.0.0/8", "2001:db8::1"}
+	cfg.RateLimit.ExemptSessions = []string{"probe"}
+
+	handler := Middleware(cfg, NewLimiter(1, time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		session    *session.Session
+		exempt     bool
+	}{
+		{name: "IP in exempt range", remoteAddr: "10.1.2.3:1234", exempt: true},
+		{name: "exact IPv6 address", remoteAddr: "[2001:db8::1]:1234", exempt: true},
+		{name: "exempt returning session", remoteAddr: "192.0.2.10:1234", session: &session.Session{ID: "probe"}, exempt: true},
+		{name: "exempt ID on a fresh session is not trusted", remoteAddr: "192.0.2.11:1234", session: &session.Session{ID: "probe", New: true}},
+		{name: "other IP", remoteAddr: "192.0.2.12:1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var last *httptest.ResponseRecorder
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest("GET", "/api/models", nil)
+				req.RemoteAddr = tt.remoteAddr
+				if tt.session != nil {
+					req = req.WithContext(session.NewContext(req.Context(), tt.session))
+				}
+				last = httptest.NewRecorder()
+				handler.ServeHTTP(last, req)
+			}
+
+			if tt.exempt {
+				if last.Code != http.StatusOK || last.Header().Get("X-RateLimit-Limit") != "" {
+					t.Errorf("expected exempt request without limit headers, got %d %v", last.Code, last.Header())
+				}
+			} else if last.Code != http.StatusTooManyRequests {
+				t.Errorf("expected 429, got %d", last.Code)
+			}
+		})
+	}
+}
+
+func TestMiddlewareBehindProxy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.RateLimit.Enabled = true
+	cfg.Server.TrustedProxies = []string{"172.16.0.0/12"}
+	handler := clientip.New(cfg.Server).Middleware(Middleware(cfg, NewLimiter(1, time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	send := func(remoteAddr, client string) int {
+		req := httptest.NewRequest("GET", "/api/models", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Fly-Client-IP", client)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if send("172.16.0.2:1234", "198.51.100.1") != http.StatusOK || send("172.16.0.2:1234", "198.51.100.2") != http.StatusOK {
+		t.Error("expected clients behind a trusted proxy to get a bucket each")
+	}
+	if send("172.16.0.2:1234", "198.51.100.1") != http.StatusTooManyRequests {
+		t.Error("expected a client behind the proxy to be limited on its own address")
+	}
+	if send("192.0.2.1:1234", "198.51.100.3") != http.StatusOK || send("192.0.2.1:1234", "198.51.100.4") != http.StatusTooManyRequests {
+		t.Error("expected an untrusted peer to be limited on its own address, whatever it claims")
+	}
+}
+
+func TestCostLimiterBehavior(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewCostLimiter(config.RateLimitConfig{Enabled: true, Tokens: 1000, TokensWindow: config.Duration{Duration: time.Minute}, ExemptIPs: []string{"10.0.0.0/8"}})
+	limiter.now = func() time.Time { return now }
+	request := func(ip string) *http.Request {
+		req := httptest.NewRequest("POST", "/api/messages", nil)
+		req.RemoteAddr = ip + ":1234"
+		return req
+	}
+
+	if result := limiter.Take(request("192.0.2.1"), 800); !result.Allowed || result.Remaining != 200 {
+		t.Fatalf("expected the first request to fit with 200 left, got %+v", result)
+	}
+	result := limiter.Take(request("192.0.2.1"), 500)
+	if result.Allowed || result.Reset.Sub(now) != 18*time.Second {
+		t.Errorf("expected 500 tokens to be refused until 300 more refill in 18s, got %+v", result)
+	}
+	if result := limiter.Take(request("192.0.2.2"), 500); !result.Allowed {
+		t.Errorf("expected other clients to have their own bucket, got %+v", result)
+	}
+
+	now = now.Add(18 * time.Second)
+	if result := limiter.Take(request("192.0.2.1"), 500); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected the bucket to have refilled enough, got %+v", result)
+	}
+	now = now.Add(time.Minute)
+	if result := limiter.Take(request("192.0.2.1"), 50000); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected a request over the capacity to take a full bucket, got %+v", result)
+	}
+	if result := limiter.Take(request("10.1.2.3"), 50000); !result.Allowed || result.Limit != 0 {
+		t.Errorf("expected exempt callers to be let through uncounted, got %+v", result)
+	}
+
+	now = now.Add(time.Minute)
+	limiter.Cleanup()
+	if len(limiter.buckets) != 0 {
+		t.Errorf("expected refilled buckets to be dropped, got %d", len(limiter.buckets))
+	}
+	if disabled := NewCostLimiter(config.RateLimitConfig{Enabled: true}); disabled != nil || !disabled.Take(request("192.0.2.1"), 1).Allowed {
+		t.Error("expected no limiter without RATE_LIMIT_TOKENS, and a nil one to allow everything")
+	}
+}
+
+type recordedEvents []moderation.Event
+
+func (r *recordedEvents) Record(e moderation.Event) { *r = append(*r, e) }
+
+// keyedProvider accepts keys starting with prefix.
+type keyedProvider struct {
+	services.Provider
+	prefix string
+}
+
+func (p keyedProvider) ClientAPIKey(r *http.Request) string { return r.Header.Get("x-api-key") }
+func (p keyedProvider) ValidateAPIKey(key string) bool      { return strings.HasPrefix(key, p.prefix) }
+
+type selectedProvider struct{}
+
+func TestLockoutJudgesKeysByProvider(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Security = config.SecurityConfig{
+		KeyFailureThreshold: 5,
+		KeyFailureWindow:    config.Duration{Duration: time.Minute},
+	}
+	anthropic, openai := keyedProvider{prefix: "sk-ant-"}, keyedProvider{prefix: "sk-"}
+	lockout := NewLockout(cfg, func(ctx context.Context) services.Provider {
+		if p, ok := ctx.Value(selectedProvider{}).(services.Provider); ok {
+			return p
+		}
+		return anthropic
+	}, nil)
+	handler := lockout.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(ip string, provider services.Provider) {
+		req := httptest.NewRequest("GET", "/api/models", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("x-api-key", "sk-proj-1234567890")
+		if provider != nil {
+			req = req.WithContext(context.WithValue(req.Context(), selectedProvider{}, provider))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send("192.0.2.1", openai)
+	if o := lockout.offenders["192.0.2.1"]; o != nil {
+		t.Errorf("expected a key valid for the selected provider to go uncounted, got %+v", o)
+	}
+	send("192.0.2.2", nil)
+	if o := lockout.offenders["192.0.2.2"]; o == nil || o.failures != 1 {
+		t.Errorf("expected a key malformed for the default provider to count, got %+v", o)
+	}
+}
+
+func TestLockoutBehavior(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Anthropic.BaseURL = upstream.URL
+	cfg.Anthropic.KeyPrefix = "sk-ant-"
+	cfg.Security = config.SecurityConfig{
+		APIKeyMinLength:       10,
+		KeyFailureThreshold:   2,
+		KeyFailureWindow:      config.Duration{Duration: time.Minute},
+		KeyFailureDelay:       config.Duration{Duration: time.Millisecond},
+		KeyFailureMaxDelay:    config.Duration{Duration: 4 * time.Millisecond},
+		KeyFailureBanAfter:    4,
+		KeyFailureBanDuration: config.Duration{Duration: time.Minute},
+	}
+	service := services.NewAnthropicService(cfg)
+	var events recordedEvents
+	lockout := NewLockout(cfg, func(context.Context) services.Provider { return service }, &events)
+	now := time.Now()
+	lockout.now = func() time.Time { return now }
+	handler := lockout.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/models" {
+			service.GetModels(r.Context(), service.ClientAPIKey(r))
+		}
+	}))
+	send := func(ip, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("x-api-key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := range 4 {
+		if w := send("192.0.2.1", "/api/config", "guess"); w.Code != http.StatusOK {
+			t.Fatalf("expected failure %d to be let through, got %d", i+1, w.Code)
+		}
+	}
+	w := send("192.0.2.1", "/api/config", "sk-ant-1234567890")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected the client to be banned for a minute, got %d (Retry-After %q)", w.Code, w.Header().Get("Retry-After"))
+	}
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action+" "+e.Attribution["client"])
+	}
+	if strings.Join(actions, ",") != "delayed 192.0.2.1,banned 192.0.2.1" {
+		t.Errorf("expected the delay and the ban in the audit trail, got %v", actions)
+	}
+
+	if w := send("192.0.2.2", "/api/config", "sk-ant-1234567890"); w.Code != http.StatusOK || lockout.offenders["192.0.2.2"] != nil {
+		t.Errorf("expected a well-formed key to go uncounted, got %d", w.Code)
+	}
+	send("192.0.2.3", "/api/models", "sk-ant-1234567890")
+	if o := lockout.offenders["192.0.2.3"]; o == nil || o.failures != 1 {
+		t.Errorf("expected a key the provider rejected to count, got %+v", o)
+	}
+
+	for failures, expected := range map[int]time.Duration{1: 0, 2: time.Millisecond, 3: 2 * time.Millisecond, 10: 4 * time.Millisecond} {
+		if got := lockout.delayFor(failures); got != expected {
+			t.Errorf("expected a %s delay after %d failures, got %s", expected, failures, got)
+		}
+	}
+
+	behindProxy := clientip.New(config.ServerConfig{TrustedProxies: []string{"172.16.0.0/12"}}).Middleware(handler)
+	viaProxy := func(client string) {
+		req := httptest.NewRequest("GET", "/api/config", nil)
+		req.RemoteAddr = "172.16.0.2:1234"
+		req.Header.Set("x-api-key", "guess")
+		if client != "" {
+			req.Header.Set("Fly-Client-IP", client)
+		}
+		behindProxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	viaProxy("198.51.100.1")
+	viaProxy("")
+	if o := lockout.offenders["198.51.100.1"]; o == nil || o.failures != 1 {
+		t.Errorf("expected failures behind a proxy to count against the client, got %+v", o)
+	}
+	if o := lockout.offenders["172.16.0.2"]; o != nil {
+		t.Errorf("expected the proxy's own address never to be counted against, got %+v", o)
+	}
+
+	now = now.Add(2 * time.Minute)
+	lockout.Cleanup()
+	if len(lockout.offenders) != 0 {
+		t.Errorf("expected lapsed clients to be dropped, got %d", len(lockout.offenders))
+	}
+	cfg.Security.KeyFailureThreshold = 0
+	if NewLockout(cfg, func(context.Context) services.Provider { return service }, nil) != nil {
+		t.Error("expected no lockout with KEY_FAILURE_THRESHOLD=0")
+	}
+}
+
+func TestRouteMiddlewareBehavior(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		limiter    *Limiter
+		wantSecond int
+	}{
+		{name: "limits the route with the general limit off", limiter: NewLimiter(1, time.Minute), wantSecond: http.StatusTooManyRequests},
+		{name: "limits the route with the general limit on", enabled: true, limiter: NewLimiter(1, time.Minute), wantSecond: http.StatusTooManyRequests},
+		{name: "a nil limiter lets everything through", enabled: true, wantSecond: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.RateLimit.Enabled = tt.enabled
+			handler := RouteMiddleware(cfg, tt.limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			var w *httptest.ResponseRecorder
+			for i := range 2 {
+				w = httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/generate-title", nil))
+				if i == 0 && w.Code != http.StatusOK {
+					t.Fatalf("first request: expected 200, got %d", w.Code)
+				}
+				if w.Header().Get("X-RateLimit-Limit") != "" {
+					t.Errorf("request %d set the general X-RateLimit-* headers: %v", i+1, w.Header())
+				}
+			}
+			if w.Code != tt.wantSecond {
+				t.Errorf("second request: expected %d, got %d", tt.wantSecond, w.Code)
+			}
+			if tt.wantSecond == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After should be set on 429 responses")
+			}
+		})
+	}
+}
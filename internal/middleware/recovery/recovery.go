@@ -0,0 +1,113 @@
+package recovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/middleware/route"
+)
+
+// Report is the structured description of a recovered panic.
+type Report struct {
+	Time      time.Time `json:"time"`
+	Panic     string    `json:"panic"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Route     string    `json:"route"`
+	RequestID string    `json:"request_id"`
+	Stack     string    `json:"stack"`
+}
+
+type Recoverer struct {
+	logger     *slog.Logger
+	panics     *metrics.CounterVec
+	trackerURL string
+	client     *http.Client
+}
+
+func NewRecoverer(cfg *config.Config, logger *slog.Logger, registry *metrics.Registry) *Recoverer {
+	return &Recoverer{
+		logger:     logger,
+		panics:     registry.Counter("manto_http_panics_total", "Panics recovered while serving HTTP requests.", "route"),
+		trackerURL: cfg.ErrorTracking.URL,
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Middleware replaces chi's Recoverer: it logs a structured report with the
+// stack, counts the panic, forwards it to the error tracker when configured,
+// and answers with a JSON 500 if nothing has been written yet.
+func (rc *Recoverer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+
+			report := Report{
+				Time:      time.Now().UTC(),
+				Panic:     fmt.Sprint(rvr),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Route:     route.Pattern(r),
+				RequestID: middleware.GetReqID(r.Context()),
+				Stack:     string(debug.Stack()),
+			}
+
+			rc.panics.Inc(report.Route)
+			rc.logger.Error("panic recovered",
+				slog.String("panic", report.Panic),
+				slog.String("method", report.Method),
+				slog.String("path", report.Path),
+				slog.String("route", report.Route),
+				slog.String("request_id", report.RequestID),
+				slog.String("stack", report.Stack),
+			)
+			if rc.trackerURL != "" {
+				go rc.forward(report)
+			}
+
+			if ww.Status() == 0 {
+				ww.Header().Set("Content-Type", "application/json")
+				ww.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(ww).Encode(map[string]string{
+					"error":      "Internal server error",
+					"request_id": report.RequestID,
+				})
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+func (rc *Recoverer) forward(report Report) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return
+	}
+
+	resp, err := rc.client.Post(rc.trackerURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		rc.logger.Warn("failed to forward panic report", slog.String("error", err.Error()))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		rc.logger.Warn("error tracker rejected panic report", slog.Int("status", resp.StatusCode))
+	}
+}
@@ -0,0 +1,78 @@
+package recovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+func TestRecovererBehavior(t *testing.T) {
+	reports := make(chan Report, 1)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report Report
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &report)
+		reports <- report
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer tracker.Close()
+
+	cfg := &config.Config{}
+	cfg.ErrorTracking.URL = tracker.URL
+
+	var logs bytes.Buffer
+	registry := metrics.NewRegistry()
+	recoverer := NewRecoverer(cfg, slog.New(slog.NewJSONHandler(&logs, nil)), registry)
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(recoverer.Middleware)
+	r.Get("/api/boom/{id}", func(w http.ResponseWriter, r *http.Request) {
+		panic("kaboom")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/boom/42", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected JSON error body: %v", err)
+	}
+	if body["error"] == "" || body["request_id"] == "" {
+		t.Errorf("expected error and request_id in body, got %v", body)
+	}
+
+	logged := logs.String()
+	for _, want := range []string{`"panic":"kaboom"`, `"route":"/api/boom/{id}"`, `"stack":`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("expected log to contain %s, got %s", want, logged)
+		}
+	}
+
+	if got := registry.Counter("manto_http_panics_total", "").Value("/api/boom/{id}"); got != 1 {
+		t.Errorf("expected panic counter 1, got %v", got)
+	}
+
+	select {
+	case report := <-reports:
+		if report.Panic != "kaboom" || report.RequestID != body["request_id"] {
+			t.Errorf("unexpected forwarded report: %+v", report)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("expected panic report to be forwarded to the error tracker")
+	}
+}
@@ -0,0 +1,20 @@
+// Package route names the route that served a request, for labelling
+// metrics and reports by route rather than by raw path.
+package route
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Pattern returns the chi route that served r, once it has been served, or
+// "unknown" when none matched.
+func Pattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,25 @@
+package route
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestPatternBehavior(t *testing.T) {
+	var served string
+	r := chi.NewRouter()
+	r.Get("/api/conversations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		served = Pattern(r)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/conversations/conv_1", nil))
+	if served != "/api/conversations/{id}" {
+		t.Errorf("expected the route pattern, got %q", served)
+	}
+
+	if got := Pattern(httptest.NewRequest("GET", "/nowhere", nil)); got != "unknown" {
+		t.Errorf("expected unknown outside a router, got %q", got)
+	}
+}
@@ -2,31 +2,117 @@ package security
 
 import (
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/tenants"
 )
 
-func SecurityHeaders(cfg *config.Config) func(http.Handler) http.Handler {
+// apiPrefixes are the paths that serve JSON rather than pages, and get the
+// API profile.
+var apiPrefixes = []string{"/api/", "/anthropic/"}
+
+// Headers returns the security headers cfg calls for on pages and static
+// files: the full Content-Security-Policy and the hardening headers.
+// Hardening headers left empty in cfg are not sent.
+func Headers(cfg *config.Config) http.Header {
 	allowed := strings.Join(cfg.Security.AllowedAPIEndpoints, " ")
+	scriptSrc := "'self'"
+	if cfg.IsDevelopment() {
+		// Dev tooling (source maps, live reload, browser extensions talking to
+		// local dev servers) needs eval and localhost connections.
+		allowed += " ws://localhost:* http://localhost:*"
+		scriptSrc += " 'unsafe-eval'"
+	}
 	csp := "default-src 'self'; " +
 		"connect-src 'self' " + allowed + "; " +
 		"style-src 'self' 'unsafe-inline'; " +
-		"script-src 'self'; " +
-		"img-src 'self' data:; " +
-		"object-src 'none'; base-uri 'self'"
+		"script-src " + scriptSrc + "; " +
+		"img-src 'self' data:; "
+	if cfg.Speech.TTSProvider != "" {
+		// Read-aloud plays the fetched audio from a blob: URL.
+		csp += "media-src 'self' blob:; "
+	}
+	csp += "object-src 'none'; base-uri 'self'"
+	switch cfg.Security.FrameOptions {
+	case "DENY":
+		csp += "; frame-ancestors 'none'"
+	case "SAMEORIGIN":
+		csp += "; frame-ancestors 'self'"
+	}
+
+	header := http.Header{}
+	set := func(name, value string) {
+		if value != "" && value != "off" {
+			header.Set(name, value)
+		}
+	}
+	set("X-Content-Type-Options", cfg.Security.ContentTypeOptions)
+	set("Referrer-Policy", cfg.Security.ReferrerPolicy)
+	set("Permissions-Policy", cfg.Security.PermissionsPolicy)
+	set("X-Frame-Options", cfg.Security.FrameOptions)
+	set("Cross-Origin-Resource-Policy", cfg.Security.CrossOriginResourcePolicy)
+	header.Set("Content-Security-Policy", csp)
+	if cfg.Security.EnableHSTS && !cfg.IsDevelopment() {
+		header.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains; preload")
+	}
+	return header
+}
+
+// APIHeaders returns the security headers cfg calls for on API responses.
+// JSON isn't rendered, so the CSP and framing headers are left out, but
+// responses are marked no-store, with Pragma for HTTP/1.0 caches, so chat
+// content never lingers in a proxy or browser cache. Handlers serving public
+// data that may be cached say so themselves; see AllowCaching.
+func APIHeaders(cfg *config.Config) http.Header {
+	page := Headers(cfg)
+	header := http.Header{}
+	for _, name := range []string{"X-Content-Type-Options", "Referrer-Policy", "Cross-Origin-Resource-Policy", "Strict-Transport-Security"} {
+		if value := page.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	header.Set("Cache-Control", "no-store")
+	header.Set("Pragma", "no-cache")
+	return header
+}
+
+// AllowCaching replaces the API profile's no-store with cacheControl, for
+// API responses that hold no user content.
+func AllowCaching(w http.ResponseWriter, cacheControl string) {
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Del("Pragma")
+}
+
+// IsAPIPath reports whether path gets the API profile, including under a
+// /t/{tenant} prefix.
+func IsAPIPath(path string) bool {
+	if rest, ok := strings.CutPrefix(path, tenants.PathPrefix); ok {
+		_, path, _ = strings.Cut(rest, "/")
+		path = "/" + path
+	}
+	for _, prefix := range apiPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SecurityHeaders sets the API profile on API paths and the page profile on
+// everything else.
+func SecurityHeaders(cfg *config.Config) func(http.Handler) http.Handler {
+	page, api := Headers(cfg), APIHeaders(cfg)
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("Referrer-Policy", "no-referrer")
-			w.Header().Set("Permissions-Policy", "geolocation=()")
-			w.Header().Set("X-Frame-Options", "DENY")
-			w.Header().Set("Cross-Origin-Resource-Policy", "same-site")
-			w.Header().Set("Content-Security-Policy", csp)
-
-			if cfg.Security.EnableHSTS {
-				w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains; preload")
+			headers := page
+			if IsAPIPath(r.URL.Path) {
+				headers = api
+			}
+			for name, values := range headers {
+				w.Header()[name] = slices.Clone(values)
 			}
 
 			next.ServeHTTP(w, r)
@@ -0,0 +1,153 @@
+package security
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Finding severities, from most to least pressing.
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// Finding is a risky combination of security headers.
+type Finding struct {
+	Severity string `json:"severity"`
+	Header   string `json:"header"`
+	Message  string `json:"message"`
+}
+
+// ParseCSP splits a Content-Security-Policy into its directives, each with
+// its sources. Later duplicates are ignored, as browsers do.
+func ParseCSP(policy string) map[string][]string {
+	directives := map[string][]string{}
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, seen := directives[name]; !seen {
+			directives[name] = fields[1:]
+		}
+	}
+	return directives
+}
+
+// Check flags risky combinations in a page's header: inline or eval'd
+// script without a nonce or hash to vouch for it, directives that fall back
+// to nothing or to anything, a page that can be framed, and missing or
+// weakened hardening headers. hsts says whether Strict-Transport-Security is
+// expected, which it isn't in development.
+func Check(header http.Header, hsts bool) []Finding {
+	findings := []Finding{}
+	add := func(severity, name, message string) {
+		findings = append(findings, Finding{Severity: severity, Header: name, Message: message})
+	}
+
+	const cspHeader = "Content-Security-Policy"
+	csp := ParseCSP(header.Get(cspHeader))
+	if len(csp) == 0 {
+		add(SeverityHigh, cspHeader, "no Content-Security-Policy is sent")
+	} else {
+		if _, ok := csp["default-src"]; !ok {
+			add(SeverityHigh, cspHeader, "no default-src, so directives not listed allow any source")
+		}
+		if sources := effective(csp, "script-src"); contains(sources, "'unsafe-inline'") && !vouched(sources) {
+			add(SeverityHigh, cspHeader, "script-src allows 'unsafe-inline' without a nonce or hash")
+		}
+		if sources := effective(csp, "script-src"); contains(sources, "'unsafe-eval'") {
+			add(SeverityMedium, cspHeader, "script-src allows 'unsafe-eval'")
+		}
+		if sources := effective(csp, "style-src"); contains(sources, "'unsafe-inline'") && !vouched(sources) {
+			add(SeverityLow, cspHeader, "style-src allows 'unsafe-inline' without a nonce or hash")
+		}
+		for _, name := range []string{"default-src", "script-src", "connect-src", "object-src"} {
+			if sources := effective(csp, name); contains(sources, "*") || contains(sources, "https:") || contains(sources, "http:") {
+				add(SeverityHigh, cspHeader, name+" allows any host")
+			}
+		}
+		if sources := effective(csp, "object-src"); !contains(sources, "'none'") {
+			add(SeverityMedium, cspHeader, "object-src is not 'none', so plugins can load")
+		}
+		if _, ok := csp["base-uri"]; !ok {
+			add(SeverityMedium, cspHeader, "no base-uri, so an injected <base> can redirect relative URLs")
+		}
+		if _, ok := csp["frame-ancestors"]; !ok {
+			add(SeverityMedium, cspHeader, "no frame-ancestors, so framing is only limited by X-Frame-Options")
+		}
+	}
+
+	if _, ok := csp["frame-ancestors"]; !ok {
+		if xfo := strings.ToUpper(header.Get("X-Frame-Options")); xfo != "DENY" && xfo != "SAMEORIGIN" {
+			add(SeverityHigh, "X-Frame-Options", "the page can be framed by any site (clickjacking)")
+		}
+	}
+	return append(findings, checkCommon(header, hsts)...)
+}
+
+// CheckAPI flags risky API response headers: cacheable responses and
+// missing or weakened hardening headers.
+func CheckAPI(header http.Header, hsts bool) []Finding {
+	findings := []Finding{}
+	if !strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-store") {
+		findings = append(findings, Finding{Severity: SeverityMedium, Header: "Cache-Control", Message: "not no-store, so answers may be kept by caches"})
+	}
+	if !strings.EqualFold(header.Get("Pragma"), "no-cache") {
+		findings = append(findings, Finding{Severity: SeverityLow, Header: "Pragma", Message: "not no-cache, so HTTP/1.0 caches may keep answers"})
+	}
+	return append(findings, checkCommon(header, hsts)...)
+}
+
+// checkCommon flags the hardening headers both profiles need.
+func checkCommon(header http.Header, hsts bool) []Finding {
+	findings := []Finding{}
+	add := func(severity, name, message string) {
+		findings = append(findings, Finding{Severity: severity, Header: name, Message: message})
+	}
+	if !strings.EqualFold(header.Get("X-Content-Type-Options"), "nosniff") {
+		add(SeverityMedium, "X-Content-Type-Options", "not nosniff, so browsers may guess content types")
+	}
+	switch strings.ToLower(header.Get("Referrer-Policy")) {
+	case "":
+		add(SeverityLow, "Referrer-Policy", "not set, so the browser default decides what leaks in the Referer header")
+	case "unsafe-url", "no-referrer-when-downgrade":
+		add(SeverityMedium, "Referrer-Policy", "full URLs are sent to other sites")
+	}
+	if hsts && header.Get("Strict-Transport-Security") == "" {
+		add(SeverityMedium, "Strict-Transport-Security", "not sent, so the first visit can be downgraded to plain http")
+	}
+	return findings
+}
+
+// effective returns the sources that govern name: its own, or default-src's
+// when it isn't listed.
+func effective(csp map[string][]string, name string) []string {
+	if sources, ok := csp[name]; ok {
+		return sources
+	}
+	return csp["default-src"]
+}
+
+func contains(sources []string, source string) bool {
+	for _, s := range sources {
+		if strings.EqualFold(s, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// vouched reports whether sources carry a nonce or hash, which makes
+// browsers ignore 'unsafe-inline'.
+func vouched(sources []string) bool {
+	for _, s := range sources {
+		s = strings.ToLower(s)
+		if strings.HasPrefix(s, "'nonce-") || strings.HasPrefix(s, "'sha256-") || strings.HasPrefix(s, "'sha384-") || strings.HasPrefix(s, "'sha512-") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func TestCheckBehavior(t *testing.T) {
+	hardened := func() http.Header {
+		header := http.Header{}
+		header.Set("Content-Security-Policy", "default-src 'self'; script-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Strict-Transport-Security", "max-age=31536000")
+		return header
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(http.Header)
+		hsts     bool
+		expected []string
+	}{
+		{name: "passes a hardened policy", hsts: true},
+		{
+			name: "flags inline script without a nonce",
+			modify: func(h http.Header) {
+				h.Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'")
+			},
+			expected: []string{"script-src allows 'unsafe-inline' without a nonce or hash"},
+		},
+		{
+			name: "accepts inline script vouched for by a nonce",
+			modify: func(h http.Header) {
+				h.Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline' 'nonce-abc'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'")
+			},
+		},
+		{
+			name: "flags inline script inherited from default-src",
+			modify: func(h http.Header) {
+				h.Set("Content-Security-Policy", "default-src 'self' 'unsafe-inline'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'")
+			},
+			expected: []string{"script-src allows 'unsafe-inline' without a nonce or hash", "style-src allows 'unsafe-inline' without a nonce or hash"},
+		},
+		{
+			name: "flags a frameable page",
+			modify: func(h http.Header) {
+				h.Set("Content-Security-Policy", "default-src 'self'; object-src 'none'; base-uri 'self'")
+			},
+			expected: []string{"no frame-ancestors, so framing is only limited by X-Frame-Options", "the page can be framed by any site (clickjacking)"},
+		},
+		{
+			name: "relies on X-Frame-Options without frame-ancestors",
+			modify: func(h http.Header) {
+				h.Set("Content-Security-Policy", "default-src 'self'; object-src 'none'; base-uri 'self'")
+				h.Set("X-Frame-Options", "DENY")
+			},
+			expected: []string{"no frame-ancestors, so framing is only limited by X-Frame-Options"},
+		},
+		{
+			name: "flags a wildcard connect-src",
+			modify: func(h http.Header) {
+				h.Set("Content-Security-Policy", "default-src 'self'; connect-src *; object-src 'none'; base-uri 'self'; frame-ancestors 'none'")
+			},
+			expected: []string{"connect-src allows any host"},
+		},
+		{
+			name:     "flags missing HSTS when it is expected",
+			modify:   func(h http.Header) { h.Del("Strict-Transport-Security") },
+			hsts:     true,
+			expected: []string{"not sent, so the first visit can be downgraded to plain http"},
+		},
+		{
+			name:     "flags a missing policy",
+			modify:   func(h http.Header) { h.Del("Content-Security-Policy"); h.Set("X-Frame-Options", "DENY") },
+			expected: []string{"no Content-Security-Policy is sent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := hardened()
+			if tt.modify != nil {
+				tt.modify(header)
+			}
+			findings := Check(header, tt.hsts)
+			if len(findings) != len(tt.expected) {
+				t.Fatalf("expected %d findings, got %+v", len(tt.expected), findings)
+			}
+			for i, finding := range findings {
+				if finding.Message != tt.expected[i] {
+					t.Errorf("expected finding %q, got %q", tt.expected[i], finding.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestHeadersBehavior(t *testing.T) {
+	cfg := &config.Config{Environment: "production"}
+	cfg.Security.EnableHSTS = true
+	cfg.Security.AllowedAPIEndpoints = []string{"https://api.anthropic.com"}
+	cfg.Security.ReferrerPolicy = "no-referrer"
+	cfg.Security.ContentTypeOptions = "nosniff"
+	cfg.Security.FrameOptions = "DENY"
+	cfg.Security.CrossOriginResourcePolicy = "same-site"
+	cfg.Security.PermissionsPolicy = "geolocation=()"
+
+	header := Headers(cfg)
+	for _, finding := range Check(header, true) {
+		if finding.Severity != SeverityLow {
+			t.Errorf("expected the production headers to raise only low findings, got %+v", finding)
+		}
+	}
+	if header.Get("Strict-Transport-Security") == "" {
+		t.Error("expected HSTS in production")
+	}
+
+	t.Run("follows the configured values", func(t *testing.T) {
+		cfg.Security.ReferrerPolicy = "strict-origin-when-cross-origin"
+		cfg.Security.ContentTypeOptions = "off"
+		cfg.Security.FrameOptions = "SAMEORIGIN"
+		cfg.Security.PermissionsPolicy = ""
+
+		header := Headers(cfg)
+		if header.Get("Referrer-Policy") != "strict-origin-when-cross-origin" || header.Get("X-Frame-Options") != "SAMEORIGIN" {
+			t.Errorf("expected the configured values, got %v", header)
+		}
+		if _, ok := header["X-Content-Type-Options"]; ok {
+			t.Error("expected X-Content-Type-Options to be left out when off")
+		}
+		if _, ok := header["Permissions-Policy"]; ok {
+			t.Error("expected Permissions-Policy to be left out when empty")
+		}
+		if frame := ParseCSP(header.Get("Content-Security-Policy"))["frame-ancestors"]; len(frame) != 1 || frame[0] != "'self'" {
+			t.Errorf("expected frame-ancestors to follow FRAME_OPTIONS, got %v", frame)
+		}
+	})
+}
+
+func TestProfilesBehavior(t *testing.T) {
+	cfg := &config.Config{Environment: "production"}
+	cfg.Security.EnableHSTS = true
+	cfg.Security.ReferrerPolicy = "no-referrer"
+	cfg.Security.ContentTypeOptions = "nosniff"
+	cfg.Security.FrameOptions = "DENY"
+	handler := SecurityHeaders(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/i18n" {
+			AllowCaching(w, "public, max-age=300")
+		}
+	}))
+
+	tests := []struct {
+		path      string
+		expectAPI bool
+		cache     string
+		pragma    string
+	}{
+		{path: "/", expectAPI: false},
+		{path: "/chat.js", expectAPI: false},
+		{path: "/api/messages", expectAPI: true, cache: "no-store", pragma: "no-cache"},
+		{path: "/t/acme/api/models", expectAPI: true, cache: "no-store", pragma: "no-cache"},
+		{path: "/anthropic/v1/messages", expectAPI: true, cache: "no-store", pragma: "no-cache"},
+		{path: "/api/i18n", expectAPI: true, cache: "public, max-age=300"},
+		{path: "/apiary", expectAPI: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if hasCSP := w.Header().Get("Content-Security-Policy") != ""; hasCSP == tt.expectAPI {
+				t.Errorf("expected a CSP only on pages, got %q", w.Header().Get("Content-Security-Policy"))
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.cache {
+				t.Errorf("expected Cache-Control %q, got %q", tt.cache, got)
+			}
+			if got := w.Header().Get("Pragma"); got != tt.pragma {
+				t.Errorf("expected Pragma %q, got %q", tt.pragma, got)
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Strict-Transport-Security") == "" {
+				t.Errorf("expected the shared hardening headers, got %v", w.Header())
+			}
+		})
+	}
+
+	if findings := CheckAPI(APIHeaders(cfg), true); len(findings) != 0 {
+		t.Errorf("expected the API profile to pass its check, got %+v", findings)
+	}
+	cacheable := APIHeaders(cfg)
+	cacheable.Set("Cache-Control", "public, max-age=60")
+	cacheable.Del("Pragma")
+	if findings := CheckAPI(cacheable, true); len(findings) != 2 || findings[0].Header != "Cache-Control" || findings[1].Header != "Pragma" {
+		t.Errorf("expected cacheable API responses to be flagged, got %+v", findings)
+	}
+}
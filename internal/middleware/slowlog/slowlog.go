@@ -0,0 +1,53 @@
+package slowlog
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/timing"
+	"github.com/manto/manto-web/internal/tracing"
+)
+
+// Middleware attaches a timing.Timings to each request and logs a warning
+// with the recorded phase breakdown when the request exceeds the configured
+// threshold, with the caller's trace ID when it sent one. A zero threshold
+// disables it.
+func Middleware(cfg *config.Config, logger *slog.Logger) func(http.Handler) http.Handler {
+	threshold := cfg.Logging.SlowRequestThreshold.Duration
+
+	return func(next http.Handler) http.Handler {
+		if threshold <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timings := timing.New()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(timing.NewContext(r.Context(), timings)))
+
+			total := timings.Elapsed()
+			if total < threshold {
+				return
+			}
+
+			attrs := []any{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", ww.Status()),
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+				slog.Duration("total", total),
+				slog.Duration("threshold", threshold),
+			}
+			if sc, ok := tracing.FromContext(r.Context()); ok {
+				attrs = append(attrs, slog.String("trace_id", sc.TraceID))
+			}
+			for _, phase := range timings.Phases() {
+				attrs = append(attrs, slog.Duration(phase.Name, phase.Duration))
+			}
+			logger.Warn("slow request", attrs...)
+		})
+	}
+}
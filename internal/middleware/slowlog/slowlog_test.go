@@ -0,0 +1,66 @@
+package slowlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/timing"
+)
+
+func TestMiddlewareBehavior(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		sleep     time.Duration
+		expectLog bool
+	}{
+		{name: "logs requests over the threshold", threshold: 5 * time.Millisecond, sleep: 10 * time.Millisecond, expectLog: true},
+		{name: "ignores fast requests", threshold: time.Second, expectLog: false},
+		{name: "disabled with zero threshold", threshold: 0, sleep: 5 * time.Millisecond, expectLog: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Logging.SlowRequestThreshold = config.Duration{Duration: tt.threshold}
+
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+			handler := Middleware(cfg, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				timing.FromContext(r.Context()).Record("validation", time.Millisecond)
+				time.Sleep(tt.sleep)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/messages", nil))
+
+			if !tt.expectLog {
+				if buf.Len() != 0 {
+					t.Errorf("expected no log output, got %s", buf.String())
+				}
+				return
+			}
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("expected a JSON log entry, got %q: %v", buf.String(), err)
+			}
+			if entry["level"] != "WARN" {
+				t.Errorf("expected WARN level, got %v", entry["level"])
+			}
+			if entry["path"] != "/api/messages" || entry["status"] != float64(http.StatusAccepted) {
+				t.Errorf("expected path and status in log entry, got %v", entry)
+			}
+			if _, ok := entry["validation"]; !ok {
+				t.Errorf("expected recorded phase in log entry, got %v", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package timeout
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/middleware/route"
+)
+
+// writeMargin is how long before the server's WriteTimeout a request is
+// given up, leaving time to send the 504 before the connection is cut.
+const writeMargin = time.Second
+
+// Timeout bounds how long a request may take, and answers one that runs out
+// of time with a JSON 504 rather than a dropped connection.
+type Timeout struct {
+	budget   time.Duration
+	logger   *slog.Logger
+	timeouts *metrics.CounterVec
+}
+
+// New bounds requests to REQUEST_TIMEOUT, or to just under WRITE_TIMEOUT
+// when that comes first: past it the server closes the connection, and the
+// client would see a reset instead of an error.
+func New(cfg *config.Config, logger *slog.Logger, registry *metrics.Registry) *Timeout {
+	budget := cfg.Server.RequestTimeout.Duration
+	if write := cfg.Server.WriteTimeout.Duration; write > 0 {
+		write -= min(writeMargin, write/10)
+		if budget <= 0 || write < budget {
+			budget = write
+		}
+	}
+	return &Timeout{
+		budget:   budget,
+		logger:   logger,
+		timeouts: registry.Counter("manto_http_request_timeouts_total", "Requests that ran out of time, by route and whether the response had started (before_response, mid_response).", "route", "stage"),
+	}
+}
+
+// Middleware gives each request's context a deadline. When it passes before
+// the response has started, the client gets a 504 with the elapsed time and
+// the request ID, and whatever the handler writes afterwards is dropped.
+// Responses already under way can only be cut short; they are logged so the
+// reset can be explained. Handlers that stream lift the deadline with Stream.
+func (t *Timeout) Middleware(next http.Handler) http.Handler {
+	if t.budget <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(r.Context(), t.budget)
+		defer cancel()
+
+		budget := &budget{parent: r.Context()}
+		tw := &timeoutWriter{ResponseWriter: w, ctx: ctx, budget: budget, header: make(http.Header), requestID: middleware.GetReqID(ctx), start: start}
+		budget.timer = time.AfterFunc(t.budget, func() { tw.timeout() })
+		defer budget.timer.Stop()
+
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(ctx, budgetKey{}, budget)))
+
+		if budget.lifted.Load() || ctx.Err() != context.DeadlineExceeded {
+			return
+		}
+		tw.timeout()
+		stage := "before_response"
+		if !tw.timedOut {
+			stage = "mid_response"
+		}
+		t.timeouts.Inc(route.Pattern(r), stage)
+		t.logger.Warn("request timed out",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("request_id", tw.requestID),
+			slog.String("stage", stage),
+			slog.Duration("elapsed", time.Since(start)),
+			slog.Duration("timeout", t.budget))
+	})
+}
+
+type budgetKey struct{}
+
+// budget is a request's deadline as Stream sees it: the context from before
+// the deadline, and the timer that answers with a 504.
+type budget struct {
+	parent context.Context
+	timer  *time.Timer
+	lifted atomic.Bool
+}
+
+// Stream lifts the deadline from a request whose handler is about to stream
+// its response, such as server-sent events, which may run as long as the
+// client keeps listening. The returned request keeps r's values, but its
+// context is only cancelled when the client goes away. If no deadline
+// applies, or it has already passed, r is returned unchanged.
+func Stream(r *http.Request) *http.Request {
+	b, ok := r.Context().Value(budgetKey{}).(*budget)
+	if !ok || b.lifted.Load() || !b.timer.Stop() {
+		return r
+	}
+	b.lifted.Store(true)
+	return r.WithContext(streamContext{Context: context.WithoutCancel(r.Context()), parent: b.parent})
+}
+
+// streamContext carries a request's values without its deadline, and is
+// done when the request's context was before the deadline was added.
+type streamContext struct {
+	context.Context
+	parent context.Context
+}
+
+func (c streamContext) Deadline() (time.Time, bool) { return c.parent.Deadline() }
+func (c streamContext) Done() <-chan struct{}       { return c.parent.Done() }
+func (c streamContext) Err() error                  { return c.parent.Err() }
+
+// timeoutWriter lets the deadline answer for a handler that hasn't started
+// its response. Once either has written the header, the other's writes are
+// the only ones that go through. Until then the handler's headers are kept
+// apart, so the deadline can answer while the handler is still setting them.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	budget    *budget
+	header    http.Header
+	requestID string
+	start     time.Time
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+// timeout sends the 504 unless the response has started.
+func (w *timeoutWriter) timeout() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader {
+		return
+	}
+	w.writeTimeout()
+}
+
+// writeTimeout sends the 504. The caller holds w.mu.
+func (w *timeoutWriter) writeTimeout() {
+	w.wroteHeader, w.timedOut = true, true
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w.ResponseWriter).Encode(map[string]interface{}{
+		"error":      "Request timed out",
+		"code":       "request_timeout",
+		"elapsed_ms": time.Since(w.start).Milliseconds(),
+		"request_id": w.requestID,
+	})
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Header returns the handler's headers, which are the response's own once
+// it has started, so trailers set after the body still go out.
+func (w *timeoutWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader && !w.timedOut {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+// WriteHeader passes the handler's status on, unless the deadline has
+// passed first and wasn't lifted: then the handler's own error, typically a
+// failed upstream call, gives way to the 504.
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader {
+		return
+	}
+	if w.ctx.Err() == context.DeadlineExceeded && !w.budget.lifted.Load() {
+		w.writeTimeout()
+		return
+	}
+	w.wroteHeader = true
+	dst := w.ResponseWriter.Header()
+	for name, values := range w.header {
+		dst[name] = values
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush passes flushes on for streamed answers, starting the response if
+// the handler hasn't.
+func (w *timeoutWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !w.timedOut {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
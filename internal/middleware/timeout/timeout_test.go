@@ -0,0 +1,166 @@
+package timeout
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+func TestTimeoutBehavior(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.RequestTimeout = config.Duration{Duration: 50 * time.Millisecond}
+
+	var logs bytes.Buffer
+	registry := metrics.NewRegistry()
+	limiter := New(cfg, slog.New(slog.NewJSONHandler(&logs, nil)), registry)
+
+	finished := make(chan struct{})
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(limiter.Middleware)
+	r.Get("/api/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Fast", "yes")
+		w.Write([]byte("ok"))
+	})
+	r.Get("/api/slow/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Slow", "yes")
+		defer close(finished)
+		<-r.Context().Done()
+		time.Sleep(100 * time.Millisecond)
+		http.Error(w, "upstream failed", http.StatusBadRequest)
+	})
+	r.Get("/api/late", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		http.Error(w, r.Context().Err().Error(), http.StatusBadGateway)
+	})
+	r.Get("/api/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: first\n\n"))
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+	})
+	r.Get("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		r = Stream(r)
+		time.Sleep(100 * time.Millisecond)
+		if err := r.Context().Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("X-Request-Id", middleware.GetReqID(r.Context()))
+		w.Write([]byte("data: late\n\n"))
+	})
+
+	t.Run("fast requests pass through", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/api/fast", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "ok" || w.Header().Get("X-Fast") != "yes" {
+			t.Errorf("expected the handler's response, got %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+	})
+
+	t.Run("deadline answers before the handler does", func(t *testing.T) {
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL + "/api/slow/42")
+		if err != nil {
+			t.Fatalf("expected a response, not %v", err)
+		}
+		defer resp.Body.Close()
+		select {
+		case <-finished:
+			t.Error("expected the 504 before the handler returned")
+		default:
+		}
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Fatalf("expected status 504, got %d", resp.StatusCode)
+		}
+		if resp.Header.Get("X-Slow") != "" {
+			t.Errorf("expected the handler's headers to be dropped, got %v", resp.Header)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("expected JSON error body: %v", err)
+		}
+		if body["code"] != "request_timeout" || body["request_id"] == "" || body["elapsed_ms"].(float64) < 50 {
+			t.Errorf("expected code, request_id and elapsed_ms in body, got %v", body)
+		}
+	})
+
+	t.Run("handler errors after the deadline become a 504", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/api/late", nil))
+		if w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("expected status 504, got %d", w.Code)
+		}
+		if strings.Contains(w.Body.String(), "deadline") {
+			t.Errorf("expected the handler's body to be dropped, got %q", w.Body.String())
+		}
+	})
+
+	t.Run("started responses are left alone and logged", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/api/stream", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "data: first\n\n" {
+			t.Errorf("expected the stream as sent, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("streams can lift the deadline", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/api/events", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "data: late\n\n" || w.Header().Get("X-Request-Id") == "" {
+			t.Errorf("expected the stream past the deadline with the request's values, got %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+	})
+
+	logged := logs.String()
+	for _, want := range []string{`"stage":"before_response"`, `"stage":"mid_response"`, `"request_id":`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("expected %s in logs, got %s", want, logged)
+		}
+	}
+	timeouts := registry.Counter("manto_http_request_timeouts_total", "")
+	if got := timeouts.Value("/api/late", "before_response"); got != 1 {
+		t.Errorf("expected one timeout before the response on /api/late, got %v", got)
+	}
+	if got := timeouts.Value("/api/stream", "mid_response"); got != 1 {
+		t.Errorf("expected one timeout mid-response on /api/stream, got %v", got)
+	}
+	if got := timeouts.Value("/api/events", "before_response") + timeouts.Value("/api/events", "mid_response"); got != 0 {
+		t.Errorf("expected no timeouts on a lifted stream, got %v", got)
+	}
+}
+
+func TestTimeoutBudget(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		request, write time.Duration
+		want           time.Duration
+	}{
+		{"request timeout within write timeout", 10 * time.Second, 30 * time.Second, 10 * time.Second},
+		{"cut to just under the write timeout", 60 * time.Second, 30 * time.Second, 29 * time.Second},
+		{"short write timeouts keep a tenth", 60 * time.Second, 5 * time.Second, 4500 * time.Millisecond},
+		{"no request timeout", 0, 30 * time.Second, 29 * time.Second},
+		{"no write timeout", 60 * time.Second, 0, 60 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Server.RequestTimeout = config.Duration{Duration: tc.request}
+			cfg.Server.WriteTimeout = config.Duration{Duration: tc.write}
+			if got := New(cfg, slog.Default(), metrics.NewRegistry()).budget; got != tc.want {
+				t.Errorf("expected budget %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+// PatternChecker reports every match of a regular expression.
+type PatternChecker struct {
+	name   string
+	rule   string
+	action string
+	re     *regexp.Regexp
+}
+
+// NewPatternChecker matches pattern, reporting findings as rule.
+func NewPatternChecker(name, rule, action string, re *regexp.Regexp) *PatternChecker {
+	return &PatternChecker{name: name, rule: rule, action: action, re: re}
+}
+
+// NewKeywordChecker matches any of keywords as whole words, ignoring case.
+// It returns nil when there are no keywords.
+func NewKeywordChecker(action string, keywords []string) *PatternChecker {
+	var quoted []string
+	for _, k := range keywords {
+		if k = strings.TrimSpace(k); k != "" {
+			quoted = append(quoted, regexp.QuoteMeta(k))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	re := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	return NewPatternChecker("keywords", action, action, re)
+}
+
+func (c *PatternChecker) Name() string { return c.name }
+
+func (c *PatternChecker) Check(ctx context.Context, text string) ([]Finding, error) {
+	var findings []Finding
+	for _, loc := range c.re.FindAllStringIndex(text, -1) {
+		findings = append(findings, Finding{Checker: c.name, Rule: c.rule, Action: c.action, Start: loc[0], End: loc[1]})
+	}
+	return findings, nil
+}
+
+// RemoteChecker asks an external moderation API about the whole text. It
+// speaks the widely used shape of POST {"input": text} answered with
+// {"results": [{"flagged": bool, "categories": {"name": bool}}]}.
+type RemoteChecker struct {
+	url    string
+	apiKey string
+	action string
+	client *http.Client
+}
+
+func NewRemoteChecker(url, apiKey, action string, client *http.Client) *RemoteChecker {
+	return &RemoteChecker{url: url, apiKey: apiKey, action: action, client: client}
+}
+
+func (c *RemoteChecker) Name() string { return "remote" }
+
+func (c *RemoteChecker) Check(ctx context.Context, text string) ([]Finding, error) {
+	body, err := json.Marshal(map[string]string{"input": text})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation API returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid moderation API response: %w", err)
+	}
+
+	var findings []Finding
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		var categories []string
+		for name, hit := range r.Categories {
+			if hit {
+				categories = append(categories, name)
+			}
+		}
+		sort.Strings(categories)
+		rule := strings.Join(categories, "+")
+		if rule == "" {
+			rule = "flagged"
+		}
+		findings = append(findings, Finding{Checker: c.Name(), Rule: rule, Action: c.action, End: len(text)})
+	}
+	return findings, nil
+}
+
+// piiActions maps PII_ACTION and SECRET_SCAN_ACTION to pipeline actions.
+var piiActions = map[string]string{
+	"block": ActionBlock,
+	"mask":  ActionRedact,
+	"warn":  ActionFlag,
+}
+
+// New builds the deployment's pipeline from cfg, pii and secrets, or returns
+// nil when no checker is configured. Patterns have already been validated by
+// config.Load. PII and secret scanning only apply to prompts.
+func New(cfg config.ModerationConfig, pii config.PIIConfig, secrets config.SecretsConfig, client *http.Client, logger *slog.Logger) *Pipeline {
+	var local []Checker
+	for _, rule := range []struct {
+		action   string
+		keywords []string
+		pattern  string
+	}{
+		{ActionBlock, cfg.BlockKeywords, cfg.BlockPattern},
+		{ActionRedact, cfg.RedactKeywords, cfg.RedactPattern},
+		{ActionFlag, cfg.FlagKeywords, cfg.FlagPattern},
+	} {
+		if c := NewKeywordChecker(rule.action, rule.keywords); c != nil {
+			local = append(local, c)
+		}
+		if rule.pattern != "" {
+			local = append(local, NewPatternChecker("pattern", rule.action, rule.action, regexp.MustCompile(rule.pattern)))
+		}
+	}
+
+	p := NewPipeline(cfg.FailClosed, logger)
+	for _, stage := range cfg.Stages {
+		p.Add(stage, false, local...)
+		if cfg.APIURL != "" {
+			p.Add(stage, true, NewRemoteChecker(cfg.APIURL, cfg.APIKey, cfg.APIAction, client))
+		}
+	}
+	if action, ok := piiActions[pii.Action]; ok {
+		var custom *regexp.Regexp
+		if pii.Pattern != "" {
+			custom = regexp.MustCompile(pii.Pattern)
+		}
+		p.Add(StageInput, false, NewPIIChecker(action, pii.Detectors, custom))
+	}
+	if action, ok := piiActions[secrets.Action]; ok {
+		p.Add(StageInput, false, NewSecretChecker(action, secrets.MinEntropy))
+	}
+	if !p.Enabled(StageInput) && !p.Enabled(StageOutput) {
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,274 @@
+// Package moderation checks prompts before they are sent upstream and answers
+// before they are returned. Checkers (keyword lists, regular expressions, PII
+// detectors, an external moderation API) report findings, each carrying the
+// action the deployment configured for it: flag lets the content through but
+// records it, redact masks the matched text, and block refuses the request.
+// Every action taken is audit-logged without the content itself.
+package moderation
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ActionFlag   = "flag"
+	ActionRedact = "redact"
+	ActionBlock  = "block"
+
+	StageInput  = "input"
+	StageOutput = "output"
+
+	// Mask replaces redacted text.
+	Mask = "[redacted]"
+)
+
+// ErrBlocked is returned when a check blocks the content.
+var ErrBlocked = errors.New("content blocked by moderation")
+
+func severity(action string) int {
+	switch action {
+	case ActionFlag:
+		return 1
+	case ActionRedact:
+		return 2
+	case ActionBlock:
+		return 3
+	}
+	return 0
+}
+
+// Finding is one thing a checker objected to. Start and End delimit the
+// offending text for redaction; a finding about the whole text spans all of
+// it. Mask, when set, replaces the text instead of the default Mask.
+type Finding struct {
+	Checker string
+	Rule    string
+	Action  string
+	Start   int
+	End     int
+	Mask    string
+}
+
+// Checker inspects text.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context, text string) ([]Finding, error)
+}
+
+// Verdict is the outcome of checking one text: the strictest action any
+// finding asked for, and the text with redactions applied.
+type Verdict struct {
+	Action   string
+	Text     string
+	Findings []Finding
+
+	// original is the text as checked, for recorders.
+	original string
+}
+
+// Event is an action taken, as handed to a Recorder. Text is the content as
+// checked; recorders decide what, if anything, of it to keep.
+type Event struct {
+	Time        time.Time
+	Stage       string
+	Action      string
+	Checkers    []string
+	Rules       []string
+	Text        string
+	Findings    []Finding
+	Attribution map[string]string
+}
+
+// Recorder keeps a record of moderation actions, e.g. for compliance review.
+type Recorder interface {
+	Record(Event)
+}
+
+// Pipeline runs the checkers registered for each stage. Local checkers are
+// cheap and see every message; remote ones call out, so on input they only
+// see the newest message, earlier turns having been checked when they were
+// sent. A nil *Pipeline lets everything through.
+type Pipeline struct {
+	local      map[string][]Checker
+	remote     map[string][]Checker
+	failClosed bool
+	logger     *slog.Logger
+	recorder   Recorder
+}
+
+// NewPipeline builds an empty pipeline. When failClosed is set, a remote
+// checker that cannot be reached blocks the content rather than letting it
+// through.
+func NewPipeline(failClosed bool, logger *slog.Logger) *Pipeline {
+	return &Pipeline{
+		local:      map[string][]Checker{},
+		remote:     map[string][]Checker{},
+		failClosed: failClosed,
+		logger:     logger,
+	}
+}
+
+// WithRecorder hands every audited action to recorder as well as the log.
+func (p *Pipeline) WithRecorder(recorder Recorder) *Pipeline {
+	if p != nil {
+		p.recorder = recorder
+	}
+	return p
+}
+
+// Add registers checkers for stage.
+func (p *Pipeline) Add(stage string, remote bool, checkers ...Checker) {
+	if remote {
+		p.remote[stage] = append(p.remote[stage], checkers...)
+	} else {
+		p.local[stage] = append(p.local[stage], checkers...)
+	}
+}
+
+// Enabled reports whether stage has any checkers.
+func (p *Pipeline) Enabled(stage string) bool {
+	return p != nil && len(p.local[stage])+len(p.remote[stage]) > 0
+}
+
+// Check runs the stage's checkers over text, including remote ones when
+// remote is set.
+func (p *Pipeline) Check(ctx context.Context, stage, text string, remote bool) Verdict {
+	verdict := Verdict{Text: text, original: text}
+	if !p.Enabled(stage) || text == "" {
+		return verdict
+	}
+
+	checkers := p.local[stage]
+	if remote {
+		checkers = append(append([]Checker{}, p.local[stage]...), p.remote[stage]...)
+	}
+	for _, checker := range checkers {
+		findings, err := checker.Check(ctx, text)
+		if err != nil {
+			p.logger.Warn("moderation check failed",
+				slog.String("stage", stage),
+				slog.String("checker", checker.Name()),
+				slog.String("error", err.Error()))
+			if p.failClosed {
+				findings = []Finding{{Checker: checker.Name(), Rule: "unavailable", Action: ActionBlock, End: len(text)}}
+			}
+		}
+		verdict.Findings = append(verdict.Findings, findings...)
+	}
+
+	for _, f := range verdict.Findings {
+		if severity(f.Action) > severity(verdict.Action) {
+			verdict.Action = f.Action
+		}
+	}
+	if verdict.Action == ActionRedact {
+		verdict.Text = redact(text, verdict.Findings)
+	}
+	return verdict
+}
+
+// MaskFindings masks every span any finding covers, whatever its action.
+func MaskFindings(text string, findings []Finding) string {
+	masked := make([]Finding, len(findings))
+	for i, f := range findings {
+		f.Action = ActionRedact
+		masked[i] = f
+	}
+	return redact(text, masked)
+}
+
+// redact masks every span a redact finding covers, merging overlaps; a
+// merged span takes the mask of the finding that starts first.
+func redact(text string, findings []Finding) string {
+	type span struct {
+		start, end int
+		mask       string
+	}
+	var spans []span
+	for _, f := range findings {
+		if f.Action == ActionRedact && f.Start < f.End {
+			mask := f.Mask
+			if mask == "" {
+				mask = Mask
+			}
+			spans = append(spans, span{f.Start, f.End, mask})
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var merged []span
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+			merged[n-1].end = max(merged[n-1].end, s.end)
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, s := range merged {
+		b.WriteString(text[last:s.start])
+		b.WriteString(s.mask)
+		last = s.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Audit logs the action taken on a verdict, naming the checkers and rules
+// involved but never the content, and passes it on to the recorder, if any.
+// attrs identify the request, e.g. the key fingerprint and model.
+func (p *Pipeline) Audit(stage string, verdict Verdict, attrs ...slog.Attr) {
+	if p == nil || verdict.Action == "" {
+		return
+	}
+	var checkers, rules []string
+	for _, f := range verdict.Findings {
+		checkers = append(checkers, f.Checker)
+		rules = append(rules, f.Rule)
+	}
+	checkers, rules = dedupe(checkers), dedupe(rules)
+	args := []any{
+		slog.String("stage", stage),
+		slog.String("action", verdict.Action),
+		slog.String("checkers", strings.Join(checkers, ",")),
+		slog.String("rules", strings.Join(rules, ",")),
+	}
+	attribution := map[string]string{}
+	for _, attr := range attrs {
+		args = append(args, attr)
+		attribution[attr.Key] = attr.Value.String()
+	}
+	p.logger.Info("moderation action", args...)
+
+	if p.recorder != nil {
+		p.recorder.Record(Event{
+			Time:        time.Now().UTC(),
+			Stage:       stage,
+			Action:      verdict.Action,
+			Checkers:    checkers,
+			Rules:       rules,
+			Text:        verdict.original,
+			Findings:    verdict.Findings,
+			Attribution: attribution,
+		})
+	}
+}
+
+func dedupe(values []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package moderation
+
+import (
+	"context"
+	"regexp"
+)
+
+// PII detectors, as named in PII_DETECTORS. Custom patterns report as
+// PIICustom.
+const (
+	PIIEmail  = "email"
+	PIIPhone  = "phone"
+	PIICard   = "card"
+	PIICustom = "custom"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{1,4}\)[\s.-]?)?\b\d{2,5}(?:[\s.-]?\d{2,5}){1,4}\b`)
+	datePattern  = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+	cardPattern  = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+)
+
+// PIIChecker finds personal data: email addresses, phone numbers, payment
+// card numbers that pass the Luhn check, and an optional custom pattern.
+// Redacted matches are masked with the detector's name, e.g. "[email]", so
+// the model can still make sense of the prompt.
+type PIIChecker struct {
+	detectors map[string]bool
+	custom    *regexp.Regexp
+	action    string
+}
+
+// NewPIIChecker applies action to the named detectors' matches and to
+// custom's, when it is not nil.
+func NewPIIChecker(action string, detectors []string, custom *regexp.Regexp) *PIIChecker {
+	c := &PIIChecker{detectors: map[string]bool{}, custom: custom, action: action}
+	for _, d := range detectors {
+		c.detectors[d] = true
+	}
+	return c
+}
+
+func (c *PIIChecker) Name() string { return "pii" }
+
+func (c *PIIChecker) Check(ctx context.Context, text string) ([]Finding, error) {
+	var findings []Finding
+	add := func(rule string, start, end int) {
+		findings = append(findings, Finding{Checker: c.Name(), Rule: rule, Action: c.action, Start: start, End: end, Mask: "[" + rule + "]"})
+	}
+
+	// Cards first: their digit runs also look like phone numbers, and the
+	// earlier finding names a merged redaction.
+	if c.detectors[PIICard] {
+		for _, loc := range cardPattern.FindAllStringIndex(text, -1) {
+			if luhn(text[loc[0]:loc[1]]) {
+				add(PIICard, loc[0], loc[1])
+			}
+		}
+	}
+	if c.detectors[PIIEmail] {
+		for _, loc := range emailPattern.FindAllStringIndex(text, -1) {
+			add(PIIEmail, loc[0], loc[1])
+		}
+	}
+	if c.detectors[PIIPhone] {
+		for _, loc := range phonePattern.FindAllStringIndex(text, -1) {
+			if n := digits(text[loc[0]:loc[1]]); n >= 9 && n <= 15 && !datePattern.MatchString(text[loc[0]:loc[1]]) && !c.covered(findings, loc) {
+				add(PIIPhone, loc[0], loc[1])
+			}
+		}
+	}
+	if c.custom != nil {
+		for _, loc := range c.custom.FindAllStringIndex(text, -1) {
+			add(PIICustom, loc[0], loc[1])
+		}
+	}
+	return findings, nil
+}
+
+// covered reports whether a card finding already spans loc.
+func (c *PIIChecker) covered(findings []Finding, loc []int) bool {
+	for _, f := range findings {
+		if f.Rule == PIICard && f.Start <= loc[0] && loc[1] <= f.End {
+			return true
+		}
+	}
+	return false
+}
+
+func digits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		d := int(s[i] - '0')
+		if d < 0 || d > 9 {
+			continue
+		}
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
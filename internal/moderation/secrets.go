@@ -0,0 +1,107 @@
+package moderation
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"unicode"
+)
+
+// Secret rules, as reported in findings.
+const (
+	SecretAWSKey      = "aws-key"
+	SecretGitHubToken = "github-token"
+	SecretPrivateKey  = "private-key"
+	SecretHighEntropy = "high-entropy"
+)
+
+var (
+	secretPatterns = []struct {
+		rule string
+		re   *regexp.Regexp
+	}{
+		{SecretAWSKey, regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{SecretGitHubToken, regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+		{SecretPrivateKey, regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`)},
+	}
+	tokenPattern = regexp.MustCompile(`[A-Za-z0-9+/=_-]{24,}`)
+)
+
+type secretsOverrideKey struct{}
+
+// AllowSecrets returns a context in which secret findings only flag the
+// content, for deployments that let clients override the scan.
+func AllowSecrets(ctx context.Context) context.Context {
+	return context.WithValue(ctx, secretsOverrideKey{}, true)
+}
+
+// SecretChecker finds credentials: AWS access keys, GitHub tokens, private
+// key headers and, when minEntropy is positive, long tokens whose Shannon
+// entropy per character reaches it.
+type SecretChecker struct {
+	action     string
+	minEntropy float64
+}
+
+func NewSecretChecker(action string, minEntropy float64) *SecretChecker {
+	return &SecretChecker{action: action, minEntropy: minEntropy}
+}
+
+func (c *SecretChecker) Name() string { return "secrets" }
+
+func (c *SecretChecker) Check(ctx context.Context, text string) ([]Finding, error) {
+	action := c.action
+	if allowed, _ := ctx.Value(secretsOverrideKey{}).(bool); allowed {
+		action = ActionFlag
+	}
+
+	var findings []Finding
+	for _, p := range secretPatterns {
+		for _, loc := range p.re.FindAllStringIndex(text, -1) {
+			findings = append(findings, Finding{Checker: c.Name(), Rule: p.rule, Action: action, Start: loc[0], End: loc[1]})
+		}
+	}
+	if c.minEntropy > 0 {
+		for _, loc := range tokenPattern.FindAllStringIndex(text, -1) {
+			token := text[loc[0]:loc[1]]
+			if mixed(token) && entropy(token) >= c.minEntropy && !overlaps(findings, loc) {
+				findings = append(findings, Finding{Checker: c.Name(), Rule: SecretHighEntropy, Action: action, Start: loc[0], End: loc[1]})
+			}
+		}
+	}
+	return findings, nil
+}
+
+// mixed reports whether s has both letters and digits, which rules out long
+// words, paths and the like.
+func mixed(s string) bool {
+	var letter, digit bool
+	for _, r := range s {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	return letter && digit
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	var h float64
+	for _, n := range counts {
+		p := float64(n) / float64(len(s))
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+func overlaps(findings []Finding, loc []int) bool {
+	for _, f := range findings {
+		if f.Start < loc[1] && loc[0] < f.End {
+			return true
+		}
+	}
+	return false
+}
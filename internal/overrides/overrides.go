@@ -0,0 +1,230 @@
+// Package overrides lets an operator change the default system message, the
+// models clients may use, and which optional features are on, through the
+// admin API while the server runs, instead of editing the environment and
+// restarting. Overrides apply to the whole deployment or to one tenant, and
+// are kept in a JSON file so they survive restarts.
+package overrides
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/atomicfile"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/tenants"
+)
+
+// DefaultScope names the overrides for the whole deployment; any other
+// scope is a tenant ID.
+const DefaultScope = "default"
+
+// Features are the optional features an override can switch off. Features
+// not enabled in the environment can't be switched on, as their routes are
+// only mounted at startup.
+var Features = []string{"conversations", "batch", "evals", "summarize", "speech", "passthrough", "titles"}
+
+// Override is one scope's changes. A nil System or Models leaves the setting
+// as it is; Features maps a feature to whether it is on.
+type Override struct {
+	System    *string         `json:"system,omitempty"`
+	Models    []string        `json:"models"`
+	Features  map[string]bool `json:"features,omitempty"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// Validate checks the override only names known features.
+func (o *Override) Validate() error {
+	for feature := range o.Features {
+		if !slices.Contains(Features, feature) {
+			return fmt.Errorf("unknown feature %q", feature)
+		}
+	}
+	return nil
+}
+
+// Store keeps overrides by scope in memory, optionally snapshotting them to
+// a JSON file after every change.
+type Store struct {
+	path string
+
+	mu        sync.RWMutex
+	overrides map[string]Override
+}
+
+// NewStore opens a store backed by path. An empty path keeps overrides in
+// memory only, so they last until the server restarts.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, overrides: make(map[string]Override)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides: %w", err)
+	}
+	if s.overrides, err = parseSnapshot(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseSnapshot(data []byte) (map[string]Override, error) {
+	var snap map[string]Override
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse overrides: %w", err)
+	}
+	if snap == nil {
+		snap = make(map[string]Override)
+	}
+	return snap, nil
+}
+
+// Replace swaps the store's contents for the snapshot in data, as written to
+// its file, and persists them.
+func (s *Store) Replace(data []byte) error {
+	overrides, err := parseSnapshot(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides = overrides
+	return s.persist()
+}
+
+// All returns every scope's override.
+func (s *Store) All() map[string]Override {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make(map[string]Override, len(s.overrides))
+	for scope, o := range s.overrides {
+		all[scope] = o
+	}
+	return all
+}
+
+// Set replaces scope's override.
+func (s *Store) Set(scope string, o Override) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[scope] = o
+	return s.persist()
+}
+
+// Delete removes scope's override, reporting whether there was one.
+func (s *Store) Delete(scope string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.overrides[scope]; !ok {
+		return false, nil
+	}
+	delete(s.overrides, scope)
+	return true, s.persist()
+}
+
+// Resolve returns the overrides in effect for tenant, which may be nil.
+// A nil store resolves to no overrides.
+func (s *Store) Resolve(tenant *tenants.Tenant) Resolved {
+	if s == nil {
+		return Resolved{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	resolved := Resolved{deployment: s.overrides[DefaultScope]}
+	if tenant != nil {
+		resolved.tenant = s.overrides[tenant.ID]
+	}
+	return resolved
+}
+
+// Gate answers 404 for a feature an override has switched off for the
+// request's tenant.
+func (s *Store) Gate(feature string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.Resolve(tenants.FromContext(r.Context())).Enabled(feature) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"This feature is turned off"}` + "\n"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// persist writes the snapshot atomically. Callers must hold s.mu.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.overrides, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode overrides: %w", err)
+	}
+	if err := atomicfile.Write(s.path, data); err != nil {
+		return fmt.Errorf("failed to write overrides: %w", err)
+	}
+	return nil
+}
+
+// Resolved is a tenant's override layered over the deployment's: the
+// tenant's settings win, and both allow-lists must allow a model.
+type Resolved struct {
+	tenant, deployment Override
+}
+
+// Allows reports whether the overrides let clients use model.
+func (r Resolved) Allows(model string) bool {
+	for _, models := range [][]string{r.tenant.Models, r.deployment.Models} {
+		if models != nil && !slices.Contains(models, model) {
+			return false
+		}
+	}
+	return true
+}
+
+// Restricted reports whether either allow-list limits the models clients
+// may use.
+func (r Resolved) Restricted() bool {
+	return r.tenant.Models != nil || r.deployment.Models != nil
+}
+
+// Apply gives the request the tenant's overridden system message, unless the
+// client sent one. Call it before the tenant's own settings, which it
+// replaces.
+func (r Resolved) Apply(request *services.MessageRequest) {
+	if request.System == nil && r.tenant.System != nil {
+		system := *r.tenant.System
+		request.System = &system
+	}
+}
+
+// SystemMessage is the deployment's default system message: the override,
+// if one is set, or fallback, SYSTEM_MESSAGE.
+func (r Resolved) SystemMessage(fallback string) string {
+	if r.deployment.System != nil {
+		return *r.deployment.System
+	}
+	return fallback
+}
+
+// Enabled reports whether feature is on. Features are on unless switched
+// off.
+func (r Resolved) Enabled(feature string) bool {
+	for _, features := range []map[string]bool{r.tenant.Features, r.deployment.Features} {
+		if on, ok := features[feature]; ok {
+			return on
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package overrides
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/tenants"
+)
+
+func TestStoreBehavior(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	system := "Answer in French."
+	if err := store.Set(DefaultScope, Override{System: &system, Models: []string{"claude-haiku"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := reopened.All()[DefaultScope]; o.System == nil || *o.System != system || len(o.Models) != 1 {
+		t.Errorf("expected the override to survive a restart, got %+v", o)
+	}
+
+	if deleted, err := reopened.Delete(DefaultScope); !deleted || err != nil {
+		t.Errorf("expected the override to be deleted, got %v %v", deleted, err)
+	}
+	if deleted, _ := reopened.Delete(DefaultScope); deleted {
+		t.Error("expected nothing left to delete")
+	}
+
+	if err := (&Override{Features: map[string]bool{"teleport": false}}).Validate(); err == nil {
+		t.Error("expected unknown features to be refused")
+	}
+}
+
+func TestResolveBehavior(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	os.WriteFile(path, []byte(`{"tenants":[{"id":"acme"},{"id":"globex"}]}`), 0o600)
+	reg, err := tenants.Load(config.TenantsConfig{File: path, Mode: "header", Header: "X-Manto-Tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant := func(id string) *tenants.Tenant {
+		var found *tenants.Tenant
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Manto-Tenant", id)
+		reg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			found = tenants.FromContext(r.Context())
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		return found
+	}
+
+	store, _ := NewStore("")
+	deployment, scoped := "Be brief.", "You help Acme."
+	store.Set(DefaultScope, Override{System: &deployment, Models: []string{"claude-haiku", "claude-opus"}, Features: map[string]bool{"batch": false}})
+	store.Set("acme", Override{System: &scoped, Models: []string{"claude-haiku"}, Features: map[string]bool{"batch": true}})
+
+	acme, globex := store.Resolve(tenant("acme")), store.Resolve(tenant("globex"))
+	if acme.Allows("claude-opus") || !acme.Allows("claude-haiku") || !globex.Allows("claude-opus") || globex.Allows("claude-sonnet") {
+		t.Error("expected both allow-lists to apply to acme, and only the deployment's to globex")
+	}
+	if !acme.Enabled("batch") || globex.Enabled("batch") || !globex.Enabled("evals") {
+		t.Error("expected acme's flag to win over the deployment's, and unset flags to be on")
+	}
+
+	request := &services.MessageRequest{}
+	acme.Apply(request)
+	if request.System == nil || *request.System != scoped {
+		t.Errorf("expected acme's system message, got %v", request.System)
+	}
+	request = &services.MessageRequest{}
+	globex.Apply(request)
+	if request.System != nil || globex.SystemMessage("env") != deployment || store.Resolve(nil).SystemMessage("env") != deployment {
+		t.Error("expected the deployment's system message to replace SYSTEM_MESSAGE only")
+	}
+}
+
+func TestGateBehavior(t *testing.T) {
+	store, _ := NewStore("")
+	store.Set(DefaultScope, Override{Features: map[string]bool{"batch": false}})
+	handler := store.Gate("batch")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/batches/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected a switched off feature to answer 404, got %d", w.Code)
+	}
+
+	var none *Store
+	request := &services.MessageRequest{}
+	none.Resolve(nil).Apply(request)
+	if request.System != nil || !none.Resolve(nil).Allows("claude-opus") || !none.Resolve(nil).Enabled("batch") {
+		t.Error("expected a nil store to override nothing")
+	}
+}
@@ -0,0 +1,193 @@
+// Package parts assembles long messages uploaded in segments, so that no
+// single request has to carry a very large paste whole. A message then
+// refers to the assembled text by its upload ID.
+package parts
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound   = errors.New("upload not found")
+	ErrTooLarge   = errors.New("upload too large")
+	ErrTooMany    = errors.New("too many uploads in progress")
+	ErrInvalidUTF = errors.New("upload is not valid UTF-8")
+)
+
+// IndexError reports a segment sent out of order. Expected is the index the
+// upload is waiting for.
+type IndexError struct {
+	Expected int
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("segment out of order (expected index %d)", e.Expected)
+}
+
+// Status describes an upload after a segment has been added.
+type Status struct {
+	ID        string    `json:"uploadId"`
+	Parts     int       `json:"parts"`
+	Size      int       `json:"size"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type upload struct {
+	owner   string
+	data    []byte
+	parts   int
+	last    int
+	touched time.Time
+}
+
+// Store keeps uploads for ttl after they were last used. Each owner may
+// have maxUploads at a time, each up to maxSize bytes.
+type Store struct {
+	ttl        time.Duration
+	maxSize    int
+	maxUploads int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+func NewStore(ttl time.Duration, maxSize, maxUploads int) *Store {
+	return &Store{ttl: ttl, maxSize: maxSize, maxUploads: maxUploads, now: time.Now, uploads: make(map[string]*upload)}
+}
+
+// Append adds segment at index to owner's upload id, starting a new upload
+// when id is empty. Index must be the number of segments received so far;
+// resending the last segment is accepted without change, so clients can
+// retry safely.
+func (s *Store) Append(owner, id string, index int, segment []byte) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var u *upload
+	if id == "" {
+		if index != 0 {
+			return Status{}, &IndexError{Expected: 0}
+		}
+		if s.count(owner) >= s.maxUploads {
+			return Status{}, ErrTooMany
+		}
+		if len(segment) > s.maxSize {
+			return Status{}, ErrTooLarge
+		}
+		id = newUploadID()
+		u = &upload{owner: owner}
+		s.uploads[id] = u
+	} else if u = s.get(owner, id); u == nil {
+		return Status{}, ErrNotFound
+	}
+
+	switch {
+	case index == u.parts:
+		if len(u.data)+len(segment) > s.maxSize {
+			return Status{}, ErrTooLarge
+		}
+		u.data = append(u.data, segment...)
+		u.parts++
+		u.last = len(segment)
+	case index == u.parts-1 && bytes.Equal(u.data[len(u.data)-u.last:], segment):
+	default:
+		return Status{}, &IndexError{Expected: u.parts}
+	}
+	u.touched = s.now()
+	return s.status(id, u), nil
+}
+
+// Text returns owner's assembled upload and keeps it for another ttl.
+func (s *Store) Text(owner, id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(owner, id)
+	if u == nil {
+		return "", ErrNotFound
+	}
+	if !utf8.Valid(u.data) {
+		return "", ErrInvalidUTF
+	}
+	u.touched = s.now()
+	return string(u.data), nil
+}
+
+// Delete discards owner's upload, reporting whether it existed.
+func (s *Store) Delete(owner, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.get(owner, id) == nil {
+		return false
+	}
+	delete(s.uploads, id)
+	return true
+}
+
+// Cleanup drops uploads unused for ttl.
+func (s *Store) Cleanup() {
+	cutoff := s.now().Add(-s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, u := range s.uploads {
+		if u.touched.Before(cutoff) {
+			delete(s.uploads, id)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup every minute until stop is closed.
+func (s *Store) StartCleanup(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Cleanup()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// get returns owner's live upload id. Expired uploads are treated as gone
+// even before Cleanup drops them.
+func (s *Store) get(owner, id string) *upload {
+	u, ok := s.uploads[id]
+	if !ok || u.owner != owner || u.touched.Before(s.now().Add(-s.ttl)) {
+		return nil
+	}
+	return u
+}
+
+func (s *Store) count(owner string) int {
+	n := 0
+	for id := range s.uploads {
+		if s.get(owner, id) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Store) status(id string, u *upload) Status {
+	return Status{ID: id, Parts: u.parts, Size: len(u.data), ExpiresAt: u.touched.Add(s.ttl)}
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
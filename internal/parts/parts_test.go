@@ -0,0 +1,104 @@
+package parts
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreBehavior(t *testing.T) {
+	t.Run("segments are assembled in order", func(t *testing.T) {
+		store := NewStore(time.Minute, 100, 2)
+		status, err := store.Append("alice", "", 0, []byte("hello "))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		status, err = store.Append("alice", status.ID, 1, []byte("world"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if status.Parts != 2 || status.Size != 11 {
+			t.Errorf("expected 2 parts of 11 bytes, got %+v", status)
+		}
+		text, err := store.Text("alice", status.ID)
+		if err != nil || text != "hello world" {
+			t.Errorf("expected %q, got %q (%v)", "hello world", text, err)
+		}
+	})
+
+	t.Run("resending the last segment is a no-op", func(t *testing.T) {
+		store := NewStore(time.Minute, 100, 2)
+		status, _ := store.Append("alice", "", 0, []byte("a"))
+		status, _ = store.Append("alice", status.ID, 1, []byte("b"))
+		status, err := store.Append("alice", status.ID, 1, []byte("b"))
+		if err != nil || status.Parts != 2 || status.Size != 2 {
+			t.Errorf("expected the retry to change nothing, got %+v (%v)", status, err)
+		}
+	})
+
+	t.Run("segments out of order are refused", func(t *testing.T) {
+		store := NewStore(time.Minute, 100, 2)
+		status, _ := store.Append("alice", "", 0, []byte("a"))
+		_, err := store.Append("alice", status.ID, 3, []byte("b"))
+		var indexErr *IndexError
+		if !errors.As(err, &indexErr) || indexErr.Expected != 1 {
+			t.Errorf("expected an index error expecting 1, got %v", err)
+		}
+	})
+
+	t.Run("uploads are limited in size and number", func(t *testing.T) {
+		store := NewStore(time.Minute, 4, 1)
+		status, _ := store.Append("alice", "", 0, []byte("abc"))
+		if _, err := store.Append("alice", status.ID, 1, []byte("de")); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("expected ErrTooLarge, got %v", err)
+		}
+		if _, err := store.Append("alice", "", 0, []byte("x")); !errors.Is(err, ErrTooMany) {
+			t.Errorf("expected ErrTooMany, got %v", err)
+		}
+		if _, err := store.Append("bob", "", 0, []byte("x")); err != nil {
+			t.Errorf("expected other owners to be unaffected, got %v", err)
+		}
+	})
+
+	t.Run("uploads belong to their owner", func(t *testing.T) {
+		store := NewStore(time.Minute, 100, 2)
+		status, _ := store.Append("alice", "", 0, []byte("secret"))
+		if _, err := store.Text("bob", status.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		if store.Delete("bob", status.ID) {
+			t.Error("expected another owner's delete to fail")
+		}
+		if !store.Delete("alice", status.ID) {
+			t.Error("expected the owner's delete to succeed")
+		}
+	})
+
+	t.Run("unused uploads expire", func(t *testing.T) {
+		store := NewStore(time.Minute, 100, 2)
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		status, _ := store.Append("alice", "", 0, []byte("a"))
+		now = now.Add(2 * time.Minute)
+		if _, err := store.Text("alice", status.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		store.Cleanup()
+		if len(store.uploads) != 0 {
+			t.Errorf("expected cleanup to drop the upload, %d left", len(store.uploads))
+		}
+	})
+
+	t.Run("split characters must be completed", func(t *testing.T) {
+		store := NewStore(time.Minute, 100, 2)
+		euro := []byte("€")
+		status, _ := store.Append("alice", "", 0, euro[:1])
+		if _, err := store.Text("alice", status.ID); !errors.Is(err, ErrInvalidUTF) {
+			t.Errorf("expected ErrInvalidUTF, got %v", err)
+		}
+		store.Append("alice", status.ID, 1, euro[1:])
+		if text, err := store.Text("alice", status.ID); err != nil || text != "€" {
+			t.Errorf("expected the completed character, got %q (%v)", text, err)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Result is a finished response kept until its owner polls for it.
+type Result struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Job is a queued request running in the background on behalf of a client
+// that was answered with 202 Accepted.
+type Job struct {
+	ID string
+
+	owner  string
+	waiter *Waiter
+	cancel context.CancelFunc
+	done   chan struct{}
+	result Result
+
+	mu         sync.Mutex
+	lastPolled time.Time
+	finishedAt time.Time
+}
+
+// Done reports whether the result is ready.
+func (j *Job) Done() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (j *Job) Result() Result {
+	<-j.done
+	return j.result
+}
+
+// Jobs tracks background requests by ID. Jobs nobody polls for within ttl are
+// cancelled, and finished results are dropped after ttl.
+type Jobs struct {
+	queue *Queue
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewJobs(q *Queue, ttl time.Duration) *Jobs {
+	return &Jobs{queue: q, ttl: ttl, now: time.Now, jobs: make(map[string]*Job)}
+}
+
+// Submit runs fn in the background once w is given a slot, releasing the slot
+// when fn returns.
+func (js *Jobs) Submit(owner string, w *Waiter, fn func(ctx context.Context) Result) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:         newJobID(),
+		owner:      owner,
+		waiter:     w,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		lastPolled: js.now(),
+	}
+
+	js.mu.Lock()
+	js.jobs[job.ID] = job
+	js.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		select {
+		case <-w.Ready():
+		case <-ctx.Done():
+			js.queue.Cancel(w)
+			job.result = Result{Status: http.StatusGone}
+			close(job.done)
+			return
+		}
+
+		start := time.Now()
+		job.result = fn(ctx)
+		js.queue.Release(time.Since(start))
+
+		job.mu.Lock()
+		job.finishedAt = js.now()
+		job.mu.Unlock()
+		close(job.done)
+	}()
+
+	return job
+}
+
+// Get returns owner's job and marks it as polled.
+func (js *Jobs) Get(owner, id string) (*Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	job, ok := js.jobs[id]
+	if !ok || job.owner != owner {
+		return nil, false
+	}
+	job.mu.Lock()
+	job.lastPolled = js.now()
+	job.mu.Unlock()
+	return job, true
+}
+
+// Position is the job's place in the queue, 0 once it is running.
+func (js *Jobs) Position(job *Job) int {
+	return js.queue.Position(job.waiter)
+}
+
+// Remove forgets a job, typically after its result has been delivered.
+func (js *Jobs) Remove(id string) {
+	js.mu.Lock()
+	delete(js.jobs, id)
+	js.mu.Unlock()
+}
+
+// Cleanup cancels abandoned jobs and drops uncollected results older than ttl.
+func (js *Jobs) Cleanup() {
+	cutoff := js.now().Add(-js.ttl)
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for id, job := range js.jobs {
+		job.mu.Lock()
+		abandoned := !job.Done() && job.lastPolled.Before(cutoff)
+		expired := job.Done() && job.finishedAt.Before(cutoff)
+		job.mu.Unlock()
+
+		if abandoned {
+			job.cancel()
+		}
+		if abandoned || expired {
+			delete(js.jobs, id)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup every minute until stop is closed.
+func (js *Jobs) StartCleanup(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				js.Cleanup()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,273 @@
+// Package queue limits how many upstream requests run at once. Requests that
+// cannot start immediately wait in line, and callers can ask for their
+// position and an estimated wait so clients get feedback instead of a stall.
+// The line is fair across clients: each waits in its own lane, and lanes take
+// turns in weighted round robin, so one client queueing many requests can't
+// starve everyone else.
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrFull = errors.New("queue is full")
+
+// ewmaWeight is how much each finished request moves the average duration.
+const ewmaWeight = 0.2
+
+// Waiter is a queued request. Ready is closed once it holds a slot.
+type Waiter struct {
+	ready  chan struct{}
+	lane   string
+	weight int
+}
+
+func (w *Waiter) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// Queue is a fair concurrency limiter. A nil *Queue imposes no limit.
+type Queue struct {
+	concurrency int
+	maxWaiting  int
+
+	mu      sync.Mutex
+	active  int
+	waiting scheduler
+	average time.Duration
+}
+
+// New returns a queue allowing concurrency requests at once with up to
+// maxWaiting more queued behind them. A concurrency below 1 returns nil, which
+// disables limiting.
+func New(concurrency, maxWaiting int) *Queue {
+	if concurrency < 1 {
+		return nil
+	}
+	return &Queue{concurrency: concurrency, maxWaiting: maxWaiting}
+}
+
+// TryAcquire takes a slot if one is free and nobody is already waiting.
+func (q *Queue) TryAcquire() bool {
+	if q == nil {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.active < q.concurrency && q.waiting.len() == 0 {
+		q.active++
+		return true
+	}
+	return false
+}
+
+// EnqueueFor adds a waiter to the back of lane, which identifies the client.
+// A lane with waiting requests is served up to weight of them (at least one)
+// per turn. The slot is handed over by closing the waiter's Ready channel;
+// call Cancel if the caller gives up first.
+func (q *Queue) EnqueueFor(lane string, weight int) (*Waiter, error) {
+	w := &Waiter{ready: make(chan struct{}), lane: lane, weight: max(weight, 1)}
+	if q == nil {
+		close(w.ready)
+		return w, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.active < q.concurrency && q.waiting.len() == 0 {
+		q.active++
+		close(w.ready)
+		return w, nil
+	}
+	if q.waiting.len() >= q.maxWaiting {
+		return nil, ErrFull
+	}
+	q.waiting.push(w)
+	return w, nil
+}
+
+// Cancel removes w from the queue. If w had already been given a slot, the
+// slot is released.
+func (q *Queue) Cancel(w *Waiter) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	if q.waiting.remove(w) {
+		q.mu.Unlock()
+		return
+	}
+	q.mu.Unlock()
+	q.Release(0)
+}
+
+// Release frees a slot after a request that took d, handing it straight to the
+// next waiter if there is one. A zero d does not affect the wait estimate.
+func (q *Queue) Release(d time.Duration) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if d > 0 {
+		if q.average == 0 {
+			q.average = d
+		} else {
+			q.average = time.Duration(float64(q.average)*(1-ewmaWeight) + float64(d)*ewmaWeight)
+		}
+	}
+
+	if q.waiting.len() > 0 {
+		close(q.waiting.pop().ready)
+		return
+	}
+	q.active--
+}
+
+// Position returns w's 1-based place in line, counting the waiters the
+// round robin will serve before it, or 0 once it holds a slot.
+func (q *Queue) Position(w *Waiter) int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.waiting.contains(w) {
+		return 0
+	}
+	order := q.waiting.clone()
+	for position := 1; ; position++ {
+		if order.pop() == w {
+			return position
+		}
+	}
+}
+
+// EstimatedWait guesses how long a waiter at position will wait, from the
+// moving average of recent request durations.
+func (q *Queue) EstimatedWait(position int) time.Duration {
+	if q == nil || position < 1 {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rounds := (position + q.concurrency - 1) / q.concurrency
+	return time.Duration(rounds) * q.average
+}
+
+// lane holds one client's waiters in arrival order.
+type lane struct {
+	key     string
+	weight  int
+	waiters []*Waiter
+}
+
+// scheduler orders waiters in weighted round robin across lanes: the lane
+// whose turn it is gives up to its weight of waiters, then the turn passes to
+// the next lane. Lanes join at the back of the ring and leave once empty.
+type scheduler struct {
+	lanes  []*lane
+	turn   int
+	credit int
+	count  int
+}
+
+func (s *scheduler) len() int {
+	return s.count
+}
+
+func (s *scheduler) push(w *Waiter) {
+	s.count++
+	for _, l := range s.lanes {
+		if l.key == w.lane {
+			l.weight = w.weight
+			l.waiters = append(l.waiters, w)
+			return
+		}
+	}
+	s.lanes = append(s.lanes, &lane{key: w.lane, weight: w.weight, waiters: []*Waiter{w}})
+}
+
+// pop removes and returns the next waiter to serve. The scheduler must not be
+// empty.
+func (s *scheduler) pop() *Waiter {
+	l := s.lanes[s.turn]
+	if s.credit == 0 {
+		s.credit = l.weight
+	}
+	w := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	s.count--
+	s.credit--
+	if len(l.waiters) == 0 {
+		s.drop(s.turn)
+	} else if s.credit == 0 {
+		s.turn = (s.turn + 1) % len(s.lanes)
+	}
+	return w
+}
+
+func (s *scheduler) remove(w *Waiter) bool {
+	for i, l := range s.lanes {
+		if l.key != w.lane {
+			continue
+		}
+		for j, waiting := range l.waiters {
+			if waiting == w {
+				l.waiters = append(l.waiters[:j:j], l.waiters[j+1:]...)
+				s.count--
+				if len(l.waiters) == 0 {
+					s.drop(i)
+				}
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func (s *scheduler) contains(w *Waiter) bool {
+	for _, l := range s.lanes {
+		if l.key == w.lane {
+			for _, waiting := range l.waiters {
+				if waiting == w {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// drop removes the empty lane at i, keeping the turn on the lane that follows
+// it.
+func (s *scheduler) drop(i int) {
+	s.lanes = append(s.lanes[:i:i], s.lanes[i+1:]...)
+	switch {
+	case i < s.turn:
+		s.turn--
+	case i == s.turn:
+		s.credit = 0
+	}
+	if s.turn >= len(s.lanes) {
+		s.turn = 0
+	}
+}
+
+// clone copies the scheduler so it can be popped without disturbing s.
+func (s *scheduler) clone() scheduler {
+	c := *s
+	c.lanes = make([]*lane, len(s.lanes))
+	for i, l := range s.lanes {
+		copied := *l
+		c.lanes[i] = &copied
+	}
+	return c
+}
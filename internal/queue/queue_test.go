@@ -0,0 +1,158 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func isReady(w *Waiter) bool {
+	select {
+	case <-w.Ready():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestQueueBehavior(t *testing.T) {
+	q := New(1, 2)
+
+	if !q.TryAcquire() {
+		t.Fatal("expected the first request to get a slot")
+	}
+	if q.TryAcquire() {
+		t.Fatal("expected no free slot")
+	}
+
+	first, err := q.EnqueueFor("", 1)
+	if err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+	second, _ := q.EnqueueFor("", 1)
+	if _, err := q.EnqueueFor("", 1); !errors.Is(err, ErrFull) {
+		t.Errorf("expected ErrFull, got %v", err)
+	}
+
+	if q.Position(first) != 1 || q.Position(second) != 2 {
+		t.Errorf("expected positions 1 and 2, got %d and %d", q.Position(first), q.Position(second))
+	}
+
+	q.Release(4 * time.Second)
+	if !isReady(first) || isReady(second) {
+		t.Fatal("expected the slot to pass to the head of the queue only")
+	}
+	if q.Position(first) != 0 || q.Position(second) != 1 {
+		t.Errorf("expected positions 0 and 1, got %d and %d", q.Position(first), q.Position(second))
+	}
+	if wait := q.EstimatedWait(1); wait != 4*time.Second {
+		t.Errorf("expected 4s estimate from the last duration, got %v", wait)
+	}
+
+	q.Cancel(second)
+	if q.Position(second) != 0 {
+		t.Error("expected cancelled waiter to leave the queue")
+	}
+
+	q.Cancel(first)
+	if !q.TryAcquire() {
+		t.Error("expected cancelling a ready waiter to free its slot")
+	}
+
+	t.Run("nil queue does not limit", func(t *testing.T) {
+		var unlimited *Queue
+		w, err := unlimited.EnqueueFor("", 1)
+		if !unlimited.TryAcquire() || err != nil || !isReady(w) {
+			t.Error("expected nil queue to admit everything")
+		}
+		unlimited.Release(time.Second)
+	})
+}
+
+func TestJobsBehavior(t *testing.T) {
+	q := New(1, 5)
+	q.TryAcquire()
+	jobs := NewJobs(q, time.Minute)
+
+	w, _ := q.EnqueueFor("", 1)
+	job := jobs.Submit("alice", w, func(ctx context.Context) Result {
+		return Result{Status: http.StatusOK, Body: []byte("done")}
+	})
+
+	if _, ok := jobs.Get("bob", job.ID); ok {
+		t.Error("expected jobs to be scoped to their owner")
+	}
+	if got, ok := jobs.Get("alice", job.ID); !ok || got.Done() || jobs.Position(got) != 1 {
+		t.Fatal("expected job to be waiting at position 1")
+	}
+
+	q.Release(time.Second)
+	if result := job.Result(); result.Status != http.StatusOK || string(result.Body) != "done" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if !q.TryAcquire() {
+		t.Error("expected the job to release its slot")
+	}
+
+	t.Run("abandoned jobs are cancelled", func(t *testing.T) {
+		now := time.Now()
+		jobs.now = func() time.Time { return now }
+
+		w, _ := q.EnqueueFor("", 1)
+		abandoned := jobs.Submit("alice", w, func(ctx context.Context) Result {
+			t.Error("abandoned job should not run")
+			return Result{}
+		})
+
+		now = now.Add(2 * time.Minute)
+		jobs.Cleanup()
+		if abandoned.Result().Status != http.StatusGone {
+			t.Errorf("expected abandoned job to end with 410, got %d", abandoned.Result().Status)
+		}
+		if _, ok := jobs.Get("alice", abandoned.ID); ok {
+			t.Error("expected abandoned job to be removed")
+		}
+		if q.Position(w) != 0 {
+			t.Error("expected abandoned waiter to leave the queue")
+		}
+	})
+}
+
+func TestFairnessBehavior(t *testing.T) {
+	q := New(1, 10)
+	q.TryAcquire()
+
+	enqueue := func(lane string, weight int) *Waiter {
+		w, err := q.EnqueueFor(lane, weight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	a1, a2, a3 := enqueue("alice", 1), enqueue("alice", 1), enqueue("alice", 1)
+	b1 := enqueue("bob", 1)
+	c1, c2, c3 := enqueue("carol", 2), enqueue("carol", 2), enqueue("carol", 2)
+
+	expected := []*Waiter{a1, b1, c1, c2, a2, c3, a3}
+	for i, w := range expected {
+		if got := q.Position(w); got != i+1 {
+			t.Errorf("expected waiter %d at position %d, got %d", i, i+1, got)
+		}
+	}
+
+	q.Cancel(c2)
+	expected = []*Waiter{a1, b1, c1, c3, a2, a3}
+	for i, w := range expected {
+		q.Release(time.Second)
+		if !isReady(w) {
+			t.Fatalf("expected waiter %d to be served next", i)
+		}
+		for _, later := range expected[i+1:] {
+			if isReady(later) {
+				t.Fatalf("expected only waiter %d to be served", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package replay keeps the payloads of message requests the provider failed,
+// so an operator can send them again from the admin API and tell a bad
+// request from an intermittent upstream problem. Payloads never hold the
+// caller's key: it is sent in a header, and scrubbed from the payload and
+// error in case a client pasted it into a prompt.
+package replay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/atomicfile"
+	"github.com/manto/manto-web/internal/capture"
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// ErrNotFound is returned for a failure that isn't kept, or no longer is.
+var ErrNotFound = errors.New("failure not found")
+
+// Failure is one failed request.
+type Failure struct {
+	ID      string          `json:"id"`
+	Time    time.Time       `json:"time"`
+	Model   string          `json:"model"`
+	Key     string          `json:"key"`
+	Tenant  string          `json:"tenant,omitempty"`
+	Error   string          `json:"error"`
+	Request json.RawMessage `json:"request"`
+	// APIVersion is the anthropic-version the client asked for, if any.
+	APIVersion string `json:"apiVersion,omitempty"`
+}
+
+// Store keeps the most recent failures, optionally snapshotting them to a
+// JSON file after every change. A nil *Store records nothing.
+type Store struct {
+	path string
+	size int
+
+	mu       sync.RWMutex
+	failures []Failure
+}
+
+// NewStore returns the store cfg describes, or nil when replay is disabled.
+func NewStore(cfg config.DebugConfig) (*Store, error) {
+	if !cfg.ReplayEnabled {
+		return nil, nil
+	}
+	s := &Store{path: cfg.ReplayFile, size: cfg.ReplaySize}
+	if s.path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay store: %w", err)
+	}
+	if s.failures, err = parseSnapshot(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseSnapshot(data []byte) ([]Failure, error) {
+	var snap struct {
+		Failures []Failure `json:"failures"`
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse replay store: %w", err)
+	}
+	return snap.Failures, nil
+}
+
+// Replace swaps the store's contents for the snapshot in data, as written to
+// its file, and persists them.
+func (s *Store) Replace(data []byte) error {
+	failures, err := parseSnapshot(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = failures
+	return s.persist()
+}
+
+// Record keeps request, which failed with failure, dropping the oldest
+// failure once the store is full. apiKey is scrubbed from what is kept and
+// key is its fingerprint.
+func (s *Store) Record(request *services.MessageRequest, apiKey, key, tenant string, failure error) error {
+	if s == nil {
+		return nil
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode failed request: %w", err)
+	}
+	f := Failure{
+		ID:         newID(),
+		Time:       time.Now().UTC(),
+		Model:      request.Model,
+		Key:        key,
+		Tenant:     tenant,
+		Error:      scrub(failure.Error(), apiKey),
+		Request:    json.RawMessage(scrub(string(payload), apiKey)),
+		APIVersion: request.APIVersion,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, f)
+	if len(s.failures) > s.size {
+		s.failures = s.failures[len(s.failures)-s.size:]
+	}
+	return s.persist()
+}
+
+// List returns the kept failures, newest first.
+func (s *Store) List() []Failure {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Failure, len(s.failures))
+	for i, f := range s.failures {
+		list[len(list)-1-i] = f
+	}
+	return list
+}
+
+// Get returns failure id.
+func (s *Store) Get(id string) (Failure, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.failures {
+		if f.ID == id {
+			return f, nil
+		}
+	}
+	return Failure{}, ErrNotFound
+}
+
+// MessageRequest decodes the failed request so it can be sent again.
+func (f Failure) MessageRequest() (*services.MessageRequest, error) {
+	var request services.MessageRequest
+	if err := json.Unmarshal(f.Request, &request); err != nil {
+		return nil, fmt.Errorf("failed to decode failed request: %w", err)
+	}
+	request.APIVersion = f.APIVersion
+	return &request, nil
+}
+
+// persist writes the snapshot atomically. Callers must hold s.mu.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(map[string][]Failure{"failures": s.failures})
+	if err != nil {
+		return fmt.Errorf("failed to encode replay store: %w", err)
+	}
+	if err := atomicfile.Write(s.path, data); err != nil {
+		return fmt.Errorf("failed to write replay store: %w", err)
+	}
+	return nil
+}
+
+func scrub(text, apiKey string) string {
+	if apiKey == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, apiKey, capture.Redacted)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "fail_" + hex.EncodeToString(b)
+}
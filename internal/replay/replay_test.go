@@ -0,0 +1,61 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+)
+
+func TestStoreBehavior(t *testing.T) {
+	cfg := config.DebugConfig{ReplayEnabled: true, ReplaySize: 2, ReplayFile: filepath.Join(t.TempDir(), "failures.json")}
+	store, err := NewStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey := "sk-ant-secret"
+	for i := range 3 {
+		request := &services.MessageRequest{Model: "claude-haiku", Messages: []services.Message{{Role: "user", Content: fmt.Sprintf("attempt %d with %s", i, apiKey)}}}
+		if err := store.Record(request, apiKey, "key_1", "acme", fmt.Errorf("API error (529): overloaded for %s", apiKey)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := NewStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := reopened.List()
+	if len(list) != 2 || !strings.Contains(string(list[0].Request), "attempt 2") {
+		t.Fatalf("expected the two newest failures to survive a restart, newest first, got %+v", list)
+	}
+	for _, f := range list {
+		if strings.Contains(string(f.Request)+f.Error, apiKey) {
+			t.Errorf("expected the key to be redacted, got %s / %s", f.Request, f.Error)
+		}
+	}
+
+	found, err := reopened.Get(list[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := found.MessageRequest()
+	if err != nil || request.Model != "claude-haiku" || request.Messages[0].Content != "attempt 1 with [redacted]" {
+		t.Errorf("expected the failed request back, got %+v %v", request, err)
+	}
+	if _, err := reopened.Get("fail_missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	disabled, err := NewStore(config.DebugConfig{})
+	if disabled != nil || err != nil {
+		t.Fatalf("expected no store when replay is disabled, got %v %v", disabled, err)
+	}
+	if err := disabled.Record(&services.MessageRequest{}, "", "", "", errors.New("boom")); err != nil {
+		t.Errorf("expected a nil store to record nothing, got %v", err)
+	}
+}
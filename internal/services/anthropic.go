@@ -2,117 +2,366 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/manto/manto-web/internal/capture"
 	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/dnscache"
+	"github.com/manto/manto-web/internal/egress"
+	"github.com/manto/manto-web/internal/metrics"
+	"github.com/manto/manto-web/internal/status"
+	"github.com/manto/manto-web/internal/timing"
 )
 
 type AnthropicService struct {
-	config     *config.Config
-	httpClient *http.Client
+	name        string
+	displayName string
+	config      *config.Config
+	httpClient  *http.Client
+	keyPrefixes []string
+	keyPattern  *regexp.Regexp
+	capture     *capture.Recorder
+	status      *status.Tracker
 }
 
 func NewAnthropicService(cfg *config.Config) *AnthropicService {
+	keyPattern, _ := cfg.Anthropic.KeyRegexp()
+	client := &http.Client{Timeout: cfg.Anthropic.Timeout.Duration}
+	resolver := dnscache.New(cfg.DNS, metrics.Default)
+	guard := egress.New(cfg)
+	if n := cfg.Anthropic.PrewarmConnections; n > 0 || resolver != nil || guard != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// Keep every pre-warmed connection idle in the pool, not just
+		// the default transport's two per host.
+		transport.MaxIdleConnsPerHost = max(n, transport.MaxIdleConnsPerHost)
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		dial := egress.DialFunc(dialer.DialContext)
+		if resolver != nil {
+			dial = resolver.DialContext(dialer)
+		}
+		// The guard checks the hostname before the resolver sees it.
+		transport.DialContext = guard.DialContext(dial)
+		client.Transport = transport
+	}
 	return &AnthropicService{
-		config: cfg,
-		httpClient: &http.Client{
-			Timeout: cfg.Anthropic.Timeout.Duration,
-		},
+		name:        AnthropicProvider,
+		displayName: "Anthropic",
+		config:      cfg,
+		httpClient:  client,
+		keyPrefixes: cfg.Anthropic.KeyPrefixes(),
+		keyPattern:  keyPattern,
 	}
 }
 
-func (s *AnthropicService) GetModels(apiKey string) (string, error) {
-	req, err := http.NewRequest("GET", s.config.Anthropic.BaseURL+"/v1/models", nil)
+// WithName offers the service under name, e.g. for a gateway registered
+// alongside Anthropic itself.
+func (s *AnthropicService) WithName(name, displayName string) *AnthropicService {
+	s.name, s.displayName = name, displayName
+	return s
+}
+
+// WithCapture keeps sanitized copies of upstream exchanges in recorder.
+func (s *AnthropicService) WithCapture(recorder *capture.Recorder) *AnthropicService {
+	s.capture = recorder
+	return s
+}
+
+// WithStatus reports the outcome of every upstream request to tracker.
+func (s *AnthropicService) WithStatus(tracker *status.Tracker) *AnthropicService {
+	s.status = tracker
+	return s
+}
+
+// Probe checks that the base URL can be reached, through any configured
+// proxy, with an unauthenticated HEAD request. Any HTTP response counts, since
+// only the connection and TLS handshake are in question.
+func (s *AnthropicService) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.config.Anthropic.BaseURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", s.userAgent())
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("%s is unreachable: %w", s.config.Anthropic.BaseURL, err)
 	}
+	resp.Body.Close()
+	return nil
+}
 
-	s.setHeaders(req, apiKey, s.config.Anthropic.APIVersion)
+// Prewarm opens n connections to the base URL at once, with the same HEAD
+// request as Probe, leaving them idle in the pool for the next requests. It
+// returns how many succeeded and the first error.
+func (s *AnthropicService) Prewarm(ctx context.Context, n int) (int, error) {
+	errs := make(chan error, n)
+	for range n {
+		go func() { errs <- s.Probe(ctx) }()
+	}
+	var warmed int
+	var first error
+	for range n {
+		if err := <-errs; err == nil {
+			warmed++
+		} else if first == nil {
+			first = err
+		}
+	}
+	return warmed, first
+}
 
-	resp, err := s.httpClient.Do(req)
+func (s *AnthropicService) GetModels(ctx context.Context, apiKey string) (string, error) {
+	req, err := s.newRequest(ctx, "GET", "/v1/models", apiKey, nil, nil)
 	if err != nil {
-		return "", fmt.Errorf("network error: %w", err)
+		return "", err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return "", NetworkError(err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return "", BadResponse("failed to read response", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return "", UpstreamError(resp.StatusCode, resp.Header, fmt.Sprintf("API error (status %d): %s", resp.StatusCode, body))
 	}
 
 	return string(body), nil
 }
 
-func (s *AnthropicService) SendMessage(apiKey string, request *MessageRequest) (*MessageResponse, error) {
+func (s *AnthropicService) SendMessage(ctx context.Context, apiKey string, request *MessageRequest) (*MessageResponse, error) {
+	// The answer is read in one piece, so a client's "stream" is ignored
+	// here; StreamMessage streams.
+	if request != nil && request.Stream {
+		buffered := *request
+		buffered.Stream = false
+		request = &buffered
+	}
 	jsonData, err := json.Marshal(request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", s.config.Anthropic.BaseURL+"/v1/messages", bytes.NewBuffer(jsonData))
+	req, err := s.newRequest(ctx, "POST", "/v1/messages", apiKey, bytes.NewBuffer(jsonData), s.versionHeader(request))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	s.setHeaders(req, apiKey, s.config.Anthropic.APIVersion)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("network error: %w", err)
+		return nil, NetworkError(err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, BadResponse("failed to read response", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		var errorResp ErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
-			return nil, fmt.Errorf("%s", errorResp.Error.Message)
-		}
-
-		switch resp.StatusCode {
-		case http.StatusUnauthorized:
-			return nil, fmt.Errorf("invalid API key")
-		case http.StatusBadRequest:
-			return nil, fmt.Errorf("invalid request format")
-		case http.StatusTooManyRequests:
-			return nil, fmt.Errorf("rate limit exceeded")
-		case http.StatusInternalServerError:
-			return nil, fmt.Errorf("service temporarily unavailable")
-		default:
-			return nil, fmt.Errorf("failed to send message")
-		}
+		return nil, apiError(resp.StatusCode, resp.Header, body)
 	}
 
 	var messageResp MessageResponse
 	if err := json.Unmarshal(body, &messageResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		return nil, BadResponse("failed to parse response", err)
 	}
 
 	return &messageResp, nil
 }
 
+// apiError turns a failed Messages API response into an *APIError,
+// preferring the provider's own message.
+func apiError(status int, header http.Header, body []byte) error {
+	var errorResp ErrorResponse
+	if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
+		return UpstreamError(status, header, errorResp.Error.Message)
+	}
+
+	switch status {
+	case http.StatusUnauthorized:
+		return UpstreamError(status, header, "invalid API key")
+	case http.StatusBadRequest:
+		return UpstreamError(status, header, "invalid request format")
+	case http.StatusTooManyRequests:
+		return UpstreamError(status, header, "rate limit exceeded")
+	case http.StatusInternalServerError:
+		return UpstreamError(status, header, "service temporarily unavailable")
+	default:
+		return UpstreamError(status, header, "failed to send message")
+	}
+}
+
+// CountTokens asks the provider for the exact input token count of request.
+func (s *AnthropicService) CountTokens(ctx context.Context, apiKey string, request *CountTokensRequest) (int, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := s.newRequest(ctx, "POST", "/v1/messages/count_tokens", apiKey, bytes.NewBuffer(jsonData), s.versionHeader(&MessageRequest{Model: request.Model}))
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return 0, NetworkError(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, BadResponse("failed to read response", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, UpstreamError(resp.StatusCode, resp.Header, fmt.Sprintf("API error (status %d): %s", resp.StatusCode, body))
+	}
+
+	var countResp CountTokensResponse
+	if err := json.Unmarshal(body, &countResp); err != nil {
+		return 0, BadResponse("failed to parse response", err)
+	}
+
+	return countResp.InputTokens, nil
+}
+
+// Forward sends a raw request to path on the upstream API with apiKey,
+// passing on only the allow-listed client headers. The caller must close
+// the response body.
+func (s *AnthropicService) Forward(ctx context.Context, apiKey, method, path, rawQuery string, header http.Header, body io.Reader) (*http.Response, error) {
+	if rawQuery != "" {
+		path += "?" + rawQuery
+	}
+	req, err := s.newRequest(ctx, method, path, apiKey, body, header)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, NetworkError(err)
+	}
+	return resp, nil
+}
+
+type authFailureKey struct{}
+
+// WithAuthFailureHook returns a copy of ctx carrying onFailure, which
+// upstream requests made with it call when the provider rejects the key.
+func WithAuthFailureHook(ctx context.Context, onFailure func()) context.Context {
+	return context.WithValue(ctx, authFailureKey{}, onFailure)
+}
+
+// ReportAuthFailure calls ctx's auth failure hook, if any. Providers call it
+// when the upstream rejects the caller's key.
+func ReportAuthFailure(ctx context.Context) {
+	if onFailure, ok := ctx.Value(authFailureKey{}).(func()); ok {
+		onFailure()
+	}
+}
+
+// do sends req upstream, recording time to first byte and total round trip
+// in the request's timings when present, capturing the exchange when debug
+// capture is on, reporting the outcome to the status tracker, and reporting
+// a rejected key to the request's auth failure hook.
+func (s *AnthropicService) do(req *http.Request) (*http.Response, error) {
+	timings := timing.FromContext(req.Context())
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			timings.Since("upstream_ttfb", start)
+		},
+	}
+
+	resp, err := s.capture.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), s.httpClient.Do)
+	timings.Since("upstream_headers", start)
+	s.status.Observe(resp, err)
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		ReportAuthFailure(req.Context())
+	}
+	return resp, err
+}
+
+// ValidateAPIKey checks the key's length and that it starts with one of the
+// configured prefixes or matches the key pattern. With neither configured,
+// only the length is checked.
 func (s *AnthropicService) ValidateAPIKey(apiKey string) bool {
-	prefix := s.config.Anthropic.KeyPrefix
 	minLength := s.config.Security.APIKeyMinLength
+	if len(apiKey) < minLength {
+		return false
+	}
+	if len(s.keyPrefixes) == 0 && s.keyPattern == nil {
+		return true
+	}
 
-	return len(apiKey) >= minLength && strings.HasPrefix(apiKey, prefix)
+	for _, prefix := range s.keyPrefixes {
+		if strings.HasPrefix(apiKey, prefix) {
+			return true
+		}
+	}
+	return s.keyPattern != nil && s.keyPattern.MatchString(apiKey)
 }
 
-func (s *AnthropicService) setHeaders(req *http.Request, apiKey string, apiVersion string) {
-	req.Header.Set("x-api-key", apiKey)
-	req.Header.Set("anthropic-version", apiVersion)
-	req.Header.Set("User-Agent", "Manto/1.0")
+// fingerprintVisible is how many trailing characters of a key a fingerprint
+// shows, and fingerprintHidden the minimum number it must keep hidden.
+const (
+	fingerprintVisible = 4
+	fingerprintHidden  = 8
+)
+
+// Fingerprint returns a short, stable label for apiKey such as "sk-ant-…a1b2",
+// for logs and usage stats where the key itself must never appear.
+func (s *AnthropicService) Fingerprint(apiKey string) string {
+	return FingerprintKey(apiKey, s.keyPrefixes)
+}
+
+// FingerprintKey labels apiKey for any provider. It shows the longest of
+// prefixes that matches (or the first four characters) and the last four,
+// falling back to the prefix alone for keys too short to reveal any.
+func FingerprintKey(apiKey string, prefixes []string) string {
+	prefix := ""
+	for _, p := range prefixes {
+		if strings.HasPrefix(apiKey, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if prefix == "" && len(apiKey) >= fingerprintVisible+fingerprintHidden+fingerprintVisible {
+		prefix = apiKey[:fingerprintVisible]
+	}
+
+	if len(apiKey)-len(prefix) < fingerprintVisible+fingerprintHidden {
+		return prefix + "…"
+	}
+	return prefix + "…" + apiKey[len(apiKey)-fingerprintVisible:]
+}
+
+// ClientAPIKey reads the caller's key from the configured header, accepting an
+// optional "Bearer" scheme so Authorization can be used.
+func (s *AnthropicService) ClientAPIKey(r *http.Request) string {
+	return ClientKey(r, s.config.Anthropic.ClientKeyHeader)
+}
+
+// ClientKey reads a caller's key from header (x-api-key if empty), accepting
+// an optional "Bearer" scheme.
+func ClientKey(r *http.Request, header string) string {
+	value := r.Header.Get(headerOrDefault(header))
+	if scheme, key, ok := strings.Cut(value, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(key)
+	}
+	return value
 }
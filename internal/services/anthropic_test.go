@@ -1,7 +1,19 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/manto/manto-web/internal/config"
@@ -101,6 +113,25 @@ func TestServiceConfigurationBehavior(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "service accepts any configured prefix or pattern",
+			modifyConfig: func(cfg *config.Config) {
+				cfg.Anthropic.KeyPrefix = "sk-ant-, gw-"
+				cfg.Anthropic.KeyPattern = `org_[a-f0-9]{12}`
+			},
+			testBehavior: func(t *testing.T, service *AnthropicService) {
+				for _, key := range []string{"sk-ant-1234567890", "gw-1234567890", "org_0123456789ab"} {
+					if !service.ValidateAPIKey(key) {
+						t.Errorf("should accept %s", key)
+					}
+				}
+				for _, key := range []string{"other-1234567890", "org_0123456789abX", "xorg_0123456789ab"} {
+					if service.ValidateAPIKey(key) {
+						t.Errorf("should reject %s", key)
+					}
+				}
+			},
+		},
 		{
 			name: "service respects custom minimum key length",
 			modifyConfig: func(cfg *config.Config) {
@@ -118,6 +149,26 @@ func TestServiceConfigurationBehavior(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "service reads and forwards keys with configured headers",
+			modifyConfig: func(cfg *config.Config) {
+				cfg.Anthropic.ClientKeyHeader = "Authorization"
+				cfg.Anthropic.AuthHeader = "Authorization"
+				cfg.Anthropic.AuthScheme = "Bearer"
+			},
+			testBehavior: func(t *testing.T, service *AnthropicService) {
+				in := httptest.NewRequest("GET", "/api/models", nil)
+				in.Header.Set("Authorization", "Bearer sk-ant-1234567890")
+				if key := service.ClientAPIKey(in); key != "sk-ant-1234567890" {
+					t.Errorf("expected key from Authorization header, got %q", key)
+				}
+
+				out, _ := service.newRequest(context.Background(), "GET", "/v1/models", "sk-ant-1234567890", nil, in.Header)
+				if out.Header.Get("Authorization") != "Bearer sk-ant-1234567890" || out.Header.Get("x-api-key") != "" {
+					t.Errorf("expected bearer auth upstream, got %v", out.Header)
+				}
+			},
+		},
 		{
 			name: "service uses configured base URL",
 			modifyConfig: func(cfg *config.Config) {
@@ -141,6 +192,91 @@ func TestServiceConfigurationBehavior(t *testing.T) {
 	}
 }
 
+func TestUpstreamHeadersBehavior(t *testing.T) {
+	var got http.Header
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{"id":"m1","content":[],"usage":{}}`))
+	}))
+	defer fake.Close()
+
+	client := http.Header{}
+	client.Set("x-api-key", "sk-ant-client-key-123")
+	client.Set("Authorization", "Bearer other")
+	client.Set("Cookie", "manto_session=abc")
+	client.Set("X-Forwarded-For", "203.0.113.7")
+	client.Set("X-Real-Ip", "203.0.113.7")
+	client.Set("Referer", "https://chat.example.com/")
+	client.Set("User-Agent", "Mozilla/5.0")
+	client.Set("X-Manto-Access-Code", "letmein")
+	client.Set("Anthropic-Beta", "tools-2024")
+	client.Set("Accept", "application/json")
+	client.Set("Connection", "keep-alive, Accept")
+
+	tests := []struct {
+		name      string
+		userAgent string
+		send      func(*AnthropicService) error
+		expected  map[string]string
+	}{
+		{
+			name: "raw requests forward only allow-listed headers",
+			send: func(s *AnthropicService) error {
+				resp, err := s.Forward(context.Background(), "sk-ant-1234567890", "POST", "/v1/messages", "", client, strings.NewReader("{}"))
+				if err == nil {
+					resp.Body.Close()
+				}
+				return err
+			},
+			expected: map[string]string{
+				"X-Api-Key":         "sk-ant-1234567890",
+				"Anthropic-Beta":    "tools-2024",
+				"Anthropic-Version": "2023-06-01",
+				"Content-Type":      "application/json",
+				"User-Agent":        "Manto/1.0",
+			},
+		},
+		{
+			name:      "messages carry the configured user agent",
+			userAgent: "Acme-Gateway/2.3",
+			send: func(s *AnthropicService) error {
+				_, err := s.SendMessage(context.Background(), "sk-ant-1234567890", &MessageRequest{Model: "haiku"})
+				return err
+			},
+			expected: map[string]string{
+				"X-Api-Key":         "sk-ant-1234567890",
+				"Anthropic-Version": "2023-06-01",
+				"Content-Type":      "application/json",
+				"User-Agent":        "Acme-Gateway/2.3",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createTestConfig()
+			cfg.Anthropic.BaseURL = fake.URL
+			cfg.Anthropic.UserAgent = tt.userAgent
+			if err := tt.send(NewAnthropicService(cfg)); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"Accept-Encoding", "Content-Length"} {
+				got.Del(name)
+			}
+			for name, value := range tt.expected {
+				if got.Get(name) != value {
+					t.Errorf("expected %s %q, got %q", name, value, got.Get(name))
+				}
+			}
+			for name := range got {
+				if _, ok := tt.expected[name]; !ok {
+					t.Errorf("unexpected header %s sent upstream: %q", name, got.Get(name))
+				}
+			}
+		})
+	}
+}
+
 func TestServiceErrorHandlingBehavior(t *testing.T) {
 	cfg := createTestConfig()
 	service := NewAnthropicService(cfg)
@@ -150,7 +286,7 @@ func TestServiceErrorHandlingBehavior(t *testing.T) {
 		service.config.Anthropic.BaseURL = "://invalid-url"
 		defer func() { service.config.Anthropic.BaseURL = originalURL }()
 
-		_, err := service.GetModels("sk-ant-validkey123")
+		_, err := service.GetModels(context.Background(), "sk-ant-validkey123")
 		if err == nil {
 			t.Error("expected error for invalid URL")
 		}
@@ -161,9 +297,415 @@ func TestServiceErrorHandlingBehavior(t *testing.T) {
 	})
 
 	t.Run("SendMessage with invalid request returns error", func(t *testing.T) {
-		_, err := service.SendMessage("sk-ant-validkey123", nil)
+		_, err := service.SendMessage(context.Background(), "sk-ant-validkey123", nil)
 		if err == nil {
 			t.Error("expected error for nil request")
 		}
 	})
 }
+
+func TestAPIErrorBehavior(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		retryAfter       string
+		body             string
+		expectCode       string
+		expectStatus     int
+		expectMessage    string
+		expectRetryAfter int
+	}{
+		{name: "passes the provider's message on", status: 400, body: `{"error":{"type":"invalid_request_error","message":"max_tokens: too large"}}`, expectCode: CodeInvalidRequest, expectStatus: 400, expectMessage: "max_tokens: too large"},
+		{name: "answers a rejected key with 401", status: 401, expectCode: CodeAuthentication, expectStatus: 401, expectMessage: "invalid API key"},
+		{name: "keeps the upstream's Retry-After", status: 429, retryAfter: "30", expectCode: CodeRateLimited, expectStatus: 429, expectMessage: "rate limit exceeded", expectRetryAfter: 30},
+		{name: "answers overloaded as 503", status: 529, body: `{"error":{"type":"overloaded_error","message":"Overloaded"}}`, expectCode: CodeOverloaded, expectStatus: 503, expectMessage: "Overloaded"},
+		{name: "answers upstream failures as 502", status: 500, expectCode: CodeUpstreamError, expectStatus: 502, expectMessage: "service temporarily unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer fake.Close()
+			cfg := createTestConfig()
+			cfg.Anthropic.BaseURL = fake.URL
+
+			_, err := NewAnthropicService(cfg).SendMessage(context.Background(), "sk-ant-validkey123", &MessageRequest{Model: "claude-3-5-haiku"})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an *APIError, got %v", err)
+			}
+			if apiErr.Code != tt.expectCode || apiErr.HTTPStatus != tt.expectStatus || apiErr.Message != tt.expectMessage || apiErr.RetryAfter != tt.expectRetryAfter {
+				t.Errorf("unexpected error: %+v", apiErr)
+			}
+		})
+	}
+
+	t.Run("answers an unreachable upstream as 502 and a timeout as 504", func(t *testing.T) {
+		if got := NetworkError(errors.New("connection refused")); got.Code != CodeUpstreamUnreachable || got.HTTPStatus != http.StatusBadGateway {
+			t.Errorf("unexpected error: %+v", got)
+		}
+		got := NetworkError(fmt.Errorf("request: %w", context.DeadlineExceeded))
+		if got.Code != CodeUpstreamTimeout || got.HTTPStatus != http.StatusGatewayTimeout || !errors.Is(got, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %+v", got)
+		}
+	})
+}
+
+func TestProbeBehavior(t *testing.T) {
+	var method string
+	var header http.Header
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, header = r.Method, r.Header.Clone()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	defer fake.Close()
+
+	tests := []struct {
+		name        string
+		baseURL     string
+		expectError bool
+	}{
+		{name: "any response counts as reachable", baseURL: fake.URL},
+		{name: "refused connections fail", baseURL: closed.URL, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createTestConfig()
+			cfg.Anthropic.BaseURL = tt.baseURL
+			cfg.Anthropic.AuthHeader = "x-api-key"
+			err := NewAnthropicService(cfg).Probe(context.Background())
+			if tt.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "unreachable") {
+				t.Errorf("expected the error to say the upstream is unreachable, got %v", err)
+			}
+		})
+	}
+
+	if method != http.MethodHead || header.Get("x-api-key") != "" || header.Get("User-Agent") != defaultUserAgent {
+		t.Errorf("expected an unauthenticated HEAD with the Manto User-Agent, got %s %v", method, header)
+	}
+}
+
+func TestMergeStopSequencesBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   []string
+		server   []string
+		expected []string
+	}{
+		{name: "no server sequences", client: []string{"END"}, expected: []string{"END"}},
+		{name: "server sequences only", server: []string{"\n\nUser:"}, expected: []string{"\n\nUser:"}},
+		{name: "client first, duplicates dropped", client: []string{"END", "\n\nUser:"}, server: []string{"\n\nUser:", "STOP"}, expected: []string{"END", "\n\nUser:", "STOP"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &MessageRequest{StopSequences: tt.client}
+			req.MergeStopSequences(tt.server)
+			if strings.Join(req.StopSequences, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("expected %q, got %q", tt.expected, req.StopSequences)
+			}
+		})
+	}
+}
+
+func TestMessageJSONBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		content  string
+		blocks   int
+		expected string
+	}{
+		{name: "string content round-trips", input: `{"role":"user","content":"hi"}`, content: "hi", expected: `{"role":"user","content":"hi"}`},
+		{name: "user blocks flatten to text", input: `{"role":"user","content":[{"type":"text","text":"a"},{"type":"text","text":"b"}]}`, content: "a\n\nb", expected: `{"role":"user","content":"a\n\nb"}`},
+		{
+			name:     "assistant blocks are kept",
+			input:    `{"role":"assistant","content":[{"type":"thinking","thinking":"t","signature":"s"},{"type":"text","text":"a"}]}`,
+			content:  "a",
+			blocks:   2,
+			expected: `{"role":"assistant","content":[{"type":"thinking","thinking":"t","signature":"s"},{"type":"text","text":"a"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Message
+			if err := json.Unmarshal([]byte(tt.input), &m); err != nil {
+				t.Fatal(err)
+			}
+			if m.Content != tt.content || len(m.Blocks) != tt.blocks {
+				t.Errorf("expected content %q with %d blocks, got %q with %d", tt.content, tt.blocks, m.Content, len(m.Blocks))
+			}
+			out, _ := json.Marshal(m)
+			if string(out) != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, out)
+			}
+		})
+	}
+}
+
+func TestNormalizeHistoryBehavior(t *testing.T) {
+	text := func(s string) *string { return &s }
+	history := func() []Message {
+		return []Message{
+			{Role: "user", Content: "q1"},
+			{Role: "assistant", Content: "a1", Model: "sonnet", Blocks: []ContentBlock{{Type: BlockThinking, Thinking: text("t"), Signature: "s"}, {Type: BlockText, Text: text("a1")}}},
+			{Role: "user", Content: "q2"},
+			{Role: "assistant", Model: "sonnet", Blocks: []ContentBlock{{Type: BlockRedactedThinking, Data: "x"}}},
+			{Role: "user", Content: "q3"},
+		}
+	}
+	tests := []struct {
+		name     string
+		model    string
+		thinking bool
+		dropped  int
+		turns    int
+	}{
+		{name: "same thinking model keeps thinking", model: "sonnet", thinking: true, dropped: 0, turns: 3},
+		{name: "other thinking model drops foreign signatures", model: "opus", thinking: true, dropped: 2, turns: 3},
+		{name: "model without thinking drops thinking", model: "haiku", dropped: 2, turns: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &MessageRequest{Model: tt.model, Messages: history(), Thinking: &ThinkingConfig{Type: "enabled"}}
+			if dropped := req.NormalizeHistory(tt.thinking); dropped != tt.dropped {
+				t.Errorf("expected %d blocks dropped, got %d", tt.dropped, dropped)
+			}
+			if len(req.Messages) != tt.turns || req.Messages[2].Content != "q2\n\nq3" {
+				t.Errorf("expected the thinking-only turn removed and users merged, got %+v", req.Messages)
+			}
+			if (req.Thinking != nil) != tt.thinking {
+				t.Errorf("expected thinking config kept only for thinking models, got %v", req.Thinking)
+			}
+		})
+	}
+}
+
+func TestStreamMessageBehavior(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		events        []string
+		expectedText  string
+		expectedError string
+	}{
+		{
+			name:   "assembles the streamed answer",
+			status: http.StatusOK,
+			events: []string{
+				`{"type":"message_start","message":{"id":"msg_1","model":"haiku","usage":{"input_tokens":5}}}`,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":2}}`,
+				`{"type":"message_stop"}`,
+			},
+			expectedText: "Hello",
+		},
+		{
+			name:          "reports error events",
+			status:        http.StatusOK,
+			events:        []string{`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`},
+			expectedError: "Overloaded",
+		},
+		{
+			name:          "reports truncated streams",
+			status:        http.StatusOK,
+			events:        []string{`{"type":"message_start","message":{"id":"msg_1"}}`},
+			expectedError: "ended early",
+		},
+		{name: "reports API errors", status: http.StatusUnauthorized, expectedError: "invalid API key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]any
+				json.NewDecoder(r.Body).Decode(&body)
+				if body["stream"] != true {
+					t.Error("expected stream to be requested")
+				}
+				w.WriteHeader(tt.status)
+				for _, e := range tt.events {
+					fmt.Fprintf(w, "event: x\ndata: %s\n\n", e)
+				}
+			}))
+			defer fake.Close()
+			cfg := createTestConfig()
+			cfg.Anthropic.BaseURL = fake.URL
+			service := NewAnthropicService(cfg)
+
+			var streamed string
+			response, err := service.StreamMessage(context.Background(), "sk-ant-validkey123", &MessageRequest{Model: "haiku"}, func(text string) error {
+				streamed += text
+				return nil
+			})
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Fatalf("expected error %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if streamed != tt.expectedText || response.Text() != tt.expectedText || response.StopReason != "end_turn" || response.Usage.InputTokens != 5 || response.Usage.OutputTokens != 2 {
+				t.Errorf("unexpected response %+v (streamed %q)", response, streamed)
+			}
+		})
+	}
+}
+
+func TestFingerprintBehavior(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Anthropic.KeyPrefix = "sk-ant-,sk-ant-api03-"
+	service := NewAnthropicService(cfg)
+
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{name: "longest matching prefix and last four", key: "sk-ant-REDACTED", expected: "sk-ant-api03-…a1b2"},
+		{name: "unknown prefix shows first four", key: "gw_abcdefghijklmnopa1b2", expected: "gw_a…a1b2"},
+		{name: "short key hides the tail", key: "sk-ant-abc123", expected: "sk-ant-…"},
+		{name: "empty key", key: "", expected: "…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := service.Fingerprint(tt.key); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSigningBehavior(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":[],"content":[]}`))
+	}))
+	defer fake.Close()
+
+	tests := []struct {
+		name      string
+		key       string
+		algorithm string
+		newHash   func() hash.Hash
+		send      func(*AnthropicService) error
+	}{
+		{
+			name: "leaves requests unsigned without a key",
+			send: func(s *AnthropicService) error {
+				_, err := s.GetModels(context.Background(), "sk-ant-1234567890")
+				return err
+			},
+		},
+		{
+			name:      "signs messages with sha256",
+			key:       "gateway-secret",
+			algorithm: "sha256",
+			newHash:   sha256.New,
+			send: func(s *AnthropicService) error {
+				_, err := s.SendMessage(context.Background(), "sk-ant-1234567890", &MessageRequest{Model: "haiku"})
+				return err
+			},
+		},
+		{
+			name:      "signs bodiless passthrough requests with sha512",
+			key:       "gateway-secret",
+			algorithm: "sha512",
+			newHash:   sha512.New,
+			send: func(s *AnthropicService) error {
+				resp, err := s.Forward(context.Background(), "sk-ant-1234567890", "GET", "/v1/models/claude", "beta=true", nil, nil)
+				if err == nil {
+					resp.Body.Close()
+				}
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createTestConfig()
+			cfg.Anthropic.BaseURL = fake.URL
+			cfg.Anthropic.SigningKey = tt.key
+			cfg.Anthropic.SigningAlgorithm = tt.algorithm
+			cfg.Anthropic.SigningHeader = "X-Gateway-Signature"
+			if err := tt.send(NewAnthropicService(cfg)); err != nil {
+				t.Fatal(err)
+			}
+
+			header := got.Header.Get("X-Gateway-Signature")
+			if tt.key == "" {
+				if header != "" {
+					t.Errorf("expected no signature, got %q", header)
+				}
+				return
+			}
+			timestamp, mac, ok := strings.Cut(strings.TrimPrefix(header, "t="), ",v1=")
+			if !ok {
+				t.Fatalf("expected t=...,v1=..., got %q", header)
+			}
+			if want := signature(tt.newHash, tt.key, timestamp, got.Method, got.URL.RequestURI(), gotBody); mac != want {
+				t.Errorf("expected signature %s over what was sent, got %s", want, mac)
+			}
+		})
+	}
+}
+
+func TestPrewarmBehavior(t *testing.T) {
+	var opened atomic.Int32
+	fake := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[]}`))
+	}))
+	fake.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			opened.Add(1)
+		}
+	}
+	fake.Start()
+	defer fake.Close()
+
+	cfg := createTestConfig()
+	cfg.Anthropic.BaseURL = fake.URL
+	cfg.Anthropic.PrewarmConnections = 3
+	service := NewAnthropicService(cfg)
+	warmed, err := service.Prewarm(context.Background(), 3)
+	if warmed != 3 || err != nil {
+		t.Fatalf("expected 3 warmed connections, got %d: %v", warmed, err)
+	}
+	before := opened.Load()
+	if _, err := service.SendMessage(context.Background(), "sk-ant-1234567890", &MessageRequest{Model: "haiku"}); err != nil {
+		t.Fatal(err)
+	}
+	if opened.Load() != before {
+		t.Errorf("expected the message to reuse a warmed connection, but %d were opened", opened.Load()-before)
+	}
+
+	cfg = createTestConfig()
+	cfg.Anthropic.BaseURL = "http://127.0.0.1:1"
+	if warmed, err := NewAnthropicService(cfg).Prewarm(context.Background(), 2); warmed != 0 || err == nil {
+		t.Errorf("expected pre-warming an unreachable upstream to fail, got %d %v", warmed, err)
+	}
+}
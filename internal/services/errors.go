@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Codes of an APIError, telling clients what went wrong without parsing the
+// message.
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeAuthentication      = "authentication_failed"
+	CodePermissionDenied    = "permission_denied"
+	CodeNotFound            = "not_found"
+	CodeRequestTooLarge     = "request_too_large"
+	CodeRateLimited         = "rate_limited"
+	CodeOverloaded          = "overloaded"
+	CodeUpstreamError       = "upstream_error"
+	CodeUpstreamUnreachable = "upstream_unreachable"
+	CodeUpstreamTimeout     = "upstream_timeout"
+)
+
+// APIError is a request a provider failed: rejected by the upstream API or
+// never answered. HTTPStatus is the status to answer the client with, which
+// is not always the upstream's own: a failing upstream is a 502 here, not a
+// 500. RetryAfter is in seconds, from the upstream's Retry-After.
+type APIError struct {
+	Code       string
+	HTTPStatus int
+	Message    string
+	RetryAfter int
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// UpstreamError describes an error response from the upstream API, with
+// message, the provider's own if it gave one, to show the client.
+func UpstreamError(status int, header http.Header, message string) *APIError {
+	e := &APIError{Code: CodeUpstreamError, HTTPStatus: http.StatusBadGateway, Message: message}
+	switch {
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		e.Code, e.HTTPStatus = CodeInvalidRequest, http.StatusBadRequest
+	case status == http.StatusUnauthorized:
+		e.Code, e.HTTPStatus = CodeAuthentication, http.StatusUnauthorized
+	case status == http.StatusForbidden:
+		e.Code, e.HTTPStatus = CodePermissionDenied, http.StatusForbidden
+	case status == http.StatusNotFound:
+		e.Code, e.HTTPStatus = CodeNotFound, http.StatusNotFound
+	case status == http.StatusRequestEntityTooLarge:
+		e.Code, e.HTTPStatus = CodeRequestTooLarge, http.StatusRequestEntityTooLarge
+	case status == http.StatusTooManyRequests:
+		e.Code, e.HTTPStatus = CodeRateLimited, http.StatusTooManyRequests
+	case status == http.StatusServiceUnavailable || status == 529: // Anthropic's "overloaded"
+		e.Code, e.HTTPStatus = CodeOverloaded, http.StatusServiceUnavailable
+	case status == http.StatusGatewayTimeout:
+		e.Code, e.HTTPStatus = CodeUpstreamTimeout, http.StatusGatewayTimeout
+	}
+	if header != nil {
+		e.RetryAfter = retryAfter(header.Get("Retry-After"))
+	}
+	return e
+}
+
+// StreamError describes an error event in the middle of a stream, by the
+// Messages API's error type, e.g. "overloaded_error".
+func StreamError(detail ErrorDetail) *APIError {
+	status := http.StatusInternalServerError
+	switch detail.Type {
+	case "invalid_request_error":
+		status = http.StatusBadRequest
+	case "authentication_error":
+		status = http.StatusUnauthorized
+	case "permission_error":
+		status = http.StatusForbidden
+	case "not_found_error":
+		status = http.StatusNotFound
+	case "request_too_large":
+		status = http.StatusRequestEntityTooLarge
+	case "rate_limit_error":
+		status = http.StatusTooManyRequests
+	case "overloaded_error":
+		status = 529
+	}
+	return UpstreamError(status, nil, detail.Message)
+}
+
+// NetworkError describes a request that got no response: the upstream was
+// unreachable, or didn't answer in time.
+func NetworkError(err error) *APIError {
+	e := &APIError{Code: CodeUpstreamUnreachable, HTTPStatus: http.StatusBadGateway, Message: "network error: " + err.Error(), Err: err}
+	var timeout interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &timeout) && timeout.Timeout() {
+		e.Code, e.HTTPStatus = CodeUpstreamTimeout, http.StatusGatewayTimeout
+	}
+	return e
+}
+
+// BadResponse describes an upstream answer that couldn't be read or made
+// no sense, such as a truncated body or one that isn't JSON, because of err
+// if there was one.
+func BadResponse(message string, err error) *APIError {
+	e := &APIError{Code: CodeUpstreamError, HTTPStatus: http.StatusBadGateway, Message: message, Err: err}
+	if err != nil {
+		e.Message += ": " + err.Error()
+	}
+	return e
+}
+
+// AsAPIError returns err as an *APIError, wrapping errors that aren't one
+// as a 502.
+func AsAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return &APIError{Code: CodeUpstreamError, HTTPStatus: http.StatusBadGateway, Message: err.Error(), Err: err}
+}
+
+// retryAfter reads a Retry-After header in seconds or as an HTTP date.
+func retryAfter(value string) int {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return seconds
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return int((d + time.Second - 1) / time.Second)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultUserAgent = "Manto/1.0"
+
+// forwardedHeaders are the only client request headers ever passed upstream.
+// Everything else is dropped: credentials, cookies, the client's address and
+// user agent, and Manto's own headers such as the access code.
+var forwardedHeaders = []string{"Accept", "Anthropic-Beta", "Anthropic-Version", "Content-Type"}
+
+// hopByHopHeaders describe a single connection and are never forwarded, nor
+// are any headers the client's Connection header names.
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// upstreamHeader returns the subset of client that may be forwarded.
+func upstreamHeader(client http.Header) http.Header {
+	dropped := slices.Clone(hopByHopHeaders)
+	for _, value := range client.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			dropped = append(dropped, textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)))
+		}
+	}
+
+	header := http.Header{}
+	for _, name := range forwardedHeaders {
+		if values := client.Values(name); len(values) > 0 && !slices.Contains(dropped, name) {
+			header[name] = slices.Clone(values)
+		}
+	}
+	return header
+}
+
+// newRequest builds every request to the upstream API, so what leaves Manto
+// is decided in one place: the allow-listed part of client (which may be
+// nil), the key in the configured auth header, the API version (the
+// client's, if it sent one), the configured User-Agent and, with a signing
+// key, the signature. A JSON content type is assumed for requests with a
+// body. Signing reads the whole body first.
+func (s *AnthropicService) newRequest(ctx context.Context, method, path, apiKey string, body io.Reader, client http.Header) (*http.Request, error) {
+	var signed []byte
+	if s.config.Anthropic.SigningKey != "" && body != nil {
+		var err error
+		if signed, err = io.ReadAll(body); err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		body = bytes.NewReader(signed)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.config.Anthropic.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = upstreamHeader(client)
+
+	if scheme := s.config.Anthropic.AuthScheme; scheme != "" {
+		apiKey = scheme + " " + apiKey
+	}
+	req.Header.Set(headerOrDefault(s.config.Anthropic.AuthHeader), apiKey)
+	if req.Header.Get("Anthropic-Version") == "" {
+		req.Header.Set("Anthropic-Version", s.config.Anthropic.APIVersion)
+	}
+	req.Header.Set("User-Agent", s.userAgent())
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	s.sign(req, signed, time.Now())
+	return req, nil
+}
+
+// versionHeader asks for the API version the client requested, or the
+// model's default.
+func (s *AnthropicService) versionHeader(request *MessageRequest) http.Header {
+	if request == nil {
+		return nil
+	}
+	version := request.APIVersion
+	if version == "" {
+		version = s.config.Anthropic.APIVersionFor(request.Model)
+	}
+	return http.Header{"Anthropic-Version": {version}}
+}
+
+func (s *AnthropicService) userAgent() string {
+	if s.config.Anthropic.UserAgent == "" {
+		return defaultUserAgent
+	}
+	return s.config.Anthropic.UserAgent
+}
+
+func headerOrDefault(name string) string {
+	if name == "" {
+		return "x-api-key"
+	}
+	return name
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+// ModelsCache remembers each key's model list for a while, keyed by a hash
+// of the provider and API key so keys aren't held in memory. The list rarely
+// changes, yet every page load asks for it. Concurrent misses for the same
+// key share one upstream call. Failures aren't cached. A nil *ModelsCache
+// asks the provider every time.
+type ModelsCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	lookups *metrics.CounterVec
+
+	mu       sync.Mutex
+	entries  map[string]modelsEntry
+	inflight map[string]*modelsCall
+}
+
+type modelsEntry struct {
+	data    string
+	expires time.Time
+}
+
+// modelsCall is an upstream call that waiting requests share; done is closed
+// once data and err are set.
+type modelsCall struct {
+	done chan struct{}
+	data string
+	err  error
+}
+
+// NewModelsCache returns a cache holding lists for ttl, or nil when ttl is
+// not positive.
+func NewModelsCache(ttl time.Duration, registry *metrics.Registry) *ModelsCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &ModelsCache{
+		ttl:      ttl,
+		now:      time.Now,
+		lookups:  registry.Counter("manto_models_cache_lookups_total", "Model list lookups by result (hit, miss, or shared with a call in flight).", "result"),
+		entries:  make(map[string]modelsEntry),
+		inflight: make(map[string]*modelsCall),
+	}
+}
+
+// GetModels returns provider's model list for apiKey, from the cache when
+// it's fresh. The upstream call isn't tied to the request that started it,
+// so a client going away doesn't fail the others waiting on it; each request
+// stops waiting when its own ctx ends.
+func (c *ModelsCache) GetModels(ctx context.Context, provider Provider, apiKey string) (string, error) {
+	if c == nil {
+		return provider.GetModels(ctx, apiKey)
+	}
+	key := modelsKey(provider.Name(), apiKey)
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && c.now().Before(entry.expires) {
+		c.mu.Unlock()
+		c.lookups.Inc("hit")
+		return entry.data, nil
+	}
+	call, shared := c.inflight[key]
+	if !shared {
+		call = &modelsCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		go c.fetch(context.WithoutCancel(ctx), provider, apiKey, key, call)
+	}
+	c.mu.Unlock()
+	if shared {
+		c.lookups.Inc("shared")
+	} else {
+		c.lookups.Inc("miss")
+	}
+
+	select {
+	case <-call.done:
+		return call.data, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (c *ModelsCache) fetch(ctx context.Context, provider Provider, apiKey, key string, call *modelsCall) {
+	call.data, call.err = provider.GetModels(ctx, apiKey)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if call.err == nil {
+		now := c.now()
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.entries[key] = modelsEntry{data: call.data, expires: now.Add(c.ttl)}
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// modelsKey hashes a provider name and API key. The separator can't appear
+// in provider names, so pairs cannot collide.
+func modelsKey(provider, apiKey string) string {
+	sum := sha256.Sum256([]byte(provider + "\x00" + apiKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/metrics"
+)
+
+// modelsProvider answers GetModels with the key it was asked for, once
+// release is closed.
+type modelsProvider struct {
+	Provider
+	name    string
+	calls   atomic.Int32
+	release chan struct{}
+	err     error
+}
+
+func (p *modelsProvider) Name() string { return p.name }
+
+func (p *modelsProvider) GetModels(ctx context.Context, apiKey string) (string, error) {
+	p.calls.Add(1)
+	<-p.release
+	if p.err != nil {
+		return "", p.err
+	}
+	return `{"data":[{"id":"` + apiKey + `"}]}`, nil
+}
+
+func TestModelsCacheBehavior(t *testing.T) {
+	newCache := func() *ModelsCache {
+		return NewModelsCache(10*time.Minute, metrics.NewRegistry())
+	}
+	released := func() chan struct{} {
+		release := make(chan struct{})
+		close(release)
+		return release
+	}
+
+	t.Run("serves repeat lookups from the cache until the TTL passes", func(t *testing.T) {
+		cache := newCache()
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+		provider := &modelsProvider{name: "anthropic", release: released()}
+
+		for i := 0; i < 3; i++ {
+			if data, err := cache.GetModels(context.Background(), provider, "sk-ant-one"); err != nil || data != `{"data":[{"id":"sk-ant-one"}]}` {
+				t.Fatalf("expected the provider's list, got %q, %v", data, err)
+			}
+		}
+		if got := provider.calls.Load(); got != 1 {
+			t.Errorf("expected 1 upstream call, got %d", got)
+		}
+
+		now = now.Add(10 * time.Minute)
+		cache.GetModels(context.Background(), provider, "sk-ant-one")
+		if got := provider.calls.Load(); got != 2 {
+			t.Errorf("expected a fresh call once the TTL passed, got %d calls", got)
+		}
+	})
+
+	t.Run("keys and providers are cached apart", func(t *testing.T) {
+		cache := newCache()
+		anthropic := &modelsProvider{name: "anthropic", release: released()}
+		openai := &modelsProvider{name: "openai", release: released()}
+
+		cache.GetModels(context.Background(), anthropic, "key-one")
+		if data, _ := cache.GetModels(context.Background(), anthropic, "key-two"); data != `{"data":[{"id":"key-two"}]}` {
+			t.Errorf("expected the second key's own list, got %q", data)
+		}
+		cache.GetModels(context.Background(), openai, "key-one")
+		if anthropic.calls.Load() != 2 || openai.calls.Load() != 1 {
+			t.Errorf("expected separate calls per key and provider, got %d and %d", anthropic.calls.Load(), openai.calls.Load())
+		}
+		for key := range cache.entries {
+			if key == "key-one" || key == "key-two" {
+				t.Errorf("expected hashed keys, found %q", key)
+			}
+		}
+	})
+
+	t.Run("concurrent misses share one upstream call", func(t *testing.T) {
+		cache := newCache()
+		provider := &modelsProvider{name: "anthropic", release: make(chan struct{})}
+
+		var wg sync.WaitGroup
+		results := make([]string, 5)
+		for i := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i], _ = cache.GetModels(context.Background(), provider, "sk-ant-one")
+			}()
+		}
+		for cache.lookups.Value("miss")+cache.lookups.Value("shared") < 5 {
+			time.Sleep(time.Millisecond)
+		}
+		close(provider.release)
+		wg.Wait()
+
+		if got := provider.calls.Load(); got != 1 {
+			t.Errorf("expected 1 upstream call, got %d", got)
+		}
+		for _, data := range results {
+			if data != `{"data":[{"id":"sk-ant-one"}]}` {
+				t.Errorf("expected every request to get the list, got %q", data)
+			}
+		}
+	})
+
+	t.Run("a waiter giving up doesn't fail the call", func(t *testing.T) {
+		cache := newCache()
+		provider := &modelsProvider{name: "anthropic", release: make(chan struct{})}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := cache.GetModels(ctx, provider, "sk-ant-one"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the canceled request to stop waiting, got %v", err)
+		}
+		close(provider.release)
+		if data, err := cache.GetModels(context.Background(), provider, "sk-ant-one"); err != nil || data == "" {
+			t.Errorf("expected the list, got %q, %v", data, err)
+		}
+		if got := provider.calls.Load(); got != 1 {
+			t.Errorf("expected the call to carry on and be cached, got %d calls", got)
+		}
+	})
+
+	t.Run("failures aren't cached", func(t *testing.T) {
+		cache := newCache()
+		provider := &modelsProvider{name: "anthropic", release: released(), err: UpstreamError(429, nil, "slow down")}
+
+		for i := 0; i < 2; i++ {
+			if _, err := cache.GetModels(context.Background(), provider, "sk-ant-one"); AsAPIError(err).Code != CodeRateLimited {
+				t.Fatalf("expected the provider's error, got %v", err)
+			}
+		}
+		if got := provider.calls.Load(); got != 2 {
+			t.Errorf("expected every failed lookup to reach the provider, got %d calls", got)
+		}
+	})
+
+	t.Run("a nil cache asks the provider every time", func(t *testing.T) {
+		cache := NewModelsCache(0, metrics.NewRegistry())
+		provider := &modelsProvider{name: "anthropic", release: released()}
+		cache.GetModels(context.Background(), provider, "sk-ant-one")
+		cache.GetModels(context.Background(), provider, "sk-ant-one")
+		if got := provider.calls.Load(); got != 2 {
+			t.Errorf("expected 2 upstream calls, got %d", got)
+		}
+	})
+}
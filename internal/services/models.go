@@ -1,16 +1,152 @@
 package services
 
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// MessageRequest mirrors the Anthropic Messages API request. Optional sampling
+// parameters are pointers so an explicit zero (e.g. temperature 0 for
+// deterministic output) is distinguishable from a field the client omitted.
 type MessageRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	MaxTokens   int       `json:"max_tokens"`
-	Temperature *float64  `json:"temperature,omitempty"`
-	System      *string   `json:"system,omitempty"`
+	Model         string          `json:"model"`
+	Messages      []Message       `json:"messages"`
+	MaxTokens     int             `json:"max_tokens"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          *int            `json:"top_k,omitempty"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	System        *string         `json:"system,omitempty"`
+	Thinking      *ThinkingConfig `json:"thinking,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	// ResponseFormat is enforced by Manto and taken out of the request
+	// before it goes upstream.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// APIVersion is the anthropic-version the client asked for, sent as a
+	// header; empty uses the model's default.
+	APIVersion string `json:"-"`
+}
+
+// ResponseFormatJSONSchema asks for an answer that is a JSON document
+// matching Schema.
+const ResponseFormatJSONSchema = "json_schema"
+
+// ResponseFormat asks for an answer in a given shape, e.g. {"type":
+// "json_schema", "schema": {...}}.
+type ResponseFormat struct {
+	Type   string          `json:"type"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// ThinkingConfig enables extended thinking, e.g. {"type": "enabled",
+// "budget_tokens": 2048}.
+type ThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// ApplyDefaults fills only the fields the client left unset. A MaxTokens of
+// zero is treated as unset since the API requires a positive value. The
+// default temperature is not applied with extended thinking, which only
+// accepts the API's own.
+func (r *MessageRequest) ApplyDefaults(maxTokens int, temperature float64, system string) {
+	if r.MaxTokens == 0 {
+		r.MaxTokens = maxTokens
+	}
+	if r.Temperature == nil && r.Thinking == nil {
+		r.Temperature = &temperature
+	}
+	if r.System == nil && system != "" {
+		r.System = &system
+	}
+}
+
+// MergeStopSequences appends server-mandated stop sequences to the client's,
+// skipping any the client already sent.
+func (r *MessageRequest) MergeStopSequences(extra []string) {
+	for _, s := range extra {
+		if !slices.Contains(r.StopSequences, s) {
+			r.StopSequences = append(r.StopSequences, s)
+		}
+	}
+}
+
+// CountTokensRequest mirrors the Anthropic token counting API request.
+type CountTokensRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	System   *string   `json:"system,omitempty"`
+}
+
+type CountTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
 }
 
+// Message is one turn of a conversation. Content is its text; Blocks, when
+// set, are sent in its place as the API's array form, which is how
+// assistant turns carry thinking blocks back. Model is the model that wrote
+// an assistant turn, where known, and is not sent. UploadID refers to text
+// uploaded in parts, which is appended to Content before the request is
+// checked; it is not sent either.
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role     string
+	Content  string
+	Blocks   []ContentBlock
+	Model    string
+	UploadID string
+}
+
+type wireMessage struct {
+	Role     string          `json:"role"`
+	Content  json.RawMessage `json:"content"`
+	UploadID string          `json:"uploadId,omitempty"`
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	var content any = m.Content
+	if len(m.Blocks) > 0 {
+		content = m.Blocks
+	}
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(wireMessage{Role: m.Role, Content: raw})
+}
+
+// UnmarshalJSON accepts content as a string or an array of blocks. Content
+// is set to the text either way, so length checks and moderation see it;
+// only assistant turns keep their blocks, since user turns are sent as the
+// (possibly redacted) text.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var wire wireMessage
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*m = Message{Role: wire.Role, UploadID: wire.UploadID}
+	if len(wire.Content) == 0 || string(wire.Content) == "null" {
+		return nil
+	}
+	if wire.Content[0] == '"' {
+		return json.Unmarshal(wire.Content, &m.Content)
+	}
+
+	var blocks []ContentBlock
+	if err := json.Unmarshal(wire.Content, &blocks); err != nil {
+		return err
+	}
+	var texts []string
+	for _, b := range blocks {
+		if b.Type == "text" && b.Text != nil {
+			texts = append(texts, *b.Text)
+		}
+	}
+	m.Content = strings.Join(texts, "\n\n")
+	if m.Role == "assistant" {
+		m.Blocks = blocks
+	}
+	return nil
 }
 
 type MessageResponse struct {
@@ -21,11 +157,48 @@ type MessageResponse struct {
 	Model      string         `json:"model"`
 	StopReason string         `json:"stop_reason"`
 	Usage      UsageInfo      `json:"usage"`
+
+	// Incomplete is set by Manto on an answer the upstream stopped sending
+	// partway through.
+	Incomplete *Incomplete `json:"incomplete,omitempty"`
+	// Continuations is set by Manto to the number of follow-up requests
+	// stitched into an answer that ran out of tokens.
+	Continuations int `json:"continuations,omitempty"`
+}
+
+// StopMaxTokens is the stop reason of an answer cut off by max_tokens.
+const StopMaxTokens = "max_tokens"
+
+// IncompleteUpstreamError is the reason given when the upstream stream
+// failed mid-answer.
+const IncompleteUpstreamError = "upstream_error"
+
+// Incomplete marks an answer cut short, with why.
+type Incomplete struct {
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Text concatenates the text blocks of the response.
+func (r *MessageResponse) Text() string {
+	var text string
+	for _, block := range r.Content {
+		if block.Type == "text" && block.Text != nil {
+			text += *block.Text
+		}
+	}
+	return text
 }
 
+// ContentBlock is a text, thinking or redacted_thinking block. Thinking
+// blocks carry a signature tied to the model that produced them; redacted
+// ones carry opaque data instead.
 type ContentBlock struct {
-	Type string  `json:"type"`
-	Text *string `json:"text,omitempty"`
+	Type      string  `json:"type"`
+	Text      *string `json:"text,omitempty"`
+	Thinking  *string `json:"thinking,omitempty"`
+	Signature string  `json:"signature,omitempty"`
+	Data      string  `json:"data,omitempty"`
 }
 
 type UsageInfo struct {
@@ -0,0 +1,72 @@
+package services
+
+import "strings"
+
+// Block types the proxy can carry in a conversation's history.
+const (
+	BlockText             = "text"
+	BlockThinking         = "thinking"
+	BlockRedactedThinking = "redacted_thinking"
+)
+
+// NormalizeHistory adapts the conversation to the request's model, so a
+// conversation can switch models mid-way without the API rejecting history
+// written by another. thinking reports whether the target model supports
+// extended thinking. Thinking blocks are dropped when it does not, or when
+// they came from a different model, since their signatures only verify with
+// the model that wrote them; block types the proxy cannot represent are
+// always dropped. Assistant turns left empty are removed and the user turns
+// around them merged. It returns the number of blocks dropped.
+func (r *MessageRequest) NormalizeHistory(thinking bool) int {
+	if !thinking {
+		r.Thinking = nil
+	}
+
+	dropped := 0
+	var messages []Message
+	for _, m := range r.Messages {
+		if len(m.Blocks) > 0 {
+			var kept []ContentBlock
+			for _, b := range m.Blocks {
+				switch b.Type {
+				case BlockText:
+					kept = append(kept, b)
+				case BlockThinking, BlockRedactedThinking:
+					if thinking && (m.Model == "" || m.Model == r.Model) {
+						kept = append(kept, b)
+						continue
+					}
+					dropped++
+				default:
+					dropped++
+				}
+			}
+			m.Blocks = kept
+			if !hasText(kept) {
+				m.Blocks = nil
+			}
+		}
+		if m.Role == "assistant" && len(m.Blocks) == 0 && strings.TrimSpace(m.Content) == "" {
+			continue
+		}
+
+		if n := len(messages); n > 0 && messages[n-1].Role == m.Role && m.Role == "user" {
+			messages[n-1].Content += "\n\n" + m.Content
+			continue
+		}
+		messages = append(messages, m)
+	}
+	r.Messages = messages
+	return dropped
+}
+
+// hasText reports whether blocks include a text block; an assistant turn of
+// thinking alone is not valid history.
+func hasText(blocks []ContentBlock) bool {
+	for _, b := range blocks {
+		if b.Type == BlockText {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,302 @@
+// Package ollama is a provider for models served by Ollama, usually on the
+// same machine. Ollama needs no API key; one sent anyway is ignored. Its
+// chat API is translated to and from the Messages API shapes the rest of
+// Manto uses.
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/egress"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/timing"
+)
+
+// Name is the provider's name in config and in requests.
+const Name = "ollama"
+
+// Options describes an Ollama server and how Manto offers it.
+type Options struct {
+	Name        string
+	DisplayName string
+	BaseURL     string
+	Timeout     time.Duration
+}
+
+// Service talks to an Ollama server.
+type Service struct {
+	config     *config.Config
+	options    Options
+	httpClient *http.Client
+}
+
+// New returns the provider cfg.Ollama describes.
+func New(cfg *config.Config) *Service {
+	return NewServer(cfg, Options{
+		Name:        Name,
+		DisplayName: "Ollama",
+		BaseURL:     cfg.Ollama.BaseURL,
+		Timeout:     cfg.Ollama.Timeout.Duration,
+	})
+}
+
+// NewServer returns a provider for another Ollama server.
+func NewServer(cfg *config.Config, options Options) *Service {
+	return &Service{
+		config:  cfg,
+		options: options,
+		httpClient: &http.Client{
+			Timeout:   options.Timeout,
+			Transport: egress.New(cfg).Transport(),
+		},
+	}
+}
+
+var (
+	_ services.Provider  = (*Service)(nil)
+	_ services.Describer = (*Service)(nil)
+)
+
+// Name implements services.Provider.
+func (s *Service) Name() string {
+	return s.options.Name
+}
+
+// DisplayName implements services.Describer.
+func (s *Service) DisplayName() string {
+	return s.options.DisplayName
+}
+
+// KeyRequired implements services.Describer.
+func (s *Service) KeyRequired() bool {
+	return false
+}
+
+// KeyPrefix implements services.Describer.
+func (s *Service) KeyPrefix() string {
+	return ""
+}
+
+// ClientAPIKey returns whatever key the caller sent, if any. It isn't passed
+// on, but still tells callers' conversations and usage apart.
+func (s *Service) ClientAPIKey(r *http.Request) string {
+	return services.ClientKey(r, s.config.Anthropic.ClientKeyHeader)
+}
+
+// ValidateAPIKey accepts any key, or none.
+func (s *Service) ValidateAPIKey(apiKey string) bool {
+	return true
+}
+
+// Fingerprint implements services.Provider.
+func (s *Service) Fingerprint(apiKey string) string {
+	return services.FingerprintKey(apiKey, nil)
+}
+
+// GetModels lists the pulled models in the Models API's shape, with the
+// model name as both ID and display name.
+func (s *Service) GetModels(ctx context.Context, apiKey string) (string, error) {
+	resp, err := s.do(ctx, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", services.BadResponse("failed to read response", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", apiError(resp.StatusCode, resp.Header, body)
+	}
+
+	var tags struct {
+		Models []struct {
+			Name       string    `json:"name"`
+			ModifiedAt time.Time `json:"modified_at"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(body, &tags); err != nil {
+		return "", services.BadResponse("failed to parse response", err)
+	}
+	data := make([]map[string]string, len(tags.Models))
+	for i, m := range tags.Models {
+		data[i] = map[string]string{
+			"id":           m.Name,
+			"type":         "model",
+			"display_name": m.Name,
+			"created_at":   m.ModifiedAt.UTC().Format(time.RFC3339),
+		}
+	}
+	models, err := json.Marshal(map[string]interface{}{"data": data, "has_more": false})
+	if err != nil {
+		return "", err
+	}
+	return string(models), nil
+}
+
+// SendMessage implements services.Provider.
+func (s *Service) SendMessage(ctx context.Context, apiKey string, request *services.MessageRequest) (*services.MessageResponse, error) {
+	resp, err := s.do(ctx, http.MethodPost, "/api/chat", chatRequest(request, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, services.BadResponse("failed to read response", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp.StatusCode, resp.Header, body)
+	}
+
+	var chunk chatChunk
+	if err := json.Unmarshal(body, &chunk); err != nil {
+		return nil, services.BadResponse("failed to parse response", err)
+	}
+	answer := newAnswer()
+	answer.add(chunk)
+	return answer.response(), nil
+}
+
+// StreamMessage implements services.Provider. Ollama streams one JSON
+// object per line, the last marked done. Failures once the answer has
+// started are returned as a *services.IncompleteError.
+func (s *Service) StreamMessage(ctx context.Context, apiKey string, request *services.MessageRequest, onText func(string) error) (*services.MessageResponse, error) {
+	resp, err := s.do(ctx, http.MethodPost, "/api/chat", chatRequest(request, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, services.BadResponse("failed to read response", err)
+		}
+		return nil, apiError(resp.StatusCode, resp.Header, body)
+	}
+
+	answer := newAnswer()
+	started := false
+	fail := func(err error) (*services.MessageResponse, error) {
+		if !started {
+			return nil, err
+		}
+		partial := answer.response()
+		partial.StopReason = ""
+		return nil, &services.IncompleteError{Partial: partial, Err: err}
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk chatChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fail(services.BadResponse("failed to parse stream event", err))
+		}
+		if chunk.Error != "" {
+			return fail(services.UpstreamError(http.StatusBadGateway, nil, chunk.Error))
+		}
+		started = true
+		answer.add(chunk)
+		if chunk.Message.Content != "" {
+			if err := onText(chunk.Message.Content); err != nil {
+				return nil, err
+			}
+		}
+		if chunk.Done {
+			return answer.response(), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(services.BadResponse("failed to read stream", err))
+	}
+	return fail(services.BadResponse("stream ended early", nil))
+}
+
+// do sends a request to path under the base URL.
+func (s *Service) do(ctx context.Context, method, path string, payload interface{}) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(jsonData)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.options.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", s.config.Anthropic.UserAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := s.httpClient.Do(req)
+	timing.FromContext(ctx).Since("upstream_headers", start)
+	if err != nil {
+		return nil, services.NetworkError(err)
+	}
+	return resp, nil
+}
+
+// apiError turns a failed response into an error, preferring Ollama's own
+// message, e.g. for a model that hasn't been pulled.
+func apiError(status int, header http.Header, body []byte) error {
+	var errorResp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error != "" {
+		return services.UpstreamError(status, header, errorResp.Error)
+	}
+	return services.UpstreamError(status, header, fmt.Sprintf("API error (status %d)", status))
+}
+
+// answer assembles a Messages API response from chat chunks.
+type answer struct {
+	message services.MessageResponse
+	text    string
+}
+
+func newAnswer() *answer {
+	return &answer{message: services.MessageResponse{
+		ID:   "msg_ollama_" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		Type: "message",
+		Role: "assistant",
+	}}
+}
+
+func (a *answer) add(chunk chatChunk) {
+	a.message.Model = chunk.Model
+	a.text += chunk.Message.Content
+	if chunk.Done {
+		a.message.StopReason = stopReason(chunk.DoneReason)
+		a.message.Usage = services.UsageInfo{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount}
+	}
+}
+
+func (a *answer) response() *services.MessageResponse {
+	response := a.message
+	response.Content = []services.ContentBlock{}
+	if a.text != "" {
+		text := a.text
+		response.Content = append(response.Content, services.ContentBlock{Type: "text", Text: &text})
+	}
+	return &response
+}
@@ -0,0 +1,138 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+)
+
+func createTestConfig(baseURL string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Ollama.Enabled = true
+	cfg.Ollama.BaseURL = baseURL
+	return cfg
+}
+
+func TestChatBehavior(t *testing.T) {
+	var upstream map[string]interface{}
+	var auth string
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"llama3.2:latest","model":"llama3.2:latest","modified_at":"2024-10-01T12:00:00.5+02:00","size":2019393189}]}`))
+		case "/api/chat":
+			upstream = nil
+			json.NewDecoder(r.Body).Decode(&upstream)
+			if upstream["stream"] == true {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.Write([]byte(`{"model":"llama3.2","message":{"role":"assistant","content":"Hel"},"done":false}` + "\n" +
+					`{"model":"llama3.2","message":{"role":"assistant","content":"lo"},"done":false}` + "\n" +
+					`{"model":"llama3.2","message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":9,"eval_count":2}` + "\n"))
+				return
+			}
+			w.Write([]byte(`{"model":"llama3.2","message":{"role":"assistant","content":"Hello"},"done":true,"done_reason":"stop","prompt_eval_count":9,"eval_count":1}`))
+		}
+	}))
+	defer fake.Close()
+	service := New(createTestConfig(fake.URL))
+
+	system := "Be brief"
+	topK := 40
+	request := &services.MessageRequest{
+		Model:     "llama3.2",
+		MaxTokens: 64,
+		TopK:      &topK,
+		System:    &system,
+		Messages:  []services.Message{{Role: "user", Content: "Hi"}},
+	}
+
+	t.Run("translates a message request and its answer", func(t *testing.T) {
+		response, err := service.SendMessage(context.Background(), "", request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if auth != "" {
+			t.Errorf("expected no credentials upstream, got %q", auth)
+		}
+		messages, _ := upstream["messages"].([]interface{})
+		if len(messages) != 2 || messages[0].(map[string]interface{})["role"] != "system" {
+			t.Errorf("expected the system prompt as the first message, got %v", upstream["messages"])
+		}
+		options, _ := upstream["options"].(map[string]interface{})
+		if options["num_predict"] != float64(64) || options["top_k"] != float64(40) || upstream["stream"] != false {
+			t.Errorf("expected options to be translated, got %v", upstream)
+		}
+		if response.Text() != "Hello" || response.StopReason != "end_turn" || response.Usage.InputTokens != 9 || response.Role != "assistant" {
+			t.Errorf("unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("streams answer text", func(t *testing.T) {
+		var pieces []string
+		response, err := service.StreamMessage(context.Background(), "", request, func(text string) error {
+			pieces = append(pieces, text)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Join(pieces, "|") != "Hel|lo" || response.Text() != "Hello" {
+			t.Errorf("expected text in pieces, got %v and %q", pieces, response.Text())
+		}
+		if response.StopReason != services.StopMaxTokens || response.Usage.OutputTokens != 2 {
+			t.Errorf("expected stop reason and usage from the last line, got %+v", response)
+		}
+	})
+
+	t.Run("lists pulled models in the Models API's shape", func(t *testing.T) {
+		models, err := service.GetModels(context.Background(), "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(models, `"id":"llama3.2:latest"`) || !strings.Contains(models, `"created_at":"2024-10-01T10:00:00Z"`) {
+			t.Errorf("unexpected models: %s", models)
+		}
+	})
+}
+
+func TestStreamFailureBehavior(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		expectIncomplete bool
+	}{
+		{name: "returns the partial answer when the stream breaks off", body: `{"message":{"content":"Hel"},"done":false}` + "\n", expectIncomplete: true},
+		{name: "returns Ollama's error before the answer starts", body: `{"error":"model \"llama9\" not found, try pulling it first"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer fake.Close()
+			service := New(createTestConfig(fake.URL))
+
+			request := &services.MessageRequest{Model: "llama9", Messages: []services.Message{{Role: "user", Content: "Hi"}}}
+			_, err := service.StreamMessage(context.Background(), "", request, func(string) error { return nil })
+			var incomplete *services.IncompleteError
+			if errors.As(err, &incomplete) != tt.expectIncomplete {
+				t.Fatalf("expected incomplete=%v, got %v", tt.expectIncomplete, err)
+			}
+			if tt.expectIncomplete && incomplete.Partial.Text() != "Hel" {
+				t.Errorf("expected the partial answer, got %q", incomplete.Partial.Text())
+			}
+			if !tt.expectIncomplete && (err == nil || !strings.Contains(err.Error(), "try pulling it first")) {
+				t.Errorf("expected Ollama's message, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package ollama
+
+import (
+	"github.com/manto/manto-web/internal/services"
+)
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type options struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// chatRequestBody is an /api/chat request.
+type chatRequestBody struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  options       `json:"options"`
+}
+
+// chatChunk is an /api/chat response, or one line of a streamed one.
+type chatChunk struct {
+	Model           string      `json:"model"`
+	Message         chatMessage `json:"message"`
+	Done            bool        `json:"done"`
+	DoneReason      string      `json:"done_reason"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+	EvalCount       int         `json:"eval_count"`
+	Error           string      `json:"error"`
+}
+
+// chatRequest translates request. The system prompt becomes the first
+// message and each turn is sent as its text; max_tokens becomes
+// num_predict, and extended thinking is dropped.
+func chatRequest(request *services.MessageRequest, stream bool) chatRequestBody {
+	body := chatRequestBody{
+		Model:  request.Model,
+		Stream: stream,
+		Options: options{
+			Temperature: request.Temperature,
+			TopP:        request.TopP,
+			TopK:        request.TopK,
+			NumPredict:  request.MaxTokens,
+			Stop:        request.StopSequences,
+		},
+	}
+	if request.System != nil && *request.System != "" {
+		body.Messages = append(body.Messages, chatMessage{Role: "system", Content: *request.System})
+	}
+	for _, m := range request.Messages {
+		body.Messages = append(body.Messages, chatMessage{Role: m.Role, Content: m.Content})
+	}
+	return body
+}
+
+// stopReason maps Ollama's done reason to the Messages API's stop reason.
+func stopReason(doneReason string) string {
+	switch doneReason {
+	case "stop", "":
+		return "end_turn"
+	case "length":
+		return services.StopMaxTokens
+	default:
+		return doneReason
+	}
+}
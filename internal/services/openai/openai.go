@@ -0,0 +1,335 @@
+// Package openai is a provider for the OpenAI Chat Completions API and the
+// many servers compatible with it. Requests and answers are translated to
+// and from the Messages API shapes the rest of Manto uses, so handlers can't
+// tell the providers apart.
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/egress"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/timing"
+)
+
+// Name is the provider's name in config and in requests.
+const Name = "openai"
+
+// Options describes an OpenAI-compatible API and how Manto offers it.
+type Options struct {
+	Name        string
+	DisplayName string
+	// BaseURL includes the API version, e.g. https://api.openai.com/v1.
+	BaseURL   string
+	KeyPrefix string
+	Timeout   time.Duration
+}
+
+// Service talks to an OpenAI-compatible API with the caller's key.
+type Service struct {
+	config     *config.Config
+	options    Options
+	httpClient *http.Client
+}
+
+// New returns the provider cfg.OpenAI describes.
+func New(cfg *config.Config) *Service {
+	return NewCompatible(cfg, Options{
+		Name:        Name,
+		DisplayName: "OpenAI",
+		BaseURL:     cfg.OpenAI.BaseURL,
+		KeyPrefix:   cfg.OpenAI.KeyPrefix,
+		Timeout:     cfg.OpenAI.Timeout.Duration,
+	})
+}
+
+// NewCompatible returns a provider for another OpenAI-compatible API.
+func NewCompatible(cfg *config.Config, options Options) *Service {
+	return &Service{
+		config:  cfg,
+		options: options,
+		httpClient: &http.Client{
+			Timeout:   options.Timeout,
+			Transport: egress.New(cfg).Transport(),
+		},
+	}
+}
+
+var (
+	_ services.Provider  = (*Service)(nil)
+	_ services.Describer = (*Service)(nil)
+)
+
+// Name implements services.Provider.
+func (s *Service) Name() string {
+	return s.options.Name
+}
+
+// DisplayName implements services.Describer.
+func (s *Service) DisplayName() string {
+	return s.options.DisplayName
+}
+
+// KeyRequired implements services.Describer.
+func (s *Service) KeyRequired() bool {
+	return true
+}
+
+// KeyPrefix implements services.Describer.
+func (s *Service) KeyPrefix() string {
+	return s.options.KeyPrefix
+}
+
+// ClientAPIKey reads the caller's key from the same header as for Anthropic,
+// so clients send every provider's key the same way.
+func (s *Service) ClientAPIKey(r *http.Request) string {
+	return services.ClientKey(r, s.config.Anthropic.ClientKeyHeader)
+}
+
+// ValidateAPIKey checks the key's length and, when one is configured, its
+// prefix.
+func (s *Service) ValidateAPIKey(apiKey string) bool {
+	return len(apiKey) >= s.config.Security.APIKeyMinLength && strings.HasPrefix(apiKey, s.options.KeyPrefix)
+}
+
+// Fingerprint implements services.Provider.
+func (s *Service) Fingerprint(apiKey string) string {
+	return services.FingerprintKey(apiKey, []string{s.options.KeyPrefix})
+}
+
+type model struct {
+	ID      string `json:"id"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+	// Name, ContextLength and Pricing are reported by OpenRouter and some
+	// other aggregators; prices are USD per token, as decimal strings.
+	Name          string `json:"name"`
+	ContextLength int    `json:"context_length"`
+	Pricing       *struct {
+		Prompt     string `json:"prompt"`
+		Completion string `json:"completion"`
+	} `json:"pricing"`
+}
+
+// GetModels lists the models in the Models API's shape, with the ID as the
+// display name unless the API reports a name. A context length and prices
+// are passed on as context_length and pricing, in USD per million tokens
+// like the Anthropic price settings, when the API reports them.
+func (s *Service) GetModels(ctx context.Context, apiKey string) (string, error) {
+	resp, err := s.do(ctx, http.MethodGet, "/models", apiKey, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", services.BadResponse("failed to read response", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", services.UpstreamError(resp.StatusCode, resp.Header, fmt.Sprintf("API error (status %d): %s", resp.StatusCode, body))
+	}
+
+	var list struct {
+		Data []model `json:"data"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return "", services.BadResponse("failed to parse response", err)
+	}
+	data := make([]map[string]interface{}, len(list.Data))
+	for i, m := range list.Data {
+		entry := map[string]interface{}{
+			"id":           m.ID,
+			"type":         "model",
+			"display_name": m.ID,
+			"created_at":   time.Unix(m.Created, 0).UTC().Format(time.RFC3339),
+		}
+		if m.Name != "" {
+			entry["display_name"] = m.Name
+		}
+		if m.ContextLength > 0 {
+			entry["context_length"] = m.ContextLength
+		}
+		if m.Pricing != nil {
+			input, inputOK := perMillion(m.Pricing.Prompt)
+			output, outputOK := perMillion(m.Pricing.Completion)
+			if inputOK && outputOK {
+				entry["pricing"] = map[string]float64{"input_per_million": input, "output_per_million": output}
+			}
+		}
+		data[i] = entry
+	}
+	models, err := json.Marshal(map[string]interface{}{"data": data, "has_more": false})
+	if err != nil {
+		return "", err
+	}
+	return string(models), nil
+}
+
+// perMillion converts a per-token price to USD per million tokens, rounded
+// to a millionth of a dollar. Prices that can't be known in advance, which
+// OpenRouter gives as -1 for its routers, and unparseable ones are not ok.
+func perMillion(perToken string) (float64, bool) {
+	price, err := strconv.ParseFloat(perToken, 64)
+	if err != nil || price < 0 {
+		return 0, false
+	}
+	return math.Round(price*1e12) / 1e6, true
+}
+
+// SendMessage implements services.Provider.
+func (s *Service) SendMessage(ctx context.Context, apiKey string, request *services.MessageRequest) (*services.MessageResponse, error) {
+	resp, err := s.do(ctx, http.MethodPost, "/chat/completions", apiKey, chatRequest(request, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, services.BadResponse("failed to read response", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp.StatusCode, resp.Header, body)
+	}
+
+	var completion chatCompletion
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return nil, services.BadResponse("failed to parse response", err)
+	}
+	return completion.response(), nil
+}
+
+// StreamMessage implements services.Provider. Failures once the answer has
+// started are returned as a *services.IncompleteError.
+func (s *Service) StreamMessage(ctx context.Context, apiKey string, request *services.MessageRequest, onText func(string) error) (*services.MessageResponse, error) {
+	resp, err := s.do(ctx, http.MethodPost, "/chat/completions", apiKey, chatRequest(request, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, services.BadResponse("failed to read response", err)
+		}
+		return nil, apiError(resp.StatusCode, resp.Header, body)
+	}
+
+	completion := chatCompletion{Choices: []choice{{}}}
+	answer := &completion.Choices[0]
+	started := false
+	fail := func(err error) (*services.MessageResponse, error) {
+		if !started {
+			return nil, err
+		}
+		partial := completion.response()
+		partial.StopReason = ""
+		return nil, &services.IncompleteError{Partial: partial, Err: err}
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return completion.response(), nil
+		}
+		var chunk chatCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fail(services.BadResponse("failed to parse stream event", err))
+		}
+		if chunk.Error != nil {
+			return fail(services.UpstreamError(http.StatusBadGateway, nil, chunk.Error.Message))
+		}
+		started = true
+		completion.ID, completion.Model = chunk.ID, chunk.Model
+		if chunk.Usage != nil {
+			completion.Usage = chunk.Usage
+		}
+		for _, c := range chunk.Choices {
+			if c.Index != 0 {
+				continue
+			}
+			if c.FinishReason != "" {
+				answer.FinishReason = c.FinishReason
+			}
+			if c.Delta.Content == "" {
+				continue
+			}
+			answer.Message.Content += c.Delta.Content
+			if err := onText(c.Delta.Content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(services.BadResponse("failed to read stream", err))
+	}
+	return fail(services.BadResponse("stream ended early", nil))
+}
+
+// do sends a request to path under the base URL with apiKey as a bearer
+// token, reporting a rejected key to ctx's auth failure hook.
+func (s *Service) do(ctx context.Context, method, path, apiKey string, payload interface{}) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(jsonData)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.options.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("User-Agent", s.config.Anthropic.UserAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := s.httpClient.Do(req)
+	timing.FromContext(ctx).Since("upstream_headers", start)
+	if err != nil {
+		return nil, services.NetworkError(err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		services.ReportAuthFailure(ctx)
+	}
+	return resp, nil
+}
+
+// apiError turns a failed response into an error, preferring the API's own
+// message, which comes in the same envelope as the Messages API's.
+func apiError(status int, header http.Header, body []byte) error {
+	var errorResp services.ErrorResponse
+	if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
+		return services.UpstreamError(status, header, errorResp.Error.Message)
+	}
+	switch status {
+	case http.StatusUnauthorized:
+		return services.UpstreamError(status, header, "invalid API key")
+	case http.StatusTooManyRequests:
+		return services.UpstreamError(status, header, "rate limit exceeded")
+	default:
+		return services.UpstreamError(status, header, fmt.Sprintf("API error (status %d)", status))
+	}
+}
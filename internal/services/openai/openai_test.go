@@ -0,0 +1,206 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+)
+
+func createTestConfig(baseURL string) *config.Config {
+	cfg := &config.Config{}
+	cfg.OpenAI.Enabled = true
+	cfg.OpenAI.BaseURL = baseURL
+	cfg.OpenAI.KeyPrefix = "sk-"
+	cfg.Security.APIKeyMinLength = 10
+	return cfg
+}
+
+func TestChatCompletionsBehavior(t *testing.T) {
+	var upstream map[string]interface{}
+	var auth string
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/v1/models":
+			w.Write([]byte(`{"object":"list","data":[{"id":"gpt-4o","object":"model","created":1715367049,"owned_by":"system"}]}`))
+		case "/v1/chat/completions":
+			upstream = nil
+			json.NewDecoder(r.Body).Decode(&upstream)
+			if upstream["stream"] == true {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte("data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hel\"}}]}\n\n" +
+					"data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo\"},\"finish_reason\":\"length\"}]}\n\n" +
+					"data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":2}}\n\n" +
+					"data: [DONE]\n\n"))
+				return
+			}
+			w.Write([]byte(`{"id":"c1","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":7,"completion_tokens":1}}`))
+		}
+	}))
+	defer fake.Close()
+	service := New(createTestConfig(fake.URL + "/v1"))
+
+	system := "Be brief"
+	temperature := 0.2
+	request := &services.MessageRequest{
+		Model:         "gpt-4o",
+		MaxTokens:     64,
+		Temperature:   &temperature,
+		System:        &system,
+		StopSequences: []string{"END"},
+		Messages:      []services.Message{{Role: "user", Content: "Hi"}},
+	}
+
+	t.Run("translates a message request and its answer", func(t *testing.T) {
+		response, err := service.SendMessage(context.Background(), "sk-test-1234567890", request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if auth != "Bearer sk-test-1234567890" {
+			t.Errorf("expected the key as a bearer token, got %q", auth)
+		}
+		messages, _ := upstream["messages"].([]interface{})
+		if len(messages) != 2 || messages[0].(map[string]interface{})["role"] != "system" {
+			t.Errorf("expected the system prompt as the first message, got %v", upstream["messages"])
+		}
+		if upstream["max_tokens"] != float64(64) || upstream["temperature"] != 0.2 || len(upstream["stop"].([]interface{})) != 1 {
+			t.Errorf("expected sampling parameters to be translated, got %v", upstream)
+		}
+		if response.Text() != "Hello" || response.StopReason != "end_turn" || response.Usage.InputTokens != 7 || response.Role != "assistant" {
+			t.Errorf("unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("streams answer text", func(t *testing.T) {
+		var pieces []string
+		response, err := service.StreamMessage(context.Background(), "sk-test-1234567890", request, func(text string) error {
+			pieces = append(pieces, text)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Join(pieces, "|") != "Hel|lo" || response.Text() != "Hello" {
+			t.Errorf("expected text in pieces, got %v and %q", pieces, response.Text())
+		}
+		if response.StopReason != services.StopMaxTokens || response.Usage.OutputTokens != 2 {
+			t.Errorf("expected stop reason and usage from the stream, got %+v", response)
+		}
+	})
+
+	t.Run("lists models in the Models API's shape", func(t *testing.T) {
+		models, err := service.GetModels(context.Background(), "sk-test-1234567890")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(models, `"display_name":"gpt-4o"`) || !strings.Contains(models, `"created_at":"2024-05-10T18:50:49Z"`) {
+			t.Errorf("unexpected models: %s", models)
+		}
+	})
+}
+
+func TestStreamFailureBehavior(t *testing.T) {
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n"))
+	}))
+	defer fake.Close()
+	service := New(createTestConfig(fake.URL))
+
+	request := &services.MessageRequest{Model: "gpt-4o", Messages: []services.Message{{Role: "user", Content: "Hi"}}}
+	_, err := service.StreamMessage(context.Background(), "sk-test-1234567890", request, func(string) error { return nil })
+	var incomplete *services.IncompleteError
+	if !errors.As(err, &incomplete) || incomplete.Partial.Text() != "Hel" {
+		t.Errorf("expected an incomplete answer, got %v", err)
+	}
+}
+
+func TestAPIKeyBehavior(t *testing.T) {
+	var failures int
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"Incorrect API key provided","type":"invalid_request_error"}}`))
+	}))
+	defer fake.Close()
+	service := New(createTestConfig(fake.URL))
+
+	tests := []struct {
+		apiKey   string
+		expected bool
+	}{
+		{apiKey: "sk-proj-1234567890", expected: true},
+		{apiKey: "sk-12", expected: false},
+		{apiKey: "key-1234567890", expected: false},
+	}
+	for _, tt := range tests {
+		if got := service.ValidateAPIKey(tt.apiKey); got != tt.expected {
+			t.Errorf("ValidateAPIKey(%q) = %v, expected %v", tt.apiKey, got, tt.expected)
+		}
+	}
+
+	ctx := services.WithAuthFailureHook(context.Background(), func() { failures++ })
+	request := &services.MessageRequest{Model: "gpt-4o", Messages: []services.Message{{Role: "user", Content: "Hi"}}}
+	_, err := service.SendMessage(ctx, "sk-proj-1234567890", request)
+	if err == nil || err.Error() != "Incorrect API key provided" {
+		t.Errorf("expected the API's error message, got %v", err)
+	}
+	if failures != 1 {
+		t.Errorf("expected a rejected key to be reported, got %d reports", failures)
+	}
+}
+
+func TestOpenRouterBehavior(t *testing.T) {
+	var auth string
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"data":[` +
+			`{"id":"anthropic/claude-sonnet-4","name":"Anthropic: Claude Sonnet 4","created":1747930371,"context_length":200000,"pricing":{"prompt":"0.000003","completion":"0.000015"}},` +
+			`{"id":"openrouter/auto","name":"Auto Router","created":1699401600,"context_length":2000000,"pricing":{"prompt":"-1","completion":"-1"}}]}`))
+	}))
+	defer fake.Close()
+	cfg := createTestConfig("")
+	cfg.OpenRouter.BaseURL = fake.URL + "/api/v1"
+	cfg.OpenRouter.KeyPrefix = "sk-or-"
+	service := NewOpenRouter(cfg)
+
+	if service.Name() != OpenRouterName || service.ValidateAPIKey("sk-proj-1234567890") || !service.ValidateAPIKey("sk-or-v1-1234567890") {
+		t.Errorf("expected OpenRouter's name and key prefix, got %q", service.Name())
+	}
+
+	models, err := service.GetModels(context.Background(), "sk-or-v1-1234567890")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if auth != "Bearer sk-or-v1-1234567890" {
+		t.Errorf("expected the caller's key, got %q", auth)
+	}
+	var list struct {
+		Data []struct {
+			ID            string             `json:"id"`
+			DisplayName   string             `json:"display_name"`
+			ContextLength int                `json:"context_length"`
+			Pricing       map[string]float64 `json:"pricing"`
+		} `json:"data"`
+	}
+	json.Unmarshal([]byte(models), &list)
+	if len(list.Data) != 2 {
+		t.Fatalf("expected two models, got %s", models)
+	}
+	sonnet := list.Data[0]
+	if sonnet.DisplayName != "Anthropic: Claude Sonnet 4" || sonnet.ContextLength != 200000 {
+		t.Errorf("expected the name and context length, got %+v", sonnet)
+	}
+	if sonnet.Pricing["input_per_million"] != 3 || sonnet.Pricing["output_per_million"] != 15 {
+		t.Errorf("expected prices per million tokens, got %v", sonnet.Pricing)
+	}
+	if list.Data[1].Pricing != nil {
+		t.Errorf("expected no prices for a router, got %v", list.Data[1].Pricing)
+	}
+}
@@ -0,0 +1,22 @@
+package openai
+
+import (
+	"github.com/manto/manto-web/internal/config"
+)
+
+// OpenRouterName is the OpenRouter provider's name in config and in
+// requests.
+const OpenRouterName = "openrouter"
+
+// NewOpenRouter returns the provider cfg.OpenRouter describes. OpenRouter
+// reaches many vendors' models with one key through an OpenAI-compatible
+// API, and reports each model's context length and prices.
+func NewOpenRouter(cfg *config.Config) *Service {
+	return NewCompatible(cfg, Options{
+		Name:        OpenRouterName,
+		DisplayName: "OpenRouter",
+		BaseURL:     cfg.OpenRouter.BaseURL,
+		KeyPrefix:   cfg.OpenRouter.KeyPrefix,
+		Timeout:     cfg.OpenRouter.Timeout.Duration,
+	})
+}
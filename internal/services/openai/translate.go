@@ -0,0 +1,107 @@
+package openai
+
+import (
+	"github.com/manto/manto-web/internal/services"
+)
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// chatRequestBody is a Chat Completions request.
+type chatRequestBody struct {
+	Model         string         `json:"model"`
+	Messages      []chatMessage  `json:"messages"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	TopP          *float64       `json:"top_p,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type choice struct {
+	Index        int         `json:"index"`
+	Message      chatMessage `json:"message"`
+	Delta        chatMessage `json:"delta"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// chatCompletion is a Chat Completions response, or one chunk of a streamed
+// one.
+type chatCompletion struct {
+	ID      string                `json:"id"`
+	Model   string                `json:"model"`
+	Choices []choice              `json:"choices"`
+	Usage   *usage                `json:"usage"`
+	Error   *services.ErrorDetail `json:"error"`
+}
+
+// chatRequest translates request. The system prompt becomes the first
+// message and each turn is sent as its text, so thinking blocks are left
+// behind; top_k and extended thinking have no equivalent and are dropped.
+func chatRequest(request *services.MessageRequest, stream bool) chatRequestBody {
+	body := chatRequestBody{
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		Stop:        request.StopSequences,
+		Stream:      stream,
+	}
+	if stream {
+		body.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	if request.System != nil && *request.System != "" {
+		body.Messages = append(body.Messages, chatMessage{Role: "system", Content: *request.System})
+	}
+	for _, m := range request.Messages {
+		body.Messages = append(body.Messages, chatMessage{Role: m.Role, Content: m.Content})
+	}
+	return body
+}
+
+// stopReasons maps finish reasons to the Messages API's stop reasons.
+var stopReasons = map[string]string{
+	"stop":           "end_turn",
+	"length":         services.StopMaxTokens,
+	"content_filter": "refusal",
+	"tool_calls":     "tool_use",
+	"function_call":  "tool_use",
+}
+
+// response translates the first choice of c into a Messages API response.
+func (c *chatCompletion) response() *services.MessageResponse {
+	response := &services.MessageResponse{
+		ID:      c.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   c.Model,
+		Content: []services.ContentBlock{},
+	}
+	if c.Usage != nil {
+		response.Usage = services.UsageInfo{InputTokens: c.Usage.PromptTokens, OutputTokens: c.Usage.CompletionTokens}
+	}
+	if len(c.Choices) == 0 {
+		return response
+	}
+	answer := c.Choices[0]
+	if text := answer.Message.Content; text != "" {
+		response.Content = append(response.Content, services.ContentBlock{Type: "text", Text: &text})
+	}
+	response.StopReason = answer.FinishReason
+	if reason, ok := stopReasons[answer.FinishReason]; ok {
+		response.StopReason = reason
+	}
+	return response
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// Provider is a model backend. Handlers reach backends only through it, so
+// adding one doesn't touch them. Keys are the caller's own, read from the
+// request by ClientAPIKey and fingerprinted for logs and usage.
+type Provider interface {
+	// Name identifies the provider in config and in requests.
+	Name() string
+	ClientAPIKey(r *http.Request) string
+	ValidateAPIKey(apiKey string) bool
+	Fingerprint(apiKey string) string
+	SendMessage(ctx context.Context, apiKey string, request *MessageRequest) (*MessageResponse, error)
+	// StreamMessage calls onText with each piece of answer text as it
+	// arrives and returns the assembled response.
+	StreamMessage(ctx context.Context, apiKey string, request *MessageRequest, onText func(string) error) (*MessageResponse, error)
+	// GetModels returns the model list as a JSON document with the models
+	// under "data", in the Models API's shape.
+	GetModels(ctx context.Context, apiKey string) (string, error)
+}
+
+// TokenCounter is a Provider that counts input tokens exactly.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, apiKey string, request *CountTokensRequest) (int, error)
+}
+
+// Forwarder is a Provider whose raw API can be proxied.
+type Forwarder interface {
+	Forward(ctx context.Context, apiKey, method, path, rawQuery string, header http.Header, body io.Reader) (*http.Response, error)
+}
+
+// Describer is a Provider that tells clients how to present it: its name
+// for people, whether it needs a key, and the prefix its keys start with, if
+// any.
+type Describer interface {
+	DisplayName() string
+	KeyRequired() bool
+	KeyPrefix() string
+}
+
+// AnthropicProvider is the name of the Anthropic Messages API provider.
+const AnthropicProvider = "anthropic"
+
+// Name implements Provider.
+func (s *AnthropicService) Name() string {
+	return s.name
+}
+
+// DisplayName implements Describer.
+func (s *AnthropicService) DisplayName() string {
+	return s.displayName
+}
+
+// KeyRequired implements Describer.
+func (s *AnthropicService) KeyRequired() bool {
+	return true
+}
+
+// KeyPrefix implements Describer with the first configured prefix.
+func (s *AnthropicService) KeyPrefix() string {
+	if len(s.keyPrefixes) == 0 {
+		return ""
+	}
+	return s.keyPrefixes[0]
+}
+
+var (
+	_ Provider     = (*AnthropicService)(nil)
+	_ TokenCounter = (*AnthropicService)(nil)
+	_ Forwarder    = (*AnthropicService)(nil)
+	_ Describer    = (*AnthropicService)(nil)
+)
@@ -0,0 +1,95 @@
+// Package registry builds every configured provider at startup: Anthropic,
+// the built-in providers enabled by their own settings, and the entries of
+// the PROVIDERS_<n>_* list.
+package registry
+
+import (
+	"fmt"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/services/ollama"
+	"github.com/manto/manto-web/internal/services/openai"
+	"github.com/manto/manto-web/internal/services/vertex"
+)
+
+// Build returns the providers cfg configures, in the order of
+// cfg.ProviderNames. anthropic is built by the caller, which also uses it
+// for the startup probe and key lockout.
+func Build(cfg *config.Config, anthropic *services.AnthropicService) ([]services.Provider, error) {
+	providers := []services.Provider{anthropic}
+	if cfg.OpenAI.Enabled {
+		providers = append(providers, openai.New(cfg))
+	}
+	if cfg.OpenRouter.Enabled {
+		providers = append(providers, openai.NewOpenRouter(cfg))
+	}
+	if cfg.Ollama.Enabled {
+		providers = append(providers, ollama.New(cfg))
+	}
+	if cfg.Vertex.Enabled {
+		p, err := vertex.New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("vertex: %w", err)
+		}
+		providers = append(providers, p)
+	}
+	for _, spec := range cfg.Providers {
+		p, err := fromSpec(cfg, spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.Name, err)
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// fromSpec builds a registry entry. Settings the entry leaves empty come
+// from its type's own settings.
+func fromSpec(cfg *config.Config, spec config.ProviderSpec) (services.Provider, error) {
+	displayName := or(spec.DisplayName, spec.Name)
+	switch spec.Type {
+	case "anthropic":
+		// The service reads its settings from the config it is given, so
+		// the entry gets a copy with its own endpoint and keys.
+		entry := *cfg
+		entry.Anthropic.BaseURL = or(spec.BaseURL, cfg.Anthropic.BaseURL)
+		if spec.KeyPrefix != "" {
+			entry.Anthropic.KeyPrefix = spec.KeyPrefix
+			entry.Anthropic.KeyPattern = ""
+		}
+		return services.NewAnthropicService(&entry).WithName(spec.Name, displayName), nil
+	case "openai":
+		return openai.NewCompatible(cfg, openai.Options{
+			Name:        spec.Name,
+			DisplayName: displayName,
+			BaseURL:     spec.BaseURL,
+			KeyPrefix:   or(spec.KeyPrefix, cfg.OpenAI.KeyPrefix),
+			Timeout:     cfg.OpenAI.Timeout.Duration,
+		}), nil
+	case "openrouter":
+		return openai.NewCompatible(cfg, openai.Options{
+			Name:        spec.Name,
+			DisplayName: displayName,
+			BaseURL:     or(spec.BaseURL, cfg.OpenRouter.BaseURL),
+			KeyPrefix:   or(spec.KeyPrefix, cfg.OpenRouter.KeyPrefix),
+			Timeout:     cfg.OpenRouter.Timeout.Duration,
+		}), nil
+	case "ollama":
+		return ollama.NewServer(cfg, ollama.Options{
+			Name:        spec.Name,
+			DisplayName: displayName,
+			BaseURL:     or(spec.BaseURL, cfg.Ollama.BaseURL),
+			Timeout:     cfg.Ollama.Timeout.Duration,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", spec.Type)
+	}
+}
+
+func or(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+)
+
+func createTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Anthropic.BaseURL = "https://api.anthropic.com"
+	cfg.Anthropic.KeyPrefix = "sk-ant-"
+	cfg.OpenAI.KeyPrefix = "sk-"
+	cfg.OpenRouter.BaseURL = "https://openrouter.ai/api/v1"
+	cfg.OpenRouter.KeyPrefix = "sk-or-"
+	cfg.Ollama.BaseURL = "http://localhost:11434"
+	return cfg
+}
+
+func TestBuildBehavior(t *testing.T) {
+	tests := []struct {
+		name            string
+		setup           func(cfg *config.Config)
+		expectError     bool
+		expectedNames   []string
+		expectedDisplay map[string]string
+		expectedPrefix  map[string]string
+	}{
+		{
+			name:          "registers Anthropic alone by default",
+			setup:         func(cfg *config.Config) {},
+			expectedNames: []string{"anthropic"},
+		},
+		{
+			name: "registers the enabled built-in providers",
+			setup: func(cfg *config.Config) {
+				cfg.OpenAI.Enabled = true
+				cfg.Ollama.Enabled = true
+			},
+			expectedNames: []string{"anthropic", "openai", "ollama"},
+		},
+		{
+			name: "builds the providers list by type",
+			setup: func(cfg *config.Config) {
+				cfg.Providers = []config.ProviderSpec{
+					{Name: "groq", Type: "openai", DisplayName: "Groq", BaseURL: "https://api.groq.com/openai/v1", KeyPrefix: "gsk_"},
+					{Name: "gpu-box", Type: "ollama", BaseURL: "http://gpu-box.internal:11434"},
+					{Name: "gateway", Type: "anthropic", DisplayName: "Claude via gateway", BaseURL: "https://llm-gateway.example.com", KeyPrefix: "gw-"},
+					{Name: "router", Type: "openrouter"},
+				}
+			},
+			expectedNames:   []string{"anthropic", "groq", "gpu-box", "gateway", "router"},
+			expectedDisplay: map[string]string{"groq": "Groq", "gpu-box": "gpu-box", "gateway": "Claude via gateway"},
+			expectedPrefix:  map[string]string{"anthropic": "sk-ant-", "groq": "gsk_", "gateway": "gw-", "router": "sk-or-"},
+		},
+		{
+			name: "rejects an unknown type",
+			setup: func(cfg *config.Config) {
+				cfg.Providers = []config.ProviderSpec{{Name: "bedrock", Type: "bedrock"}}
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createTestConfig()
+			tt.setup(cfg)
+			providers, err := Build(cfg, services.NewAnthropicService(cfg))
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(providers) != len(tt.expectedNames) {
+				t.Fatalf("expected %d providers, got %d", len(tt.expectedNames), len(providers))
+			}
+			for i, p := range providers {
+				name := p.Name()
+				if name != tt.expectedNames[i] {
+					t.Errorf("expected provider %d to be %q, got %q", i, tt.expectedNames[i], name)
+				}
+				d, ok := p.(services.Describer)
+				if !ok {
+					continue
+				}
+				if want, ok := tt.expectedDisplay[name]; ok && d.DisplayName() != want {
+					t.Errorf("%s: expected display name %q, got %q", name, want, d.DisplayName())
+				}
+				if want, ok := tt.expectedPrefix[name]; ok && d.KeyPrefix() != want {
+					t.Errorf("%s: expected key prefix %q, got %q", name, want, d.KeyPrefix())
+				}
+			}
+		})
+	}
+
+	t.Run("leaves the shared Anthropic settings alone", func(t *testing.T) {
+		cfg := createTestConfig()
+		cfg.Providers = []config.ProviderSpec{{Name: "gateway", Type: "anthropic", BaseURL: "https://llm-gateway.example.com", KeyPrefix: "gw-"}}
+		if _, err := Build(cfg, services.NewAnthropicService(cfg)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Anthropic.BaseURL != "https://api.anthropic.com" || cfg.Anthropic.KeyPrefix != "sk-ant-" {
+			t.Errorf("expected the Anthropic settings to be unchanged, got %+v", cfg.Anthropic)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sign adds the configured HMAC signature header to req, whose body is body.
+// The header reads "t=<unix seconds>,v1=<hex>", where the MAC covers
+//
+//	<unix seconds>\n<METHOD>\n<request URI>\n<hex digest of the body>
+//
+// with the digest taken with the same algorithm, so gateways can reject
+// stale or altered requests. It does nothing without a signing key.
+func (s *AnthropicService) sign(req *http.Request, body []byte, now time.Time) {
+	cfg := s.config.Anthropic
+	if cfg.SigningKey == "" {
+		return
+	}
+	newHash := sha256.New
+	if cfg.SigningAlgorithm == "sha512" {
+		newHash = sha512.New
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(cfg.SigningHeader, "t="+timestamp+",v1="+signature(newHash, cfg.SigningKey, timestamp, req.Method, req.URL.RequestURI(), body))
+}
+
+func signature(newHash func() hash.Hash, key, timestamp, method, requestURI string, body []byte) string {
+	digest := newHash()
+	digest.Write(body)
+	mac := hmac.New(newHash, []byte(key))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + requestURI + "\n" + hex.EncodeToString(digest.Sum(nil))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
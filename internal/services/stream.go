@@ -0,0 +1,157 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// streamEvent is the union of the Messages API's server-sent events that
+// StreamMessage reads.
+type streamEvent struct {
+	Type         string           `json:"type"`
+	Message      *MessageResponse `json:"message"`
+	Index        int              `json:"index"`
+	ContentBlock *ContentBlock    `json:"content_block"`
+	Delta        struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		Thinking   string `json:"thinking"`
+		Signature  string `json:"signature"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *UsageInfo  `json:"usage"`
+	Error ErrorDetail `json:"error"`
+}
+
+// IncompleteError is returned by StreamMessage when the stream fails after
+// the answer has started. Partial holds what arrived, without a stop reason.
+type IncompleteError struct {
+	Partial *MessageResponse
+	Err     error
+}
+
+func (e *IncompleteError) Error() string {
+	return "answer incomplete: " + e.Err.Error()
+}
+
+func (e *IncompleteError) Unwrap() error {
+	return e.Err
+}
+
+// StreamMessage sends request with streaming enabled, calling onText with
+// each piece of answer text as it arrives, and returns the assembled
+// response once the stream ends. An error from onText aborts the stream.
+// Failures once the answer has started are returned as an *IncompleteError.
+func (s *AnthropicService) StreamMessage(ctx context.Context, apiKey string, request *MessageRequest, onText func(string) error) (*MessageResponse, error) {
+	streamed := *request
+	streamed.Stream = true
+	jsonData, err := json.Marshal(&streamed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := s.newRequest(ctx, "POST", "/v1/messages", apiKey, bytes.NewBuffer(jsonData), s.versionHeader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, NetworkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, BadResponse("failed to read response", err)
+		}
+		return nil, apiError(resp.StatusCode, resp.Header, body)
+	}
+	return ReadStream(resp.Body, onText)
+}
+
+// ReadStream reads a Messages API event stream from body the way
+// StreamMessage does, for providers that stream the same events.
+func ReadStream(body io.Reader, onText func(string) error) (*MessageResponse, error) {
+	var response *MessageResponse
+	fail := func(err error) (*MessageResponse, error) {
+		if response == nil {
+			return nil, err
+		}
+		return nil, &IncompleteError{Partial: response, Err: err}
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event streamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return fail(BadResponse("failed to parse stream event", err))
+		}
+
+		if response == nil && event.Type != "error" {
+			response = &MessageResponse{}
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				*response = *event.Message
+				response.Content = nil
+			}
+		case "content_block_start":
+			if event.ContentBlock != nil {
+				response.Content = append(response.Content, *event.ContentBlock)
+			}
+		case "content_block_delta":
+			if event.Index < 0 || event.Index >= len(response.Content) {
+				continue
+			}
+			block := &response.Content[event.Index]
+			switch event.Delta.Type {
+			case "text_delta":
+				block.Text = appendText(block.Text, event.Delta.Text)
+				if err := onText(event.Delta.Text); err != nil {
+					return nil, err
+				}
+			case "thinking_delta":
+				block.Thinking = appendText(block.Thinking, event.Delta.Thinking)
+			case "signature_delta":
+				block.Signature += event.Delta.Signature
+			}
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				response.StopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				response.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return response, nil
+		case "error":
+			return fail(StreamError(event.Error))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(BadResponse("failed to read stream", err))
+	}
+	return fail(BadResponse("stream ended early", nil))
+}
+
+func appendText(s *string, more string) *string {
+	joined := more
+	if s != nil {
+		joined = *s + more
+	}
+	return &joined
+}
@@ -0,0 +1,180 @@
+package vertex
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scope is the OAuth2 scope Vertex AI calls need.
+const scope = "https://www.googleapis.com/auth/cloud-platform"
+
+// credentials is the part of a service account key file Manto uses.
+type credentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+}
+
+// tokenSource trades assertions signed with a service account's key for
+// access tokens, caching each until shortly before it expires.
+type tokenSource struct {
+	email    string
+	keyID    string
+	key      *rsa.PrivateKey
+	tokenURL string
+	client   *http.Client
+	now      func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// loadTokenSource reads the service account key file at path.
+func loadTokenSource(path, tokenURL string, client *http.Client) (*tokenSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	if creds.Type != "service_account" || creds.ClientEmail == "" {
+		return nil, fmt.Errorf("credentials in %s are not a service account key", path)
+	}
+	key, err := parseKey(creds.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return &tokenSource{
+		email:    creds.ClientEmail,
+		keyID:    creds.PrivateKeyID,
+		key:      key,
+		tokenURL: tokenURL,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// parseKey decodes a PEM RSA key, in PKCS #8 as Google issues them or PKCS #1.
+func parseKey(raw string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(raw))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA key")
+	}
+	return key, nil
+}
+
+// Token returns a current access token, fetching a new one when the cached
+// one is missing or within a minute of expiring.
+func (t *tokenSource) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && t.now().Before(t.expiry.Add(-time.Minute)) {
+		return t.token, nil
+	}
+
+	assertion, err := t.assertion()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("network error fetching token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		if token.Error != "" {
+			return "", fmt.Errorf("token exchange failed: %s: %s", token.Error, token.ErrorDescription)
+		}
+		return "", fmt.Errorf("token exchange failed (status %d)", resp.StatusCode)
+	}
+	t.token = token.AccessToken
+	t.expiry = t.now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// Invalidate drops the cached token, e.g. after Vertex rejects it.
+func (t *tokenSource) Invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = ""
+}
+
+// assertion returns a JWT, signed with the service account's key, asking
+// for an hour-long token with the cloud-platform scope.
+func (t *tokenSource) assertion() (string, error) {
+	now := t.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": t.keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   t.email,
+		"scope": scope,
+		"aud":   t.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign assertion: %w", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
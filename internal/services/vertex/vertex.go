@@ -0,0 +1,230 @@
+// Package vertex is a provider for Claude on Google Cloud Vertex AI. Vertex
+// serves the Messages API's request and answer shapes under its own URL
+// scheme, with the model in the path and an OAuth2 access token for the
+// configured service account in place of the caller's key.
+package vertex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/egress"
+	"github.com/manto/manto-web/internal/services"
+	"github.com/manto/manto-web/internal/timing"
+)
+
+// Name is the provider's name in config and in requests.
+const Name = "vertex"
+
+// anthropicVersion is the API version Vertex expects in request bodies, in
+// place of the anthropic-version header.
+const anthropicVersion = "vertex-2023-10-16"
+
+// Service talks to Vertex AI as the configured service account.
+type Service struct {
+	config     *config.Config
+	httpClient *http.Client
+	tokens     *tokenSource
+}
+
+// New returns the provider cfg.Vertex describes, reading its service
+// account key file.
+func New(cfg *config.Config) (*Service, error) {
+	client := &http.Client{
+		Timeout:   cfg.Vertex.Timeout.Duration,
+		Transport: egress.New(cfg).Transport(),
+	}
+	tokens, err := loadTokenSource(cfg.Vertex.CredentialsFile, cfg.Vertex.TokenURL, client)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{config: cfg, httpClient: client, tokens: tokens}, nil
+}
+
+var (
+	_ services.Provider  = (*Service)(nil)
+	_ services.Describer = (*Service)(nil)
+)
+
+// Name implements services.Provider.
+func (s *Service) Name() string {
+	return Name
+}
+
+// DisplayName implements services.Describer.
+func (s *Service) DisplayName() string {
+	return "Vertex AI"
+}
+
+// KeyRequired implements services.Describer.
+func (s *Service) KeyRequired() bool {
+	return false
+}
+
+// KeyPrefix implements services.Describer.
+func (s *Service) KeyPrefix() string {
+	return ""
+}
+
+// ClientAPIKey returns whatever key the caller sent, if any. It isn't passed
+// on, but still tells callers' conversations and usage apart.
+func (s *Service) ClientAPIKey(r *http.Request) string {
+	return services.ClientKey(r, s.config.Anthropic.ClientKeyHeader)
+}
+
+// ValidateAPIKey accepts any key, or none.
+func (s *Service) ValidateAPIKey(apiKey string) bool {
+	return true
+}
+
+// Fingerprint implements services.Provider.
+func (s *Service) Fingerprint(apiKey string) string {
+	return services.FingerprintKey(apiKey, nil)
+}
+
+// GetModels lists VERTEX_MODELS in the Models API's shape. Vertex has no
+// call listing the Claude models a project may use.
+func (s *Service) GetModels(ctx context.Context, apiKey string) (string, error) {
+	data := make([]map[string]string, len(s.config.Vertex.Models))
+	for i, id := range s.config.Vertex.Models {
+		data[i] = map[string]string{"id": id, "type": "model", "display_name": id}
+	}
+	models, err := json.Marshal(map[string]interface{}{"data": data, "has_more": false})
+	if err != nil {
+		return "", err
+	}
+	return string(models), nil
+}
+
+// SendMessage implements services.Provider.
+func (s *Service) SendMessage(ctx context.Context, apiKey string, request *services.MessageRequest) (*services.MessageResponse, error) {
+	resp, err := s.do(ctx, request, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, services.BadResponse("failed to read response", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp.StatusCode, resp.Header, body)
+	}
+
+	var response services.MessageResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, services.BadResponse("failed to parse response", err)
+	}
+	return &response, nil
+}
+
+// StreamMessage implements services.Provider. Vertex streams the Messages
+// API's events, so failures once the answer has started are returned as a
+// *services.IncompleteError just as for Anthropic.
+func (s *Service) StreamMessage(ctx context.Context, apiKey string, request *services.MessageRequest, onText func(string) error) (*services.MessageResponse, error) {
+	resp, err := s.do(ctx, request, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, services.BadResponse("failed to read response", err)
+		}
+		return nil, apiError(resp.StatusCode, resp.Header, body)
+	}
+	return services.ReadStream(resp.Body, onText)
+}
+
+// do posts request to the model's rawPredict, or streamRawPredict, endpoint.
+// A rejected access token is dropped so the next request fetches another;
+// it says nothing about the caller's key.
+func (s *Service) do(ctx context.Context, request *services.MessageRequest, stream bool) (*http.Response, error) {
+	payload, err := requestBody(request, stream)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.modelURL(request.Model, stream), bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.config.Anthropic.UserAgent)
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+
+	start := time.Now()
+	resp, err := s.httpClient.Do(req)
+	timing.FromContext(ctx).Since("upstream_headers", start)
+	if err != nil {
+		return nil, services.NetworkError(err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		s.tokens.Invalidate()
+	}
+	return resp, nil
+}
+
+// modelURL returns model's endpoint under the project and region.
+func (s *Service) modelURL(model string, stream bool) string {
+	method := "rawPredict"
+	if stream {
+		method = "streamRawPredict"
+	}
+	v := s.config.Vertex
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:%s",
+		v.Endpoint(), url.PathEscape(v.ProjectID), url.PathEscape(v.Region), url.PathEscape(model), method)
+}
+
+// requestBody translates request: the model moves to the URL and the API
+// version into the body.
+func requestBody(request *services.MessageRequest, stream bool) ([]byte, error) {
+	sent := *request
+	sent.Stream = stream
+	jsonData, err := json.Marshal(&sent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(jsonData, &fields); err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	delete(fields, "model")
+	fields["anthropic_version"] = json.RawMessage(`"` + anthropicVersion + `"`)
+	return json.Marshal(fields)
+}
+
+// apiError turns a failed response into an error. Vertex passes Anthropic's
+// errors through, and reports its own, e.g. for a model not enabled in the
+// project, as Google API errors; both keep the message in error.message.
+// Vertex authenticates Manto's service account rather than the caller, so a
+// 401 or 403 is the deployment's problem and is answered as a 502.
+func apiError(status int, header http.Header, body []byte) error {
+	message := fmt.Sprintf("API error (status %d)", status)
+	var errorResp services.ErrorResponse
+	if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
+		message = errorResp.Error.Message
+	} else if status == http.StatusTooManyRequests {
+		message = "rate limit exceeded"
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		status = http.StatusBadGateway
+	}
+	return services.UpstreamError(status, header, message)
+}
@@ -0,0 +1,219 @@
+package vertex
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// writeCredentials writes a service account key file for key and returns
+// its path.
+func writeCredentials(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	data, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "manto@example-project.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write credentials: %v", err)
+	}
+	return path
+}
+
+func createTestConfig(baseURL, credentials string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Vertex.Enabled = true
+	cfg.Vertex.ProjectID = "example-project"
+	cfg.Vertex.Region = "us-east5"
+	cfg.Vertex.BaseURL = baseURL
+	cfg.Vertex.TokenURL = baseURL + "/token"
+	cfg.Vertex.CredentialsFile = credentials
+	cfg.Vertex.Models = []string{"claude-sonnet-4-5@20250929"}
+	return cfg
+}
+
+func TestVertexBehavior(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	var tokenRequests int
+	var assertion, path, auth string
+	var upstream map[string]interface{}
+	reject := false
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			r.ParseForm()
+			assertion = r.PostForm.Get("assertion")
+			w.Write([]byte(`{"access_token":"ya29.token","expires_in":3600,"token_type":"Bearer"}`))
+			return
+		}
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		upstream = nil
+		json.NewDecoder(r.Body).Decode(&upstream)
+		if reject {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":401,"message":"Request had invalid authentication credentials.","status":"UNAUTHENTICATED"}}`))
+			return
+		}
+		if strings.HasSuffix(path, ":streamRawPredict") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_vrtx_1\",\"type\":\"message\",\"role\":\"assistant\",\"model\":\"claude-sonnet-4-5\",\"content\":[],\"usage\":{\"input_tokens\":9,\"output_tokens\":1}}}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"lo\"}}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":2}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+			return
+		}
+		w.Write([]byte(`{"id":"msg_vrtx_1","type":"message","role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"text","text":"Hello"}],"stop_reason":"end_turn","usage":{"input_tokens":9,"output_tokens":1}}`))
+	}))
+	defer fake.Close()
+
+	service, err := New(createTestConfig(fake.URL, writeCredentials(t, key)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	request := &services.MessageRequest{
+		Model:     "claude-sonnet-4-5@20250929",
+		MaxTokens: 64,
+		Messages:  []services.Message{{Role: "user", Content: "Hi"}},
+	}
+
+	t.Run("sends the model in the URL and the version in the body", func(t *testing.T) {
+		response, err := service.SendMessage(context.Background(), "", request)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if path != "/v1/projects/example-project/locations/us-east5/publishers/anthropic/models/claude-sonnet-4-5@20250929:rawPredict" {
+			t.Errorf("unexpected path: %s", path)
+		}
+		if auth != "Bearer ya29.token" {
+			t.Errorf("expected the access token, got %q", auth)
+		}
+		if _, ok := upstream["model"]; ok || upstream["anthropic_version"] != anthropicVersion || upstream["max_tokens"] != float64(64) {
+			t.Errorf("unexpected body: %v", upstream)
+		}
+		if response.Text() != "Hello" || response.StopReason != "end_turn" || response.Usage.InputTokens != 9 {
+			t.Errorf("unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("signs the token assertion with the service account key", func(t *testing.T) {
+		parts := strings.Split(assertion, ".")
+		if len(parts) != 3 {
+			t.Fatalf("expected a JWT, got %q", assertion)
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Errorf("expected a valid signature: %v", err)
+		}
+		var claims map[string]interface{}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+		if claims["iss"] != "manto@example-project.iam.gserviceaccount.com" || claims["aud"] != fake.URL+"/token" || claims["scope"] != scope {
+			t.Errorf("unexpected claims: %v", claims)
+		}
+	})
+
+	t.Run("streams answer text", func(t *testing.T) {
+		var pieces []string
+		response, err := service.StreamMessage(context.Background(), "", request, func(text string) error {
+			pieces = append(pieces, text)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.HasSuffix(path, ":streamRawPredict") || upstream["stream"] != true {
+			t.Errorf("expected a streaming request, got %s %v", path, upstream)
+		}
+		if strings.Join(pieces, "|") != "Hel|lo" || response.Text() != "Hello" || response.Usage.OutputTokens != 2 {
+			t.Errorf("unexpected stream: %v %+v", pieces, response)
+		}
+	})
+
+	t.Run("reuses the access token until it is rejected", func(t *testing.T) {
+		if tokenRequests != 1 {
+			t.Errorf("expected one token exchange so far, got %d", tokenRequests)
+		}
+		reject = true
+		_, err := service.SendMessage(context.Background(), "", request)
+		reject = false
+		if err == nil || !strings.Contains(err.Error(), "invalid authentication credentials") {
+			t.Errorf("expected Google's error message, got %v", err)
+		}
+		if _, err := service.SendMessage(context.Background(), "", request); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tokenRequests != 2 {
+			t.Errorf("expected a fresh token after the rejection, got %d exchanges", tokenRequests)
+		}
+	})
+
+	t.Run("refreshes a token about to expire", func(t *testing.T) {
+		before := tokenRequests
+		service.tokens.now = func() time.Time { return time.Now().Add(59*time.Minute + 30*time.Second) }
+		defer func() { service.tokens.now = time.Now }()
+		if _, err := service.tokens.Token(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tokenRequests != before+1 {
+			t.Errorf("expected a refresh, got %d exchanges", tokenRequests-before)
+		}
+	})
+
+	t.Run("lists the configured models", func(t *testing.T) {
+		models, err := service.GetModels(context.Background(), "")
+		if err != nil || !strings.Contains(models, `"id":"claude-sonnet-4-5@20250929"`) {
+			t.Errorf("unexpected models: %s %v", models, err)
+		}
+	})
+}
+
+func TestCredentialsBehavior(t *testing.T) {
+	dir := t.TempDir()
+	notServiceAccount := filepath.Join(dir, "user.json")
+	os.WriteFile(notServiceAccount, []byte(`{"type":"authorized_user","client_id":"x"}`), 0o600)
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "rejects a missing file", path: filepath.Join(dir, "missing.json")},
+		{name: "rejects credentials that aren't a service account", path: notServiceAccount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(createTestConfig("https://us-east5-aiplatform.googleapis.com", tt.path)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Package session issues anonymous, signed browser sessions. A session is
+// just a random ID in an HttpOnly cookie, HMAC-signed so clients cannot forge
+// or pick one; it lets rate limits, usage counters and optionally stored
+// conversations follow a browser without any account.
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+type contextKey struct{}
+
+// Session is the anonymous session attached to a request. A nil *Session is
+// valid and records nothing, so callers never need to check for it.
+type Session struct {
+	ID string
+	// New is set when the session was issued by this request rather than
+	// presented by the client, i.e. the caller may not be keeping cookies.
+	New   bool
+	usage *Usage
+}
+
+// Usage counts what a session has consumed since the server started.
+type Usage struct {
+	Requests     atomic.Int64
+	InputTokens  atomic.Int64
+	OutputTokens atomic.Int64
+	lastSeen     atomic.Int64
+	// lastKey is the fingerprint of the API key last used, never the key.
+	lastKey atomic.Pointer[string]
+}
+
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+// RecordUsage adds one upstream request and its token counts, made with the
+// API key identified by keyFingerprint.
+func (s *Session) RecordUsage(keyFingerprint string, inputTokens, outputTokens int) {
+	if s == nil {
+		return
+	}
+	s.usage.lastKey.Store(&keyFingerprint)
+	s.usage.Requests.Add(1)
+	s.usage.InputTokens.Add(int64(inputTokens))
+	s.usage.OutputTokens.Add(int64(outputTokens))
+}
+
+// Manager signs and verifies session cookies and keeps per-session usage in
+// memory.
+type Manager struct {
+	secret     []byte
+	cookieName string
+	maxAge     time.Duration
+	secure     bool
+
+	mu    sync.Mutex
+	usage map[string]*Usage
+}
+
+// NewManager builds a manager from cfg. Without SESSION_SECRET a random key is
+// generated, so sessions reset whenever the process restarts.
+func NewManager(cfg *config.Config) *Manager {
+	secret := []byte(cfg.Session.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+		log.Printf("SESSION_SECRET not set; anonymous sessions will not survive a restart")
+	}
+	return &Manager{
+		secret:     secret,
+		cookieName: cfg.Session.CookieName,
+		maxAge:     cfg.Session.MaxAge.Duration,
+		secure:     !cfg.IsDevelopment(),
+		usage:      make(map[string]*Usage),
+	}
+}
+
+// Middleware attaches the caller's session to the request context, issuing a
+// new one when the cookie is missing, tampered with or expired.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := m.verify(r)
+		s := &Session{ID: id}
+		if ok {
+			s.usage = m.usageFor(id)
+		} else {
+			// Only sessions the client returns are tracked, so clients that
+			// ignore cookies don't grow the usage table on every request.
+			s.ID, s.New, s.usage = newID(), true, &Usage{}
+			http.SetCookie(w, m.cookie(s.ID, time.Now()))
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), s)))
+	})
+}
+
+// UsageHandler reports the calling session's ID and usage counters. The ID is
+// what operators list in RATE_LIMIT_EXEMPT_SESSIONS.
+func (m *Manager) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	s := FromContext(r.Context())
+	if s == nil {
+		http.Error(w, "no session", http.StatusNotFound)
+		return
+	}
+	var lastKey string
+	if key := s.usage.lastKey.Load(); key != nil {
+		lastKey = *key
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      s.ID,
+		"lastKey": lastKey,
+		"usage": map[string]int64{
+			"requests":     s.usage.Requests.Load(),
+			"inputTokens":  s.usage.InputTokens.Load(),
+			"outputTokens": s.usage.OutputTokens.Load(),
+		},
+	})
+}
+
+// Cleanup forgets usage for sessions not seen within the cookie lifetime.
+func (m *Manager) Cleanup() {
+	cutoff := time.Now().Add(-m.maxAge).Unix()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, u := range m.usage {
+		if u.lastSeen.Load() < cutoff {
+			delete(m.usage, id)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup hourly until stop is closed.
+func (m *Manager) StartCleanup(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Cleanup()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Manager) usageFor(id string) *Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.usage[id]
+	if !ok {
+		u = &Usage{}
+		m.usage[id] = u
+	}
+	u.lastSeen.Store(time.Now().Unix())
+	return u
+}
+
+// The cookie value is "<id>.<issued unix>.<signature>".
+func (m *Manager) cookie(id string, issued time.Time) *http.Cookie {
+	payload := id + "." + strconv.FormatInt(issued.Unix(), 10)
+	return &http.Cookie{
+		Name:     m.cookieName,
+		Value:    payload + "." + m.sign(payload),
+		Path:     "/",
+		MaxAge:   int(m.maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (m *Manager) verify(r *http.Request) (string, bool) {
+	c, err := r.Cookie(m.cookieName)
+	if err != nil {
+		return "", false
+	}
+	i := strings.LastIndex(c.Value, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, signature := c.Value[:i], c.Value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(m.sign(payload))) {
+		return "", false
+	}
+
+	id, issuedRaw, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+	issued, err := strconv.ParseInt(issuedRaw, 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > m.maxAge {
+		return "", false
+	}
+	return id, true
+}
+
+func (m *Manager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func createTestConfig() *config.Config {
+	cfg := &config.Config{Environment: "production"}
+	cfg.Session.Enabled = true
+	cfg.Session.Secret = strings.Repeat("s", 32)
+	cfg.Session.CookieName = "manto_session"
+	cfg.Session.MaxAge = config.Duration{Duration: time.Hour}
+	return cfg
+}
+
+func TestSessionMiddlewareBehavior(t *testing.T) {
+	manager := NewManager(createTestConfig())
+	var seen *Session
+	handler := manager.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+		seen.RecordUsage("sk-ant-…7890", 3, 5)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a session cookie on first visit, got %d", len(cookies))
+	}
+	issued := cookies[0]
+	if !issued.HttpOnly || !issued.Secure || issued.SameSite != http.SameSiteLaxMode {
+		t.Errorf("expected HttpOnly, Secure, SameSite=Lax cookie, got %+v", issued)
+	}
+	if !seen.New {
+		t.Error("expected first visit to be marked new")
+	}
+	firstID := seen.ID
+
+	tests := []struct {
+		name      string
+		value     string
+		expectNew bool
+	}{
+		{name: "valid cookie is reused", value: issued.Value},
+		{name: "tampered id is rejected", value: flipFirst(issued.Value), expectNew: true},
+		{name: "tampered signature is rejected", value: issued.Value[:len(issued.Value)-1] + "x", expectNew: true},
+		{name: "garbage is rejected", value: "nonsense", expectNew: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "manto_session", Value: tt.value})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if seen.New != tt.expectNew {
+				t.Errorf("expected new=%v, got %v", tt.expectNew, seen.New)
+			}
+			if !tt.expectNew && seen.ID != firstID {
+				t.Errorf("expected session %s, got %s", firstID, seen.ID)
+			}
+			if tt.expectNew && len(w.Result().Cookies()) != 1 {
+				t.Error("expected a replacement cookie")
+			}
+		})
+	}
+
+	t.Run("expired cookie is rejected", func(t *testing.T) {
+		old := manager.cookie(firstID, time.Now().Add(-2*time.Hour))
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(old)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if !seen.New {
+			t.Error("expected expired session to be replaced")
+		}
+	})
+
+	t.Run("usage is tracked per returning session", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/session", nil)
+		req.AddCookie(issued)
+		w := httptest.NewRecorder()
+		manager.Middleware(http.HandlerFunc(manager.UsageHandler)).ServeHTTP(w, req)
+
+		body := w.Body.String()
+		if !strings.Contains(body, `"requests":1`) || !strings.Contains(body, `"outputTokens":5`) || !strings.Contains(body, `"lastKey":"sk-ant-…7890"`) {
+			t.Errorf("expected usage from the earlier returning request, got %s", body)
+		}
+	})
+}
+
+// flipFirst changes the first character of s, whatever it is.
+func flipFirst(s string) string {
+	if s[0] == 'f' {
+		return "e" + s[1:]
+	}
+	return "f" + s[1:]
+}
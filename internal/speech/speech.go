@@ -0,0 +1,116 @@
+// Package speech talks to speech services. For speech-to-text, the OpenAI
+// transcription API and whisper.cpp's bundled server both take a multipart
+// upload and answer {"text": ...}. For text-to-speech, the OpenAI speech API
+// and ElevenLabs both take JSON and stream the audio back.
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// Providers.
+const (
+	ProviderOpenAI     = "openai"
+	ProviderWhisperCPP = "whispercpp"
+)
+
+// defaultURLs are used when no URL is configured.
+var defaultURLs = map[string]string{
+	ProviderOpenAI:     "https://api.openai.com/v1",
+	ProviderWhisperCPP: "http://127.0.0.1:8080",
+}
+
+// Audio is one recording to transcribe. Language is an optional ISO-639-1
+// hint.
+type Audio struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	Language    string
+}
+
+// Transcriber sends recordings to a speech-to-text provider.
+type Transcriber struct {
+	provider string
+	url      string
+	apiKey   string
+	model    string
+	client   *http.Client
+}
+
+// NewTranscriber returns a Transcriber for provider, at baseURL or the
+// provider's usual address when it is empty.
+func NewTranscriber(provider, baseURL, apiKey, model string, client *http.Client) *Transcriber {
+	if baseURL == "" {
+		baseURL = defaultURLs[provider]
+	}
+	return &Transcriber{provider: provider, url: strings.TrimRight(baseURL, "/"), apiKey: apiKey, model: model, client: client}
+}
+
+// Transcribe returns the text spoken in audio.
+func (t *Transcriber) Transcribe(ctx context.Context, audio Audio) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, audio.Name))
+	header.Set("Content-Type", audio.ContentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(audio.Data); err != nil {
+		return "", err
+	}
+	fields := map[string]string{"response_format": "json"}
+	endpoint := t.url + "/inference"
+	if t.provider == ProviderOpenAI {
+		fields["model"] = t.model
+		endpoint = t.url + "/audio/transcriptions"
+	}
+	if audio.Language != "" {
+		fields["language"] = audio.Language
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return "", err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("transcription API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid transcription API response: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
@@ -0,0 +1,138 @@
+package speech
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTranscribeBehavior(t *testing.T) {
+	tests := []struct {
+		name          string
+		provider      string
+		language      string
+		status        int
+		response      string
+		expectedPath  string
+		expectedModel string
+		expectedText  string
+		expectedErr   string
+	}{
+		{name: "openai transcription", provider: ProviderOpenAI, status: http.StatusOK, response: `{"text":" hello there "}`, expectedPath: "/audio/transcriptions", expectedModel: "whisper-1", expectedText: "hello there"},
+		{name: "whisper.cpp inference", provider: ProviderWhisperCPP, language: "es", status: http.StatusOK, response: `{"text":"hola"}`, expectedPath: "/inference", expectedText: "hola"},
+		{name: "upstream failure", provider: ProviderOpenAI, status: http.StatusUnauthorized, response: `bad key`, expectedPath: "/audio/transcriptions", expectedModel: "whisper-1", expectedErr: "transcription API returned 401: bad key"},
+		{name: "invalid response", provider: ProviderOpenAI, status: http.StatusOK, response: `text`, expectedPath: "/audio/transcriptions", expectedModel: "whisper-1", expectedErr: "invalid transcription API response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.expectedPath {
+					t.Errorf("expected path %s, got %s", tt.expectedPath, r.URL.Path)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer key" {
+					t.Errorf("expected bearer key, got %q", got)
+				}
+				file, header, err := r.FormFile("file")
+				if err != nil {
+					t.Fatalf("expected a file part: %v", err)
+				}
+				data, _ := io.ReadAll(file)
+				if string(data) != "audio" || header.Filename != "clip.webm" || header.Header.Get("Content-Type") != "audio/webm" {
+					t.Errorf("unexpected file part %q %q %q", data, header.Filename, header.Header.Get("Content-Type"))
+				}
+				if got := r.FormValue("model"); got != tt.expectedModel {
+					t.Errorf("expected model %q, got %q", tt.expectedModel, got)
+				}
+				if got := r.FormValue("language"); got != tt.language {
+					t.Errorf("expected language %q, got %q", tt.language, got)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			transcriber := NewTranscriber(tt.provider, server.URL+"/", "key", "whisper-1", server.Client())
+			text, err := transcriber.Transcribe(context.Background(), Audio{Name: "clip.webm", ContentType: "audio/webm", Data: []byte("audio"), Language: tt.language})
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if text != tt.expectedText {
+				t.Errorf("expected %q, got %q", tt.expectedText, text)
+			}
+		})
+	}
+}
+
+func TestSynthesizeBehavior(t *testing.T) {
+	tests := []struct {
+		name                string
+		provider            string
+		voice               string
+		format              string
+		status              int
+		contentType         string
+		expectedPath        string
+		expectedAuth        string
+		expectedBody        string
+		expectedContentType string
+		expectedErr         string
+	}{
+		{name: "openai speech", provider: ProviderOpenAI, format: "opus", status: http.StatusOK, contentType: "audio/ogg", expectedPath: "/audio/speech", expectedAuth: "Bearer key", expectedBody: `{"input":"hi","model":"tts-1","response_format":"opus","voice":"alloy"}`, expectedContentType: "audio/ogg"},
+		{name: "requested voice", provider: ProviderOpenAI, voice: "nova", format: "mp3", status: http.StatusOK, contentType: "audio/mpeg", expectedPath: "/audio/speech", expectedAuth: "Bearer key", expectedBody: `{"input":"hi","model":"tts-1","response_format":"mp3","voice":"nova"}`, expectedContentType: "audio/mpeg"},
+		{name: "mislabelled audio", provider: ProviderOpenAI, format: "wav", status: http.StatusOK, contentType: "application/octet-stream", expectedPath: "/audio/speech", expectedAuth: "Bearer key", expectedBody: `{"input":"hi","model":"tts-1","response_format":"wav","voice":"alloy"}`, expectedContentType: "audio/wav"},
+		{name: "elevenlabs stream", provider: ProviderElevenLabs, voice: "voice 1", format: "mp3", status: http.StatusOK, contentType: "audio/mpeg", expectedPath: "/v1/text-to-speech/voice 1/stream", expectedAuth: "key", expectedBody: `{"model_id":"eleven_multilingual_v2","text":"hi"}`, expectedContentType: "audio/mpeg"},
+		{name: "upstream failure", provider: ProviderOpenAI, format: "mp3", status: http.StatusTooManyRequests, expectedPath: "/audio/speech", expectedAuth: "Bearer key", expectedBody: `{"input":"hi","model":"tts-1","response_format":"mp3","voice":"alloy"}`, expectedErr: "speech API returned 429"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.expectedPath {
+					t.Errorf("expected path %s, got %s", tt.expectedPath, r.URL.Path)
+				}
+				auth := r.Header.Get("Authorization")
+				if tt.provider == ProviderElevenLabs {
+					auth = r.Header.Get("xi-api-key")
+				}
+				if auth != tt.expectedAuth {
+					t.Errorf("expected auth %q, got %q", tt.expectedAuth, auth)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != tt.expectedBody {
+					t.Errorf("expected body %s, got %s", tt.expectedBody, body)
+				}
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte("audio"))
+			}))
+			defer server.Close()
+
+			synthesizer := NewSynthesizer(tt.provider, server.URL, "key", "", "", server.Client())
+			audio, err := synthesizer.Synthesize(context.Background(), "hi", tt.voice, tt.format)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer audio.Body.Close()
+			data, _ := io.ReadAll(audio.Body)
+			if string(data) != "audio" || audio.ContentType != tt.expectedContentType {
+				t.Errorf("expected audio as %s, got %q as %s", tt.expectedContentType, data, audio.ContentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProviderElevenLabs is the ElevenLabs text-to-speech API.
+const ProviderElevenLabs = "elevenlabs"
+
+// ttsDefaults are each text-to-speech provider's address, model and voice.
+var ttsDefaults = map[string]struct{ url, model, voice string }{
+	ProviderOpenAI:     {"https://api.openai.com/v1", "tts-1", "alloy"},
+	ProviderElevenLabs: {"https://api.elevenlabs.io", "eleven_multilingual_v2", ""},
+}
+
+// ContentTypes maps the audio formats synthesizers produce to their media
+// types.
+var ContentTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"opus": "audio/ogg",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+}
+
+// Speech is synthesized audio, read from Body as the provider produces it.
+type Speech struct {
+	Body        io.ReadCloser
+	ContentType string
+}
+
+// Synthesizer sends text to a text-to-speech provider.
+type Synthesizer struct {
+	provider string
+	url      string
+	apiKey   string
+	model    string
+	voice    string
+	client   *http.Client
+}
+
+// NewSynthesizer returns a Synthesizer for provider. Empty settings take the
+// provider's defaults.
+func NewSynthesizer(provider, baseURL, apiKey, model, voice string, client *http.Client) *Synthesizer {
+	defaults := ttsDefaults[provider]
+	if baseURL == "" {
+		baseURL = defaults.url
+	}
+	if model == "" {
+		model = defaults.model
+	}
+	if voice == "" {
+		voice = defaults.voice
+	}
+	return &Synthesizer{provider: provider, url: strings.TrimRight(baseURL, "/"), apiKey: apiKey, model: model, voice: voice, client: client}
+}
+
+// Synthesize starts reading text aloud in format, with voice or the
+// configured one. The caller closes the returned Body.
+func (s *Synthesizer) Synthesize(ctx context.Context, text, voice, format string) (*Speech, error) {
+	if voice == "" {
+		voice = s.voice
+	}
+	var endpoint string
+	var payload map[string]string
+	if s.provider == ProviderElevenLabs {
+		endpoint = s.url + "/v1/text-to-speech/" + url.PathEscape(voice) + "/stream?output_format=mp3_44100_128"
+		payload = map[string]string{"text": text, "model_id": s.model}
+	} else {
+		endpoint = s.url + "/audio/speech"
+		payload = map[string]string{"model": s.model, "input": text, "voice": voice, "response_format": format}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		if s.provider == ProviderElevenLabs {
+			req.Header.Set("xi-api-key", s.apiKey)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+s.apiKey)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("speech API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+
+	// Providers label their audio inconsistently, so anything that isn't an
+	// audio type is replaced with the one the format implies.
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || !strings.HasPrefix(mediaType, "audio/") {
+		contentType = ContentTypes[format]
+	}
+	return &Speech{Body: resp.Body, ContentType: contentType}, nil
+}
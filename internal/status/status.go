@@ -0,0 +1,134 @@
+// Package status tracks whether the upstream is usable, so the UI can warn
+// before someone writes a long prompt that is bound to fail. Maintenance is
+// declared by the operator; outages and quota exhaustion are inferred from a
+// run of failed upstream responses and clear on the next success.
+package status
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+const (
+	StateMaintenance = "maintenance"
+	StateOutage      = "outage"
+	StateQuota       = "quota"
+)
+
+// Status describes a degraded service. Message is the operator's text, for
+// maintenance; the UI words the other states itself.
+type Status struct {
+	State      string    `json:"state"`
+	Message    string    `json:"message,omitempty"`
+	Since      time.Time `json:"since"`
+	RetryAfter int       `json:"retryAfter,omitempty"`
+}
+
+// Tracker follows upstream responses. A nil *Tracker reports no status.
+type Tracker struct {
+	threshold int
+	now       func() time.Time
+
+	mu          sync.Mutex
+	maintenance *Status
+	failing     string
+	failures    int
+	degraded    *Status
+	retryUntil  time.Time
+}
+
+// NewTracker returns a tracker starting in the maintenance state cfg
+// declares.
+func NewTracker(cfg config.StatusConfig) *Tracker {
+	t := &Tracker{threshold: cfg.FailureThreshold, now: time.Now}
+	t.SetMaintenance(cfg.Maintenance, cfg.MaintenanceMessage)
+	return t
+}
+
+// SetMaintenance turns maintenance mode on or off.
+func (t *Tracker) SetMaintenance(enabled bool, message string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case !enabled:
+		t.maintenance = nil
+	case t.maintenance == nil:
+		t.maintenance = &Status{State: StateMaintenance, Message: message, Since: t.now().UTC()}
+	default:
+		t.maintenance.Message = message
+	}
+}
+
+// Observe records the outcome of an upstream request. Network errors and 5xx
+// responses count towards an outage, 429s towards quota exhaustion, and
+// anything else, including client errors such as a bad key, shows the
+// upstream is working. Requests the client abandoned say nothing either way.
+func (t *Tracker) Observe(resp *http.Response, err error) {
+	if t == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	switch {
+	case err != nil, resp.StatusCode >= 500:
+		t.fail(StateOutage, 0)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		t.fail(StateQuota, retryAfter)
+	default:
+		t.mu.Lock()
+		t.failing, t.failures, t.degraded = "", 0, nil
+		t.mu.Unlock()
+	}
+}
+
+func (t *Tracker) fail(state string, retryAfter int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failing != state {
+		t.failing, t.failures = state, 0
+	}
+	t.failures++
+	if t.failures < t.threshold {
+		return
+	}
+	now := t.now()
+	if t.degraded == nil || t.degraded.State != state {
+		t.degraded = &Status{State: state, Since: now.UTC()}
+	}
+	t.degraded.RetryAfter = retryAfter
+	t.retryUntil = time.Time{}
+	if retryAfter > 0 {
+		t.retryUntil = now.Add(time.Duration(retryAfter) * time.Second)
+	}
+}
+
+// Current returns the status to show, or nil when all is well. Maintenance
+// takes precedence; quota exhaustion lapses once its Retry-After has passed.
+func (t *Tracker) Current() *Status {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.maintenance != nil {
+		s := *t.maintenance
+		return &s
+	}
+	if t.degraded == nil {
+		return nil
+	}
+	if !t.retryUntil.IsZero() && !t.now().Before(t.retryUntil) {
+		t.failing, t.failures, t.degraded = "", 0, nil
+		return nil
+	}
+	s := *t.degraded
+	return &s
+}
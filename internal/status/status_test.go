@@ -0,0 +1,85 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func response(code int, retryAfter string) *http.Response {
+	resp := &http.Response{StatusCode: code, Header: http.Header{}}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestTrackerBehavior(t *testing.T) {
+	type outcome struct {
+		resp *http.Response
+		err  error
+	}
+	failure := outcome{err: errors.New("connection refused")}
+
+	tests := []struct {
+		name     string
+		outcomes []outcome
+		advance  time.Duration
+		expected string
+	}{
+		{name: "healthy by default"},
+		{name: "failures below the threshold pass", outcomes: []outcome{failure, failure}},
+		{name: "network errors and 5xx make an outage", outcomes: []outcome{failure, {resp: response(529, "")}, failure}, expected: StateOutage},
+		{name: "a success clears the outage", outcomes: []outcome{failure, failure, failure, {resp: response(200, "")}}},
+		{name: "client errors count as success", outcomes: []outcome{failure, failure, {resp: response(401, "")}, failure}},
+		{name: "abandoned requests are ignored", outcomes: []outcome{failure, failure, {err: context.Canceled}, failure}, expected: StateOutage},
+		{name: "429s make quota exhaustion", outcomes: []outcome{{resp: response(429, "")}, {resp: response(429, "")}, {resp: response(429, "60")}}, expected: StateQuota},
+		{name: "quota lapses after Retry-After", outcomes: []outcome{{resp: response(429, "")}, {resp: response(429, "")}, {resp: response(429, "60")}}, advance: time.Minute},
+		{name: "mixed failures restart the count", outcomes: []outcome{failure, failure, {resp: response(429, "")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			tracker := NewTracker(config.StatusConfig{FailureThreshold: 3})
+			tracker.now = func() time.Time { return now }
+
+			for _, o := range tt.outcomes {
+				tracker.Observe(o.resp, o.err)
+			}
+			now = now.Add(tt.advance)
+
+			got := tracker.Current()
+			switch {
+			case tt.expected == "" && got != nil:
+				t.Errorf("expected no status, got %+v", got)
+			case tt.expected != "" && (got == nil || got.State != tt.expected):
+				t.Errorf("expected %s, got %+v", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("maintenance takes precedence", func(t *testing.T) {
+		tracker := NewTracker(config.StatusConfig{FailureThreshold: 1, Maintenance: true, MaintenanceMessage: "Back at 3pm"})
+		tracker.Observe(nil, errors.New("timeout"))
+		if got := tracker.Current(); got == nil || got.State != StateMaintenance || got.Message != "Back at 3pm" {
+			t.Errorf("expected maintenance, got %+v", got)
+		}
+		tracker.SetMaintenance(false, "")
+		if got := tracker.Current(); got == nil || got.State != StateOutage {
+			t.Errorf("expected the outage once maintenance ends, got %+v", got)
+		}
+	})
+
+	t.Run("nil trackers report nothing", func(t *testing.T) {
+		var tracker *Tracker
+		tracker.Observe(nil, errors.New("timeout"))
+		if tracker.Current() != nil {
+			t.Error("expected a nil tracker to report no status")
+		}
+	})
+}
@@ -0,0 +1,193 @@
+// Package summarize condenses documents too long for one request: the text is
+// split into chunks, each chunk is summarized, and the summaries are combined
+// into one, over several rounds if they are still too long together.
+package summarize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Stages reported in Progress.
+const (
+	StageChunks  = "chunks"
+	StageCombine = "combine"
+)
+
+// Progress reports how far a stage has got. Round counts the combining
+// rounds, from 1, for summaries that needed more than one.
+type Progress struct {
+	Stage     string `json:"stage"`
+	Round     int    `json:"round,omitempty"`
+	Completed int    `json:"completed"`
+	Total     int    `json:"total"`
+}
+
+// SendFunc asks the model for prompt's answer.
+type SendFunc func(ctx context.Context, prompt string) (string, error)
+
+// Summarizer runs the summaries. Count estimates the tokens in a text.
+type Summarizer struct {
+	ChunkTokens  int
+	Concurrency  int
+	Instructions string
+	Count        func(string) int
+	Send         SendFunc
+}
+
+// Summarize returns a summary of text, calling progress (never concurrently)
+// as each request finishes.
+func (s *Summarizer) Summarize(ctx context.Context, text string, progress func(Progress)) (string, error) {
+	chunks := Split(text, s.ChunkTokens, s.Count)
+	if len(chunks) == 1 {
+		summary, err := s.run(ctx, StageChunks, 0, []string{s.prompt(wholePrompt, chunks[0])}, progress)
+		if err != nil {
+			return "", err
+		}
+		return summary[0], nil
+	}
+
+	prompts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		prompts[i] = s.prompt(fmt.Sprintf(chunkPrompt, i+1, len(chunks)), chunk)
+	}
+	summaries, err := s.run(ctx, StageChunks, 0, prompts, progress)
+	if err != nil {
+		return "", err
+	}
+
+	for round := 1; ; round++ {
+		groups := Split(strings.Join(summaries, "\n\n"), s.ChunkTokens, s.Count)
+		if len(groups) > 1 && len(groups) >= len(summaries) {
+			// Each summary fills a chunk on its own, so combining them would
+			// never get down to one.
+			return strings.Join(summaries, "\n\n"), nil
+		}
+		prompts = make([]string, len(groups))
+		for i, group := range groups {
+			prompts[i] = s.prompt(combinePrompt, group)
+		}
+		if summaries, err = s.run(ctx, StageCombine, round, prompts, progress); err != nil {
+			return "", err
+		}
+		if len(summaries) == 1 {
+			return summaries[0], nil
+		}
+	}
+}
+
+const (
+	wholePrompt   = "Summarize the following document. Keep the key facts, names and figures."
+	chunkPrompt   = "This is part %d of %d of a longer document. Summarize it, keeping the key facts, names and figures, so the parts' summaries can be combined later."
+	combinePrompt = "These are summaries of consecutive parts of one document, in order. Combine them into a single coherent summary of the whole document."
+)
+
+func (s *Summarizer) prompt(task, text string) string {
+	if s.Instructions != "" {
+		task += "\n\nFollow these instructions for the summary: " + s.Instructions
+	}
+	return task + "\n\n<document>\n" + text + "\n</document>"
+}
+
+// run sends prompts Concurrency at a time and returns the answers in order.
+// The first failure cancels the rest.
+func (s *Summarizer) run(ctx context.Context, stage string, round int, prompts []string, progress func(Progress)) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	answers := make([]string, len(prompts))
+	concurrency := max(s.Concurrency, 1)
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	completed := 0
+	for i, prompt := range prompts {
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			answer, err := s.Send(ctx, prompt)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			answers[i] = strings.TrimSpace(answer)
+			completed++
+			if progress != nil && firstErr == nil {
+				progress(Progress{Stage: stage, Round: round, Completed: completed, Total: len(prompts)})
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+// Split breaks text into chunks of at most maxTokens by count, preferring to
+// break between paragraphs, then between lines, then between words. A single
+// word over the limit is cut.
+func Split(text string, maxTokens int, count func(string) int) []string {
+	text = strings.TrimSpace(text)
+	if count(text) <= maxTokens {
+		return []string{text}
+	}
+	var chunks []string
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		parts := strings.Split(text, sep)
+		if len(parts) < 2 {
+			continue
+		}
+		current := ""
+		for _, part := range parts {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			candidate := part
+			if current != "" {
+				candidate = current + sep + part
+			}
+			if count(candidate) <= maxTokens {
+				current = candidate
+				continue
+			}
+			if current != "" {
+				chunks = append(chunks, strings.TrimSpace(current))
+			}
+			if count(part) <= maxTokens {
+				current = part
+				continue
+			}
+			chunks = append(chunks, Split(part, maxTokens, count)...)
+			current = ""
+		}
+		if strings.TrimSpace(current) != "" {
+			chunks = append(chunks, strings.TrimSpace(current))
+		}
+		return chunks
+	}
+	// One long word: cut it in half until the pieces fit.
+	runes := []rune(text)
+	half := len(runes) / 2
+	if half == 0 {
+		return []string{text}
+	}
+	return append(Split(string(runes[:half]), maxTokens, count), Split(string(runes[half:]), maxTokens, count)...)
+}
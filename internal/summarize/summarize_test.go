@@ -0,0 +1,97 @@
+package summarize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// words counts a token per word.
+func words(s string) int {
+	return len(strings.Fields(s))
+}
+
+func TestSplitBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		max      int
+		expected []string
+	}{
+		{name: "short text is one chunk", text: "  a b c ", max: 5, expected: []string{"a b c"}},
+		{name: "paragraphs are packed together", text: "a b\n\nc d\n\ne f", max: 4, expected: []string{"a b\n\nc d", "e f"}},
+		{name: "long paragraphs break between lines", text: "a b\nc d\ne f\n\ng", max: 4, expected: []string{"a b\nc d", "e f", "g"}},
+		{name: "long lines break between words", text: "a b c d e", max: 2, expected: []string{"a b", "c d", "e"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Split(tt.text, tt.max, words)
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("long words are cut", func(t *testing.T) {
+		got := Split("abcdefgh", 1, func(s string) int { return len(s) / 3 })
+		if strings.Join(got, "") != "abcdefgh" || len(got) < 2 {
+			t.Errorf("expected the word cut into pieces, got %q", got)
+		}
+	})
+}
+
+func TestSummarizeBehavior(t *testing.T) {
+	tests := []struct {
+		name           string
+		text           string
+		fail           string
+		expected       string
+		expectedStages string
+		expectedCalls  int32
+	}{
+		{name: "short documents take one request", text: "one two", expected: "summary", expectedStages: "chunks 1/1", expectedCalls: 1},
+		{name: "long documents are summarized in parts and combined", text: "a b c\n\nd e f\n\ng h i", expected: "summary", expectedStages: "chunks 1/3,chunks 2/3,chunks 3/3,combine 1/1", expectedCalls: 4},
+		{name: "failures stop the summary", text: "a b c\n\nd e f", fail: "part 2", expectedCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			s := &Summarizer{
+				ChunkTokens:  4,
+				Concurrency:  1,
+				Instructions: "be brief",
+				Count:        words,
+				Send: func(ctx context.Context, prompt string) (string, error) {
+					calls.Add(1)
+					if !strings.Contains(prompt, "be brief") {
+						t.Errorf("expected the instructions in %q", prompt)
+					}
+					if tt.fail != "" && strings.Contains(prompt, tt.fail) {
+						return "", errors.New("upstream failed")
+					}
+					return " summary ", nil
+				},
+			}
+
+			var stages []string
+			summary, err := s.Summarize(context.Background(), tt.text, func(p Progress) {
+				stages = append(stages, fmt.Sprintf("%s %d/%d", p.Stage, p.Completed, p.Total))
+			})
+			if tt.fail != "" {
+				if err == nil {
+					t.Error("expected the failure to be returned")
+				}
+			} else if err != nil || summary != tt.expected || strings.Join(stages, ",") != tt.expectedStages {
+				t.Errorf("expected %q after %s, got %q after %v (%v)", tt.expected, tt.expectedStages, summary, stages, err)
+			}
+			if calls.Load() != tt.expectedCalls {
+				t.Errorf("expected %d requests, got %d", tt.expectedCalls, calls.Load())
+			}
+		})
+	}
+}
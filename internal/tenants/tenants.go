@@ -0,0 +1,352 @@
+// Package tenants hosts several isolated workspaces in one process, for
+// agencies running Manto for more than one client. Each request names its
+// tenant by header or by a /t/{tenant} path prefix; the tenant then brings
+// its own branding, model allow-list, system prompt and token cap, a usage
+// quota, and a separate namespace for stored conversations.
+package tenants
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+)
+
+// PathPrefix starts the path of requests naming their tenant in path mode.
+const PathPrefix = "/t/"
+
+// ErrQuotaExceeded is returned by Admit once a tenant has used its quota for
+// the current window.
+var ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Branding overrides the deployment's BRAND_* settings. Empty fields keep
+// them.
+type Branding struct {
+	Name            string `json:"name,omitempty"`
+	ShortName       string `json:"shortName,omitempty"`
+	Description     string `json:"description,omitempty"`
+	ThemeColor      string `json:"themeColor,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+}
+
+// Quota limits the requests and tokens (input and output together) a tenant
+// may use per Window, "24h" by default. Zero means unlimited.
+type Quota struct {
+	Requests int64  `json:"requests,omitempty"`
+	Tokens   int64  `json:"tokens,omitempty"`
+	Window   string `json:"window,omitempty"`
+
+	window time.Duration
+}
+
+// Tenant is one workspace, as read from TENANTS_FILE. An empty Models allows
+// every model; System replaces the deployment's default system prompt; a
+// positive MaxTokens caps max_tokens. QueueWeight is how many queued requests
+// each of the tenant's clients gets served per turn when upstream slots are
+// scarce, 1 by default.
+type Tenant struct {
+	ID          string   `json:"id"`
+	Branding    Branding `json:"branding"`
+	Models      []string `json:"models,omitempty"`
+	System      string   `json:"system,omitempty"`
+	MaxTokens   int      `json:"maxTokens,omitempty"`
+	QueueWeight int      `json:"queueWeight,omitempty"`
+	Quota       Quota    `json:"quota"`
+
+	requests     atomic.Int64
+	inputTokens  atomic.Int64
+	outputTokens atomic.Int64
+
+	mu             sync.Mutex
+	windowStart    time.Time
+	windowRequests int64
+	windowTokens   int64
+}
+
+// Registry is the set of tenants and how requests name them.
+type Registry struct {
+	mode     string
+	header   string
+	required bool
+	now      func() time.Time
+
+	tenants []*Tenant
+	byID    map[string]*Tenant
+}
+
+// Load reads the tenants cfg.File defines. An empty file means a single
+// tenant deployment and returns nil, which is safe to use.
+func Load(cfg config.TenantsConfig) (*Registry, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants: %w", err)
+	}
+	var file struct {
+		Tenants []*Tenant `json:"tenants"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants: %w", err)
+	}
+
+	reg := &Registry{
+		mode:     cfg.Mode,
+		header:   cfg.Header,
+		required: cfg.Required,
+		now:      time.Now,
+		tenants:  file.Tenants,
+		byID:     make(map[string]*Tenant, len(file.Tenants)),
+	}
+	if len(file.Tenants) == 0 {
+		return nil, errors.New("invalid tenants: at least one tenant is required")
+	}
+	for _, t := range file.Tenants {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		if reg.byID[t.ID] != nil {
+			return nil, fmt.Errorf("invalid tenants: %s is defined twice", t.ID)
+		}
+		reg.byID[t.ID] = t
+	}
+	return reg, nil
+}
+
+func (t *Tenant) validate() error {
+	if !validID.MatchString(t.ID) {
+		return fmt.Errorf("invalid tenant ID %q (must be lowercase letters, digits and dashes)", t.ID)
+	}
+	if t.MaxTokens < 0 || t.QueueWeight < 0 || t.Quota.Requests < 0 || t.Quota.Tokens < 0 {
+		return fmt.Errorf("invalid tenant %s: limits must not be negative", t.ID)
+	}
+	t.Quota.window = 24 * time.Hour
+	if t.Quota.Window != "" {
+		window, err := time.ParseDuration(t.Quota.Window)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid tenant %s: quota window %q (must be a positive duration such as 24h)", t.ID, t.Quota.Window)
+		}
+		t.Quota.window = window
+	}
+	return nil
+}
+
+// Middleware attaches the request's tenant to its context. In path mode the
+// /t/{tenant} prefix is removed first, so routes match as usual. Requests
+// naming an unknown tenant, or none when one is required, get 404.
+func (reg *Registry) Middleware(next http.Handler) http.Handler {
+	if reg == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id string
+		if reg.mode == "path" {
+			rest, ok := strings.CutPrefix(r.URL.Path, PathPrefix)
+			if ok {
+				var path string
+				id, path, _ = strings.Cut(rest, "/")
+				if path == "" && !strings.HasSuffix(rest, "/") {
+					// Relative links in the UI need the trailing slash.
+					http.Redirect(w, r, PathPrefix+id+"/", http.StatusPermanentRedirect)
+					return
+				}
+				r.URL.Path = "/" + path
+				r.URL.RawPath = ""
+			}
+		} else {
+			w.Header().Add("Vary", reg.header)
+			id = r.Header.Get(reg.header)
+		}
+
+		if id == "" && !reg.required {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t := reg.byID[id]
+		if t == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"Unknown tenant"}` + "\n"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+	})
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the request's tenant. A nil *Tenant changes, limits
+// and records nothing, so callers never need to check for it.
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(contextKey{}).(*Tenant)
+	return t
+}
+
+// Allows reports whether the tenant may use model.
+func (t *Tenant) Allows(model string) bool {
+	return t == nil || len(t.Models) == 0 || slices.Contains(t.Models, model)
+}
+
+// Restricted reports whether the tenant limits the models, max_tokens or
+// usage of its clients.
+func (t *Tenant) Restricted() bool {
+	return t != nil && (len(t.Models) > 0 || t.MaxTokens > 0 || t.Quota.Requests > 0 || t.Quota.Tokens > 0)
+}
+
+// Apply gives the request the tenant's system prompt, unless the client
+// sent one, and holds max_tokens to the tenant's cap. Call it before
+// applying defaults.
+func (t *Tenant) Apply(request *services.MessageRequest) {
+	if t == nil {
+		return
+	}
+	if request.System == nil && t.System != "" {
+		system := t.System
+		request.System = &system
+	}
+	if t.MaxTokens > 0 && (request.MaxTokens == 0 || request.MaxTokens > t.MaxTokens) {
+		request.MaxTokens = t.MaxTokens
+	}
+}
+
+// Admit counts a request against the quota, or returns ErrQuotaExceeded if
+// the current window's requests or tokens are used up.
+func (t *Tenant) Admit(now time.Time) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.roll(now)
+	if t.Quota.Requests > 0 && t.windowRequests >= t.Quota.Requests ||
+		t.Quota.Tokens > 0 && t.windowTokens >= t.Quota.Tokens {
+		return ErrQuotaExceeded
+	}
+	t.windowRequests++
+	t.requests.Add(1)
+	return nil
+}
+
+// RecordUsage adds an answered request's tokens to the tenant's usage.
+func (t *Tenant) RecordUsage(inputTokens, outputTokens int) {
+	if t == nil {
+		return
+	}
+	t.inputTokens.Add(int64(inputTokens))
+	t.outputTokens.Add(int64(outputTokens))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.windowTokens += int64(inputTokens + outputTokens)
+}
+
+// roll starts a new quota window once the current one has passed. Callers
+// must hold t.mu.
+func (t *Tenant) roll(now time.Time) {
+	if now.Sub(t.windowStart) >= t.Quota.window {
+		t.windowStart, t.windowRequests, t.windowTokens = now, 0, 0
+	}
+}
+
+// Owner scopes a conversation owner to the tenant, so one tenant never sees
+// another's conversations even for the same API key.
+func (t *Tenant) Owner(owner string) string {
+	if t == nil {
+		return owner
+	}
+	return t.ID + ":" + owner
+}
+
+// Weight is the tenant's queue weight; a nil tenant has the default of 1.
+func (t *Tenant) Weight() int {
+	if t == nil || t.QueueWeight < 1 {
+		return 1
+	}
+	return t.QueueWeight
+}
+
+// Brand returns base with the tenant's branding applied.
+func (t *Tenant) Brand(base config.BrandingConfig) config.BrandingConfig {
+	if t == nil {
+		return base
+	}
+	for field, value := range map[*string]string{
+		&base.Name:            t.Branding.Name,
+		&base.ShortName:       t.Branding.ShortName,
+		&base.Description:     t.Branding.Description,
+		&base.ThemeColor:      t.Branding.ThemeColor,
+		&base.BackgroundColor: t.Branding.BackgroundColor,
+	} {
+		if value != "" {
+			*field = value
+		}
+	}
+	return base
+}
+
+// Has reports whether id names a tenant.
+func (reg *Registry) Has(id string) bool {
+	return reg != nil && reg.byID[id] != nil
+}
+
+// BasePath is the path prefix the tenant's UI is served under: /t/{id} in
+// path mode and empty otherwise.
+func (reg *Registry) BasePath(t *Tenant) string {
+	if reg == nil || t == nil || reg.mode != "path" {
+		return ""
+	}
+	return PathPrefix + t.ID
+}
+
+// Report is a tenant's usage since the server started, and in the current
+// quota window.
+type Report struct {
+	ID           string    `json:"id"`
+	Requests     int64     `json:"requests"`
+	InputTokens  int64     `json:"inputTokens"`
+	OutputTokens int64     `json:"outputTokens"`
+	WindowStart  time.Time `json:"windowStart"`
+	WindowUsage  Quota     `json:"windowUsage"`
+	Quota        Quota     `json:"quota"`
+}
+
+// Report lists every tenant's usage, in file order.
+func (reg *Registry) Report() []Report {
+	now := reg.now()
+	reports := make([]Report, 0, len(reg.tenants))
+	for _, t := range reg.tenants {
+		t.mu.Lock()
+		t.roll(now)
+		report := Report{
+			ID:           t.ID,
+			Requests:     t.requests.Load(),
+			InputTokens:  t.inputTokens.Load(),
+			OutputTokens: t.outputTokens.Load(),
+			WindowStart:  t.windowStart,
+			WindowUsage:  Quota{Requests: t.windowRequests, Tokens: t.windowTokens, Window: t.Quota.window.String()},
+			Quota:        Quota{Requests: t.Quota.Requests, Tokens: t.Quota.Tokens, Window: t.Quota.window.String()},
+		}
+		t.mu.Unlock()
+		reports = append(reports, report)
+	}
+	return reports
+}
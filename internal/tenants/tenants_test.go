@@ -0,0 +1,159 @@
+package tenants
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/config"
+	"github.com/manto/manto-web/internal/services"
+)
+
+const testTenants = `{"tenants":[
+	{"id":"acme","branding":{"name":"Acme Chat"},"models":["claude-haiku"],"system":"You work for Acme.","maxTokens":500,"quota":{"requests":2,"window":"1h"}},
+	{"id":"globex"}
+]}`
+
+func loadTenants(t *testing.T, mode, data string) *Registry {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reg, err := Load(config.TenantsConfig{File: path, Mode: mode, Header: "X-Manto-Tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestLoadBehavior(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{name: "no tenants", data: `{"tenants":[]}`, err: "at least one"},
+		{name: "invalid ID", data: `{"tenants":[{"id":"Acme Corp"}]}`, err: "lowercase"},
+		{name: "duplicate IDs", data: `{"tenants":[{"id":"acme"},{"id":"acme"}]}`, err: "twice"},
+		{name: "negative quota", data: `{"tenants":[{"id":"acme","quota":{"requests":-1}}]}`, err: "negative"},
+		{name: "invalid window", data: `{"tenants":[{"id":"acme","quota":{"window":"daily"}}]}`, err: "quota window"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tenants.json")
+			os.WriteFile(path, []byte(tt.data), 0o600)
+			_, err := Load(config.TenantsConfig{File: path, Mode: "header"})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error mentioning %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if reg, err := Load(config.TenantsConfig{}); reg != nil || err != nil {
+		t.Errorf("expected no registry without a file, got %v %v", reg, err)
+	}
+}
+
+func TestMiddlewareBehavior(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           string
+		path           string
+		header         string
+		expectedStatus int
+		expectedTenant string
+		expectedPath   string
+	}{
+		{name: "header names the tenant", mode: "header", path: "/api/models", header: "acme", expectedStatus: http.StatusOK, expectedTenant: "acme", expectedPath: "/api/models"},
+		{name: "no header serves the default", mode: "header", path: "/api/models", expectedStatus: http.StatusOK, expectedPath: "/api/models"},
+		{name: "unknown header tenant", mode: "header", path: "/api/models", header: "initech", expectedStatus: http.StatusNotFound},
+		{name: "path prefix is stripped", mode: "path", path: "/t/globex/api/models", expectedStatus: http.StatusOK, expectedTenant: "globex", expectedPath: "/api/models"},
+		{name: "path mode ignores the header", mode: "path", path: "/api/models", header: "acme", expectedStatus: http.StatusOK, expectedPath: "/api/models"},
+		{name: "bare tenant path redirects", mode: "path", path: "/t/acme", expectedStatus: http.StatusPermanentRedirect},
+		{name: "unknown path tenant", mode: "path", path: "/t/initech/", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := loadTenants(t, tt.mode, testTenants)
+			var gotTenant, gotPath string
+			handler := reg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tenant := FromContext(r.Context()); tenant != nil {
+					gotTenant = tenant.ID
+				}
+				gotPath = r.URL.Path
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Manto-Tenant", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if gotTenant != tt.expectedTenant || gotPath != tt.expectedPath {
+				t.Errorf("expected tenant %q at %q, got %q at %q", tt.expectedTenant, tt.expectedPath, gotTenant, gotPath)
+			}
+		})
+	}
+}
+
+func TestTenantBehavior(t *testing.T) {
+	reg := loadTenants(t, "path", testTenants)
+	acme := reg.byID["acme"]
+
+	if acme.Allows("claude-opus") || !acme.Allows("claude-haiku") || !reg.byID["globex"].Allows("claude-opus") {
+		t.Error("expected only acme's listed models to be allowed")
+	}
+
+	request := &services.MessageRequest{MaxTokens: 4000}
+	acme.Apply(request)
+	if request.System == nil || *request.System != "You work for Acme." || request.MaxTokens != 500 {
+		t.Errorf("expected acme's system prompt and token cap, got %v %d", request.System, request.MaxTokens)
+	}
+
+	if acme.Owner("key_abc") != "acme:key_abc" {
+		t.Errorf("expected a tenant-scoped owner, got %s", acme.Owner("key_abc"))
+	}
+	if brand := acme.Brand(config.BrandingConfig{Name: "Manto", ThemeColor: "#6b46c1"}); brand.Name != "Acme Chat" || brand.ThemeColor != "#6b46c1" {
+		t.Errorf("expected acme's name over the default colour, got %+v", brand)
+	}
+	if reg.BasePath(acme) != "/t/acme" {
+		t.Errorf("expected /t/acme, got %s", reg.BasePath(acme))
+	}
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := acme.Admit(now); err != nil {
+			t.Fatalf("expected request %d to be admitted, got %v", i+1, err)
+		}
+	}
+	acme.RecordUsage(10, 20)
+	if err := acme.Admit(now); err != ErrQuotaExceeded {
+		t.Errorf("expected the quota to be used up, got %v", err)
+	}
+	if err := acme.Admit(now.Add(time.Hour)); err != nil {
+		t.Errorf("expected a new window to admit requests, got %v", err)
+	}
+
+	report := reg.Report()
+	if len(report) != 2 || report[0].Requests != 3 || report[0].InputTokens != 10 || report[0].OutputTokens != 20 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	var none *Tenant
+	none.Apply(request)
+	none.RecordUsage(1, 1)
+	if none.Admit(now) != nil || none.Owner("key_abc") != "key_abc" || !none.Allows("claude-opus") {
+		t.Error("expected a nil tenant to change nothing")
+	}
+}
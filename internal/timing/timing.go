@@ -0,0 +1,67 @@
+package timing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type contextKey struct{}
+
+// Phase is a named slice of time spent serving a request.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Timings collects phase durations for one request. A nil *Timings is valid
+// and discards everything, so callers never need to check for it.
+type Timings struct {
+	start time.Time
+
+	mu     sync.Mutex
+	phases []Phase
+}
+
+func New() *Timings {
+	return &Timings{start: time.Now()}
+}
+
+func NewContext(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(contextKey{}).(*Timings)
+	return t
+}
+
+func (t *Timings) Record(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.phases = append(t.phases, Phase{Name: name, Duration: d})
+	t.mu.Unlock()
+}
+
+// Since records the time elapsed from start under name.
+func (t *Timings) Since(name string, start time.Time) {
+	t.Record(name, time.Since(start))
+}
+
+func (t *Timings) Phases() []Phase {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Phase(nil), t.phases...)
+}
+
+func (t *Timings) Elapsed() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Since(t.start)
+}
@@ -0,0 +1,78 @@
+package tokens
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/manto/manto-web/internal/services"
+)
+
+// Cache remembers exact token counts for a short time, keyed by a hash of the
+// model, system prompt and messages. While a user types, the conversation so
+// far stays identical between keystrokes, so its count is fetched once.
+type Cache struct {
+	ttl     time.Duration
+	maxSize int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	count   int
+	expires time.Time
+}
+
+func NewCache(ttl time.Duration, maxSize int) *Cache {
+	return &Cache{ttl: ttl, maxSize: maxSize, now: time.Now, entries: make(map[string]cacheEntry)}
+}
+
+// Key hashes a counting request. Messages are serialized as JSON so contents
+// containing separators cannot collide.
+func Key(model, system string, messages []services.Message) string {
+	data, _ := json.Marshal(struct {
+		Model    string             `json:"m"`
+		System   string             `json:"s"`
+		Messages []services.Message `json:"x"`
+	}{model, system, messages})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) Get(key string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.now().After(entry.expires) {
+		return 0, false
+	}
+	return entry.count, true
+}
+
+// Set stores count under key. When the cache is full, expired entries are
+// dropped first and, failing that, an arbitrary entry is evicted.
+func (c *Cache) Set(key string, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= c.maxSize {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= c.maxSize {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = cacheEntry{count: count, expires: now.Add(c.ttl)}
+}
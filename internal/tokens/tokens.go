@@ -0,0 +1,146 @@
+// Package tokens estimates token counts locally so budget checks, context
+// trimming and typing-time estimates don't need an upstream round trip.
+//
+// The estimate is a heuristic, not Claude's real tokenizer: text is split into
+// words, numbers, punctuation and whitespace runs the way BPE pre-tokenizers
+// do, then long runs are charged one token per CharsPerToken characters. It
+// tends to land within ~10-15% of the real count for English prose and code.
+package tokens
+
+import (
+	"math"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/manto/manto-web/internal/services"
+)
+
+const (
+	// Method names the estimation strategy in API responses. MethodHybrid
+	// marks counts where the conversation history was counted exactly by the
+	// provider and only the draft message was estimated.
+	Method       = "heuristic"
+	MethodHybrid = "hybrid"
+
+	// messageOverhead approximates the role markers wrapped around each
+	// message, and requestOverhead the fixed framing of a request.
+	messageOverhead = 4
+	requestOverhead = 3
+)
+
+// Estimator counts tokens with a configurable characters-per-token ratio.
+type Estimator struct {
+	charsPerToken float64
+}
+
+// NewEstimator returns an estimator charging one token per charsPerToken
+// characters of a word. Values below 1 fall back to 4.
+func NewEstimator(charsPerToken float64) *Estimator {
+	if charsPerToken < 1 {
+		charsPerToken = 4
+	}
+	return &Estimator{charsPerToken: charsPerToken}
+}
+
+// Count estimates the tokens in text.
+func (e *Estimator) Count(text string) int {
+	count := 0
+	for len(text) > 0 {
+		r, _ := utf8.DecodeRuneInString(text)
+		class := classify(r)
+
+		n := 0
+		runes := 0
+		for n < len(text) {
+			next, size := utf8.DecodeRuneInString(text[n:])
+			if classify(next) != class {
+				break
+			}
+			n += size
+			runes++
+		}
+		text = text[n:]
+
+		switch class {
+		case classSpace:
+			// Single spaces merge into the following word; longer runs
+			// (indentation, blank lines) cost roughly a token per few.
+			if runes > 1 {
+				count += int(math.Ceil(float64(runes) / e.charsPerToken))
+			}
+		case classPunct:
+			count += runes
+		case classDigit:
+			// Numbers are split into groups of up to three digits.
+			count += (runes + 2) / 3
+		case classOther:
+			// Scripts without spaces (CJK and similar) are close to a token
+			// per character.
+			count += runes
+		default:
+			count += int(math.Ceil(float64(runes) / e.charsPerToken))
+		}
+	}
+	return count
+}
+
+// CountRequest estimates the input tokens of a message request, including the
+// system prompt and per-message framing.
+func (e *Estimator) CountRequest(system string, messages []services.Message) int {
+	total := requestOverhead + e.Count(system)
+	for _, m := range messages {
+		total += messageOverhead + e.Count(m.Content)
+	}
+	return total
+}
+
+// CountMessage estimates one message including its framing, for adding a
+// draft to an exact count of the messages before it.
+func (e *Estimator) CountMessage(m services.Message) int {
+	return messageOverhead + e.Count(m.Content)
+}
+
+// Trim drops the oldest messages until the request fits in budget tokens. The
+// last message is always kept, and the result always starts with a user
+// message as the Messages API requires. It returns the kept messages and
+// whether they fit.
+func (e *Estimator) Trim(system string, messages []services.Message, budget int) ([]services.Message, bool) {
+	for start := 0; start < len(messages); start++ {
+		if messages[start].Role != "user" && start != len(messages)-1 {
+			continue
+		}
+		kept := messages[start:]
+		if e.CountRequest(system, kept) <= budget {
+			return kept, true
+		}
+	}
+	if len(messages) == 0 {
+		return messages, e.CountRequest(system, nil) <= budget
+	}
+	return messages[len(messages)-1:], false
+}
+
+type runeClass int
+
+const (
+	classLetter runeClass = iota
+	classDigit
+	classSpace
+	classPunct
+	classOther
+)
+
+func classify(r rune) runeClass {
+	switch {
+	case unicode.IsSpace(r):
+		return classSpace
+	case unicode.IsDigit(r):
+		return classDigit
+	case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+		return classOther
+	case unicode.IsLetter(r), unicode.IsMark(r):
+		return classLetter
+	default:
+		return classPunct
+	}
+}
@@ -0,0 +1,107 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manto/manto-web/internal/services"
+)
+
+func TestCountBehavior(t *testing.T) {
+	e := NewEstimator(4)
+
+	tests := []struct {
+		name     string
+		text     string
+		expected int
+	}{
+		{name: "empty", text: "", expected: 0},
+		{name: "short words", text: "Hello world", expected: 4},
+		{name: "punctuation counts per character", text: "Hi!?", expected: 3},
+		{name: "digits in groups of three", text: "1234567", expected: 3},
+		{name: "indentation", text: "a\n        b", expected: 5},
+		{name: "CJK per character", text: "你好世界", expected: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.Count(tt.text); got != tt.expected {
+				t.Errorf("expected %d tokens for %q, got %d", tt.expected, tt.text, got)
+			}
+		})
+	}
+
+	t.Run("scales with length", func(t *testing.T) {
+		prose := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)
+		got := e.Count(prose)
+		// The real tokenizer gives roughly 1000 tokens for this text.
+		if got < 800 || got > 1400 {
+			t.Errorf("estimate %d is far from the expected ~1000", got)
+		}
+	})
+}
+
+func TestTrimBehavior(t *testing.T) {
+	e := NewEstimator(4)
+	long := strings.Repeat("word ", 100)
+	messages := []services.Message{
+		{Role: "user", Content: long},
+		{Role: "assistant", Content: long},
+		{Role: "user", Content: "short question"},
+	}
+
+	tests := []struct {
+		name     string
+		budget   int
+		expected int
+		fits     bool
+	}{
+		{name: "everything fits", budget: 10000, expected: 3, fits: true},
+		{name: "drops oldest turns but starts with user", budget: 50, expected: 1, fits: true},
+		{name: "last message alone too large", budget: 5, expected: 1, fits: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, fits := e.Trim("", messages, tt.budget)
+			if len(kept) != tt.expected || fits != tt.fits {
+				t.Fatalf("expected %d messages (fits=%v), got %d (fits=%v)", tt.expected, tt.fits, len(kept), fits)
+			}
+			if kept[0].Role != "user" {
+				t.Errorf("expected trimmed history to start with a user message, got %s", kept[0].Role)
+			}
+		})
+	}
+}
+
+func TestCacheBehavior(t *testing.T) {
+	now := time.Unix(1000, 0)
+	cache := NewCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+
+	history := []services.Message{{Role: "user", Content: "Hello"}}
+	key := Key("claude", "", history)
+	if Key("claude", "", []services.Message{{Role: "user", Content: "Hello!"}}) == key || Key("other", "", history) == key {
+		t.Fatal("different requests should hash to different keys")
+	}
+
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("empty cache should miss")
+	}
+	cache.Set(key, 12)
+	if count, ok := cache.Get(key); !ok || count != 12 {
+		t.Errorf("expected cached count 12, got %d (hit=%v)", count, ok)
+	}
+
+	cache.Set("b", 1)
+	cache.Set("c", 2)
+	if len(cache.entries) != 2 {
+		t.Errorf("cache should stay within its size, has %d entries", len(cache.entries))
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.Get("c"); ok {
+		t.Error("expired entries should miss")
+	}
+}
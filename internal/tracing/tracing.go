@@ -0,0 +1,95 @@
+// Package tracing joins the W3C Trace Context callers propagate in the
+// traceparent header. Manto exports no spans itself; the trace a request
+// belongs to is kept so metrics and logs can point at the caller's trace.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+// Header is the W3C Trace Context request header.
+const Header = "traceparent"
+
+type contextKey struct{}
+
+// SpanContext identifies the caller's span a request was sent from. Sampled
+// reports whether the caller records the trace, and so whether linking to
+// it leads anywhere.
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// Parse reads a traceparent header value: version, trace ID, parent span ID
+// and flags, as lowercase hex separated by dashes. Versions after 00 may
+// append fields, which are ignored.
+func Parse(traceparent string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) {
+		return SpanContext{}, false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return SpanContext{}, false
+	}
+	sampled, _ := hex.DecodeString(flags)
+	return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: sampled[0]&1 == 1}, true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func NewContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// FromContext returns the caller's span for the request ctx serves, if it
+// sent a valid traceparent.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Exemplar returns the exemplar labels linking a metric observed while
+// serving ctx to its trace, or nil unless the caller is sampling it.
+func Exemplar(ctx context.Context) map[string]string {
+	if sc, ok := FromContext(ctx); ok && sc.Sampled {
+		return map[string]string{"trace_id": sc.TraceID}
+	}
+	return nil
+}
+
+// Middleware keeps the span context of requests carrying a valid
+// traceparent when TRACING_ENABLED is set. Invalid headers are ignored, as
+// the specification asks.
+func Middleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Tracing.Enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if sc, ok := Parse(r.Header.Get(Header)); ok {
+				r = r.WithContext(NewContext(r.Context(), sc))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
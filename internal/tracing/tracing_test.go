@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manto/manto-web/internal/config"
+)
+
+func TestParseBehavior(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		expectOK    bool
+		expectTrace string
+		sampled     bool
+	}{
+		{name: "reads a sampled span", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", expectOK: true, expectTrace: "4bf92f3577b34da6a3ce929d0e0e4736", sampled: true},
+		{name: "reads an unsampled span", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", expectOK: true, expectTrace: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "accepts fields appended by later versions", header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", expectOK: true, expectTrace: "4bf92f3577b34da6a3ce929d0e0e4736", sampled: true},
+		{name: "rejects extra fields in version 00", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "rejects version ff", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "rejects an all-zero trace ID", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "rejects an all-zero span ID", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "rejects uppercase hex", header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "rejects a short trace ID", header: "00-4bf92f3577b34da6-00f067aa0ba902b7-01"},
+		{name: "rejects an empty header", header: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := Parse(tt.header)
+			if ok != tt.expectOK {
+				t.Fatalf("expected ok=%t, got %t", tt.expectOK, ok)
+			}
+			if sc.TraceID != tt.expectTrace || sc.Sampled != tt.sampled {
+				t.Errorf("unexpected span context: %+v", sc)
+			}
+		})
+	}
+}
+
+func TestMiddlewareBehavior(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tests := []struct {
+		name           string
+		enabled        bool
+		header         string
+		expectExemplar bool
+	}{
+		{name: "links sampled requests to their trace", enabled: true, header: header, expectExemplar: true},
+		{name: "leaves unsampled requests unlinked", enabled: true, header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "ignores an invalid header", enabled: true, header: "garbage"},
+		{name: "ignores traceparent when tracing is off", header: header},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Tracing.Enabled = tt.enabled
+			var exemplar map[string]string
+			handler := Middleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				exemplar = Exemplar(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/api/models", nil)
+			req.Header.Set(Header, tt.header)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if tt.expectExemplar {
+				if exemplar["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+					t.Errorf("expected the trace ID as exemplar, got %v", exemplar)
+				}
+			} else if exemplar != nil {
+				t.Errorf("expected no exemplar, got %v", exemplar)
+			}
+		})
+	}
+}
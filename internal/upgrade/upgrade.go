@@ -0,0 +1,143 @@
+// Package upgrade implements zero-downtime binary restarts by handing the
+// listening socket to a freshly exec'd copy of the process. The old process
+// stops accepting once the new one reports ready, then drains in-flight
+// requests (including long streaming responses) before exiting.
+package upgrade
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The config package's strict mode accepts these; keep it in step when
+// renaming them.
+const (
+	listenFDEnvPrefix = "MANTO_LISTEN_FD_"
+	readyFDEnv        = "MANTO_READY_FD"
+)
+
+func listenFDEnv(name string) string {
+	return listenFDEnvPrefix + strings.ToUpper(name)
+}
+
+// Listen returns the socket named name inherited from a parent process when
+// present, otherwise it binds addr.
+func Listen(name, addr string) (net.Listener, bool, error) {
+	envName := listenFDEnv(name)
+	if fd := os.Getenv(envName); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %s: %w", envName, err)
+		}
+		f := os.NewFile(uintptr(n), "inherited-listener")
+		defer f.Close()
+
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to inherit listener: %w", err)
+		}
+		return ln, true, nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	return ln, false, err
+}
+
+// Ready tells the parent process, if any, that this process is serving and
+// the parent can begin draining.
+func Ready() error {
+	fd := os.Getenv(readyFDEnv)
+	if fd == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", readyFDEnv, err)
+	}
+
+	f := os.NewFile(uintptr(n), "ready-pipe")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// Restart starts a new copy of the current binary that inherits every named
+// listener, and waits up to timeout for it to call Ready.
+func Restart(listeners map[string]net.Listener, timeout time.Duration) (*os.Process, error) {
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// ExtraFiles start at fd 3 in the child.
+	var files []*os.File
+	var env []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i, name := range names {
+		filer, ok := listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %s does not support file descriptor inheritance", name)
+		}
+		f, err := filer.File()
+		if err != nil {
+			return nil, fmt.Errorf("failed to duplicate listener %s: %w", name, err)
+		}
+		files = append(files, f)
+		env = append(env, fmt.Sprintf("%s=%d", listenFDEnv(name), 3+i))
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ready pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return nil, fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(append(os.Environ(), env...), fmt.Sprintf("%s=%d", readyFDEnv, 3+len(files)))
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return nil, fmt.Errorf("new process exited before becoming ready: %w", err)
+		}
+		go cmd.Wait()
+		return cmd.Process, nil
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("new process not ready after %s", timeout)
+	}
+}
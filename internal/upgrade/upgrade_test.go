@@ -0,0 +1,65 @@
+package upgrade
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestListenInheritsDescriptor(t *testing.T) {
+	original, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+
+	f, err := original.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(listenFDEnv("public"), strconv.Itoa(int(f.Fd())))
+
+	ln, inherited, err := Listen("public", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	if !inherited {
+		t.Error("expected listener to be reported as inherited")
+	}
+	if ln.Addr().String() != original.Addr().String() {
+		t.Errorf("expected inherited address %s, got %s", original.Addr(), ln.Addr())
+	}
+}
+
+func TestListenBindsWithoutInheritance(t *testing.T) {
+	ln, inherited, err := Listen("admin", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	if inherited {
+		t.Error("fresh listener should not be reported as inherited")
+	}
+}
+
+func TestReadySignalsParent(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	t.Setenv(readyFDEnv, strconv.Itoa(int(w.Fd())))
+
+	if err := Ready(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil {
+		t.Errorf("expected readiness byte, got error: %v", err)
+	}
+}